@@ -2,10 +2,12 @@ package asm
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"sort"
@@ -226,6 +228,13 @@ func (ins *Instruction) IsFunctionCall() bool {
 	return ins.OpCode.JumpOp() == Call && ins.Src == PseudoCall
 }
 
+// IsKfuncCall returns true if the instruction calls a kernel function.
+//
+// This is not the same thing as a BPF helper call.
+func (ins *Instruction) IsKfuncCall() bool {
+	return ins.OpCode.JumpOp() == Call && ins.Src == PseudoKfuncCall
+}
+
 // IsLoadOfFunctionPointer returns true if the instruction loads a function pointer.
 func (ins *Instruction) IsLoadOfFunctionPointer() bool {
 	return ins.OpCode.IsDWordLoad() && ins.Src == PseudoFunc
@@ -318,10 +327,14 @@ func (ins Instruction) Format(f fmt.State, c rune) {
 	case cls.IsJump():
 		switch jop := op.JumpOp(); jop {
 		case Call:
-			if ins.Src == PseudoCall {
+			switch ins.Src {
+			case PseudoCall:
 				// bpf-to-bpf call
 				fmt.Fprint(f, ins.Constant)
-			} else {
+			case PseudoKfuncCall:
+				// kernel function call
+				fmt.Fprintf(f, "kfunc: %d", ins.Constant)
+			default:
 				fmt.Fprint(f, BuiltinFunc(ins.Constant))
 			}
 
@@ -699,9 +712,32 @@ func (insns Instructions) Marshal(w io.Writer, bo binary.ByteOrder) error {
 // It mirrors bpf_prog_calc_tag in the kernel and so can be compared
 // to ProgramInfo.Tag to figure out whether a loaded program matches
 // certain instructions.
+//
+// Kernels starting from 6.18 derive the tag from a SHA-256 digest instead
+// of SHA-1, use TagSHA256 for those.
 func (insns Instructions) Tag(bo binary.ByteOrder) (string, error) {
-	h := sha1.New()
+	return insns.tag(sha1.New(), bo)
+}
+
+// TagSHA256 calculates the kernel tag for a series of instructions the way
+// Linux 6.18 and later do.
+//
+// See Tag for details.
+func (insns Instructions) TagSHA256(bo binary.ByteOrder) (string, error) {
+	return insns.tag(sha256.New(), bo)
+}
+
+func (insns Instructions) tag(h hash.Hash, bo binary.ByteOrder) (string, error) {
+	// The kernel sees resolved jumps and bpf2bpf calls. Resolve them on a
+	// copy to avoid modifying insns.
+	insns = append(Instructions(nil), insns...)
+	if err := insns.encodeFunctionReferences(); err != nil {
+		return "", err
+	}
+
 	for i, ins := range insns {
+		// The kernel zeroes both halves of the immediate of map loads,
+		// since they contain the fd and the offset into the value.
 		if ins.IsLoadFromMap() {
 			ins.Constant = 0
 		}
@@ -290,6 +290,39 @@ func TestInstructionIterator(t *testing.T) {
 	}
 }
 
+func TestInstructionsTag(t *testing.T) {
+	insns := Instructions{
+		LoadImm(R0, -1, DWord),
+		LoadMapPtr(R1, 42),
+		Mov.Imm32(R0, 0),
+		Return(),
+	}
+
+	tag, err := insns.Tag(binary.LittleEndian)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tag, qt.Equals, "5b1af505ea3efd7b")
+
+	tag, err = insns.TagSHA256(binary.LittleEndian)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tag, qt.Equals, "b834b2998842019b")
+
+	// Function references are resolved, without modifying the instructions.
+	call := Instructions{
+		Call.Label("fn"),
+		Return(),
+		Mov.Imm(R0, 0).WithSymbol("fn"),
+		Return(),
+	}
+	tag, err = call.Tag(binary.LittleEndian)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, call[0].Constant, qt.Equals, int64(-1))
+
+	call[0].Constant = 1
+	want, err := call.Tag(binary.LittleEndian)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tag, qt.Equals, want)
+}
+
 func TestMetadataCopyOnWrite(t *testing.T) {
 	c := qt.New(t)
 
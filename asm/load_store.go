@@ -115,6 +115,34 @@ func LoadMapPtr(dst Register, fd int) Instruction {
 	}
 }
 
+// LoadMapPtrIdx stores a pointer to a map in dst. The map is the entry at
+// index of the fd_array passed to the kernel when loading the program.
+//
+// Requires at least Linux 5.14.
+func LoadMapPtrIdx(dst Register, index uint32) Instruction {
+	return Instruction{
+		OpCode:   LoadImmOp(DWord),
+		Dst:      dst,
+		Src:      PseudoMapIdx,
+		Constant: int64(index),
+	}
+}
+
+// LoadMapValueIdx stores a pointer to the value at a certain offset of a map.
+// The map is the entry at index of the fd_array passed to the kernel when
+// loading the program.
+//
+// Requires at least Linux 5.14.
+func LoadMapValueIdx(dst Register, index, offset uint32) Instruction {
+	indexAndOffset := (uint64(offset) << 32) | uint64(index)
+	return Instruction{
+		OpCode:   LoadImmOp(DWord),
+		Dst:      dst,
+		Src:      PseudoMapIdxValue,
+		Constant: int64(indexAndOffset),
+	}
+}
+
 // LoadMapValue stores a pointer to the value at a certain offset of a map.
 func LoadMapValue(dst Register, fd int, offset uint32) Instruction {
 	if fd < 0 {
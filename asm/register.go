@@ -35,10 +35,14 @@ const (
 
 // Pseudo registers used by 64bit loads and jumps
 const (
-	PseudoMapFD    = R1 // BPF_PSEUDO_MAP_FD
-	PseudoMapValue = R2 // BPF_PSEUDO_MAP_VALUE
-	PseudoCall     = R1 // BPF_PSEUDO_CALL
-	PseudoFunc     = R4 // BPF_PSEUDO_FUNC
+	PseudoMapFD       = R1 // BPF_PSEUDO_MAP_FD
+	PseudoMapValue    = R2 // BPF_PSEUDO_MAP_VALUE
+	PseudoBTFID       = R3 // BPF_PSEUDO_BTF_ID
+	PseudoCall        = R1 // BPF_PSEUDO_CALL
+	PseudoKfuncCall   = R2 // BPF_PSEUDO_KFUNC_CALL
+	PseudoFunc        = R4 // BPF_PSEUDO_FUNC
+	PseudoMapIdx      = R5 // BPF_PSEUDO_MAP_IDX
+	PseudoMapIdxValue = R6 // BPF_PSEUDO_MAP_IDX_VALUE
 )
 
 func (r Register) String() string {
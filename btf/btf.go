@@ -3,14 +3,17 @@ package btf
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"debug/elf"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math"
 	"os"
 	"reflect"
+	"sync"
 
 	"github.com/cilium/ebpf/internal"
 	"github.com/cilium/ebpf/internal/sys"
@@ -209,7 +212,16 @@ func loadSpecFromELF(file *internal.SafeELFFile) (*Spec, error) {
 		return nil, err
 	}
 
-	return inflateSpec(rawTypes, rawStrings, file.ByteOrder)
+	spec, err := inflateSpec(rawTypes, rawStrings, file.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := spec.fixupKconfig(); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
 }
 
 func loadRawSpec(btf io.ReaderAt, bo binary.ByteOrder) (*Spec, error) {
@@ -263,11 +275,59 @@ func indexTypes(types []Type) (map[Type]TypeID, map[essentialName][]Type) {
 	return typeIDs, typesByName
 }
 
+var kernelBTF struct {
+	sync.RWMutex
+	spec *Spec
+}
+
+// FlushKernelSpec removes the cached kernel BTF, so that the next call to
+// LoadKernelSpec reads it again. This also affects the kernel BTF used when
+// loading programs and maps. This is useful after loading kernel modules
+// which ship BTF, or in tests.
+func FlushKernelSpec() {
+	kernelBTF.Lock()
+	defer kernelBTF.Unlock()
+
+	kernelBTF.spec = nil
+	internal.FlushKernelBTF()
+}
+
 // LoadKernelSpec returns the current kernel's BTF information.
 //
 // Defaults to /sys/kernel/btf/vmlinux and falls back to scanning the file system
-// for vmlinux ELFs. Returns an error wrapping ErrNotSupported if BTF is not enabled.
+// for vmlinux ELFs, and then for gzip compressed vmlinuz images. Returns an
+// error wrapping ErrNotSupported if BTF is not enabled.
+//
+// The BTF is read once and cached for the lifetime of the process, see
+// FlushKernelSpec. Each call returns a copy which may be modified freely.
+// Copying the types of vmlinux is expensive, so callers should hold on to the
+// result instead of calling LoadKernelSpec repeatedly.
 func LoadKernelSpec() (*Spec, error) {
+	kernelBTF.RLock()
+	spec := kernelBTF.spec
+	kernelBTF.RUnlock()
+
+	if spec == nil {
+		kernelBTF.Lock()
+		defer kernelBTF.Unlock()
+
+		spec = kernelBTF.spec
+	}
+
+	if spec == nil {
+		var err error
+		spec, err = loadKernelSpec()
+		if err != nil {
+			return nil, err
+		}
+
+		kernelBTF.spec = spec
+	}
+
+	return spec.Copy(), nil
+}
+
+func loadKernelSpec() (*Spec, error) {
 	fh, err := os.Open("/sys/kernel/btf/vmlinux")
 	if err == nil {
 		defer fh.Close()
@@ -284,6 +344,30 @@ func LoadKernelSpec() (*Spec, error) {
 	return loadSpecFromELF(file)
 }
 
+// LoadKernelSpecFromFS returns BTF for the current kernel from a collection of
+// pre-generated BTF, for example created by MinimalSpec and embedded into an
+// application. This allows using CO-RE on kernels which don't expose BTF.
+//
+// The BTF is read from the file named "<release>.btf" at the root of fsys,
+// where release is the output of `uname -r`. Returns an error wrapping
+// ErrNotSupported if there is no such file.
+func LoadKernelSpecFromFS(fsys fs.FS) (*Spec, error) {
+	release, err := internal.KernelRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := fs.ReadFile(fsys, release+".btf")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no BTF found for kernel version %s: %w", release, internal.ErrNotSupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadSpecFromReader(bytes.NewReader(raw))
+}
+
 // findVMLinux scans multiple well-known paths for vmlinux kernel images.
 func findVMLinux() (*internal.SafeELFFile, error) {
 	release, err := internal.KernelRelease()
@@ -311,9 +395,66 @@ func findVMLinux() (*internal.SafeELFFile, error) {
 		return file, err
 	}
 
+	compressedLocations := []string{
+		"/boot/vmlinuz-%s",
+		"/lib/modules/%s/vmlinuz",
+	}
+
+	for _, loc := range compressedLocations {
+		image, err := os.ReadFile(fmt.Sprintf(loc, release))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := extractVMLinux(image)
+		if errors.Is(err, internal.ErrNotSupported) {
+			continue
+		}
+		return file, err
+	}
+
 	return nil, fmt.Errorf("no BTF found for kernel version %s: %w", release, internal.ErrNotSupported)
 }
 
+// extractVMLinux finds a gzip compressed vmlinux ELF in a kernel image like
+// vmlinuz, similar to the kernel's scripts/extract-vmlinux.
+//
+// Returns an error wrapping ErrNotSupported if the image doesn't contain one.
+func extractVMLinux(image []byte) (*internal.SafeELFFile, error) {
+	gzipMagic := []byte{0x1f, 0x8b, 0x08}
+
+	for offset := 0; offset < len(image); offset++ {
+		i := bytes.Index(image[offset:], gzipMagic)
+		if i == -1 {
+			break
+		}
+		offset += i
+
+		zr, err := gzip.NewReader(bytes.NewReader(image[offset:]))
+		if err != nil {
+			continue
+		}
+		zr.Multistream(false)
+
+		// The magic may occur by chance, in which case decompression fails.
+		vmlinux, err := io.ReadAll(zr)
+		if err != nil {
+			continue
+		}
+
+		file, err := internal.NewSafeELFFile(bytes.NewReader(vmlinux))
+		if err != nil {
+			continue
+		}
+		return file, nil
+	}
+
+	return nil, fmt.Errorf("no gzip compressed vmlinux in image: %w", internal.ErrNotSupported)
+}
+
 // parseBTFHeader parses the header of the .BTF section.
 func parseBTFHeader(r io.Reader, bo binary.ByteOrder) (*btfHeader, error) {
 	var header btfHeader
@@ -400,7 +541,9 @@ func fixupDatasec(rawTypes []rawType, rawStrings *stringTable, sectionSizes map[
 		}
 
 		if name == ".kconfig" || name == ".ksyms" {
-			return fmt.Errorf("reference to %s: %w", name, ErrNotSupported)
+			// Externs don't occupy space in the ELF. The sections are laid
+			// out by fixupKconfig and sanitizeKsyms instead.
+			continue
 		}
 
 		if rawTypes[i].SizeType != 0 {
@@ -438,6 +581,58 @@ func fixupDatasec(rawTypes []rawType, rawStrings *stringTable, sectionSizes map[
 	return nil
 }
 
+// fixupKconfig assigns offsets to the variables in the .kconfig section.
+//
+// The compiler emits kconfig externs as undefined symbols, so their layout
+// is up to the loader. The variables are turned into globals since the kernel
+// doesn't accept extern variables.
+func (s *Spec) fixupKconfig() error {
+	var ds *Datasec
+	if err := s.TypeByName(".kconfig", &ds); errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rawDatasec := &s.rawTypes[s.typeIDs[ds]-1]
+	secinfos := rawDatasec.data.([]btfVarSecinfo)
+
+	offset := 0
+	for i, vsi := range ds.Vars {
+		v, ok := vsi.Type.(*Var)
+		if !ok {
+			return fmt.Errorf("data section .kconfig: unexpected type %s", vsi.Type)
+		}
+
+		size, err := Sizeof(v.Type)
+		if err != nil {
+			return fmt.Errorf("data section .kconfig: variable %s: %w", v.Name, err)
+		}
+
+		align, err := alignof(v.Type)
+		if err != nil {
+			return fmt.Errorf("data section .kconfig: variable %s: %w", v.Name, err)
+		}
+
+		offset = internal.Align(offset, align)
+
+		ds.Vars[i].Offset = uint32(offset)
+		ds.Vars[i].Size = uint32(size)
+		secinfos[i].Offset = uint32(offset)
+		secinfos[i].Size = uint32(size)
+
+		v.Linkage = GlobalVar
+		s.rawTypes[s.typeIDs[v]-1].data.(*btfVariable).Linkage = uint32(GlobalVar)
+
+		offset += size
+	}
+
+	ds.Size = uint32(offset)
+	rawDatasec.SizeType = uint32(offset)
+
+	return nil
+}
+
 // Copy creates a copy of Spec.
 func (s *Spec) Copy() *Spec {
 	types := copyTypes(s.types, nil)
@@ -455,6 +650,12 @@ func (s *Spec) Copy() *Spec {
 	}
 }
 
+// Marshal encodes the spec into the raw BTF format, as found in
+// /sys/kernel/btf/vmlinux. The result can be read by LoadSpecFromReader.
+func (s *Spec) Marshal() ([]byte, error) {
+	return s.marshal(marshalOpts{ByteOrder: s.byteOrder})
+}
+
 type marshalOpts struct {
 	ByteOrder        binary.ByteOrder
 	StripFuncLinkage bool
@@ -471,8 +672,13 @@ func (s *Spec) marshal(opts marshalOpts) ([]byte, error) {
 	// we don't know the size of the type section yet.
 	_, _ = buf.Write(make([]byte, headerLen))
 
+	rawTypes, err := sanitizeKsyms(s.rawTypes, s.strings)
+	if err != nil {
+		return nil, err
+	}
+
 	// Write type section, just after the header.
-	for _, raw := range s.rawTypes {
+	for _, raw := range rawTypes {
 		switch {
 		case opts.StripFuncLinkage && raw.Kind() == kindFunc:
 			raw.SetLinkage(StaticFunc)
@@ -505,7 +711,7 @@ func (s *Spec) marshal(opts marshalOpts) ([]byte, error) {
 	}
 
 	raw := buf.Bytes()
-	err := binary.Write(sliceWriter(raw[:headerLen]), opts.ByteOrder, header)
+	err = binary.Write(sliceWriter(raw[:headerLen]), opts.ByteOrder, header)
 	if err != nil {
 		return nil, fmt.Errorf("can't write header: %v", err)
 	}
@@ -513,6 +719,111 @@ func (s *Spec) marshal(opts marshalOpts) ([]byte, error) {
 	return raw, nil
 }
 
+// sanitizeKsyms rewrites the .ksyms section into a form accepted by the kernel.
+//
+// The section contains extern variables and functions, which the kernel
+// rejects. Variables are turned into global ints and functions are replaced
+// by a dummy variable, which mirrors what libbpf does. rawTypes is not
+// modified, types are appended to a copy instead.
+func sanitizeKsyms(rawTypes []rawType, strings *stringTable) ([]rawType, error) {
+	ksyms := -1
+	intID := TypeID(0)
+	for i := range rawTypes {
+		raw := &rawTypes[i]
+		switch raw.Kind() {
+		case kindDatasec:
+			name, err := strings.Lookup(raw.NameOff)
+			if err != nil {
+				return nil, err
+			}
+			if name == ".ksyms" {
+				ksyms = i
+			}
+
+		case kindInt:
+			if intID == 0 && raw.Size() == 4 {
+				intID = TypeID(i + 1)
+			}
+		}
+	}
+
+	if ksyms == -1 {
+		return rawTypes, nil
+	}
+
+	rawTypes = append([]rawType(nil), rawTypes...)
+
+	if intID == 0 {
+		data := new(btfInt)
+		data.SetEncoding(Signed)
+		data.SetBits(32)
+
+		raw := rawType{btfType{SizeType: 4}, data}
+		raw.SetKind(kindInt)
+		rawTypes = append(rawTypes, raw)
+		intID = TypeID(len(rawTypes))
+	}
+
+	dummyID := TypeID(0)
+	secinfos := append([]btfVarSecinfo(nil), rawTypes[ksyms].data.([]btfVarSecinfo)...)
+	for i := range secinfos {
+		id := secinfos[i].Type
+		if id == 0 || int(id) > len(rawTypes) {
+			return nil, fmt.Errorf(".ksyms: invalid type id %d for variable %d", id, i)
+		}
+
+		switch raw := rawTypes[id-1]; raw.Kind() {
+		case kindFunc:
+			// Parameters of extern functions may be anonymous, but the kernel
+			// requires names. Borrow the name of the function.
+			protoID := raw.Type()
+			if protoID == 0 || int(protoID) > len(rawTypes) {
+				return nil, fmt.Errorf(".ksyms: invalid prototype id %d for function %d", protoID, i)
+			}
+
+			proto := rawTypes[protoID-1]
+			if params, ok := proto.data.([]btfParam); ok {
+				params = append([]btfParam(nil), params...)
+				for j := range params {
+					if params[j].NameOff == 0 {
+						params[j].NameOff = raw.NameOff
+					}
+				}
+				proto.data = params
+				rawTypes[protoID-1] = proto
+			}
+
+			// The kernel doesn't accept extern linkage for functions.
+			raw.SetLinkage(GlobalFunc)
+			rawTypes[id-1] = raw
+
+			if dummyID == 0 {
+				dummy := rawType{btfType{NameOff: raw.NameOff, SizeType: uint32(intID)}, &btfVariable{uint32(GlobalVar)}}
+				dummy.SetKind(kindVar)
+				rawTypes = append(rawTypes, dummy)
+				dummyID = TypeID(len(rawTypes))
+			}
+			secinfos[i].Type = dummyID
+
+		case kindVar:
+			raw.SizeType = uint32(intID)
+			raw.data = &btfVariable{uint32(GlobalVar)}
+			rawTypes[id-1] = raw
+
+		default:
+			return nil, fmt.Errorf(".ksyms: unexpected kind %s for variable %d", raw.Kind(), i)
+		}
+
+		secinfos[i].Offset = uint32(i) * 4
+		secinfos[i].Size = 4
+	}
+
+	rawTypes[ksyms].data = secinfos
+	rawTypes[ksyms].SizeType = uint32(len(secinfos)) * 4
+
+	return rawTypes, nil
+}
+
 type sliceWriter []byte
 
 func (sw sliceWriter) Write(p []byte) (int, error) {
@@ -672,12 +983,34 @@ type Handle struct {
 	fd   *sys.FD
 }
 
+// HandleOptions control loading BTF into the kernel.
+type HandleOptions struct {
+	// File descriptor of a BPF token which grants permission to load BTF,
+	// see ebpf.Token. Ignored if zero.
+	TokenFD int
+}
+
 // NewHandle loads BTF into the kernel.
 //
 // Returns ErrNotSupported if BTF is not supported.
 func NewHandle(spec *Spec) (*Handle, error) {
-	if err := haveBTF(); err != nil {
-		return nil, err
+	return NewHandleWithOptions(spec, HandleOptions{})
+}
+
+// NewHandleWithOptions loads BTF into the kernel.
+//
+// Returns ErrNotSupported if BTF is not supported.
+func NewHandleWithOptions(spec *Spec, opts HandleOptions) (*Handle, error) {
+	if opts.TokenFD < 0 {
+		return nil, fmt.Errorf("invalid token file descriptor %d", opts.TokenFD)
+	}
+
+	// Feature probes require privileges which a token holder may lack.
+	// Tokens were introduced long after BTF support, so skip the probe.
+	if opts.TokenFD == 0 {
+		if err := haveBTF(); err != nil {
+			return nil, err
+		}
 	}
 
 	if spec.byteOrder != internal.NativeEndian {
@@ -686,7 +1019,7 @@ func NewHandle(spec *Spec) (*Handle, error) {
 
 	btf, err := spec.marshal(marshalOpts{
 		ByteOrder:        internal.NativeEndian,
-		StripFuncLinkage: haveFuncLinkage() != nil,
+		StripFuncLinkage: opts.TokenFD == 0 && haveFuncLinkage() != nil,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("can't marshal BTF: %w", err)
@@ -701,6 +1034,11 @@ func NewHandle(spec *Spec) (*Handle, error) {
 		BtfSize: uint32(len(btf)),
 	}
 
+	if opts.TokenFD != 0 {
+		attr.BtfTokenFd = int32(opts.TokenFD)
+		attr.BtfFlags |= unix.BPF_F_TOKEN_FD
+	}
+
 	fd, err := sys.BtfLoad(attr)
 	if err != nil {
 		logBuf := make([]byte, 64*1024)
@@ -2,6 +2,7 @@ package btf
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -279,15 +280,216 @@ func TestLoadSpecFromElf(t *testing.T) {
 	})
 }
 
+func TestFixupKconfig(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	char := &Int{Name: "char", Size: 1, Encoding: Char}
+	str := &Array{Index: u32, Type: char, Nelems: 4}
+
+	ids := map[Type]TypeID{(*Void)(nil): 0, u32: 1, char: 2, str: 3}
+	strings := newStringTableBuilder()
+
+	var rawTypes []rawType
+	for _, typ := range []Type{u32, char, str} {
+		raw, err := marshalType(typ, ids, strings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawTypes = append(rawTypes, raw)
+	}
+
+	// Externs are emitted with zero offsets and sizes.
+	var secinfos []btfVarSecinfo
+	for _, v := range []struct {
+		name string
+		typ  TypeID
+	}{
+		{"CONFIG_BPF", 2},
+		{"CONFIG_HZ", 1},
+		{"CONFIG_LOCALVERSION", 3},
+	} {
+		raw := rawType{btfType{NameOff: strings.Add(v.name), SizeType: uint32(v.typ)}, &btfVariable{uint32(ExternVar)}}
+		raw.SetKind(kindVar)
+		rawTypes = append(rawTypes, raw)
+		secinfos = append(secinfos, btfVarSecinfo{Type: TypeID(len(rawTypes))})
+	}
+
+	ds := rawType{btfType{NameOff: strings.Add(".kconfig")}, secinfos}
+	ds.SetKind(kindDatasec)
+	ds.SetVlen(len(secinfos))
+	rawTypes = append(rawTypes, ds)
+
+	spec, err := inflateSpec(rawTypes, &strings.table, internal.NativeEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spec.fixupKconfig(); err != nil {
+		t.Fatal("Can't fix up .kconfig:", err)
+	}
+
+	var kconfig *Datasec
+	if err := spec.TypeByName(".kconfig", &kconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if kconfig.Size != 12 {
+		t.Error("Expected size 12, got", kconfig.Size)
+	}
+
+	for i, want := range []VarSecinfo{{Offset: 0, Size: 1}, {Offset: 4, Size: 4}, {Offset: 8, Size: 4}} {
+		vsi := kconfig.Vars[i]
+		if vsi.Offset != want.Offset || vsi.Size != want.Size {
+			t.Errorf("Variable %d: expected offset %d size %d, got %d %d", i, want.Offset, want.Size, vsi.Offset, vsi.Size)
+		}
+
+		if v := vsi.Type.(*Var); v.Linkage != GlobalVar {
+			t.Error("Expected global linkage:", v)
+		}
+	}
+
+	h, err := NewHandle(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal("Can't load BTF:", err)
+	}
+	h.Close()
+}
+
+func TestSanitizeKsyms(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	strings := newStringTableBuilder()
+
+	rawU32, err := marshalType(u32, map[Type]TypeID{u32: 1}, strings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := rawType{btfType{SizeType: 1}, []btfParam{{Type: 1}}}
+	proto.SetKind(kindFuncProto)
+	proto.SetVlen(1)
+
+	fn := rawType{btfType{NameOff: strings.Add("bpf_kfunc"), SizeType: 2}, nil}
+	fn.SetKind(kindFunc)
+	fn.SetLinkage(ExternFunc)
+
+	untyped := rawType{btfType{NameOff: strings.Add("untyped_ksym")}, &btfVariable{uint32(ExternVar)}}
+	untyped.SetKind(kindVar)
+
+	typed := rawType{btfType{NameOff: strings.Add("typed_ksym"), SizeType: 1}, &btfVariable{uint32(ExternVar)}}
+	typed.SetKind(kindVar)
+
+	ds := rawType{btfType{NameOff: strings.Add(".ksyms")}, []btfVarSecinfo{{Type: 3}, {Type: 4}, {Type: 5}}}
+	ds.SetKind(kindDatasec)
+	ds.SetVlen(3)
+
+	spec, err := inflateSpec([]rawType{rawU32, proto, fn, untyped, typed, ds}, &strings.table, internal.NativeEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := spec.marshal(marshalOpts{ByteOrder: internal.NativeEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linkage := spec.rawTypes[2].Linkage(); linkage != ExternFunc {
+		t.Error("Marshaling modified the spec, linkage is", linkage)
+	}
+
+	decoded, err := loadRawSpec(bytes.NewReader(raw), internal.NativeEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ksyms *Datasec
+	if err := decoded.TypeByName(".ksyms", &ksyms); err != nil {
+		t.Fatal(err)
+	}
+
+	if ksyms.Size != 12 {
+		t.Error("Expected size 12, got", ksyms.Size)
+	}
+
+	for i, vsi := range ksyms.Vars {
+		v, ok := vsi.Type.(*Var)
+		if !ok {
+			t.Fatalf("Variable %d: expected Var, got %s", i, vsi.Type)
+		}
+		if v.Linkage != GlobalVar {
+			t.Errorf("Variable %d: expected global linkage: %s", i, v)
+		}
+		if vsi.Offset != uint32(i)*4 || vsi.Size != 4 {
+			t.Errorf("Variable %d: unexpected offset %d size %d", i, vsi.Offset, vsi.Size)
+		}
+	}
+
+	h, err := NewHandle(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal("Can't load BTF:", err)
+	}
+	h.Close()
+}
+
 func TestLoadKernelSpec(t *testing.T) {
 	if _, err := os.Stat("/sys/kernel/btf/vmlinux"); os.IsNotExist(err) {
 		t.Skip("/sys/kernel/btf/vmlinux not present")
 	}
 
-	_, err := LoadKernelSpec()
+	spec, err := LoadKernelSpec()
 	if err != nil {
 		t.Fatal("Can't load kernel spec:", err)
 	}
+
+	cached, err := LoadKernelSpec()
+	if err != nil {
+		t.Fatal("Can't load cached kernel spec:", err)
+	}
+
+	if spec == cached || spec.types[1] == cached.types[1] {
+		t.Error("LoadKernelSpec doesn't return a copy")
+	}
+
+	FlushKernelSpec()
+	if _, err := LoadKernelSpec(); err != nil {
+		t.Fatal("Can't load kernel spec after flushing:", err)
+	}
+}
+
+func TestExtractVMLinux(t *testing.T) {
+	elf, err := os.ReadFile(fmt.Sprintf("testdata/relocs-%s.elf", internal.ClangEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var image bytes.Buffer
+	// Mimic a boot sector and a spurious gzip magic.
+	image.Write(make([]byte, 512))
+	image.Write([]byte{0x1f, 0x8b, 0x08, 0xff, 0xff})
+
+	zw := gzip.NewWriter(&image)
+	if _, err := zw.Write(elf); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	image.Write([]byte("trailer"))
+
+	file, err := extractVMLinux(image.Bytes())
+	if err != nil {
+		t.Fatal("Can't extract vmlinux:", err)
+	}
+	defer file.Close()
+
+	if _, err := loadSpecFromELF(file); err != nil {
+		t.Fatal("Can't load BTF from extracted vmlinux:", err)
+	}
+
+	_, err = extractVMLinux(make([]byte, 512))
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatal("Expected ErrNotSupported for an image without vmlinux, got", err)
+	}
 }
 
 func TestGuessBTFByteOrder(t *testing.T) {
@@ -33,6 +33,12 @@ const (
 	kindDatasec
 	// Added ~5.13
 	kindFloat
+	// Added 5.16
+	kindDeclTag
+	// Added 5.17
+	kindTypeTag
+	// Added 6.0
+	kindEnum64
 )
 
 // FuncLinkage describes BTF function linkage metadata.
@@ -72,7 +78,7 @@ type btfType struct {
 	 * bits 24-28: kind (e.g. int, ptr, array...etc)
 	 * bits 29-30: unused
 	 * bit     31: kind_flag, currently used by
-	 *             struct, union and fwd
+	 *             struct, union, fwd, enum and enum64
 	 */
 	Info uint32
 	/* "size" is used by INT, ENUM, STRUCT and UNION.
@@ -121,6 +127,12 @@ func (k btfKind) String() string {
 		return "Section"
 	case kindFloat:
 		return "Float"
+	case kindDeclTag:
+		return "Decl Tag"
+	case kindTypeTag:
+		return "Type Tag"
+	case kindEnum64:
+		return "Enumeration64"
 	default:
 		return fmt.Sprintf("Unknown (%d)", k)
 	}
@@ -168,6 +180,14 @@ func (bt *btfType) KindFlag() bool {
 	return bt.info(btfTypeKindFlagMask, btfTypeKindFlagShift) == 1
 }
 
+func (bt *btfType) SetKindFlag(flag bool) {
+	var value uint32
+	if flag {
+		value = 1
+	}
+	bt.setInfo(value, btfTypeKindFlagMask, btfTypeKindFlagShift)
+}
+
 func (bt *btfType) Linkage() FuncLinkage {
 	return FuncLinkage(bt.info(btfTypeVlenMask, btfTypeVlenShift))
 }
@@ -275,7 +295,17 @@ type btfVariable struct {
 
 type btfEnum struct {
 	NameOff uint32
-	Val     int32
+	Val     uint32
+}
+
+type btfEnum64 struct {
+	NameOff uint32
+	ValLo32 uint32
+	ValHi32 uint32
+}
+
+type btfDeclTag struct {
+	ComponentIdx int32
 }
 
 type btfParam struct {
@@ -325,6 +355,11 @@ func readTypes(r io.Reader, bo binary.ByteOrder, typeLen uint32) ([]rawType, err
 		case kindDatasec:
 			data = make([]btfVarSecinfo, header.Vlen())
 		case kindFloat:
+		case kindDeclTag:
+			data = new(btfDeclTag)
+		case kindTypeTag:
+		case kindEnum64:
+			data = make([]btfEnum64, header.Vlen())
 		default:
 			return nil, fmt.Errorf("type id %v: unknown kind: %v", id, header.Kind())
 		}
@@ -0,0 +1,331 @@
+package btf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MinimalSpec returns the subset of target which is needed to satisfy relos,
+// similar to `bpftool gen min_core_btf`.
+//
+// Composite types which are only used by field relocations keep the accessed
+// members, others are dropped. Pointers to types which aren't needed point
+// at Void instead. The result can be marshaled and shipped with an
+// application, to use CO-RE on kernels which don't expose their own BTF.
+func MinimalSpec(local, target *Spec, relos []*CORERelocation) (*Spec, error) {
+	if local.byteOrder != target.byteOrder {
+		return nil, fmt.Errorf("can't minimize %s against %s", local.byteOrder, target.byteOrder)
+	}
+
+	// Split relocations into per Type lists, like CORERelocate.
+	var localTypes []Type
+	relosByType := make(map[Type][]*CORERelocation)
+	for _, relo := range relos {
+		if relo.kind == reloTypeIDLocal {
+			// Doesn't refer to the target at all.
+			continue
+		}
+
+		if _, ok := relosByType[relo.typ]; !ok {
+			localTypes = append(localTypes, relo.typ)
+		}
+		relosByType[relo.typ] = append(relosByType[relo.typ], relo)
+	}
+
+	gen := newBTFGen()
+	for _, localType := range localTypes {
+		localTypeName := localType.TypeName()
+		if localTypeName == "" {
+			return nil, fmt.Errorf("relocate unnamed or anonymous type %s: %w", localType, ErrNotSupported)
+		}
+
+		group := relosByType[localType]
+		targets := target.namedTypes[newEssentialName(localTypeName)]
+		_, best, err := coreCalculateFixups(local, target, localType, targets, group)
+		if err != nil {
+			return nil, fmt.Errorf("relocate %s: %w", localType, err)
+		}
+
+		underlying := Copy(localType, UnderlyingType)
+		for _, targetType := range best {
+			for _, relo := range group {
+				if err := gen.markRelocation(underlying, targetType, relo); err != nil {
+					return nil, fmt.Errorf("relocate %s: %s: %w", localType, relo.kind, err)
+				}
+			}
+		}
+	}
+
+	var roots []Type
+	for _, typ := range target.types {
+		if gen.kept(typ) {
+			roots = append(roots, gen.build(typ))
+		}
+	}
+
+	return NewSpecFromTypes(roots...)
+}
+
+// btfGen tracks which parts of a target Spec are needed by CO-RE relocations.
+type btfGen struct {
+	// Types which are kept with all of their members. Pointers are only
+	// followed if the type is in followed as well.
+	full     map[Type]bool
+	followed map[Type]bool
+
+	// Composite types which only keep some of their members, by index.
+	partial map[Type]map[int]bool
+
+	// Typedefs, qualifiers and arrays on the path to a member. Their
+	// children are marked separately.
+	shallow map[Type]bool
+
+	copies map[Type]Type
+}
+
+func newBTFGen() *btfGen {
+	return &btfGen{
+		make(map[Type]bool),
+		make(map[Type]bool),
+		make(map[Type]map[int]bool),
+		make(map[Type]bool),
+		make(map[Type]Type),
+	}
+}
+
+func (g *btfGen) kept(typ Type) bool {
+	return g.full[typ] || g.shallow[typ] || g.partial[typ] != nil
+}
+
+func (g *btfGen) markRelocation(local, target Type, relo *CORERelocation) error {
+	switch relo.kind {
+	case reloFieldByteOffset, reloFieldByteSize, reloFieldExists, reloFieldSigned, reloFieldLShiftU64, reloFieldRShiftU64:
+		err := g.markField(local, relo.accessor, target)
+		if errors.Is(err, errImpossibleRelocation) {
+			// The relocation is poisoned for this target.
+			return nil
+		}
+		return err
+
+	case reloEnumvalExists, reloEnumvalValue:
+		g.markType(target, false)
+
+	case reloTypeIDTarget, reloTypeSize, reloTypeExists, reloTypeMatches:
+		g.markType(target, true)
+
+	default:
+		return fmt.Errorf("unsupported relocation kind: %w", ErrNotSupported)
+	}
+
+	return nil
+}
+
+// markType keeps typ and all types it refers to. Pointers are only followed
+// if follow is true.
+func (g *btfGen) markType(typ Type, follow bool) {
+	if g.full[typ] && (!follow || g.followed[typ]) {
+		return
+	}
+
+	g.full[typ] = true
+	if follow {
+		g.followed[typ] = true
+	}
+
+	if _, ok := typ.(*Pointer); ok && !follow {
+		return
+	}
+
+	var children typeDeque
+	typ.walk(&children)
+	for !children.empty() {
+		g.markType(*children.shift(), follow)
+	}
+}
+
+// markField keeps the members of target which are needed to access the
+// field described by the accessor of the local type.
+//
+// local must not contain typedefs or qualifiers, see UnderlyingType.
+func (g *btfGen) markField(local Type, acc coreAccessor, target Type) error {
+	var (
+		shallow []Type
+		members []memberRef
+		indices []Type
+	)
+
+	skipQualifiers := func(typ Type) Type {
+		for depth := 0; depth <= maxTypeDepth; depth++ {
+			switch v := typ.(type) {
+			case qualifier:
+				shallow = append(shallow, typ)
+				typ = v.qualify()
+			case *Typedef:
+				shallow = append(shallow, typ)
+				typ = v.Type
+			default:
+				return typ
+			}
+		}
+		return &cycle{typ}
+	}
+
+	target = skipQualifiers(target)
+	for _, a := range acc[1:] {
+		switch localType := local.(type) {
+		case composite:
+			localMembers := localType.members()
+			if a >= len(localMembers) {
+				return fmt.Errorf("invalid accessor %d for %s", a, localType)
+			}
+
+			localMember := localMembers[a]
+			local = localMember.Type
+			if localMember.Name == "" {
+				// Anonymous members are found by coreFindMemberPath.
+				continue
+			}
+
+			targetType, ok := target.(composite)
+			if !ok {
+				return fmt.Errorf("target not composite: %w", errImpossibleRelocation)
+			}
+
+			path, err := coreFindMemberPath(targetType, localMember.Name)
+			if err != nil {
+				return err
+			}
+			members = append(members, path...)
+
+			last := path[len(path)-1]
+			target = skipQualifiers(last.typ.(composite).members()[last.index].Type)
+
+		case *Array:
+			targetType, ok := target.(*Array)
+			if !ok {
+				return fmt.Errorf("target not array: %w", errImpossibleRelocation)
+			}
+
+			shallow = append(shallow, targetType)
+			indices = append(indices, targetType.Index)
+
+			local = localType.Type
+			target = skipQualifiers(targetType.Type)
+
+		default:
+			return fmt.Errorf("relocate field of %T: %w", localType, ErrNotSupported)
+		}
+	}
+
+	for _, typ := range shallow {
+		g.shallow[typ] = true
+	}
+
+	for _, member := range members {
+		keep := g.partial[member.typ]
+		if keep == nil {
+			keep = make(map[int]bool)
+			g.partial[member.typ] = keep
+		}
+		keep[member.index] = true
+	}
+
+	for _, typ := range indices {
+		g.markType(typ, false)
+	}
+
+	g.markType(target, false)
+	return nil
+}
+
+// build returns a copy of typ which only contains the kept parts.
+func (g *btfGen) build(typ Type) Type {
+	if cpy, ok := g.copies[typ]; ok {
+		return cpy
+	}
+
+	if !g.kept(typ) {
+		return &Void{}
+	}
+
+	cpy := typ.copy()
+	g.copies[typ] = cpy
+
+	if keep := g.partial[typ]; keep != nil && !g.full[typ] {
+		switch v := cpy.(type) {
+		case *Struct:
+			v.Members = filterMembers(v.Members, keep)
+		case *Union:
+			v.Members = filterMembers(v.Members, keep)
+		}
+	}
+
+	var children typeDeque
+	cpy.walk(&children)
+	for !children.empty() {
+		child := children.shift()
+		*child = g.build(*child)
+	}
+
+	return cpy
+}
+
+func filterMembers(members []Member, keep map[int]bool) []Member {
+	var result []Member
+	for i, member := range members {
+		if keep[i] {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// memberRef identifies a member of a composite type.
+type memberRef struct {
+	typ   Type
+	index int
+}
+
+// coreFindMemberPath finds a member in a composite type like coreFindMember,
+// but returns all members on the way to it, including anonymous structs and
+// unions.
+func coreFindMemberPath(typ composite, name string) ([]memberRef, error) {
+	type candidate struct {
+		composite
+		path []memberRef
+	}
+
+	candidates := []candidate{{typ, nil}}
+	visited := make(map[composite]bool)
+
+	for i := 0; i < len(candidates); i++ {
+		c := candidates[i]
+		if visited[c.composite] {
+			continue
+		}
+		if len(visited) >= maxTypeDepth {
+			return nil, fmt.Errorf("type is nested too deep")
+		}
+		visited[c.composite] = true
+
+		for j, member := range c.members() {
+			path := append(c.path[:len(c.path):len(c.path)], memberRef{c.composite.(Type), j})
+			if member.Name == name {
+				return path, nil
+			}
+
+			if member.Name != "" {
+				continue
+			}
+
+			comp, ok := member.Type.(composite)
+			if !ok {
+				return nil, fmt.Errorf("anonymous non-composite type %T not allowed", member.Type)
+			}
+
+			candidates = append(candidates, candidate{comp, path})
+		}
+	}
+
+	return nil, fmt.Errorf("no matching member: %w", errImpossibleRelocation)
+}
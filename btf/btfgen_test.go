@@ -0,0 +1,136 @@
+package btf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/google/go-cmp/cmp"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMinimalSpec(t *testing.T) {
+	i32 := &Int{Name: "int", Size: 4, Encoding: Signed}
+
+	localTask := &Struct{Name: "task", Size: 12}
+	localTask.Members = []Member{
+		{Name: "pid", Type: i32},
+		{Name: "b", Type: i32, Offset: 32},
+		{Name: "parent", Type: &Pointer{Target: localTask}, Offset: 64},
+	}
+
+	mm := &Struct{Name: "mm", Size: 4, Members: []Member{{Name: "x", Type: i32}}}
+	anon := &Struct{Size: 8, Members: []Member{
+		{Name: "a", Type: i32},
+		{Name: "b", Type: i32, Offset: 32},
+	}}
+	targetTask := &Struct{Name: "task", Size: 40}
+	targetTask.Members = []Member{
+		{Name: "mm", Type: &Pointer{Target: mm}},
+		{Name: "pid", Type: &Typedef{Name: "pid_t", Type: i32}, Offset: 64},
+		{Name: "flags", Type: i32, Offset: 96},
+		{Name: "", Type: anon, Offset: 128},
+		{Name: "parent", Type: &Pointer{Target: targetTask}, Offset: 256},
+	}
+
+	local, err := NewSpecFromTypes(localTask)
+	qt.Assert(t, err, qt.IsNil)
+
+	target, err := NewSpecFromTypes(targetTask)
+	qt.Assert(t, err, qt.IsNil)
+
+	relos := []*CORERelocation{
+		{localTask, coreAccessor{0, 0}, reloFieldByteOffset},
+		{localTask, coreAccessor{0, 1}, reloFieldByteOffset},
+	}
+
+	minimal, err := MinimalSpec(local, target, relos)
+	qt.Assert(t, err, qt.IsNil)
+
+	var task *Struct
+	qt.Assert(t, minimal.TypeByName("task", &task), qt.IsNil)
+	qt.Assert(t, task.Size, qt.Equals, targetTask.Size)
+	qt.Assert(t, task.Members, qt.HasLen, 2)
+	qt.Assert(t, task.Members[0].Name, qt.Equals, "pid")
+	qt.Assert(t, task.Members[0].Offset, qt.Equals, Bits(64))
+	qt.Assert(t, task.Members[0].Type.TypeName(), qt.Equals, "pid_t")
+
+	nested, ok := task.Members[1].Type.(*Struct)
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, nested.Members, qt.HasLen, 1)
+	qt.Assert(t, nested.Members[0].Name, qt.Equals, "b")
+
+	_, err = minimal.AnyTypeByName("mm")
+	qt.Assert(t, err, qt.ErrorIs, ErrNotFound)
+
+	// The minimal spec yields the same fixups as the full one.
+	want, err := CORERelocate(local, target, relos)
+	qt.Assert(t, err, qt.IsNil)
+	got, err := CORERelocate(local, minimal, relos)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got, qt.CmpEquals(cmp.AllowUnexported(COREFixup{})), want)
+
+	// Type based relocations keep the whole type.
+	minimal, err = MinimalSpec(local, target, []*CORERelocation{
+		{localTask, coreAccessor{0}, reloTypeSize},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, minimal.TypeByName("task", &task), qt.IsNil)
+	qt.Assert(t, task.Members, qt.HasLen, len(targetTask.Members))
+	qt.Assert(t, minimal.TypeByName("mm", new(*Struct)), qt.IsNil)
+}
+
+func TestMinimalSpecPointerToUnusedType(t *testing.T) {
+	i32 := &Int{Name: "int", Size: 4, Encoding: Signed}
+	mm := &Struct{Name: "mm", Size: 4, Members: []Member{{Name: "x", Type: i32}}}
+
+	localTask := &Struct{Name: "task", Size: 8, Members: []Member{
+		{Name: "mm", Type: &Pointer{Target: &Void{}}},
+	}}
+	targetTask := &Struct{Name: "task", Size: 8, Members: []Member{
+		{Name: "mm", Type: &Pointer{Target: mm}},
+	}}
+
+	local, err := NewSpecFromTypes(localTask)
+	qt.Assert(t, err, qt.IsNil)
+	target, err := NewSpecFromTypes(targetTask)
+	qt.Assert(t, err, qt.IsNil)
+
+	minimal, err := MinimalSpec(local, target, []*CORERelocation{
+		{localTask, coreAccessor{0, 0}, reloFieldByteOffset},
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	var task *Struct
+	qt.Assert(t, minimal.TypeByName("task", &task), qt.IsNil)
+	qt.Assert(t, task.Members[0].Type, qt.DeepEquals, Type(&Pointer{Target: &Void{}}))
+}
+
+func TestLoadKernelSpecFromFS(t *testing.T) {
+	i32 := &Int{Name: "int", Size: 4, Encoding: Signed}
+	spec, err := NewSpecFromTypes(&Typedef{Name: "foo", Type: i32})
+	qt.Assert(t, err, qt.IsNil)
+
+	raw, err := spec.Marshal()
+	qt.Assert(t, err, qt.IsNil)
+
+	_, err = LoadSpecFromReader(bytes.NewReader(raw))
+	qt.Assert(t, err, qt.IsNil)
+
+	release, err := internal.KernelRelease()
+	qt.Assert(t, err, qt.IsNil)
+
+	fsys := fstest.MapFS{
+		release + ".btf": &fstest.MapFile{Data: raw},
+	}
+
+	kernel, err := LoadKernelSpecFromFS(fsys)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, kernel.TypeByName("foo", new(*Typedef)), qt.IsNil)
+
+	_, err = LoadKernelSpecFromFS(fstest.MapFS{})
+	qt.Assert(t, errors.Is(err, ErrNotSupported), qt.IsTrue)
+}
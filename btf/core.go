@@ -18,8 +18,8 @@ import (
 // COREFixup is the result of computing a CO-RE relocation for a target.
 type COREFixup struct {
 	kind   coreKind
-	local  uint32
-	target uint32
+	local  uint64
+	target uint64
 	// True if there is no valid fixup. The instruction is replaced with an
 	// invalid dummy.
 	poison bool
@@ -119,10 +119,11 @@ const (
 	reloTypeSize                        /* type size in bytes */
 	reloEnumvalExists                   /* enum value existence in target kernel */
 	reloEnumvalValue                    /* enum value integer value */
+	reloTypeMatches                     /* type matches kernel type */
 )
 
 func (k coreKind) checksForExistence() bool {
-	return k == reloEnumvalExists || k == reloTypeExists || k == reloFieldExists
+	return k == reloEnumvalExists || k == reloTypeExists || k == reloFieldExists || k == reloTypeMatches
 }
 
 func (k coreKind) String() string {
@@ -151,6 +152,8 @@ func (k coreKind) String() string {
 		return "enumval_exists"
 	case reloEnumvalValue:
 		return "enumval_value"
+	case reloTypeMatches:
+		return "type_matches"
 	default:
 		return "unknown"
 	}
@@ -192,8 +195,8 @@ func CORERelocate(local, target *Spec, relos []*CORERelocation) ([]COREFixup, er
 
 			result[i] = COREFixup{
 				kind:   relo.kind,
-				local:  uint32(id),
-				target: uint32(id),
+				local:  uint64(id),
+				target: uint64(id),
 			}
 			continue
 		}
@@ -214,7 +217,7 @@ func CORERelocate(local, target *Spec, relos []*CORERelocation) ([]COREFixup, er
 		}
 
 		targets := target.namedTypes[newEssentialName(localTypeName)]
-		fixups, err := coreCalculateFixups(local, target, localType, targets, group.relos)
+		fixups, _, err := coreCalculateFixups(local, target, localType, targets, group.relos)
 		if err != nil {
 			return nil, fmt.Errorf("relocate %s: %w", localType, err)
 		}
@@ -234,20 +237,22 @@ var errImpossibleRelocation = errors.New("impossible relocation")
 // the "best" target.
 //
 // The best target is determined by scoring: the less poisoning we have to do
-// the better the target is.
-func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type, relos []*CORERelocation) ([]COREFixup, error) {
+// the better the target is. All targets with the best score are returned
+// alongside the fixups, they are nil if no target matched.
+func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type, relos []*CORERelocation) ([]COREFixup, []Type, error) {
 	localID, err := localSpec.TypeID(local)
 	if err != nil {
-		return nil, fmt.Errorf("local type ID: %w", err)
+		return nil, nil, fmt.Errorf("local type ID: %w", err)
 	}
 	local = Copy(local, UnderlyingType)
 
 	bestScore := len(relos)
 	var bestFixups []COREFixup
+	var bestTargets []Type
 	for i := range targets {
 		targetID, err := targetSpec.TypeID(targets[i])
 		if err != nil {
-			return nil, fmt.Errorf("target type ID: %w", err)
+			return nil, nil, fmt.Errorf("target type ID: %w", err)
 		}
 		target := Copy(targets[i], UnderlyingType)
 
@@ -256,7 +261,7 @@ func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type
 		for _, relo := range relos {
 			fixup, err := coreCalculateFixup(localSpec.byteOrder, local, localID, target, targetID, relo)
 			if err != nil {
-				return nil, fmt.Errorf("target %s: %w", target, err)
+				return nil, nil, fmt.Errorf("target %s: %w", target, err)
 			}
 			if fixup.poison || fixup.isNonExistant() {
 				score++
@@ -273,6 +278,7 @@ func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type
 			// This is the best target yet, use it.
 			bestScore = score
 			bestFixups = fixups
+			bestTargets = []Type{targets[i]}
 			continue
 		}
 
@@ -280,9 +286,10 @@ func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type
 		// the fixups agree with each other.
 		for i, fixup := range bestFixups {
 			if !fixup.equal(fixups[i]) {
-				return nil, fmt.Errorf("%s: multiple types match: %w", fixup.kind, errAmbiguousRelocation)
+				return nil, nil, fmt.Errorf("%s: multiple types match: %w", fixup.kind, errAmbiguousRelocation)
 			}
 		}
+		bestTargets = append(bestTargets, targets[i])
 	}
 
 	if bestFixups == nil {
@@ -290,6 +297,7 @@ func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type
 		// targets at all.
 		//
 		// Poison everything except checksForExistence.
+		bestTargets = nil
 		bestFixups = make([]COREFixup, len(relos))
 		for i, relo := range relos {
 			if relo.kind.checksForExistence() {
@@ -300,16 +308,16 @@ func coreCalculateFixups(localSpec, targetSpec *Spec, local Type, targets []Type
 		}
 	}
 
-	return bestFixups, nil
+	return bestFixups, bestTargets, nil
 }
 
 // coreCalculateFixup calculates the fixup for a single local type, target type
 // and relocation.
 func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID, target Type, targetID TypeID, relo *CORERelocation) (COREFixup, error) {
-	fixup := func(local, target uint32) (COREFixup, error) {
+	fixup := func(local, target uint64) (COREFixup, error) {
 		return COREFixup{kind: relo.kind, local: local, target: target}, nil
 	}
-	fixupWithoutValidation := func(local, target uint32) (COREFixup, error) {
+	fixupWithoutValidation := func(local, target uint64) (COREFixup, error) {
 		return COREFixup{kind: relo.kind, local: local, target: target, skipLocalValidation: true}, nil
 	}
 	poison := func() (COREFixup, error) {
@@ -339,7 +347,7 @@ func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID,
 			return fixup(1, 1)
 
 		case reloTypeIDTarget:
-			return fixup(uint32(localID), uint32(targetID))
+			return fixup(uint64(localID), uint64(targetID))
 
 		case reloTypeSize:
 			localSize, err := Sizeof(local)
@@ -352,9 +360,24 @@ func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID,
 				return zero, err
 			}
 
-			return fixup(uint32(localSize), uint32(targetSize))
+			return fixup(uint64(localSize), uint64(targetSize))
 		}
 
+	case reloTypeMatches:
+		if len(relo.accessor) > 1 || relo.accessor[0] != 0 {
+			return zero, fmt.Errorf("%s: unexpected accessor %v", relo.kind, relo.accessor)
+		}
+
+		err := coreTypesMatch(local, target, false, 0)
+		if errors.Is(err, errImpossibleRelocation) {
+			return poison()
+		}
+		if err != nil {
+			return zero, fmt.Errorf("relocation %s: %w", relo.kind, err)
+		}
+
+		return fixup(1, 1)
+
 	case reloEnumvalValue, reloEnumvalExists:
 		localValue, targetValue, err := coreFindEnumValue(local, relo.accessor, target)
 		if errors.Is(err, errImpossibleRelocation) {
@@ -369,23 +392,10 @@ func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID,
 			return fixup(1, 1)
 
 		case reloEnumvalValue:
-			return fixup(uint32(localValue.Value), uint32(targetValue.Value))
+			return fixup(localValue.Value, targetValue.Value)
 		}
 
-	case reloFieldSigned:
-		switch local.(type) {
-		case *Enum:
-			return fixup(1, 1)
-		case *Int:
-			return fixup(
-				uint32(local.(*Int).Encoding&Signed),
-				uint32(target.(*Int).Encoding&Signed),
-			)
-		default:
-			return fixupWithoutValidation(0, 0)
-		}
-
-	case reloFieldByteOffset, reloFieldByteSize, reloFieldExists, reloFieldLShiftU64, reloFieldRShiftU64:
+	case reloFieldByteOffset, reloFieldByteSize, reloFieldExists, reloFieldSigned, reloFieldLShiftU64, reloFieldRShiftU64:
 		if _, ok := target.(*Fwd); ok {
 			// We can't relocate fields using a forward declaration, so
 			// skip it. If a non-forward declaration is present in the BTF
@@ -411,36 +421,61 @@ func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID,
 			return fixup(1, 1)
 
 		case reloFieldByteOffset:
-			return maybeSkipValidation(fixup(localField.offset, targetField.offset))
+			return maybeSkipValidation(fixup(uint64(localField.offset), uint64(targetField.offset)))
 
 		case reloFieldByteSize:
-			localSize, err := Sizeof(localField.Type)
+			localSize, err := localField.byteSize()
 			if err != nil {
 				return zero, err
 			}
 
-			targetSize, err := Sizeof(targetField.Type)
+			targetSize, err := targetField.byteSize()
 			if err != nil {
 				return zero, err
 			}
-			return maybeSkipValidation(fixup(uint32(localSize), uint32(targetSize)))
+			return maybeSkipValidation(fixup(uint64(localSize), uint64(targetSize)))
+
+		case reloFieldSigned:
+			switch local := UnderlyingType(localField.Type).(type) {
+			case *Enum:
+				target, ok := UnderlyingType(targetField.Type).(*Enum)
+				if !ok {
+					return zero, fmt.Errorf("target isn't *Enum but %T", targetField.Type)
+				}
+
+				return fixup(boolToUint64(local.Signed), boolToUint64(target.Signed))
+
+			case *Int:
+				target, ok := UnderlyingType(targetField.Type).(*Int)
+				if !ok {
+					return zero, fmt.Errorf("target isn't *Int but %T", targetField.Type)
+				}
+
+				return fixup(
+					uint64(local.Encoding&Signed),
+					uint64(target.Encoding&Signed),
+				)
+
+			default:
+				return fixupWithoutValidation(0, 0)
+			}
 
 		case reloFieldLShiftU64:
-			var target uint32
+			var target uint64
 			if byteOrder == binary.LittleEndian {
 				targetSize, err := targetField.sizeBits()
 				if err != nil {
 					return zero, err
 				}
 
-				target = uint32(64 - targetField.bitfieldOffset - targetSize)
+				target = uint64(64 - targetField.bitfieldOffset - targetSize)
 			} else {
-				loadWidth, err := Sizeof(targetField.Type)
+				loadWidth, err := targetField.byteSize()
 				if err != nil {
 					return zero, err
 				}
 
-				target = uint32(64 - Bits(loadWidth*8) + targetField.bitfieldOffset)
+				target = uint64(64 - Bits(loadWidth*8) + targetField.bitfieldOffset)
 			}
 			return fixupWithoutValidation(0, target)
 
@@ -450,7 +485,7 @@ func coreCalculateFixup(byteOrder binary.ByteOrder, local Type, localID TypeID,
 				return zero, err
 			}
 
-			return fixupWithoutValidation(0, uint32(64-targetSize))
+			return fixupWithoutValidation(0, uint64(64-targetSize))
 		}
 	}
 
@@ -533,6 +568,13 @@ func (ca coreAccessor) enumValue(t Type) (*EnumValue, error) {
 	return &e.Values[i], nil
 }
 
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // coreField represents the position of a "child" of a composite type from the
 // start of that type.
 //
@@ -580,6 +622,17 @@ func (cf *coreField) adjustOffsetBits(offset Bits) error {
 	// we can "skip" with the aligned offset.
 	cf.bitfieldOffset = offset - Bits(offsetBytes*8)
 
+	// Widen the load until it covers the whole bitfield.
+	for cf.bitfieldOffset+cf.bitfieldSize > Bits(align*8) {
+		if align >= 8 {
+			return fmt.Errorf("bitfield at bit offset %d can't be read with a 64 bit load", offset)
+		}
+
+		align *= 2
+		offsetBytes = uint32(offset/8) / uint32(align) * uint32(align)
+		cf.bitfieldOffset = offset - Bits(offsetBytes*8)
+	}
+
 	// We know that cf.offset is aligned at to at least align since we get it
 	// from the compiler via BTF. Adding an aligned offsetBytes preserves the
 	// alignment.
@@ -587,6 +640,30 @@ func (cf *coreField) adjustOffsetBits(offset Bits) error {
 	return nil
 }
 
+// byteSize returns the number of bytes to load to read the field.
+//
+// This may be larger than the size of the field's type if it is a bitfield
+// which straddles an alignment boundary, which is possible in packed structs.
+func (cf *coreField) byteSize() (uint32, error) {
+	if cf.bitfieldSize == 0 && cf.bitfieldOffset == 0 {
+		size, err := Sizeof(cf.Type)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(size), nil
+	}
+
+	align, err := alignof(cf.Type)
+	if err != nil {
+		return 0, err
+	}
+
+	for cf.bitfieldOffset+cf.bitfieldSize > Bits(align*8) {
+		align *= 2
+	}
+	return uint32(align), nil
+}
+
 func (cf *coreField) sizeBits() (Bits, error) {
 	if cf.bitfieldSize > 0 {
 		return cf.bitfieldSize, nil
@@ -970,3 +1047,165 @@ func coreAreMembersCompatible(localType Type, targetType Type) error {
 		return fmt.Errorf("type %s: %w", localType, ErrNotSupported)
 	}
 }
+
+/* coreTypesMatch checks two types for a match as defined by
+ * bpf_core_types_match in libbpf. The rules are stricter than those of
+ * coreAreTypesCompatible, and names of nested types matter:
+ *   - typedefs and CONST/VOLATILE/RESTRICT modifiers are ignored;
+ *   - names have to match (modulo flavor suffix), anonymous types only
+ *     match anonymous types;
+ *   - INTs have to match in size and signedness;
+ *   - ENUMs have to match in size, and every local value has to exist in
+ *     the target with the same name and value;
+ *   - STRUCTs and UNIONs have to be of the same kind, and every local member
+ *     has to exist in the target with a matching type. Behind a pointer, the
+ *     kind is enough and FWDs of the right kind match as well;
+ *   - ARRAYs have to match in length and element type;
+ *   - FUNC_PROTOs have to match in the number of parameters and in the types
+ *     of the parameters and return value.
+ *
+ * Returns errImpossibleRelocation if the types don't match.
+ */
+func coreTypesMatch(localType, targetType Type, behindPtr bool, depth int) error {
+	if depth >= maxTypeDepth {
+		return errors.New("types are nested too deep")
+	}
+
+	localType, targetType = UnderlyingType(localType), UnderlyingType(targetType)
+
+	localName, targetName := localType.TypeName(), targetType.TypeName()
+	if newEssentialName(localName) != newEssentialName(targetName) {
+		return fmt.Errorf("names don't match: %w", errImpossibleRelocation)
+	}
+
+	mismatch := fmt.Errorf("%T doesn't match %T: %w", localType, targetType, errImpossibleRelocation)
+
+	switch lv := localType.(type) {
+	case *Void:
+		if _, ok := targetType.(*Void); !ok {
+			return mismatch
+		}
+
+	case *Fwd:
+		switch tv := targetType.(type) {
+		case *Fwd:
+			if lv.Kind != tv.Kind {
+				return mismatch
+			}
+		case *Struct:
+			if !behindPtr || lv.Kind != FwdStruct {
+				return mismatch
+			}
+		case *Union:
+			if !behindPtr || lv.Kind != FwdUnion {
+				return mismatch
+			}
+		default:
+			return mismatch
+		}
+
+	case *Enum:
+		tv, ok := targetType.(*Enum)
+		if !ok || lv.size() != tv.size() {
+			return mismatch
+		}
+
+	values:
+		for _, lval := range lv.Values {
+			for _, tval := range tv.Values {
+				if newEssentialName(lval.Name) == newEssentialName(tval.Name) && lval.Value == tval.Value {
+					continue values
+				}
+			}
+			return fmt.Errorf("enum value %s: %w", lval.Name, errImpossibleRelocation)
+		}
+
+	case *Struct, *Union:
+		if behindPtr {
+			if tv, ok := targetType.(*Fwd); ok {
+				_, isUnion := localType.(*Union)
+				if isUnion != (tv.Kind == FwdUnion) {
+					return mismatch
+				}
+				return nil
+			}
+		}
+
+		if reflect.TypeOf(localType) != reflect.TypeOf(targetType) {
+			return mismatch
+		}
+
+		if behindPtr {
+			return nil
+		}
+
+		localMembers := localType.(composite).members()
+		targetMembers := targetType.(composite).members()
+		if len(localMembers) > len(targetMembers) {
+			return fmt.Errorf("target has fewer members: %w", errImpossibleRelocation)
+		}
+
+	members:
+		for _, lm := range localMembers {
+			for _, tm := range targetMembers {
+				if lm.Name != tm.Name && (tm.Name == "" || newEssentialName(lm.Name) != newEssentialName(tm.Name)) {
+					continue
+				}
+
+				err := coreTypesMatch(lm.Type, tm.Type, behindPtr, depth+1)
+				if errors.Is(err, errImpossibleRelocation) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				continue members
+			}
+			return fmt.Errorf("member %s: %w", lm.Name, errImpossibleRelocation)
+		}
+
+	case *Int:
+		tv, ok := targetType.(*Int)
+		if !ok || lv.Size != tv.Size || lv.Encoding.IsSigned() != tv.Encoding.IsSigned() {
+			return mismatch
+		}
+
+	case *Float:
+		tv, ok := targetType.(*Float)
+		if !ok || lv.Size != tv.Size {
+			return mismatch
+		}
+
+	case *Pointer:
+		tv, ok := targetType.(*Pointer)
+		if !ok {
+			return mismatch
+		}
+		return coreTypesMatch(lv.Target, tv.Target, true, depth+1)
+
+	case *Array:
+		tv, ok := targetType.(*Array)
+		if !ok || lv.Nelems != tv.Nelems {
+			return mismatch
+		}
+		return coreTypesMatch(lv.Type, tv.Type, behindPtr, depth+1)
+
+	case *FuncProto:
+		tv, ok := targetType.(*FuncProto)
+		if !ok || len(lv.Params) != len(tv.Params) {
+			return mismatch
+		}
+
+		for i := range lv.Params {
+			if err := coreTypesMatch(lv.Params[i].Type, tv.Params[i].Type, behindPtr, depth+1); err != nil {
+				return fmt.Errorf("param %d: %w", i, err)
+			}
+		}
+		return coreTypesMatch(lv.Return, tv.Return, behindPtr, depth+1)
+
+	default:
+		return fmt.Errorf("type %s: %w", localType, ErrNotSupported)
+	}
+
+	return nil
+}
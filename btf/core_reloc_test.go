@@ -1,6 +1,7 @@
 package btf_test
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -109,3 +110,76 @@ func TestCORERelocationRead(t *testing.T) {
 		}
 	})
 }
+
+func TestCORERelocationMinimalBTF(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		target func(*ebpf.CollectionSpec) (*btf.Spec, error)
+	}{
+		{"relocs", func(spec *ebpf.CollectionSpec) (*btf.Spec, error) {
+			return spec.Types, nil
+		}},
+		{"relocs_read", func(*ebpf.CollectionSpec) (*btf.Spec, error) {
+			return btf.LoadSpec(fmt.Sprintf("testdata/relocs_read_tgt-%s.elf", internal.ClangEndian))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := ebpf.LoadCollectionSpec(fmt.Sprintf("testdata/%s-%s.elf", tc.name, internal.ClangEndian))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Programs which are expected to fail to relocate would fail
+			// minimizing as well. type_ids expects the IDs of the target
+			// to match the local ones, which isn't the case after minimizing.
+			for name := range spec.Programs {
+				if strings.HasPrefix(name, "err_") || name == "type_ids" {
+					delete(spec.Programs, name)
+				}
+			}
+
+			target, err := tc.target(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			minimal, err := spec.MinimalBTF(target)
+			if err != nil {
+				t.Fatal("Minimize BTF:", err)
+			}
+
+			raw, err := minimal.Marshal()
+			if err != nil {
+				t.Fatal("Marshal minimal BTF:", err)
+			}
+
+			minimal, err = btf.LoadSpecFromReader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal("Load minimal BTF:", err)
+			}
+
+			for _, progSpec := range spec.Programs {
+				t.Run(progSpec.Name, func(t *testing.T) {
+					prog, err := ebpf.NewProgramWithOptions(progSpec, ebpf.ProgramOptions{
+						KernelTypes: minimal,
+					})
+					testutils.SkipIfNotSupported(t, err)
+					if err != nil {
+						t.Fatal("Load program:", err)
+					}
+					defer prog.Close()
+
+					ret, _, err := prog.Test(make([]byte, 14))
+					testutils.SkipIfNotSupported(t, err)
+					if err != nil {
+						t.Fatal("Error when running:", err)
+					}
+
+					if ret != 0 {
+						t.Error("Assertion failed on line", ret)
+					}
+				})
+			}
+		})
+	}
+}
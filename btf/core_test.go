@@ -1,12 +1,14 @@
 package btf
 
 import (
+	"encoding/binary"
 	"errors"
 	"math/rand"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/internal/testutils"
 	"github.com/google/go-cmp/cmp"
 
@@ -140,6 +142,70 @@ func TestCOREAreMembersCompatible(t *testing.T) {
 	}
 }
 
+func TestCORETypesMatch(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	s32 := &Int{Name: "s32", Size: 4, Encoding: Signed}
+	fooStruct := func(members ...Member) *Struct {
+		return &Struct{Name: "foo", Members: members}
+	}
+
+	tests := []struct {
+		a, b    Type
+		matches bool
+	}{
+		{&Void{}, &Void{}, true},
+		{u32, &Int{Name: "u32", Size: 4}, true},
+		{u32, &Int{Name: "u32", Size: 8}, false},
+		{u32, &Int{Name: "u32", Size: 4, Encoding: Signed}, false},
+		{u32, &Typedef{Name: "u32", Type: &Int{Name: "u32", Size: 4}}, true},
+		{&Typedef{Name: "t", Type: u32}, &Const{Type: &Int{Name: "u32", Size: 4}}, true},
+		{&Enum{Name: "e", Values: []EnumValue{{"A", 1}}}, &Enum{Name: "e___x", Values: []EnumValue{{"B", 2}, {"A", 1}}}, true},
+		{&Enum{Name: "e", Values: []EnumValue{{"A", 1}}}, &Enum{Name: "e", Values: []EnumValue{{"A", 2}}}, false},
+		{&Enum{Name: "e", Size: 4}, &Enum{Name: "e", Size: 8}, false},
+		{&Enum{}, &Enum{Name: "e"}, false},
+		{fooStruct(Member{Name: "a", Type: u32}), fooStruct(Member{Name: "b", Type: s32}, Member{Name: "a", Type: u32}), true},
+		{fooStruct(Member{Name: "a", Type: u32}), fooStruct(Member{Name: "a", Type: s32}), false},
+		{fooStruct(Member{Name: "a", Type: u32}), fooStruct(), false},
+		{fooStruct(), &Union{Name: "foo"}, false},
+		{fooStruct(), &Fwd{Name: "foo"}, false},
+		{&Pointer{Target: fooStruct(Member{Name: "a", Type: u32})}, &Pointer{Target: fooStruct()}, true},
+		{&Pointer{Target: fooStruct()}, &Pointer{Target: &Fwd{Name: "foo", Kind: FwdStruct}}, true},
+		{&Pointer{Target: fooStruct()}, &Pointer{Target: &Fwd{Name: "foo", Kind: FwdUnion}}, false},
+		{&Pointer{Target: fooStruct()}, &Pointer{Target: &Union{Name: "foo"}}, false},
+		{&Pointer{Target: &Fwd{Name: "foo"}}, &Pointer{Target: fooStruct()}, true},
+		{&Array{Type: u32, Nelems: 2}, &Array{Type: u32, Nelems: 2}, true},
+		{&Array{Type: u32, Nelems: 2}, &Array{Type: u32, Nelems: 3}, false},
+		{&Float{Name: "f", Size: 4}, &Float{Name: "f", Size: 8}, false},
+		{
+			&FuncProto{Return: u32, Params: []FuncParam{{Name: "a", Type: u32}}},
+			&FuncProto{Return: u32, Params: []FuncParam{{Name: "b", Type: u32}}},
+			true,
+		},
+		{
+			&FuncProto{Return: u32, Params: []FuncParam{{Type: u32}}},
+			&FuncProto{Return: u32, Params: []FuncParam{{Type: s32}}},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		err := coreTypesMatch(test.a, test.b, false, 0)
+		if test.matches {
+			if err != nil {
+				t.Errorf("Expected types to match: %s\na = %#v\nb = %#v", err, test.a, test.b)
+			}
+		} else if !errors.Is(err, errImpossibleRelocation) {
+			t.Errorf("Expected types to not match: %s\na = %#v\nb = %#v", err, test.a, test.b)
+		}
+	}
+
+	relo := &CORERelocation{u32, coreAccessor{0}, reloTypeMatches}
+	fixup, err := coreCalculateFixup(binary.LittleEndian, u32, 1, s32, 2, relo)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fixup.poison, qt.IsFalse)
+	qt.Assert(t, fixup.isNonExistant(), qt.IsTrue)
+}
+
 func TestCOREAccessor(t *testing.T) {
 	for _, valid := range []string{
 		"0",
@@ -209,7 +275,7 @@ func TestCOREFindEnumValue(t *testing.T) {
 		name                    string
 		local, target           Type
 		acc                     coreAccessor
-		localValue, targetValue int32
+		localValue, targetValue uint64
 	}{
 		{"a to b", a, b, coreAccessor{0}, 23, 0},
 		{"b to a", b, a, coreAccessor{1}, 123, 42},
@@ -570,6 +636,95 @@ func TestCORERelocation(t *testing.T) {
 	})
 }
 
+func TestCOREFixupBitfield(t *testing.T) {
+	u8 := &Int{Size: 1}
+	s8 := &Int{Size: 1, Encoding: Signed}
+
+	// Packed structs in which b straddles a byte boundary. In target, b
+	// also straddles a 16 bit boundary.
+	local := &Struct{Size: 2, Members: []Member{
+		{Name: "a", Type: u8, Offset: 0, BitfieldSize: 6},
+		{Name: "b", Type: s8, Offset: 6, BitfieldSize: 4},
+	}}
+
+	target := &Struct{Size: 4, Members: []Member{
+		{Name: "a", Type: u8, Offset: 0, BitfieldSize: 6},
+		{Name: "b", Type: s8, Offset: 14, BitfieldSize: 4},
+	}}
+
+	for _, test := range []struct {
+		kind          coreKind
+		local, target uint64
+	}{
+		{reloFieldByteOffset, 0, 0},
+		{reloFieldByteSize, 2, 4},
+		{reloFieldSigned, 1, 1},
+		{reloFieldLShiftU64, 0, 64 - 14 - 4},
+		{reloFieldRShiftU64, 0, 64 - 4},
+	} {
+		t.Run(test.kind.String(), func(t *testing.T) {
+			relo := &CORERelocation{local, coreAccessor{0, 1}, test.kind}
+			fixup, err := coreCalculateFixup(binary.LittleEndian, local, 1, target, 1, relo)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, fixup.poison, qt.IsFalse)
+			if !fixup.skipLocalValidation {
+				qt.Check(t, fixup.local, qt.Equals, test.local)
+			}
+			qt.Check(t, fixup.target, qt.Equals, test.target)
+		})
+	}
+
+	unsigned := &Struct{Size: 4, Members: []Member{
+		{Name: "b", Type: &Typedef{Type: u8}, Offset: 0},
+	}}
+	relo := &CORERelocation{local, coreAccessor{0, 1}, reloFieldSigned}
+	fixup, err := coreCalculateFixup(binary.LittleEndian, local, 1, unsigned, 1, relo)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fixup.target, qt.Equals, uint64(0), qt.Commentf("signedness of typedef'd field"))
+
+	// A u8 bitfield which needs a 64 bit load.
+	wide := &Struct{Size: 16, Members: []Member{
+		{Name: "b", Type: u8, Offset: 28, BitfieldSize: 8},
+	}}
+	field, _, err := coreFindField(wide, coreAccessor{0, 0}, wide)
+	qt.Assert(t, err, qt.IsNil)
+	size, err := field.byteSize()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, uint32(8))
+
+	tooWide := &Struct{Size: 16, Members: []Member{
+		{Name: "b", Type: &Int{Size: 8}, Offset: 60, BitfieldSize: 8},
+	}}
+	_, _, err = coreFindField(tooWide, coreAccessor{0, 0}, tooWide)
+	qt.Assert(t, err, qt.Not(qt.IsNil), qt.Commentf("bitfield straddling a 64 bit boundary"))
+}
+
+func TestCOREFixupEnum64(t *testing.T) {
+	local := &Enum{Size: 8, Values: []EnumValue{{"foo", 1 << 40}}}
+	target := &Enum{Size: 8, Values: []EnumValue{{"foo", 1 << 50}}}
+
+	relo := &CORERelocation{local, coreAccessor{0}, reloEnumvalValue}
+	fixup, err := coreCalculateFixup(binary.LittleEndian, local, 1, target, 2, relo)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fixup.local, qt.Equals, uint64(1<<40))
+	qt.Assert(t, fixup.target, qt.Equals, uint64(1<<50))
+
+	ins := asm.LoadImm(asm.R0, 1<<40, asm.DWord)
+	qt.Assert(t, fixup.Apply(&ins), qt.IsNil)
+	qt.Assert(t, ins.Constant, qt.Equals, int64(1<<50))
+
+	ins = asm.Mov.Imm(asm.R0, 0)
+	fixup.skipLocalValidation = true
+	qt.Assert(t, fixup.Apply(&ins), qt.Not(qt.IsNil), qt.Commentf("64 bit value in 32 bit immediate"))
+
+	signed := &Enum{Size: 8, Signed: true, Values: []EnumValue{{"foo", 1}}}
+	mixed := &Struct{Size: 8, Members: []Member{{Name: "e", Type: signed}}}
+	relo = &CORERelocation{mixed, coreAccessor{0, 0}, reloFieldSigned}
+	fixup, err = coreCalculateFixup(binary.LittleEndian, mixed, 1, mixed, 1, relo)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fixup.target, qt.Equals, uint64(1))
+}
+
 func TestCORECopyWithoutQualifiers(t *testing.T) {
 	qualifiers := []struct {
 		name string
@@ -65,7 +65,8 @@ func (gf *GoFormatter) writeTypeDecl(name string, typ Type) error {
 
 	switch v := skipQualifiers(typ).(type) {
 	case *Enum:
-		fmt.Fprintf(&gf.w, "type %s int32", name)
+		fmt.Fprintf(&gf.w, "type %s ", name)
+		gf.writeEnumLit(v)
 		if len(v.Values) == 0 {
 			return nil
 		}
@@ -73,7 +74,13 @@ func (gf *GoFormatter) writeTypeDecl(name string, typ Type) error {
 		gf.w.WriteString("; const ( ")
 		for _, ev := range v.Values {
 			id := gf.enumIdentifier(name, ev.Name)
-			fmt.Fprintf(&gf.w, "%s %s = %d; ", id, name, ev.Value)
+			if enumIsSigned(v) {
+				// Truncate and sign extend the value to the size of the enum.
+				shift := 64 - v.size()*8
+				fmt.Fprintf(&gf.w, "%s %s = %d; ", id, name, int64(ev.Value<<shift)>>shift)
+			} else {
+				fmt.Fprintf(&gf.w, "%s %s = %d; ", id, name, ev.Value)
+			}
 		}
 		gf.w.WriteString(")")
 
@@ -123,7 +130,7 @@ func (gf *GoFormatter) writeTypeLit(typ Type, depth int) error {
 		gf.writeIntLit(v)
 
 	case *Enum:
-		gf.w.WriteString("int32")
+		gf.writeEnumLit(v)
 
 	case *Typedef:
 		err = gf.writeType(v.Type, depth)
@@ -168,6 +175,23 @@ func (gf *GoFormatter) writeIntLit(i *Int) {
 	}
 }
 
+// enumIsSigned returns true if an enum is formatted as a signed integer.
+//
+// BTF before Linux 6.0 doesn't encode the signedness of enums. Enums of up to
+// 32 bits are therefore always treated as signed.
+func enumIsSigned(e *Enum) bool {
+	return e.Signed || e.size() < 8
+}
+
+func (gf *GoFormatter) writeEnumLit(e *Enum) {
+	var encoding IntEncoding
+	if enumIsSigned(e) {
+		encoding = Signed
+	}
+
+	gf.writeIntLit(&Int{Size: e.size(), Encoding: encoding})
+}
+
 func (gf *GoFormatter) writeStructLit(size uint32, members []Member, depth int) error {
 	gf.w.WriteString("struct { ")
 
@@ -259,8 +283,8 @@ func (gf *GoFormatter) writeDatasecLit(ds *Datasec, depth int) error {
 
 	prevOffset := uint32(0)
 	for i, vsi := range ds.Vars {
-		v := vsi.Type.(*Var)
-		if v.Linkage != GlobalVar {
+		v, ok := vsi.Type.(*Var)
+		if !ok || v.Linkage != GlobalVar {
 			// Ignore static, extern, etc. for now.
 			continue
 		}
@@ -0,0 +1,453 @@
+package btf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CFormatter converts a Type to C syntax, similar to
+// `bpftool btf dump file <file> format c`.
+//
+// Named types are referred to by name, anonymous structs, unions and enums
+// are written out in place. A zero CFormatter is valid to use.
+type CFormatter struct {
+	w strings.Builder
+}
+
+// TypeDeclaration generates a C declaration for a BTF type.
+//
+// typ must be a struct, union, enum, forward declaration, typedef, function
+// or variable. For example:
+//
+//	struct foo {
+//		int bar;
+//		char *baz;
+//	};
+func (cf *CFormatter) TypeDeclaration(typ Type) (string, error) {
+	cf.w.Reset()
+	if err := cf.writeTypeDecl(typ); err != nil {
+		return "", err
+	}
+	return cf.w.String(), nil
+}
+
+func (cf *CFormatter) writeTypeDecl(typ Type) error {
+	switch v := typ.(type) {
+	case *Struct, *Union, *Enum:
+		if err := cf.writeTypeLit(v, 0, 0); err != nil {
+			return err
+		}
+
+	case *Fwd:
+		fmt.Fprintf(&cf.w, "%s %s", v.Kind, v.Name)
+
+	case *Typedef:
+		cf.w.WriteString("typedef ")
+		if err := cf.writeDecl(v.Type, v.Name, 0, 0); err != nil {
+			return err
+		}
+
+	case *Func:
+		if _, ok := v.Type.(*FuncProto); !ok {
+			return fmt.Errorf("func %s: expected a FuncProto, got %s", v.Name, v.Type)
+		}
+
+		switch v.Linkage {
+		case StaticFunc:
+			cf.w.WriteString("static ")
+		case ExternFunc:
+			cf.w.WriteString("extern ")
+		}
+
+		if err := cf.writeDecl(v.Type, v.Name, 0, 0); err != nil {
+			return err
+		}
+
+	case *Var:
+		switch v.Linkage {
+		case StaticVar:
+			cf.w.WriteString("static ")
+		case ExternVar:
+			cf.w.WriteString("extern ")
+		}
+
+		if err := cf.writeDecl(v.Type, v.Name, 0, 0); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("can't declare %s in C: %w", typ, ErrNotSupported)
+	}
+
+	cf.w.WriteString(";")
+	return nil
+}
+
+// writeDecl outputs typ followed by a declarator.
+//
+// C declarations are read inside out, so pointers, arrays and function
+// prototypes wrap the declarator before the innermost type is written:
+//
+//	int (*foo)[2]
+//	const char *const bar
+//
+// indent is the nesting level of anonymous types written in place, depth
+// limits recursion.
+func (cf *CFormatter) writeDecl(typ Type, declarator string, indent, depth int) error {
+	if depth > maxTypeDepth {
+		return errNestedTooDeep
+	}
+
+	withDeclarator := func(s string) {
+		cf.w.WriteString(s)
+		if declarator != "" {
+			cf.w.WriteString(" ")
+			cf.w.WriteString(declarator)
+		}
+	}
+
+	switch v := typ.(type) {
+	case *Pointer:
+		inner := "*" + declarator
+		switch skipQualifiers(v.Target).(type) {
+		case *Array, *FuncProto:
+			inner = "(" + inner + ")"
+		}
+		return cf.writeDecl(v.Target, inner, indent, depth+1)
+
+	case *Array:
+		return cf.writeDecl(v.Type, fmt.Sprintf("%s[%d]", declarator, v.Nelems), indent, depth+1)
+
+	case *FuncProto:
+		params, err := cf.funcParams(v, indent, depth)
+		if err != nil {
+			return err
+		}
+		return cf.writeDecl(v.Return, declarator+"("+params+")", indent, depth+1)
+
+	case qualifier:
+		var keyword string
+		switch v.(type) {
+		case *Const:
+			keyword = "const"
+		case *Volatile:
+			keyword = "volatile"
+		case *Restrict:
+			keyword = "restrict"
+		}
+
+		inner := v.qualify()
+		if keyword == "" {
+			// Type tags have no C syntax.
+			return cf.writeDecl(inner, declarator, indent, depth+1)
+		}
+
+		if _, ok := inner.(*Pointer); ok {
+			// Qualifiers of pointers follow the asterisk.
+			if declarator != "" {
+				keyword += " " + declarator
+			}
+			return cf.writeDecl(inner, keyword, indent, depth+1)
+		}
+
+		cf.w.WriteString(keyword + " ")
+		return cf.writeDecl(inner, declarator, indent, depth+1)
+
+	case *Void:
+		withDeclarator("void")
+
+	case *Int:
+		withDeclarator(cIntName(v))
+
+	case *Float:
+		withDeclarator(v.Name)
+
+	case *Typedef:
+		withDeclarator(v.Name)
+
+	case *Fwd:
+		withDeclarator(fmt.Sprintf("%s %s", v.Kind, v.Name))
+
+	case *Struct, *Union, *Enum:
+		if name := v.TypeName(); name != "" {
+			withDeclarator(fmt.Sprintf("%s %s", cKeyword(v), name))
+			return nil
+		}
+
+		if err := cf.writeTypeLit(v, indent, depth); err != nil {
+			return err
+		}
+		withDeclarator("")
+
+	default:
+		return fmt.Errorf("type %T: %w", v, ErrNotSupported)
+	}
+
+	return nil
+}
+
+func (cf *CFormatter) funcParams(fp *FuncProto, indent, depth int) (string, error) {
+	if len(fp.Params) == 0 {
+		return "void", nil
+	}
+
+	params := make([]string, 0, len(fp.Params))
+	for i, param := range fp.Params {
+		if _, ok := param.Type.(*Void); ok && i == len(fp.Params)-1 {
+			params = append(params, "...")
+			continue
+		}
+
+		var sub CFormatter
+		if err := sub.writeDecl(param.Type, param.Name, indent, depth+1); err != nil {
+			return "", fmt.Errorf("param %s: %w", param.Name, err)
+		}
+		params = append(params, sub.w.String())
+	}
+
+	return strings.Join(params, ", "), nil
+}
+
+// writeTypeLit outputs the full definition of a struct, union or enum.
+func (cf *CFormatter) writeTypeLit(typ Type, indent, depth int) error {
+	cf.w.WriteString(cKeyword(typ))
+	if name := typ.TypeName(); name != "" {
+		cf.w.WriteString(" " + name)
+	}
+	cf.w.WriteString(" {\n")
+
+	packed := false
+	switch v := typ.(type) {
+	case *Struct:
+		packed = cIsPacked(v.Size, v.Members)
+		if err := cf.writeMembers(v.Size, v.Members, packed, indent, depth); err != nil {
+			return err
+		}
+
+	case *Union:
+		for _, m := range v.Members {
+			if err := cf.writeMember(m, indent, depth); err != nil {
+				return err
+			}
+		}
+
+	case *Enum:
+		for _, ev := range v.Values {
+			cf.writeIndent(indent + 1)
+			if v.Signed {
+				// Truncate and sign extend the value to the size of the enum.
+				shift := 64 - v.size()*8
+				fmt.Fprintf(&cf.w, "%s = %d,\n", ev.Name, int64(ev.Value<<shift)>>shift)
+			} else {
+				fmt.Fprintf(&cf.w, "%s = %d,\n", ev.Name, ev.Value)
+			}
+		}
+	}
+
+	cf.writeIndent(indent)
+	cf.w.WriteString("}")
+	if packed {
+		cf.w.WriteString(" __attribute__((packed))")
+	}
+	return nil
+}
+
+// writeMembers outputs the members of a struct, adding explicit padding
+// where the offsets don't match the natural layout.
+func (cf *CFormatter) writeMembers(size uint32, members []Member, packed bool, indent, depth int) error {
+	var offset Bits
+	for _, m := range members {
+		natural := offset
+		if m.BitfieldSize > 0 {
+			// A bitfield starts a new storage unit if it doesn't fit into
+			// the current one.
+			if unit, err := Sizeof(m.Type); err == nil && !packed {
+				bits := Bits(unit) * 8
+				if natural/bits != (natural+m.BitfieldSize-1)/bits {
+					natural = roundUpBits(natural, bits)
+				}
+			}
+		} else if align, err := cAlignof(m.Type); err == nil && !packed {
+			natural = roundUpBits(natural, Bits(align)*8)
+		} else if err != nil {
+			// Unknown alignment, trust the offset.
+			natural = m.Offset
+		}
+
+		if m.Offset > natural {
+			cf.writePadding(offset, m.Offset, indent)
+		}
+
+		if err := cf.writeMember(m, indent, depth); err != nil {
+			return err
+		}
+
+		end := m.Offset + m.BitfieldSize
+		if m.BitfieldSize == 0 {
+			size, err := Sizeof(m.Type)
+			if err != nil {
+				return fmt.Errorf("member %s: %w", m.Name, err)
+			}
+			end = m.Offset + Bits(size)*8
+		}
+		if end > offset {
+			offset = end
+		}
+	}
+
+	end := Bits(size) * 8
+	if align, err := cAlignof(&Struct{Members: members}); err == nil && !packed {
+		if roundUpBits(offset, Bits(align)*8) < end {
+			cf.writePadding(offset, end, indent)
+		}
+	}
+
+	return nil
+}
+
+func (cf *CFormatter) writeMember(m Member, indent, depth int) error {
+	cf.writeIndent(indent + 1)
+	if err := cf.writeDecl(m.Type, m.Name, indent+1, depth+1); err != nil {
+		return fmt.Errorf("member %s: %w", m.Name, err)
+	}
+	if m.BitfieldSize > 0 {
+		fmt.Fprintf(&cf.w, ": %d", m.BitfieldSize)
+	}
+	cf.w.WriteString(";\n")
+	return nil
+}
+
+// writePadding outputs unnamed bitfields covering the bits between from and to.
+func (cf *CFormatter) writePadding(from, to Bits, indent int) {
+	for from < to {
+		name, bits := "char", 8-from%8
+		for _, unit := range []struct {
+			name string
+			bits Bits
+		}{{"long", 64}, {"int", 32}, {"short", 16}, {"char", 8}} {
+			// Don't straddle the alignment of the unit.
+			if from%unit.bits == 0 && to-from >= unit.bits {
+				name, bits = unit.name, unit.bits
+				break
+			}
+		}
+		if bits > to-from {
+			bits = to - from
+		}
+
+		cf.writeIndent(indent + 1)
+		fmt.Fprintf(&cf.w, "%s: %d;\n", name, bits)
+		from += bits
+	}
+}
+
+func (cf *CFormatter) writeIndent(indent int) {
+	cf.w.WriteString(strings.Repeat("\t", indent))
+}
+
+// cIsPacked returns true if a struct can't be expressed without
+// __attribute__((packed)).
+func cIsPacked(size uint32, members []Member) bool {
+	for _, m := range members {
+		if m.BitfieldSize > 0 {
+			continue
+		}
+
+		align, err := cAlignof(m.Type)
+		if err != nil {
+			continue
+		}
+
+		if m.Offset%(Bits(align)*8) != 0 {
+			return true
+		}
+	}
+
+	align, err := cAlignof(&Struct{Members: members})
+	return err == nil && size%uint32(align) != 0
+}
+
+// cAlignof returns the alignment of a type in bytes on a 64 bit target.
+func cAlignof(typ Type) (int, error) {
+	return cAlignofDepth(typ, 0)
+}
+
+func cAlignofDepth(typ Type, depth int) (int, error) {
+	if depth > maxTypeDepth {
+		return 0, errNestedTooDeep
+	}
+
+	switch t := UnderlyingType(typ).(type) {
+	case *Pointer:
+		return 8, nil
+	case *Float:
+		return int(t.Size), nil
+	case composite:
+		align := 1
+		for _, m := range t.members() {
+			a, err := cAlignofDepth(m.Type, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if a > align {
+				align = a
+			}
+		}
+		return align, nil
+	case *Array:
+		return cAlignofDepth(t.Type, depth+1)
+	default:
+		return alignof(t)
+	}
+}
+
+func roundUpBits(offset, align Bits) Bits {
+	if align == 0 {
+		return offset
+	}
+	return (offset + align - 1) / align * align
+}
+
+func cKeyword(typ Type) string {
+	switch typ.(type) {
+	case *Struct:
+		return "struct"
+	case *Union:
+		return "union"
+	case *Enum:
+		return "enum"
+	default:
+		return ""
+	}
+}
+
+// cIntName returns the name of an integer, synthesizing one for anonymous
+// integers.
+func cIntName(i *Int) string {
+	if i.Name != "" {
+		return i.Name
+	}
+
+	if i.Encoding == Bool {
+		return "_Bool"
+	}
+
+	var name string
+	switch i.Size {
+	case 1:
+		name = "char"
+	case 2:
+		name = "short"
+	case 4:
+		name = "int"
+	case 8:
+		name = "long long"
+	default:
+		name = "__int128"
+	}
+
+	if i.Encoding.IsSigned() {
+		return name
+	}
+	return "unsigned " + name
+}
@@ -0,0 +1,220 @@
+package btf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCTypeDeclaration(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	s32 := &Int{Name: "int", Size: 4, Encoding: Signed}
+	char := &Int{Name: "char", Size: 1, Encoding: Signed | Char}
+
+	tests := []struct {
+		typ    Type
+		output string
+	}{
+		{&Fwd{Name: "foo", Kind: FwdUnion}, "union foo;"},
+		{&Typedef{Name: "t", Type: u32}, "typedef u32 t;"},
+		{&Typedef{Name: "t", Type: &Int{Size: 8}}, "typedef unsigned long long t;"},
+		{&Typedef{Name: "t", Type: &Array{Nelems: 2, Type: &Pointer{Target: char}}}, "typedef char *t[2];"},
+		{&Typedef{Name: "t", Type: &Pointer{Target: &Array{Nelems: 2, Type: char}}}, "typedef char (*t)[2];"},
+		{&Typedef{Name: "t", Type: &Pointer{Target: &Const{Type: char}}}, "typedef const char *t;"},
+		{&Typedef{Name: "t", Type: &Const{Type: &Pointer{Target: char}}}, "typedef char *const t;"},
+		{&Typedef{Name: "t", Type: &Pointer{Target: &Const{Type: &Array{Nelems: 2, Type: u32}}}}, "typedef const u32 (*t)[2];"},
+		{&Typedef{Name: "t", Type: &TypeTag{Value: "user", Type: &Pointer{Target: &Void{}}}}, "typedef void *t;"},
+		{
+			&Typedef{Name: "t", Type: &Pointer{Target: &FuncProto{
+				Return: s32,
+				Params: []FuncParam{{Name: "a", Type: u32}, {Type: &Pointer{Target: char}}},
+			}}},
+			"typedef int (*t)(u32 a, char *);",
+		},
+		{
+			&Func{Name: "f", Linkage: StaticFunc, Type: &FuncProto{Return: &Void{}}},
+			"static void f(void);",
+		},
+		{
+			&Func{Name: "f", Linkage: GlobalFunc, Type: &FuncProto{
+				Return: s32,
+				Params: []FuncParam{{Name: "fmt", Type: &Pointer{Target: char}}, {Type: &Void{}}},
+			}},
+			"int f(char *fmt, ...);",
+		},
+		{&Var{Name: "v", Type: &Volatile{Type: u32}, Linkage: ExternVar}, "extern volatile u32 v;"},
+		{
+			&Enum{Name: "e", Size: 4, Signed: true, Values: []EnumValue{{"A", 0}, {"B", math.MaxUint32}}},
+			"enum e {\n\tA = 0,\n\tB = -1,\n};",
+		},
+		{
+			&Enum{Name: "e", Size: 4, Values: []EnumValue{{"A", math.MaxUint32}}},
+			"enum e {\n\tA = 4294967295,\n};",
+		},
+		{
+			&Enum{Name: "e", Size: 8, Values: []EnumValue{{"A", math.MaxUint64}}},
+			"enum e {\n\tA = 18446744073709551615,\n};",
+		},
+		{
+			&Struct{
+				Name: "s",
+				Size: 16,
+				Members: []Member{
+					{Name: "a", Type: u32},
+					{Name: "b", Type: &Pointer{Target: &Struct{Name: "s"}}, Offset: 64},
+				},
+			},
+			"struct s {\n\tu32 a;\n\tstruct s *b;\n};",
+		},
+		{
+			&Struct{
+				Name: "padding",
+				Size: 24,
+				Members: []Member{
+					{Name: "a", Type: u32},
+					{Name: "b", Type: u32, Offset: 64},
+				},
+			},
+			"struct padding {\n\tu32 a;\n\tint: 32;\n\tu32 b;\n\tint: 32;\n\tlong: 64;\n};",
+		},
+		{
+			&Struct{
+				Name: "bitfield",
+				Size: 4,
+				Members: []Member{
+					{Name: "a", Type: u32, BitfieldSize: 3},
+					{Name: "b", Type: u32, Offset: 5, BitfieldSize: 27},
+				},
+			},
+			"struct bitfield {\n\tu32 a: 3;\n\tchar: 2;\n\tu32 b: 27;\n};",
+		},
+		{
+			&Struct{
+				Name: "packed",
+				Size: 5,
+				Members: []Member{
+					{Name: "a", Type: char},
+					{Name: "b", Type: u32, Offset: 8},
+				},
+			},
+			"struct packed {\n\tchar a;\n\tu32 b;\n} __attribute__((packed));",
+		},
+		{
+			&Struct{
+				Name: "nested",
+				Size: 8,
+				Members: []Member{
+					{Name: "a", Type: &Struct{
+						Size:    4,
+						Members: []Member{{Name: "b", Type: u32}},
+					}},
+					{Type: &Union{
+						Size: 4,
+						Members: []Member{
+							{Name: "c", Type: u32},
+							{Name: "d", Type: &Enum{Size: 4, Values: []EnumValue{{"X", 1}}}},
+						},
+					}, Offset: 32},
+				},
+			},
+			"struct nested {\n" +
+				"\tstruct {\n\t\tu32 b;\n\t} a;\n" +
+				"\tunion {\n\t\tu32 c;\n\t\tenum {\n\t\t\tX = 1,\n\t\t} d;\n\t};\n" +
+				"};",
+		},
+		{
+			&Struct{
+				Name: "nested_pointer",
+				Size: 8,
+				Members: []Member{
+					{Name: "p", Type: &Pointer{Target: &Const{Type: &Struct{
+						Size:    4,
+						Members: []Member{{Name: "x", Type: u32}},
+					}}}},
+				},
+			},
+			"struct nested_pointer {\n\tconst struct {\n\t\tu32 x;\n\t} *p;\n};",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.typ), func(t *testing.T) {
+			var cf CFormatter
+			have, err := cf.TypeDeclaration(test.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != test.output {
+				t.Errorf("Unexpected output:\n-%s\n+%s", test.output, have)
+			}
+		})
+	}
+}
+
+func TestCTypeDeclarationUnsupported(t *testing.T) {
+	var cf CFormatter
+	for _, typ := range []Type{
+		&Int{Name: "int", Size: 4},
+		&Pointer{Target: &Void{}},
+		&Datasec{Name: ".data"},
+	} {
+		if _, err := cf.TypeDeclaration(typ); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported for %s, got %v", typ, err)
+		}
+	}
+}
+
+func TestCTypeDeclarationCycle(t *testing.T) {
+	var s Struct
+	s.Members = []Member{{Name: "f", Type: &s}}
+
+	var cf CFormatter
+	_, err := cf.TypeDeclaration(&Typedef{Name: "t", Type: &s})
+	if !errors.Is(err, errNestedTooDeep) {
+		t.Fatal("Expected errNestedTooDeep, got", err)
+	}
+}
+
+func TestCTypeDeclarationVMLinux(t *testing.T) {
+	spec, err := LoadSpecFromReader(readVMLinux(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cf CFormatter
+	iter := spec.Iterate()
+	for iter.Next() {
+		switch iter.Type.(type) {
+		case *Struct, *Union, *Enum, *Typedef, *Func:
+		default:
+			continue
+		}
+
+		if iter.Type.TypeName() == "" {
+			continue
+		}
+
+		if _, err := cf.TypeDeclaration(iter.Type); err != nil {
+			t.Errorf("%s: %s", iter.Type, err)
+		}
+	}
+
+	iphdr, err := spec.AnyTypeByName("iphdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := cf.TypeDeclaration(iphdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(have, "struct iphdr {\n\t__u8 ihl: 4;\n\t__u8 version: 4;\n") {
+		t.Errorf("Unexpected declaration of iphdr:\n%s", have)
+	}
+	if !strings.HasSuffix(have, "\n};") {
+		t.Errorf("Declaration of iphdr isn't terminated:\n%s", have)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"go/format"
+	"math"
 	"strings"
 	"testing"
 )
@@ -25,6 +26,10 @@ func TestGoTypeDeclaration(t *testing.T) {
 		{&Typedef{Name: "frob", Type: &Int{Size: 8}}, "type t uint64"},
 		{&Int{Size: 16}, "type t uint128"},
 		{&Enum{Values: []EnumValue{{"FOO", 32}}}, "type t int32; const ( tFOO t = 32; )"},
+		{&Enum{Values: []EnumValue{{"FOO", math.MaxUint32}}}, "type t int32; const ( tFOO t = -1; )"},
+		{&Enum{Signed: true, Values: []EnumValue{{"FOO", math.MaxUint64}}}, "type t int32; const ( tFOO t = -1; )"},
+		{&Enum{Size: 8, Values: []EnumValue{{"FOO", math.MaxUint64}}}, "type t uint64; const ( tFOO t = 18446744073709551615; )"},
+		{&Enum{Size: 8, Signed: true, Values: []EnumValue{{"FOO", math.MaxUint64}}}, "type t int64; const ( tFOO t = -1; )"},
 		{&Array{Nelems: 2, Type: &Int{Size: 1}}, "type t [2]uint8"},
 		{
 			&Union{
@@ -0,0 +1,120 @@
+package btf
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// GoType returns a Type describing the memory layout of typ.
+//
+// Booleans, integers, floats, arrays and structs are supported. Struct
+// fields named _ are treated as padding and omitted. Named types which
+// aren't structs are described by a Typedef. Types containing pointers,
+// such as slices, maps or strings, return an error.
+func GoType(typ reflect.Type) (Type, error) {
+	return newGoTypeConverter().convert(typ)
+}
+
+type goTypeConverter struct {
+	// Types which have already been converted, so that a Go type used
+	// multiple times maps to the same Type.
+	types map[reflect.Type]Type
+	index Type
+}
+
+func newGoTypeConverter() *goTypeConverter {
+	return &goTypeConverter{
+		types: make(map[reflect.Type]Type),
+		index: &Int{Name: "__ARRAY_SIZE_TYPE__", Size: 4},
+	}
+}
+
+func (gc *goTypeConverter) convert(typ reflect.Type) (Type, error) {
+	if typ == nil {
+		return nil, fmt.Errorf("nil type")
+	}
+
+	if t, ok := gc.types[typ]; ok {
+		return t, nil
+	}
+
+	t, err := gc.convertUnnamed(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ.Kind() != reflect.Struct && typ.PkgPath() != "" && isIdentifier(typ.Name()) {
+		t = &Typedef{Name: typ.Name(), Type: t}
+	}
+
+	gc.types[typ] = t
+	return t, nil
+}
+
+func (gc *goTypeConverter) convertUnnamed(typ reflect.Type) (Type, error) {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &Int{Name: "bool", Size: 1, Encoding: Bool}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Int{Name: typ.Kind().String(), Size: uint32(typ.Size()), Encoding: Signed}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Int{Name: typ.Kind().String(), Size: uint32(typ.Size())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Float{Name: typ.Kind().String(), Size: uint32(typ.Size())}, nil
+
+	case reflect.Array:
+		elem, err := gc.convert(typ.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("array element: %w", err)
+		}
+		return &Array{Index: gc.index, Type: elem, Nelems: uint32(typ.Len())}, nil
+
+	case reflect.Struct:
+		s := &Struct{Size: uint32(typ.Size())}
+		if isIdentifier(typ.Name()) {
+			s.Name = typ.Name()
+		}
+
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Name == "_" {
+				continue
+			}
+
+			member, err := gc.convert(field.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+
+			s.Members = append(s.Members, Member{
+				Name:   field.Name,
+				Type:   member,
+				Offset: Bits(field.Offset * 8),
+			})
+		}
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("%s can't be described by BTF", typ)
+	}
+}
+
+// isIdentifier returns true if name is a valid C identifier.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package btf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+// NewSpecFromTypes creates a Spec which contains roots and all types they
+// refer to.
+//
+// The returned Spec uses the given Types as is, so they can be passed to
+// Spec.TypeID.
+func NewSpecFromTypes(roots ...Type) (*Spec, error) {
+	var b Builder
+	if err := b.add(roots); err != nil {
+		return nil, err
+	}
+
+	return b.Spec()
+}
+
+// Builder assigns IDs to Types, for example to construct type information
+// for programs or maps created at runtime.
+//
+// IDs are assigned in the order in which types are added, and stay valid for
+// all Specs returned by the Builder. The zero value is ready to use.
+type Builder struct {
+	// All added types, the first one is always Void.
+	types types
+	ids   map[Type]TypeID
+}
+
+// NewBuilder creates a Builder from a list of types.
+//
+// It is more efficient than calling Add individually.
+func NewBuilder(types []Type) (*Builder, error) {
+	b := new(Builder)
+	if err := b.add(types); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Add a Type and all types it refers to, and return its ID.
+//
+// Adding the same Type again returns the same ID. Void always has ID 0.
+func (b *Builder) Add(typ Type) (TypeID, error) {
+	if err := b.add([]Type{typ}); err != nil {
+		return 0, err
+	}
+
+	if _, ok := typ.(*Void); ok {
+		return 0, nil
+	}
+	return b.ids[typ], nil
+}
+
+func (b *Builder) add(roots []Type) error {
+	if b.ids == nil {
+		b.types = types{(*Void)(nil)}
+		b.ids = map[Type]TypeID{(*Void)(nil): 0}
+	}
+
+	var pending typeDeque
+	for i := range roots {
+		pending.push(&roots[i])
+	}
+
+	for !pending.empty() {
+		typ := *pending.shift()
+		if typ == nil {
+			return errors.New("nil type")
+		}
+
+		if _, ok := b.ids[typ]; ok {
+			continue
+		}
+
+		if _, ok := typ.(*Void); ok {
+			// All instances of Void share ID 0.
+			continue
+		}
+
+		b.ids[typ] = TypeID(len(b.types))
+		b.types = append(b.types, typ)
+		typ.walk(&pending)
+	}
+
+	return nil
+}
+
+// Spec returns a Spec containing all types added so far.
+//
+// The Spec uses the added Types as is, so they can be passed to
+// Spec.TypeID, MapSpec.Key and MapSpec.Value or used as program metadata,
+// see WithFuncMetadata.
+func (b *Builder) Spec() (*Spec, error) {
+	if b.ids == nil {
+		if err := b.add(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	all := make(types, len(b.types))
+	copy(all, b.types)
+
+	strings := newStringTableBuilder()
+	rawTypes := make([]rawType, 0, len(all)-1)
+	for _, typ := range all[1:] {
+		raw, err := marshalType(typ, b.ids, strings)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: %w", typ, err)
+		}
+		rawTypes = append(rawTypes, raw)
+	}
+
+	typeIDs, typesByName := indexTypes(all)
+
+	return &Spec{
+		rawTypes:   rawTypes,
+		namedTypes: typesByName,
+		typeIDs:    typeIDs,
+		types:      all,
+		strings:    &strings.table,
+		byteOrder:  internal.NativeEndian,
+	}, nil
+}
+
+// marshalType encodes typ, using ids to refer to other types.
+func marshalType(typ Type, ids map[Type]TypeID, strings *stringTableBuilder) (rawType, error) {
+	var raw rawType
+	raw.NameOff = strings.Add(typ.TypeName())
+
+	marshalMembers := func(members []Member) []btfMember {
+		raws := make([]btfMember, 0, len(members))
+		for _, m := range members {
+			offset := uint32(m.Offset)
+			if m.BitfieldSize > 0 {
+				raw.SetKindFlag(true)
+			}
+			raws = append(raws, btfMember{strings.Add(m.Name), ids[m.Type], offset})
+		}
+
+		if raw.KindFlag() {
+			// Bitfield sizes are encoded in the upper bits of the offset
+			// if the kind flag is set.
+			for i, m := range members {
+				raws[i].Offset |= uint32(m.BitfieldSize) << 24
+			}
+		}
+		return raws
+	}
+
+	switch v := typ.(type) {
+	case *Int:
+		if v.Size > 16 {
+			return rawType{}, fmt.Errorf("invalid size %d", v.Size)
+		}
+		raw.SetKind(kindInt)
+		raw.SetSize(v.Size)
+		data := new(btfInt)
+		data.SetEncoding(v.Encoding)
+		data.SetBits(byte(v.Size * 8))
+		raw.data = data
+
+	case *Float:
+		raw.SetKind(kindFloat)
+		raw.SetSize(v.Size)
+
+	case *Pointer:
+		raw.SetKind(kindPointer)
+		raw.SizeType = uint32(ids[v.Target])
+
+	case *Array:
+		raw.SetKind(kindArray)
+		raw.data = &btfArray{ids[v.Type], ids[v.Index], v.Nelems}
+
+	case *Struct:
+		raw.SetKind(kindStruct)
+		raw.SetSize(v.Size)
+		raw.SetVlen(len(v.Members))
+		raw.data = marshalMembers(v.Members)
+
+	case *Union:
+		raw.SetKind(kindUnion)
+		raw.SetSize(v.Size)
+		raw.SetVlen(len(v.Members))
+		raw.data = marshalMembers(v.Members)
+
+	case *Enum:
+		raw.SetSize(v.size())
+		raw.SetVlen(len(v.Values))
+		raw.SetKindFlag(v.Signed)
+
+		if v.size() == 8 || !enumFitsInt32(v) {
+			raw.SetKind(kindEnum64)
+			values := make([]btfEnum64, 0, len(v.Values))
+			for _, value := range v.Values {
+				values = append(values, btfEnum64{
+					strings.Add(value.Name),
+					uint32(value.Value),
+					uint32(value.Value >> 32),
+				})
+			}
+			raw.data = values
+			break
+		}
+
+		raw.SetKind(kindEnum)
+		values := make([]btfEnum, 0, len(v.Values))
+		for _, value := range v.Values {
+			values = append(values, btfEnum{strings.Add(value.Name), uint32(value.Value)})
+		}
+		raw.data = values
+
+	case *Fwd:
+		raw.SetKind(kindForward)
+		raw.SetKindFlag(v.Kind == FwdUnion)
+
+	case *Typedef:
+		raw.SetKind(kindTypedef)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *Volatile:
+		raw.SetKind(kindVolatile)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *Const:
+		raw.SetKind(kindConst)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *Restrict:
+		raw.SetKind(kindRestrict)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *DeclTag:
+		if v.Index < -1 || v.Index > math.MaxInt32 {
+			return rawType{}, fmt.Errorf("invalid index %d", v.Index)
+		}
+		raw.SetKind(kindDeclTag)
+		raw.NameOff = strings.Add(v.Value)
+		raw.SizeType = uint32(ids[v.Type])
+		raw.data = &btfDeclTag{int32(v.Index)}
+
+	case *TypeTag:
+		raw.SetKind(kindTypeTag)
+		raw.NameOff = strings.Add(v.Value)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *Func:
+		raw.SetKind(kindFunc)
+		raw.SetLinkage(v.Linkage)
+		raw.SizeType = uint32(ids[v.Type])
+
+	case *FuncProto:
+		raw.SetKind(kindFuncProto)
+		raw.SetVlen(len(v.Params))
+		raw.SizeType = uint32(ids[v.Return])
+		params := make([]btfParam, 0, len(v.Params))
+		for _, param := range v.Params {
+			params = append(params, btfParam{strings.Add(param.Name), ids[param.Type]})
+		}
+		raw.data = params
+
+	case *Var:
+		raw.SetKind(kindVar)
+		raw.SizeType = uint32(ids[v.Type])
+		raw.data = &btfVariable{uint32(v.Linkage)}
+
+	case *Datasec:
+		raw.SetKind(kindDatasec)
+		raw.SetSize(v.Size)
+		raw.SetVlen(len(v.Vars))
+		secinfos := make([]btfVarSecinfo, 0, len(v.Vars))
+		for _, vsi := range v.Vars {
+			secinfos = append(secinfos, btfVarSecinfo{ids[vsi.Type], vsi.Offset, vsi.Size})
+		}
+		raw.data = secinfos
+
+	default:
+		return rawType{}, fmt.Errorf("can't marshal %T: %w", typ, ErrNotSupported)
+	}
+
+	return raw, nil
+}
+
+// enumFitsInt32 returns true if all values of e can be encoded by a 32 bit
+// enum.
+func enumFitsInt32(e *Enum) bool {
+	for _, value := range e.Values {
+		if e.Signed && int64(value.Value) != int64(int32(value.Value)) ||
+			!e.Signed && value.Value > math.MaxUint32 {
+			return false
+		}
+	}
+	return true
+}
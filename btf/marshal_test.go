@@ -0,0 +1,210 @@
+package btf
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewSpecFromTypes(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	value := &Struct{
+		Name: "value",
+		Size: 16,
+		Members: []Member{
+			{Name: "a", Type: u32},
+			{Name: "b", Type: &Array{Index: u32, Type: &Int{Name: "char", Size: 1, Encoding: Char}, Nelems: 4}, Offset: 32},
+			{Name: "c", Type: &Enum{Name: "e", Signed: true, Values: []EnumValue{{"neg", math.MaxUint64}}}, Offset: 64},
+			{Name: "d", Type: &Typedef{Name: "flags", Type: u32}, Offset: 96, BitfieldSize: 3},
+		},
+	}
+
+	spec, err := NewSpecFromTypes(u32, value)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, typ := range []Type{u32, value} {
+		_, err := spec.TypeID(typ)
+		qt.Assert(t, err, qt.IsNil, qt.Commentf("%s", typ))
+	}
+
+	raw, err := spec.marshal(marshalOpts{ByteOrder: internal.NativeEndian})
+	qt.Assert(t, err, qt.IsNil)
+
+	decoded, err := loadRawSpec(bytes.NewReader(raw), internal.NativeEndian)
+	qt.Assert(t, err, qt.IsNil)
+
+	var have *Struct
+	qt.Assert(t, decoded.TypeByName("value", &have), qt.IsNil)
+	qt.Assert(t, have, qt.CmpEquals(cmp.Comparer(func(a, b Type) bool {
+		return a.TypeName() == b.TypeName()
+	})), value)
+}
+
+func TestMarshalEnum64(t *testing.T) {
+	enums := []*Enum{
+		{Name: "u64", Size: 8, Values: []EnumValue{{"A", 1}, {"B", math.MaxUint64}}},
+		{Name: "s64", Size: 8, Signed: true, Values: []EnumValue{{"A", math.MaxUint64}}},
+		{Name: "wide", Size: 4, Values: []EnumValue{{"A", math.MaxUint32 + 1}}},
+		{Name: "u32", Size: 4, Values: []EnumValue{{"A", math.MaxUint32}}},
+	}
+
+	var roots []Type
+	for _, e := range enums {
+		roots = append(roots, e)
+	}
+
+	spec, err := NewSpecFromTypes(roots...)
+	qt.Assert(t, err, qt.IsNil)
+
+	raw, err := spec.marshal(marshalOpts{ByteOrder: internal.NativeEndian})
+	qt.Assert(t, err, qt.IsNil)
+
+	decoded, err := loadRawSpec(bytes.NewReader(raw), internal.NativeEndian)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, want := range enums {
+		var have *Enum
+		qt.Assert(t, decoded.TypeByName(want.Name, &have), qt.IsNil)
+		qt.Assert(t, have, qt.DeepEquals, want)
+	}
+}
+
+func TestNewSpecFromTypesFuncsAndVars(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	fn := &Func{
+		Name: "fn",
+		Type: &FuncProto{
+			Return: u32,
+			Params: []FuncParam{{Name: "a", Type: u32}},
+		},
+		Linkage: GlobalFunc,
+	}
+	v := &Var{Name: "v", Type: u32, Linkage: GlobalVar}
+	ds := &Datasec{Name: ".data", Size: 8, Vars: []VarSecinfo{{Type: v, Offset: 4, Size: 4}}}
+
+	spec, err := NewSpecFromTypes(fn, ds)
+	qt.Assert(t, err, qt.IsNil)
+
+	raw, err := spec.marshal(marshalOpts{ByteOrder: internal.NativeEndian})
+	qt.Assert(t, err, qt.IsNil)
+
+	decoded, err := loadRawSpec(bytes.NewReader(raw), internal.NativeEndian)
+	qt.Assert(t, err, qt.IsNil)
+
+	var haveFn *Func
+	qt.Assert(t, decoded.TypeByName("fn", &haveFn), qt.IsNil)
+	qt.Assert(t, haveFn.Linkage, qt.Equals, GlobalFunc)
+	proto := haveFn.Type.(*FuncProto)
+	qt.Assert(t, proto.Params, qt.HasLen, 1)
+	qt.Assert(t, proto.Params[0].Name, qt.Equals, "a")
+
+	var haveDs *Datasec
+	qt.Assert(t, decoded.TypeByName(".data", &haveDs), qt.IsNil)
+	qt.Assert(t, haveDs.Size, qt.Equals, uint32(8))
+	qt.Assert(t, haveDs.Vars, qt.HasLen, 1)
+	qt.Assert(t, haveDs.Vars[0].Offset, qt.Equals, uint32(4))
+	qt.Assert(t, haveDs.Vars[0].Type.TypeName(), qt.Equals, "v")
+
+	h, err := NewHandle(spec)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	h.Close()
+}
+
+func TestGoType(t *testing.T) {
+	type flags uint16
+	type value struct {
+		A uint32
+		_ uint32
+		B [2]flags
+		C int64
+		D bool
+		E float32
+	}
+
+	typ, err := GoType(reflect.TypeOf(value{}))
+	qt.Assert(t, err, qt.IsNil)
+
+	s, ok := typ.(*Struct)
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, s.Name, qt.Equals, "value")
+	qt.Assert(t, s.Size, qt.Equals, uint32(32))
+	qt.Assert(t, s.Members, qt.HasLen, 5)
+
+	size, err := Sizeof(s)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, 32)
+
+	b := s.Members[1]
+	qt.Assert(t, b.Name, qt.Equals, "B")
+	qt.Assert(t, b.Offset.Bytes(), qt.Equals, uint32(8))
+	arr := b.Type.(*Array)
+	qt.Assert(t, arr.Nelems, qt.Equals, uint32(2))
+	qt.Assert(t, arr.Type.TypeName(), qt.Equals, "flags")
+
+	_, err = NewSpecFromTypes(typ)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, v := range []interface{}{"", []byte{}, &value{}, map[int]int{}} {
+		_, err := GoType(reflect.TypeOf(v))
+		qt.Assert(t, err, qt.IsNotNil, qt.Commentf("%T", v))
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	u32 := &Int{Name: "u32", Size: 4}
+	ptr := &Pointer{Target: u32}
+
+	var b Builder
+	id, err := b.Add(ptr)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(1))
+
+	id, err = b.Add(u32)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(2), qt.Commentf("dependencies should be added"))
+
+	id, err = b.Add(ptr)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(1), qt.Commentf("adding twice should return the same ID"))
+
+	id, err = b.Add(&Void{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(0))
+
+	spec, err := b.Spec()
+	qt.Assert(t, err, qt.IsNil)
+
+	td := &Typedef{Name: "td", Type: ptr}
+	id, err = b.Add(td)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(3))
+
+	// Specs aren't affected by later additions.
+	_, err = spec.TypeID(td)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	spec, err = b.Spec()
+	qt.Assert(t, err, qt.IsNil)
+	for typ, want := range map[Type]TypeID{ptr: 1, u32: 2, td: 3} {
+		have, err := spec.TypeID(typ)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, have, qt.Equals, want)
+	}
+
+	_, err = b.Add(nil)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	b2, err := NewBuilder([]Type{td})
+	qt.Assert(t, err, qt.IsNil)
+	id, err = b2.Add(u32)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Equals, TypeID(3))
+}
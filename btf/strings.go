@@ -110,3 +110,32 @@ func search(ints []uint32, needle uint32) int {
 	// i == j, f(i-1) == false, and f(j) (= f(i)) == true  =>  answer is i.
 	return i
 }
+
+// stringTableBuilder creates a stringTable from strings which are added one
+// at a time. Duplicate strings are only stored once.
+type stringTableBuilder struct {
+	table   stringTable
+	offsets map[string]uint32
+	length  uint32
+}
+
+func newStringTableBuilder() *stringTableBuilder {
+	stb := &stringTableBuilder{offsets: make(map[string]uint32)}
+	// The first string must be empty.
+	stb.Add("")
+	return stb
+}
+
+// Add a string to the table and return its offset.
+func (stb *stringTableBuilder) Add(str string) uint32 {
+	if offset, ok := stb.offsets[str]; ok {
+		return offset
+	}
+
+	offset := stb.length
+	stb.table.offsets = append(stb.table.offsets, offset)
+	stb.table.strings = append(stb.table.strings, str)
+	stb.offsets[str] = offset
+	stb.length += uint32(len(str)) + 1
+	return offset
+}
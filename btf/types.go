@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/cilium/ebpf/asm"
@@ -51,6 +52,8 @@ var (
 	_ Type = (*Var)(nil)
 	_ Type = (*Datasec)(nil)
 	_ Type = (*Float)(nil)
+	_ Type = (*DeclTag)(nil)
+	_ Type = (*TypeTag)(nil)
 )
 
 // types is a list of Type.
@@ -271,7 +274,11 @@ type Member struct {
 
 // Enum lists possible values.
 type Enum struct {
-	Name   string
+	Name string
+	// Size of the enum value in bytes. Assumed to be 4 if zero.
+	Size uint32
+	// True if the values should be interpreted as signed integers.
+	Signed bool
 	Values []EnumValue
 }
 
@@ -285,11 +292,18 @@ func (e *Enum) TypeName() string { return e.Name }
 //
 // Is is not a valid Type
 type EnumValue struct {
-	Name  string
-	Value int32
+	Name string
+	// Value is sign extended to 64 bits if the Enum is signed.
+	Value uint64
+}
+
+func (e *Enum) size() uint32 {
+	if e.Size == 0 {
+		return 4
+	}
+	return e.Size
 }
 
-func (e *Enum) size() uint32    { return 4 }
 func (e *Enum) walk(*typeDeque) {}
 func (e *Enum) copy() Type {
 	cpy := *e
@@ -415,11 +429,21 @@ type Func struct {
 	Linkage FuncLinkage
 }
 
+// FuncMetadata returns the Func associated with an instruction, or nil.
 func FuncMetadata(ins *asm.Instruction) *Func {
 	fn, _ := ins.Metadata.Get(funcInfoMeta{}).(*Func)
 	return fn
 }
 
+// WithFuncMetadata associates fn with the first instruction of a function.
+//
+// The Func must be part of the program's BTF, so it is included in the
+// func_info passed to the kernel.
+func WithFuncMetadata(ins asm.Instruction, fn *Func) asm.Instruction {
+	ins.Metadata.Set(funcInfoMeta{}, fn)
+	return ins
+}
+
 func (f *Func) Format(fs fmt.State, verb rune) {
 	formatType(fs, verb, f, f.Linkage, "proto=", f.Type)
 }
@@ -512,6 +536,9 @@ func (ds *Datasec) copy() Type {
 
 // VarSecinfo describes variable in a Datasec.
 //
+// Type is a *Var, except in the .ksyms section which may also contain
+// *Func for kernel functions.
+//
 // It is not a valid Type.
 type VarSecinfo struct {
 	Type   Type
@@ -539,6 +566,45 @@ func (f *Float) copy() Type {
 	return &cpy
 }
 
+// DeclTag associates metadata with a declaration.
+type DeclTag struct {
+	Type  Type
+	Value string
+	// The index this tag refers to in the target type. For composite types,
+	// a value of -1 indicates that the tag refers to the whole type. Otherwise
+	// it indicates which member or argument the tag applies to.
+	Index int
+}
+
+func (dt *DeclTag) Format(fs fmt.State, verb rune) {
+	formatType(fs, verb, dt, "type=", dt.Type, "value=", strconv.Quote(dt.Value), "index=", dt.Index)
+}
+
+func (dt *DeclTag) TypeName() string    { return "" }
+func (dt *DeclTag) walk(tdq *typeDeque) { tdq.push(&dt.Type) }
+func (dt *DeclTag) copy() Type {
+	cpy := *dt
+	return &cpy
+}
+
+// TypeTag associates metadata with a type. It is a qualifier.
+type TypeTag struct {
+	Type  Type
+	Value string
+}
+
+func (tt *TypeTag) Format(fs fmt.State, verb rune) {
+	formatType(fs, verb, tt, "type=", tt.Type, "value=", strconv.Quote(tt.Value))
+}
+
+func (tt *TypeTag) TypeName() string    { return "" }
+func (tt *TypeTag) qualify() Type       { return tt.Type }
+func (tt *TypeTag) walk(tdq *typeDeque) { tdq.push(&tt.Type) }
+func (tt *TypeTag) copy() Type {
+	cpy := *tt
+	return &cpy
+}
+
 // cycle is a type which had to be elided since it exceeded maxTypeDepth.
 type cycle struct {
 	root Type
@@ -574,6 +640,7 @@ var (
 	_ qualifier = (*Const)(nil)
 	_ qualifier = (*Restrict)(nil)
 	_ qualifier = (*Volatile)(nil)
+	_ qualifier = (*TypeTag)(nil)
 )
 
 // Sizeof returns the size of a type in bytes.
@@ -630,13 +697,16 @@ func Sizeof(typ Type) (int, error) {
 
 // alignof returns the alignment of a type.
 //
-// Currently only supports the subset of types necessary for bitfield relocations.
+// Currently only supports the subset of types necessary for bitfield relocations
+// and kconfig externs.
 func alignof(typ Type) (int, error) {
 	switch t := UnderlyingType(typ).(type) {
 	case *Enum:
 		return int(t.size()), nil
 	case *Int:
 		return int(t.Size), nil
+	case *Array:
+		return alignof(t.Type)
 	default:
 		return 0, fmt.Errorf("can't calculate alignment of %T", t)
 	}
@@ -936,12 +1006,32 @@ func inflateRawTypes(rawTypes []rawType, rawStrings *stringTable) ([]Type, error
 				if err != nil {
 					return nil, fmt.Errorf("get name for enum value %d: %s", i, err)
 				}
+				value := uint64(btfVal.Val)
+				if raw.KindFlag() {
+					// Sign extend values to 64 bit.
+					value = uint64(int32(btfVal.Val))
+				}
+				vals = append(vals, EnumValue{
+					Name:  name,
+					Value: value,
+				})
+			}
+			typ = &Enum{name, raw.Size(), raw.KindFlag(), vals}
+
+		case kindEnum64:
+			rawvals := raw.data.([]btfEnum64)
+			vals := make([]EnumValue, 0, len(rawvals))
+			for i, btfVal := range rawvals {
+				name, err := rawStrings.Lookup(btfVal.NameOff)
+				if err != nil {
+					return nil, fmt.Errorf("get name for enum64 value %d: %s", i, err)
+				}
 				vals = append(vals, EnumValue{
 					Name:  name,
-					Value: btfVal.Val,
+					Value: uint64(btfVal.ValHi32)<<32 | uint64(btfVal.ValLo32),
 				})
 			}
-			typ = &Enum{name, vals}
+			typ = &Enum{name, raw.Size(), raw.KindFlag(), vals}
 
 		case kindForward:
 			if raw.KindFlag() {
@@ -1015,6 +1105,10 @@ func inflateRawTypes(rawTypes []rawType, rawStrings *stringTable) ([]Type, error
 			}
 			for i := range vars {
 				fixup(btfVars[i].Type, &vars[i].Type)
+				if name == ".ksyms" {
+					// Contains extern functions as well as variables.
+					continue
+				}
 				if err := assert(&vars[i].Type, reflect.TypeOf((*Var)(nil))); err != nil {
 					return nil, err
 				}
@@ -1024,6 +1118,21 @@ func inflateRawTypes(rawTypes []rawType, rawStrings *stringTable) ([]Type, error
 		case kindFloat:
 			typ = &Float{name, raw.Size()}
 
+		case kindDeclTag:
+			btfIndex := raw.data.(*btfDeclTag).ComponentIdx
+			if btfIndex < -1 {
+				return nil, fmt.Errorf("type id %d: invalid decl tag index %d", id, btfIndex)
+			}
+
+			dt := &DeclTag{nil, name, int(btfIndex)}
+			fixup(raw.Type(), &dt.Type)
+			typ = dt
+
+		case kindTypeTag:
+			tt := &TypeTag{nil, name}
+			fixup(raw.Type(), &tt.Type)
+			typ = tt
+
 		default:
 			return nil, fmt.Errorf("type id %d: unknown kind: %v", id, raw.Kind())
 		}
@@ -1,10 +1,14 @@
 package btf
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
+	"github.com/cilium/ebpf/internal"
+
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
 )
@@ -121,6 +125,8 @@ func TestType(t *testing.T) {
 		func() Type { return &Volatile{Type: &Void{}} },
 		func() Type { return &Const{Type: &Void{}} },
 		func() Type { return &Restrict{Type: &Void{}} },
+		func() Type { return &DeclTag{Type: &Void{}, Value: "tag"} },
+		func() Type { return &TypeTag{Type: &Void{}, Value: "tag"} },
 		func() Type { return &Func{Name: "foo", Type: &Void{}} },
 		func() Type {
 			return &FuncProto{
@@ -411,6 +417,96 @@ func TestInflateLegacyBitfield(t *testing.T) {
 	}
 }
 
+func TestInflateEnum(t *testing.T) {
+	var signed rawType
+	signed.SetKind(kindEnum)
+	signed.SetVlen(1)
+	signed.SetSize(4)
+	signed.Info |= 1 << btfTypeKindFlagShift
+	signed.data = []btfEnum{{Val: 0xffffffff}}
+
+	unsigned := signed
+	unsigned.Info &^= 1 << btfTypeKindFlagShift
+
+	var enum64 rawType
+	enum64.SetKind(kindEnum64)
+	enum64.SetVlen(1)
+	enum64.SetSize(8)
+	enum64.data = []btfEnum64{{ValLo32: 1, ValHi32: 2}}
+
+	// Round trip the types to exercise readTypes.
+	var buf bytes.Buffer
+	for _, raw := range []rawType{signed, unsigned, enum64} {
+		qt.Assert(t, raw.Marshal(&buf, internal.NativeEndian), qt.IsNil)
+	}
+
+	raw, err := readTypes(&buf, internal.NativeEndian, uint32(buf.Len()))
+	qt.Assert(t, err, qt.IsNil)
+
+	types, err := inflateRawTypes(raw, newStringTable(""))
+	qt.Assert(t, err, qt.IsNil)
+
+	want := []Type{
+		(*Void)(nil),
+		&Enum{Size: 4, Signed: true, Values: []EnumValue{{"", math.MaxUint64}}},
+		&Enum{Size: 4, Values: []EnumValue{{"", math.MaxUint32}}},
+		&Enum{Size: 8, Values: []EnumValue{{"", 2<<32 | 1}}},
+	}
+	qt.Assert(t, types, qt.DeepEquals, want)
+
+	size, err := Sizeof(types[3])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, 8)
+}
+
+func TestInflateTags(t *testing.T) {
+	var integer rawType
+	integer.SetKind(kindInt)
+	integer.SetSize(4)
+	integer.data = new(btfInt)
+
+	var typeTag rawType
+	typeTag.NameOff = 1
+	typeTag.SetKind(kindTypeTag)
+	typeTag.SizeType = 1
+
+	var declTag rawType
+	declTag.NameOff = 5
+	declTag.SetKind(kindDeclTag)
+	declTag.SizeType = 2
+	declTag.data = &btfDeclTag{-1}
+
+	// Round trip the types to exercise readTypes.
+	var buf bytes.Buffer
+	for _, raw := range []rawType{integer, typeTag, declTag} {
+		qt.Assert(t, raw.Marshal(&buf, internal.NativeEndian), qt.IsNil)
+	}
+
+	raw, err := readTypes(&buf, internal.NativeEndian, uint32(buf.Len()))
+	qt.Assert(t, err, qt.IsNil)
+
+	types, err := inflateRawTypes(raw, newStringTable("", "foo", "bar"))
+	qt.Assert(t, err, qt.IsNil)
+
+	qt.Assert(t, types, qt.HasLen, 4)
+	tt, ok := types[2].(*TypeTag)
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, tt.Value, qt.Equals, "foo")
+	qt.Assert(t, tt.Type, qt.Equals, types[1])
+
+	dt, ok := types[3].(*DeclTag)
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, dt.Value, qt.Equals, "bar")
+	qt.Assert(t, dt.Index, qt.Equals, -1)
+	qt.Assert(t, dt.Type, qt.Equals, Type(tt))
+
+	// Type tags are qualifiers.
+	size, err := Sizeof(tt)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, 4)
+	qt.Assert(t, UnderlyingType(tt), qt.Equals, types[1])
+}
+
 func BenchmarkUnderlyingType(b *testing.B) {
 	b.Run("no unwrapping", func(b *testing.B) {
 		v := &Int{}
@@ -0,0 +1,526 @@
+// Package cbpf converts classic BPF socket filters to eBPF.
+//
+// This allows reusing filters generated by tools like tcpdump with
+// SocketFilter programs, which can be combined with maps and helpers.
+package cbpf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// Instruction is a classic BPF instruction.
+//
+// It has the same layout as struct sock_filter, and as RawInstruction in
+// golang.org/x/net/bpf.
+type Instruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+func (ins Instruction) String() string {
+	return fmt.Sprintf("{ %#04x, %d, %d, %#08x }", ins.Op, ins.Jt, ins.Jf, ins.K)
+}
+
+// Classic BPF opcodes from linux/filter.h.
+const (
+	classLd   = 0x00
+	classLdx  = 0x01
+	classSt   = 0x02
+	classStx  = 0x03
+	classAlu  = 0x04
+	classJmp  = 0x05
+	classRet  = 0x06
+	classMisc = 0x07
+
+	sizeW = 0x00
+	sizeH = 0x08
+	sizeB = 0x10
+
+	modeImm = 0x00
+	modeAbs = 0x20
+	modeInd = 0x40
+	modeMem = 0x60
+	modeLen = 0x80
+	modeMsh = 0xa0
+
+	srcK = 0x00
+	srcX = 0x08
+
+	aluAdd = 0x00
+	aluSub = 0x10
+	aluMul = 0x20
+	aluDiv = 0x30
+	aluOr  = 0x40
+	aluAnd = 0x50
+	aluLsh = 0x60
+	aluRsh = 0x70
+	aluNeg = 0x80
+	aluMod = 0x90
+	aluXor = 0xa0
+
+	jmpJa   = 0x00
+	jmpJeq  = 0x10
+	jmpJgt  = 0x20
+	jmpJge  = 0x30
+	jmpJset = 0x40
+
+	retK = 0x00
+	retX = 0x08
+	retA = 0x10
+
+	miscTax = 0x00
+	miscTxa = 0x80
+
+	// Offset of ancillary data loads, which have no equivalent in eBPF.
+	skfAdOff = 0xfffff000
+
+	// Number of scratch memory slots.
+	memWords = 16
+
+	// Maximum length of a classic BPF program.
+	maxInsns = 4096
+)
+
+// Registers used to hold the classic BPF machine state. LdAbsMode and
+// LdIndMode loads implicitly use R6 as context and clobber R0 to R5.
+const (
+	regA   = asm.R0
+	regX   = asm.R7
+	regCtx = asm.R6
+	// Preserves A across loads.
+	regTmp = asm.R8
+	// Holds constants which can't be encoded as a sign extended immediate.
+	regK = asm.R2
+)
+
+// ToEBPF converts a classic BPF filter to eBPF.
+//
+// The result can be loaded as a SocketFilter or SchedCLS program and has
+// the same return value as the classic filter: the number of bytes of
+// the packet to keep. As with classic filters, out of bounds packet
+// accesses and divisions by zero terminate the program with a return value
+// of zero.
+//
+// Loads of ancillary data (offsets from SKF_AD_OFF) are not supported.
+func ToEBPF(filter []Instruction) (asm.Instructions, error) {
+	if len(filter) == 0 {
+		return nil, errors.New("empty filter")
+	}
+	if len(filter) > maxInsns {
+		return nil, fmt.Errorf("filter exceeds %d instructions", maxInsns)
+	}
+
+	targets, loadedMem, err := analyze(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(regCtx, asm.R1),
+		asm.Mov.Imm32(regA, 0),
+		asm.Mov.Imm32(regX, 0),
+	}
+
+	// The verifier rejects reads from uninitialised stack slots, while
+	// classic scratch memory reads as zero.
+	for k := uint32(0); k < memWords; k++ {
+		if loadedMem[k] {
+			insns = append(insns, asm.StoreImm(asm.RFP, memOffset(k), 0, asm.Word))
+		}
+	}
+
+	for i, ins := range filter {
+		block, err := convert(i, ins)
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d: %w", i, err)
+		}
+
+		if targets[i] {
+			block[0] = block[0].WithSymbol(label(i))
+		}
+
+		insns = append(insns, block...)
+	}
+
+	return insns, nil
+}
+
+// NewSocketFilter converts a classic BPF filter and loads it as a
+// SocketFilter program.
+//
+// The program can be attached using link.AttachSocketFilter.
+func NewSocketFilter(filter []Instruction) (*ebpf.Program, error) {
+	insns, err := ToEBPF(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "cbpf_filter",
+		Type:         ebpf.SocketFilter,
+		License:      "GPL",
+		Instructions: insns,
+	})
+}
+
+// analyze validates the control flow of filter.
+//
+// Returns the instructions which are the target of a jump, and the scratch
+// memory slots which are read.
+func analyze(filter []Instruction) (targets map[int]bool, loadedMem map[uint32]bool, err error) {
+	targets = make(map[int]bool)
+	loadedMem = make(map[uint32]bool)
+
+	for i, ins := range filter {
+		switch ins.Op & 0x07 {
+		case classJmp:
+			var next []int
+			if ins.Op&0xf0 == jmpJa {
+				if uint64(ins.K) >= uint64(len(filter)-i-1) {
+					return nil, nil, fmt.Errorf("instruction %d: jump out of bounds", i)
+				}
+				next = []int{i + 1 + int(ins.K)}
+			} else {
+				next = []int{i + 1 + int(ins.Jt), i + 1 + int(ins.Jf)}
+			}
+
+			for _, target := range next {
+				if target >= len(filter) {
+					return nil, nil, fmt.Errorf("instruction %d: jump out of bounds", i)
+				}
+				targets[target] = true
+			}
+
+		case classLd, classLdx:
+			if ins.Op&0xe0 == modeMem {
+				if ins.K >= memWords {
+					return nil, nil, fmt.Errorf("instruction %d: invalid scratch memory slot %d", i, ins.K)
+				}
+				loadedMem[ins.K] = true
+			}
+		}
+	}
+
+	if last := filter[len(filter)-1]; last.Op&0x07 != classRet {
+		return nil, nil, errors.New("filter doesn't end in a return")
+	}
+
+	return targets, loadedMem, nil
+}
+
+// convert translates a single classic instruction.
+//
+// The result always contains at least one instruction.
+func convert(i int, ins Instruction) (asm.Instructions, error) {
+	switch ins.Op & 0x07 {
+	case classLd:
+		return convertLoad(ins, regA)
+
+	case classLdx:
+		if ins.Op&0xe0 == modeMsh {
+			if ins.Op&0x18 != sizeB {
+				return nil, errors.New("invalid size for MSH load")
+			}
+			if ins.K >= skfAdOff {
+				return nil, errors.New("ancillary data loads are not supported")
+			}
+
+			// X = 4 * (P[K] & 0xf)
+			return asm.Instructions{
+				asm.Mov.Reg(regTmp, regA),
+				asm.LoadAbs(int32(ins.K), asm.Byte),
+				asm.And.Imm32(asm.R0, 0xf),
+				asm.LSh.Imm32(asm.R0, 2),
+				asm.Mov.Reg32(regX, asm.R0),
+				asm.Mov.Reg(regA, regTmp),
+			}, nil
+		}
+		return convertLoad(ins, regX)
+
+	case classSt, classStx:
+		if ins.K >= memWords {
+			return nil, fmt.Errorf("invalid scratch memory slot %d", ins.K)
+		}
+
+		src := regA
+		if ins.Op&0x07 == classStx {
+			src = regX
+		}
+		return asm.Instructions{
+			asm.StoreMem(asm.RFP, memOffset(ins.K), src, asm.Word),
+		}, nil
+
+	case classAlu:
+		return convertALU(i, ins)
+
+	case classJmp:
+		return convertJump(i, ins)
+
+	case classRet:
+		switch ins.Op & 0x18 {
+		case retK:
+			return asm.Instructions{
+				asm.Mov.Imm32(asm.R0, int32(ins.K)),
+				asm.Return(),
+			}, nil
+		case retX:
+			return asm.Instructions{
+				asm.Mov.Reg32(asm.R0, regX),
+				asm.Return(),
+			}, nil
+		case retA:
+			return asm.Instructions{
+				asm.Return(),
+			}, nil
+		}
+
+	case classMisc:
+		switch ins.Op & 0xf8 {
+		case miscTax:
+			return asm.Instructions{asm.Mov.Reg32(regX, regA)}, nil
+		case miscTxa:
+			return asm.Instructions{asm.Mov.Reg32(regA, regX)}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported opcode %#04x", ins.Op)
+}
+
+func convertLoad(ins Instruction, dst asm.Register) (asm.Instructions, error) {
+	mode := ins.Op & 0xe0
+	size := ins.Op & 0x18
+
+	switch mode {
+	case modeImm:
+		return asm.Instructions{asm.Mov.Imm32(dst, int32(ins.K))}, nil
+
+	case modeMem:
+		if ins.K >= memWords {
+			return nil, fmt.Errorf("invalid scratch memory slot %d", ins.K)
+		}
+		return asm.Instructions{asm.LoadMem(dst, asm.RFP, memOffset(ins.K), asm.Word)}, nil
+
+	case modeLen:
+		// The first field of struct __sk_buff is len.
+		return asm.Instructions{asm.LoadMem(dst, regCtx, 0, asm.Word)}, nil
+
+	case modeAbs, modeInd:
+		if dst != regA {
+			break
+		}
+
+		var asmSize asm.Size
+		switch size {
+		case sizeW:
+			asmSize = asm.Word
+		case sizeH:
+			asmSize = asm.Half
+		case sizeB:
+			asmSize = asm.Byte
+		default:
+			return nil, fmt.Errorf("invalid load size %#x", size)
+		}
+
+		if mode == modeAbs {
+			if ins.K >= skfAdOff {
+				return nil, errors.New("ancillary data loads are not supported")
+			}
+			return asm.Instructions{asm.LoadAbs(int32(ins.K), asmSize)}, nil
+		}
+		return asm.Instructions{asm.LoadInd(asm.R0, regX, int32(ins.K), asmSize)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported opcode %#04x", ins.Op)
+}
+
+func convertALU(i int, ins Instruction) (asm.Instructions, error) {
+	var op asm.ALUOp
+	switch ins.Op & 0xf0 {
+	case aluAdd:
+		op = asm.Add
+	case aluSub:
+		op = asm.Sub
+	case aluMul:
+		op = asm.Mul
+	case aluDiv:
+		op = asm.Div
+	case aluOr:
+		op = asm.Or
+	case aluAnd:
+		op = asm.And
+	case aluLsh:
+		op = asm.LSh
+	case aluRsh:
+		op = asm.RSh
+	case aluMod:
+		op = asm.Mod
+	case aluXor:
+		op = asm.Xor
+	case aluNeg:
+		return asm.Instructions{asm.Neg.Imm32(regA, 0)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported opcode %#04x", ins.Op)
+	}
+
+	if ins.Op&srcX == srcK {
+		switch {
+		case (op == asm.Div || op == asm.Mod) && ins.K == 0:
+			return nil, errors.New("division by zero")
+		case (op == asm.LSh || op == asm.RSh) && ins.K >= 32:
+			return nil, fmt.Errorf("shift by %d exceeds 32 bits", ins.K)
+		}
+		return asm.Instructions{op.Imm32(regA, int32(ins.K))}, nil
+	}
+
+	if op != asm.Div && op != asm.Mod {
+		return asm.Instructions{op.Reg32(regA, regX)}, nil
+	}
+
+	// Classic BPF aborts the filter when dividing by zero.
+	divide := fmt.Sprintf("cbpf_div_%d", i)
+	return asm.Instructions{
+		asm.JNE.Imm(regX, 0, divide),
+		asm.Mov.Imm32(asm.R0, 0),
+		asm.Return(),
+		op.Reg32(regA, regX).WithSymbol(divide),
+	}, nil
+}
+
+func convertJump(i int, ins Instruction) (asm.Instructions, error) {
+	var op asm.JumpOp
+	switch ins.Op & 0xf0 {
+	case jmpJa:
+		return asm.Instructions{asm.Ja.Label(label(i + 1 + int(ins.K)))}, nil
+	case jmpJeq:
+		op = asm.JEq
+	case jmpJgt:
+		op = asm.JGT
+	case jmpJge:
+		op = asm.JGE
+	case jmpJset:
+		op = asm.JSet
+	default:
+		return nil, fmt.Errorf("unsupported opcode %#04x", ins.Op)
+	}
+
+	var insns asm.Instructions
+	trueLabel := label(i + 1 + int(ins.Jt))
+
+	switch {
+	case ins.Op&srcX == srcX:
+		insns = append(insns, op.Reg(regA, regX, trueLabel))
+
+	case ins.K <= math.MaxInt32:
+		insns = append(insns, op.Imm(regA, int32(ins.K), trueLabel))
+
+	default:
+		// Immediates are sign extended to 64 bits, while A is zero
+		// extended.
+		insns = append(insns,
+			asm.Mov.Imm32(regK, int32(ins.K)),
+			op.Reg(regA, regK, trueLabel),
+		)
+	}
+
+	if ins.Jf != 0 {
+		insns = append(insns, asm.Ja.Label(label(i+1+int(ins.Jf))))
+	}
+
+	return insns, nil
+}
+
+// ParseDecimal reads a filter in the format produced by tcpdump -ddd and
+// bpf_asm.
+//
+// The first line contains the number of instructions, followed by one
+// instruction per line. Lines can be separated by commas as well.
+func ParseDecimal(r io.Reader) ([]Instruction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitDecimal)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("missing instruction count")
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(scanner.Text()), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("instruction count: %w", err)
+	}
+
+	filter := make([]Instruction, 0, n)
+	for scanner.Scan() {
+		var fields [4]uint64
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != len(fields) {
+			return nil, fmt.Errorf("instruction %d: expected %d fields, got %d", len(filter), len(fields), len(parts))
+		}
+
+		for j, bits := range []int{16, 8, 8, 32} {
+			fields[j], err = strconv.ParseUint(parts[j], 10, bits)
+			if err != nil {
+				return nil, fmt.Errorf("instruction %d: %w", len(filter), err)
+			}
+		}
+
+		filter = append(filter, Instruction{
+			Op: uint16(fields[0]),
+			Jt: uint8(fields[1]),
+			Jf: uint8(fields[2]),
+			K:  uint32(fields[3]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if uint64(len(filter)) != n {
+		return nil, fmt.Errorf("expected %d instructions, got %d", n, len(filter))
+	}
+
+	return filter, nil
+}
+
+// splitDecimal splits on newlines and commas, skipping empty tokens.
+func splitDecimal(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for ; start < len(data); start++ {
+		if c := data[start]; c != '\n' && c != ',' && c != '\r' && c != ' ' && c != '\t' {
+			break
+		}
+	}
+
+	for i := start; i < len(data); i++ {
+		if c := data[i]; c == '\n' || c == ',' {
+			return i + 1, data[start:i], nil
+		}
+	}
+
+	if atEOF && start < len(data) {
+		return len(data), data[start:], nil
+	}
+
+	return start, nil, nil
+}
+
+func label(i int) string {
+	return fmt.Sprintf("cbpf_%d", i)
+}
+
+// memOffset returns the stack offset of a scratch memory slot.
+func memOffset(k uint32) int16 {
+	return -int16(memWords-k) * 4
+}
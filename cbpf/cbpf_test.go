@@ -0,0 +1,208 @@
+package cbpf
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/link"
+)
+
+// Output of tcpdump -ddd "ip proto 17" for a DLT_RAW capture, which
+// matches UDP over IPv4.
+const udpFilter = `7
+48 0 0 0
+84 0 0 240
+21 0 3 64
+48 0 0 9
+21 0 1 17
+6 0 0 262144
+6 0 0 0
+`
+
+func TestParseDecimal(t *testing.T) {
+	for _, input := range []string{
+		"2\n6 0 0 1\n6 0 0 0\n",
+		"2,6 0 0 1,6 0 0 0",
+		"2\r\n6 0 0 1\r\n6 0 0 0\r\n",
+	} {
+		filter, err := ParseDecimal(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Parse %q: %s", input, err)
+		}
+		if len(filter) != 2 || filter[0] != (Instruction{Op: 6, K: 1}) {
+			t.Errorf("Parse %q: unexpected result %v", input, filter)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"3\n6 0 0 1\n",
+		"1\n6 0 0\n",
+		"1\n6 0 256 0\n",
+		"x\n6 0 0 0\n",
+	} {
+		if _, err := ParseDecimal(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse %q: expected an error", input)
+		}
+	}
+}
+
+func TestToEBPFInvalid(t *testing.T) {
+	for name, filter := range map[string][]Instruction{
+		"empty":            nil,
+		"no return":        {{Op: classLd | modeImm, K: 1}},
+		"jump past end":    {{Op: classJmp | jmpJeq, Jt: 1}, {Op: classRet}},
+		"ja past end":      {{Op: classJmp | jmpJa, K: 1}, {Op: classRet}},
+		"ancillary":        {{Op: classLd | modeAbs | sizeW, K: skfAdOff}, {Op: classRet | retA}},
+		"division by zero": {{Op: classAlu | aluDiv}, {Op: classRet | retA}},
+		"invalid shift":    {{Op: classAlu | aluLsh, K: 32}, {Op: classRet | retA}},
+		"invalid slot":     {{Op: classSt, K: memWords}, {Op: classRet | retA}},
+		"invalid opcode":   {{Op: classMisc | 0x40}, {Op: classRet | retA}},
+	} {
+		if _, err := ToEBPF(filter); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestToEBPF(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.12", "BPF_PROG_TEST_RUN")
+
+	// The kernel strips the ethernet header before running the filter.
+	udp := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x08, 0x00,
+		0x45, 0, 0, 28, 0, 0, 0, 0, 64, 17, 0, 0, 127, 0, 0, 1, 127, 0, 0, 1,
+		0x12, 0x34, 0x00, 0x35, 0, 8, 0, 0,
+	}
+	tcp := append([]byte(nil), udp...)
+	tcp[14+9] = 6
+
+	filter, err := ParseDecimal(strings.NewReader(udpFilter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFilter(t, filter, udp, 262144)
+	checkFilter(t, filter, tcp, 0)
+
+	// Load the UDP destination port, using the IP header length.
+	checkFilter(t, []Instruction{
+		{Op: classLdx | modeMsh | sizeB, K: 0},
+		{Op: classLd | modeInd | sizeH, K: 2},
+		{Op: classRet | retA},
+	}, udp, 53)
+
+	// Out of bounds loads abort the filter.
+	checkFilter(t, []Instruction{
+		{Op: classLd | modeAbs | sizeW, K: 1000},
+		{Op: classRet | retK, K: 1},
+	}, udp, 0)
+
+	// Scratch memory, X and arithmetic.
+	checkFilter(t, []Instruction{
+		{Op: classLd | modeImm, K: 7},
+		{Op: classSt, K: 3},
+		{Op: classLdx | modeImm, K: 5},
+		{Op: classLd | modeMem, K: 3},
+		{Op: classAlu | aluMul | srcX},
+		{Op: classAlu | aluSub | srcK, K: 5},
+		{Op: classStx, K: 15},
+		{Op: classLd | modeMem, K: 0},
+		{Op: classAlu | aluAdd | srcK, K: 0},
+		{Op: classMisc | miscTax},
+		{Op: classLd | modeMem, K: 15},
+		{Op: classAlu | aluAdd | srcX},
+		{Op: classAlu | aluOr | srcK, K: 0x100},
+		{Op: classRet | retA},
+	}, udp, 0x105)
+
+	// Division by X aborts the filter if X is zero.
+	checkFilter(t, []Instruction{
+		{Op: classLd | modeImm, K: 10},
+		{Op: classAlu | aluDiv | srcX},
+		{Op: classRet | retK, K: 1},
+	}, udp, 0)
+
+	// Comparisons are unsigned.
+	checkFilter(t, []Instruction{
+		{Op: classLd | modeImm, K: 0xffffffff},
+		{Op: classJmp | jmpJgt | srcK, K: 0xfffffffe, Jt: 0, Jf: 1},
+		{Op: classRet | retK, K: 1},
+		{Op: classRet | retK, K: 2},
+	}, udp, 1)
+
+	// Packet length.
+	checkFilter(t, []Instruction{
+		{Op: classLd | modeLen | sizeW},
+		{Op: classJmp | jmpJset | srcK, K: 0x80, Jt: 1},
+		{Op: classJmp | jmpJa, K: 1},
+		{Op: classRet | retK, K: 1},
+		{Op: classRet | retA},
+	}, udp, uint32(len(udp)-14))
+}
+
+func checkFilter(tb testing.TB, filter []Instruction, packet []byte, want uint32) {
+	tb.Helper()
+
+	prog, err := NewSocketFilter(filter)
+	testutils.SkipIfNotSupported(tb, err)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer prog.Close()
+
+	ret, _, err := prog.Test(packet)
+	testutils.SkipIfNotSupported(tb, err)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if ret != want {
+		tb.Errorf("Filter %v returned %d, expected %d", filter, ret, want)
+	}
+}
+
+func TestAttachSocketFilter(t *testing.T) {
+	// Drop all packets.
+	prog, err := NewSocketFilter([]Instruction{{Op: classRet | retK, K: 0}})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := link.AttachSocketFilter(conn, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.WriteTo([]byte("dropped"), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := conn.ReadFrom(make([]byte, 16)); err == nil {
+		t.Fatal("Filter didn't drop packet")
+	}
+
+	if err := link.DetachSocketFilter(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.WriteTo([]byte("passed"), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadFrom(make([]byte, 16)); err != nil {
+		t.Fatal("Packet wasn't received after detaching filter:", err)
+	}
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/kconfig"
 )
 
 // CollectionOptions control loading a collection into the kernel.
@@ -27,7 +33,27 @@ type CollectionOptions struct {
 	//
 	// The given Maps are Clone()d before being used in the Collection, so the
 	// caller can Close() them freely when they are no longer needed.
+	//
+	// The replacements are used as is: the Contents of the MapSpec are not
+	// written to them and they are not frozen. This allows sharing maps
+	// between collections, including read-only ones.
 	MapReplacements map[string]*Map
+
+	// ProgramOverrides replaces Programs for individual programs, indexed by
+	// their name in the CollectionSpec. This allows requesting a verbose
+	// verifier log only for a program which fails to load.
+	//
+	// The attach type, attach target and flags of a program are part of its
+	// ProgramSpec and can be modified there.
+	ProgramOverrides map[string]ProgramOptions
+
+	// LazyPrograms defers loading programs into the kernel until they are
+	// retrieved using Collection.Program. A program which the kernel rejects
+	// only causes an error when it is requested.
+	//
+	// Programs referenced by the contents of a ProgramArray are loaded with
+	// the Collection.
+	LazyPrograms bool
 }
 
 // CollectionSpec describes a collection.
@@ -42,6 +68,12 @@ type CollectionSpec struct {
 	// ByteOrder specifies whether the ELF was compiled for
 	// big-endian or little-endian architectures.
 	ByteOrder binary.ByteOrder
+
+	// Functions holds library functions which aren't programs, indexed by
+	// name. Calls between programs and functions of the same spec are
+	// already resolved, Functions is only used by LinkCollectionSpecs to
+	// resolve calls across specs. May be nil.
+	Functions map[string]asm.Instructions
 }
 
 // Copy returns a recursive copy of the spec.
@@ -65,6 +97,14 @@ func (cs *CollectionSpec) Copy() *CollectionSpec {
 		cpy.Programs[name] = spec.Copy()
 	}
 
+	if cs.Functions != nil {
+		cpy.Functions = make(map[string]asm.Instructions, len(cs.Functions))
+		for name, insns := range cs.Functions {
+			cpy.Functions[name] = make(asm.Instructions, len(insns))
+			copy(cpy.Functions[name], insns)
+		}
+	}
+
 	return &cpy
 }
 
@@ -114,9 +154,11 @@ func (cs *CollectionSpec) RewriteMaps(maps map[string]*Map) error {
 //    volatile const type foobar;
 //    volatile const type foobar = default;
 //
-// Replacement values must be of the same length as the C sizeof(type).
-// If necessary, they are marshalled according to the same rules as
-// map values.
+// Go integers and booleans are converted to the size of integer, boolean
+// and enum constants, and an error is returned if the value is out of
+// range. Other replacement values must be of the same length as the C
+// sizeof(type). If necessary, they are marshalled according to the same
+// rules as map values.
 //
 // From Linux 5.5 the verifier will use constants to eliminate dead code.
 //
@@ -139,8 +181,8 @@ func (cs *CollectionSpec) RewriteConstants(consts map[string]interface{}) error
 			return fmt.Errorf("map %s: %w", name, err)
 		}
 
-		// MapSpec.Copy() performs a shallow copy. Fully copy the byte slice
-		// to avoid any changes affecting other copies of the MapSpec.
+		// The byte slice may be shared with the caller or a spec which
+		// isn't a copy of this one. Don't modify it in place.
 		cpy := make([]byte, len(b))
 		copy(cpy, b)
 
@@ -159,7 +201,12 @@ func (cs *CollectionSpec) RewriteConstants(consts map[string]interface{}) error
 				return fmt.Errorf("section %s: offset %d(+%d) for variable %s is out of bounds", name, v.Offset, v.Size, vname)
 			}
 
-			b, err := marshalBytes(replacement, int(v.Size))
+			var typ btf.Type
+			if vr, ok := v.Type.(*btf.Var); ok {
+				typ = vr.Type
+			}
+
+			b, err := marshalConstant(replacement, typ, int(v.Size))
 			if err != nil {
 				return fmt.Errorf("marshaling constant replacement %s: %w", vname, err)
 			}
@@ -180,12 +227,78 @@ func (cs *CollectionSpec) RewriteConstants(consts map[string]interface{}) error
 	}
 
 	if len(missing) != 0 {
+		sort.Strings(missing)
 		return fmt.Errorf("spec is missing one or more constants: %s", strings.Join(missing, ","))
 	}
 
 	return nil
 }
 
+// marshalConstant encodes the replacement for a constant of type typ which
+// occupies size bytes.
+//
+// Integers and booleans are converted to the size of integer and enum
+// types, other values must marshal to exactly size bytes.
+func marshalConstant(value interface{}, typ btf.Type, size int) ([]byte, error) {
+	var signed bool
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Int:
+		signed = t.Encoding.IsSigned()
+	case *btf.Enum:
+		signed = t.Signed
+	default:
+		return marshalBytes(value, size)
+	}
+
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return marshalBytes(value, size)
+	}
+
+	bits := uint(size * 8)
+	maxUnsigned := uint64(math.MaxUint64) >> (64 - bits)
+	maxSigned := int64(maxUnsigned >> 1)
+	minSigned := -maxSigned - 1
+
+	var raw uint64
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if signed && (n < minSigned || n > maxSigned) || !signed && (n < 0 || uint64(n) > maxUnsigned) {
+			return nil, fmt.Errorf("value %d of type %T doesn't fit into %s", n, value, typ)
+		}
+		raw = uint64(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if signed && n > uint64(maxSigned) || !signed && n > maxUnsigned {
+			return nil, fmt.Errorf("value %d of type %T doesn't fit into %s", n, value, typ)
+		}
+		raw = n
+
+	case reflect.Bool:
+		if v.Bool() {
+			raw = 1
+		}
+
+	default:
+		return marshalBytes(value, size)
+	}
+
+	buf := make([]byte, size)
+	switch size {
+	case 1:
+		buf[0] = uint8(raw)
+	case 2:
+		internal.NativeEndian.PutUint16(buf, uint16(raw))
+	case 4:
+		internal.NativeEndian.PutUint32(buf, uint32(raw))
+	case 8:
+		internal.NativeEndian.PutUint64(buf, raw)
+	}
+	return buf, nil
+}
+
 // Assign the contents of a CollectionSpec to a struct.
 //
 // This function is a shortcut to manually checking the presence
@@ -316,11 +429,125 @@ func (cs *CollectionSpec) LoadAndAssign(to interface{}, opts *CollectionOptions)
 	return nil
 }
 
+// Verify checks whether the running kernel accepts all programs of the spec,
+// without keeping any of them.
+//
+// All maps are created since programs refer to them, and data maps like
+// .rodata are populated and frozen like NewCollection does, so that the
+// verifier sees the same constants. Nothing is pinned or attached, maps
+// referring to programs or other maps are left empty, and all objects are
+// closed before returning. CollectionOptions.LazyPrograms is ignored. opts
+// may be nil.
+//
+// The result holds the outcome of loading each program, indexed by name:
+// nil if the verifier accepted the program, the error otherwise. Rejected
+// programs return a *VerifierError holding the verifier log. Programs of
+// unspecified type are left out. An error is returned if the spec can't be
+// loaded at all, for example because a map can't be created.
+func (cs *CollectionSpec) Verify(opts *CollectionOptions) (map[string]error, error) {
+	spec := cs.Copy()
+	for _, ms := range spec.Maps {
+		ms.Pinning = PinNone
+	}
+
+	loader, err := newCollectionLoader(spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer loader.cleanup()
+
+	for mapName := range spec.Maps {
+		if _, err := loader.loadMap(mapName); err != nil {
+			return nil, err
+		}
+	}
+
+	for mapName, m := range loader.maps {
+		switch spec.Maps[mapName].Type {
+		case ProgramArray, ArrayOfMaps, HashOfMaps, StructOpsMap:
+			// Populating these requires loading the programs under test,
+			// or registers struct_ops with the kernel.
+			continue
+		}
+
+		if err := loader.populateMap(mapName, m); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(map[string]error)
+	for progName, prog := range spec.Programs {
+		if prog.Type == UnspecifiedProgram {
+			continue
+		}
+
+		prog, err := loader.loadProgram(progName)
+		if err == nil {
+			// Don't hold on to programs which passed verification.
+			prog.Close()
+			delete(loader.programs, progName)
+		}
+		results[progName] = err
+	}
+
+	return results, nil
+}
+
+// MinimalBTF returns the subset of target, usually the BTF of a kernel, which
+// is needed by the CO-RE relocations of all programs in the spec.
+//
+// Storing the result for each supported kernel allows loading the spec on
+// kernels without BTF, see btf.LoadKernelSpecFromFS and
+// ProgramOptions.KernelTypes.
+func (cs *CollectionSpec) MinimalBTF(target *btf.Spec) (*btf.Spec, error) {
+	var relos []*btf.CORERelocation
+	for name, prog := range cs.Programs {
+		if prog.BTF == nil {
+			continue
+		}
+
+		if prog.BTF != cs.Types {
+			return nil, fmt.Errorf("program %s: BTF doesn't match collection", name)
+		}
+
+		for i := range prog.Instructions {
+			if relo := btf.CORERelocationMetadata(&prog.Instructions[i]); relo != nil {
+				relos = append(relos, relo)
+			}
+		}
+	}
+
+	if len(relos) == 0 {
+		return btf.NewSpecFromTypes()
+	}
+
+	return btf.MinimalSpec(cs.Types, target, relos)
+}
+
 // Collection is a collection of Programs and Maps associated
 // with their symbols
 type Collection struct {
+	// Programs contains the loaded programs. See CollectionOptions.LazyPrograms
+	// for programs which are loaded on demand.
 	Programs map[string]*Program
 	Maps     map[string]*Map
+
+	// Variables contains the global variables declared in data sections
+	// which have BTF, indexed by name. They refer to Maps and become unusable
+	// once the map of their section is closed.
+	//
+	// Names declared in more than one section are ambiguous and left out.
+	// Use the Map of the section to access them.
+	Variables map[string]*Variable
+
+	// Programs which haven't been loaded yet.
+	lazy *lazyPrograms
+}
+
+// lazyPrograms loads the programs of a Collection on demand.
+type lazyPrograms struct {
+	spec *CollectionSpec
+	opts CollectionOptions
 }
 
 // NewCollection creates a Collection from the given spec, creating and
@@ -352,7 +579,7 @@ func NewCollectionWithOptions(spec *CollectionSpec, opts CollectionOptions) (*Co
 	}
 
 	for progName, prog := range spec.Programs {
-		if prog.Type == UnspecifiedProgram {
+		if opts.LazyPrograms || prog.Type == UnspecifiedProgram {
 			continue
 		}
 
@@ -369,11 +596,33 @@ func NewCollectionWithOptions(spec *CollectionSpec, opts CollectionOptions) (*Co
 
 	maps, progs := loader.maps, loader.programs
 
+	vars := make(map[string]*Variable)
+	ambiguous := make(map[string]bool)
+	for mapName, m := range maps {
+		for name, v := range newVariables(spec.Maps[mapName], m) {
+			// Sections may declare static variables of the same name, for
+			// example after linking multiple objects.
+			if vars[name] != nil || ambiguous[name] {
+				delete(vars, name)
+				ambiguous[name] = true
+				continue
+			}
+			vars[name] = v
+		}
+	}
+
 	loader.finalize()
 
+	var lazy *lazyPrograms
+	if opts.LazyPrograms {
+		lazy = &lazyPrograms{spec.Copy(), opts}
+	}
+
 	return &Collection{
 		progs,
 		maps,
+		vars,
+		lazy,
 	}, nil
 }
 
@@ -387,12 +636,19 @@ func newHandleCache() *handleCache {
 	}
 }
 
-func (hc handleCache) btfHandle(spec *btf.Spec) (*btf.Handle, error) {
+// btfHandle returns a cached handle for spec. token may be nil, it's only
+// used if the handle doesn't exist yet.
+func (hc handleCache) btfHandle(spec *btf.Spec, token *Token) (*btf.Handle, error) {
 	if hc.btfHandles[spec] != nil {
 		return hc.btfHandles[spec], nil
 	}
 
-	handle, err := btf.NewHandle(spec)
+	var opts btf.HandleOptions
+	if token != nil {
+		opts.TokenFD = token.FD()
+	}
+
+	handle, err := btf.NewHandleWithOptions(spec, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -420,6 +676,11 @@ func newCollectionLoader(coll *CollectionSpec, opts *CollectionOptions) (*collec
 		opts = &CollectionOptions{}
 	}
 
+	// Catch objects built for the wrong target before creating any maps.
+	if err := internal.CheckByteOrder(coll.ByteOrder); err != nil {
+		return nil, err
+	}
+
 	// Check for existing MapSpecs in the CollectionSpec for all provided replacement maps.
 	for name, m := range opts.MapReplacements {
 		spec, ok := coll.Maps[name]
@@ -432,6 +693,12 @@ func newCollectionLoader(coll *CollectionSpec, opts *CollectionOptions) (*collec
 		}
 	}
 
+	for name := range opts.ProgramOverrides {
+		if _, ok := coll.Programs[name]; !ok {
+			return nil, fmt.Errorf("program options for %s: program not found in CollectionSpec", name)
+		}
+	}
+
 	return &collectionLoader{
 		coll,
 		opts,
@@ -507,7 +774,7 @@ func (cl *collectionLoader) loadProgram(progName string) (*Program, error) {
 	// Bail out early if we know the kernel is going to reject the program.
 	// This skips loading map dependencies, saving some cleanup work later.
 	if progSpec.Type == UnspecifiedProgram {
-		return nil, fmt.Errorf("cannot load program %s: program type is unspecified", progName)
+		return nil, fmt.Errorf("cannot load program %s: program type is unspecified (section %q)", progName, progSpec.SectionName)
 	}
 
 	if progSpec.BTF != nil && cl.coll.Types != progSpec.BTF {
@@ -542,7 +809,12 @@ func (cl *collectionLoader) loadProgram(progName string) (*Program, error) {
 		}
 	}
 
-	prog, err := newProgramWithOptions(progSpec, cl.opts.Programs, cl.handles)
+	opts := cl.opts.Programs
+	if override, ok := cl.opts.ProgramOverrides[progName]; ok {
+		opts = override
+	}
+
+	prog, err := newProgramWithOptions(progSpec, opts, cl.handles)
 	if err != nil {
 		return nil, fmt.Errorf("program %s: %w", progName, err)
 	}
@@ -553,46 +825,167 @@ func (cl *collectionLoader) loadProgram(progName string) (*Program, error) {
 
 func (cl *collectionLoader) populateMaps() error {
 	for mapName, m := range cl.maps {
-		mapSpec, ok := cl.coll.Maps[mapName]
-		if !ok {
-			return fmt.Errorf("missing map spec %s", mapName)
-		}
-
-		mapSpec = mapSpec.Copy()
-
-		// MapSpecs that refer to inner maps or programs within the same
-		// CollectionSpec do so using strings. These strings are used as the key
-		// to look up the respective object in the Maps or Programs fields.
-		// Resolve those references to actual Map or Program resources that
-		// have been loaded into the kernel.
-		for i, kv := range mapSpec.Contents {
-			if objName, ok := kv.Value.(string); ok {
-				switch mapSpec.Type {
-				case ProgramArray:
-					// loadProgram is idempotent and could return an existing Program.
-					prog, err := cl.loadProgram(objName)
-					if err != nil {
-						return fmt.Errorf("loading program %s, for map %s: %w", objName, mapName, err)
-					}
-					mapSpec.Contents[i] = MapKV{kv.Key, prog}
-
-				case ArrayOfMaps, HashOfMaps:
-					// loadMap is idempotent and could return an existing Map.
-					innerMap, err := cl.loadMap(objName)
-					if err != nil {
-						return fmt.Errorf("loading inner map %s, for map %s: %w", objName, mapName, err)
-					}
-					mapSpec.Contents[i] = MapKV{kv.Key, innerMap}
+		if err := cl.populateMap(mapName, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// populateMap writes the contents of a map and freezes it if specified.
+func (cl *collectionLoader) populateMap(mapName string, m *Map) error {
+	mapSpec, ok := cl.coll.Maps[mapName]
+	if !ok {
+		return fmt.Errorf("missing map spec %s", mapName)
+	}
+
+	if _, ok := cl.opts.MapReplacements[mapName]; ok {
+		// Replacements are shared with their owner, who is in charge of
+		// populating and freezing them.
+		return nil
+	}
+
+	mapSpec = mapSpec.Copy()
+
+	if mapName == ".kconfig" && len(mapSpec.Contents) == 0 {
+		if err := resolveKconfig(mapSpec); err != nil {
+			return fmt.Errorf("resolving kconfig: %w", err)
+		}
+	}
+
+	// MapSpecs that refer to inner maps or programs within the same
+	// CollectionSpec do so using strings. These strings are used as the key
+	// to look up the respective object in the Maps or Programs fields.
+	// Resolve those references to actual Map or Program resources that
+	// have been loaded into the kernel.
+	for i, kv := range mapSpec.Contents {
+		if objName, ok := kv.Value.(string); ok {
+			switch mapSpec.Type {
+			case ProgramArray:
+				// loadProgram is idempotent and could return an existing Program.
+				prog, err := cl.loadProgram(objName)
+				if err != nil {
+					return fmt.Errorf("loading program %s, for map %s: %w", objName, mapName, err)
+				}
+				mapSpec.Contents[i] = MapKV{kv.Key, prog}
+
+			case ArrayOfMaps, HashOfMaps:
+				// loadMap is idempotent and could return an existing Map.
+				innerMap, err := cl.loadMap(objName)
+				if err != nil {
+					return fmt.Errorf("loading inner map %s, for map %s: %w", objName, mapName, err)
 				}
+				mapSpec.Contents[i] = MapKV{kv.Key, innerMap}
+			}
+		}
+
+		if value, ok := kv.Value.(StructOpsValue); ok && mapSpec.Type == StructOpsMap {
+			progs := make(map[string]*Program, len(value.Programs))
+			for member, progName := range value.Programs {
+				prog, err := cl.loadProgram(progName)
+				if err != nil {
+					return fmt.Errorf("loading program %s, for map %s: %w", progName, mapName, err)
+				}
+				progs[member] = prog
+			}
+
+			data, err := value.marshalKernel(mapSpec.Value, progs)
+			if err != nil {
+				return fmt.Errorf("map %s: %w", mapName, err)
+			}
+			mapSpec.Contents[i] = MapKV{kv.Key, data}
+		}
+	}
+
+	// Populate and freeze the map if specified.
+	if err := m.finalize(mapSpec); err != nil {
+		return fmt.Errorf("populating map %s: %w", mapName, err)
+	}
+
+	return nil
+}
+
+// resolveKconfig fills the contents of the .kconfig map from the configuration
+// of the running kernel.
+//
+// Options which are missing from the configuration are left zeroed, which
+// matches the behaviour of weak externs in libbpf.
+func resolveKconfig(m *MapSpec) error {
+	ds, ok := m.Value.(*btf.Datasec)
+	if !ok {
+		return errors.New("map value is not a Datasec")
+	}
+
+	type configInfo struct {
+		offset uint32
+		typ    btf.Type
+	}
+
+	configs := make(map[string]configInfo)
+
+	data := make([]byte, ds.Size)
+	for _, vsi := range ds.Vars {
+		v := vsi.Type.(*btf.Var)
+		n := v.TypeName()
+
+		switch {
+		case n == "LINUX_KERNEL_VERSION":
+			if integer, ok := v.Type.(*btf.Int); !ok || integer.Size != 4 {
+				return fmt.Errorf("variable %s must be a 32 bits integer, got %s", n, v.Type)
+			}
+
+			kv, err := internal.KernelVersion()
+			if err != nil {
+				return fmt.Errorf("getting kernel version: %w", err)
 			}
+			internal.NativeEndian.PutUint32(data[vsi.Offset:], kv.Kernel())
+
+		case strings.HasPrefix(n, "CONFIG_"):
+			configs[n] = configInfo{vsi.Offset, v.Type}
+
+		default:
+			return fmt.Errorf("variable %s: %w", n, ErrNotSupported)
 		}
+	}
 
-		// Populate and freeze the map if specified.
-		if err := m.finalize(mapSpec); err != nil {
-			return fmt.Errorf("populating map %s: %w", mapName, err)
+	if len(configs) > 0 {
+		f, err := kconfig.Find()
+		if err != nil {
+			return fmt.Errorf("cannot find a kconfig file: %w", err)
+		}
+		defer f.Close()
+
+		filter := make(map[string]struct{}, len(configs))
+		for config := range configs {
+			filter[config] = struct{}{}
+		}
+
+		kernelConfig, err := kconfig.Parse(f, filter)
+		if err != nil {
+			return fmt.Errorf("cannot parse kconfig file: %w", err)
+		}
+
+		for n, info := range configs {
+			value, ok := kernelConfig[n]
+			if !ok {
+				continue
+			}
+
+			size, err := btf.Sizeof(info.typ)
+			if err != nil {
+				return fmt.Errorf("variable %s: %w", n, err)
+			}
+
+			err = kconfig.PutValue(data[info.offset:info.offset+uint32(size)], info.typ, value)
+			if err != nil {
+				return fmt.Errorf("variable %s: %w", n, err)
+			}
 		}
 	}
 
+	m.Contents = []MapKV{{uint32(0), data}}
+
 	return nil
 }
 
@@ -609,6 +1002,245 @@ func LoadCollection(file string) (*Collection, error) {
 	return NewCollection(spec)
 }
 
+// ProgramUpdater is an attachment whose program can be replaced, like
+// link.Link.
+type ProgramUpdater interface {
+	Update(*Program) error
+}
+
+// ReplaceOptions control how ReplaceCollection swaps out a Collection.
+type ReplaceOptions struct {
+	CollectionOptions
+
+	// Links attached to programs of the existing Collection, keyed by
+	// program name. Each link is updated to the program of the same name
+	// in the new Collection.
+	//
+	// Links which can't be updated by the kernel must be wrapped in a type
+	// which detaches and re-attaches the program.
+	Links map[string][]ProgramUpdater
+
+	// RecreateMaps names maps which are created from the new spec, even if
+	// they are compatible with the existing map.
+	RecreateMaps []string
+}
+
+// ReplaceCollection loads spec as a replacement for coll, preserving the
+// state of maps.
+//
+// Maps of coll are reused if spec contains a compatible MapSpec of the
+// same name. Their contents are left as they are, and the Contents of the
+// MapSpec are ignored. Frozen maps and ProgramArrays are always created from
+// spec, since their contents are defined by the spec.
+//
+// Once the new Collection is loaded, all links in opts are updated.
+// Each update is atomic, but links are updated one after the other. If an
+// update fails, links are reverted to their previous programs, the new
+// Collection is closed and coll remains usable.
+//
+// On success, coll is closed and the new Collection is returned.
+func ReplaceCollection(coll *Collection, spec *CollectionSpec, opts ReplaceOptions) (*Collection, error) {
+	for name := range opts.Links {
+		if _, ok := coll.Programs[name]; !ok {
+			return nil, fmt.Errorf("link for program %s: program not found in existing collection", name)
+		}
+		if _, ok := spec.Programs[name]; !ok {
+			return nil, fmt.Errorf("link for program %s: program not found in spec", name)
+		}
+	}
+
+	recreate := make(map[string]bool, len(opts.RecreateMaps))
+	for _, name := range opts.RecreateMaps {
+		recreate[name] = true
+	}
+
+	spec = spec.Copy()
+	replacements := make(map[string]*Map, len(opts.MapReplacements))
+	for name, m := range opts.MapReplacements {
+		replacements[name] = m
+	}
+
+	for name, m := range coll.Maps {
+		mapSpec, ok := spec.Maps[name]
+		if !ok || recreate[name] || replacements[name] != nil {
+			continue
+		}
+
+		if mapSpec.Freeze || mapSpec.Type == ProgramArray {
+			continue
+		}
+
+		if err := mapSpec.checkCompatibility(m); err != nil {
+			continue
+		}
+
+		// Replacements keep their existing contents.
+		replacements[name] = m
+	}
+
+	collOpts := opts.CollectionOptions
+	collOpts.MapReplacements = replacements
+
+	newColl, err := NewCollectionWithOptions(spec, collOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	type update struct {
+		prog string
+		link ProgramUpdater
+	}
+
+	var updated []update
+	rollback := func() {
+		// The old programs are still open and have been attached with
+		// these links before, so reverting is expected to succeed.
+		for _, u := range updated {
+			_ = u.link.Update(coll.Programs[u.prog])
+		}
+		newColl.Close()
+	}
+
+	for name, links := range opts.Links {
+		for _, l := range links {
+			if err := l.Update(newColl.Programs[name]); err != nil {
+				rollback()
+				return nil, fmt.Errorf("update link for program %s: %w", name, err)
+			}
+			updated = append(updated, update{name, l})
+		}
+	}
+
+	coll.Close()
+	return newColl, nil
+}
+
+// Assign the contents of a Collection to a struct.
+//
+// This is useful when a Collection has been created by other means than
+// CollectionSpec.LoadAndAssign, for example after tweaking the loaded
+// objects.
+//
+// 'to' must be a pointer to a struct. A field of the struct is updated with
+// a Program or Map if it has an `ebpf` tag and its type is *Program or *Map.
+// The tag's value specifies the name of the program or map as found in the
+// Collection. Fields of type *Variable are assigned the Variable of that name.
+//
+//    struct {
+//        Foo     *ebpf.Program  `ebpf:"xdp_foo"`
+//        Bar     *ebpf.Map      `ebpf:"bar_map"`
+//        Baz     *ebpf.Variable `ebpf:"baz"`
+//        Ignored int
+//    }
+//
+// Returns an error if any of the eBPF objects can't be found, or
+// if the same Map or Program is assigned multiple times.
+//
+// Assigned objects are detached from the Collection, so the caller is
+// responsible for closing them. Variables aren't detached and can only be
+// used as long as the map of their section is open. On error no objects are
+// detached, but programs loaded because of LazyPrograms remain part of the
+// Collection.
+func (coll *Collection) Assign(to interface{}) error {
+	assignedMaps := make(map[string]bool)
+	assignedProgs := make(map[string]bool)
+
+	// Only already loaded maps are assigned. Programs are loaded if the
+	// Collection uses LazyPrograms.
+	getValue := func(typ reflect.Type, name string) (interface{}, error) {
+		switch typ {
+
+		case reflect.TypeOf((*Program)(nil)):
+			p, err := coll.Program(name)
+			if err != nil {
+				return nil, err
+			}
+			assignedProgs[name] = true
+			return p, nil
+
+		case reflect.TypeOf((*Map)(nil)):
+			if m := coll.Maps[name]; m != nil {
+				assignedMaps[name] = true
+				return m, nil
+			}
+			return nil, fmt.Errorf("missing map %q", name)
+
+		case reflect.TypeOf((*Variable)(nil)):
+			if v := coll.Variables[name]; v != nil {
+				return v, nil
+			}
+			return nil, fmt.Errorf("missing variable %q", name)
+
+		default:
+			return nil, fmt.Errorf("unsupported type %s", typ)
+		}
+	}
+
+	if err := assignValues(to, getValue); err != nil {
+		return err
+	}
+
+	for name := range assignedProgs {
+		coll.DetachProgram(name)
+	}
+	for name := range assignedMaps {
+		coll.DetachMap(name)
+	}
+
+	return nil
+}
+
+// Program returns the named program, loading it into the kernel if the
+// Collection was created with CollectionOptions.LazyPrograms.
+//
+// Subsequent calls return the same Program, which is also added to
+// Collection.Programs.
+func (coll *Collection) Program(name string) (*Program, error) {
+	if prog := coll.Programs[name]; prog != nil {
+		return prog, nil
+	}
+
+	if coll.lazy == nil || coll.lazy.spec.Programs[name] == nil {
+		return nil, fmt.Errorf("missing program %q", name)
+	}
+
+	loader, err := newCollectionLoader(coll.lazy.spec, &coll.lazy.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer loader.handles.close()
+
+	for mapName, m := range coll.Maps {
+		loader.maps[mapName] = m
+	}
+
+	prog, err := loader.loadProgram(name)
+
+	// Maps which are missing from the Collection have been detached. Don't
+	// create a second instance behind the caller's back.
+	for mapName, m := range loader.maps {
+		if coll.Maps[mapName] == m {
+			continue
+		}
+
+		m.Close()
+		if err == nil {
+			prog.Close()
+			err = fmt.Errorf("program %s: map %s was detached from the collection", name, mapName)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if coll.Programs == nil {
+		coll.Programs = make(map[string]*Program)
+	}
+	coll.Programs[name] = prog
+	return prog, nil
+}
+
 // Close frees all maps and programs associated with the collection.
 //
 // The collection mustn't be used afterwards.
@@ -621,6 +1253,160 @@ func (coll *Collection) Close() {
 	}
 }
 
+// Subdirectories used by Collection.Pin.
+const (
+	pinnedMapsDir     = "maps"
+	pinnedProgramsDir = "programs"
+)
+
+// bpffs doesn't allow dots in names, which are common in map names like
+// .rodata. Escape them reversibly.
+var (
+	pinNameEscaper   = strings.NewReplacer("%", "%25", ".", "%2E")
+	pinNameUnescaper = strings.NewReplacer("%2E", ".", "%25", "%")
+)
+
+// Pin persists all maps and programs of the Collection below dir on a bpffs.
+//
+// Maps are pinned into the subdirectory maps and programs into the
+// subdirectory programs, using their names in the Collection. Programs which
+// haven't been loaded because of LazyPrograms are not pinned. Use
+// LoadPinnedCollection to restore the Collection.
+//
+// Objects which are already pinned, for example because of PinByName, keep
+// their existing pin and are pinned a second time below dir.
+//
+// Pins created by this call are removed again if an error occurs.
+func (coll *Collection) Pin(dir string) (err error) {
+	var (
+		// Objects which were unpinned before this call.
+		pinned []interface{ Unpin() error }
+		// Additional pins of objects which were pinned before this call.
+		created []string
+	)
+	defer func() {
+		if err != nil {
+			for _, obj := range pinned {
+				_ = obj.Unpin()
+			}
+			for _, path := range created {
+				_ = internal.Unpin(path)
+			}
+		}
+	}()
+
+	for _, sub := range []string{pinnedMapsDir, pinnedProgramsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	for name, m := range coll.Maps {
+		path := filepath.Join(dir, pinnedMapsDir, pinNameEscaper.Replace(name))
+		switch {
+		case m.pinnedPath == path:
+		case m.IsPinned():
+			// Map.Pin would move the existing pin, which may be shared with
+			// other processes.
+			if err := internal.Pin("", path, m.fd); err != nil {
+				return fmt.Errorf("pin map %s: %w", name, err)
+			}
+			created = append(created, path)
+		default:
+			if err := m.Pin(path); err != nil {
+				return fmt.Errorf("pin map %s: %w", name, err)
+			}
+			pinned = append(pinned, m)
+		}
+	}
+
+	for name, p := range coll.Programs {
+		path := filepath.Join(dir, pinnedProgramsDir, pinNameEscaper.Replace(name))
+		switch {
+		case p.pinnedPath == path:
+		case p.IsPinned():
+			if err := internal.Pin("", path, p.fd); err != nil {
+				return fmt.Errorf("pin program %s: %w", name, err)
+			}
+			created = append(created, path)
+		default:
+			if err := p.Pin(path); err != nil {
+				return fmt.Errorf("pin program %s: %w", name, err)
+			}
+			pinned = append(pinned, p)
+		}
+	}
+
+	return nil
+}
+
+// LoadPinnedCollection restores a Collection pinned below dir by
+// Collection.Pin.
+//
+// The returned Collection has no Variables, since the pins don't carry the
+// layout of data sections.
+func LoadPinnedCollection(dir string, opts *LoadPinOptions) (_ *Collection, err error) {
+	coll := &Collection{
+		Programs: make(map[string]*Program),
+		Maps:     make(map[string]*Map),
+	}
+	defer func() {
+		if err != nil {
+			coll.Close()
+		}
+	}()
+
+	maps, err := readPinnedNames(filepath.Join(dir, pinnedMapsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, path := range maps {
+		m, err := LoadPinnedMap(path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("map %s: %w", name, err)
+		}
+		coll.Maps[name] = m
+	}
+
+	progs, err := readPinnedNames(filepath.Join(dir, pinnedProgramsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, path := range progs {
+		p, err := LoadPinnedProgram(path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %w", name, err)
+		}
+		coll.Programs[name] = p
+	}
+
+	return coll, nil
+}
+
+// readPinnedNames returns the paths of all objects in dir, indexed by their
+// unescaped names. A missing dir is treated as empty.
+func readPinnedNames(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		names[pinNameUnescaper.Replace(entry.Name())] = filepath.Join(dir, entry.Name())
+	}
+
+	return names, nil
+}
+
 // DetachMap removes the named map from the Collection.
 //
 // This means that a later call to Close() will not affect this map.
@@ -0,0 +1,146 @@
+package ebpf
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+type mapSpecSummary struct {
+	Type       string `json:"type"`
+	KeySize    uint32 `json:"key_size"`
+	ValueSize  uint32 `json:"value_size"`
+	MaxEntries uint32 `json:"max_entries"`
+	Flags      uint32 `json:"flags"`
+	Pinning    string `json:"pinning"`
+	Key        string `json:"key,omitempty"`
+	Value      string `json:"value,omitempty"`
+	InnerMap   string `json:"inner_map,omitempty"`
+	Contents   int    `json:"contents"`
+}
+
+type programSpecSummary struct {
+	Type         string   `json:"type"`
+	AttachType   string   `json:"attach_type"`
+	AttachTo     string   `json:"attach_to,omitempty"`
+	Section      string   `json:"section"`
+	License      string   `json:"license"`
+	Instructions int      `json:"instructions"`
+	Helpers      []string `json:"helpers,omitempty"`
+	Kfuncs       []string `json:"kfuncs,omitempty"`
+	Maps         []string `json:"maps,omitempty"`
+}
+
+type collectionSpecSummary struct {
+	ByteOrder string                        `json:"byte_order,omitempty"`
+	Maps      map[string]mapSpecSummary     `json:"maps"`
+	Programs  map[string]programSpecSummary `json:"programs"`
+	Types     []string                      `json:"types,omitempty"`
+}
+
+// MarshalJSON encodes a summary of the spec for inspection, for example to
+// diff the objects generated in CI.
+//
+// The summary lists maps, programs with the helpers, kfuncs and maps they
+// reference, and the named BTF types of the spec. It can't be decoded back
+// into a CollectionSpec.
+func (cs *CollectionSpec) MarshalJSON() ([]byte, error) {
+	summary := collectionSpecSummary{
+		Maps:     make(map[string]mapSpecSummary, len(cs.Maps)),
+		Programs: make(map[string]programSpecSummary, len(cs.Programs)),
+	}
+
+	if cs.ByteOrder != nil {
+		summary.ByteOrder = fmt.Sprint(cs.ByteOrder)
+	}
+
+	for name, ms := range cs.Maps {
+		summary.Maps[name] = summarizeMapSpec(ms)
+	}
+
+	for name, ps := range cs.Programs {
+		summary.Programs[name] = summarizeProgramSpec(ps)
+	}
+
+	if cs.Types != nil {
+		iter := cs.Types.Iterate()
+		for iter.Next() {
+			if iter.Type.TypeName() == "" {
+				continue
+			}
+			summary.Types = append(summary.Types, fmt.Sprint(iter.Type))
+		}
+	}
+
+	return json.Marshal(summary)
+}
+
+func summarizeMapSpec(ms *MapSpec) mapSpecSummary {
+	summary := mapSpecSummary{
+		Type:       ms.Type.String(),
+		KeySize:    ms.KeySize,
+		ValueSize:  ms.ValueSize,
+		MaxEntries: ms.MaxEntries,
+		Flags:      ms.Flags,
+		Pinning:    ms.Pinning.String(),
+		Contents:   len(ms.Contents),
+	}
+
+	if ms.Key != nil {
+		summary.Key = fmt.Sprint(ms.Key)
+	}
+	if ms.Value != nil {
+		summary.Value = fmt.Sprint(ms.Value)
+	}
+	if ms.InnerMap != nil {
+		summary.InnerMap = ms.InnerMap.String()
+	}
+
+	return summary
+}
+
+func summarizeProgramSpec(ps *ProgramSpec) programSpecSummary {
+	var (
+		helpers = make(map[string]bool)
+		kfuncs  = make(map[string]bool)
+		maps    = make(map[string]bool)
+	)
+
+	for _, ins := range ps.Instructions {
+		switch {
+		case ins.IsBuiltinCall():
+			helpers[asm.BuiltinFunc(ins.Constant).String()] = true
+		case ins.IsKfuncCall():
+			kfuncs[ins.Reference()] = true
+		case ins.IsLoadFromMap() && ins.Reference() != "":
+			maps[ins.Reference()] = true
+		}
+	}
+
+	return programSpecSummary{
+		Type:         ps.Type.String(),
+		AttachType:   ps.AttachType.String(),
+		AttachTo:     ps.AttachTo,
+		Section:      ps.SectionName,
+		License:      ps.License,
+		Instructions: len(ps.Instructions),
+		Helpers:      sortedKeys(helpers),
+		Kfuncs:       sortedKeys(kfuncs),
+		Maps:         sortedKeys(maps),
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,15 +1,24 @@
 package ebpf
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/kconfig"
 	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+
+	qt "github.com/frankban/quicktest"
 )
 
 func TestCollectionSpecNotModified(t *testing.T) {
@@ -88,6 +97,40 @@ func TestCollectionSpecCopy(t *testing.T) {
 	}
 }
 
+func TestCollectionSpecCopyContents(t *testing.T) {
+	cs := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".data": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Contents:   []MapKV{{uint32(0), []byte{1, 2, 3, 4}}},
+				Extra:      bytes.NewReader([]byte{5}),
+			},
+			"ops": {
+				Type:     StructOpsMap,
+				Contents: []MapKV{{uint32(0), StructOpsValue{Data: []byte{1}, Programs: map[string]string{"a": "b"}}}},
+			},
+		},
+	}
+
+	cpy := cs.Copy()
+	cpy.Maps[".data"].Contents[0].Value.([]byte)[0] = 0xff
+	cpy.Maps["ops"].Contents[0].Value.(StructOpsValue).Data[0] = 0xff
+	cpy.Maps["ops"].Contents[0].Value.(StructOpsValue).Programs["a"] = "c"
+	if _, err := cpy.Maps[".data"].Extra.ReadByte(); err != nil {
+		t.Fatal(err)
+	}
+
+	qt.Assert(t, cs.Maps[".data"].Contents[0].Value, qt.DeepEquals, []byte{1, 2, 3, 4})
+	qt.Assert(t, cs.Maps[".data"].Extra.Len(), qt.Equals, 1)
+	qt.Assert(t, cs.Maps["ops"].Contents[0].Value, qt.DeepEquals, StructOpsValue{
+		Data:     []byte{1},
+		Programs: map[string]string{"a": "b"},
+	})
+}
+
 func TestCollectionSpecLoadCopy(t *testing.T) {
 	file := fmt.Sprintf("testdata/loader-%s.elf", internal.ClangEndian)
 	spec, err := LoadCollectionSpec(file)
@@ -112,6 +155,168 @@ func TestCollectionSpecLoadCopy(t *testing.T) {
 	}
 }
 
+func TestCollectionSpecRewriteConstants(t *testing.T) {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	s8 := &btf.Int{Name: "s8", Size: 1, Encoding: btf.Signed}
+	boolean := &btf.Int{Name: "bool", Size: 1, Encoding: btf.Bool}
+
+	newSpec := func() *CollectionSpec {
+		return &CollectionSpec{
+			Maps: map[string]*MapSpec{
+				".rodata": {
+					Type:       Array,
+					KeySize:    4,
+					ValueSize:  6,
+					MaxEntries: 1,
+					Value: &btf.Datasec{
+						Name: ".rodata",
+						Size: 6,
+						Vars: []btf.VarSecinfo{
+							{Type: &btf.Var{Name: "port", Type: u32}, Offset: 0, Size: 4},
+							{Type: &btf.Var{Name: "level", Type: &btf.Const{Type: s8}}, Offset: 4, Size: 1},
+							{Type: &btf.Var{Name: "enabled", Type: boolean}, Offset: 5, Size: 1},
+						},
+					},
+					Contents: []MapKV{{uint32(0), make([]byte, 6)}},
+				},
+			},
+		}
+	}
+
+	cs := newSpec()
+	err := cs.RewriteConstants(map[string]interface{}{
+		"port":    8080,
+		"level":   -1,
+		"enabled": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 6)
+	internal.NativeEndian.PutUint32(want, 8080)
+	want[4] = 0xff
+	want[5] = 1
+	if have := cs.Maps[".rodata"].Contents[0].Value.([]byte); !bytes.Equal(have, want) {
+		t.Errorf("Expected contents %v, got %v", want, have)
+	}
+
+	for name, value := range map[string]interface{}{
+		"port":  -1,
+		"level": uint8(128),
+	} {
+		if err := newSpec().RewriteConstants(map[string]interface{}{name: value}); err == nil {
+			t.Errorf("Out of range value %v for %s isn't rejected", value, name)
+		}
+	}
+
+	if err := newSpec().RewriteConstants(map[string]interface{}{"port": uint16(1)}); err != nil {
+		t.Error("Can't rewrite constant with a smaller integer:", err)
+	}
+
+	err = newSpec().RewriteConstants(map[string]interface{}{"b": 1, "a": 2})
+	if err == nil || err.Error() != "spec is missing one or more constants: a,b" {
+		t.Error("Expected sorted list of missing constants, got", err)
+	}
+}
+
+func TestCollectionKconfig(t *testing.T) {
+	f, err := kconfig.Find()
+	if err != nil {
+		t.Skip("No kernel configuration available:", err)
+	}
+	config, err := kconfig.Parse(f, map[string]struct{}{"CONFIG_HZ": {}})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hz, err := strconv.ParseUint(config["CONFIG_HZ"], 10, 32)
+	if err != nil {
+		t.Skip("CONFIG_HZ is not set:", err)
+	}
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	boolean := &btf.Int{Name: "bool", Size: 1, Encoding: btf.Bool}
+	ds := &btf.Datasec{
+		Name: ".kconfig",
+		Size: 16,
+		Vars: []btf.VarSecinfo{
+			{Type: &btf.Var{Name: "LINUX_KERNEL_VERSION", Type: u32}, Offset: 0, Size: 4},
+			{Type: &btf.Var{Name: "CONFIG_HZ", Type: u32}, Offset: 4, Size: 4},
+			{Type: &btf.Var{Name: "CONFIG_BPF", Type: boolean}, Offset: 8, Size: 1},
+			{Type: &btf.Var{Name: "CONFIG_DOES_NOT_EXIST", Type: u32}, Offset: 12, Size: 4},
+		},
+	}
+
+	spec := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".kconfig": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  ds.Size,
+				MaxEntries: 1,
+				Flags:      unix.BPF_F_RDONLY_PROG,
+				Freeze:     true,
+				Value:      ds,
+			},
+		},
+	}
+
+	coll, err := NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	if spec.Maps[".kconfig"].Contents != nil {
+		t.Error("NewCollection modified the spec")
+	}
+
+	var have []byte
+	if err := coll.Maps[".kconfig"].Lookup(uint32(0), &have); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := internal.KernelVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, ds.Size)
+	internal.NativeEndian.PutUint32(want[0:], kv.Kernel())
+	internal.NativeEndian.PutUint32(want[4:], uint32(hz))
+	want[8] = 1
+	if !bytes.Equal(have, want) {
+		t.Errorf("Expected contents %v, got %v", want, have)
+	}
+
+	spec.Maps[".kconfig"].Value = &btf.Datasec{
+		Name: ".kconfig",
+		Size: 4,
+		Vars: []btf.VarSecinfo{
+			{Type: &btf.Var{Name: "UNKNOWN", Type: u32}, Offset: 0, Size: 4},
+		},
+	}
+	spec.Maps[".kconfig"].ValueSize = 4
+	if _, err := NewCollection(spec); !errors.Is(err, ErrNotSupported) {
+		t.Error("Expected ErrNotSupported for unknown extern, got", err)
+	}
+}
+
+func TestCollectionSpecForeignByteOrder(t *testing.T) {
+	foreign := "eb"
+	if internal.ClangEndian == "eb" {
+		foreign = "el"
+	}
+
+	spec, err := LoadCollectionSpec(fmt.Sprintf("testdata/loader-%s.elf", foreign))
+	qt.Assert(t, err, qt.IsNil)
+
+	_, err = NewCollection(spec)
+	qt.Assert(t, err, qt.ErrorMatches, fmt.Sprintf("object compiled for bpf%s .* requires bpf%s .*", foreign, internal.ClangEndian))
+}
+
 func TestCollectionSpecRewriteMaps(t *testing.T) {
 	insns := asm.Instructions{
 		// R1 map
@@ -257,6 +462,50 @@ func TestCollectionSpecMapReplacements(t *testing.T) {
 		t.Fatalf("failed to update replaced map: %s", err)
 	}
 }
+
+func TestCollectionSpecMapReplacements_Shared(t *testing.T) {
+	cs := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".rodata": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Flags:      unix.BPF_F_RDONLY_PROG,
+				Freeze:     true,
+				Contents:   []MapKV{{uint32(0), uint32(1)}},
+			},
+		},
+	}
+
+	first, err := NewCollection(cs)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// Load the spec again with different contents, sharing the frozen map.
+	cs.Maps[".rodata"].Contents = []MapKV{{uint32(0), uint32(2)}}
+	second, err := NewCollectionWithOptions(cs, CollectionOptions{
+		MapReplacements: map[string]*Map{
+			".rodata": first.Maps[".rodata"],
+		},
+	})
+	if err != nil {
+		t.Fatal("Can't share frozen map:", err)
+	}
+	defer second.Close()
+
+	var value uint32
+	if err := second.Maps[".rodata"].Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 1 {
+		t.Error("Replacement map was overwritten, got value", value)
+	}
+}
+
 func TestCollectionSpecMapReplacements_NonExistingMap(t *testing.T) {
 	cs := &CollectionSpec{
 		Maps: map[string]*MapSpec{
@@ -321,6 +570,129 @@ func TestCollectionSpecMapReplacements_SpecMismatch(t *testing.T) {
 	if !errors.Is(err, ErrMapIncompatible) {
 		t.Fatalf("Overriding a map with a mismatching spec failed with the wrong error")
 	}
+
+	var mie *MapIncompatibleError
+	if !errors.As(err, &mie) {
+		t.Fatal("Error doesn't wrap MapIncompatibleError:", err)
+	}
+	if mie.Field != "ValueSize" || mie.Expected != uint32(4) || mie.Actual != uint32(8) {
+		t.Errorf("Unexpected mismatch %+v", mie)
+	}
+}
+
+type fakeUpdater struct {
+	prog *Program
+	err  error
+}
+
+func (fu *fakeUpdater) Update(prog *Program) error {
+	if fu.err != nil {
+		return fu.err
+	}
+	fu.prog = prog
+	return nil
+}
+
+func TestReplaceCollection(t *testing.T) {
+	newSpec := func(ret int32, resizedEntries uint32) *CollectionSpec {
+		arraySpec := func(maxEntries uint32) *MapSpec {
+			return &MapSpec{
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: maxEntries,
+				Contents:   []MapKV{{uint32(0), uint32(ret)}},
+			}
+		}
+
+		return &CollectionSpec{
+			Maps: map[string]*MapSpec{
+				"state":   arraySpec(1),
+				"resized": arraySpec(resizedEntries),
+				"reset":   arraySpec(1),
+			},
+			Programs: map[string]*ProgramSpec{
+				"prog": {
+					Type: SocketFilter,
+					Instructions: asm.Instructions{
+						asm.LoadMapPtr(asm.R1, 0).WithReference("state"),
+						asm.LoadMapPtr(asm.R1, 0).WithReference("resized"),
+						asm.LoadMapPtr(asm.R1, 0).WithReference("reset"),
+						asm.Mov.Imm(asm.R0, ret),
+						asm.Return(),
+					},
+					License: "MIT",
+				},
+			},
+		}
+	}
+
+	lookup := func(t *testing.T, coll *Collection, name string) uint32 {
+		t.Helper()
+
+		var value uint32
+		if err := coll.Maps[name].Lookup(uint32(0), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	oldColl, err := NewCollection(newSpec(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldColl.Close()
+
+	for _, name := range []string{"state", "resized", "reset"} {
+		if err := oldColl.Maps[name].Put(uint32(0), uint32(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok, fail := &fakeUpdater{}, &fakeUpdater{err: errors.New("failed")}
+	_, err = ReplaceCollection(oldColl, newSpec(2, 2), ReplaceOptions{
+		Links: map[string][]ProgramUpdater{"prog": {ok, fail}},
+	})
+	if err == nil {
+		t.Fatal("ReplaceCollection doesn't return an error from Update")
+	}
+	if ok.prog != oldColl.Programs["prog"] {
+		t.Fatal("Link wasn't reverted to the old program")
+	}
+
+	_, err = ReplaceCollection(oldColl, newSpec(2, 2), ReplaceOptions{
+		Links: map[string][]ProgramUpdater{"missing": {ok}},
+	})
+	if err == nil {
+		t.Fatal("ReplaceCollection accepts links for unknown programs")
+	}
+
+	newColl, err := ReplaceCollection(oldColl, newSpec(2, 2), ReplaceOptions{
+		Links:        map[string][]ProgramUpdater{"prog": {ok}},
+		RecreateMaps: []string{"reset"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newColl.Close()
+
+	if ok.prog != newColl.Programs["prog"] {
+		t.Error("Link wasn't updated to the new program")
+	}
+
+	if v := lookup(t, newColl, "state"); v != 100 {
+		t.Errorf("Compatible map wasn't reused, value is %d", v)
+	}
+	if v := lookup(t, newColl, "resized"); v != 2 {
+		t.Errorf("Incompatible map wasn't recreated, value is %d", v)
+	}
+	if v := lookup(t, newColl, "reset"); v != 2 {
+		t.Errorf("Map from RecreateMaps wasn't recreated, value is %d", v)
+	}
+
+	if oldColl.Programs["prog"].FD() != -1 {
+		t.Error("Old collection wasn't closed")
+	}
 }
 
 func TestCollectionSpec_LoadAndAssign_LazyLoading(t *testing.T) {
@@ -434,6 +806,311 @@ func TestCollectionAssign(t *testing.T) {
 	}
 }
 
+func TestCollectionAssignLoaded(t *testing.T) {
+	spec := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"map1": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"prog1": {
+				Type: SocketFilter,
+				Instructions: asm.Instructions{
+					asm.LoadImm(asm.R0, 0, asm.DWord),
+					asm.Return(),
+				},
+				License: "MIT",
+			},
+		},
+	}
+
+	coll, err := NewCollection(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	var objs struct {
+		Program *Program `ebpf:"prog1"`
+		Map     *Map     `ebpf:"map1"`
+	}
+
+	if err := coll.Assign(&struct {
+		Program *Program `ebpf:"prog1"`
+		Missing *Map     `ebpf:"missing"`
+	}{}); err == nil {
+		t.Fatal("Assign doesn't return an error for a missing map")
+	}
+	if coll.Programs["prog1"] == nil {
+		t.Fatal("Failed Assign detached a program")
+	}
+
+	if err := coll.Assign(&objs); err != nil {
+		t.Fatal("Can't assign collection:", err)
+	}
+	defer objs.Program.Close()
+	defer objs.Map.Close()
+
+	if objs.Program == nil || objs.Map == nil {
+		t.Fatal("Assign didn't populate all fields")
+	}
+
+	if len(coll.Programs) != 0 || len(coll.Maps) != 0 {
+		t.Error("Assigned objects weren't detached from the collection")
+	}
+}
+
+func TestCollectionLazyPrograms(t *testing.T) {
+	spec := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"map1": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Contents:   []MapKV{{uint32(0), uint32(42)}},
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"good": {
+				Type: SocketFilter,
+				Instructions: asm.Instructions{
+					asm.LoadMapValue(asm.R0, 0, 0).WithReference("map1"),
+					asm.LoadMem(asm.R0, asm.R0, 0, asm.Word),
+					asm.Return(),
+				},
+				License: "MIT",
+			},
+			"bad": {
+				Type: SocketFilter,
+				Instructions: asm.Instructions{
+					asm.BuiltinFunc(0xffff).Call(),
+					asm.Return(),
+				},
+				License: "MIT",
+			},
+		},
+	}
+
+	coll, err := NewCollectionWithOptions(spec, CollectionOptions{LazyPrograms: true})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal("Lazy collection loads programs:", err)
+	}
+	defer coll.Close()
+
+	if len(coll.Programs) != 0 {
+		t.Fatal("Programs were loaded eagerly")
+	}
+
+	prog, err := coll.Program("good")
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal("Can't load program:", err)
+	}
+
+	if again, err := coll.Program("good"); err != nil || again != prog {
+		t.Error("Program doesn't return the loaded program:", err)
+	}
+	if coll.Programs["good"] != prog {
+		t.Error("Loaded program is missing from Programs")
+	}
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 42 {
+		t.Error("Program doesn't use the collection's map, got", ret)
+	}
+
+	if _, err := coll.Program("bad"); err == nil {
+		t.Error("Loading an invalid program doesn't return an error")
+	}
+	if _, err := coll.Program("missing"); err == nil {
+		t.Error("Loading a missing program doesn't return an error")
+	}
+
+	m := coll.DetachMap("map1")
+	defer m.Close()
+	delete(coll.Programs, "good")
+	defer prog.Close()
+	if _, err := coll.Program("good"); err == nil {
+		t.Error("Loading a program using a detached map doesn't return an error")
+	}
+	if len(coll.Maps) != 0 {
+		t.Error("Loading a program recreated a detached map")
+	}
+}
+
+func TestCollectionProgramOverrides(t *testing.T) {
+	prog := func() *ProgramSpec {
+		return &ProgramSpec{
+			Type: SocketFilter,
+			Instructions: asm.Instructions{
+				asm.LoadImm(asm.R0, 0, asm.DWord),
+				asm.Return(),
+			},
+			License: "MIT",
+		}
+	}
+
+	spec := &CollectionSpec{
+		Programs: map[string]*ProgramSpec{
+			"quiet":   prog(),
+			"verbose": prog(),
+		},
+	}
+
+	coll, err := NewCollectionWithOptions(spec, CollectionOptions{
+		ProgramOverrides: map[string]ProgramOptions{
+			"verbose": {LogLevel: 1},
+		},
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	qt.Assert(t, coll.Programs["quiet"].VerifierLog, qt.Equals, "")
+	qt.Assert(t, coll.Programs["verbose"].VerifierLog, qt.Not(qt.Equals), "")
+
+	_, err = NewCollectionWithOptions(spec, CollectionOptions{
+		ProgramOverrides: map[string]ProgramOptions{"missing": {}},
+	})
+	qt.Assert(t, err, qt.ErrorMatches, ".*missing.*not found.*")
+}
+
+func TestCollectionPin(t *testing.T) {
+	spec := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".rodata%": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"prog1": {
+				Type: SocketFilter,
+				Instructions: asm.Instructions{
+					asm.LoadImm(asm.R0, 0, asm.DWord),
+					asm.Return(),
+				},
+				License: "MIT",
+			},
+		},
+	}
+
+	coll, err := NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	dir := testutils.TempBPFFS(t)
+	qt.Assert(t, coll.Pin(dir), qt.IsNil)
+	qt.Assert(t, coll.Maps[".rodata%"].IsPinned(), qt.IsTrue)
+	qt.Assert(t, coll.Programs["prog1"].IsPinned(), qt.IsTrue)
+
+	pinned, err := LoadPinnedCollection(dir, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer pinned.Close()
+
+	qt.Assert(t, pinned.Maps, qt.HasLen, 1)
+	qt.Assert(t, pinned.Programs, qt.HasLen, 1)
+
+	info, err := pinned.Maps[".rodata%"].Info()
+	qt.Assert(t, err, qt.IsNil)
+	want, err := coll.Maps[".rodata%"].Info()
+	qt.Assert(t, err, qt.IsNil)
+	id, _ := info.ID()
+	wantID, _ := want.ID()
+	qt.Assert(t, id, qt.Equals, wantID)
+
+	qt.Assert(t, pinned.Programs["prog1"].Type(), qt.Equals, SocketFilter)
+
+	// Pinning to a location which isn't a bpffs fails without leaving
+	// anything behind.
+	coll2, err := NewCollection(spec)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll2.Close()
+
+	qt.Assert(t, coll2.Pin(t.TempDir()), qt.IsNotNil)
+	qt.Assert(t, coll2.Maps[".rodata%"].IsPinned(), qt.IsFalse)
+
+	// A missing directory results in an empty Collection.
+	empty, err := LoadPinnedCollection(filepath.Join(dir, "missing"), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, empty.Maps, qt.HasLen, 0)
+}
+
+func TestCollectionPinByName(t *testing.T) {
+	spec := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"shared": {
+				Name:       "shared",
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Pinning:    PinByName,
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"prog1": {
+				Type: SocketFilter,
+				Instructions: asm.Instructions{
+					asm.LoadImm(asm.R0, 0, asm.DWord),
+					asm.Return(),
+				},
+				License: "MIT",
+			},
+		},
+	}
+
+	pinPath := testutils.TempBPFFS(t)
+	coll, err := NewCollectionWithOptions(spec, CollectionOptions{
+		Maps: MapOptions{PinPath: pinPath},
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	byName := filepath.Join(pinPath, "shared")
+
+	// The pin created by PinByName stays where it is.
+	dir := testutils.TempBPFFS(t)
+	qt.Assert(t, coll.Pin(dir), qt.IsNil)
+	_, err = os.Stat(byName)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = os.Stat(filepath.Join(dir, pinnedMapsDir, "shared"))
+	qt.Assert(t, err, qt.IsNil)
+
+	// Occupy the path of prog1 so that pinning fails after the map has been
+	// pinned.
+	prog, err := NewProgram(spec.Programs["prog1"])
+	qt.Assert(t, err, qt.IsNil)
+	defer prog.Close()
+
+	dir2 := testutils.TempBPFFS(t)
+	qt.Assert(t, os.Mkdir(filepath.Join(dir2, pinnedProgramsDir), 0755), qt.IsNil)
+	qt.Assert(t, prog.Pin(filepath.Join(dir2, pinnedProgramsDir, "prog1")), qt.IsNil)
+
+	qt.Assert(t, coll.Pin(dir2), qt.IsNotNil)
+
+	// Only the pins created by the failed call are removed.
+	_, err = os.Stat(byName)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = os.Stat(filepath.Join(dir2, pinnedMapsDir, "shared"))
+	qt.Assert(t, errors.Is(err, os.ErrNotExist), qt.IsTrue)
+	qt.Assert(t, coll.Maps["shared"].IsPinned(), qt.IsTrue)
+}
+
 func TestAssignValues(t *testing.T) {
 	zero := func(t reflect.Type, name string) (interface{}, error) {
 		return reflect.Zero(t).Interface(), nil
@@ -579,3 +1256,162 @@ func ExampleCollectionSpec_LoadAndAssign() {
 	// Output: SocketFilter
 	// Array
 }
+
+func TestCollectionSpecMarshalJSON(t *testing.T) {
+	cs := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"hash": {
+				Type:       Hash,
+				KeySize:    4,
+				ValueSize:  8,
+				MaxEntries: 1,
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"prog": {
+				Type:        SocketFilter,
+				SectionName: "socket",
+				License:     "MIT",
+				Instructions: asm.Instructions{
+					asm.LoadMapPtr(asm.R1, 0).WithReference("hash"),
+					asm.FnKtimeGetNs.Call(),
+					asm.FnGetPrandomU32.Call(),
+					asm.FnKtimeGetNs.Call(),
+					asm.Return(),
+				},
+			},
+		},
+	}
+
+	b, err := json.Marshal(cs)
+	qt.Assert(t, err, qt.IsNil)
+
+	var summary struct {
+		Maps     map[string]map[string]interface{}
+		Programs map[string]struct {
+			Section      string
+			Instructions int
+			Helpers      []string
+			Maps         []string
+		}
+	}
+	qt.Assert(t, json.Unmarshal(b, &summary), qt.IsNil)
+
+	qt.Assert(t, summary.Maps["hash"]["type"], qt.Equals, "Hash")
+	qt.Assert(t, summary.Maps["hash"]["value_size"], qt.Equals, float64(8))
+
+	prog := summary.Programs["prog"]
+	qt.Assert(t, prog.Section, qt.Equals, "socket")
+	qt.Assert(t, prog.Instructions, qt.Equals, 5)
+	qt.Assert(t, prog.Helpers, qt.DeepEquals, []string{"FnGetPrandomU32", "FnKtimeGetNs"})
+	qt.Assert(t, prog.Maps, qt.DeepEquals, []string{"hash"})
+
+	spec, err := LoadCollectionSpec(fmt.Sprintf("testdata/loader-%s.elf", internal.ClangEndian))
+	qt.Assert(t, err, qt.IsNil)
+
+	b, err = json.Marshal(spec)
+	qt.Assert(t, err, qt.IsNil)
+
+	var types struct{ Types []string }
+	qt.Assert(t, json.Unmarshal(b, &types), qt.IsNil)
+	qt.Assert(t, types.Types, qt.Any(qt.Equals), `Typedef:"uint32_t"[Int:"unsigned int"]`)
+}
+
+func TestCollectionSpecVerify(t *testing.T) {
+	insns := asm.Instructions{
+		asm.LoadMapPtr(asm.R1, 0).WithReference("hash"),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+	}
+
+	cs := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"hash": {
+				Type:       Hash,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Pinning:    PinByName,
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"valid": {
+				Type:         SocketFilter,
+				License:      "MIT",
+				Instructions: insns,
+			},
+			"invalid": {
+				Type:    SocketFilter,
+				License: "MIT",
+				// R0 isn't initialized.
+				Instructions: insns[2:],
+			},
+			"unspecified": {
+				Instructions: insns,
+			},
+		},
+	}
+
+	pinPath := testutils.TempBPFFS(t)
+	results, err := cs.Verify(&CollectionOptions{
+		Maps: MapOptions{PinPath: pinPath},
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+
+	qt.Assert(t, results, qt.HasLen, 2)
+	qt.Assert(t, results["valid"], qt.IsNil)
+
+	var ve *VerifierError
+	qt.Assert(t, errors.As(results["invalid"], &ve), qt.IsTrue)
+
+	// Nothing was pinned and the spec is left alone.
+	_, err = os.Stat(filepath.Join(pinPath, "hash"))
+	qt.Assert(t, errors.Is(err, os.ErrNotExist), qt.IsTrue)
+	qt.Assert(t, cs.Maps["hash"].Pinning, qt.Equals, PinByName)
+}
+
+func TestCollectionSpecVerifyFrozenConstants(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.5", "frozen read-only maps")
+
+	cs := &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".rodata": {
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Flags:      unix.BPF_F_RDONLY_PROG,
+				Freeze:     true,
+				Contents:   []MapKV{{uint32(0), uint32(1)}},
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"gated": {
+				Type:    SocketFilter,
+				License: "MIT",
+				Instructions: asm.Instructions{
+					asm.LoadMapValue(asm.R1, 0, 0).WithReference(".rodata"),
+					asm.LoadMem(asm.R1, asm.R1, 0, asm.Word),
+					asm.JEq.Imm(asm.R1, 0, "disabled"),
+					asm.Mov.Imm(asm.R0, 0),
+					asm.Return(),
+					// R0 isn't initialized, this is only accepted if the
+					// verifier knows that the branch is dead.
+					asm.Return().WithSymbol("disabled"),
+				},
+			},
+		},
+	}
+
+	results, err := cs.Verify(nil)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results["gated"], qt.IsNil)
+
+	// Enabling the branch makes the verifier reject the program.
+	cs.Maps[".rodata"].Contents = []MapKV{{uint32(0), uint32(0)}}
+	results, err = cs.Verify(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results["gated"], qt.IsNotNil)
+}
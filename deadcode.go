@@ -0,0 +1,535 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// EliminateDeadCode removes instructions which can never execute because
+// they are guarded by a branch on a constant.
+//
+// Constants are 64 bit immediate loads, for example those modified by
+// RewriteConstants. Call the method after replacing constants to prevent
+// the verifier from rejecting code which is disabled at load time, for
+// example because it uses helpers missing from the running kernel.
+//
+// See CollectionSpec.EliminateDeadCode to take constants stored in .rodata
+// into account.
+func (ps *ProgramSpec) EliminateDeadCode() error {
+	insns, err := eliminateDeadCode(ps.Instructions, ps.ByteOrder, nil)
+	if err != nil {
+		return fmt.Errorf("program %s: %w", ps.Name, err)
+	}
+
+	ps.Instructions = insns
+	return nil
+}
+
+// EliminateDeadCode removes instructions which can never execute from all
+// programs in the spec.
+//
+// In addition to immediate loads, constants are loaded from read-only data
+// sections such as .rodata. Call the method after RewriteConstants so that
+// the replaced values are taken into account.
+//
+// See ProgramSpec.EliminateDeadCode for details.
+func (cs *CollectionSpec) EliminateDeadCode() error {
+	data := make(map[string][]byte)
+	for name, spec := range cs.Maps {
+		if !spec.Freeze || spec.Flags&unix.BPF_F_RDONLY_PROG == 0 {
+			continue
+		}
+
+		if len(spec.Contents) != 1 {
+			continue
+		}
+
+		if value, ok := spec.Contents[0].Value.([]byte); ok {
+			data[name] = value
+		}
+	}
+
+	for name, spec := range cs.Programs {
+		insns, err := eliminateDeadCode(spec.Instructions, spec.ByteOrder, data)
+		if err != nil {
+			return fmt.Errorf("program %s: %w", name, err)
+		}
+
+		spec.Instructions = insns
+	}
+
+	return nil
+}
+
+// eliminateDeadCode folds conditional jumps on known values and removes
+// unreachable instructions until no more changes occur.
+//
+// data contains the contents of read-only maps, indexed by the reference
+// used by direct value loads.
+func eliminateDeadCode(insns asm.Instructions, bo binary.ByteOrder, data map[string][]byte) (asm.Instructions, error) {
+	if bo == nil {
+		bo = internal.NativeEndian
+	}
+
+	// Folding modifies instructions in place.
+	insns = append(asm.Instructions(nil), insns...)
+
+	for {
+		cfg, err := newControlFlow(insns)
+		if err != nil {
+			return nil, err
+		}
+
+		folded := cfg.foldBranches(bo, data)
+		live := cfg.reachable(folded)
+
+		removed := false
+		for i := range insns {
+			if !live[i] || folded[i] {
+				removed = true
+				break
+			}
+		}
+
+		if !removed {
+			return insns, nil
+		}
+
+		insns, err = cfg.remove(live, folded)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// controlFlow contains the jump targets of a sequence of instructions.
+type controlFlow struct {
+	insns asm.Instructions
+	// Raw offset of each instruction. Has one extra element for the end of
+	// the program.
+	offsets []asm.RawInstructionOffset
+	// Index of the instruction targeted by a jump or function reference, or
+	// -1.
+	targets []int
+	// Whether the target is encoded in Offset or Constant, as opposed to a
+	// symbol reference.
+	raw []bool
+}
+
+func newControlFlow(insns asm.Instructions) (*controlFlow, error) {
+	cfg := &controlFlow{
+		insns:   insns,
+		offsets: make([]asm.RawInstructionOffset, 0, len(insns)+1),
+		targets: make([]int, len(insns)),
+		raw:     make([]bool, len(insns)),
+	}
+
+	indices := make(map[asm.RawInstructionOffset]int)
+	iter := insns.Iterate()
+	for iter.Next() {
+		indices[iter.Offset] = iter.Index
+		cfg.offsets = append(cfg.offsets, iter.Offset)
+	}
+	end := asm.RawInstructionOffset(0)
+	if len(insns) > 0 {
+		last := len(insns) - 1
+		end = cfg.offsets[last] + asm.RawInstructionOffset(insns[last].Size()/asm.InstructionSize)
+	}
+	cfg.offsets = append(cfg.offsets, end)
+
+	symbols, err := insns.SymbolOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range insns {
+		ins := &insns[i]
+		cfg.targets[i] = -1
+
+		var rel int64
+		switch {
+		case ins.IsFunctionReference():
+			if ins.Constant == -1 && ins.Reference() != "" {
+				// Unsatisfied references are reported when loading the
+				// program.
+				if target, ok := symbols[ins.Reference()]; ok {
+					cfg.targets[i] = target
+				}
+				continue
+			}
+			rel = ins.Constant
+
+		case ins.OpCode.Class().IsJump():
+			switch op := ins.OpCode.JumpOp(); {
+			case op == asm.Call, op == asm.Exit:
+				continue
+			case op == asm.Ja && ins.OpCode.Class() == asm.Jump32Class:
+				return nil, fmt.Errorf("instruction %d: unsupported jump %v", i, ins.OpCode)
+			}
+
+			if ins.Offset == -1 && ins.Reference() != "" {
+				// Unsatisfied references are reported when loading the
+				// program.
+				if target, ok := symbols[ins.Reference()]; ok {
+					cfg.targets[i] = target
+				}
+				continue
+			}
+			rel = int64(ins.Offset)
+
+		default:
+			continue
+		}
+
+		target, ok := indices[asm.RawInstructionOffset(int64(cfg.offsets[i])+1+rel)]
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: jump to invalid offset %d", i, rel)
+		}
+
+		cfg.targets[i] = target
+		cfg.raw[i] = true
+	}
+
+	return cfg, nil
+}
+
+// leaders returns the instructions which start a basic block.
+func (cfg *controlFlow) leaders() []bool {
+	leaders := make([]bool, len(cfg.insns))
+	if len(leaders) > 0 {
+		leaders[0] = true
+	}
+
+	for i := range cfg.insns {
+		ins := &cfg.insns[i]
+
+		if ins.Symbol() != "" {
+			leaders[i] = true
+		}
+
+		if target := cfg.targets[i]; target >= 0 {
+			leaders[target] = true
+		}
+
+		if ins.OpCode.Class().IsJump() && i+1 < len(leaders) {
+			leaders[i+1] = true
+		}
+	}
+
+	return leaders
+}
+
+// foldBranches evaluates conditional jumps whose operands are known.
+//
+// Jumps which are always taken are converted into unconditional jumps in
+// place. Returns the jumps which are never taken.
+func (cfg *controlFlow) foldBranches(bo binary.ByteOrder, data map[string][]byte) []bool {
+	leaders := cfg.leaders()
+	never := make([]bool, len(cfg.insns))
+
+	var regs registerValues
+	for i := range cfg.insns {
+		ins := &cfg.insns[i]
+
+		if leaders[i] {
+			regs = registerValues{}
+		}
+
+		if !ins.OpCode.Class().IsJump() {
+			regs.update(ins, bo, data)
+			continue
+		}
+
+		switch ins.OpCode.JumpOp() {
+		case asm.Ja:
+			// Jumps to the next instruction are no-ops, unless they carry a
+			// label.
+			if cfg.targets[i] == i+1 && ins.Symbol() == "" {
+				never[i] = true
+			}
+			continue
+
+		case asm.Exit:
+			continue
+
+		case asm.Call:
+			for r := asm.R0; r <= asm.R5; r++ {
+				regs.clobber(r)
+			}
+			continue
+		}
+
+		taken, ok := regs.evaluate(ins)
+		if !ok {
+			continue
+		}
+
+		if !taken {
+			never[i] = true
+			continue
+		}
+
+		ins.OpCode = asm.Ja.Op(asm.ImmSource)
+		ins.Dst, ins.Src, ins.Constant = asm.R0, asm.R0, 0
+	}
+
+	return never
+}
+
+// reachable returns the instructions which may execute when entering the
+// program at the first instruction. Jumps which are never taken are treated
+// as falling through.
+func (cfg *controlFlow) reachable(never []bool) []bool {
+	live := make([]bool, len(cfg.insns))
+
+	var queue []int
+	visit := func(i int) {
+		if i >= 0 && i < len(live) && !live[i] {
+			live[i] = true
+			queue = append(queue, i)
+		}
+	}
+
+	visit(0)
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		if never[i] {
+			visit(i + 1)
+			continue
+		}
+
+		ins := &cfg.insns[i]
+		visit(cfg.targets[i])
+
+		switch ins.OpCode.JumpOp() {
+		case asm.Exit:
+			continue
+		case asm.Ja:
+			continue
+		}
+
+		visit(i + 1)
+	}
+
+	return live
+}
+
+// remove drops instructions which are not live or which are marked as
+// removable, and updates the raw offsets of the remaining jumps.
+func (cfg *controlFlow) remove(live, removable []bool) (asm.Instructions, error) {
+	keep := make([]bool, len(cfg.insns))
+	for i, ins := range cfg.insns {
+		keep[i] = live[i] && !removable[i]
+
+		// A removable jump may carry a label or function metadata. Turn it
+		// into a no-op instead.
+		if live[i] && removable[i] && ins.Symbol() != "" {
+			cfg.insns[i].OpCode = asm.Ja.Op(asm.ImmSource)
+			cfg.insns[i].Dst, cfg.insns[i].Src = asm.R0, asm.R0
+			cfg.insns[i].Offset, cfg.insns[i].Constant = 0, 0
+			cfg.raw[i] = true
+			cfg.targets[i] = i + 1
+			keep[i] = true
+		}
+	}
+
+	// Compute the new raw offset of each instruction. Instructions which are
+	// dropped map to the offset of the next remaining instruction.
+	offsets := make([]asm.RawInstructionOffset, len(cfg.insns)+1)
+	var offset asm.RawInstructionOffset
+	for i := range cfg.insns {
+		offsets[i] = offset
+		if keep[i] {
+			offset += cfg.offsets[i+1] - cfg.offsets[i]
+		}
+	}
+	offsets[len(cfg.insns)] = offset
+
+	insns := make(asm.Instructions, 0, len(cfg.insns))
+	for i, ins := range cfg.insns {
+		if !keep[i] {
+			continue
+		}
+
+		if cfg.raw[i] {
+			rel := int64(offsets[cfg.targets[i]]) - int64(offsets[i]) - 1
+			if ins.IsFunctionReference() {
+				ins.Constant = rel
+			} else {
+				if rel < math.MinInt16 || rel > math.MaxInt16 {
+					return nil, fmt.Errorf("instruction %d: jump offset %d out of range", i, rel)
+				}
+				ins.Offset = int16(rel)
+			}
+		}
+
+		insns = append(insns, ins)
+	}
+
+	if len(insns) == 0 {
+		return nil, errors.New("no instructions left")
+	}
+
+	return insns, nil
+}
+
+// registerValues tracks registers which contain known scalars or pointers
+// into read-only data.
+type registerValues struct {
+	known [asm.R10 + 1]bool
+	value [asm.R10 + 1]int64
+
+	// Registers pointing into read-only data, and their offset.
+	data   [asm.R10 + 1][]byte
+	offset [asm.R10 + 1]int64
+}
+
+func (rv *registerValues) clobber(r asm.Register) {
+	if r > asm.R10 {
+		return
+	}
+	rv.known[r] = false
+	rv.data[r] = nil
+}
+
+func (rv *registerValues) set(r asm.Register, value int64) {
+	rv.clobber(r)
+	rv.known[r] = true
+	rv.value[r] = value
+}
+
+// update records the effect of a non-jump instruction.
+func (rv *registerValues) update(ins *asm.Instruction, bo binary.ByteOrder, data map[string][]byte) {
+	op := ins.OpCode
+	switch cls := op.Class(); {
+	case ins.IsConstantLoad(asm.DWord):
+		rv.set(ins.Dst, ins.Constant)
+
+	case ins.IsLoadFromMap() && ins.Src == asm.PseudoMapValue:
+		rv.clobber(ins.Dst)
+		if contents, ok := data[ins.Reference()]; ok {
+			rv.data[ins.Dst] = contents
+			rv.offset[ins.Dst] = int64(uint32(uint64(ins.Constant) >> 32))
+		}
+
+	case cls == asm.LdXClass && op.Mode() == asm.MemMode:
+		contents := rv.data[ins.Src]
+		start := rv.offset[ins.Src] + int64(ins.Offset)
+		size := int64(op.Size().Sizeof())
+		rv.clobber(ins.Dst)
+
+		if contents == nil || start < 0 || start+size > int64(len(contents)) {
+			return
+		}
+
+		buf := contents[start : start+size]
+		switch size {
+		case 1:
+			rv.set(ins.Dst, int64(buf[0]))
+		case 2:
+			rv.set(ins.Dst, int64(bo.Uint16(buf)))
+		case 4:
+			rv.set(ins.Dst, int64(bo.Uint32(buf)))
+		case 8:
+			rv.set(ins.Dst, int64(bo.Uint64(buf)))
+		}
+
+	case cls.IsALU() && op.ALUOp() == asm.Mov:
+		var value int64
+		if op.Source() == asm.ImmSource {
+			value = int64(int32(ins.Constant))
+		} else if ins.Src <= asm.R10 && rv.known[ins.Src] {
+			value = rv.value[ins.Src]
+		} else {
+			rv.clobber(ins.Dst)
+			return
+		}
+
+		if cls == asm.ALUClass {
+			value = int64(uint32(value))
+		}
+		rv.set(ins.Dst, value)
+
+	case cls == asm.ALU64Class && op.ALUOp() == asm.Add && op.Source() == asm.ImmSource && rv.data[ins.Dst] != nil:
+		rv.offset[ins.Dst] += int64(int32(ins.Constant))
+
+	case cls == asm.StClass, cls == asm.StXClass && op.Mode() == asm.MemMode:
+		// Stores to memory don't modify registers.
+
+	case cls == asm.StXClass:
+		// Atomic operations may write to the source register and R0.
+		rv.clobber(ins.Src)
+		rv.clobber(asm.R0)
+
+	case cls == asm.LdClass && (op.Mode() == asm.AbsMode || op.Mode() == asm.IndMode):
+		// Legacy packet loads clobber the caller saved registers.
+		for r := asm.R0; r <= asm.R5; r++ {
+			rv.clobber(r)
+		}
+
+	default:
+		rv.clobber(ins.Dst)
+	}
+}
+
+// evaluate returns whether a conditional jump is taken, if its operands
+// are known.
+func (rv *registerValues) evaluate(ins *asm.Instruction) (taken bool, ok bool) {
+	if ins.Dst > asm.R10 || !rv.known[ins.Dst] {
+		return false, false
+	}
+	dst := rv.value[ins.Dst]
+
+	var src int64
+	if ins.OpCode.Source() == asm.ImmSource {
+		src = int64(int32(ins.Constant))
+	} else {
+		if ins.Src > asm.R10 || !rv.known[ins.Src] {
+			return false, false
+		}
+		src = rv.value[ins.Src]
+	}
+
+	if ins.OpCode.Class() == asm.Jump32Class {
+		return compare(ins.OpCode.JumpOp(), int64(int32(dst)), int64(int32(src)), uint64(uint32(dst)), uint64(uint32(src)))
+	}
+
+	return compare(ins.OpCode.JumpOp(), dst, src, uint64(dst), uint64(src))
+}
+
+func compare(op asm.JumpOp, sdst, ssrc int64, udst, usrc uint64) (bool, bool) {
+	switch op {
+	case asm.JEq:
+		return udst == usrc, true
+	case asm.JNE:
+		return udst != usrc, true
+	case asm.JGT:
+		return udst > usrc, true
+	case asm.JGE:
+		return udst >= usrc, true
+	case asm.JLT:
+		return udst < usrc, true
+	case asm.JLE:
+		return udst <= usrc, true
+	case asm.JSet:
+		return udst&usrc != 0, true
+	case asm.JSGT:
+		return sdst > ssrc, true
+	case asm.JSGE:
+		return sdst >= ssrc, true
+	case asm.JSLT:
+		return sdst < ssrc, true
+	case asm.JSLE:
+		return sdst <= ssrc, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,111 @@
+package ebpf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// deadCodeInsns branches on the value in R1 to either return 1, or call
+// an invalid helper.
+func deadCodeInsns(load ...asm.Instruction) asm.Instructions {
+	insns := append(asm.Instructions{}, load...)
+	return append(insns,
+		asm.Instruction{OpCode: asm.JEq.Op(asm.ImmSource), Dst: asm.R1, Constant: 0, Offset: 3},
+		asm.BuiltinFunc(0xffff).Call(),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.Return(),
+	)
+}
+
+func formatInsns(insns asm.Instructions) []string {
+	var out []string
+	for _, ins := range insns {
+		out = append(out, fmt.Sprint(ins))
+	}
+	return out
+}
+
+func TestProgramSpecEliminateDeadCode(t *testing.T) {
+	c := qt.New(t)
+
+	spec := &ProgramSpec{
+		Type:    SocketFilter,
+		License: "MIT",
+		Instructions: deadCodeInsns(
+			asm.LoadImm(asm.R1, 1, asm.DWord).WithReference("feature"),
+		),
+	}
+
+	unknown := &ProgramSpec{Instructions: deadCodeInsns()}
+	c.Assert(unknown.EliminateDeadCode(), qt.IsNil)
+	c.Assert(formatInsns(unknown.Instructions), qt.DeepEquals, formatInsns(deadCodeInsns()),
+		qt.Commentf("unknown values shouldn't change instructions"))
+
+	c.Assert(spec.RewriteConstants(map[string]interface{}{"feature": 0}), qt.IsNil)
+	c.Assert(spec.EliminateDeadCode(), qt.IsNil)
+	c.Assert(formatInsns(spec.Instructions), qt.DeepEquals, formatInsns(asm.Instructions{
+		asm.LoadImm(asm.R1, 0, asm.DWord).WithReference("feature"),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.Return(),
+	}))
+
+	prog, err := NewProgram(spec)
+	testutils.SkipIfNotSupported(t, err)
+	c.Assert(err, qt.IsNil)
+	defer prog.Close()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ret, qt.Equals, uint32(1))
+}
+
+func TestCollectionSpecEliminateDeadCode(t *testing.T) {
+	c := qt.New(t)
+
+	newSpec := func(value byte) *CollectionSpec {
+		return &CollectionSpec{
+			Maps: map[string]*MapSpec{
+				".rodata": {
+					Type:       Array,
+					KeySize:    4,
+					ValueSize:  8,
+					MaxEntries: 1,
+					Flags:      unix.BPF_F_RDONLY_PROG,
+					Freeze:     true,
+					Contents:   []MapKV{{uint32(0), []byte{0, 0, 0, 0, value, 0, 0, 0}}},
+				},
+			},
+			Programs: map[string]*ProgramSpec{
+				"prog": {
+					Type:    SocketFilter,
+					License: "MIT",
+					Instructions: deadCodeInsns(
+						asm.LoadMapValue(asm.R1, 0, 4).WithReference(".rodata"),
+						asm.LoadMem(asm.R1, asm.R1, 0, asm.Word),
+					),
+				},
+			},
+		}
+	}
+
+	spec := newSpec(1)
+	c.Assert(spec.EliminateDeadCode(), qt.IsNil)
+	insns := spec.Programs["prog"].Instructions
+	c.Assert(insns, qt.HasLen, 5)
+	c.Assert(insns[2].IsBuiltinCall(), qt.IsTrue)
+
+	spec = newSpec(0)
+	c.Assert(spec.EliminateDeadCode(), qt.IsNil)
+	insns = spec.Programs["prog"].Instructions
+	c.Assert(insns, qt.HasLen, 4)
+	c.Assert(insns[2].Constant, qt.Equals, int64(1))
+}
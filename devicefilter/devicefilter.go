@@ -0,0 +1,233 @@
+// Package devicefilter generates BPF_PROG_TYPE_CGROUP_DEVICE programs which
+// control access to device nodes in a cgroup.
+//
+// A program is generated from a list of rules, which are evaluated in
+// order. The first matching rule decides whether access is granted. If no
+// rule matches, the default action applies.
+package devicefilter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+)
+
+// Wildcard matches any major or minor number.
+const Wildcard = -1
+
+// DeviceType is the type of a device node.
+type DeviceType uint32
+
+// Equivalents of BPF_DEVCG_DEV_*.
+const (
+	// AnyDevice matches both block and character devices.
+	AnyDevice   DeviceType = 0
+	BlockDevice DeviceType = 1
+	CharDevice  DeviceType = 2
+)
+
+func (dt DeviceType) String() string {
+	switch dt {
+	case AnyDevice:
+		return "a"
+	case BlockDevice:
+		return "b"
+	case CharDevice:
+		return "c"
+	default:
+		return fmt.Sprintf("DeviceType(%d)", uint32(dt))
+	}
+}
+
+// Access is a combination of device access modes.
+type Access uint32
+
+// Equivalents of BPF_DEVCG_ACC_*.
+const (
+	AccessMknod Access = 1 << iota
+	AccessRead
+	AccessWrite
+
+	// AccessAll is the combination of all access modes.
+	AccessAll = AccessMknod | AccessRead | AccessWrite
+)
+
+func (a Access) String() string {
+	var s string
+	for _, mode := range []struct {
+		access Access
+		char   string
+	}{
+		{AccessRead, "r"},
+		{AccessWrite, "w"},
+		{AccessMknod, "m"},
+	} {
+		if a&mode.access != 0 {
+			s += mode.char
+		}
+	}
+	return s
+}
+
+// Rule matches device accesses.
+type Rule struct {
+	Type DeviceType
+	// Major and Minor numbers of the device, or Wildcard.
+	Major, Minor int64
+	// Access modes the rule applies to.
+	//
+	// An allow rule matches if all requested modes are contained in
+	// Access. A deny rule matches if any of the requested modes is
+	// contained in Access.
+	Access Access
+	Allow  bool
+}
+
+func (r Rule) String() string {
+	number := func(n int64) string {
+		if n == Wildcard {
+			return "*"
+		}
+		return fmt.Sprint(n)
+	}
+
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+
+	return fmt.Sprintf("%s %s %s:%s %s", action, r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+func (r Rule) validate() error {
+	if r.Type > CharDevice {
+		return fmt.Errorf("invalid device type %s", r.Type)
+	}
+	if r.Access == 0 || r.Access&^AccessAll != 0 {
+		return fmt.Errorf("invalid access %#x", uint32(r.Access))
+	}
+	for _, n := range []int64{r.Major, r.Minor} {
+		if n != Wildcard && (n < 0 || n > math.MaxInt32) {
+			return fmt.Errorf("invalid device number %d", n)
+		}
+	}
+	return nil
+}
+
+// Offsets into struct bpf_cgroup_dev_ctx.
+const (
+	ctxAccessType = 0
+	ctxMajor      = 4
+	ctxMinor      = 8
+)
+
+// Instructions generates a program which evaluates rules.
+func Instructions(rules []Rule, defaultAllow bool) (asm.Instructions, error) {
+	const (
+		typ    = asm.R2
+		access = asm.R3
+		major  = asm.R4
+		minor  = asm.R5
+	)
+
+	insns := asm.Instructions{
+		// access_type is (access << 16) | type.
+		asm.LoadMem(typ, asm.R1, ctxAccessType, asm.Word),
+		asm.Mov.Reg(access, typ),
+		asm.And.Imm(typ, 0xffff),
+		asm.RSh.Imm(access, 16),
+		asm.LoadMem(major, asm.R1, ctxMajor, asm.Word),
+		asm.LoadMem(minor, asm.R1, ctxMinor, asm.Word),
+	}
+
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rule, err)
+		}
+
+		next := fmt.Sprintf("rule_%d", i+1)
+
+		var block asm.Instructions
+		if rule.Type != AnyDevice {
+			block = append(block, asm.JNE.Imm(typ, int32(rule.Type), next))
+		}
+
+		block = append(block, asm.Mov.Reg(asm.R0, access))
+		if rule.Allow {
+			// Skip the rule if any requested mode isn't allowed.
+			block = append(block,
+				asm.And.Imm(asm.R0, int32(^rule.Access&AccessAll)),
+				asm.JNE.Imm(asm.R0, 0, next),
+			)
+		} else {
+			// Skip the rule if none of the requested modes are denied.
+			block = append(block,
+				asm.And.Imm(asm.R0, int32(rule.Access)),
+				asm.JEq.Imm(asm.R0, 0, next),
+			)
+		}
+
+		if rule.Major != Wildcard {
+			block = append(block, asm.JNE.Imm(major, int32(rule.Major), next))
+		}
+		if rule.Minor != Wildcard {
+			block = append(block, asm.JNE.Imm(minor, int32(rule.Minor), next))
+		}
+
+		block = append(block, returnVerdict(rule.Allow)...)
+		block[0] = block[0].WithSymbol(fmt.Sprintf("rule_%d", i))
+		insns = append(insns, block...)
+	}
+
+	verdict := returnVerdict(defaultAllow)
+	verdict[0] = verdict[0].WithSymbol(fmt.Sprintf("rule_%d", len(rules)))
+	return append(insns, verdict...), nil
+}
+
+func returnVerdict(allow bool) asm.Instructions {
+	var verdict int32
+	if allow {
+		verdict = 1
+	}
+
+	return asm.Instructions{
+		asm.Mov.Imm(asm.R0, verdict),
+		asm.Return(),
+	}
+}
+
+// NewProgram loads a program which evaluates rules.
+func NewProgram(rules []Rule, defaultAllow bool) (*ebpf.Program, error) {
+	insns, err := Instructions(rules, defaultAllow)
+	if err != nil {
+		return nil, err
+	}
+
+	return ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "device_filter",
+		Type:         ebpf.CGroupDevice,
+		License:      "MIT",
+		Instructions: insns,
+	})
+}
+
+// Attach loads a program which evaluates rules and attaches it to the
+// cgroupv2 at path.
+//
+// The program is detached when the link is closed.
+func Attach(path string, rules []Rule, defaultAllow bool) (link.Link, error) {
+	prog, err := NewProgram(rules, defaultAllow)
+	if err != nil {
+		return nil, err
+	}
+	defer prog.Close()
+
+	return link.AttachCgroup(link.CgroupOptions{
+		Path:    path,
+		Attach:  ebpf.AttachCGroupDevice,
+		Program: prog,
+	})
+}
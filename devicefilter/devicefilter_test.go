@@ -0,0 +1,124 @@
+package devicefilter
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestInstructions(t *testing.T) {
+	rules := []Rule{
+		{Type: CharDevice, Major: 1, Minor: 3, Access: AccessRead | AccessWrite, Allow: true},
+		{Type: AnyDevice, Major: Wildcard, Minor: Wildcard, Access: AccessMknod, Allow: false},
+		{Type: BlockDevice, Major: 8, Minor: Wildcard, Access: AccessAll, Allow: true},
+	}
+
+	insns, err := Instructions(rules, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each rule must be reachable via its label.
+	for i := 0; i <= len(rules); i++ {
+		found := false
+		for _, ins := range insns {
+			if ins.Symbol() == fmt.Sprintf("rule_%d", i) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Missing label for rule %d", i)
+		}
+	}
+
+	for _, rule := range []Rule{
+		{Type: 3, Access: AccessRead},
+		{Type: CharDevice, Access: 0},
+		{Type: CharDevice, Access: 8},
+		{Type: CharDevice, Major: -2, Access: AccessRead},
+		{Type: CharDevice, Minor: 1 << 32, Access: AccessRead},
+	} {
+		if _, err := Instructions([]Rule{rule}, false); err == nil {
+			t.Errorf("Rule %s should be rejected", rule)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	rule := Rule{Type: CharDevice, Major: 1, Minor: Wildcard, Access: AccessRead | AccessMknod, Allow: true}
+	if s := rule.String(); s != "allow c 1:* rm" {
+		t.Error("Unexpected string:", s)
+	}
+}
+
+func TestNewProgram(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.15", "BPF_PROG_TYPE_CGROUP_DEVICE")
+
+	prog, err := NewProgram([]Rule{
+		{Type: CharDevice, Major: 1, Minor: 3, Access: AccessAll, Allow: true},
+	}, false)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.Close()
+}
+
+func TestAttach(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.15", "BPF_PROG_TYPE_CGROUP_DEVICE")
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// Opens /dev/null (char 1:3) from inside the cgroup.
+	openDevNull := func(t *testing.T, cgroup string) error {
+		t.Helper()
+
+		script := `echo $$ > "$1/cgroup.procs" && exec cat /dev/null`
+		return exec.Command("sh", "-c", script, "sh", cgroup).Run()
+	}
+
+	for _, test := range []struct {
+		name    string
+		rules   []Rule
+		def     bool
+		allowed bool
+	}{
+		{"allow by rule", []Rule{{Type: CharDevice, Major: 1, Minor: 3, Access: AccessRead, Allow: true}}, false, true},
+		{"deny by default", []Rule{{Type: CharDevice, Major: 1, Minor: 5, Access: AccessRead, Allow: true}}, false, false},
+		{"deny by rule", []Rule{
+			{Type: AnyDevice, Major: Wildcard, Minor: Wildcard, Access: AccessRead, Allow: false},
+			{Type: CharDevice, Major: 1, Minor: 3, Access: AccessAll, Allow: true},
+		}, true, false},
+		{"insufficient access", []Rule{{Type: CharDevice, Major: 1, Minor: 3, Access: AccessWrite, Allow: true}}, false, false},
+		{"allow by default", nil, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cgroup := testutils.CreateCgroup(t)
+
+			l, err := Attach(cgroup.Name(), test.rules, test.def)
+			testutils.SkipIfNotSupported(t, err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			err = openDevNull(t, cgroup.Name())
+			if test.allowed && err != nil {
+				t.Fatal("Access was denied:", err)
+			}
+			if !test.allowed && err == nil {
+				t.Fatal("Access was allowed")
+			}
+		})
+	}
+}
+
+func TestAccessString(t *testing.T) {
+	if s := AccessAll.String(); s != "rwm" {
+		t.Error("Unexpected string:", s)
+	}
+}
@@ -0,0 +1,104 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+// devMapValueSize is the size of struct bpf_devmap_val and
+// struct bpf_cpumap_val.
+const devMapValueSize = 8
+
+// DevMapValue is the value of a DevMap or DevMapHash, equivalent to
+// struct bpf_devmap_val.
+//
+// The map must have a ValueSize of 8. Maps with a ValueSize of 4 only
+// store the interface index and use uint32 values instead.
+type DevMapValue struct {
+	// Index of the network interface to redirect to.
+	IfIndex uint32
+	// An optional program of type XDP and attach type AttachXDPDevMap. It
+	// is run on frames after they have been redirected to the interface.
+	//
+	// Only used when writing a value, requires Linux 5.8.
+	Program *Program
+	// The ID of the program, or zero. Only populated when reading a value.
+	ProgramID ProgramID
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v DevMapValue) MarshalBinary() ([]byte, error) {
+	return marshalRedirectValue(v.IfIndex, v.Program)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *DevMapValue) UnmarshalBinary(buf []byte) error {
+	ifIndex, id, err := unmarshalRedirectValue(buf)
+	if err != nil {
+		return err
+	}
+
+	*v = DevMapValue{IfIndex: ifIndex, ProgramID: id}
+	return nil
+}
+
+// CPUMapValue is the value of a CPUMap, equivalent to
+// struct bpf_cpumap_val.
+//
+// The map must have a ValueSize of 8. Maps with a ValueSize of 4 only
+// store the queue size and use uint32 values instead.
+type CPUMapValue struct {
+	// Size of the queue for frames redirected to the CPU.
+	QueueSize uint32
+	// An optional program of type XDP and attach type AttachXDPCPUMap. It
+	// is run on the target CPU for each redirected frame.
+	//
+	// Only used when writing a value, requires Linux 5.9.
+	Program *Program
+	// The ID of the program, or zero. Only populated when reading a value.
+	ProgramID ProgramID
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v CPUMapValue) MarshalBinary() ([]byte, error) {
+	return marshalRedirectValue(v.QueueSize, v.Program)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *CPUMapValue) UnmarshalBinary(buf []byte) error {
+	queueSize, id, err := unmarshalRedirectValue(buf)
+	if err != nil {
+		return err
+	}
+
+	*v = CPUMapValue{QueueSize: queueSize, ProgramID: id}
+	return nil
+}
+
+// marshalRedirectValue encodes the common layout of devmap and cpumap
+// values: a 32 bit integer followed by a union of program fd and id.
+func marshalRedirectValue(first uint32, prog *Program) ([]byte, error) {
+	// The kernel ignores negative file descriptors.
+	fd := -1
+	if prog != nil {
+		fd = prog.FD()
+		if fd < 0 {
+			return nil, errors.New("program is closed")
+		}
+	}
+
+	buf := make([]byte, devMapValueSize)
+	internal.NativeEndian.PutUint32(buf, first)
+	internal.NativeEndian.PutUint32(buf[4:], uint32(int32(fd)))
+	return buf, nil
+}
+
+func unmarshalRedirectValue(buf []byte) (uint32, ProgramID, error) {
+	if len(buf) != devMapValueSize {
+		return 0, 0, fmt.Errorf("value of %d bytes doesn't match size %d", len(buf), devMapValueSize)
+	}
+
+	return internal.NativeEndian.Uint32(buf), ProgramID(internal.NativeEndian.Uint32(buf[4:])), nil
+}
@@ -0,0 +1,120 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func newRedirectProgram(t *testing.T, attachType AttachType) *Program {
+	t.Helper()
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type:       XDP,
+		AttachType: attachType,
+		Instructions: asm.Instructions{
+			// XDP_PASS
+			asm.LoadImm(asm.R0, 2, asm.DWord),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+func programID(t *testing.T, prog *Program) ProgramID {
+	t.Helper()
+
+	info, err := prog.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, ok := info.ID()
+	if !ok {
+		t.Skip("Program ID not supported")
+	}
+	return id
+}
+
+func TestDevMapValue(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.8", "devmap program")
+
+	m, err := NewMap(&MapSpec{
+		Type:       DevMap,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	prog := newRedirectProgram(t, AttachXDPDevMap)
+
+	// Interface 1 is the loopback device.
+	if err := m.Put(uint32(0), DevMapValue{IfIndex: 1, Program: prog}); err != nil {
+		t.Fatal(err)
+	}
+
+	var value DevMapValue
+	if err := m.Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.IfIndex != 1 {
+		t.Errorf("Expected interface 1, got %d", value.IfIndex)
+	}
+	if id := programID(t, prog); value.ProgramID != id {
+		t.Errorf("Expected program ID %d, got %d", id, value.ProgramID)
+	}
+
+	if err := m.Put(uint32(0), DevMapValue{IfIndex: 1}); err != nil {
+		t.Fatal("Can't put value without program:", err)
+	}
+	if err := m.Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.ProgramID != 0 {
+		t.Errorf("Expected no program, got ID %d", value.ProgramID)
+	}
+}
+
+func TestCPUMapValue(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.9", "cpumap program")
+
+	m, err := NewMap(&MapSpec{
+		Type:       CPUMap,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	prog := newRedirectProgram(t, AttachXDPCPUMap)
+
+	if err := m.Put(uint32(0), CPUMapValue{QueueSize: 192, Program: prog}); err != nil {
+		t.Fatal(err)
+	}
+
+	var value CPUMapValue
+	if err := m.Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.QueueSize != 192 {
+		t.Errorf("Expected queue size 192, got %d", value.QueueSize)
+	}
+	if id := programID(t, prog); value.ProgramID != id {
+		t.Errorf("Expected program ID %d, got %d", id, value.ProgramID)
+	}
+}
@@ -0,0 +1,10 @@
+// Package ebpftest contains helpers for testing code which uses eBPF.
+//
+// The helpers mirror the ones used by the library's own test suite: tests can
+// be skipped on kernels which are too old or lack a feature, temporary
+// directories can be created on a BPF file system and minimal maps and
+// programs serve as fixtures.
+//
+// Importing this package lifts the RLIMIT_MEMLOCK restriction for the
+// current process.
+package ebpftest
@@ -0,0 +1,68 @@
+package ebpftest
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// NewMap creates a map from spec.
+//
+// The test is skipped if the map type isn't supported by the kernel and fails
+// on any other error. The map is closed at the end of the test.
+func NewMap(tb testing.TB, spec *ebpf.MapSpec) *ebpf.Map {
+	tb.Helper()
+
+	m, err := ebpf.NewMap(spec)
+	SkipIfNotSupported(tb, err)
+	if err != nil {
+		tb.Fatal("Can't create map:", err)
+	}
+	tb.Cleanup(func() { m.Close() })
+
+	return m
+}
+
+// NewArray creates an array map with 4 byte keys and values.
+func NewArray(tb testing.TB, maxEntries uint32) *ebpf.Map {
+	tb.Helper()
+
+	return NewMap(tb, &ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: maxEntries,
+	})
+}
+
+// NewProgram loads a program from spec.
+//
+// The test is skipped if the program type isn't supported by the kernel and
+// fails on any other error. The program is closed at the end of the test.
+func NewProgram(tb testing.TB, spec *ebpf.ProgramSpec) *ebpf.Program {
+	tb.Helper()
+
+	prog, err := ebpf.NewProgram(spec)
+	SkipIfNotSupported(tb, err)
+	if err != nil {
+		tb.Fatal("Can't load program:", err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+// NewMinimalProgram loads a program of the given type which returns zero.
+func NewMinimalProgram(tb testing.TB, typ ebpf.ProgramType) *ebpf.Program {
+	tb.Helper()
+
+	return NewProgram(tb, &ebpf.ProgramSpec{
+		Type: typ,
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+}
@@ -0,0 +1,36 @@
+package ebpftest
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestNewArray(t *testing.T) {
+	m := NewArray(t, 2)
+
+	if err := m.Put(uint32(1), uint32(42)); err != nil {
+		t.Fatal("Can't put:", err)
+	}
+
+	var value uint32
+	if err := m.Lookup(uint32(1), &value); err != nil {
+		t.Fatal("Can't lookup:", err)
+	}
+	if value != 42 {
+		t.Error("Expected value 42, got", value)
+	}
+}
+
+func TestNewMinimalProgram(t *testing.T) {
+	prog := NewMinimalProgram(t, ebpf.SocketFilter)
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 0 {
+		t.Error("Expected return value 0, got", ret)
+	}
+}
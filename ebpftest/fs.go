@@ -0,0 +1,27 @@
+package ebpftest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+// TempBPFFS creates a temporary directory on the BPF file system mounted at
+// /sys/fs/bpf.
+//
+// The directory and any objects pinned in it are removed at the end of the
+// test.
+func TempBPFFS(tb testing.TB) string {
+	tb.Helper()
+	return testutils.TempBPFFS(tb)
+}
+
+// CreateCgroup creates a temporary cgroup in the cgroupv2 hierarchy and
+// returns an open file referring to it.
+//
+// The cgroup is removed at the end of the test.
+func CreateCgroup(tb testing.TB) *os.File {
+	tb.Helper()
+	return testutils.CreateCgroup(tb)
+}
@@ -0,0 +1,40 @@
+package ebpftest
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+// SkipOnOldKernel skips the test if the kernel is older than minVersion.
+//
+// minVersion is a string of the form "major.minor.patch", where the patch level
+// may be omitted. feature is included in the reason for skipping the test.
+//
+// If the CI_MAX_KERNEL_VERSION environment variable is set the test fails
+// instead if it would never execute on a kernel of that version.
+func SkipOnOldKernel(tb testing.TB, minVersion, feature string) {
+	tb.Helper()
+	testutils.SkipOnOldKernel(tb, minVersion, feature)
+}
+
+// SkipIfNotSupported skips the test if err indicates that a feature isn't
+// supported by the kernel.
+//
+// The test fails if err carries a minimum kernel version that is older than
+// the running kernel, since that indicates a bug in feature detection. err
+// may be nil, in which case the test continues.
+func SkipIfNotSupported(tb testing.TB, err error) {
+	tb.Helper()
+	testutils.SkipIfNotSupported(tb, err)
+}
+
+// CheckFeatureTest fails the test if fn returns an error other than
+// ErrNotSupported, or if fn claims that a feature is missing even though the
+// kernel is new enough to support it.
+//
+// fn is usually one of the functions from the features package.
+func CheckFeatureTest(t *testing.T, fn func() error) {
+	t.Helper()
+	testutils.CheckFeatureTest(t, fn)
+}
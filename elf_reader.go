@@ -27,6 +27,8 @@ type elfCode struct {
 	version  uint32
 	btf      *btf.Spec
 	extInfo  *btf.ExtInfos
+	kconfig  *btf.Datasec
+	ksyms    *btf.Datasec
 }
 
 // LoadCollectionSpec parses an ELF file into a CollectionSpec.
@@ -45,7 +47,16 @@ func LoadCollectionSpec(file string) (*CollectionSpec, error) {
 }
 
 // LoadCollectionSpecFromReader parses an ELF file into a CollectionSpec.
+//
+// The ELF may be gzip compressed, in which case it is decompressed into
+// memory first. zstd compressed ELFs are recognised but not supported, and
+// return an error wrapping ErrNotSupported.
 func LoadCollectionSpecFromReader(rd io.ReaderAt) (*CollectionSpec, error) {
+	rd, err := internal.MaybeDecompress(rd)
+	if err != nil {
+		return nil, err
+	}
+
 	f, err := internal.NewSafeELFFile(rd)
 	if err != nil {
 		return nil, err
@@ -77,6 +88,8 @@ func LoadCollectionSpecFromReader(rd io.ReaderAt) (*CollectionSpec, error) {
 			sections[idx] = newElfSection(sec, btfMapSection)
 		case sec.Name == ".bss" || sec.Name == ".data" || strings.HasPrefix(sec.Name, ".rodata"):
 			sections[idx] = newElfSection(sec, dataSection)
+		case sec.Name == ".struct_ops" || sec.Name == ".struct_ops.link":
+			sections[idx] = newElfSection(sec, structOpsSection)
 		case sec.Type == elf.SHT_REL:
 			// Store relocations under the section index of the target
 			relSections[elf.SectionIndex(sec.Info)] = sec
@@ -95,7 +108,7 @@ func LoadCollectionSpecFromReader(rd io.ReaderAt) (*CollectionSpec, error) {
 		return nil, fmt.Errorf("load version: %w", err)
 	}
 
-	btfSpec, btfExtInfo, err := btf.LoadSpecAndExtInfosFromReader(rd)
+	btfSpec, btfExtInfo, err := btf.LoadSpecAndExtInfosFromReader(f.ReaderAt())
 	if err != nil && !errors.Is(err, btf.ErrNotFound) {
 		return nil, fmt.Errorf("load BTF: %w", err)
 	}
@@ -116,6 +129,16 @@ func LoadCollectionSpecFromReader(rd io.ReaderAt) (*CollectionSpec, error) {
 
 	ec.assignSymbols(symbols)
 
+	if btfSpec != nil {
+		var ds *btf.Datasec
+		if btfSpec.TypeByName(".kconfig", &ds) == nil {
+			ec.kconfig = ds
+		}
+		if btfSpec.TypeByName(".ksyms", &ds) == nil {
+			ec.ksyms = ds
+		}
+	}
+
 	if err := ec.loadRelocations(relSections, symbols); err != nil {
 		return nil, fmt.Errorf("load relocations: %w", err)
 	}
@@ -134,13 +157,34 @@ func LoadCollectionSpecFromReader(rd io.ReaderAt) (*CollectionSpec, error) {
 		return nil, fmt.Errorf("load data sections: %w", err)
 	}
 
+	ec.loadKconfigSection(maps)
+
 	// Finally, collect programs and link them.
-	progs, err := ec.loadProgramSections()
+	progs, funcs, err := ec.loadProgramSections()
 	if err != nil {
 		return nil, fmt.Errorf("load programs: %w", err)
 	}
 
-	return &CollectionSpec{maps, progs, btfSpec, ec.ByteOrder}, nil
+	if err := ec.loadStructOpsMaps(maps, progs); err != nil {
+		return nil, fmt.Errorf("load struct_ops maps: %w", err)
+	}
+
+	return &CollectionSpec{
+		Maps:      maps,
+		Programs:  progs,
+		Types:     btfSpec,
+		ByteOrder: ec.ByteOrder,
+		Functions: funcs,
+	}, nil
+}
+
+// newSectionReader returns a buffered reader over the contents of sec. The
+// buffer is no larger than the section itself.
+func newSectionReader(sec *elf.Section) *bufio.Reader {
+	if sec.Flags&elf.SHF_COMPRESSED != 0 {
+		return bufio.NewReader(sec.Open())
+	}
+	return internal.NewBufferedSectionReader(sec, 0, int64(sec.Size))
 }
 
 func loadLicense(sec *elf.Section) (string, error) {
@@ -175,6 +219,7 @@ const (
 	btfMapSection
 	programSection
 	dataSection
+	structOpsSection
 )
 
 type elfSection struct {
@@ -218,7 +263,7 @@ func (ec *elfCode) assignSymbols(symbols []elf.Symbol) {
 		// Older versions of LLVM don't tag symbols correctly, so keep
 		// all NOTYPE ones.
 		switch symSection.kind {
-		case mapSection, btfMapSection, dataSection:
+		case mapSection, btfMapSection, dataSection, structOpsSection:
 			if symType != elf.STT_NOTYPE && symType != elf.STT_OBJECT {
 				continue
 			}
@@ -275,10 +320,10 @@ func (ec *elfCode) loadRelocations(relSections map[elf.SectionIndex]*elf.Section
 }
 
 // loadProgramSections iterates ec's sections and emits a ProgramSpec
-// for each function it finds.
+// for each function it finds. Functions in .text are returned separately.
 //
-// The resulting map is indexed by function name.
-func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, error) {
+// The resulting maps are indexed by function name.
+func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, map[string]asm.Instructions, error) {
 
 	progs := make(map[string]*ProgramSpec)
 
@@ -290,12 +335,12 @@ func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, error) {
 		}
 
 		if len(sec.symbols) == 0 {
-			return nil, fmt.Errorf("section %v: missing symbols", sec.Name)
+			return nil, nil, fmt.Errorf("section %v: missing symbols", sec.Name)
 		}
 
 		funcs, err := ec.loadFunctions(sec)
 		if err != nil {
-			return nil, fmt.Errorf("section %v: %w", sec.Name, err)
+			return nil, nil, fmt.Errorf("section %v: %w", sec.Name, err)
 		}
 
 		progType, attachType, progFlags, attachTo := getProgType(sec.Name)
@@ -317,7 +362,7 @@ func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, error) {
 
 			// Function names must be unique within a single ELF blob.
 			if progs[name] != nil {
-				return nil, fmt.Errorf("duplicate program name %s", name)
+				return nil, nil, fmt.Errorf("duplicate program name %s", name)
 			}
 			progs[name] = spec
 
@@ -330,15 +375,20 @@ func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, error) {
 	flattenPrograms(progs, export)
 
 	// Hide programs (e.g. library functions) that were not explicitly emitted
-	// to an ELF section. These could be exposed in a separate CollectionSpec
-	// field later to allow them to be modified.
+	// to an ELF section. Their instructions are kept around so that they can
+	// be linked against other objects.
+	var funcs map[string]asm.Instructions
 	for n, p := range progs {
 		if p.SectionName == ".text" {
+			if funcs == nil {
+				funcs = make(map[string]asm.Instructions)
+			}
+			funcs[n] = p.Instructions
 			delete(progs, n)
 		}
 	}
 
-	return progs, nil
+	return progs, funcs, nil
 }
 
 // loadFunctions extracts instruction streams from the given program section
@@ -347,7 +397,7 @@ func (ec *elfCode) loadProgramSections() (map[string]*ProgramSpec, error) {
 //
 // The resulting map is indexed by function name.
 func (ec *elfCode) loadFunctions(section *elfSection) (map[string]asm.Instructions, error) {
-	r := bufio.NewReader(section.Open())
+	r := newSectionReader(section.Section)
 
 	// Decode the section's instruction stream.
 	var insns asm.Instructions
@@ -566,15 +616,64 @@ func (ec *elfCode) relocateInstruction(ins *asm.Instruction, rel elf.Symbol) err
 		}
 
 	case undefSection:
-		if bind != elf.STB_GLOBAL {
+		if ksym := ec.ksymType(name); ksym != nil {
+			if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+				return fmt.Errorf("ksym: %s: unsupported binding: %s", name, bind)
+			}
+
+			switch ksym.(type) {
+			case *btf.Func:
+				if ins.OpCode.JumpOp() != asm.Call {
+					return fmt.Errorf("kfunc: %s: not a call: %v", name, ins)
+				}
+				ins.Src = asm.PseudoKfuncCall
+
+			default:
+				if !ins.IsConstantLoad(asm.DWord) {
+					return fmt.Errorf("ksym: %s: not a dword load: %v", name, ins)
+				}
+			}
+
+			// The instruction is patched with the kernel's BTF ID or the
+			// address of the symbol at load time.
+			ins.Metadata.Set(ksymMetaKey{}, &ksymMeta{ksym, bind == elf.STB_WEAK})
+			break
+		}
+
+		if offset, ok := ec.kconfigOffset(name); ok {
+			if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+				return fmt.Errorf("kconfig: %s: unsupported binding: %s", name, bind)
+			}
+
+			if !ins.IsConstantLoad(asm.DWord) {
+				return fmt.Errorf("kconfig: %s: not a dword load: %v", name, ins)
+			}
+
+			// Turn the reference into a direct load from the .kconfig map,
+			// which is populated at load time.
+			name = ".kconfig"
+			ins.Constant = int64(uint64(offset) << 32)
+			ins.Src = asm.PseudoMapValue
+			break
+		}
+
+		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
 			return fmt.Errorf("asm relocation: %s: unsupported binding: %s", name, bind)
 		}
 
-		if typ != elf.STT_NOTYPE {
+		if typ != elf.STT_NOTYPE && typ != elf.STT_FUNC {
 			return fmt.Errorf("asm relocation: %s: unsupported type %s", name, typ)
 		}
 
-		// There is nothing to do here but set ins.Reference.
+		if bind == elf.STB_WEAK {
+			if !ins.IsFunctionReference() {
+				return fmt.Errorf("weak symbol %s: not a function reference: %v", name, ins)
+			}
+
+			// The function may be supplied by linking another object,
+			// otherwise the reference is removed at load time.
+			ins.Metadata.Set(weakMetaKey{}, true)
+		}
 
 	default:
 		return fmt.Errorf("relocation to %q: %w", target.Name, ErrNotSupported)
@@ -600,8 +699,10 @@ func (ec *elfCode) loadMaps(maps map[string]*MapSpec) error {
 		}
 
 		var (
-			r    = bufio.NewReader(sec.Open())
+			r    = newSectionReader(sec.Section)
 			size = sec.Size / uint64(nSym)
+			defs = make([]*MapSpec, 0, nSym)
+			exts = make([]legacyMapExtension, 0, nSym)
 		)
 		for i, offset := 0, uint64(0); i < nSym; i, offset = i+1, offset+size {
 			mapSym, ok := sec.symbols[offset]
@@ -628,10 +729,22 @@ func (ec *elfCode) loadMaps(maps map[string]*MapSpec) error {
 				return fmt.Errorf("map %s: missing value size", mapName)
 			case binary.Read(lr, ec.ByteOrder, &spec.MaxEntries) != nil:
 				return fmt.Errorf("map %s: missing max entries", mapName)
-			case binary.Read(lr, ec.ByteOrder, &spec.Flags) != nil:
+			}
+
+			// Definitions predating map_flags end after max_entries.
+			if err := binary.Read(lr, ec.ByteOrder, &spec.Flags); err != nil && !errors.Is(err, io.EOF) {
 				return fmt.Errorf("map %s: missing flags", mapName)
 			}
 
+			ext, err := readLegacyMapExtension(lr, ec.ByteOrder, size)
+			if err != nil {
+				return fmt.Errorf("map %s: %w", mapName, err)
+			}
+
+			if err := ext.apply(&spec); err != nil {
+				return fmt.Errorf("map %s: %w", mapName, err)
+			}
+
 			extra, err := io.ReadAll(lr)
 			if err != nil {
 				return fmt.Errorf("map %s: reading map tail: %w", mapName, err)
@@ -645,12 +758,148 @@ func (ec *elfCode) loadMaps(maps map[string]*MapSpec) error {
 			}
 
 			maps[mapName] = &spec
+			defs = append(defs, &spec)
+			exts = append(exts, ext)
+		}
+
+		for i, spec := range defs {
+			if err := exts[i].resolveInnerMap(spec, i, defs, exts); err != nil {
+				return fmt.Errorf("map %s: %w", spec.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+const (
+	// Size of struct bpf_map_def in the kernel's samples/bpf, which appends
+	// inner_map_idx and numa_node to the definition used by libbpf.
+	samplesMapDefSize = 7 * 4
+	// Size of struct bpf_elf_map used by iproute2, which appends id, pinning,
+	// inner_id and inner_idx to the definition used by libbpf.
+	iproute2MapDefSize = 9 * 4
+)
+
+// Values of the pinning field of iproute2's struct bpf_elf_map.
+const (
+	iproute2PinNone = iota
+	iproute2PinObjectNS
+	iproute2PinGlobalNS
+)
+
+// legacyMapExtension holds the fields some loaders append to libbpf's
+// struct bpf_map_def.
+type legacyMapExtension struct {
+	size uint64
+
+	// samples/bpf
+	InnerMapIdx uint32
+	NumaNode    uint32
+
+	// iproute2
+	ID       uint32
+	Pinning  uint32
+	InnerID  uint32
+	InnerIdx uint32
+}
+
+// readLegacyMapExtension reads the fields following map_flags from a
+// definition of the given size.
+//
+// Definitions of other sizes are left alone, their trailing bytes end up
+// in MapSpec.Extra.
+func readLegacyMapExtension(r io.Reader, bo binary.ByteOrder, size uint64) (legacyMapExtension, error) {
+	ext := legacyMapExtension{size: size}
+
+	var fields []interface{}
+	switch size {
+	case samplesMapDefSize:
+		fields = []interface{}{&ext.InnerMapIdx, &ext.NumaNode}
+	case iproute2MapDefSize:
+		fields = []interface{}{&ext.ID, &ext.Pinning, &ext.InnerID, &ext.InnerIdx}
+	}
+
+	for _, field := range fields {
+		if err := binary.Read(r, bo, field); err != nil {
+			return legacyMapExtension{}, fmt.Errorf("read legacy map definition: %w", err)
+		}
+	}
+
+	return ext, nil
+}
+
+// apply copies the fields which don't refer to other maps into spec.
+func (ext *legacyMapExtension) apply(spec *MapSpec) error {
+	switch ext.size {
+	case samplesMapDefSize:
+		spec.NumaNode = ext.NumaNode
+
+	case iproute2MapDefSize:
+		switch ext.Pinning {
+		case iproute2PinNone:
+		case iproute2PinGlobalNS:
+			// iproute2 pins into /sys/fs/bpf/tc/globals, which needs to be
+			// configured via MapOptions.PinPath.
+			spec.Pinning = PinByName
+		case iproute2PinObjectNS:
+			return fmt.Errorf("pinning PIN_OBJECT_NS: %w", ErrNotSupported)
+		default:
+			return fmt.Errorf("unsupported pinning %d: %w", ext.Pinning, ErrNotSupported)
+		}
+
+		if ext.InnerIdx != 0 {
+			return fmt.Errorf("inner_idx %d: %w", ext.InnerIdx, ErrNotSupported)
 		}
 	}
 
 	return nil
 }
 
+// resolveInnerMap sets the InnerMap of spec, which is defs[idx], if the
+// extension refers to another definition in the same section.
+func (ext *legacyMapExtension) resolveInnerMap(spec *MapSpec, idx int, defs []*MapSpec, exts []legacyMapExtension) error {
+	if !spec.Type.canStoreMap() {
+		return nil
+	}
+
+	inner := -1
+	switch ext.size {
+	case samplesMapDefSize:
+		// inner_map_idx is the position of the inner map in the section.
+		inner = int(ext.InnerMapIdx)
+		if inner >= len(defs) {
+			return fmt.Errorf("inner_map_idx %d is out of bounds", inner)
+		}
+
+	case iproute2MapDefSize:
+		if ext.InnerID == 0 {
+			return nil
+		}
+
+		// inner_id matches the id of another definition.
+		for i := range exts {
+			if i != idx && exts[i].ID == ext.InnerID {
+				inner = i
+				break
+			}
+		}
+		if inner == -1 {
+			return fmt.Errorf("no map with id %d for inner_id", ext.InnerID)
+		}
+
+	default:
+		return nil
+	}
+
+	if inner == idx {
+		return errors.New("map can't be its own inner map")
+	}
+
+	spec.InnerMap = defs[inner].Copy()
+	return nil
+}
+
 // loadBTFMaps iterates over all ELF sections marked as BTF map sections
 // (like .maps) and parses them into MapSpecs. Dump the .maps section and
 // any relocations with `readelf -x .maps -r <elf_file>`.
@@ -738,6 +987,8 @@ func mapSpecFromBTF(es *elfSection, vs *btf.VarSecinfo, def *btf.Struct, spec *b
 		keySize, valueSize uint32
 		mapType            MapType
 		flags, maxEntries  uint32
+		mapExtra           uint32
+		numaNode           uint32
 		pinType            PinType
 		innerMapSpec       *MapSpec
 		contents           []MapKV
@@ -765,6 +1016,18 @@ func mapSpecFromBTF(es *elfSection, vs *btf.VarSecinfo, def *btf.Struct, spec *b
 				return nil, fmt.Errorf("can't get BTF map max entries: %w", err)
 			}
 
+		case "map_extra":
+			mapExtra, err = uintFromBTF(member.Type)
+			if err != nil {
+				return nil, fmt.Errorf("can't get BTF map extra: %w", err)
+			}
+
+		case "numa_node":
+			numaNode, err = uintFromBTF(member.Type)
+			if err != nil {
+				return nil, fmt.Errorf("can't get BTF map NUMA node: %w", err)
+			}
+
 		case "key":
 			if keySize != 0 {
 				return nil, errors.New("both key and key_size given")
@@ -915,6 +1178,8 @@ func mapSpecFromBTF(es *elfSection, vs *btf.VarSecinfo, def *btf.Struct, spec *b
 		ValueSize:  valueSize,
 		MaxEntries: maxEntries,
 		Flags:      flags,
+		MapExtra:   uint64(mapExtra),
+		NumaNode:   numaNode,
 		Key:        key,
 		Value:      value,
 		BTF:        spec,
@@ -1027,12 +1292,7 @@ func (ec *elfCode) loadDataSections(maps map[string]*MapSpec) error {
 			continue
 		}
 
-		data, err := sec.Data()
-		if err != nil {
-			return fmt.Errorf("data section %s: can't get contents: %w", sec.Name, err)
-		}
-
-		if uint64(len(data)) > math.MaxUint32 {
+		if sec.Size > math.MaxUint32 {
 			return fmt.Errorf("data section %s: contents exceed maximum size", sec.Name)
 		}
 
@@ -1040,9 +1300,19 @@ func (ec *elfCode) loadDataSections(maps map[string]*MapSpec) error {
 			Name:       SanitizeName(sec.Name, -1),
 			Type:       Array,
 			KeySize:    4,
-			ValueSize:  uint32(len(data)),
+			ValueSize:  uint32(sec.Size),
 			MaxEntries: 1,
-			Contents:   []MapKV{{uint32(0), data}},
+		}
+
+		// Sections without contents in the file, like .bss, are
+		// zero-initialized by the kernel. Don't allocate a buffer for them.
+		if sec.Type != elf.SHT_NOBITS {
+			data, err := sec.Data()
+			if err != nil {
+				return fmt.Errorf("data section %s: can't get contents: %w", sec.Name, err)
+			}
+
+			mapSpec.Contents = []MapKV{{uint32(0), data}}
 		}
 
 		// It is possible for a data section to exist without a corresponding BTF Datasec
@@ -1061,9 +1331,6 @@ func (ec *elfCode) loadDataSections(maps map[string]*MapSpec) error {
 		case strings.HasPrefix(n, ".rodata"):
 			mapSpec.Flags = unix.BPF_F_RDONLY_PROG
 			mapSpec.Freeze = true
-		case n == ".bss":
-			// The kernel already zero-initializes the map
-			mapSpec.Contents = nil
 		}
 
 		maps[sec.Name] = mapSpec
@@ -1071,6 +1338,211 @@ func (ec *elfCode) loadDataSections(maps map[string]*MapSpec) error {
 	return nil
 }
 
+// loadStructOpsMaps emits a StructOpsMap for each variable in the .struct_ops
+// and .struct_ops.link sections. Programs referenced by the variables'
+// function pointers are set up to implement the respective member.
+func (ec *elfCode) loadStructOpsMaps(maps map[string]*MapSpec, progs map[string]*ProgramSpec) error {
+	for _, sec := range ec.sections {
+		if sec.kind != structOpsSection {
+			continue
+		}
+
+		if ec.btf == nil {
+			return fmt.Errorf("section %s: missing BTF", sec.Name)
+		}
+
+		var ds *btf.Datasec
+		if err := ec.btf.TypeByName(sec.Name, &ds); err != nil {
+			return fmt.Errorf("section %s: %w", sec.Name, err)
+		}
+
+		data, err := sec.Data()
+		if err != nil {
+			return fmt.Errorf("section %s: can't get contents: %w", sec.Name, err)
+		}
+
+		var flags uint32
+		if sec.Name == ".struct_ops.link" {
+			flags = unix.BPF_F_LINK
+		}
+
+		for _, vsi := range ds.Vars {
+			v, ok := vsi.Type.(*btf.Var)
+			if !ok {
+				return fmt.Errorf("section %s: unexpected type %s", sec.Name, vsi.Type)
+			}
+
+			typ, ok := btf.UnderlyingType(v.Type).(*btf.Struct)
+			if !ok {
+				return fmt.Errorf("section %s: variable %s is not a struct", sec.Name, v.Name)
+			}
+
+			start, end := uint64(vsi.Offset), uint64(vsi.Offset)+uint64(vsi.Size)
+			if end > uint64(len(data)) {
+				return fmt.Errorf("section %s: variable %s exceeds section bounds", sec.Name, v.Name)
+			}
+
+			value := StructOpsValue{
+				Data:     data[start:end],
+				Programs: make(map[string]string),
+			}
+
+			for offset, sym := range sec.relocations {
+				if offset < start || offset >= end {
+					continue
+				}
+
+				member, err := structOpsMemberAt(typ, uint32(offset-start))
+				if err != nil {
+					return fmt.Errorf("%s: %w", v.Name, err)
+				}
+
+				progName, err := ec.structOpsProgram(sym, data[offset:])
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", v.Name, member.Name, err)
+				}
+
+				prog := progs[progName]
+				if prog == nil {
+					return fmt.Errorf("%s.%s: unknown program %s", v.Name, member.Name, progName)
+				}
+
+				if prog.Type != StructOps {
+					return fmt.Errorf("%s.%s: program %s has type %s", v.Name, member.Name, progName, prog.Type)
+				}
+
+				attachTo := typ.Name + ":" + member.Name
+				if prog.AttachTo != "" && prog.AttachTo != attachTo {
+					return fmt.Errorf("program %s implements both %s and %s", progName, prog.AttachTo, attachTo)
+				}
+
+				prog.AttachTo = attachTo
+				value.Programs[member.Name] = progName
+			}
+
+			if maps[v.Name] != nil {
+				return fmt.Errorf("section %s: map %s already exists", sec.Name, v.Name)
+			}
+
+			maps[v.Name] = &MapSpec{
+				Name:       SanitizeName(v.Name, -1),
+				Type:       StructOpsMap,
+				KeySize:    4,
+				ValueSize:  vsi.Size,
+				MaxEntries: 1,
+				Flags:      flags,
+				BTF:        ec.btf,
+				Value:      typ,
+				Contents:   []MapKV{{uint32(0), value}},
+			}
+		}
+	}
+
+	return nil
+}
+
+// structOpsMemberAt returns the function pointer member of typ at the given
+// byte offset.
+func structOpsMemberAt(typ *btf.Struct, offset uint32) (btf.Member, error) {
+	for _, m := range typ.Members {
+		if m.Offset.Bytes() != offset {
+			continue
+		}
+
+		if !isFuncPointer(m.Type) {
+			return btf.Member{}, fmt.Errorf("member %s of %s is not a function pointer", m.Name, typ.Name)
+		}
+
+		return m, nil
+	}
+
+	return btf.Member{}, fmt.Errorf("no member of %s at offset %d", typ.Name, offset)
+}
+
+// structOpsProgram returns the name of the program a relocation in a
+// struct_ops section refers to. data starts at the relocated location.
+func (ec *elfCode) structOpsProgram(sym elf.Symbol, data []byte) (string, error) {
+	target := ec.sections[sym.Section]
+	if target == nil || target.kind != programSection {
+		return "", fmt.Errorf("relocation to %s doesn't point at a program", sym.Name)
+	}
+
+	switch elf.ST_TYPE(sym.Info) {
+	case elf.STT_FUNC, elf.STT_NOTYPE:
+		return sym.Name, nil
+
+	case elf.STT_SECTION:
+		// The offset of the function in the section is stored at the
+		// relocated location.
+		if len(data) < 8 {
+			return "", fmt.Errorf("relocation to %s is out of bounds", sym.Name)
+		}
+
+		offset := sym.Value + ec.ByteOrder.Uint64(data)
+		fn, ok := target.symbols[offset]
+		if !ok {
+			return "", fmt.Errorf("no function at offset %d of section %s", offset, target.Name)
+		}
+		return fn.Name, nil
+
+	default:
+		return "", fmt.Errorf("relocation to %s: unsupported type %s", sym.Name, elf.ST_TYPE(sym.Info))
+	}
+}
+
+// ksymType returns the type of a kernel symbol declared in the .ksyms section,
+// or nil if there is no such symbol.
+func (ec *elfCode) ksymType(name string) btf.Type {
+	if ec.ksyms == nil {
+		return nil
+	}
+
+	for _, vsi := range ec.ksyms.Vars {
+		if vsi.Type.TypeName() == name {
+			return vsi.Type
+		}
+	}
+
+	return nil
+}
+
+// kconfigOffset returns the offset of a kconfig extern in the .kconfig map.
+func (ec *elfCode) kconfigOffset(name string) (uint32, bool) {
+	if ec.kconfig == nil {
+		return 0, false
+	}
+
+	for _, vsi := range ec.kconfig.Vars {
+		if v, ok := vsi.Type.(*btf.Var); ok && v.Name == name {
+			return vsi.Offset, true
+		}
+	}
+
+	return 0, false
+}
+
+// loadKconfigSection emits a map for kconfig externs. Its contents are
+// resolved against the configuration of the running kernel when the
+// collection is loaded.
+func (ec *elfCode) loadKconfigSection(maps map[string]*MapSpec) {
+	if ec.kconfig == nil || ec.kconfig.Size == 0 {
+		return
+	}
+
+	maps[".kconfig"] = &MapSpec{
+		Name:       SanitizeName(".kconfig", -1),
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  ec.kconfig.Size,
+		MaxEntries: 1,
+		Flags:      unix.BPF_F_RDONLY_PROG,
+		Freeze:     true,
+		BTF:        ec.btf,
+		Key:        &btf.Void{},
+		Value:      ec.kconfig,
+	}
+}
+
 func getProgType(sectionName string) (ProgramType, AttachType, uint32, string) {
 	types := []struct {
 		prefix     string
@@ -1084,6 +1556,8 @@ func getProgType(sectionName string) (ProgramType, AttachType, uint32, string) {
 		{"sk_reuseport/migrate", SkReuseport, AttachSkReuseportSelectOrMigrate, 0},
 		{"sk_reuseport", SkReuseport, AttachSkReuseportSelect, 0},
 		{"kprobe/", Kprobe, AttachNone, 0},
+		{"kprobe.multi/", Kprobe, AttachTraceKprobeMulti, 0},
+		{"kretprobe.multi/", Kprobe, AttachTraceKprobeMulti, 0},
 		{"uprobe/", Kprobe, AttachNone, 0},
 		{"kretprobe/", Kprobe, AttachNone, 0},
 		{"uretprobe/", Kprobe, AttachNone, 0},
@@ -1106,8 +1580,10 @@ func getProgType(sectionName string) (ProgramType, AttachType, uint32, string) {
 		{"freplace/", Extension, AttachNone, 0},
 		{"lsm/", LSM, AttachLSMMac, 0},
 		{"lsm.s/", LSM, AttachLSMMac, unix.BPF_F_SLEEPABLE},
+		{"lsm_cgroup/", LSM, AttachLSMCgroup, 0},
 		{"iter/", Tracing, AttachTraceIter, 0},
-		{"syscall", Syscall, AttachNone, 0},
+		{"iter.s/", Tracing, AttachTraceIter, unix.BPF_F_SLEEPABLE},
+		{"syscall", Syscall, AttachNone, unix.BPF_F_SLEEPABLE},
 		{"xdp_devmap/", XDP, AttachXDPDevMap, 0},
 		{"xdp_cpumap/", XDP, AttachXDPCPUMap, 0},
 		{"xdp", XDP, AttachNone, 0},
@@ -1147,8 +1623,11 @@ func getProgType(sectionName string) (ProgramType, AttachType, uint32, string) {
 		{"cgroup/sysctl", CGroupSysctl, AttachCGroupSysctl, 0},
 		{"cgroup/getsockopt", CGroupSockopt, AttachCGroupGetsockopt, 0},
 		{"cgroup/setsockopt", CGroupSockopt, AttachCGroupSetsockopt, 0},
-		{"struct_ops+", StructOps, AttachNone, 0},
+		{"struct_ops.s", StructOps, AttachNone, unix.BPF_F_SLEEPABLE},
+		{"struct_ops", StructOps, AttachNone, 0},
 		{"sk_lookup/", SkLookup, AttachSkLookup, 0},
+		{"sk_lookup", SkLookup, AttachSkLookup, 0},
+		{"netfilter", Netfilter, AttachNetfilter, 0},
 
 		{"seccomp", SocketFilter, AttachNone, 0},
 	}
@@ -1175,7 +1654,7 @@ func (ec *elfCode) loadSectionRelocations(sec *elf.Section, symbols []elf.Symbol
 		return nil, fmt.Errorf("section %s: relocations are less than 16 bytes", sec.Name)
 	}
 
-	r := bufio.NewReader(sec.Open())
+	r := newSectionReader(sec)
 	for off := uint64(0); off < sec.Size; off += sec.Entsize {
 		ent := io.LimitReader(r, int64(sec.Entsize))
 
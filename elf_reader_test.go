@@ -2,6 +2,7 @@ package ebpf
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"flag"
@@ -149,7 +150,7 @@ func TestLoadCollectionSpec(t *testing.T) {
 			return false
 		}),
 		cmpopts.IgnoreTypes(new(btf.Spec)),
-		cmpopts.IgnoreFields(CollectionSpec{}, "ByteOrder", "Types"),
+		cmpopts.IgnoreFields(CollectionSpec{}, "ByteOrder", "Types", "Functions"),
 		cmpopts.IgnoreFields(ProgramSpec{}, "Instructions", "ByteOrder"),
 		cmpopts.IgnoreFields(MapSpec{}, "Key", "Value"),
 		cmpopts.IgnoreUnexported(ProgramSpec{}),
@@ -265,6 +266,44 @@ func TestDataSections(t *testing.T) {
 	}
 }
 
+func TestLoadCompressedCollectionSpec(t *testing.T) {
+	file := fmt.Sprintf("testdata/loader-%s.elf", internal.ClangEndian)
+	want, err := LoadCollectionSpec(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := LoadCollectionSpecFromReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(have.Programs) != len(want.Programs) || len(have.Maps) != len(want.Maps) {
+		t.Fatalf("Expected %d programs and %d maps, got %d and %d",
+			len(want.Programs), len(want.Maps), len(have.Programs), len(have.Maps))
+	}
+
+	for name, prog := range want.Programs {
+		if diff := cmp.Diff(prog.Instructions.String(), have.Programs[name].Instructions.String()); diff != "" {
+			t.Errorf("Program %s differs (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
 func TestInlineASMConstant(t *testing.T) {
 	file := fmt.Sprintf("testdata/loader-%s.elf", internal.ClangEndian)
 	coll, err := LoadCollectionSpec(file)
@@ -421,6 +460,51 @@ func TestLoadInvalidInitializedBTFMap(t *testing.T) {
 	})
 }
 
+func TestMapSpecFromBTF(t *testing.T) {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4}
+	u64 := &btf.Int{Name: "unsigned long long", Size: 8}
+	uint := func(n uint32) btf.Type {
+		return &btf.Pointer{Target: &btf.Array{Index: u32, Type: u32, Nelems: n}}
+	}
+
+	def := &btf.Struct{
+		Members: []btf.Member{
+			{Name: "type", Type: uint(uint32(Hash))},
+			{Name: "key", Type: &btf.Pointer{Target: u32}},
+			{Name: "value", Type: &btf.Pointer{Target: u64}},
+			{Name: "max_entries", Type: uint(42)},
+			{Name: "map_flags", Type: uint(unix.BPF_F_NUMA_NODE)},
+			{Name: "numa_node", Type: uint(1)},
+			{Name: "pinning", Type: uint(uint32(PinByName))},
+		},
+	}
+
+	spec, err := mapSpecFromBTF(nil, nil, def, nil, "hash_map", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &MapSpec{
+		Name:       "hash_map",
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 42,
+		Flags:      unix.BPF_F_NUMA_NODE,
+		NumaNode:   1,
+		Pinning:    PinByName,
+		Key:        u32,
+		Value:      u64,
+	}
+	if diff := cmp.Diff(want, spec); diff != "" {
+		t.Errorf("MapSpec mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := mapSpecFromBTF(nil, nil, def, nil, "hash_map_inner", true); err == nil {
+		t.Error("Pinned inner map doesn't return an error")
+	}
+}
+
 func TestStringSection(t *testing.T) {
 	testutils.Files(t, testutils.Glob(t, "testdata/strings-*.elf"), func(t *testing.T, file string) {
 		_, err := LoadCollectionSpec(file)
@@ -576,35 +660,16 @@ func TestIPRoute2Compat(t *testing.T) {
 			t.Fatal("Map hash_map not found")
 		}
 
-		var id, pinning, innerID, innerIndex uint32
-
-		if ms.Extra == nil {
-			t.Fatal("missing extra bytes")
-		}
-
-		switch {
-		case binary.Read(ms.Extra, spec.ByteOrder, &id) != nil:
-			t.Fatal("missing id")
-		case binary.Read(ms.Extra, spec.ByteOrder, &pinning) != nil:
-			t.Fatal("missing pinning")
-		case binary.Read(ms.Extra, spec.ByteOrder, &innerID) != nil:
-			t.Fatal("missing inner_id")
-		case binary.Read(ms.Extra, spec.ByteOrder, &innerIndex) != nil:
-			t.Fatal("missing inner_idx")
-		}
-
-		if id != 0 || innerID != 0 || innerIndex != 0 {
-			t.Fatal("expecting id, inner_id and inner_idx to be zero")
-		}
-
-		if pinning != 2 {
-			t.Fatal("expecting pinning field to be 2 (PIN_GLOBAL_NS)")
+		if ms.Extra != nil {
+			t.Fatal("expected the iproute2 fields to be consumed, got extra bytes")
 		}
 
 		// iproute2 (tc) pins maps in /sys/fs/bpf/tc/globals with PIN_GLOBAL_NS,
 		// which needs to be be configured in this library using MapOptions.PinPath.
 		// For the sake of the test, we use a tempdir on bpffs below.
-		ms.Pinning = PinByName
+		if ms.Pinning != PinByName {
+			t.Fatal("expected PIN_GLOBAL_NS to be converted to PinByName, got", ms.Pinning)
+		}
 
 		coll, err := NewCollectionWithOptions(spec, CollectionOptions{
 			Maps: MapOptions{
@@ -697,17 +762,6 @@ func TestLibBPFCompat(t *testing.T) {
 			t.Fatalf("Can't read %s: %s", file, err)
 		}
 
-		switch file {
-		case "test_sk_assign.o":
-			// Test contains a legacy iproute2 bpf_elf_map definition.
-			for _, m := range spec.Maps {
-				if m.Extra == nil || m.Extra.Len() == 0 {
-					t.Fatalf("Expected extra bytes in map %s", m.Name)
-				}
-				m.Extra = nil
-			}
-		}
-
 		var opts CollectionOptions
 		for _, mapSpec := range spec.Maps {
 			if mapSpec.Pinning != PinNone {
@@ -891,6 +945,54 @@ func TestGetProgType(t *testing.T) {
 			At: AttachNone,
 			To: "",
 		},
+		"syscall": {
+			Pt: Syscall,
+			At: AttachNone,
+			To: "",
+			Fl: unix.BPF_F_SLEEPABLE,
+		},
+		"sk_lookup": {
+			Pt: SkLookup,
+			At: AttachSkLookup,
+			To: "",
+		},
+		"iter.s/bpf_map": {
+			Pt: Tracing,
+			At: AttachTraceIter,
+			To: "bpf_map",
+			Fl: unix.BPF_F_SLEEPABLE,
+		},
+		"lsm_cgroup/socket_bind": {
+			Pt: LSM,
+			At: AttachLSMCgroup,
+			To: "socket_bind",
+		},
+		"kprobe.multi/vfs_*": {
+			Pt: Kprobe,
+			At: AttachTraceKprobeMulti,
+			To: "vfs_*",
+		},
+		"struct_ops/init": {
+			Pt: StructOps,
+			At: AttachNone,
+			To: "",
+		},
+		"struct_ops.s/init": {
+			Pt: StructOps,
+			At: AttachNone,
+			To: "",
+			Fl: unix.BPF_F_SLEEPABLE,
+		},
+		"netfilter": {
+			Pt: Netfilter,
+			At: AttachNetfilter,
+			To: "",
+		},
+		"unknown/foo": {
+			Pt: UnspecifiedProgram,
+			At: AttachNone,
+			To: "",
+		},
 	}
 
 	for section, want := range testcases {
@@ -901,3 +1003,90 @@ func TestGetProgType(t *testing.T) {
 		}
 	}
 }
+
+func TestLegacyMapExtension(t *testing.T) {
+	read := func(size uint64, fields ...uint32) legacyMapExtension {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, internal.NativeEndian, fields); err != nil {
+			t.Fatal(err)
+		}
+
+		ext, err := readLegacyMapExtension(&buf, internal.NativeEndian, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ext
+	}
+
+	t.Run("samples", func(t *testing.T) {
+		inner := &MapSpec{Name: "inner", Type: Array}
+		outer := &MapSpec{Name: "outer", Type: ArrayOfMaps}
+		defs := []*MapSpec{inner, outer}
+		exts := []legacyMapExtension{read(samplesMapDefSize, 0, 0), read(samplesMapDefSize, 0, 1)}
+
+		if err := exts[1].apply(outer); err != nil {
+			t.Fatal(err)
+		}
+		if outer.NumaNode != 1 {
+			t.Error("expected numa_node 1, got", outer.NumaNode)
+		}
+
+		if err := exts[1].resolveInnerMap(outer, 1, defs, exts); err != nil {
+			t.Fatal(err)
+		}
+		if outer.InnerMap == nil || outer.InnerMap.Name != "inner" {
+			t.Fatal("expected inner map to be resolved, got", outer.InnerMap)
+		}
+
+		exts[1].InnerMapIdx = 1
+		if err := exts[1].resolveInnerMap(outer, 1, defs, exts); err == nil {
+			t.Error("expected an error for a map referring to itself")
+		}
+
+		exts[1].InnerMapIdx = 2
+		if err := exts[1].resolveInnerMap(outer, 1, defs, exts); err == nil {
+			t.Error("expected an error for an out of bounds inner_map_idx")
+		}
+	})
+
+	t.Run("iproute2", func(t *testing.T) {
+		inner := &MapSpec{Name: "inner", Type: Hash}
+		outer := &MapSpec{Name: "outer", Type: HashOfMaps}
+		defs := []*MapSpec{outer, inner}
+		exts := []legacyMapExtension{
+			read(iproute2MapDefSize, 1, iproute2PinGlobalNS, 2, 0),
+			read(iproute2MapDefSize, 2, iproute2PinNone, 0, 0),
+		}
+
+		if err := exts[0].apply(outer); err != nil {
+			t.Fatal(err)
+		}
+		if outer.Pinning != PinByName {
+			t.Error("expected PinByName, got", outer.Pinning)
+		}
+
+		if err := exts[0].resolveInnerMap(outer, 0, defs, exts); err != nil {
+			t.Fatal(err)
+		}
+		if outer.InnerMap == nil || outer.InnerMap.Name != "inner" {
+			t.Fatal("expected inner map to be resolved, got", outer.InnerMap)
+		}
+
+		exts[0].InnerID = 3
+		if err := exts[0].resolveInnerMap(outer, 0, defs, exts); err == nil {
+			t.Error("expected an error for a missing inner_id")
+		}
+
+		for _, ext := range []legacyMapExtension{
+			read(iproute2MapDefSize, 0, iproute2PinObjectNS, 0, 0),
+			read(iproute2MapDefSize, 0, 42, 0, 0),
+			read(iproute2MapDefSize, 0, iproute2PinNone, 0, 1),
+		} {
+			if err := ext.apply(&MapSpec{}); !errors.Is(err, ErrNotSupported) {
+				t.Errorf("expected ErrNotSupported for %+v, got %v", ext, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,12 @@
+// Package events allows reading events from BPF independent of the
+// underlying transport.
+//
+// BPF ring buffers are more efficient than perf event arrays, but require
+// Linux 5.8. This package chooses a ring buffer if the kernel supports it
+// and falls back to a perf event array otherwise. Readers return records in
+// the same format regardless of the map type.
+//
+// The BPF side has to use bpf_ringbuf_output or bpf_perf_event_output
+// depending on the map type. A common approach is to declare a constant
+// in the BPF program and set it based on MapType before loading.
+package events
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// ErrClosed is returned by Reader.Read after the Reader was closed.
+//
+// It's the same value as os.ErrClosed, perf.ErrClosed and ringbuf.ErrClosed,
+// so errors from either kind of map match it. It's declared here so that
+// callers don't have to import the package of the underlying reader.
+var ErrClosed = os.ErrClosed
+
+// Record contains an event read from a Reader.
+type Record struct {
+	// The CPU this record was generated on. Always -1 for ring buffers,
+	// which don't track it.
+	CPU int
+
+	// The data submitted via bpf_ringbuf_output or bpf_perf_event_output.
+	// Samples from a perf event array may contain trailing padding.
+	// Empty if LostSamples is non-zero.
+	RawSample []byte
+
+	// The number of samples which could not be written to a per-CPU perf
+	// buffer. Always zero for ring buffers, where lost samples have to be
+	// accounted for by the BPF program.
+	LostSamples uint64
+}
+
+// ReaderOptions control the behaviour of a Reader.
+type ReaderOptions struct {
+	// PerCPUBuffer is the size of each per-CPU buffer of a perf event
+	// array in bytes. Defaults to the page size.
+	PerCPUBuffer int
+
+	// Watermark is the number of bytes required in a per-CPU buffer
+	// before Read processes data. Only used for perf event arrays.
+	Watermark int
+}
+
+// Reader reads events from a ring buffer or a perf event array.
+type Reader struct {
+	typ  ebpf.MapType
+	perf *perf.Reader
+	ring *ringbuf.Reader
+}
+
+// NewReader creates a Reader for m, which must be a RingBuf or
+// PerfEventArray.
+func NewReader(m *ebpf.Map, opts ReaderOptions) (*Reader, error) {
+	switch typ := m.Type(); typ {
+	case ebpf.RingBuf:
+		rd, err := ringbuf.NewReader(m)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{typ: typ, ring: rd}, nil
+
+	case ebpf.PerfEventArray:
+		size := opts.PerCPUBuffer
+		if size == 0 {
+			size = os.Getpagesize()
+		}
+
+		rd, err := perf.NewReaderWithOptions(m, size, perf.ReaderOptions{
+			Watermark: opts.Watermark,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{typ: typ, perf: rd}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid map type %s, expected RingBuf or PerfEventArray", typ)
+	}
+}
+
+// Type returns the type of the underlying map.
+func (r *Reader) Type() ebpf.MapType {
+	return r.typ
+}
+
+// Close frees resources used by the reader.
+//
+// It interrupts calls to Read.
+func (r *Reader) Close() error {
+	if r.ring != nil {
+		return r.ring.Close()
+	}
+	return r.perf.Close()
+}
+
+// Read the next record.
+//
+// Calling Close interrupts the function and returns an error wrapping
+// ErrClosed.
+func (r *Reader) Read() (Record, error) {
+	var rec Record
+	return rec, r.ReadInto(&rec)
+}
+
+// ReadInto is like Read except that it allows reusing Record and associated
+// buffers.
+func (r *Reader) ReadInto(rec *Record) error {
+	if r.ring != nil {
+		ringRec := ringbuf.Record{RawSample: rec.RawSample}
+		if err := r.ring.ReadInto(&ringRec); err != nil {
+			return err
+		}
+
+		rec.CPU = -1
+		rec.RawSample = ringRec.RawSample
+		rec.LostSamples = 0
+		return nil
+	}
+
+	perfRec := perf.Record{RawSample: rec.RawSample}
+	if err := r.perf.ReadInto(&perfRec); err != nil {
+		return err
+	}
+
+	rec.CPU = perfRec.CPU
+	rec.RawSample = perfRec.RawSample
+	rec.LostSamples = perfRec.LostSamples
+	return nil
+}
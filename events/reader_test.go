@@ -0,0 +1,212 @@
+package events
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+// mustOutputProg loads a program which writes sample to events using the
+// helper appropriate for the map type.
+func mustOutputProg(tb testing.TB, events *ebpf.Map, sample []byte) *ebpf.Program {
+	tb.Helper()
+
+	const bpfFCurrentCPU = 0xffffffff
+
+	buf := make([]byte, len(sample)+(8-len(sample)%8)%8)
+	copy(buf, sample)
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R9, asm.R1),
+	}
+	for i := 0; i < len(buf); i += 8 {
+		var value int64
+		for j := 7; j >= 0; j-- {
+			value = value<<8 | int64(buf[i+j])
+		}
+		insns = append(insns,
+			asm.LoadImm(asm.R0, value, asm.DWord),
+			asm.StoreMem(asm.RFP, int16(i-len(buf)), asm.R0, asm.DWord),
+		)
+	}
+
+	switch events.Type() {
+	case ebpf.RingBuf:
+		insns = append(insns,
+			asm.LoadMapPtr(asm.R1, events.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, int32(-len(buf))),
+			asm.Mov.Imm(asm.R3, int32(len(sample))),
+			asm.Mov.Imm(asm.R4, 0),
+			asm.FnRingbufOutput.Call(),
+		)
+
+	case ebpf.PerfEventArray:
+		insns = append(insns,
+			asm.Mov.Reg(asm.R1, asm.R9),
+			asm.LoadMapPtr(asm.R2, events.FD()),
+			asm.LoadImm(asm.R3, bpfFCurrentCPU, asm.DWord),
+			asm.Mov.Reg(asm.R4, asm.RFP),
+			asm.Add.Imm(asm.R4, int32(-len(buf))),
+			asm.Mov.Imm(asm.R5, int32(len(sample))),
+			asm.FnPerfEventOutput.Call(),
+		)
+	}
+
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+	)
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		License:      "GPL",
+		Type:         ebpf.XDP,
+		Instructions: insns,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+func TestReader(t *testing.T) {
+	for _, typ := range []ebpf.MapType{ebpf.RingBuf, ebpf.PerfEventArray} {
+		t.Run(typ.String(), func(t *testing.T) {
+			if typ == ebpf.RingBuf {
+				testutils.SkipOnOldKernel(t, "5.8", "BPF ring buffer")
+			}
+
+			var spec ebpf.MapSpec
+			if err := adjustMapSpec(&spec, typ, 4096); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := ebpf.NewMap(&spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer events.Close()
+
+			sample := []byte("hello, events")
+			prog := mustOutputProg(t, events, sample)
+
+			rd, err := NewReader(events, ReaderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rd.Close()
+
+			if rd.Type() != typ {
+				t.Fatalf("Expected reader of type %s, got %s", typ, rd.Type())
+			}
+
+			ret, _, err := prog.Test(make([]byte, 14))
+			testutils.SkipIfNotSupported(t, err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ret != 0 {
+				t.Fatal("Expected 0 as return value, got", ret)
+			}
+
+			rec, err := rd.Read()
+			if err != nil {
+				t.Fatal("Can't read record:", err)
+			}
+
+			// Perf event samples are padded to eight bytes.
+			if !bytes.HasPrefix(rec.RawSample, sample) {
+				t.Errorf("Expected sample %q, got %q", sample, rec.RawSample)
+			}
+
+			if typ == ebpf.RingBuf && rec.CPU != -1 {
+				t.Error("Expected CPU -1 for ring buffer, got", rec.CPU)
+			}
+
+			if err := rd.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := rd.Read(); !errors.Is(err, ErrClosed) {
+				t.Fatal("Expected ErrClosed after Close, got", err)
+			}
+		})
+	}
+}
+
+func TestNewReaderInvalidMap(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewReader(m, ReaderOptions{}); err == nil {
+		t.Fatal("Creating a reader for an array should fail")
+	}
+}
+
+func TestAdjustMapSpec(t *testing.T) {
+	spec := ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	}
+
+	if err := adjustMapSpec(&spec, ebpf.RingBuf, os.Getpagesize()+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Type != ebpf.RingBuf || spec.KeySize != 0 || spec.ValueSize != 0 {
+		t.Fatalf("Unexpected ring buffer spec %+v", spec)
+	}
+
+	if spec.MaxEntries != uint32(os.Getpagesize())*2 {
+		t.Error("Expected size to be rounded up to two pages, got", spec.MaxEntries)
+	}
+
+	if err := adjustMapSpec(&spec, ebpf.RingBuf, 0); err == nil {
+		t.Error("Zero sized ring buffer should be rejected")
+	}
+
+	if err := adjustMapSpec(&spec, ebpf.PerfEventArray, 4096); err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Type != ebpf.PerfEventArray || spec.MaxEntries != 0 {
+		t.Fatalf("Unexpected perf event array spec %+v", spec)
+	}
+
+	typ, err := MapType()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec2, err := NewMapSpec("events", 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec2.Type != typ {
+		t.Errorf("NewMapSpec returned type %s, expected %s", spec2.Type, typ)
+	}
+
+	m, err := ebpf.NewMap(spec2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+}
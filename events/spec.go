@@ -0,0 +1,85 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+)
+
+// MapType returns the map type to use for events on the running kernel.
+//
+// Returns ebpf.RingBuf if supported, ebpf.PerfEventArray otherwise.
+func MapType() (ebpf.MapType, error) {
+	err := features.HaveMapType(ebpf.RingBuf)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		return ebpf.PerfEventArray, nil
+	}
+	if err != nil {
+		return ebpf.UnspecifiedMap, fmt.Errorf("probe ring buffer support: %w", err)
+	}
+	return ebpf.RingBuf, nil
+}
+
+// NewMapSpec returns a spec for an event map of the type returned by
+// MapType.
+//
+// size is the size of a ring buffer in bytes, and is rounded up to a power
+// of two multiple of the page size. It is ignored for perf event arrays,
+// which contain an entry per CPU.
+func NewMapSpec(name string, size int) (*ebpf.MapSpec, error) {
+	spec := &ebpf.MapSpec{Name: name}
+	if err := AdjustMapSpec(spec, size); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// AdjustMapSpec changes the type of an existing spec to the one returned by
+// MapType.
+//
+// Use it to fix up a map declared in an ELF before loading a
+// CollectionSpec. See NewMapSpec for the meaning of size.
+func AdjustMapSpec(spec *ebpf.MapSpec, size int) error {
+	typ, err := MapType()
+	if err != nil {
+		return err
+	}
+
+	return adjustMapSpec(spec, typ, size)
+}
+
+func adjustMapSpec(spec *ebpf.MapSpec, typ ebpf.MapType, size int) error {
+	spec.Type = typ
+	spec.KeySize = 0
+	spec.ValueSize = 0
+	spec.Key = nil
+	spec.Value = nil
+	spec.Flags = 0
+
+	switch typ {
+	case ebpf.RingBuf:
+		if size < 1 {
+			return fmt.Errorf("invalid ring buffer size %d", size)
+		}
+
+		entries := uint32(os.Getpagesize())
+		for int(entries) < size {
+			entries <<= 1
+			if entries == 0 {
+				return fmt.Errorf("ring buffer size %d is too large", size)
+			}
+		}
+		spec.MaxEntries = entries
+
+	case ebpf.PerfEventArray:
+		spec.MaxEntries = 0
+
+	default:
+		return fmt.Errorf("unsupported map type %s", typ)
+	}
+
+	return nil
+}
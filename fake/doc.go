@@ -0,0 +1,19 @@
+// Package fake provides an in-memory implementation of the bpf(2) syscall.
+//
+// Enabling the fake kernel routes every BPF syscall made by this library to
+// an emulation which lives entirely in the current process. Maps behave like
+// their kernel counterparts, while programs and links are accepted without
+// being verified or executed. This makes it possible to unit test application
+// logic which manipulates maps and manages links on machines which lack the
+// necessary privileges, run a kernel without BPF support or don't run Linux
+// at all. File descriptors of fake objects only exist within the emulation.
+//
+// The emulation is deliberately shallow: programs are never run, pinned
+// objects don't appear in the file system and only a subset of map types has
+// accurate semantics. Other map types can be created but return ErrNotSupported for
+// element operations.
+//
+// The library caches the results of feature detection for the lifetime of the
+// process. Enable the fake kernel before interacting with any other part of
+// the library, ideally from TestMain.
+package fake
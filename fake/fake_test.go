@@ -0,0 +1,448 @@
+package fake
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/link"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMain(m *testing.M) {
+	restore := Enable()
+	code := m.Run()
+	restore()
+	os.Exit(code)
+}
+
+func TestHash(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 2,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	qt.Assert(t, m.Put(uint32(1), uint64(42)), qt.IsNil)
+	qt.Assert(t, m.Put(uint32(2), uint64(23)), qt.IsNil)
+	qt.Assert(t, m.Put(uint32(3), uint64(0)), qt.Not(qt.IsNil), qt.Commentf("map should be full"))
+	qt.Assert(t, m.Update(uint32(1), uint64(1), ebpf.UpdateNoExist), qt.ErrorIs, ebpf.ErrKeyExist)
+
+	var value uint64
+	qt.Assert(t, m.Lookup(uint32(1), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint64(42))
+
+	var (
+		key     uint32
+		entries = make(map[uint32]uint64)
+	)
+	iter := m.Iterate()
+	for iter.Next(&key, &value) {
+		entries[key] = value
+	}
+	qt.Assert(t, iter.Err(), qt.IsNil)
+	qt.Assert(t, entries, qt.DeepEquals, map[uint32]uint64{1: 42, 2: 23})
+
+	qt.Assert(t, m.Delete(uint32(1)), qt.IsNil)
+	qt.Assert(t, m.Lookup(uint32(1), &value), qt.ErrorIs, ebpf.ErrKeyNotExist)
+	qt.Assert(t, m.Delete(uint32(1)), qt.ErrorIs, ebpf.ErrKeyNotExist)
+}
+
+func TestLRUHash(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.LRUHash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	qt.Assert(t, m.Put(uint32(1), uint32(1)), qt.IsNil)
+	qt.Assert(t, m.Put(uint32(2), uint32(2)), qt.IsNil)
+
+	var value uint32
+	qt.Assert(t, m.Lookup(uint32(1), &value), qt.ErrorIs, ebpf.ErrKeyNotExist)
+	qt.Assert(t, m.Lookup(uint32(2), &value), qt.IsNil)
+}
+
+func TestArray(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	var value uint32
+	qt.Assert(t, m.Lookup(uint32(1), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint32(0))
+
+	qt.Assert(t, m.Put(uint32(1), uint32(42)), qt.IsNil)
+	qt.Assert(t, m.Lookup(uint32(1), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint32(42))
+
+	qt.Assert(t, m.Lookup(uint32(2), &value), qt.ErrorIs, ebpf.ErrKeyNotExist)
+	qt.Assert(t, m.Put(uint32(2), uint32(0)), qt.Not(qt.IsNil))
+	qt.Assert(t, m.Delete(uint32(0)), qt.Not(qt.IsNil))
+
+	var keys []uint32
+	var key uint32
+	iter := m.Iterate()
+	for iter.Next(&key, &value) {
+		keys = append(keys, key)
+	}
+	qt.Assert(t, iter.Err(), qt.IsNil)
+	qt.Assert(t, keys, qt.DeepEquals, []uint32{0, 1})
+
+	qt.Assert(t, m.Freeze(), qt.IsNil)
+	qt.Assert(t, m.Put(uint32(0), uint32(1)), qt.Not(qt.IsNil))
+}
+
+func TestPerCPUArray(t *testing.T) {
+	cpus, err := internal.PossibleCPUs()
+	qt.Assert(t, err, qt.IsNil)
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	values := make([]uint32, cpus)
+	for i := range values {
+		values[i] = uint32(i)
+	}
+	qt.Assert(t, m.Put(uint32(0), values), qt.IsNil)
+
+	var got []uint32
+	qt.Assert(t, m.Lookup(uint32(0), &got), qt.IsNil)
+	qt.Assert(t, got, qt.DeepEquals, values)
+}
+
+func TestQueue(t *testing.T) {
+	for _, test := range []struct {
+		typ  ebpf.MapType
+		want []uint32
+	}{
+		{ebpf.Queue, []uint32{1, 2}},
+		{ebpf.Stack, []uint32{2, 1}},
+	} {
+		t.Run(test.typ.String(), func(t *testing.T) {
+			m, err := ebpf.NewMap(&ebpf.MapSpec{
+				Type:       test.typ,
+				ValueSize:  4,
+				MaxEntries: 2,
+			})
+			qt.Assert(t, err, qt.IsNil)
+			defer m.Close()
+
+			qt.Assert(t, m.Put(nil, uint32(1)), qt.IsNil)
+			qt.Assert(t, m.Put(nil, uint32(2)), qt.IsNil)
+
+			var got []uint32
+			var value uint32
+			for m.LookupAndDelete(nil, &value) == nil {
+				got = append(got, value)
+			}
+			qt.Assert(t, got, qt.DeepEquals, test.want)
+			qt.Assert(t, m.LookupAndDelete(nil, &value), qt.ErrorIs, ebpf.ErrKeyNotExist)
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 10,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	keys := []uint32{0, 1, 2}
+	values := []uint32{42, 4242, 424242}
+	n, err := m.BatchUpdate(keys, values, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, n, qt.Equals, len(keys))
+
+	var cursor uint32
+	gotKeys := make([]uint32, 10)
+	gotValues := make([]uint32, 10)
+	n, err = m.BatchLookup(nil, &cursor, gotKeys, gotValues, nil)
+	qt.Assert(t, err, qt.ErrorIs, ebpf.ErrKeyNotExist)
+	qt.Assert(t, n, qt.Equals, len(keys))
+	qt.Assert(t, gotKeys[:n], qt.DeepEquals, keys)
+	qt.Assert(t, gotValues[:n], qt.DeepEquals, values)
+
+	n, err = m.BatchDelete(keys, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, n, qt.Equals, len(keys))
+}
+
+func TestMapInMap(t *testing.T) {
+	inner := &ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	}
+
+	outer, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.ArrayOfMaps,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+		InnerMap:   inner,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer outer.Close()
+
+	m, err := ebpf.NewMap(inner)
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	qt.Assert(t, outer.Put(uint32(0), m), qt.IsNil)
+
+	var got *ebpf.Map
+	qt.Assert(t, outer.Lookup(uint32(1), &got), qt.ErrorIs, ebpf.ErrKeyNotExist)
+	qt.Assert(t, outer.Lookup(uint32(0), &got), qt.IsNil)
+	defer got.Close()
+
+	info, err := got.Info()
+	qt.Assert(t, err, qt.IsNil)
+	want, err := m.Info()
+	qt.Assert(t, err, qt.IsNil)
+
+	gotID, _ := info.ID()
+	wantID, _ := want.ID()
+	qt.Assert(t, gotID, qt.Equals, wantID)
+}
+
+func TestMapFromID(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "test",
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	info, err := m.Info()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, info.Name, qt.Equals, "test")
+
+	id, ok := info.ID()
+	qt.Assert(t, ok, qt.IsTrue)
+
+	m2, err := ebpf.NewMapFromID(id)
+	qt.Assert(t, err, qt.IsNil)
+	defer m2.Close()
+
+	qt.Assert(t, m.Put(uint32(1), uint32(2)), qt.IsNil)
+
+	var value uint32
+	qt.Assert(t, m2.Lookup(uint32(1), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint32(2))
+}
+
+func TestPin(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	// Map.Pin requires a bpffs, so pin using the syscall directly.
+	path := "/sys/fs/bpf/fake"
+	err = sys.ObjPin(&sys.ObjPinAttr{
+		Pathname: sys.NewStringPointer(path),
+		BpfFd:    uint32(m.FD()),
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	pinned, err := ebpf.LoadPinnedMap(path, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer pinned.Close()
+
+	qt.Assert(t, m.Put(uint32(0), uint32(42)), qt.IsNil)
+
+	var value uint32
+	qt.Assert(t, pinned.Lookup(uint32(0), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint32(42))
+
+	_, err = ebpf.LoadPinnedMap("/sys/fs/bpf/does-not-exist", nil)
+	qt.Assert(t, errors.Is(err, os.ErrNotExist), qt.IsTrue)
+}
+
+func TestProgramAndLink(t *testing.T) {
+	arr, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer arr.Close()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type: ebpf.CGroupSKB,
+		Instructions: asm.Instructions{
+			asm.LoadMapPtr(asm.R1, arr.FD()),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer prog.Close()
+
+	info, err := prog.Info()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, info.Type, qt.Equals, ebpf.CGroupSKB)
+
+	ids, ok := info.MapIDs()
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, ids, qt.HasLen, 1)
+
+	cgroup, err := os.Open(os.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer cgroup.Close()
+
+	l, err := link.AttachRawLink(link.RawLinkOptions{
+		Target:  int(cgroup.Fd()),
+		Program: prog,
+		Attach:  ebpf.AttachCGroupInetEgress,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer l.Close()
+
+	linkInfo, err := l.Info()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, linkInfo.Type, qt.Equals, link.CgroupType)
+
+	_, _, err = prog.Test(make([]byte, 14))
+	qt.Assert(t, errors.Is(err, ebpf.ErrNotSupported), qt.IsTrue)
+}
+
+func TestFileDescriptors(t *testing.T) {
+	k := newKernel()
+	prev := sys.SetBackend(k)
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, m.FD() >= firstFD, qt.IsTrue, qt.Commentf("fd %d is not fake", m.FD()))
+
+	clone, err := m.Clone()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, clone.FD(), qt.Not(qt.Equals), m.FD())
+	qt.Assert(t, clone.Put(uint32(0), uint32(42)), qt.IsNil)
+
+	var value uint32
+	qt.Assert(t, m.Lookup(uint32(0), &value), qt.IsNil)
+	qt.Assert(t, value, qt.Equals, uint32(42))
+
+	qt.Assert(t, m.Close(), qt.IsNil)
+	qt.Assert(t, clone.Lookup(uint32(0), &value), qt.IsNil, qt.Commentf("closing m invalidated its clone"))
+
+	// File descriptors are closed by the kernel which created them, even if
+	// it isn't active anymore.
+	sys.SetBackend(prev)
+	qt.Assert(t, clone.Close(), qt.IsNil)
+	qt.Assert(t, k.fds, qt.HasLen, 0)
+}
+
+func TestAttachToFakeFD(t *testing.T) {
+	sockmap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.SockMap,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer sockmap.Close()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.SkMsg,
+		AttachType: ebpf.AttachSkMsgVerdict,
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	qt.Assert(t, err, qt.IsNil)
+	defer prog.Close()
+
+	opts := link.RawAttachProgramOptions{
+		Target:  sockmap.FD(),
+		Program: prog,
+		Attach:  ebpf.AttachSkMsgVerdict,
+	}
+	qt.Assert(t, link.RawAttachProgram(opts), qt.IsNil)
+	qt.Assert(t, link.RawDetachProgram(link.RawDetachProgramOptions{
+		Target:  opts.Target,
+		Program: opts.Program,
+		Attach:  opts.Attach,
+	}), qt.IsNil)
+
+	opts.Target = firstFD + 1<<20
+	qt.Assert(t, link.RawAttachProgram(opts), qt.Not(qt.IsNil))
+}
+
+func TestReleaseObjects(t *testing.T) {
+	k := newKernel()
+	prev := sys.SetBackend(k)
+	defer sys.SetBackend(prev)
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type: ebpf.SocketFilter,
+		Instructions: asm.Instructions{
+			asm.LoadMapPtr(asm.R1, m.FD()),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	// The program keeps the map alive.
+	qt.Assert(t, m.Close(), qt.IsNil)
+	qt.Assert(t, k.maps, qt.HasLen, 1)
+
+	qt.Assert(t, prog.Close(), qt.IsNil)
+	qt.Assert(t, k.progs, qt.HasLen, 0)
+	qt.Assert(t, k.maps, qt.HasLen, 0)
+}
@@ -0,0 +1,459 @@
+package fake
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Enable routes all BPF syscalls made by the library to a new, empty in-memory
+// kernel.
+//
+// Call the returned function to restore the previous behaviour. Objects created
+// while the fake kernel was enabled become unusable afterwards.
+func Enable() (restore func()) {
+	k := newKernel()
+	prev := sys.SetBackend(k)
+	return func() { sys.SetBackend(prev) }
+}
+
+// object is a map, program, link or BTF blob known to the kernel.
+type object interface {
+	objID() uint32
+}
+
+// firstFD is the lowest file descriptor handed out by the fake kernel.
+//
+// File descriptors only exist in the fake kernel, which allows running it on
+// any platform. Real file descriptors never reach this value, so they can't
+// be confused with fake ones.
+const firstFD = 1 << 30
+
+type kernel struct {
+	mu sync.Mutex
+
+	// Objects by file descriptor. The object is nil for file descriptors
+	// which don't refer to a BPF object.
+	fds    map[int]object
+	nextFD int
+
+	maps   map[uint32]*bpfMap
+	progs  map[uint32]*prog
+	links  map[uint32]*bpfLink
+	btfs   map[uint32]*btfBlob
+	pinned map[string]object
+	lastID uint32
+}
+
+func newKernel() *kernel {
+	return &kernel{
+		fds:    make(map[int]object),
+		nextFD: firstFD,
+		maps:   make(map[uint32]*bpfMap),
+		progs:  make(map[uint32]*prog),
+		links:  make(map[uint32]*bpfLink),
+		btfs:   make(map[uint32]*btfBlob),
+		pinned: make(map[string]object),
+	}
+}
+
+// BPF implements sys.Backend.
+func (k *kernel) BPF(cmd sys.Cmd, attr unsafe.Pointer, size uintptr) (uintptr, syscall.Errno) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch cmd {
+	case sys.BPF_MAP_CREATE:
+		return k.mapCreate((*sys.MapCreateAttr)(attr))
+	case sys.BPF_MAP_LOOKUP_ELEM:
+		return 0, k.mapLookup((*sys.MapLookupElemAttr)(attr), false)
+	case sys.BPF_MAP_LOOKUP_AND_DELETE_ELEM:
+		return 0, k.mapLookup((*sys.MapLookupElemAttr)(attr), true)
+	case sys.BPF_MAP_UPDATE_ELEM:
+		return 0, k.mapUpdate((*sys.MapUpdateElemAttr)(attr))
+	case sys.BPF_MAP_DELETE_ELEM:
+		return 0, k.mapDelete((*sys.MapDeleteElemAttr)(attr))
+	case sys.BPF_MAP_GET_NEXT_KEY:
+		return 0, k.mapNextKey((*sys.MapGetNextKeyAttr)(attr))
+	case sys.BPF_MAP_FREEZE:
+		return 0, k.mapFreeze((*sys.MapFreezeAttr)(attr))
+	case sys.BPF_MAP_LOOKUP_BATCH:
+		return 0, k.mapLookupBatch((*sys.MapLookupBatchAttr)(attr), false)
+	case sys.BPF_MAP_LOOKUP_AND_DELETE_BATCH:
+		return 0, k.mapLookupBatch((*sys.MapLookupBatchAttr)(attr), true)
+	case sys.BPF_MAP_UPDATE_BATCH:
+		return 0, k.mapUpdateBatch((*sys.MapUpdateBatchAttr)(attr))
+	case sys.BPF_MAP_DELETE_BATCH:
+		return 0, k.mapDeleteBatch((*sys.MapDeleteBatchAttr)(attr))
+	case sys.BPF_PROG_LOAD:
+		return k.progLoad((*sys.ProgLoadAttr)(attr))
+	case sys.BPF_PROG_BIND_MAP:
+		return 0, k.progBindMap((*sys.ProgBindMapAttr)(attr))
+	case sys.BPF_PROG_ATTACH:
+		return 0, k.progAttach((*sys.ProgAttachAttr)(attr))
+	case sys.BPF_PROG_DETACH:
+		return 0, k.progDetach((*sys.ProgDetachAttr)(attr))
+	case sys.BPF_PROG_TEST_RUN:
+		// Programs are never executed.
+		return 0, unix.ENOTSUPP
+	case sys.BPF_LINK_CREATE:
+		return k.linkCreate((*sys.LinkCreateAttr)(attr))
+	case sys.BPF_LINK_UPDATE:
+		return 0, k.linkUpdate((*sys.LinkUpdateAttr)(attr))
+	case sys.BPF_RAW_TRACEPOINT_OPEN:
+		return k.rawTracepointOpen((*sys.RawTracepointOpenAttr)(attr))
+	case sys.BPF_BTF_LOAD:
+		return k.btfLoad((*sys.BtfLoadAttr)(attr))
+	case sys.BPF_ENABLE_STATS:
+		return k.newFD(nil)
+	case sys.BPF_OBJ_GET_INFO_BY_FD:
+		return 0, k.objInfo((*sys.ObjGetInfoByFdAttr)(attr))
+	case sys.BPF_OBJ_PIN:
+		return 0, k.objPin((*sys.ObjPinAttr)(attr))
+	case sys.BPF_OBJ_GET:
+		return k.objGet((*sys.ObjGetAttr)(attr))
+	case sys.BPF_MAP_GET_NEXT_ID, sys.BPF_PROG_GET_NEXT_ID, sys.BPF_LINK_GET_NEXT_ID, sys.BPF_BTF_GET_NEXT_ID:
+		return 0, k.nextID(cmd, (*sys.MapGetNextIdAttr)(attr))
+	case sys.BPF_MAP_GET_FD_BY_ID, sys.BPF_PROG_GET_FD_BY_ID, sys.BPF_LINK_GET_FD_BY_ID, sys.BPF_BTF_GET_FD_BY_ID:
+		return k.fdByID(cmd, (*sys.MapGetFdByIdAttr)(attr))
+	default:
+		return 0, unix.EINVAL
+	}
+}
+
+// newID allocates an ID which is unique across all object types.
+func (k *kernel) newID() uint32 {
+	k.lastID++
+	return k.lastID
+}
+
+// newFD allocates a file descriptor referring to obj.
+//
+// obj may be nil if the fd doesn't refer to a BPF object.
+func (k *kernel) newFD(obj object) (uintptr, syscall.Errno) {
+	fd := k.nextFD
+	k.nextFD++
+	k.fds[fd] = obj
+	return uintptr(fd), 0
+}
+
+// object returns the object referred to by fd.
+//
+// Returns EBADF if fd doesn't refer to an object.
+func (k *kernel) object(fd uint32) (object, syscall.Errno) {
+	obj := k.fds[int(int32(fd))]
+	if obj == nil {
+		return nil, unix.EBADF
+	}
+
+	return obj, 0
+}
+
+// Dup implements sys.Backend.
+//
+// File descriptors which weren't created by the fake kernel are passed to
+// the operating system.
+func (k *kernel) Dup(fd int) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	obj, ok := k.fds[fd]
+	if !ok {
+		return unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 1)
+	}
+
+	dup, _ := k.newFD(obj)
+	return int(dup), nil
+}
+
+// Close implements sys.Backend.
+//
+// File descriptors which weren't created by the fake kernel are passed to
+// the operating system.
+func (k *kernel) Close(fd int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	obj, ok := k.fds[fd]
+	if !ok {
+		return unix.Close(fd)
+	}
+
+	delete(k.fds, fd)
+	if obj != nil {
+		k.collect()
+	}
+	return nil
+}
+
+// collect forgets all objects which are not referenced anymore, similar to
+// the kernel freeing an object once its refcount drops to zero.
+func (k *kernel) collect() {
+	for {
+		var unused []object
+		for _, m := range k.maps {
+			if !k.inUse(m) {
+				unused = append(unused, m)
+			}
+		}
+		for _, p := range k.progs {
+			if !k.inUse(p) {
+				unused = append(unused, p)
+			}
+		}
+		for _, l := range k.links {
+			if !k.inUse(l) {
+				unused = append(unused, l)
+			}
+		}
+		for _, b := range k.btfs {
+			if !k.inUse(b) {
+				unused = append(unused, b)
+			}
+		}
+
+		if len(unused) == 0 {
+			return
+		}
+
+		// Releasing an object may release the objects it refers to.
+		for _, obj := range unused {
+			switch obj := obj.(type) {
+			case *bpfMap:
+				delete(k.maps, obj.id)
+			case *prog:
+				delete(k.progs, obj.id)
+			case *bpfLink:
+				delete(k.links, obj.id)
+			case *btfBlob:
+				delete(k.btfs, obj.id)
+			}
+		}
+	}
+}
+
+// inUse returns true if obj is referred to by a file descriptor, a pin or
+// another object.
+func (k *kernel) inUse(obj object) bool {
+	for _, o := range k.fds {
+		if o == obj {
+			return true
+		}
+	}
+
+	for _, o := range k.pinned {
+		if o == obj {
+			return true
+		}
+	}
+
+	id := obj.objID()
+	switch obj.(type) {
+	case *bpfMap:
+		for _, p := range k.progs {
+			for _, mapID := range p.mapIDs {
+				if mapID == id {
+					return true
+				}
+			}
+		}
+		return k.storedInMap(id, sys.BPF_MAP_TYPE_ARRAY_OF_MAPS, sys.BPF_MAP_TYPE_HASH_OF_MAPS)
+
+	case *prog:
+		for _, l := range k.links {
+			if l.progID == id {
+				return true
+			}
+		}
+		return k.storedInMap(id, sys.BPF_MAP_TYPE_PROG_ARRAY)
+
+	case *btfBlob:
+		for _, m := range k.maps {
+			if m.btfID == id {
+				return true
+			}
+		}
+		for _, p := range k.progs {
+			if p.btfID == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// storedInMap returns true if a map of one of the given types holds id as
+// a value.
+func (k *kernel) storedInMap(id uint32, types ...sys.MapType) bool {
+	for _, m := range k.maps {
+		for _, typ := range types {
+			if m.typ != typ {
+				continue
+			}
+
+			for _, value := range m.values {
+				if internal.NativeEndian.Uint32(value) == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (k *kernel) objInfo(attr *sys.ObjGetInfoByFdAttr) syscall.Errno {
+	obj, errNo := k.object(attr.BpfFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	var info []byte
+	switch obj := obj.(type) {
+	case *bpfMap:
+		info = obj.info()
+	case *prog:
+		return obj.info(attr)
+	case *bpfLink:
+		info = obj.info()
+	case *btfBlob:
+		return obj.info(attr)
+	default:
+		return unix.EINVAL
+	}
+
+	copy(bytesAt(attr.Info, int(attr.InfoLen)), info)
+	if int(attr.InfoLen) > len(info) {
+		attr.InfoLen = uint32(len(info))
+	}
+	return 0
+}
+
+func (k *kernel) objPin(attr *sys.ObjPinAttr) syscall.Errno {
+	obj, errNo := k.object(attr.BpfFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	path := stringAt(attr.Pathname)
+	if k.pinned[path] != nil {
+		return unix.EEXIST
+	}
+
+	k.pinned[path] = obj
+	return 0
+}
+
+func (k *kernel) objGet(attr *sys.ObjGetAttr) (uintptr, syscall.Errno) {
+	path := stringAt(attr.Pathname)
+	obj := k.pinned[path]
+	if obj == nil {
+		return 0, unix.ENOENT
+	}
+
+	return k.newFD(obj)
+}
+
+// ids returns the sorted IDs of all objects of a kind.
+func (k *kernel) ids(cmd sys.Cmd) []uint32 {
+	var ids []uint32
+	switch cmd {
+	case sys.BPF_MAP_GET_NEXT_ID, sys.BPF_MAP_GET_FD_BY_ID:
+		for id := range k.maps {
+			ids = append(ids, id)
+		}
+	case sys.BPF_PROG_GET_NEXT_ID, sys.BPF_PROG_GET_FD_BY_ID:
+		for id := range k.progs {
+			ids = append(ids, id)
+		}
+	case sys.BPF_LINK_GET_NEXT_ID, sys.BPF_LINK_GET_FD_BY_ID:
+		for id := range k.links {
+			ids = append(ids, id)
+		}
+	case sys.BPF_BTF_GET_NEXT_ID, sys.BPF_BTF_GET_FD_BY_ID:
+		for id := range k.btfs {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (k *kernel) nextID(cmd sys.Cmd, attr *sys.MapGetNextIdAttr) syscall.Errno {
+	for _, id := range k.ids(cmd) {
+		if id > attr.Id {
+			attr.NextId = id
+			return 0
+		}
+	}
+	return unix.ENOENT
+}
+
+func (k *kernel) fdByID(cmd sys.Cmd, attr *sys.MapGetFdByIdAttr) (uintptr, syscall.Errno) {
+	var obj object
+	switch cmd {
+	case sys.BPF_MAP_GET_FD_BY_ID:
+		if m := k.maps[attr.Id]; m != nil {
+			obj = m
+		}
+	case sys.BPF_PROG_GET_FD_BY_ID:
+		if p := k.progs[attr.Id]; p != nil {
+			obj = p
+		}
+	case sys.BPF_LINK_GET_FD_BY_ID:
+		if l := k.links[attr.Id]; l != nil {
+			obj = l
+		}
+	case sys.BPF_BTF_GET_FD_BY_ID:
+		if b := k.btfs[attr.Id]; b != nil {
+			obj = b
+		}
+	}
+
+	if obj == nil {
+		return 0, unix.ENOENT
+	}
+
+	return k.newFD(obj)
+}
+
+// bytesAt returns a slice of n bytes starting at ptr.
+//
+// Returns nil if ptr is nil.
+func bytesAt(ptr sys.Pointer, n int) []byte {
+	if ptr.Unsafe() == nil || n <= 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(ptr.Unsafe()), n)
+}
+
+// stringAt returns the NUL-terminated string starting at ptr.
+func stringAt(ptr sys.Pointer) string {
+	if ptr.Unsafe() == nil {
+		return ""
+	}
+
+	var b []byte
+	for i := 0; ; i++ {
+		c := *(*byte)(unsafe.Add(ptr.Unsafe(), i))
+		if c == 0 {
+			return string(b)
+		}
+		b = append(b, c)
+	}
+}
+
+func errno(err error) syscall.Errno {
+	var errNo syscall.Errno
+	if errors.As(err, &errNo) {
+		return errNo
+	}
+	return unix.EINVAL
+}
@@ -0,0 +1,590 @@
+package fake
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Flags accepted by BPF_MAP_UPDATE_ELEM.
+const (
+	updateNoExist = 1 << 0
+	updateExist   = 1 << 1
+)
+
+type mapKind int
+
+const (
+	unsupportedMap mapKind = iota
+	hashMap
+	arrayMap
+	queueMap
+	stackMap
+)
+
+type bpfMap struct {
+	id      uint32
+	typ     sys.MapType
+	name    sys.ObjName
+	flags   uint32
+	keySize uint32
+	// The size of a value as seen by user space. For per-CPU maps this is
+	// the size of the value for all possible CPUs.
+	valueSize  uint32
+	specValue  uint32
+	maxEntries uint32
+	btfID      uint32
+	frozen     bool
+
+	// keys holds the keys of hash maps in insertion order, which is also
+	// the iteration order. queue holds the values of queue and stack maps.
+	keys   [][]byte
+	values map[string][]byte
+	queue  [][]byte
+}
+
+func (m *bpfMap) objID() uint32 { return m.id }
+
+func (m *bpfMap) kind() mapKind {
+	switch m.typ {
+	case sys.BPF_MAP_TYPE_HASH, sys.BPF_MAP_TYPE_PERCPU_HASH,
+		sys.BPF_MAP_TYPE_LRU_HASH, sys.BPF_MAP_TYPE_LRU_PERCPU_HASH,
+		sys.BPF_MAP_TYPE_HASH_OF_MAPS:
+		return hashMap
+	case sys.BPF_MAP_TYPE_ARRAY, sys.BPF_MAP_TYPE_PERCPU_ARRAY,
+		sys.BPF_MAP_TYPE_PROG_ARRAY, sys.BPF_MAP_TYPE_ARRAY_OF_MAPS,
+		sys.BPF_MAP_TYPE_PERF_EVENT_ARRAY:
+		return arrayMap
+	case sys.BPF_MAP_TYPE_QUEUE:
+		return queueMap
+	case sys.BPF_MAP_TYPE_STACK:
+		return stackMap
+	default:
+		return unsupportedMap
+	}
+}
+
+// holdsFDs returns true if user space stores file descriptors in the map,
+// but receives IDs when looking up values.
+func (m *bpfMap) holdsFDs() bool {
+	switch m.typ {
+	case sys.BPF_MAP_TYPE_PROG_ARRAY, sys.BPF_MAP_TYPE_ARRAY_OF_MAPS,
+		sys.BPF_MAP_TYPE_HASH_OF_MAPS, sys.BPF_MAP_TYPE_PERF_EVENT_ARRAY:
+		return true
+	default:
+		return false
+	}
+}
+
+// sparse returns true if slots in an array may be empty.
+func (m *bpfMap) sparse() bool {
+	return m.kind() == hashMap || m.holdsFDs()
+}
+
+func (m *bpfMap) info() []byte {
+	info := sys.MapInfo{
+		Type:       uint32(m.typ),
+		Id:         m.id,
+		KeySize:    m.keySize,
+		ValueSize:  m.specValue,
+		MaxEntries: m.maxEntries,
+		MapFlags:   m.flags,
+		Name:       m.name,
+		BtfId:      m.btfID,
+	}
+	return structBytes(unsafe.Pointer(&info), unsafe.Sizeof(info))
+}
+
+func (k *kernel) mapCreate(attr *sys.MapCreateAttr) (uintptr, syscall.Errno) {
+	m := &bpfMap{
+		typ:        attr.MapType,
+		name:       attr.MapName,
+		flags:      attr.MapFlags,
+		keySize:    attr.KeySize,
+		valueSize:  attr.ValueSize,
+		specValue:  attr.ValueSize,
+		maxEntries: attr.MaxEntries,
+		values:     make(map[string][]byte),
+	}
+
+	switch m.typ {
+	case sys.BPF_MAP_TYPE_ARRAY_OF_MAPS, sys.BPF_MAP_TYPE_HASH_OF_MAPS:
+		inner, errNo := k.object(attr.InnerMapFd)
+		if errNo != 0 {
+			return 0, errNo
+		}
+		if _, ok := inner.(*bpfMap); !ok {
+			return 0, unix.EINVAL
+		}
+
+	case sys.BPF_MAP_TYPE_PERCPU_HASH, sys.BPF_MAP_TYPE_PERCPU_ARRAY,
+		sys.BPF_MAP_TYPE_LRU_PERCPU_HASH, sys.BPF_MAP_TYPE_PERCPU_CGROUP_STORAGE:
+		cpus, err := internal.PossibleCPUs()
+		if err != nil {
+			return 0, unix.EINVAL
+		}
+		m.valueSize = uint32(internal.Align(int(m.valueSize), 8) * cpus)
+	}
+
+	if m.kind() == arrayMap && m.keySize != 4 {
+		return 0, unix.EINVAL
+	}
+
+	if (m.kind() == queueMap || m.kind() == stackMap) && m.keySize != 0 {
+		return 0, unix.EINVAL
+	}
+
+	if m.maxEntries == 0 && m.typ != sys.BPF_MAP_TYPE_PERF_EVENT_ARRAY {
+		return 0, unix.EINVAL
+	}
+
+	if attr.BtfFd != 0 {
+		obj, errNo := k.object(attr.BtfFd)
+		if errNo != 0 {
+			return 0, errNo
+		}
+		b, ok := obj.(*btfBlob)
+		if !ok {
+			return 0, unix.EINVAL
+		}
+		m.btfID = b.id
+	}
+
+	m.id = k.newID()
+	fd, errNo := k.newFD(m)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	k.maps[m.id] = m
+	return fd, 0
+}
+
+func (k *kernel) lookupMap(fd uint32) (*bpfMap, syscall.Errno) {
+	obj, errNo := k.object(fd)
+	if errNo != 0 {
+		return nil, errNo
+	}
+
+	m, ok := obj.(*bpfMap)
+	if !ok {
+		return nil, unix.EINVAL
+	}
+
+	if m.kind() == unsupportedMap {
+		return nil, unix.ENOTSUPP
+	}
+
+	return m, 0
+}
+
+// index decodes the key of an array map.
+func (m *bpfMap) index(key []byte) uint32 {
+	return internal.NativeEndian.Uint32(key)
+}
+
+// get returns the value stored at key.
+func (m *bpfMap) get(key []byte) ([]byte, syscall.Errno) {
+	if m.kind() == arrayMap && m.index(key) >= m.maxEntries {
+		return nil, unix.ENOENT
+	}
+
+	value, ok := m.values[string(key)]
+	if ok {
+		return value, 0
+	}
+
+	if m.sparse() {
+		return nil, unix.ENOENT
+	}
+
+	return make([]byte, m.valueSize), 0
+}
+
+// set stores value at key, honouring the semantics of flags.
+func (m *bpfMap) set(key, value []byte, flags uint64) syscall.Errno {
+	_, exists := m.values[string(key)]
+
+	switch m.kind() {
+	case arrayMap:
+		if m.index(key) >= m.maxEntries {
+			return unix.E2BIG
+		}
+		if flags&updateNoExist > 0 {
+			return unix.EEXIST
+		}
+
+	case hashMap:
+		if flags&updateNoExist > 0 && exists {
+			return unix.EEXIST
+		}
+		if flags&updateExist > 0 && !exists {
+			return unix.ENOENT
+		}
+
+		if !exists && uint32(len(m.keys)) >= m.maxEntries {
+			if m.typ != sys.BPF_MAP_TYPE_LRU_HASH && m.typ != sys.BPF_MAP_TYPE_LRU_PERCPU_HASH {
+				return unix.E2BIG
+			}
+
+			// Evict the oldest element.
+			m.remove(m.keys[0])
+		}
+
+		if !exists {
+			m.keys = append(m.keys, append([]byte(nil), key...))
+		}
+	}
+
+	m.values[string(key)] = append([]byte(nil), value...)
+	return 0
+}
+
+// remove deletes the value at key.
+func (m *bpfMap) remove(key []byte) syscall.Errno {
+	if m.kind() == arrayMap && !m.sparse() {
+		return unix.EINVAL
+	}
+
+	if _, ok := m.values[string(key)]; !ok {
+		return unix.ENOENT
+	}
+
+	delete(m.values, string(key))
+	for i, k := range m.keys {
+		if bytes.Equal(k, key) {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return 0
+}
+
+// next returns the key following key, or the first key if key is nil or
+// doesn't exist.
+func (m *bpfMap) next(key []byte) ([]byte, syscall.Errno) {
+	switch m.kind() {
+	case arrayMap:
+		next := uint32(0)
+		if key != nil && m.index(key) < m.maxEntries {
+			next = m.index(key) + 1
+		}
+		if next >= m.maxEntries {
+			return nil, unix.ENOENT
+		}
+
+		buf := make([]byte, 4)
+		internal.NativeEndian.PutUint32(buf, next)
+		return buf, 0
+
+	case hashMap:
+		i := 0
+		if key != nil {
+			for j, k := range m.keys {
+				if bytes.Equal(k, key) {
+					i = j + 1
+					break
+				}
+			}
+		}
+		if i >= len(m.keys) {
+			return nil, unix.ENOENT
+		}
+		return m.keys[i], 0
+
+	default:
+		return nil, unix.EINVAL
+	}
+}
+
+// pop removes the next value from a queue or stack map.
+func (m *bpfMap) pop(remove bool) ([]byte, syscall.Errno) {
+	if len(m.queue) == 0 {
+		return nil, unix.ENOENT
+	}
+
+	i := 0
+	if m.kind() == stackMap {
+		i = len(m.queue) - 1
+	}
+
+	value := m.queue[i]
+	if remove {
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+	}
+	return value, 0
+}
+
+func (k *kernel) mapLookup(attr *sys.MapLookupElemAttr, remove bool) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if remove && m.frozen {
+		return unix.EPERM
+	}
+
+	var value []byte
+	switch m.kind() {
+	case queueMap, stackMap:
+		value, errNo = m.pop(remove)
+
+	default:
+		key := bytesAt(attr.Key, int(m.keySize))
+		value, errNo = m.get(key)
+		if errNo == 0 && remove {
+			errNo = m.remove(key)
+		}
+	}
+	if errNo != 0 {
+		return errNo
+	}
+
+	copy(bytesAt(attr.Value, int(m.valueSize)), value)
+	return 0
+}
+
+func (k *kernel) mapUpdate(attr *sys.MapUpdateElemAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.frozen {
+		return unix.EPERM
+	}
+
+	value, errNo := k.mapValue(m, bytesAt(attr.Value, int(m.valueSize)))
+	if errNo != 0 {
+		return errNo
+	}
+
+	switch m.kind() {
+	case queueMap, stackMap:
+		if uint32(len(m.queue)) >= m.maxEntries {
+			if attr.Flags&updateExist == 0 {
+				return unix.E2BIG
+			}
+			m.queue = m.queue[1:]
+		}
+		m.queue = append(m.queue, value)
+		return 0
+
+	default:
+		return m.set(bytesAt(attr.Key, int(m.keySize)), value, attr.Flags)
+	}
+}
+
+// mapValue copies a value passed from user space.
+//
+// File descriptors in fd maps are replaced by the ID of the object they
+// refer to.
+func (k *kernel) mapValue(m *bpfMap, value []byte) ([]byte, syscall.Errno) {
+	value = append([]byte(nil), value...)
+	if !m.holdsFDs() || m.typ == sys.BPF_MAP_TYPE_PERF_EVENT_ARRAY {
+		return value, 0
+	}
+
+	obj, errNo := k.object(internal.NativeEndian.Uint32(value))
+	if errNo != 0 {
+		return nil, errNo
+	}
+
+	switch obj.(type) {
+	case *bpfMap:
+		if m.typ == sys.BPF_MAP_TYPE_PROG_ARRAY {
+			return nil, unix.EINVAL
+		}
+	case *prog:
+		if m.typ != sys.BPF_MAP_TYPE_PROG_ARRAY {
+			return nil, unix.EINVAL
+		}
+	default:
+		return nil, unix.EINVAL
+	}
+
+	internal.NativeEndian.PutUint32(value, obj.objID())
+	return value, 0
+}
+
+func (k *kernel) mapDelete(attr *sys.MapDeleteElemAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.frozen {
+		return unix.EPERM
+	}
+
+	if m.kind() == queueMap || m.kind() == stackMap {
+		return unix.EINVAL
+	}
+
+	return m.remove(bytesAt(attr.Key, int(m.keySize)))
+}
+
+func (k *kernel) mapNextKey(attr *sys.MapGetNextKeyAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	next, errNo := m.next(bytesAt(attr.Key, int(m.keySize)))
+	if errNo != 0 {
+		return errNo
+	}
+
+	copy(bytesAt(attr.NextKey, int(m.keySize)), next)
+	return 0
+}
+
+func (k *kernel) mapFreeze(attr *sys.MapFreezeAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.frozen {
+		return unix.EBUSY
+	}
+
+	m.frozen = true
+	return 0
+}
+
+// mapLookupBatch implements BPF_MAP_LOOKUP_BATCH and its deleting variant.
+//
+// The batch token is the last key returned by the previous call.
+func (k *kernel) mapLookupBatch(attr *sys.MapLookupBatchAttr, remove bool) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.kind() != hashMap && m.kind() != arrayMap {
+		return unix.EINVAL
+	}
+
+	if remove && m.frozen {
+		return unix.EPERM
+	}
+
+	var (
+		keySize   = int(m.keySize)
+		valueSize = int(m.valueSize)
+		keys      = bytesAt(attr.Keys, int(attr.Count)*keySize)
+		values    = bytesAt(attr.Values, int(attr.Count)*valueSize)
+		key       = bytesAt(attr.InBatch, keySize)
+		count     uint32
+		deleted   [][]byte
+	)
+
+	for count < attr.Count {
+		next, errNo := m.next(key)
+		if errNo == unix.ENOENT {
+			break
+		}
+		if errNo != 0 {
+			return errNo
+		}
+
+		value, errNo := m.get(next)
+		if errNo == unix.ENOENT {
+			// Skip empty slots in fd arrays.
+			key = next
+			continue
+		}
+
+		copy(keys[int(count)*keySize:], next)
+		copy(values[int(count)*valueSize:], value)
+		copy(bytesAt(attr.OutBatch, keySize), next)
+		if remove {
+			deleted = append(deleted, next)
+		}
+
+		key = next
+		count++
+	}
+
+	for _, key := range deleted {
+		m.remove(key)
+	}
+
+	// Signal that the map has been exhausted.
+	exhausted := count < attr.Count
+	attr.Count = count
+	if exhausted {
+		return unix.ENOENT
+	}
+	return 0
+}
+
+func (k *kernel) mapUpdateBatch(attr *sys.MapUpdateBatchAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.kind() != hashMap && m.kind() != arrayMap {
+		return unix.EINVAL
+	}
+
+	if m.frozen {
+		return unix.EPERM
+	}
+
+	keySize, valueSize := int(m.keySize), int(m.valueSize)
+	keys := bytesAt(attr.Keys, int(attr.Count)*keySize)
+	values := bytesAt(attr.Values, int(attr.Count)*valueSize)
+
+	for i := 0; i < int(attr.Count); i++ {
+		value, errNo := k.mapValue(m, values[i*valueSize:(i+1)*valueSize])
+		if errNo == 0 {
+			errNo = m.set(keys[i*keySize:(i+1)*keySize], value, attr.ElemFlags)
+		}
+		if errNo != 0 {
+			attr.Count = uint32(i)
+			return errNo
+		}
+	}
+
+	return 0
+}
+
+func (k *kernel) mapDeleteBatch(attr *sys.MapDeleteBatchAttr) syscall.Errno {
+	m, errNo := k.lookupMap(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if m.kind() != hashMap && m.kind() != arrayMap {
+		return unix.EINVAL
+	}
+
+	if m.frozen {
+		return unix.EPERM
+	}
+
+	keySize := int(m.keySize)
+	keys := bytesAt(attr.Keys, int(attr.Count)*keySize)
+
+	for i := 0; i < int(attr.Count); i++ {
+		if errNo := m.remove(keys[i*keySize : (i+1)*keySize]); errNo != 0 {
+			attr.Count = uint32(i)
+			return errNo
+		}
+	}
+
+	return 0
+}
+
+// structBytes returns a copy of the memory backing a struct.
+func structBytes(ptr unsafe.Pointer, size uintptr) []byte {
+	return append([]byte(nil), unsafe.Slice((*byte)(ptr), size)...)
+}
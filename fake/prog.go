@@ -0,0 +1,388 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+type prog struct {
+	id                 uint32
+	typ                sys.ProgType
+	expectedAttachType sys.AttachType
+	name               sys.ObjName
+	insns              []byte
+	tag                [unix.BPF_TAG_SIZE]uint8
+	mapIDs             []uint32
+	btfID              uint32
+}
+
+func (p *prog) objID() uint32 { return p.id }
+
+func (p *prog) info(attr *sys.ObjGetInfoByFdAttr) syscall.Errno {
+	var info sys.ProgInfo
+	in := bytesAt(attr.Info, int(attr.InfoLen))
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&info)), unsafe.Sizeof(info)), in)
+
+	info.Type = uint32(p.typ)
+	info.Id = p.id
+	info.Tag = p.tag
+	info.Name = p.name
+	info.BtfId = p.btfID
+
+	if info.XlatedProgLen > 0 {
+		copy(bytesAt(info.XlatedProgInsns, int(info.XlatedProgLen)), p.insns)
+	}
+	info.XlatedProgLen = uint32(len(p.insns))
+
+	if info.NrMapIds > 0 {
+		ids := bytesAt(info.MapIds, int(info.NrMapIds)*4)
+		for i := 0; i < len(p.mapIDs) && (i+1)*4 <= len(ids); i++ {
+			internal.NativeEndian.PutUint32(ids[i*4:], p.mapIDs[i])
+		}
+	}
+	info.NrMapIds = uint32(len(p.mapIDs))
+
+	out := structBytes(unsafe.Pointer(&info), unsafe.Sizeof(info))
+	copy(in, out)
+	if int(attr.InfoLen) > len(out) {
+		attr.InfoLen = uint32(len(out))
+	}
+	return 0
+}
+
+func (k *kernel) lookupProg(fd uint32) (*prog, syscall.Errno) {
+	obj, errNo := k.object(fd)
+	if errNo != 0 {
+		return nil, errNo
+	}
+
+	p, ok := obj.(*prog)
+	if !ok {
+		return nil, unix.EINVAL
+	}
+
+	return p, 0
+}
+
+func (k *kernel) progLoad(attr *sys.ProgLoadAttr) (uintptr, syscall.Errno) {
+	if attr.InsnCnt == 0 {
+		return 0, unix.EINVAL
+	}
+
+	raw := append([]byte(nil), bytesAt(attr.Insns, int(attr.InsnCnt)*asm.InstructionSize)...)
+
+	var insns asm.Instructions
+	if err := insns.Unmarshal(bytes.NewReader(raw), internal.NativeEndian); err != nil {
+		return 0, unix.EINVAL
+	}
+
+	p := &prog{
+		typ:                attr.ProgType,
+		expectedAttachType: attr.ExpectedAttachType,
+		name:               attr.ProgName,
+		insns:              raw,
+	}
+
+	for _, ins := range insns {
+		if !ins.IsLoadFromMap() {
+			continue
+		}
+
+		m, errNo := k.lookupMap(uint32(ins.Constant))
+		if errNo == unix.ENOTSUPP {
+			continue
+		}
+		if errNo != 0 {
+			return 0, errNo
+		}
+		p.bindMap(m)
+	}
+
+	tag, err := insns.Tag(internal.NativeEndian)
+	if err != nil {
+		return 0, unix.EINVAL
+	}
+	if _, err := hex.Decode(p.tag[:], []byte(tag)); err != nil {
+		return 0, unix.EINVAL
+	}
+
+	if attr.ProgBtfFd != 0 {
+		obj, errNo := k.object(attr.ProgBtfFd)
+		if errNo != 0 {
+			return 0, errNo
+		}
+		b, ok := obj.(*btfBlob)
+		if !ok {
+			return 0, unix.EINVAL
+		}
+		p.btfID = b.id
+	}
+
+	p.id = k.newID()
+	fd, errNo := k.newFD(p)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	k.progs[p.id] = p
+	return fd, 0
+}
+
+// bindMap records that the program uses m.
+func (p *prog) bindMap(m *bpfMap) {
+	for _, id := range p.mapIDs {
+		if id == m.id {
+			return
+		}
+	}
+	p.mapIDs = append(p.mapIDs, m.id)
+}
+
+func (k *kernel) progBindMap(attr *sys.ProgBindMapAttr) syscall.Errno {
+	p, errNo := k.lookupProg(attr.ProgFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	obj, errNo := k.object(attr.MapFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	m, ok := obj.(*bpfMap)
+	if !ok {
+		return unix.EINVAL
+	}
+
+	p.bindMap(m)
+	return 0
+}
+
+// isFD returns true if fd is an open file descriptor.
+//
+// Descriptors handed out by the fake kernel are looked up in its own table.
+// Others belong to the operating system, for example a cgroup directory. They
+// can only be checked on Linux and are accepted elsewhere.
+func (k *kernel) isFD(fd uint32) bool {
+	n := int(int32(fd))
+	if n >= firstFD {
+		_, ok := k.fds[n]
+		return ok
+	}
+
+	if n < 0 {
+		return false
+	}
+
+	if runtime.GOOS != "linux" {
+		return true
+	}
+
+	var stat unix.Stat_t
+	return unix.Fstat(n, &stat) == nil
+}
+
+func (k *kernel) progAttach(attr *sys.ProgAttachAttr) syscall.Errno {
+	if _, errNo := k.lookupProg(attr.AttachBpfFd); errNo != 0 {
+		return errNo
+	}
+
+	if !k.isFD(attr.TargetFd) {
+		return unix.EBADF
+	}
+
+	return 0
+}
+
+func (k *kernel) progDetach(attr *sys.ProgDetachAttr) syscall.Errno {
+	if attr.AttachBpfFd != 0 {
+		if _, errNo := k.lookupProg(attr.AttachBpfFd); errNo != 0 {
+			return errNo
+		}
+	}
+
+	if !k.isFD(attr.TargetFd) {
+		return unix.EBADF
+	}
+
+	return 0
+}
+
+type bpfLink struct {
+	id     uint32
+	typ    sys.LinkType
+	progID uint32
+}
+
+func (l *bpfLink) objID() uint32 { return l.id }
+
+func (l *bpfLink) info() []byte {
+	info := sys.LinkInfo{
+		Type:   l.typ,
+		Id:     sys.LinkID(l.id),
+		ProgId: l.progID,
+	}
+	return structBytes(unsafe.Pointer(&info), unsafe.Sizeof(info))
+}
+
+func (k *kernel) newLink(typ sys.LinkType, p *prog) (uintptr, syscall.Errno) {
+	l := &bpfLink{
+		id:     k.newID(),
+		typ:    typ,
+		progID: p.id,
+	}
+
+	fd, errNo := k.newFD(l)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	k.links[l.id] = l
+	return fd, 0
+}
+
+func (k *kernel) linkCreate(attr *sys.LinkCreateAttr) (uintptr, syscall.Errno) {
+	p, errNo := k.lookupProg(attr.ProgFd)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	var typ sys.LinkType
+	switch attr.AttachType {
+	case sys.BPF_XDP:
+		typ = sys.BPF_LINK_TYPE_XDP
+	case sys.BPF_FLOW_DISSECTOR, sys.BPF_SK_LOOKUP:
+		typ = sys.BPF_LINK_TYPE_NETNS
+	case sys.BPF_TRACE_FENTRY, sys.BPF_TRACE_FEXIT, sys.BPF_MODIFY_RETURN, sys.BPF_LSM_MAC:
+		typ = sys.BPF_LINK_TYPE_TRACING
+	case sys.BPF_TRACE_ITER:
+		typ = sys.BPF_LINK_TYPE_ITER
+	case sys.BPF_PERF_EVENT:
+		typ = sys.BPF_LINK_TYPE_PERF_EVENT
+	case sys.BPF_CGROUP_INET_INGRESS, sys.BPF_CGROUP_INET_EGRESS,
+		sys.BPF_CGROUP_INET_SOCK_CREATE, sys.BPF_CGROUP_SOCK_OPS,
+		sys.BPF_CGROUP_DEVICE, sys.BPF_CGROUP_INET4_BIND, sys.BPF_CGROUP_INET6_BIND,
+		sys.BPF_CGROUP_INET4_CONNECT, sys.BPF_CGROUP_INET6_CONNECT,
+		sys.BPF_CGROUP_INET4_POST_BIND, sys.BPF_CGROUP_INET6_POST_BIND,
+		sys.BPF_CGROUP_UDP4_SENDMSG, sys.BPF_CGROUP_UDP6_SENDMSG,
+		sys.BPF_CGROUP_SYSCTL, sys.BPF_CGROUP_UDP4_RECVMSG, sys.BPF_CGROUP_UDP6_RECVMSG,
+		sys.BPF_CGROUP_GETSOCKOPT, sys.BPF_CGROUP_SETSOCKOPT,
+		sys.BPF_CGROUP_INET4_GETPEERNAME, sys.BPF_CGROUP_INET6_GETPEERNAME,
+		sys.BPF_CGROUP_INET4_GETSOCKNAME, sys.BPF_CGROUP_INET6_GETSOCKNAME,
+		sys.BPF_CGROUP_INET_SOCK_RELEASE:
+		typ = sys.BPF_LINK_TYPE_CGROUP
+	default:
+		return 0, unix.EINVAL
+	}
+
+	switch typ {
+	case sys.BPF_LINK_TYPE_TRACING, sys.BPF_LINK_TYPE_ITER:
+		// There is no target.
+	case sys.BPF_LINK_TYPE_XDP:
+		// The target is an interface index.
+	default:
+		if !k.isFD(attr.TargetFd) {
+			return 0, unix.EBADF
+		}
+	}
+
+	return k.newLink(typ, p)
+}
+
+func (k *kernel) linkUpdate(attr *sys.LinkUpdateAttr) syscall.Errno {
+	obj, errNo := k.object(attr.LinkFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	l, ok := obj.(*bpfLink)
+	if !ok {
+		return unix.EINVAL
+	}
+
+	p, errNo := k.lookupProg(attr.NewProgFd)
+	if errNo != 0 {
+		return errNo
+	}
+
+	if attr.OldProgFd != 0 {
+		old, errNo := k.lookupProg(attr.OldProgFd)
+		if errNo != 0 {
+			return errNo
+		}
+		if old.id != l.progID {
+			return unix.EPERM
+		}
+	}
+
+	l.progID = p.id
+	return 0
+}
+
+func (k *kernel) rawTracepointOpen(attr *sys.RawTracepointOpenAttr) (uintptr, syscall.Errno) {
+	p, errNo := k.lookupProg(attr.ProgFd)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	typ := sys.BPF_LINK_TYPE_TRACING
+	if p.typ == sys.BPF_PROG_TYPE_RAW_TRACEPOINT ||
+		(p.typ == sys.BPF_PROG_TYPE_TRACING && p.expectedAttachType == sys.BPF_TRACE_RAW_TP) {
+		typ = sys.BPF_LINK_TYPE_RAW_TRACEPOINT
+	}
+
+	return k.newLink(typ, p)
+}
+
+type btfBlob struct {
+	id  uint32
+	raw []byte
+}
+
+func (b *btfBlob) objID() uint32 { return b.id }
+
+func (b *btfBlob) info(attr *sys.ObjGetInfoByFdAttr) syscall.Errno {
+	var info sys.BtfInfo
+	in := bytesAt(attr.Info, int(attr.InfoLen))
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&info)), unsafe.Sizeof(info)), in)
+
+	if info.BtfSize > 0 {
+		copy(bytesAt(info.Btf, int(info.BtfSize)), b.raw)
+	}
+	info.BtfSize = uint32(len(b.raw))
+	info.Id = b.id
+
+	out := structBytes(unsafe.Pointer(&info), unsafe.Sizeof(info))
+	copy(in, out)
+	if int(attr.InfoLen) > len(out) {
+		attr.InfoLen = uint32(len(out))
+	}
+	return 0
+}
+
+func (k *kernel) btfLoad(attr *sys.BtfLoadAttr) (uintptr, syscall.Errno) {
+	if attr.BtfSize == 0 {
+		return 0, unix.EINVAL
+	}
+
+	b := &btfBlob{
+		id:  k.newID(),
+		raw: append([]byte(nil), bytesAt(attr.Btf, int(attr.BtfSize))...),
+	}
+
+	fd, errNo := k.newFD(b)
+	if errNo != 0 {
+		return 0, errNo
+	}
+
+	k.btfs[b.id] = b
+	return fd, 0
+}
@@ -60,8 +60,9 @@ func createMapTypeAttr(mt ebpf.MapType) *sys.MapCreateAttr {
 		var align int
 		keySize = uint32(8 + unsafe.Sizeof(align))
 		maxEntries = 0
-	case ebpf.Queue, ebpf.Stack:
-		// keySize needs to be 0, see alloc_check for queue and stack maps
+	case ebpf.Queue, ebpf.Stack, ebpf.BloomFilter:
+		// keySize needs to be 0, see alloc_check for queue, stack and
+		// bloom filter maps
 		keySize = 0
 	case ebpf.RingBuf:
 		// keySize and valueSize need to be 0
@@ -68,6 +68,8 @@ func createProgLoadAttr(pt ebpf.ProgramType, helper asm.BuiltinFunc) (*sys.ProgL
 		expectedAttachType = ebpf.AttachCGroupGetsockopt
 	case ebpf.SkLookup:
 		expectedAttachType = ebpf.AttachSkLookup
+	case ebpf.Netfilter:
+		expectedAttachType = ebpf.AttachNetfilter
 	case ebpf.Syscall:
 		progFlags = unix.BPF_F_SLEEPABLE
 	default:
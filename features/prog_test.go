@@ -45,6 +45,7 @@ var progTypeMinVersion = map[ebpf.ProgramType]string{
 	ebpf.LSM:                   "5.7",
 	ebpf.SkLookup:              "5.9",
 	ebpf.Syscall:               "5.14",
+	ebpf.Netfilter:             "6.4",
 }
 
 func TestHaveProgramType(t *testing.T) {
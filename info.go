@@ -30,6 +30,11 @@ type MapInfo struct {
 	Flags      uint32
 	// Name as supplied by user space at load time. Available from 4.15.
 	Name string
+
+	btf            btf.ID
+	btfKeyTypeID   btf.TypeID
+	btfValueTypeID btf.TypeID
+	memlock        *uint64
 }
 
 func newMapInfoFromFd(fd *sys.FD) (*MapInfo, error) {
@@ -42,15 +47,21 @@ func newMapInfoFromFd(fd *sys.FD) (*MapInfo, error) {
 		return nil, err
 	}
 
-	return &MapInfo{
-		MapType(info.Type),
-		MapID(info.Id),
-		info.KeySize,
-		info.ValueSize,
-		info.MaxEntries,
-		info.MapFlags,
-		unix.ByteSliceToString(info.Name[:]),
-	}, nil
+	mi := MapInfo{
+		Type:           MapType(info.Type),
+		id:             MapID(info.Id),
+		KeySize:        info.KeySize,
+		ValueSize:      info.ValueSize,
+		MaxEntries:     info.MaxEntries,
+		Flags:          info.MapFlags,
+		Name:           unix.ByteSliceToString(info.Name[:]),
+		btf:            btf.ID(info.BtfId),
+		btfKeyTypeID:   btf.TypeID(info.BtfKeyTypeId),
+		btfValueTypeID: btf.TypeID(info.BtfValueTypeId),
+		memlock:        scanMemlock(fd),
+	}
+
+	return &mi, nil
 }
 
 func newMapInfoFromProc(fd *sys.FD) (*MapInfo, error) {
@@ -65,9 +76,20 @@ func newMapInfoFromProc(fd *sys.FD) (*MapInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	mi.memlock = scanMemlock(fd)
 	return &mi, nil
 }
 
+// scanMemlock returns the memory charged to an object, or nil if the kernel
+// doesn't report it.
+func scanMemlock(fd *sys.FD) *uint64 {
+	var memlock uint64
+	if err := scanFdInfo(fd, map[string]interface{}{"memlock": &memlock}); err != nil {
+		return nil
+	}
+	return &memlock
+}
+
 // ID returns the map ID.
 //
 // Available from 4.13.
@@ -77,12 +99,119 @@ func (mi *MapInfo) ID() (MapID, bool) {
 	return mi.id, mi.id > 0
 }
 
+// BTFID returns the BTF ID associated with the map.
+//
+// Available from 4.18.
+//
+// The bool return value indicates whether this optional field is available and
+// populated. (The field may be available but not populated if the kernel
+// supports the field but the map was created without BTF information.)
+func (mi *MapInfo) BTFID() (btf.ID, bool) {
+	return mi.btf, mi.btf > 0
+}
+
+// BTFKeyTypeID returns the ID of the key type in the BTF returned by BTFID.
+//
+// Available from 4.18.
+//
+// The bool return value indicates whether this optional field is available and
+// populated.
+func (mi *MapInfo) BTFKeyTypeID() (btf.TypeID, bool) {
+	return mi.btfKeyTypeID, mi.btfKeyTypeID > 0
+}
+
+// BTFValueTypeID returns the ID of the value type in the BTF returned by
+// BTFID.
+//
+// Available from 4.18.
+//
+// The bool return value indicates whether this optional field is available and
+// populated.
+func (mi *MapInfo) BTFValueTypeID() (btf.TypeID, bool) {
+	return mi.btfValueTypeID, mi.btfValueTypeID > 0
+}
+
+// Memlock returns the number of bytes of memory charged to the map, as
+// reported by bpftool.
+//
+// Available from 4.10.
+//
+// The bool return value indicates whether this optional field is available.
+func (mi *MapInfo) Memlock() (uint64, bool) {
+	if mi.memlock == nil {
+		return 0, false
+	}
+	return *mi.memlock, true
+}
+
+// MapMemory is the memory charged to a single map.
+type MapMemory struct {
+	// Zero if the kernel doesn't expose map IDs.
+	ID      MapID
+	Name    string
+	Type    MapType
+	Memlock uint64
+}
+
+// MemoryReport describes the memory charged to a set of maps.
+type MemoryReport struct {
+	// The maps passed to AccountMemory, in order and without duplicates.
+	Maps []MapMemory
+	// The sum of Memlock over all Maps.
+	Total uint64
+}
+
+// AccountMemory reports the memory charged to maps, as returned by
+// MapInfo.Memlock.
+//
+// Maps which refer to the same kernel object, like clones, are only counted
+// once. The memory is charged against RLIMIT_MEMLOCK before Linux 5.11 and
+// against the memory cgroup of the creating process afterwards.
+//
+// Returns ErrNotSupported if the kernel doesn't report the memory usage of
+// maps.
+func AccountMemory(maps ...*Map) (*MemoryReport, error) {
+	var (
+		report MemoryReport
+		seen   = make(map[MapID]bool)
+	)
+
+	for _, m := range maps {
+		info, err := m.Info()
+		if err != nil {
+			return nil, fmt.Errorf("map %s: %w", m, err)
+		}
+
+		memlock, ok := info.Memlock()
+		if !ok {
+			return nil, fmt.Errorf("map %s: memlock: %w", m, ErrNotSupported)
+		}
+
+		id, ok := info.ID()
+		if ok {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+
+		report.Maps = append(report.Maps, MapMemory{id, info.Name, info.Type, memlock})
+		report.Total += memlock
+	}
+
+	return &report, nil
+}
+
 // programStats holds statistics of a program.
 type programStats struct {
 	// Total accumulated runtime of the program ins ns.
 	runtime time.Duration
 	// Total number of times the program was called.
 	runCount uint64
+	// Total number of times the program was not called due to recursion.
+	recursionMisses uint64
+	// Whether the kernel reports recursionMisses.
+	haveRecursionMisses bool
 }
 
 // ProgramInfo describes a program.
@@ -97,13 +226,14 @@ type ProgramInfo struct {
 	btf   btf.ID
 	stats *programStats
 
-	maps  []MapID
-	insns []byte
+	maps       []MapID
+	insns      []byte
+	jitedInsns []byte
 }
 
 func newProgramInfoFromFd(fd *sys.FD) (*ProgramInfo, error) {
 	var info sys.ProgInfo
-	err := sys.ObjInfo(fd, &info)
+	infoLen, err := sys.ObjInfoLen(fd, &info)
 	if errors.Is(err, syscall.EINVAL) {
 		return newProgramInfoFromProc(fd)
 	}
@@ -118,8 +248,11 @@ func newProgramInfoFromFd(fd *sys.FD) (*ProgramInfo, error) {
 		Name: unix.ByteSliceToString(info.Name[:]),
 		btf:  btf.ID(info.BtfId),
 		stats: &programStats{
-			runtime:  time.Duration(info.RunTimeNs),
-			runCount: info.RunCnt,
+			runtime:         time.Duration(info.RunTimeNs),
+			runCount:        info.RunCnt,
+			recursionMisses: info.RecursionMisses,
+			// The field was added in 5.12.
+			haveRecursionMisses: infoLen >= uint32(unsafe.Offsetof(info.RecursionMisses)+unsafe.Sizeof(info.RecursionMisses)),
 		},
 	}
 
@@ -138,7 +271,13 @@ func newProgramInfoFromFd(fd *sys.FD) (*ProgramInfo, error) {
 		info2.XlatedProgInsns = sys.NewSlicePointer(pi.insns)
 	}
 
-	if info.NrMapIds > 0 || info.XlatedProgLen > 0 {
+	if info.JitedProgLen > 0 {
+		pi.jitedInsns = make([]byte, info.JitedProgLen)
+		info2.JitedProgLen = info.JitedProgLen
+		info2.JitedProgInsns = sys.NewSlicePointer(pi.jitedInsns)
+	}
+
+	if info.NrMapIds > 0 || info.XlatedProgLen > 0 || info.JitedProgLen > 0 {
 		if err := sys.ObjInfo(fd, &info2); err != nil {
 			return nil, err
 		}
@@ -208,6 +347,20 @@ func (pi *ProgramInfo) Runtime() (time.Duration, bool) {
 	return time.Duration(0), false
 }
 
+// RecursionMisses returns the total number of times the program was not
+// called because it was already running on the same CPU.
+//
+// Available from 5.12. Requires trampoline based program types, like
+// Tracing and LSM.
+//
+// The bool return value indicates whether this optional field is available.
+func (pi *ProgramInfo) RecursionMisses() (uint64, bool) {
+	if pi.stats != nil && pi.stats.haveRecursionMisses {
+		return pi.stats.recursionMisses, true
+	}
+	return 0, false
+}
+
 // Instructions returns the 'xlated' instruction stream of the program
 // after it has been verified and rewritten by the kernel. These instructions
 // cannot be loaded back into the kernel as-is, this is mainly used for
@@ -241,6 +394,21 @@ func (pi *ProgramInfo) Instructions() (asm.Instructions, error) {
 	return insns, nil
 }
 
+// JitedInstructions returns the machine code generated by the JIT compiler
+// for the program.
+//
+// The code is specific to the architecture of the running kernel, use a
+// disassembler to inspect it. Returns an error wrapping ErrNotSupported if
+// the JIT is disabled or the caller lacks CAP_BPF or equivalent.
+//
+// Available from 4.13.
+func (pi *ProgramInfo) JitedInstructions() ([]byte, error) {
+	if len(pi.jitedInsns) == 0 {
+		return nil, fmt.Errorf("JIT disabled, insufficient permissions or unsupported kernel: %w", ErrNotSupported)
+	}
+	return pi.jitedInsns, nil
+}
+
 // MapIDs returns the maps related to the program.
 //
 // Available from 4.15.
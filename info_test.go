@@ -86,6 +86,96 @@ func TestMapInfoFromProc(t *testing.T) {
 	}
 }
 
+func TestMapInfoBTF(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.18", "map BTF")
+
+	file := fmt.Sprintf("testdata/map_spin_lock-%s.elf", internal.ClangEndian)
+	spec, err := LoadCollectionSpec(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapSpec := spec.Maps["spin_lock_map"]
+
+	m, err := NewMap(mapSpec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	info, err := m.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := info.BTFID(); !ok {
+		t.Error("Expected BTF ID to be available")
+	}
+
+	keyID, ok := info.BTFKeyTypeID()
+	if !ok {
+		t.Fatal("Expected BTF key type ID to be available")
+	}
+	wantKeyID, err := mapSpec.BTF.TypeID(mapSpec.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != wantKeyID {
+		t.Errorf("Expected key type ID %d, got %d", wantKeyID, keyID)
+	}
+
+	if _, ok := info.BTFValueTypeID(); !ok {
+		t.Error("Expected BTF value type ID to be available")
+	}
+
+	if memlock, ok := info.Memlock(); !ok {
+		t.Error("Expected memlock to be available")
+	} else if memlock == 0 {
+		t.Error("Expected memlock to be non-zero")
+	}
+}
+
+func TestAccountMemory(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.13", "map IDs")
+
+	hash := createHash()
+	defer hash.Close()
+
+	arr := createArray(t)
+	defer arr.Close()
+
+	clone, err := arr.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	report, err := AccountMemory(hash, arr, clone)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Maps) != 2 {
+		t.Fatalf("Expected two maps, got %d", len(report.Maps))
+	}
+
+	if report.Maps[0].Type != Hash || report.Maps[1].Type != Array {
+		t.Error("Maps aren't in order:", report.Maps)
+	}
+
+	var total uint64
+	for _, mm := range report.Maps {
+		if mm.Memlock == 0 {
+			t.Errorf("Map %d has no memory charged", mm.ID)
+		}
+		total += mm.Memlock
+	}
+	if report.Total != total {
+		t.Errorf("Total is %d instead of %d", report.Total, total)
+	}
+}
+
 func TestProgramInfo(t *testing.T) {
 	prog := mustSocketFilter(t)
 
@@ -108,7 +198,7 @@ func TestProgramInfo(t *testing.T) {
 				t.Error("Expected Name to be test, got", info.Name)
 			}
 
-			if want := "d7edec644f05498d"; info.Tag != want {
+			if want := kernelTag(t, socketFilterSpec); info.Tag != want {
 				t.Errorf("Expected Tag to be %s, got %s", want, info.Tag)
 			}
 
@@ -217,6 +307,11 @@ func TestStats(t *testing.T) {
 		t.Errorf("expected a runtime of 0ns but got %v", rt)
 	}
 
+	_, ok = pi.RecursionMisses()
+	if want := !testutils.MustKernelVersion().Less(internal.Version{5, 12, 0}); ok != want {
+		t.Errorf("expected recursion misses to be available: %t, got %t", want, ok)
+	}
+
 	if err := testStats(prog); err != nil {
 		t.Error(err)
 	}
@@ -316,5 +411,13 @@ func testStats(prog *Program) error {
 		return fmt.Errorf("runtime unexpectedly increased over the previous value (current: %v, prev: %v)", rt, lt)
 	}
 
+	ps, err := prog.Stats()
+	if err != nil {
+		return fmt.Errorf("failed to get ProgramStats: %v", err)
+	}
+	if ps.RunCount != lc || ps.Runtime != lt {
+		return fmt.Errorf("stats don't match info (run count: %v, runtime: %v)", ps.RunCount, ps.Runtime)
+	}
+
 	return nil
 }
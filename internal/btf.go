@@ -0,0 +1,19 @@
+package internal
+
+import "sync/atomic"
+
+// kernelBTFGeneration is incremented whenever the cached kernel BTF is
+// flushed.
+var kernelBTFGeneration uint64
+
+// FlushKernelBTF invalidates all copies of the kernel BTF cached outside of
+// the btf package. It is called by btf.FlushKernelSpec.
+func FlushKernelBTF() {
+	atomic.AddUint64(&kernelBTFGeneration, 1)
+}
+
+// KernelBTFGeneration returns a number which changes every time the kernel
+// BTF is flushed.
+func KernelBTFGeneration() uint64 {
+	return atomic.LoadUint64(&kernelBTFGeneration)
+}
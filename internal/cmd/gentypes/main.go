@@ -156,6 +156,7 @@ import (
 			[]patch{
 				replace(objName, "name"),
 				replace(pointer, "xlated_prog_insns"),
+				replace(pointer, "jited_prog_insns"),
 				replace(pointer, "map_ids"),
 			},
 		},
@@ -338,6 +339,10 @@ import (
 			"BtfLoad", retFd, "btf_load", "BPF_BTF_LOAD",
 			[]patch{replace(pointer, "btf", "btf_log_buf")},
 		},
+		{
+			"TokenCreate", retFd, "token_create", "BPF_TOKEN_CREATE",
+			nil,
+		},
 		{
 			"LinkCreate", retFd, "link_create", "BPF_LINK_CREATE",
 			[]patch{replace(enumTypes["AttachType"], "attach_type")},
@@ -359,6 +364,14 @@ import (
 				flattenAnon,
 			},
 		},
+		{
+			"LinkCreateTracing", retFd, "link_create", "BPF_LINK_CREATE",
+			[]patch{
+				chooseNth(4, 4),
+				replace(enumTypes["AttachType"], "attach_type"),
+				flattenAnon,
+			},
+		},
 		{
 			"LinkUpdate", retError, "link_update", "BPF_LINK_UPDATE",
 			nil,
@@ -8,6 +8,20 @@ import (
 
 type SafeELFFile struct {
 	*elf.File
+
+	// The reader the file was parsed from, nil for OpenSafeELFFile.
+	r io.ReaderAt
+	// Symbols are cached since reading them copies the whole symbol and
+	// string tables.
+	symbols    []elf.Symbol
+	symbolsErr error
+	hasSymbols bool
+}
+
+// parsedELF is an io.ReaderAt which carries the ELF parsed from it.
+type parsedELF struct {
+	io.ReaderAt
+	file *SafeELFFile
 }
 
 // NewSafeELFFile reads an ELF safely.
@@ -16,7 +30,13 @@ type SafeELFFile struct {
 // there are a bunch of unfixed bugs in debug/elf.
 //
 // https://github.com/golang/go/issues?q=is%3Aissue+is%3Aopen+debug%2Felf+in%3Atitle
+//
+// r is not parsed again if it was returned by SafeELFFile.ReaderAt.
 func NewSafeELFFile(r io.ReaderAt) (safe *SafeELFFile, err error) {
+	if p, ok := r.(*parsedELF); ok {
+		return p.file, nil
+	}
+
 	defer func() {
 		r := recover()
 		if r == nil {
@@ -32,7 +52,18 @@ func NewSafeELFFile(r io.ReaderAt) (safe *SafeELFFile, err error) {
 		return nil, err
 	}
 
-	return &SafeELFFile{file}, nil
+	return &SafeELFFile{File: file, r: r}, nil
+}
+
+// ReaderAt returns the reader the file was parsed from. Passing it to
+// NewSafeELFFile returns se instead of parsing the file again.
+//
+// Returns nil if se was created by OpenSafeELFFile.
+func (se *SafeELFFile) ReaderAt() io.ReaderAt {
+	if se.r == nil {
+		return nil
+	}
+	return &parsedELF{se.r, se}
 }
 
 // OpenSafeELFFile reads an ELF from a file.
@@ -55,11 +86,22 @@ func OpenSafeELFFile(path string) (safe *SafeELFFile, err error) {
 		return nil, err
 	}
 
-	return &SafeELFFile{file}, nil
+	return &SafeELFFile{File: file}, nil
 }
 
 // Symbols is the safe version of elf.File.Symbols.
-func (se *SafeELFFile) Symbols() (syms []elf.Symbol, err error) {
+//
+// The symbols are read once and shared by all callers, who must not modify
+// them.
+func (se *SafeELFFile) Symbols() ([]elf.Symbol, error) {
+	if !se.hasSymbols {
+		se.symbols, se.symbolsErr = se.readSymbols()
+		se.hasSymbols = true
+	}
+	return se.symbols, se.symbolsErr
+}
+
+func (se *SafeELFFile) readSymbols() (syms []elf.Symbol, err error) {
 	defer func() {
 		r := recover()
 		if r == nil {
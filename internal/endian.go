@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+)
+
+// CheckByteOrder returns an error if bo isn't the native byte order. A nil bo
+// is treated as native.
+//
+// The error names the clang target the object was compiled for and the
+// one the host requires, since mixing up bpfel and bpfeb is the usual cause.
+func CheckByteOrder(bo binary.ByteOrder) error {
+	if bo == nil || bo == NativeEndian {
+		return nil
+	}
+
+	target := "bpfeb"
+	if bo == binary.LittleEndian {
+		target = "bpfel"
+	}
+
+	return fmt.Errorf("object compiled for %s (%s) but host %s/%s requires bpf%s (%s)",
+		target, bo, runtime.GOOS, runtime.GOARCH, ClangEndian, NativeEndian)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/cilium/ebpf/internal"
 	"github.com/cilium/ebpf/internal/unix"
@@ -117,9 +118,10 @@ func (p *Poller) Add(fd int, id int) error {
 
 // Wait for events.
 //
-// Returns the number of pending events or an error wrapping os.ErrClosed if
-// Close is called.
-func (p *Poller) Wait(events []unix.EpollEvent) (int, error) {
+// Returns the number of pending events, an error wrapping os.ErrClosed if
+// Close is called, or an error wrapping os.ErrDeadlineExceeded if the
+// deadline passes without any events. A zero deadline blocks indefinitely.
+func (p *Poller) Wait(events []unix.EpollEvent, deadline time.Time) (int, error) {
 	p.epollMu.Lock()
 	defer p.epollMu.Unlock()
 
@@ -128,7 +130,18 @@ func (p *Poller) Wait(events []unix.EpollEvent) (int, error) {
 	}
 
 	for {
-		n, err := unix.EpollWait(p.epollFd, events, -1)
+		timeout := -1
+		if !deadline.IsZero() {
+			msec := time.Until(deadline).Milliseconds()
+			if msec < 0 {
+				msec = 0
+			} else if msec > math.MaxInt32 {
+				msec = math.MaxInt32
+			}
+			timeout = int(msec)
+		}
+
+		n, err := unix.EpollWait(p.epollFd, events, timeout)
 		if temp, ok := err.(temporaryError); ok && temp.Temporary() {
 			// Retry the syscall if we were interrupted, see https://github.com/golang/go/issues/20400
 			continue
@@ -138,6 +151,10 @@ func (p *Poller) Wait(events []unix.EpollEvent) (int, error) {
 			return 0, err
 		}
 
+		if n == 0 {
+			return 0, fmt.Errorf("epoll wait: %w", os.ErrDeadlineExceeded)
+		}
+
 		for _, event := range events[:n] {
 			if int(event.Fd) == p.event.raw {
 				// Since we don't read p.event the event is never cleared and
@@ -2,9 +2,13 @@ package internal
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+
+	"github.com/cilium/ebpf/internal/unix"
 )
 
 // ErrorWithLog returns an error which includes logs from the kernel verifier.
@@ -32,6 +36,11 @@ func ErrorWithLog(err error, log []byte) *VerifierError {
 		truncated = true
 	}
 
+	if errors.Is(err, unix.ENOSPC) {
+		// The kernel returns ENOSPC if the log doesn't fit into the buffer.
+		truncated = true
+	}
+
 	log = bytes.Trim(log, whitespace)
 	logLines := bytes.Split(log, []byte{'\n'})
 	lines := make([]string, 0, len(logLines))
@@ -109,6 +118,59 @@ func (le *VerifierError) Error() string {
 	return b.String()
 }
 
+// Tail returns the last n lines of the log, or all lines if there are fewer.
+func (le *VerifierError) Tail(n int) []string {
+	if n < 0 {
+		n = 0
+	}
+	if n > len(le.Log) {
+		n = len(le.Log)
+	}
+	return le.Log[len(le.Log)-n:]
+}
+
+// Instruction returns the index of the last instruction printed by the
+// verifier, which usually is the instruction that was rejected.
+//
+// Returns false if the log doesn't contain any instructions, for example
+// because the program was loaded without a log level.
+func (le *VerifierError) Instruction() (int, bool) {
+	for i := len(le.Log) - 1; i >= 0; i-- {
+		if insn, ok := parseInstructionLine(le.Log[i]); ok {
+			return insn, true
+		}
+	}
+	return 0, false
+}
+
+// Source returns the line of source code the verifier printed last, which
+// usually precedes the rejected instruction.
+//
+// The verifier only prints source code if the program was loaded with BTF
+// line info. Returns an empty string otherwise.
+func (le *VerifierError) Source() string {
+	for i := len(le.Log) - 1; i >= 0; i-- {
+		if strings.HasPrefix(le.Log[i], "; ") {
+			return strings.TrimPrefix(le.Log[i], "; ")
+		}
+	}
+	return ""
+}
+
+// parseInstructionLine parses the index out of a line like "12: (95) exit".
+func parseInstructionLine(line string) (int, bool) {
+	i := strings.Index(line, ": (")
+	if i <= 0 {
+		return 0, false
+	}
+
+	insn, err := strconv.Atoi(line[:i])
+	if err != nil || insn < 0 {
+		return 0, false
+	}
+	return insn, true
+}
+
 // includePreviousLine returns true if the given line likely is better
 // understood with additional context from the preceding line.
 func includePreviousLine(line string) bool {
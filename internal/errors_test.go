@@ -73,6 +73,42 @@ func TestVerifierError(t *testing.T) {
 	invalidR0 := readErrorFromFile(t, "testdata/invalid-R0.log")
 	t.Log(invalidR0)
 	qt.Assert(t, invalidR0.Error(), qt.Contains, "0: (95) exit: R0 !read_ok")
+
+	insn, ok := invalidR0.Instruction()
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, insn, qt.Equals, 0)
+
+	_, ok = issue43.Instruction()
+	qt.Assert(t, ok, qt.IsFalse)
+
+	enospc := ErrorWithLog(unix.ENOSPC, []byte("foo\n\x00"))
+	qt.Assert(t, enospc.Truncated, qt.IsTrue)
+}
+
+func TestVerifierErrorDetails(t *testing.T) {
+	const log = `func#0 @0
+0: R1=ctx(id=0,off=0,imm=0) R10=fp0
+; int prog(struct __sk_buff *skb) { @ prog.c:10
+0: (b7) r0 = 0
+1: R0_w=inv0
+; return *(int *)0; @ prog.c:11
+1: (61) r0 = *(u32 *)(r0 +0)
+R0 invalid mem access 'inv'
+processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0
+`
+
+	ve := ErrorWithLog(unix.EACCES, []byte(log+"\x00"))
+
+	insn, ok := ve.Instruction()
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, insn, qt.Equals, 1)
+	qt.Assert(t, ve.Source(), qt.Equals, "return *(int *)0; @ prog.c:11")
+
+	qt.Assert(t, ve.Tail(2), qt.DeepEquals, ve.Log[len(ve.Log)-2:])
+	qt.Assert(t, ve.Tail(100), qt.DeepEquals, ve.Log)
+	qt.Assert(t, ve.Tail(-1), qt.HasLen, 0)
+
+	qt.Assert(t, ErrorWithLog(unix.EINVAL, nil).Source(), qt.Equals, "")
 }
 
 func ExampleVerifierError() {
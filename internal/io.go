@@ -2,9 +2,12 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
 )
 
@@ -60,3 +63,44 @@ func ReadAllCompressed(file string) ([]byte, error) {
 
 	return io.ReadAll(gz)
 }
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// MaybeDecompress decompresses r into memory if it starts with the magic
+// bytes of a supported compression format. Otherwise r is returned as is.
+//
+// Returns an error wrapping ErrNotSupported for zstd. The standard library
+// has no zstd decoder and the package doesn't take on a dependency for one.
+func MaybeDecompress(r io.ReaderAt) (io.ReaderAt, error) {
+	magic := make([]byte, len(zstdMagic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, math.MaxInt64))
+		if err != nil {
+			return nil, fmt.Errorf("decompress gzip: %w", err)
+		}
+		defer gz.Close()
+
+		buf, err := io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("decompress gzip: %w", err)
+		}
+
+		return bytes.NewReader(buf), nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, fmt.Errorf("zstd compression: %w", ErrNotSupported)
+
+	default:
+		return r, nil
+	}
+}
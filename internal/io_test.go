@@ -2,6 +2,8 @@ package internal
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
 	"io"
 	"testing"
 )
@@ -17,3 +19,41 @@ func TestDiscardZero(t *testing.T) {
 		t.Error("No error even though input is non-zero")
 	}
 }
+
+func TestMaybeDecompress(t *testing.T) {
+	want := []byte("\x7fELF plain contents")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(want)
+	gz.Close()
+
+	for name, in := range map[string][]byte{
+		"plain": want,
+		"gzip":  buf.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := MaybeDecompress(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			have, err := io.ReadAll(io.NewSectionReader(r, 0, int64(len(want))+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(have, want) {
+				t.Errorf("Expected %q, got %q", want, have)
+			}
+		})
+	}
+
+	if _, err := MaybeDecompress(bytes.NewReader(nil)); err != nil {
+		t.Error("Error for empty input:", err)
+	}
+
+	_, err := MaybeDecompress(bytes.NewReader([]byte{0x28, 0xb5, 0x2f, 0xfd, 0}))
+	if !errors.Is(err, ErrNotSupported) {
+		t.Error("Expected ErrNotSupported for zstd, got", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package kallsyms reads the symbols of the running kernel.
+package kallsyms
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AssignAddresses looks up the addresses of symbols in /proc/kallsyms.
+//
+// The keys of symbols are the names to look up, the values are overwritten
+// with the addresses. Symbols which don't exist keep their value. Addresses
+// read as zero if they are hidden by the kernel.kptr_restrict sysctl.
+func AssignAddresses(symbols map[string]uint64) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+
+	f, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return assignAddresses(f, symbols)
+}
+
+func assignAddresses(r io.Reader, symbols map[string]uint64) error {
+	found := make(map[string]bool, len(symbols))
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// <address> <type> <name> [<module>]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			return fmt.Errorf("invalid kallsyms line %q", scanner.Text())
+		}
+
+		name := fields[2]
+		if _, ok := symbols[name]; !ok {
+			continue
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid address in kallsyms line %q: %w", scanner.Text(), err)
+		}
+
+		if found[name] && symbols[name] != addr {
+			return fmt.Errorf("symbol %s: ambiguous address", name)
+		}
+
+		symbols[name] = addr
+		found[name] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read kallsyms: %w", err)
+	}
+
+	return nil
+}
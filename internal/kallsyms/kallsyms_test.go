@@ -0,0 +1,46 @@
+package kallsyms
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+const testKallsyms = `ffffffff81000000 T _text
+ffffffff81001000 t dup
+ffffffff82000000 D bpf_prog_active
+ffffffffc0000000 t dup	[module]
+ffffffffc0001000 T mod_func	[module]
+`
+
+func TestAssignAddresses(t *testing.T) {
+	symbols := map[string]uint64{
+		"bpf_prog_active": 0,
+		"mod_func":        0,
+		"missing":         0,
+	}
+	qt.Assert(t, assignAddresses(strings.NewReader(testKallsyms), symbols), qt.IsNil)
+	qt.Assert(t, symbols, qt.DeepEquals, map[string]uint64{
+		"bpf_prog_active": 0xffffffff82000000,
+		"mod_func":        0xffffffffc0001000,
+		"missing":         0,
+	})
+
+	err := assignAddresses(strings.NewReader(testKallsyms), map[string]uint64{"dup": 0})
+	qt.Assert(t, err, qt.IsNotNil)
+
+	err = assignAddresses(strings.NewReader("invalid\n"), map[string]uint64{"dup": 0})
+	qt.Assert(t, err, qt.IsNotNil)
+}
+
+func TestAssignAddressesKernel(t *testing.T) {
+	symbols := map[string]uint64{"bpf_prog_active": 0}
+	if err := AssignAddresses(symbols); err != nil {
+		t.Skip("Can't read kallsyms:", err)
+	}
+
+	if _, ok := symbols["bpf_prog_active"]; !ok {
+		t.Fatal("Symbol was removed")
+	}
+}
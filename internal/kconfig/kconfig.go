@@ -0,0 +1,253 @@
+// Package kconfig implements a parser for the format of Linux's .config file.
+package kconfig
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+)
+
+// Find opens the configuration of the running kernel.
+//
+// It looks at /boot/config-$(uname -r) first and falls back to
+// /proc/config.gz. The caller is responsible for closing the file.
+func Find() (*os.File, error) {
+	if release, err := internal.KernelRelease(); err == nil {
+		if f, err := os.Open("/boot/config-" + release); err == nil {
+			return f, nil
+		}
+	}
+
+	f, err := os.Open("/proc/config.gz")
+	if err != nil {
+		return nil, fmt.Errorf("kernel configuration not found: %w", err)
+	}
+
+	return f, nil
+}
+
+// Parse reads a kernel configuration, which may be gzip compressed.
+//
+// Returns the values of the options in filter, or all options if filter is
+// nil. Values are returned verbatim, except for options which are not set.
+// Those have the value "n".
+func Parse(source io.Reader, filter map[string]struct{}) (map[string]string, error) {
+	r := bufio.NewReader(source)
+
+	magic, err := r.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read kernel configuration: %w", err)
+	}
+
+	var rd io.Reader = r
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompress kernel configuration: %w", err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		key, value, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		if filter != nil {
+			if _, ok := filter[key]; !ok {
+				continue
+			}
+		}
+
+		config[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read kernel configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// parseLine splits a line of a kernel configuration into key and value.
+func parseLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+
+	if strings.HasPrefix(line, "#") {
+		// Unset options are stored as "# CONFIG_FOO is not set".
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if key := strings.TrimSuffix(line, " is not set"); key != line && !strings.ContainsAny(key, " \t") {
+			return key, "n", true
+		}
+		return "", "", false
+	}
+
+	i := strings.IndexByte(line, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+
+	return line[:i], line[i+1:], true
+}
+
+// PutValue encodes a configuration value according to typ and writes it to
+// data, which must be the size of typ.
+//
+// Tristate values (y, n and m) can be stored in bool, char and
+// enum libbpf_tristate. Strings can be stored in char arrays and are
+// truncated if necessary. Numbers can be stored in integers.
+func PutValue(data []byte, typ btf.Type, value string) error {
+	typ = btf.UnderlyingType(typ)
+
+	switch value {
+	case "y", "n", "m":
+		return putTristate(data, typ, value)
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		return putString(data, typ, value)
+	}
+
+	return putNumber(data, typ, value)
+}
+
+func putTristate(data []byte, typ btf.Type, value string) error {
+	switch t := typ.(type) {
+	case *btf.Int:
+		if t.Size != 1 || len(data) != 1 {
+			return fmt.Errorf("can't store tristate in %s", typ)
+		}
+
+		switch {
+		case t.Encoding.IsBool():
+			switch value {
+			case "y":
+				data[0] = 1
+			case "n":
+				data[0] = 0
+			default:
+				return fmt.Errorf("can't store %q in %s", value, typ)
+			}
+
+		case t.Encoding.IsChar():
+			data[0] = value[0]
+
+		default:
+			return fmt.Errorf("can't store tristate in %s", typ)
+		}
+
+	case *btf.Enum:
+		if t.Name != "libbpf_tristate" {
+			return fmt.Errorf("can't store tristate in %s", typ)
+		}
+
+		var n uint64
+		switch value {
+		case "y":
+			n = 1
+		case "m":
+			n = 2
+		}
+		return putInteger(data, n)
+
+	default:
+		return fmt.Errorf("can't store tristate in %s", typ)
+	}
+
+	return nil
+}
+
+func putString(data []byte, typ btf.Type, value string) error {
+	arr, ok := typ.(*btf.Array)
+	if !ok {
+		return fmt.Errorf("can't store string in %s", typ)
+	}
+
+	elem, ok := btf.UnderlyingType(arr.Type).(*btf.Int)
+	if !ok || elem.Size != 1 || !elem.Encoding.IsChar() {
+		return fmt.Errorf("can't store string in %s", typ)
+	}
+
+	if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+		return fmt.Errorf("malformed string %s", value)
+	}
+	value = value[1 : len(value)-1]
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	// Truncate the string and keep it NUL terminated.
+	n := copy(data[:len(data)-1], value)
+	for i := n; i < len(data); i++ {
+		data[i] = 0
+	}
+
+	return nil
+}
+
+func putNumber(data []byte, typ btf.Type, value string) error {
+	var signed bool
+	switch t := typ.(type) {
+	case *btf.Int:
+		if t.Encoding.IsBool() {
+			return fmt.Errorf("can't store number in %s", typ)
+		}
+		signed = t.Encoding.IsSigned()
+	case *btf.Enum:
+		signed = t.Signed
+	default:
+		return fmt.Errorf("can't store number in %s", typ)
+	}
+
+	bits := len(data) * 8
+	if bits == 0 || bits > 64 {
+		return fmt.Errorf("can't store number in %d bytes", len(data))
+	}
+
+	if signed {
+		n, err := strconv.ParseInt(value, 0, bits)
+		if err != nil {
+			return fmt.Errorf("parse %q: %w", value, err)
+		}
+		return putInteger(data, uint64(n))
+	}
+
+	n, err := strconv.ParseUint(value, 0, bits)
+	if err != nil {
+		return fmt.Errorf("parse %q: %w", value, err)
+	}
+	return putInteger(data, n)
+}
+
+// putInteger writes n to data in native endianness, truncating it to the
+// size of data.
+func putInteger(data []byte, n uint64) error {
+	switch len(data) {
+	case 1:
+		data[0] = uint8(n)
+	case 2:
+		internal.NativeEndian.PutUint16(data, uint16(n))
+	case 4:
+		internal.NativeEndian.PutUint32(data, uint32(n))
+	case 8:
+		internal.NativeEndian.PutUint64(data, n)
+	default:
+		return fmt.Errorf("can't store integer in %d bytes", len(data))
+	}
+
+	return nil
+}
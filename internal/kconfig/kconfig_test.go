@@ -0,0 +1,163 @@
+package kconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+
+	qt "github.com/frankban/quicktest"
+)
+
+const testConfig = `#
+# Automatically generated file; DO NOT EDIT.
+#
+CONFIG_BPF=y
+CONFIG_MODULES=m
+# CONFIG_DEBUG_INFO_BTF is not set
+CONFIG_HZ=250
+CONFIG_LOCALVERSION="-test"
+
+CONFIG_BROKEN
+`
+
+func TestParse(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, input := range map[string][]byte{
+		"plain": []byte(testConfig),
+		"gzip":  compressed.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := qt.New(t)
+
+			config, err := Parse(bytes.NewReader(input), nil)
+			c.Assert(err, qt.IsNil)
+			c.Assert(config, qt.DeepEquals, map[string]string{
+				"CONFIG_BPF":            "y",
+				"CONFIG_MODULES":        "m",
+				"CONFIG_DEBUG_INFO_BTF": "n",
+				"CONFIG_HZ":             "250",
+				"CONFIG_LOCALVERSION":   `"-test"`,
+			})
+
+			config, err = Parse(bytes.NewReader(input), map[string]struct{}{
+				"CONFIG_HZ":      {},
+				"CONFIG_MISSING": {},
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(config, qt.DeepEquals, map[string]string{"CONFIG_HZ": "250"})
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	config, err := Parse(bytes.NewReader(nil), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, config, qt.HasLen, 0)
+}
+
+func TestPutValue(t *testing.T) {
+	var (
+		boolean  = &btf.Int{Size: 1, Encoding: btf.Bool}
+		char     = &btf.Int{Size: 1, Encoding: btf.Char}
+		u16      = &btf.Int{Size: 2}
+		s32      = &btf.Int{Size: 4, Encoding: btf.Signed}
+		u64      = &btf.Int{Size: 8}
+		tristate = &btf.Enum{Name: "libbpf_tristate", Size: 4}
+		str      = &btf.Array{Type: char, Nelems: 4}
+	)
+
+	u32 := func(n uint32) []byte {
+		b := make([]byte, 4)
+		internal.NativeEndian.PutUint32(b, n)
+		return b
+	}
+
+	valid := []struct {
+		typ   btf.Type
+		value string
+		want  []byte
+	}{
+		{boolean, "y", []byte{1}},
+		{boolean, "n", []byte{0}},
+		{char, "m", []byte{'m'}},
+		{tristate, "y", u32(1)},
+		{tristate, "n", u32(0)},
+		{tristate, "m", u32(2)},
+		{&btf.Typedef{Name: "tristate", Type: tristate}, "m", u32(2)},
+		{s32, "-1", u32(0xffffffff)},
+		{s32, "0x10", u32(16)},
+		{u16, "1000", func() []byte {
+			b := make([]byte, 2)
+			internal.NativeEndian.PutUint16(b, 1000)
+			return b
+		}()},
+		{u64, "250", func() []byte {
+			b := make([]byte, 8)
+			internal.NativeEndian.PutUint64(b, 250)
+			return b
+		}()},
+		{str, `"ab"`, []byte{'a', 'b', 0, 0}},
+		{str, `"abcdef"`, []byte{'a', 'b', 'c', 0}},
+	}
+
+	for _, test := range valid {
+		t.Run(test.value, func(t *testing.T) {
+			size, err := btf.Sizeof(test.typ)
+			qt.Assert(t, err, qt.IsNil)
+
+			data := bytes.Repeat([]byte{0xff}, size)
+			qt.Assert(t, PutValue(data, test.typ, test.value), qt.IsNil)
+			qt.Assert(t, data, qt.DeepEquals, test.want)
+		})
+	}
+
+	invalid := []struct {
+		typ   btf.Type
+		value string
+	}{
+		{boolean, "m"},
+		{boolean, "1"},
+		{s32, "y"},
+		{u16, "-1"},
+		{u16, "65536"},
+		{s32, `"str"`},
+		{str, "1"},
+		{&btf.Enum{Name: "other", Size: 4}, "y"},
+	}
+
+	for _, test := range invalid {
+		t.Run(test.value, func(t *testing.T) {
+			size, err := btf.Sizeof(test.typ)
+			qt.Assert(t, err, qt.IsNil)
+
+			err = PutValue(make([]byte, size), test.typ, test.value)
+			qt.Assert(t, err, qt.IsNotNil)
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	f, err := Find()
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("No kernel configuration available")
+	}
+	qt.Assert(t, err, qt.IsNil)
+	defer f.Close()
+
+	config, err := Parse(f, map[string]struct{}{"CONFIG_BPF": {}})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, config["CONFIG_BPF"], qt.Equals, "y")
+}
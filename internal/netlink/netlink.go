@@ -0,0 +1,248 @@
+// Package netlink implements the subset of rtnetlink necessary to manage
+// traffic control objects.
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+const (
+	headerLen     = 16
+	attrHeaderLen = 4
+	// Alignment of messages and attributes.
+	alignTo = 4
+)
+
+// Message is a netlink message received from the kernel.
+type Message struct {
+	Type  uint16
+	Flags uint16
+	Data  []byte
+}
+
+// Conn is a NETLINK_ROUTE socket.
+//
+// A Conn is not safe for concurrent use.
+type Conn struct {
+	fd  int
+	seq uint32
+	buf []byte
+}
+
+// Dial opens a NETLINK_ROUTE socket.
+func Dial() (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("create netlink socket: %w", err)
+	}
+
+	return &Conn{fd: fd, buf: make([]byte, os.Getpagesize()*8)}, nil
+}
+
+// Close the socket.
+func (c *Conn) Close() error {
+	if c.fd < 0 {
+		return nil
+	}
+
+	err := unix.Close(c.fd)
+	c.fd = -1
+	return err
+}
+
+// Execute sends a request to the kernel and waits for the response.
+//
+// flags are combined with NLM_F_REQUEST. Returns all messages received in
+// response, excluding acknowledgements. Errors reported by the kernel are
+// returned as syscall.Errno.
+func (c *Conn) Execute(typ, flags uint16, payload []byte) ([]Message, error) {
+	if c.fd < 0 {
+		return nil, os.ErrClosed
+	}
+
+	c.seq++
+	seq := c.seq
+
+	msg := make([]byte, headerLen, headerLen+len(payload))
+	internal.NativeEndian.PutUint32(msg[0:], uint32(headerLen+len(payload)))
+	internal.NativeEndian.PutUint16(msg[4:], typ)
+	internal.NativeEndian.PutUint16(msg[6:], flags|unix.NLM_F_REQUEST)
+	internal.NativeEndian.PutUint32(msg[8:], seq)
+	msg = append(msg, payload...)
+
+	if _, err := unix.Write(c.fd, msg); err != nil {
+		return nil, fmt.Errorf("send netlink message: %w", err)
+	}
+
+	var replies []Message
+	for {
+		n, err := unix.Read(c.fd, c.buf)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("receive netlink message: %w", err)
+		}
+
+		msgs, err := parseMessages(c.buf[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, msg := range msgs {
+			if msg.seq != seq {
+				continue
+			}
+
+			switch msg.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+
+			case unix.NLMSG_ERROR:
+				if len(msg.Data) < 4 {
+					return nil, errors.New("netlink error message is too short")
+				}
+
+				// An error code of zero acknowledges the request.
+				errNo := int32(internal.NativeEndian.Uint32(msg.Data))
+				if errNo != 0 {
+					return nil, syscall.Errno(-errNo)
+				}
+				return replies, nil
+			}
+
+			// Data refers to the receive buffer, which is reused.
+			msg.Data = append([]byte(nil), msg.Data...)
+			replies = append(replies, msg.Message)
+
+			if flags&unix.NLM_F_DUMP == 0 && flags&unix.NLM_F_ACK == 0 {
+				return replies, nil
+			}
+		}
+	}
+}
+
+type message struct {
+	Message
+	seq uint32
+}
+
+func parseMessages(b []byte) ([]message, error) {
+	var msgs []message
+	for len(b) >= headerLen {
+		length := int(internal.NativeEndian.Uint32(b[0:]))
+		if length < headerLen || length > len(b) {
+			return nil, fmt.Errorf("invalid netlink message length %d", length)
+		}
+
+		msgs = append(msgs, message{
+			Message{
+				Type:  internal.NativeEndian.Uint16(b[4:]),
+				Flags: internal.NativeEndian.Uint16(b[6:]),
+				Data:  b[headerLen:length],
+			},
+			internal.NativeEndian.Uint32(b[8:]),
+		})
+
+		if aligned := internal.Align(length, alignTo); aligned < len(b) {
+			b = b[aligned:]
+		} else {
+			b = nil
+		}
+	}
+
+	return msgs, nil
+}
+
+// AttributeEncoder builds a list of netlink attributes.
+type AttributeEncoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded attributes.
+func (ae *AttributeEncoder) Bytes() []byte {
+	return ae.buf
+}
+
+// Raw appends an attribute with an arbitrary payload.
+func (ae *AttributeEncoder) Raw(typ uint16, data []byte) {
+	length := attrHeaderLen + len(data)
+
+	hdr := make([]byte, attrHeaderLen)
+	internal.NativeEndian.PutUint16(hdr[0:], uint16(length))
+	internal.NativeEndian.PutUint16(hdr[2:], typ)
+
+	ae.buf = append(ae.buf, hdr...)
+	ae.buf = append(ae.buf, data...)
+	ae.buf = append(ae.buf, make([]byte, internal.Align(length, alignTo)-length)...)
+}
+
+// Uint32 appends an attribute holding a uint32.
+func (ae *AttributeEncoder) Uint32(typ uint16, value uint32) {
+	data := make([]byte, 4)
+	internal.NativeEndian.PutUint32(data, value)
+	ae.Raw(typ, data)
+}
+
+// String appends an attribute holding a NUL terminated string.
+func (ae *AttributeEncoder) String(typ uint16, value string) {
+	ae.Raw(typ, append([]byte(value), 0))
+}
+
+// Nested appends an attribute containing the attributes encoded by fn.
+func (ae *AttributeEncoder) Nested(typ uint16, fn func(*AttributeEncoder)) {
+	var nested AttributeEncoder
+	fn(&nested)
+	ae.Raw(typ|unix.NLA_F_NESTED, nested.Bytes())
+}
+
+// Attributes maps attribute types to their payload.
+type Attributes map[uint16][]byte
+
+// ParseAttributes decodes a list of attributes.
+//
+// The nested and byte order flags are removed from attribute types.
+func ParseAttributes(b []byte) (Attributes, error) {
+	attrs := make(Attributes)
+	for len(b) >= attrHeaderLen {
+		length := int(internal.NativeEndian.Uint16(b[0:]))
+		typ := internal.NativeEndian.Uint16(b[2:])
+		if length < attrHeaderLen || length > len(b) {
+			return nil, fmt.Errorf("invalid attribute length %d", length)
+		}
+
+		attrs[typ&^0xc000] = b[attrHeaderLen:length]
+
+		if aligned := internal.Align(length, alignTo); aligned < len(b) {
+			b = b[aligned:]
+		} else {
+			b = nil
+		}
+	}
+
+	return attrs, nil
+}
+
+// Uint32 returns the value of a uint32 attribute.
+func (a Attributes) Uint32(typ uint16) (uint32, bool) {
+	data, ok := a[typ]
+	if !ok || len(data) < 4 {
+		return 0, false
+	}
+	return internal.NativeEndian.Uint32(data), true
+}
+
+// String returns the value of a string attribute.
+func (a Attributes) String(typ uint16) (string, bool) {
+	data, ok := a[typ]
+	if !ok {
+		return "", false
+	}
+	return unix.ByteSliceToString(data), true
+}
@@ -0,0 +1,75 @@
+package netlink
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+func TestAttributes(t *testing.T) {
+	var ae AttributeEncoder
+	ae.Uint32(1, 42)
+	ae.String(2, "foo")
+	ae.Nested(3, func(ae *AttributeEncoder) {
+		ae.Uint32(4, 23)
+	})
+
+	if len(ae.Bytes())%alignTo != 0 {
+		t.Fatal("Attributes are not aligned")
+	}
+
+	attrs, err := ParseAttributes(ae.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := attrs.Uint32(1); !ok || v != 42 {
+		t.Error("Expected uint32 attribute 42, got", v)
+	}
+
+	if s, ok := attrs.String(2); !ok || s != "foo" {
+		t.Errorf("Expected string attribute foo, got %q", s)
+	}
+
+	nested, err := ParseAttributes(attrs[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := nested.Uint32(4); !ok || v != 23 {
+		t.Error("Expected nested uint32 attribute 23, got", v)
+	}
+
+	if _, err := ParseAttributes([]byte{0xff, 0, 0, 0}); err == nil {
+		t.Error("Parsing an invalid attribute should fail")
+	}
+
+	if !bytes.Equal(attrs[2], []byte("foo\x00")) {
+		t.Error("String attribute isn't NUL terminated")
+	}
+}
+
+func TestConn(t *testing.T) {
+	conn, err := Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Query filters of a non-existent interface.
+	payload := make([]byte, 20)
+	internal.NativeEndian.PutUint32(payload[4:], math.MaxInt32)
+	if _, err := conn.Execute(unix.RTM_GETTFILTER, unix.NLM_F_ACK, payload); err == nil {
+		t.Fatal("Expected an error for a missing interface")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Execute(unix.RTM_GETTFILTER, unix.NLM_F_ACK, payload); err == nil {
+		t.Fatal("Execute on closed Conn should fail")
+	}
+}
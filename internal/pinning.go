@@ -54,6 +54,22 @@ func Pin(currentPath, newPath string, fd *sys.FD) error {
 	})
 }
 
+// ReplacePin atomically replaces the object pinned at path with fd.
+func ReplacePin(path string, fd *sys.FD) error {
+	// bpffs doesn't allow dots in names.
+	tmp := fmt.Sprintf("%s_tmp%d", path, os.Getpid())
+	if err := Pin("", tmp, fd); err != nil {
+		return err
+	}
+
+	if err := unix.Renameat2(unix.AT_FDCWD, tmp, unix.AT_FDCWD, path, 0); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to replace pinned object at %v: %w", path, err)
+	}
+
+	return nil
+}
+
 func Unpin(pinnedPath string) error {
 	if pinnedPath == "" {
 		return nil
@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/cilium/ebpf/internal/unix"
 )
@@ -14,10 +15,12 @@ var ErrClosedFd = unix.EBADF
 
 type FD struct {
 	raw int
+	// The Backend which created the fd, nil for the kernel.
+	backend Backend
 }
 
 func newFD(value int) *FD {
-	fd := &FD{value}
+	fd := &FD{value, currentBackend()}
 	runtime.SetFinalizer(fd, (*FD).Close)
 	return fd
 }
@@ -68,6 +71,9 @@ func (fd *FD) Close() error {
 	fd.raw = -1
 
 	fd.Forget()
+	if fd.backend != nil {
+		return fd.backend.Close(value)
+	}
 	return unix.Close(value)
 }
 
@@ -80,17 +86,47 @@ func (fd *FD) Dup() (*FD, error) {
 		return nil, ErrClosedFd
 	}
 
-	// Always require the fd to be larger than zero: the BPF API treats the value
-	// as "no argument provided".
-	dup, err := unix.FcntlInt(uintptr(fd.raw), unix.F_DUPFD_CLOEXEC, 1)
+	var (
+		dup int
+		err error
+	)
+	if fd.backend != nil {
+		dup, err = fd.backend.Dup(fd.raw)
+	} else {
+		// Always require the fd to be larger than zero: the BPF API treats the value
+		// as "no argument provided".
+		dup, err = unix.FcntlInt(uintptr(fd.raw), unix.F_DUPFD_CLOEXEC, 1)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("can't dup fd: %v", err)
 	}
 
-	return newFD(dup), nil
+	dupFD := &FD{dup, fd.backend}
+	runtime.SetFinalizer(dupFD, (*FD).Close)
+	return dupFD, nil
 }
 
 func (fd *FD) File(name string) *os.File {
 	fd.Forget()
 	return os.NewFile(uintptr(fd.raw), name)
 }
+
+// CheckAnonInode returns an error if fd is known to refer to something other
+// than the anonymous inode called name, for example "bpf-map".
+//
+// The kernel returns information about any kind of BPF object when asked
+// for info about a specific kind, so this guards against interpreting one
+// kind of object as another.
+func (fd *FD) CheckAnonInode(name string) error {
+	link, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd.raw))
+	if err != nil {
+		// procfs may not be available, rely on the kernel instead.
+		return nil
+	}
+
+	// BPF objects are anonymous inodes named after their kind.
+	if strings.HasPrefix(link, "anon_inode:") && link != "anon_inode:"+name {
+		return fmt.Errorf("fd %d refers to %s instead of anon_inode:%s", fd.raw, link, name)
+	}
+	return nil
+}
@@ -36,3 +36,8 @@ func NewStringPointer(str string) Pointer {
 
 	return Pointer{ptr: unsafe.Pointer(p)}
 }
+
+// Unsafe returns the wrapped unsafe Pointer.
+func (p Pointer) Unsafe() unsafe.Pointer {
+	return p.ptr
+}
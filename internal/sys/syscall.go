@@ -2,6 +2,7 @@ package sys
 
 import (
 	"runtime"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
@@ -12,8 +13,18 @@ import (
 //
 // Any pointers contained in attr must use the Pointer type from this package.
 func BPF(cmd Cmd, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	b := currentBackend()
+
 	for {
-		r1, _, errNo := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+		var (
+			r1    uintptr
+			errNo syscall.Errno
+		)
+		if b != nil {
+			r1, errNo = b.BPF(cmd, attr, size)
+		} else {
+			r1, _, errNo = unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+		}
 		runtime.KeepAlive(attr)
 
 		// As of ~4.20 the verifier can be interrupted by a signal,
@@ -31,6 +42,42 @@ func BPF(cmd Cmd, attr unsafe.Pointer, size uintptr) (uintptr, error) {
 	}
 }
 
+// Backend is an alternative implementation of the bpf(2) syscall.
+//
+// File descriptors returned by a Backend don't have to exist in the
+// operating system. An FD remembers the Backend which was active when it was
+// created and delegates duplicating and closing to it.
+type Backend interface {
+	// BPF receives the same arguments as the syscall and returns either the
+	// result or a non-zero errno.
+	BPF(cmd Cmd, attr unsafe.Pointer, size uintptr) (uintptr, syscall.Errno)
+
+	// Dup duplicates fd. The result must be larger than zero.
+	Dup(fd int) (int, error)
+
+	// Close releases fd.
+	Close(fd int) error
+}
+
+// backendValue allows storing a nil Backend in an atomic.Value.
+type backendValue struct{ Backend }
+
+var backend atomic.Value
+
+// SetBackend routes all calls to BPF to b instead of the kernel.
+//
+// Passing nil restores the default behaviour. Returns the previous Backend,
+// which is nil if the kernel was in use.
+func SetBackend(b Backend) Backend {
+	prev, _ := backend.Swap(backendValue{b}).(backendValue)
+	return prev.Backend
+}
+
+func currentBackend() Backend {
+	b, _ := backend.Load().(backendValue)
+	return b.Backend
+}
+
 // Info is implemented by all structs that can be passed to the ObjInfo syscall.
 //
 //    MapInfo
@@ -69,14 +116,23 @@ func (i *BtfInfo) info() (unsafe.Pointer, uint32) {
 //
 // info may be one of MapInfo, ProgInfo, LinkInfo and BtfInfo.
 func ObjInfo(fd *FD, info Info) error {
+	_, err := ObjInfoLen(fd, info)
+	return err
+}
+
+// ObjInfoLen is like ObjInfo, but also returns the number of bytes of info
+// populated by the kernel. Older kernels don't know about all fields of info
+// and populate fewer bytes.
+func ObjInfoLen(fd *FD, info Info) (uint32, error) {
 	ptr, len := info.info()
-	err := ObjGetInfoByFd(&ObjGetInfoByFdAttr{
+	attr := ObjGetInfoByFdAttr{
 		BpfFd:   fd.Uint(),
 		InfoLen: len,
 		Info:    NewPointer(ptr),
-	})
+	}
+	err := ObjGetInfoByFd(&attr)
 	runtime.KeepAlive(fd)
-	return err
+	return attr.InfoLen, err
 }
 
 // BPFObjName is a null-terminated string made up of
@@ -5,6 +5,7 @@ package unix
 
 import (
 	"syscall"
+	"unsafe"
 
 	linux "golang.org/x/sys/unix"
 )
@@ -24,8 +25,12 @@ const (
 	E2BIG   = linux.E2BIG
 	EFAULT  = linux.EFAULT
 	EACCES  = linux.EACCES
+	EBUSY   = linux.EBUSY
 	// ENOTSUPP is not the same as ENOTSUP or EOPNOTSUP
 	ENOTSUPP = syscall.Errno(0x20c)
+	// EAFNOSUPPORT is returned when creating a socket of an unknown family
+	EAFNOSUPPORT = linux.EAFNOSUPPORT
+	ENOBUFS      = linux.ENOBUFS
 
 	BPF_F_NO_PREALLOC        = linux.BPF_F_NO_PREALLOC
 	BPF_F_NUMA_NODE          = linux.BPF_F_NUMA_NODE
@@ -36,6 +41,10 @@ const (
 	BPF_F_SLEEPABLE          = linux.BPF_F_SLEEPABLE
 	BPF_F_MMAPABLE           = linux.BPF_F_MMAPABLE
 	BPF_F_INNER_MAP          = linux.BPF_F_INNER_MAP
+	BPF_F_STACK_BUILD_ID     = linux.BPF_F_STACK_BUILD_ID
+	BPF_F_TEST_RUN_ON_CPU    = linux.BPF_F_TEST_RUN_ON_CPU
+	BPF_F_TOKEN_FD           = 1 << 16 // Not yet defined by x/sys/unix.
+	BPF_F_LINK               = 1 << 13 // Not yet defined by x/sys/unix.
 	BPF_OBJ_NAME_LEN         = linux.BPF_OBJ_NAME_LEN
 	BPF_TAG_SIZE             = linux.BPF_TAG_SIZE
 	BPF_RINGBUF_BUSY_BIT     = linux.BPF_RINGBUF_BUSY_BIT
@@ -43,10 +52,15 @@ const (
 	BPF_RINGBUF_HDR_SZ       = linux.BPF_RINGBUF_HDR_SZ
 	SYS_BPF                  = linux.SYS_BPF
 	F_DUPFD_CLOEXEC          = linux.F_DUPFD_CLOEXEC
+	F_GETFL                  = linux.F_GETFL
 	EPOLL_CTL_ADD            = linux.EPOLL_CTL_ADD
 	EPOLL_CLOEXEC            = linux.EPOLL_CLOEXEC
 	O_CLOEXEC                = linux.O_CLOEXEC
 	O_NONBLOCK               = linux.O_NONBLOCK
+	O_ACCMODE                = linux.O_ACCMODE
+	O_RDONLY                 = linux.O_RDONLY
+	O_WRONLY                 = linux.O_WRONLY
+	O_RDWR                   = linux.O_RDWR
 	PROT_READ                = linux.PROT_READ
 	PROT_WRITE               = linux.PROT_WRITE
 	MAP_SHARED               = linux.MAP_SHARED
@@ -70,6 +84,56 @@ const (
 	SO_ATTACH_BPF            = linux.SO_ATTACH_BPF
 	SO_DETACH_BPF            = linux.SO_DETACH_BPF
 	SOL_SOCKET               = linux.SOL_SOCKET
+	MFD_CLOEXEC              = linux.MFD_CLOEXEC
+	MAP_PRIVATE              = linux.MAP_PRIVATE
+	MAP_ANONYMOUS            = linux.MAP_ANONYMOUS
+	MAP_POPULATE             = linux.MAP_POPULATE
+	SOCK_RAW                 = linux.SOCK_RAW
+	SOCK_CLOEXEC             = linux.SOCK_CLOEXEC
+	MSG_DONTWAIT             = linux.MSG_DONTWAIT
+	POLLIN                   = linux.POLLIN
+)
+
+// Constants for AF_XDP sockets.
+const (
+	AF_XDP                         = linux.AF_XDP
+	SOL_XDP                        = linux.SOL_XDP
+	XDP_MMAP_OFFSETS               = linux.XDP_MMAP_OFFSETS
+	XDP_RX_RING                    = linux.XDP_RX_RING
+	XDP_TX_RING                    = linux.XDP_TX_RING
+	XDP_UMEM_REG                   = linux.XDP_UMEM_REG
+	XDP_UMEM_FILL_RING             = linux.XDP_UMEM_FILL_RING
+	XDP_UMEM_COMPLETION_RING       = linux.XDP_UMEM_COMPLETION_RING
+	XDP_STATISTICS                 = linux.XDP_STATISTICS
+	XDP_PGOFF_RX_RING              = linux.XDP_PGOFF_RX_RING
+	XDP_PGOFF_TX_RING              = linux.XDP_PGOFF_TX_RING
+	XDP_UMEM_PGOFF_FILL_RING       = linux.XDP_UMEM_PGOFF_FILL_RING
+	XDP_UMEM_PGOFF_COMPLETION_RING = linux.XDP_UMEM_PGOFF_COMPLETION_RING
+	XDP_COPY                       = linux.XDP_COPY
+	XDP_ZEROCOPY                   = linux.XDP_ZEROCOPY
+	XDP_USE_NEED_WAKEUP            = linux.XDP_USE_NEED_WAKEUP
+	XDP_RING_NEED_WAKEUP           = linux.XDP_RING_NEED_WAKEUP
+)
+
+// Constants for rtnetlink.
+const (
+	AF_NETLINK     = linux.AF_NETLINK
+	NETLINK_ROUTE  = linux.NETLINK_ROUTE
+	NLM_F_REQUEST  = linux.NLM_F_REQUEST
+	NLM_F_ACK      = linux.NLM_F_ACK
+	NLM_F_DUMP     = linux.NLM_F_DUMP
+	NLM_F_CREATE   = linux.NLM_F_CREATE
+	NLM_F_EXCL     = linux.NLM_F_EXCL
+	NLM_F_REPLACE  = linux.NLM_F_REPLACE
+	NLM_F_ECHO     = linux.NLM_F_ECHO
+	NLMSG_ERROR    = linux.NLMSG_ERROR
+	NLMSG_DONE     = linux.NLMSG_DONE
+	NLA_F_NESTED   = linux.NLA_F_NESTED
+	RTM_NEWQDISC   = linux.RTM_NEWQDISC
+	RTM_NEWTFILTER = linux.RTM_NEWTFILTER
+	RTM_DELTFILTER = linux.RTM_DELTFILTER
+	RTM_GETTFILTER = linux.RTM_GETTFILTER
+	ETH_P_ALL      = linux.ETH_P_ALL
 )
 
 // Statfs_t is a wrapper
@@ -123,6 +187,11 @@ func Eventfd(initval uint, flags int) (fd int, err error) {
 	return linux.Eventfd(initval, flags)
 }
 
+// Read is a wrapper
+func Read(fd int, p []byte) (n int, err error) {
+	return linux.Read(fd, p)
+}
+
 // Write is a wrapper
 func Write(fd int, p []byte) (n int, err error) {
 	return linux.Write(fd, p)
@@ -208,3 +277,101 @@ func Open(path string, mode int, perm uint32) (int, error) {
 func Fstat(fd int, stat *Stat_t) error {
 	return linux.Fstat(fd, stat)
 }
+
+// MemfdCreate is a wrapper
+func MemfdCreate(name string, flags int) (fd int, err error) {
+	return linux.MemfdCreate(name, flags)
+}
+
+// XDPUmemReg is a wrapper
+type XDPUmemReg = linux.XDPUmemReg
+
+// XDPRingOffset is a wrapper
+type XDPRingOffset = linux.XDPRingOffset
+
+// XDPMmapOffsets is a wrapper
+type XDPMmapOffsets = linux.XDPMmapOffsets
+
+// XDPStatistics is a wrapper
+type XDPStatistics = linux.XDPStatistics
+
+// Sockaddr is a wrapper
+type Sockaddr = linux.Sockaddr
+
+// SockaddrXDP is a wrapper
+type SockaddrXDP = linux.SockaddrXDP
+
+// PollFd is a wrapper
+type PollFd = linux.PollFd
+
+// Socket is a wrapper
+func Socket(domain, typ, proto int) (int, error) {
+	return linux.Socket(domain, typ, proto)
+}
+
+// Bind is a wrapper
+func Bind(fd int, sa Sockaddr) error {
+	return linux.Bind(fd, sa)
+}
+
+// Sendto sends p on a connected socket.
+func Sendto(fd int, p []byte, flags int) error {
+	var ptr unsafe.Pointer
+	if len(p) > 0 {
+		ptr = unsafe.Pointer(&p[0])
+	}
+
+	_, _, errNo := linux.Syscall6(linux.SYS_SENDTO, uintptr(fd), uintptr(ptr), uintptr(len(p)), uintptr(flags), 0, 0)
+	if errNo != 0 {
+		return errNo
+	}
+	return nil
+}
+
+// Poll is a wrapper
+func Poll(fds []PollFd, timeout int) (int, error) {
+	return linux.Poll(fds, timeout)
+}
+
+// Getsockopt reads a socket option of arbitrary size into value.
+//
+// size must contain the size of value and is updated with the size of the
+// option.
+func Getsockopt(fd, level, opt int, value unsafe.Pointer, size *uint32) error {
+	_, _, errNo := linux.Syscall6(linux.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt), uintptr(value), uintptr(unsafe.Pointer(size)), 0)
+	if errNo != 0 {
+		return errNo
+	}
+	return nil
+}
+
+// Setsockopt sets a socket option of arbitrary size.
+func Setsockopt(fd, level, opt int, value unsafe.Pointer, size uint32) error {
+	_, _, errNo := linux.Syscall6(linux.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt), uintptr(value), uintptr(size), 0)
+	if errNo != 0 {
+		return errNo
+	}
+	return nil
+}
+
+// CLONE_NEWNET is a wrapper
+const CLONE_NEWNET = linux.CLONE_NEWNET
+
+// Setns is a wrapper
+func Setns(fd int, nstype int) error {
+	return linux.Setns(fd, nstype)
+}
+
+// SetsockoptInt is a wrapper
+func SetsockoptInt(fd, level, opt, value int) error {
+	return linux.SetsockoptInt(fd, level, opt, value)
+}
+
+// PidfdOpen returns a file descriptor referring to the process pid.
+func PidfdOpen(pid int, flags int) (int, error) {
+	fd, _, errNo := linux.Syscall(linux.SYS_PIDFD_OPEN, uintptr(pid), uintptr(flags), 0)
+	if errNo != 0 {
+		return -1, errNo
+	}
+	return int(fd), nil
+}
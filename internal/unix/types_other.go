@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"runtime"
 	"syscall"
+	"unsafe"
 )
 
 var errNonLinux = fmt.Errorf("unsupported platform %s/%s", runtime.GOOS, runtime.GOARCH)
@@ -25,8 +26,12 @@ const (
 	E2BIG  = syscall.Errno(0)
 	EFAULT = syscall.EFAULT
 	EACCES = syscall.Errno(0)
+	EBUSY  = syscall.EBUSY
 	// ENOTSUPP is not the same as ENOTSUP or EOPNOTSUP
 	ENOTSUPP = syscall.Errno(0x20c)
+	// EAFNOSUPPORT is returned when creating a socket of an unknown family
+	EAFNOSUPPORT = syscall.EAFNOSUPPORT
+	ENOBUFS      = syscall.ENOBUFS
 
 	BPF_F_NO_PREALLOC        = 0
 	BPF_F_NUMA_NODE          = 0
@@ -37,6 +42,10 @@ const (
 	BPF_F_SLEEPABLE          = 0
 	BPF_F_MMAPABLE           = 0
 	BPF_F_INNER_MAP          = 0
+	BPF_F_STACK_BUILD_ID     = 0
+	BPF_F_TEST_RUN_ON_CPU    = 0
+	BPF_F_TOKEN_FD           = 0
+	BPF_F_LINK               = 0
 	BPF_OBJ_NAME_LEN         = 0x10
 	BPF_TAG_SIZE             = 0x8
 	BPF_RINGBUF_BUSY_BIT     = 0
@@ -44,11 +53,16 @@ const (
 	BPF_RINGBUF_HDR_SZ       = 0
 	SYS_BPF                  = 321
 	F_DUPFD_CLOEXEC          = 0x406
+	F_GETFL                  = 0x3
 	EPOLLIN                  = 0x1
 	EPOLL_CTL_ADD            = 0x1
 	EPOLL_CLOEXEC            = 0x80000
 	O_CLOEXEC                = 0x80000
 	O_NONBLOCK               = 0x800
+	O_ACCMODE                = 0x3
+	O_RDONLY                 = 0x0
+	O_WRONLY                 = 0x1
+	O_RDWR                   = 0x2
 	PROT_READ                = 0x1
 	PROT_WRITE               = 0x2
 	MAP_SHARED               = 0x1
@@ -72,6 +86,56 @@ const (
 	SO_ATTACH_BPF            = 0x32
 	SO_DETACH_BPF            = 0x1b
 	SOL_SOCKET               = 0x1
+	MFD_CLOEXEC              = 0x1
+	MAP_PRIVATE              = 0x2
+	MAP_ANONYMOUS            = 0x20
+	MAP_POPULATE             = 0x8000
+	SOCK_RAW                 = 0x3
+	SOCK_CLOEXEC             = 0x80000
+	MSG_DONTWAIT             = 0x40
+	POLLIN                   = 0x1
+)
+
+// Constants for AF_XDP sockets.
+const (
+	AF_XDP                         = 0x2c
+	SOL_XDP                        = 0x11b
+	XDP_MMAP_OFFSETS               = 0x1
+	XDP_RX_RING                    = 0x2
+	XDP_TX_RING                    = 0x3
+	XDP_UMEM_REG                   = 0x4
+	XDP_UMEM_FILL_RING             = 0x5
+	XDP_UMEM_COMPLETION_RING       = 0x6
+	XDP_STATISTICS                 = 0x7
+	XDP_PGOFF_RX_RING              = 0
+	XDP_PGOFF_TX_RING              = 0x80000000
+	XDP_UMEM_PGOFF_FILL_RING       = 0x100000000
+	XDP_UMEM_PGOFF_COMPLETION_RING = 0x180000000
+	XDP_COPY                       = 0x2
+	XDP_ZEROCOPY                   = 0x4
+	XDP_USE_NEED_WAKEUP            = 0x8
+	XDP_RING_NEED_WAKEUP           = 0x1
+)
+
+// Constants for rtnetlink.
+const (
+	AF_NETLINK     = 0x10
+	NETLINK_ROUTE  = 0x0
+	NLM_F_REQUEST  = 0x1
+	NLM_F_ACK      = 0x4
+	NLM_F_DUMP     = 0x300
+	NLM_F_CREATE   = 0x400
+	NLM_F_EXCL     = 0x200
+	NLM_F_REPLACE  = 0x100
+	NLM_F_ECHO     = 0x8
+	NLMSG_ERROR    = 0x2
+	NLMSG_DONE     = 0x3
+	NLA_F_NESTED   = 0x8000
+	RTM_NEWQDISC   = 0x24
+	RTM_NEWTFILTER = 0x2c
+	RTM_DELTFILTER = 0x2d
+	RTM_GETTFILTER = 0x2e
+	ETH_P_ALL      = 0x3
 )
 
 // Statfs_t is a wrapper
@@ -90,7 +154,10 @@ type Statfs_t struct {
 	Spare   [4]int64
 }
 
-type Stat_t struct{}
+type Stat_t struct {
+	Dev uint64
+	Ino uint64
+}
 
 // Rlimit is a wrapper
 type Rlimit struct {
@@ -145,6 +212,11 @@ func Eventfd(initval uint, flags int) (fd int, err error) {
 	return 0, errNonLinux
 }
 
+// Read is a wrapper
+func Read(fd int, p []byte) (n int, err error) {
+	return 0, errNonLinux
+}
+
 // Write is a wrapper
 func Write(fd int, p []byte) (n int, err error) {
 	return 0, errNonLinux
@@ -276,3 +348,110 @@ func Open(path string, mode int, perm uint32) (int, error) {
 func Fstat(fd int, stat *Stat_t) error {
 	return errNonLinux
 }
+
+// MemfdCreate is a wrapper
+func MemfdCreate(name string, flags int) (fd int, err error) {
+	return -1, errNonLinux
+}
+
+// XDPUmemReg is a wrapper
+type XDPUmemReg struct {
+	Addr     uint64
+	Len      uint64
+	Size     uint32
+	Headroom uint32
+	Flags    uint32
+	_        [4]byte
+}
+
+// XDPRingOffset is a wrapper
+type XDPRingOffset struct {
+	Producer uint64
+	Consumer uint64
+	Desc     uint64
+	Flags    uint64
+}
+
+// XDPMmapOffsets is a wrapper
+type XDPMmapOffsets struct {
+	Rx XDPRingOffset
+	Tx XDPRingOffset
+	Fr XDPRingOffset
+	Cr XDPRingOffset
+}
+
+// XDPStatistics is a wrapper
+type XDPStatistics struct {
+	Rx_dropped               uint64
+	Rx_invalid_descs         uint64
+	Tx_invalid_descs         uint64
+	Rx_ring_full             uint64
+	Rx_fill_ring_empty_descs uint64
+	Tx_ring_empty_descs      uint64
+}
+
+// Sockaddr is a wrapper
+type Sockaddr interface{}
+
+// SockaddrXDP is a wrapper
+type SockaddrXDP struct {
+	Flags        uint16
+	Ifindex      uint32
+	QueueID      uint32
+	SharedUmemFD uint32
+}
+
+// PollFd is a wrapper
+type PollFd struct {
+	Fd      int32
+	Events  int16
+	Revents int16
+}
+
+// Socket is a wrapper
+func Socket(domain, typ, proto int) (int, error) {
+	return -1, errNonLinux
+}
+
+// Bind is a wrapper
+func Bind(fd int, sa Sockaddr) error {
+	return errNonLinux
+}
+
+// Sendto is a wrapper
+func Sendto(fd int, p []byte, flags int) error {
+	return errNonLinux
+}
+
+// Poll is a wrapper
+func Poll(fds []PollFd, timeout int) (int, error) {
+	return -1, errNonLinux
+}
+
+// Getsockopt is a wrapper
+func Getsockopt(fd, level, opt int, value unsafe.Pointer, size *uint32) error {
+	return errNonLinux
+}
+
+// Setsockopt is a wrapper
+func Setsockopt(fd, level, opt int, value unsafe.Pointer, size uint32) error {
+	return errNonLinux
+}
+
+// CLONE_NEWNET is a wrapper
+const CLONE_NEWNET = 0x40000000
+
+// Setns is a wrapper
+func Setns(fd int, nstype int) error {
+	return errNonLinux
+}
+
+// SetsockoptInt is a wrapper
+func SetsockoptInt(fd, level, opt, value int) error {
+	return errNonLinux
+}
+
+// PidfdOpen is a wrapper
+func PidfdOpen(pid int, flags int) (int, error) {
+	return -1, errNonLinux
+}
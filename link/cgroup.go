@@ -8,13 +8,22 @@ import (
 	"github.com/cilium/ebpf"
 )
 
-type cgroupAttachFlags uint32
+// CgroupAttachFlags control how a program attached with BPF_PROG_ATTACH
+// interacts with other programs in the cgroup hierarchy.
+type CgroupAttachFlags uint32
 
 // cgroup attach flags
 const (
-	flagAllowOverride cgroupAttachFlags = 1 << iota
-	flagAllowMulti
-	flagReplace
+	// CgroupAllowOverride allows programs attached to descendant cgroups
+	// to override the program. Equivalent to BPF_F_ALLOW_OVERRIDE.
+	CgroupAllowOverride CgroupAttachFlags = 1 << iota
+	// CgroupAllowMulti allows attaching multiple programs to the same
+	// cgroup. Programs attached to descendants run in addition to the
+	// program. Equivalent to BPF_F_ALLOW_MULTI.
+	CgroupAllowMulti
+	// CgroupReplace atomically replaces CgroupOptions.Replace. Equivalent
+	// to BPF_F_REPLACE, it's set automatically if Replace is given.
+	CgroupReplace
 )
 
 type CgroupOptions struct {
@@ -24,10 +33,33 @@ type CgroupOptions struct {
 	Attach ebpf.AttachType
 	// Program must be of type CGroup*, and the attach type must match Attach.
 	Program *ebpf.Program
+	// Attach the program with BPF_PROG_ATTACH and the given flags instead
+	// of creating a bpf_link. Use this to coexist with other processes
+	// which attach programs to the same cgroup hierarchy with flags.
+	Flags CgroupAttachFlags
+	// A program attached to the cgroup with CgroupAllowMulti which is
+	// atomically replaced by Program, so that there is no window in which
+	// neither program runs. Requires Flags to contain CgroupAllowMulti
+	// and at least Linux 5.6.
+	Replace *ebpf.Program
 }
 
 // AttachCgroup links a BPF program to a cgroup.
+//
+// Uses a bpf_link if supported by the kernel, and falls back to
+// BPF_PROG_ATTACH otherwise. Setting Flags or Replace always uses the
+// latter.
 func AttachCgroup(opts CgroupOptions) (Link, error) {
+	flags := opts.Flags
+	if opts.Replace != nil {
+		if flags&CgroupAllowMulti == 0 {
+			return nil, fmt.Errorf("replacing a program requires CgroupAllowMulti: %w", errInvalidInput)
+		}
+		flags |= CgroupReplace
+	} else if flags&CgroupReplace != 0 {
+		return nil, fmt.Errorf("CgroupReplace requires a program to replace: %w", errInvalidInput)
+	}
+
 	cgroup, err := os.Open(opts.Path)
 	if err != nil {
 		return nil, fmt.Errorf("can't open cgroup: %s", err)
@@ -40,12 +72,16 @@ func AttachCgroup(opts CgroupOptions) (Link, error) {
 	}
 
 	var cg Link
-	cg, err = newLinkCgroup(cgroup, opts.Attach, clone)
-	if errors.Is(err, ErrNotSupported) {
-		cg, err = newProgAttachCgroup(cgroup, opts.Attach, clone, flagAllowMulti)
-	}
-	if errors.Is(err, ErrNotSupported) {
-		cg, err = newProgAttachCgroup(cgroup, opts.Attach, clone, flagAllowOverride)
+	if flags != 0 {
+		cg, err = newProgAttachCgroup(cgroup, opts.Attach, clone, flags, opts.Replace)
+	} else {
+		cg, err = newLinkCgroup(cgroup, opts.Attach, clone)
+		if errors.Is(err, ErrNotSupported) {
+			cg, err = newProgAttachCgroup(cgroup, opts.Attach, clone, CgroupAllowMulti, nil)
+		}
+		if errors.Is(err, ErrNotSupported) {
+			cg, err = newProgAttachCgroup(cgroup, opts.Attach, clone, CgroupAllowOverride, nil)
+		}
 	}
 	if err != nil {
 		cgroup.Close()
@@ -60,15 +96,17 @@ type progAttachCgroup struct {
 	cgroup     *os.File
 	current    *ebpf.Program
 	attachType ebpf.AttachType
-	flags      cgroupAttachFlags
+	flags      CgroupAttachFlags
 }
 
 var _ Link = (*progAttachCgroup)(nil)
 
 func (cg *progAttachCgroup) isLink() {}
 
-func newProgAttachCgroup(cgroup *os.File, attach ebpf.AttachType, prog *ebpf.Program, flags cgroupAttachFlags) (*progAttachCgroup, error) {
-	if flags&flagAllowMulti > 0 {
+// newProgAttachCgroup attaches prog using BPF_PROG_ATTACH. replace may be
+// nil, otherwise flags must include CgroupReplace.
+func newProgAttachCgroup(cgroup *os.File, attach ebpf.AttachType, prog *ebpf.Program, flags CgroupAttachFlags, replace *ebpf.Program) (*progAttachCgroup, error) {
+	if flags&CgroupAllowMulti > 0 {
 		if err := haveProgAttachReplace(); err != nil {
 			return nil, fmt.Errorf("can't support multiple programs: %w", err)
 		}
@@ -77,6 +115,7 @@ func newProgAttachCgroup(cgroup *os.File, attach ebpf.AttachType, prog *ebpf.Pro
 	err := RawAttachProgram(RawAttachProgramOptions{
 		Target:  int(cgroup.Fd()),
 		Program: prog,
+		Replace: replace,
 		Flags:   uint32(flags),
 		Attach:  attach,
 	})
@@ -84,7 +123,8 @@ func newProgAttachCgroup(cgroup *os.File, attach ebpf.AttachType, prog *ebpf.Pro
 		return nil, fmt.Errorf("cgroup: %w", err)
 	}
 
-	return &progAttachCgroup{cgroup, prog, attach, flags}, nil
+	// Replacing only applies to the initial attachment.
+	return &progAttachCgroup{cgroup, prog, attach, flags &^ CgroupReplace}, nil
 }
 
 func (cg *progAttachCgroup) Close() error {
@@ -115,11 +155,11 @@ func (cg *progAttachCgroup) Update(prog *ebpf.Program) error {
 		Flags:   uint32(cg.flags),
 	}
 
-	if cg.flags&flagAllowMulti > 0 {
+	if cg.flags&CgroupAllowMulti > 0 {
 		// Atomically replacing multiple programs requires at least
 		// 5.5 (commit 7dd68b3279f17921 "bpf: Support replacing cgroup-bpf
 		// program in MULTI mode")
-		args.Flags |= uint32(flagReplace)
+		args.Flags |= uint32(CgroupReplace)
 		args.Replace = cg.current
 	}
 
@@ -34,7 +34,7 @@ func TestAttachCgroup(t *testing.T) {
 func TestProgAttachCgroup(t *testing.T) {
 	cgroup, prog := mustCgroupFixtures(t)
 
-	link, err := newProgAttachCgroup(cgroup, ebpf.AttachCGroupInetEgress, prog, 0)
+	link, err := newProgAttachCgroup(cgroup, ebpf.AttachCGroupInetEgress, prog, 0, nil)
 	if err != nil {
 		t.Fatal("Can't create link:", err)
 	}
@@ -45,7 +45,7 @@ func TestProgAttachCgroup(t *testing.T) {
 func TestProgAttachCgroupAllowMulti(t *testing.T) {
 	cgroup, prog := mustCgroupFixtures(t)
 
-	link, err := newProgAttachCgroup(cgroup, ebpf.AttachCGroupInetEgress, prog, flagAllowMulti)
+	link, err := newProgAttachCgroup(cgroup, ebpf.AttachCGroupInetEgress, prog, CgroupAllowMulti, nil)
 	testutils.SkipIfNotSupported(t, err)
 	if err != nil {
 		t.Fatal("Can't create link:", err)
@@ -57,6 +57,53 @@ func TestProgAttachCgroupAllowMulti(t *testing.T) {
 	testLink(t, link, prog2)
 }
 
+func TestAttachCgroupReplace(t *testing.T) {
+	cgroup, prog := mustCgroupFixtures(t)
+
+	old, err := AttachCgroup(CgroupOptions{
+		Path:    cgroup.Name(),
+		Attach:  ebpf.AttachCGroupInetEgress,
+		Program: prog,
+		Flags:   CgroupAllowMulti,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	if _, ok := old.(*progAttachCgroup); !ok {
+		t.Fatalf("Expected progAttachCgroup when passing flags, got %T", old)
+	}
+
+	prog2 := mustLoadProgram(t, ebpf.CGroupSKB, ebpf.AttachCGroupInetEgress, "")
+	_, err = AttachCgroup(CgroupOptions{
+		Path:    cgroup.Name(),
+		Attach:  ebpf.AttachCGroupInetEgress,
+		Program: prog2,
+		Replace: prog,
+	})
+	if err == nil {
+		t.Fatal("Replace without CgroupAllowMulti should fail")
+	}
+
+	replacement, err := AttachCgroup(CgroupOptions{
+		Path:    cgroup.Name(),
+		Attach:  ebpf.AttachCGroupInetEgress,
+		Program: prog2,
+		Flags:   CgroupAllowMulti,
+		Replace: prog,
+	})
+	if err != nil {
+		t.Fatal("Can't replace program:", err)
+	}
+	defer replacement.Close()
+
+	if err := old.Close(); err == nil {
+		t.Error("Detaching the replaced program should fail")
+	}
+}
+
 func TestLinkCgroup(t *testing.T) {
 	cgroup, prog := mustCgroupFixtures(t)
 
@@ -0,0 +1,124 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// Links is a set of links, indexed by the name of the attached program.
+type Links map[string]Link
+
+// Close closes all links.
+//
+// Returns the first error encountered, but closes all links regardless.
+func (ls Links) Close() error {
+	var firstErr error
+	for name, l := range ls {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close link of %s: %w", name, err)
+		}
+	}
+	return firstErr
+}
+
+// AttachCollection attaches all programs of coll whose ELF section name in
+// spec fully describes the hook to attach to, like libbpf's auto-attach.
+//
+// The following sections are supported:
+//
+//	kprobe/<symbol>
+//	kretprobe/<symbol>
+//	tracepoint/<group>/<name>, tp/<group>/<name>
+//	raw_tracepoint/<name>, raw_tp/<name>
+//	fentry/<function>, fexit/<function>, fmod_ret/<function>
+//	tp_btf/<name>
+//	lsm/<hook>
+//	iter/<kind>
+//
+// Programs in other sections are skipped, since their target (a network
+// interface, a cgroup, ...) isn't known. So are programs which haven't been
+// loaded into coll, for example because of LazyPrograms.
+//
+// If attaching any program fails, all links created so far are closed.
+func AttachCollection(spec *ebpf.CollectionSpec, coll *ebpf.Collection) (_ Links, err error) {
+	names := make([]string, 0, len(spec.Programs))
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	links := make(Links)
+	defer func() {
+		if err != nil {
+			links.Close()
+		}
+	}()
+
+	for _, name := range names {
+		prog := coll.Programs[name]
+		if prog == nil {
+			continue
+		}
+
+		l, err := attachSection(spec.Programs[name].SectionName, prog)
+		if errors.Is(err, errNoAutoAttach) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %w", name, err)
+		}
+
+		links[name] = l
+	}
+
+	return links, nil
+}
+
+var errNoAutoAttach = errors.New("section can't be attached automatically")
+
+// attachSection attaches prog to the hook described by the ELF section name
+// sec.
+//
+// Returns errNoAutoAttach if the section doesn't describe a hook.
+func attachSection(sec string, prog *ebpf.Program) (Link, error) {
+	parts := strings.SplitN(sec, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errNoAutoAttach
+	}
+	kind, target := parts[0], parts[1]
+
+	switch kind {
+	case "kprobe":
+		return Kprobe(target, prog, nil)
+
+	case "kretprobe":
+		return Kretprobe(target, prog, nil)
+
+	case "tracepoint", "tp":
+		tp := strings.SplitN(target, "/", 2)
+		if len(tp) != 2 {
+			return nil, fmt.Errorf("section %s: expected <group>/<name>: %w", sec, errInvalidInput)
+		}
+		return Tracepoint(tp[0], tp[1], prog, nil)
+
+	case "raw_tracepoint", "raw_tp":
+		return AttachRawTracepoint(RawTracepointOptions{Name: target, Program: prog})
+
+	case "fentry", "fexit", "fmod_ret", "tp_btf",
+		"fentry.s", "fexit.s", "fmod_ret.s":
+		// The target is resolved when the program is loaded.
+		return AttachTracing(TracingOptions{Program: prog})
+
+	case "lsm", "lsm.s":
+		return AttachLSM(LSMOptions{Program: prog})
+
+	case "iter", "iter.s":
+		return AttachIter(IterOptions{Program: prog})
+	}
+
+	return nil, errNoAutoAttach
+}
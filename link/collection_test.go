@@ -0,0 +1,84 @@
+package link
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAttachCollection(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.17", "BPF_RAW_TRACEPOINT API")
+
+	prog := func(typ ebpf.ProgramType, section string) *ebpf.ProgramSpec {
+		return &ebpf.ProgramSpec{
+			Type:        typ,
+			SectionName: section,
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		}
+	}
+
+	spec := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"raw_tp": prog(ebpf.RawTracepoint, "raw_tp/cgroup_mkdir"),
+			"filter": prog(ebpf.SocketFilter, "socket"),
+		},
+	}
+
+	coll, err := ebpf.NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	links, err := AttachCollection(spec, coll)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, links, qt.HasLen, 1)
+	qt.Assert(t, links["raw_tp"], qt.IsNotNil)
+	qt.Assert(t, links.Close(), qt.IsNil)
+
+	// A malformed section is an error, not a skipped program.
+	spec.Programs["filter"].SectionName = "tracepoint/foobazbar"
+	_, err = AttachCollection(spec, coll)
+	qt.Assert(t, err, qt.ErrorIs, errInvalidInput)
+}
+
+func TestAttachCollectionSleepable(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.15", "sleepable iterators")
+
+	spec := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"iter": {
+				Type:        ebpf.Tracing,
+				AttachType:  ebpf.AttachTraceIter,
+				AttachTo:    "task",
+				Flags:       unix.BPF_F_SLEEPABLE,
+				SectionName: "iter.s/task",
+				License:     "GPL",
+				Instructions: asm.Instructions{
+					asm.Mov.Imm(asm.R0, 0),
+					asm.Return(),
+				},
+			},
+		},
+	}
+
+	coll, err := ebpf.NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	links, err := AttachCollection(spec, coll)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, links, qt.HasLen, 1)
+	qt.Assert(t, links["iter"], qt.IsNotNil)
+	qt.Assert(t, links.Close(), qt.IsNil)
+}
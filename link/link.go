@@ -77,6 +77,8 @@ func wrapRawLink(raw *RawLink) (Link, error) {
 		return &Iter{*raw}, nil
 	case NetNsType:
 		return &NetNsLink{*raw}, nil
+	case StructOpsType:
+		return &StructOpsLink{*raw}, nil
 	default:
 		return raw, nil
 	}
@@ -292,11 +294,13 @@ func (l *RawLink) Info() (*Info, error) {
 		extra = &XDPInfo{}
 	case PerfEventType:
 		// no extra
+	case StructOpsType:
+		// not supported
 	default:
 		return nil, fmt.Errorf("unknown link info type: %d", info.Type)
 	}
 
-	if info.Type != RawTracepointType && info.Type != IterType && info.Type != PerfEventType {
+	if extra != nil {
 		buf := bytes.NewReader(info.Extra[:])
 		err := binary.Read(buf, internal.NativeEndian, extra)
 		if err != nil {
@@ -2,8 +2,11 @@ package link
 
 import (
 	"fmt"
+	"os"
+	"runtime"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal/unix"
 )
 
 // NetNsLink is a program attached to a network namespace.
@@ -34,3 +37,69 @@ func AttachNetNs(ns int, prog *ebpf.Program) (*NetNsLink, error) {
 
 	return &NetNsLink{*link}, nil
 }
+
+// InNetNs calls fn from within the network namespace referred to by ns.
+//
+// Interface indices and netlink sockets are resolved in the network
+// namespace of the calling thread. Use InNetNs to invoke AttachXDP,
+// AttachTC, QueryTC or DetachTC for an interface in another namespace,
+// without changing the namespace of the current thread. Links created by fn
+// remain valid after it returns.
+//
+// fn is executed on a dedicated OS thread. Goroutines started by fn don't
+// inherit the namespace.
+func InNetNs(ns int, fn func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		restored, err := inNetNs(ns, fn)
+		if restored {
+			runtime.UnlockOSThread()
+		}
+		// Otherwise the runtime discards the thread once the goroutine
+		// exits, since it is still locked.
+
+		errs <- err
+	}()
+
+	return <-errs
+}
+
+// InNetNsPath calls fn from within the network namespace at path, for
+// example /proc/<pid>/ns/net or a bind mount created by ip-netns(8).
+//
+// See InNetNs for details.
+func InNetNsPath(path string, fn func() error) error {
+	ns, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	return InNetNs(int(ns.Fd()), fn)
+}
+
+// inNetNs switches the current thread to ns, calls fn and switches back.
+//
+// Returns false if the thread is left in an unknown namespace. The caller
+// must have locked the goroutine to the thread.
+func inNetNs(ns int, fn func() error) (restored bool, err error) {
+	orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		return true, fmt.Errorf("open current network namespace: %w", err)
+	}
+	defer orig.Close()
+
+	if err := unix.Setns(ns, unix.CLONE_NEWNET); err != nil {
+		return true, fmt.Errorf("enter network namespace: %w", err)
+	}
+
+	defer func() {
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			restored = false
+		}
+	}()
+
+	return true, fn()
+}
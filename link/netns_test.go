@@ -1,12 +1,20 @@
+//go:build linux
+// +build linux
+
 package link
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"runtime"
+	"syscall"
 	"testing"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
 )
 
 func TestSkLookup(t *testing.T) {
@@ -66,3 +74,90 @@ func ExampleAttachNetNs() {
 	// The socket lookup program is now active until Close().
 	link.Close()
 }
+
+func TestInNetNs(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.9", "BPF_LINK_TYPE_XDP")
+
+	ns := createNetNs(t)
+	prog := mustLoadProgram(t, ebpf.XDP, 0, "")
+
+	nsInfo, err := ns.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		l      Link
+		inside os.FileInfo
+	)
+	err = InNetNs(int(ns.Fd()), func() error {
+		var err error
+		inside, err = os.Stat(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			return err
+		}
+
+		l, err = AttachXDP(XDPOptions{Program: prog, Interface: IfIndexLO})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if !os.SameFile(inside, nsInfo) {
+		t.Error("fn wasn't called in the target namespace")
+	}
+
+	// lo in the current namespace must still be free.
+	l2, err := AttachXDP(XDPOptions{Program: prog, Interface: IfIndexLO})
+	if err != nil {
+		t.Fatal("Can't attach to lo in the current namespace:", err)
+	}
+	l2.Close()
+
+	errSentinel := errors.New("sentinel")
+	// The thread which created ns may have exited, refer to it via the fd.
+	path := fmt.Sprintf("/proc/self/fd/%d", ns.Fd())
+	err = InNetNsPath(path, func() error { return errSentinel })
+	if !errors.Is(err, errSentinel) {
+		t.Fatal("Error returned by fn is not propagated:", err)
+	}
+}
+
+// createNetNs creates a new network namespace which exists as long as the
+// returned file is open.
+func createNetNs(tb testing.TB) *os.File {
+	tb.Helper()
+
+	type result struct {
+		ns  *os.File
+		err error
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		// The thread is never unlocked, so the runtime discards it
+		// instead of reusing it in the new namespace.
+		runtime.LockOSThread()
+
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			results <- result{nil, err}
+			return
+		}
+
+		ns, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		results <- result{ns, err}
+	}()
+
+	res := <-results
+	if errors.Is(res.err, unix.EPERM) {
+		tb.Skip("Can't create network namespace:", res.err)
+	}
+	if res.err != nil {
+		tb.Fatal(res.err)
+	}
+	tb.Cleanup(func() { res.ns.Close() })
+
+	return res.ns
+}
@@ -9,29 +9,39 @@ import (
 
 // AttachSocketFilter attaches a SocketFilter BPF program to a socket.
 func AttachSocketFilter(conn syscall.Conn, program *ebpf.Program) error {
-	rawConn, err := conn.SyscallConn()
-	if err != nil {
-		return err
-	}
-	var ssoErr error
-	err = rawConn.Control(func(fd uintptr) {
-		ssoErr = syscall.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_BPF, program.FD())
+	return controlSocket(conn, func(fd int) error {
+		return AttachSocketFilterFD(fd, program)
 	})
-	if ssoErr != nil {
-		return ssoErr
-	}
-	return err
 }
 
 // DetachSocketFilter detaches a SocketFilter BPF program from a socket.
 func DetachSocketFilter(conn syscall.Conn) error {
+	return controlSocket(conn, DetachSocketFilterFD)
+}
+
+// AttachSocketFilterFD attaches a SocketFilter BPF program to a socket
+// file descriptor.
+//
+// An existing filter is replaced. The socket holds a reference to the
+// program, so program may be closed afterwards.
+func AttachSocketFilterFD(fd int, program *ebpf.Program) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ATTACH_BPF, program.FD())
+}
+
+// DetachSocketFilterFD detaches a SocketFilter BPF program from a socket
+// file descriptor.
+func DetachSocketFilterFD(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DETACH_BPF, 0)
+}
+
+func controlSocket(conn syscall.Conn, fn func(fd int) error) error {
 	rawConn, err := conn.SyscallConn()
 	if err != nil {
 		return err
 	}
 	var ssoErr error
 	err = rawConn.Control(func(fd uintptr) {
-		ssoErr = syscall.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DETACH_BPF, 0)
+		ssoErr = fn(int(fd))
 	})
 	if ssoErr != nil {
 		return ssoErr
@@ -2,6 +2,7 @@ package link
 
 import (
 	"net"
+	"syscall"
 	"testing"
 
 	"github.com/cilium/ebpf"
@@ -26,3 +27,21 @@ func TestSocketFilterAttach(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSocketFilterAttachFD(t *testing.T) {
+	prog := mustLoadProgram(t, ebpf.SocketFilter, 0, "")
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	if err := AttachSocketFilterFD(fd, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DetachSocketFilterFD(fd); err != nil {
+		t.Fatal(err)
+	}
+}
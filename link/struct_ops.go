@@ -0,0 +1,82 @@
+package link
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+type StructOpsOptions struct {
+	// Map must be of type StructOpsMap and created with BPF_F_LINK, which is
+	// the case for maps from a .struct_ops.link ELF section. Its value must
+	// already be set.
+	Map *ebpf.Map
+}
+
+// StructOpsLink registers a struct_ops map with the kernel.
+type StructOpsLink struct {
+	RawLink
+}
+
+// AttachStructOps registers the struct_ops in a map with the kernel, for
+// example to make a TCP congestion control algorithm available.
+//
+// The registration is undone when the link is closed. Maps created without
+// BPF_F_LINK are registered as soon as their value is set instead, and are
+// unregistered by deleting that value.
+func AttachStructOps(opts StructOpsOptions) (*StructOpsLink, error) {
+	if t := opts.Map.Type(); t != ebpf.StructOpsMap {
+		return nil, fmt.Errorf("invalid map type %s, expected StructOpsMap", t)
+	}
+
+	if opts.Map.Flags()&unix.BPF_F_LINK == 0 {
+		return nil, fmt.Errorf("map %s must be created with BPF_F_LINK", opts.Map)
+	}
+
+	if err := haveBPFLink(); err != nil {
+		return nil, err
+	}
+
+	mapFd := opts.Map.FD()
+	if mapFd < 0 {
+		return nil, fmt.Errorf("invalid map: %w", sys.ErrClosedFd)
+	}
+
+	fd, err := sys.LinkCreate(&sys.LinkCreateAttr{
+		ProgFd:     uint32(mapFd),
+		AttachType: sys.AttachType(ebpf.AttachStructOps),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create struct_ops link: %w", err)
+	}
+
+	return &StructOpsLink{RawLink{fd, ""}}, nil
+}
+
+// Update implements the Link interface.
+//
+// struct_ops links refer to maps instead of programs, use UpdateMap.
+func (l *StructOpsLink) Update(*ebpf.Program) error {
+	return fmt.Errorf("can't update struct_ops link with a program: %w", ErrNotSupported)
+}
+
+// UpdateMap atomically replaces the registered struct_ops with the one in
+// new, which must implement the same struct_ops type.
+func (l *StructOpsLink) UpdateMap(new *ebpf.Map) error {
+	newFd := new.FD()
+	if newFd < 0 {
+		return fmt.Errorf("invalid map: %w", sys.ErrClosedFd)
+	}
+
+	attr := sys.LinkUpdateAttr{
+		LinkFd:    l.fd.Uint(),
+		NewProgFd: uint32(newFd),
+	}
+	if err := sys.LinkUpdate(&attr); err != nil {
+		return fmt.Errorf("update struct_ops link: %w", err)
+	}
+
+	return nil
+}
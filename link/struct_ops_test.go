@@ -0,0 +1,142 @@
+package link
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// mustLoadStructOps loads a minimal TCP congestion control algorithm with the
+// given name.
+func mustLoadStructOps(t *testing.T, name string, flags uint32) *ebpf.Map {
+	t.Helper()
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	fn := &btf.Pointer{Target: &btf.FuncProto{Return: u32}}
+	ops := &btf.Struct{
+		Name: "tcp_congestion_ops",
+		Size: 40,
+		Members: []btf.Member{
+			{Name: "ssthresh", Type: fn},
+			{Name: "undo_cwnd", Type: fn, Offset: 8 * 8},
+			{Name: "cong_avoid", Type: fn, Offset: 16 * 8},
+			{Name: "name", Type: &btf.Array{Index: u32, Type: char, Nelems: 16}, Offset: 24 * 8},
+		},
+	}
+
+	types, err := btf.NewSpecFromTypes(ops)
+	qt.Assert(t, err, qt.IsNil)
+
+	data := make([]byte, ops.Size)
+	copy(data[24:], name)
+
+	progs := make(map[string]*ebpf.ProgramSpec)
+	members := make(map[string]string)
+	for _, member := range []string{"ssthresh", "undo_cwnd", "cong_avoid"} {
+		progs[member] = &ebpf.ProgramSpec{
+			Type:     ebpf.StructOps,
+			AttachTo: "tcp_congestion_ops:" + member,
+			License:  "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 2),
+				asm.Return(),
+			},
+		}
+		members[member] = member
+	}
+
+	coll, err := ebpf.NewCollection(&ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			"ops": {
+				Type:       ebpf.StructOpsMap,
+				KeySize:    4,
+				ValueSize:  ops.Size,
+				MaxEntries: 1,
+				Flags:      flags,
+				BTF:        types,
+				Value:      ops,
+				Contents:   []ebpf.MapKV{{Key: uint32(0), Value: ebpf.StructOpsValue{Data: data, Programs: members}}},
+			},
+		},
+		Programs: progs,
+		Types:    types,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+
+	m := coll.DetachMap("ops")
+	coll.Close()
+	t.Cleanup(func() { m.Close() })
+
+	return m
+}
+
+func haveCongestionControl(t *testing.T, name string) bool {
+	t.Helper()
+
+	contents, err := os.ReadFile("/proc/sys/net/ipv4/tcp_available_congestion_control")
+	if os.IsNotExist(err) {
+		t.Skip("TCP congestion control is not available")
+	}
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, field := range strings.Fields(string(contents)) {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStructOps(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "6.4", "struct_ops link")
+
+	m := mustLoadStructOps(t, "ebpf_go_link", unix.BPF_F_LINK)
+	qt.Assert(t, haveCongestionControl(t, "ebpf_go_link"), qt.IsFalse)
+
+	l, err := AttachStructOps(StructOpsOptions{Map: m})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, haveCongestionControl(t, "ebpf_go_link"), qt.IsTrue)
+
+	info, err := l.Info()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, info.Type, qt.Equals, StructOpsType)
+
+	qt.Assert(t, l.Close(), qt.IsNil)
+	qt.Assert(t, haveCongestionControl(t, "ebpf_go_link"), qt.IsFalse)
+}
+
+func TestStructOpsUpdateMap(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "6.4", "struct_ops link")
+
+	m := mustLoadStructOps(t, "ebpf_go_old", unix.BPF_F_LINK)
+	l, err := AttachStructOps(StructOpsOptions{Map: m})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer l.Close()
+
+	// The name of a registered algorithm can't change.
+	m2 := mustLoadStructOps(t, "ebpf_go_old", unix.BPF_F_LINK)
+	qt.Assert(t, l.UpdateMap(m2), qt.IsNil)
+	qt.Assert(t, haveCongestionControl(t, "ebpf_go_old"), qt.IsTrue)
+}
+
+func TestStructOpsWithoutLinkFlag(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.6", "struct_ops")
+
+	m := mustLoadStructOps(t, "ebpf_go_nolink", 0)
+	defer m.Delete(uint32(0))
+
+	_, err := AttachStructOps(StructOpsOptions{Map: m})
+	qt.Assert(t, err, qt.IsNotNil)
+}
@@ -23,6 +23,7 @@ const (
 	NetNsType         = sys.BPF_LINK_TYPE_NETNS
 	XDPType           = sys.BPF_LINK_TYPE_XDP
 	PerfEventType     = sys.BPF_LINK_TYPE_PERF_EVENT
+	StructOpsType     = sys.BPF_LINK_TYPE_STRUCT_OPS
 )
 
 var haveProgAttach = internal.FeatureTest("BPF_PROG_ATTACH", "4.10", func() error {
@@ -72,7 +73,7 @@ var haveProgAttachReplace = internal.FeatureTest("BPF_PROG_ATTACH atomic replace
 		TargetFd:    ^uint32(0),
 		AttachBpfFd: uint32(prog.FD()),
 		AttachType:  uint32(ebpf.AttachCGroupInetIngress),
-		AttachFlags: uint32(flagReplace),
+		AttachFlags: uint32(CgroupReplace),
 	}
 
 	err = sys.ProgAttach(&attr)
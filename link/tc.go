@@ -0,0 +1,327 @@
+package link
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/netlink"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// TCParent identifies the hook of a clsact qdisc a filter is attached to.
+type TCParent uint32
+
+const (
+	// TCIngress attaches to the ingress hook of the clsact qdisc.
+	TCIngress TCParent = 0xfffffff2
+	// TCEgress attaches to the egress hook of the clsact qdisc.
+	TCEgress TCParent = 0xfffffff3
+)
+
+func (p TCParent) String() string {
+	switch p {
+	case TCIngress:
+		return "ingress"
+	case TCEgress:
+		return "egress"
+	default:
+		return fmt.Sprintf("TCParent(%#x)", uint32(p))
+	}
+}
+
+// Constants from linux/pkt_sched.h, linux/rtnetlink.h and linux/pkt_cls.h.
+const (
+	tcHandleClsact = 0xffff0000
+	tcParentClsact = 0xfffffff1
+
+	tcaKind    = 1
+	tcaOptions = 2
+	tcaChain   = 11
+
+	tcaBPFFD    = 6
+	tcaBPFName  = 7
+	tcaBPFFlags = 8
+	tcaBPFID    = 11
+
+	tcaBPFFlagActDirect = 1
+
+	tcMsgLen = 20
+)
+
+// TCOptions control how a program is attached as a cls_bpf filter.
+type TCOptions struct {
+	// Program must be a SchedCLS BPF program.
+	Program *ebpf.Program
+
+	// Interface is the interface index to attach program to.
+	Interface int
+
+	// Parent is the clsact hook to attach to.
+	Parent TCParent
+
+	// Priority of the filter. Filters with a lower value run first.
+	// The kernel picks a priority if zero, which makes the order of
+	// filters installed by different users unpredictable.
+	Priority uint16
+
+	// Handle of the filter. The kernel picks a handle if zero.
+	Handle uint32
+
+	// Chain is the filter chain to attach to.
+	Chain uint32
+
+	// Replace an existing filter with the same priority and handle
+	// instead of returning an error.
+	Replace bool
+}
+
+// TCFilter is a cls_bpf filter attached to an interface.
+//
+// The program runs in direct action mode.
+type TCFilter struct {
+	Interface    int
+	Parent       TCParent
+	Priority     uint16
+	Handle       uint32
+	Chain        uint32
+	Name         string
+	ProgramID    ebpf.ProgramID
+	DirectAction bool
+}
+
+// AttachTC attaches a SchedCLS program as a cls_bpf filter.
+//
+// A clsact qdisc is created on the interface if necessary. The filter
+// remains attached until it is removed using DetachTC, even if the program
+// is closed.
+func AttachTC(opts TCOptions) (*TCFilter, error) {
+	if opts.Program == nil {
+		return nil, fmt.Errorf("program is nil: %w", errInvalidInput)
+	}
+
+	if t := opts.Program.Type(); t != ebpf.SchedCLS {
+		return nil, fmt.Errorf("invalid program type %s, expected SchedCLS", t)
+	}
+
+	if opts.Interface < 1 {
+		return nil, fmt.Errorf("invalid interface index: %d", opts.Interface)
+	}
+
+	if opts.Parent != TCIngress && opts.Parent != TCEgress {
+		return nil, fmt.Errorf("invalid parent %s", opts.Parent)
+	}
+
+	info, err := opts.Program.Info()
+	if err != nil {
+		return nil, fmt.Errorf("get program info: %w", err)
+	}
+
+	conn, err := netlink.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := createClsact(conn, opts.Interface); err != nil {
+		return nil, err
+	}
+
+	var attrs netlink.AttributeEncoder
+	attrs.String(tcaKind, "bpf")
+	if opts.Chain != 0 {
+		attrs.Uint32(tcaChain, opts.Chain)
+	}
+	attrs.Nested(tcaOptions, func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(tcaBPFFD, uint32(opts.Program.FD()))
+		ae.String(tcaBPFName, info.Name)
+		ae.Uint32(tcaBPFFlags, tcaBPFFlagActDirect)
+	})
+
+	flags := uint16(unix.NLM_F_ACK | unix.NLM_F_ECHO | unix.NLM_F_CREATE)
+	if opts.Replace {
+		flags |= unix.NLM_F_REPLACE
+	} else {
+		flags |= unix.NLM_F_EXCL
+	}
+
+	filter := TCFilter{
+		Interface: opts.Interface,
+		Parent:    opts.Parent,
+		Priority:  opts.Priority,
+		Handle:    opts.Handle,
+		Chain:     opts.Chain,
+	}
+
+	msgs, err := conn.Execute(unix.RTM_NEWTFILTER, flags, filter.marshal(attrs.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("create filter: %w", err)
+	}
+
+	for _, msg := range msgs {
+		if msg.Type != unix.RTM_NEWTFILTER {
+			continue
+		}
+
+		echo, err := unmarshalTCFilter(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		if echo != nil {
+			return echo, nil
+		}
+	}
+
+	return nil, errors.New("kernel didn't echo created filter")
+}
+
+// DetachTC removes a filter returned by AttachTC or QueryTC.
+func DetachTC(filter TCFilter) error {
+	if filter.Priority == 0 || filter.Handle == 0 {
+		return fmt.Errorf("filter priority and handle must be set")
+	}
+
+	conn, err := netlink.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var attrs netlink.AttributeEncoder
+	attrs.String(tcaKind, "bpf")
+	if filter.Chain != 0 {
+		attrs.Uint32(tcaChain, filter.Chain)
+	}
+
+	if _, err := conn.Execute(unix.RTM_DELTFILTER, unix.NLM_F_ACK, filter.marshal(attrs.Bytes())); err != nil {
+		return fmt.Errorf("delete filter: %w", err)
+	}
+
+	return nil
+}
+
+// QueryTC lists the cls_bpf filters attached to a clsact hook, across all
+// chains.
+//
+// Within a chain, filters are ordered by priority.
+func QueryTC(ifindex int, parent TCParent) ([]TCFilter, error) {
+	if ifindex < 1 {
+		return nil, fmt.Errorf("invalid interface index: %d", ifindex)
+	}
+
+	conn, err := netlink.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := TCFilter{Interface: ifindex, Parent: parent}
+	msgs, err := conn.Execute(unix.RTM_GETTFILTER, unix.NLM_F_DUMP, req.marshal(nil))
+	if errors.Is(err, unix.EINVAL) {
+		// There is no clsact qdisc on the interface.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dump filters: %w", err)
+	}
+
+	var filters []TCFilter
+	for _, msg := range msgs {
+		if msg.Type != unix.RTM_NEWTFILTER {
+			continue
+		}
+
+		filter, err := unmarshalTCFilter(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil {
+			filters = append(filters, *filter)
+		}
+	}
+
+	return filters, nil
+}
+
+// marshal encodes a struct tcmsg followed by attrs.
+func (f *TCFilter) marshal(attrs []byte) []byte {
+	// The protocol is stored in network byte order.
+	var proto [2]byte
+	binary.BigEndian.PutUint16(proto[:], unix.ETH_P_ALL)
+	info := uint32(f.Priority)<<16 | uint32(internal.NativeEndian.Uint16(proto[:]))
+
+	msg := make([]byte, tcMsgLen, tcMsgLen+len(attrs))
+	internal.NativeEndian.PutUint32(msg[4:], uint32(f.Interface))
+	internal.NativeEndian.PutUint32(msg[8:], f.Handle)
+	internal.NativeEndian.PutUint32(msg[12:], uint32(f.Parent))
+	internal.NativeEndian.PutUint32(msg[16:], info)
+	return append(msg, attrs...)
+}
+
+// unmarshalTCFilter decodes a filter from a netlink message.
+//
+// Returns nil if the message doesn't describe a cls_bpf filter instance.
+func unmarshalTCFilter(b []byte) (*TCFilter, error) {
+	if len(b) < tcMsgLen {
+		return nil, errors.New("filter message is too short")
+	}
+
+	filter := TCFilter{
+		Interface: int(int32(internal.NativeEndian.Uint32(b[4:]))),
+		Handle:    internal.NativeEndian.Uint32(b[8:]),
+		Parent:    TCParent(internal.NativeEndian.Uint32(b[12:])),
+		Priority:  uint16(internal.NativeEndian.Uint32(b[16:]) >> 16),
+	}
+
+	// The kernel also reports an entry without a handle for each
+	// priority, which doesn't correspond to a filter.
+	if filter.Handle == 0 {
+		return nil, nil
+	}
+
+	attrs, err := netlink.ParseAttributes(b[tcMsgLen:])
+	if err != nil {
+		return nil, fmt.Errorf("filter attributes: %w", err)
+	}
+
+	if kind, _ := attrs.String(tcaKind); kind != "bpf" {
+		return nil, nil
+	}
+
+	filter.Chain, _ = attrs.Uint32(tcaChain)
+
+	opts, err := netlink.ParseAttributes(attrs[tcaOptions])
+	if err != nil {
+		return nil, fmt.Errorf("cls_bpf attributes: %w", err)
+	}
+
+	filter.Name, _ = opts.String(tcaBPFName)
+	id, _ := opts.Uint32(tcaBPFID)
+	filter.ProgramID = ebpf.ProgramID(id)
+	flags, _ := opts.Uint32(tcaBPFFlags)
+	filter.DirectAction = flags&tcaBPFFlagActDirect != 0
+
+	return &filter, nil
+}
+
+// createClsact adds a clsact qdisc to an interface, unless it already
+// exists.
+func createClsact(conn *netlink.Conn, ifindex int) error {
+	var attrs netlink.AttributeEncoder
+	attrs.String(tcaKind, "clsact")
+
+	msg := make([]byte, tcMsgLen, tcMsgLen+len(attrs.Bytes()))
+	internal.NativeEndian.PutUint32(msg[4:], uint32(ifindex))
+	internal.NativeEndian.PutUint32(msg[8:], tcHandleClsact)
+	internal.NativeEndian.PutUint32(msg[12:], tcParentClsact)
+	msg = append(msg, attrs.Bytes()...)
+
+	_, err := conn.Execute(unix.RTM_NEWQDISC, unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("create clsact qdisc: %w", err)
+	}
+
+	return nil
+}
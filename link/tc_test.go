@@ -0,0 +1,122 @@
+package link
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+func TestAttachTCNilProgram(t *testing.T) {
+	_, err := AttachTC(TCOptions{Interface: IfIndexLO, Parent: TCIngress})
+	if !errors.Is(err, errInvalidInput) {
+		t.Fatal("Expected errInvalidInput for a nil program, got", err)
+	}
+}
+
+func TestAttachTC(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.5", "clsact qdisc")
+
+	prog := mustLoadProgram(t, ebpf.SchedCLS, 0, "")
+
+	info, err := prog.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := info.ID()
+
+	attach := func(prio uint16, handle, chain uint32) *TCFilter {
+		t.Helper()
+
+		filter, err := AttachTC(TCOptions{
+			Program:   prog,
+			Interface: IfIndexLO,
+			Parent:    TCIngress,
+			Priority:  prio,
+			Handle:    handle,
+			Chain:     chain,
+		})
+		if err != nil {
+			t.Fatal("Can't attach filter:", err)
+		}
+		t.Cleanup(func() { DetachTC(*filter) })
+
+		if filter.Priority != prio || filter.Handle != handle || filter.Chain != chain {
+			t.Fatalf("Unexpected filter %+v", filter)
+		}
+		if filter.ProgramID != id {
+			t.Errorf("Expected program ID %d, got %d", id, filter.ProgramID)
+		}
+		if !filter.DirectAction {
+			t.Error("Filter doesn't use direct action mode")
+		}
+
+		return filter
+	}
+
+	first := attach(10, 1, 0)
+	attach(20, 1, 0)
+	attach(10, 1, 1)
+
+	_, err = AttachTC(TCOptions{
+		Program:   prog,
+		Interface: IfIndexLO,
+		Parent:    TCIngress,
+		Priority:  10,
+		Handle:    1,
+	})
+	if !errors.Is(err, unix.EEXIST) {
+		t.Fatal("Attaching a duplicate filter should return EEXIST, got", err)
+	}
+
+	_, err = AttachTC(TCOptions{
+		Program:   prog,
+		Interface: IfIndexLO,
+		Parent:    TCIngress,
+		Priority:  10,
+		Handle:    1,
+		Replace:   true,
+	})
+	if err != nil {
+		t.Fatal("Can't replace filter:", err)
+	}
+
+	filters, err := QueryTC(IfIndexLO, TCIngress)
+	if err != nil {
+		t.Fatal("Can't query filters:", err)
+	}
+
+	if len(filters) != 3 {
+		t.Fatalf("Expected 3 filters, got %d", len(filters))
+	}
+
+	for _, filter := range filters {
+		if filter.ProgramID != id {
+			t.Errorf("Expected program ID %d, got %d", id, filter.ProgramID)
+		}
+	}
+
+	if err := DetachTC(*first); err != nil {
+		t.Fatal("Can't detach filter:", err)
+	}
+
+	filters, err = QueryTC(IfIndexLO, TCIngress)
+	if err != nil {
+		t.Fatal("Can't query filters:", err)
+	}
+
+	if len(filters) != 2 {
+		t.Fatalf("Expected 2 filters after detach, got %d", len(filters))
+	}
+
+	filters, err = QueryTC(IfIndexLO, TCEgress)
+	if err != nil {
+		t.Fatal("Can't query filters:", err)
+	}
+
+	if len(filters) != 0 {
+		t.Fatalf("Expected no egress filters, got %d", len(filters))
+	}
+}
@@ -1,11 +1,15 @@
 package link
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
 	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
 )
 
 type tracing struct {
@@ -43,7 +47,7 @@ func AttachFreplace(targetProg *ebpf.Program, name string, prog *ebpf.Program) (
 	if targetProg != nil {
 		info, err := targetProg.Info()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("target info: %w", err)
 		}
 		btfID, ok := info.BTFID()
 		if !ok {
@@ -51,19 +55,19 @@ func AttachFreplace(targetProg *ebpf.Program, name string, prog *ebpf.Program) (
 		}
 		btfHandle, err := btf.NewHandleFromID(btfID)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("target BTF: %w", err)
 		}
 		defer btfHandle.Close()
 
 		var function *btf.Func
 		if err := btfHandle.Spec().TypeByName(name, &function); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("find function %s in target: %w", name, err)
 		}
 
 		target = targetProg.FD()
 		typeID, err = btfHandle.Spec().TypeID(function)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("find function %s in target: %w", name, err)
 		}
 	}
 
@@ -85,23 +89,55 @@ type TracingOptions struct {
 	// AttachTraceFEntry/AttachTraceFExit/AttachModifyReturn or
 	// AttachTraceRawTp.
 	Program *ebpf.Program
+	// The attach type the program was loaded with. Only required when
+	// Cookie is set.
+	AttachType ebpf.AttachType
+	// Arbitrary value that can be fetched from an eBPF program
+	// via `bpf_get_attach_cookie()`.
+	//
+	// Needs kernel 5.19+.
+	Cookie uint64
 }
 
 type LSMOptions struct {
 	// Program must be of type LSM with attach type
 	// AttachLSMMac.
 	Program *ebpf.Program
+	// Arbitrary value that can be fetched from an eBPF program
+	// via `bpf_get_attach_cookie()`.
+	//
+	// Needs kernel 5.19+.
+	Cookie uint64
 }
 
 // attachBTFID links all BPF program types (Tracing/LSM) that they attach to a btf_id.
-func attachBTFID(program *ebpf.Program) (Link, error) {
+//
+// Uses BPF_LINK_CREATE if a cookie is given, since BPF_RAW_TRACEPOINT_OPEN
+// doesn't accept one.
+func attachBTFID(program *ebpf.Program, attachType ebpf.AttachType, cookie uint64) (Link, error) {
 	if program.FD() < 0 {
 		return nil, fmt.Errorf("invalid program %w", sys.ErrClosedFd)
 	}
 
-	fd, err := sys.RawTracepointOpen(&sys.RawTracepointOpenAttr{
-		ProgFd: uint32(program.FD()),
-	})
+	var (
+		fd  *sys.FD
+		err error
+	)
+	if cookie == 0 {
+		fd, err = sys.RawTracepointOpen(&sys.RawTracepointOpenAttr{
+			ProgFd: uint32(program.FD()),
+		})
+	} else {
+		if err := haveTracingLinkCookie(); err != nil {
+			return nil, err
+		}
+
+		fd, err = sys.LinkCreateTracing(&sys.LinkCreateTracingAttr{
+			ProgFd:     uint32(program.FD()),
+			AttachType: sys.AttachType(attachType),
+			Cookie:     cookie,
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +158,35 @@ func attachBTFID(program *ebpf.Program) (Link, error) {
 	return &tracing{RawLink: RawLink{fd: fd}}, nil
 }
 
+// Probe cookies for tracing links.
+//
+// Older kernels silently ignore the cookie passed to BPF_LINK_CREATE, but
+// bpf_get_attach_cookie is only available to tracing programs since cookies
+// are supported.
+//
+// https://github.com/torvalds/linux/commit/2fcc82411e74e5e6aba336561cf56fb899bfae4e
+var haveTracingLinkCookie = internal.FeatureTest("tracing link cookie", "5.19", func() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:       "probe_tracing_cookie",
+		Type:       ebpf.Tracing,
+		AttachType: ebpf.AttachTraceFEntry,
+		AttachTo:   "vfs_read",
+		Instructions: asm.Instructions{
+			asm.FnGetAttachCookie.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	if errors.Is(err, unix.EINVAL) {
+		return internal.ErrNotSupported
+	}
+	if err != nil {
+		return err
+	}
+	return prog.Close()
+})
+
 // AttachTracing links a tracing (fentry/fexit/fmod_ret) BPF program or
 // a BTF-powered raw tracepoint (tp_btf) BPF Program to a BPF hook defined
 // in kernel modules.
@@ -129,8 +194,11 @@ func AttachTracing(opts TracingOptions) (Link, error) {
 	if t := opts.Program.Type(); t != ebpf.Tracing {
 		return nil, fmt.Errorf("invalid program type %s, expected Tracing", t)
 	}
+	if opts.Cookie != 0 && opts.AttachType == ebpf.AttachNone {
+		return nil, fmt.Errorf("attach type is required with a cookie: %w", errInvalidInput)
+	}
 
-	return attachBTFID(opts.Program)
+	return attachBTFID(opts.Program, opts.AttachType, opts.Cookie)
 }
 
 // AttachLSM links a Linux security module (LSM) BPF Program to a BPF
@@ -140,5 +208,5 @@ func AttachLSM(opts LSMOptions) (Link, error) {
 		return nil, fmt.Errorf("invalid program type %s, expected LSM", t)
 	}
 
-	return attachBTFID(opts.Program)
+	return attachBTFID(opts.Program, ebpf.AttachLSMMac, opts.Cookie)
 }
@@ -28,6 +28,14 @@ func TestFreplace(t *testing.T) {
 		}
 		defer target.Close()
 
+		noName := spec.Programs["replacement"].Copy()
+		noName.AttachTarget = target
+		noName.AttachTo = ""
+		if prog, err := ebpf.NewProgram(noName); err == nil {
+			prog.Close()
+			t.Error("Loading an extension without a function name should fail")
+		}
+
 		// Test attachment specified at load time
 		spec.Programs["replacement"].AttachTarget = target
 		replacement, err := ebpf.NewProgram(spec.Programs["replacement"])
@@ -94,6 +102,31 @@ func TestTracing(t *testing.T) {
 	}
 }
 
+func TestTracingCookie(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.19", "cookie for tracing links")
+
+	prog := mustLoadProgram(t, ebpf.Tracing, ebpf.AttachTraceFEntry, "inet_dgram_connect")
+
+	if _, err := AttachTracing(TracingOptions{Program: prog, Cookie: 1000}); err == nil {
+		t.Fatal("Expected an error without an attach type")
+	}
+
+	link, err := AttachTracing(TracingOptions{
+		Program:    prog,
+		AttachType: ebpf.AttachTraceFEntry,
+		Cookie:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLink(t, link, prog)
+}
+
+func TestHaveTracingLinkCookie(t *testing.T) {
+	testutils.CheckFeatureTest(t, haveTracingLinkCookie)
+}
+
 func TestLSM(t *testing.T) {
 	testutils.SkipOnOldKernel(t, "5.11", "BPF_LINK_TYPE_TRACING")
 
@@ -3,10 +3,14 @@ package ebpf
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/kallsyms"
 )
 
 // splitSymbols splits insns into subsections delimited by Symbol Instructions.
@@ -84,7 +88,9 @@ func applyRelocations(insns asm.Instructions, local, target *btf.Spec) error {
 
 	target, err := maybeLoadKernelBTF(target)
 	if err != nil {
-		return err
+		// Kernels without /sys/kernel/btf/vmlinux still support CO-RE if
+		// the caller supplies BTF, for example from BTFHub.
+		return fmt.Errorf("load kernel BTF (consider setting ProgramOptions.KernelTypes): %w", err)
 	}
 
 	fixups, err := btf.CORERelocate(local, target, relos)
@@ -141,8 +147,10 @@ func flattenInstructions(name string, progs map[string]*ProgramSpec, refs map[*P
 	pending := make([]string, len(refs[prog]))
 	copy(pending, refs[prog])
 
-	// All references for which we've appended instructions.
-	linked := make(map[string]bool)
+	// All references for which we've appended instructions. The program
+	// itself is already part of insns, which matters if a subprogram calls
+	// back into it.
+	linked := map[string]bool{name: true}
 
 	// Iterate all pending references. We can't use a range since pending is
 	// modified in the body below.
@@ -172,25 +180,232 @@ func flattenInstructions(name string, progs map[string]*ProgramSpec, refs map[*P
 	return insns
 }
 
+type ksymMetaKey struct{}
+
+// ksymMeta describes an instruction which references a kernel symbol.
+type ksymMeta struct {
+	// Either a *btf.Func for kernel functions or a *btf.Var for variables.
+	Type btf.Type
+	// The symbol may be absent from the kernel.
+	Weak bool
+}
+
+// weakCallPoison is the helper ID that calls to missing weak kfuncs and
+// functions are rewritten to. The verifier only rejects the invalid call if
+// it is reachable.
+const weakCallPoison = 2002000000
+
+// weakMetaKey marks a reference to an undefined function with weak binding.
+type weakMetaKey struct{}
+
+// fixupKsyms resolves references to kernel symbols.
+//
+// Calls to kernel functions and loads of typed variables are resolved to IDs
+// in the kernel's BTF. Loads of untyped variables are resolved to addresses
+// from /proc/kallsyms. References to missing weak symbols become zero
+// constants or calls which the verifier rejects if they are reachable.
+func fixupKsyms(insns asm.Instructions, kernelTypes *btf.Spec) error {
+	var (
+		metas   []*ksymMeta
+		ksyms   []*asm.Instruction
+		symbols map[string]uint64
+	)
+
+	iter := insns.Iterate()
+	for iter.Next() {
+		meta, _ := iter.Ins.Metadata.Get(ksymMetaKey{}).(*ksymMeta)
+		if meta == nil {
+			continue
+		}
+
+		if v, ok := meta.Type.(*btf.Var); ok && isVoid(v.Type) {
+			if symbols == nil {
+				symbols = make(map[string]uint64)
+			}
+			symbols[v.Name] = 0
+		}
+
+		metas = append(metas, meta)
+		ksyms = append(ksyms, iter.Ins)
+	}
+
+	if len(ksyms) == 0 {
+		return nil
+	}
+
+	if err := kallsyms.AssignAddresses(symbols); err != nil {
+		return fmt.Errorf("resolve ksyms: %w", err)
+	}
+
+	for i, ins := range ksyms {
+		meta := metas[i]
+		name := meta.Type.TypeName()
+
+		switch typ := meta.Type.(type) {
+		case *btf.Func:
+			spec, err := maybeLoadKernelBTF(kernelTypes)
+			if err != nil {
+				return fmt.Errorf("kfunc %s: %w", name, err)
+			}
+
+			var fn *btf.Func
+			err = spec.TypeByName(name, &fn)
+			if errors.Is(err, btf.ErrNotFound) && meta.Weak {
+				ins.Src = asm.R0
+				ins.Constant = weakCallPoison
+				ins.Offset = 0
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("kfunc %s: %w", name, err)
+			}
+
+			id, err := spec.TypeID(fn)
+			if err != nil {
+				return fmt.Errorf("kfunc %s: %w", name, err)
+			}
+
+			ins.Src = asm.PseudoKfuncCall
+			ins.Constant = int64(id)
+			ins.Offset = 0
+
+		case *btf.Var:
+			if isVoid(typ.Type) {
+				addr := symbols[name]
+				if addr == 0 && !meta.Weak {
+					return fmt.Errorf("ksym %s: address not found or hidden by kernel.kptr_restrict", name)
+				}
+
+				ins.Src = asm.R0
+				ins.Constant = int64(addr)
+				continue
+			}
+
+			spec, err := maybeLoadKernelBTF(kernelTypes)
+			if err != nil {
+				return fmt.Errorf("ksym %s: %w", name, err)
+			}
+
+			var v *btf.Var
+			err = spec.TypeByName(name, &v)
+			if errors.Is(err, btf.ErrNotFound) && meta.Weak {
+				ins.Src = asm.R0
+				ins.Constant = 0
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("ksym %s: %w", name, err)
+			}
+
+			id, err := spec.TypeID(v)
+			if err != nil {
+				return fmt.Errorf("ksym %s: %w", name, err)
+			}
+
+			// The upper 32 bits contain the fd of the BTF object, which is
+			// zero for vmlinux.
+			ins.Src = asm.PseudoBTFID
+			ins.Constant = int64(id)
+
+		default:
+			return fmt.Errorf("ksym %s: unsupported type %T", name, typ)
+		}
+	}
+
+	return nil
+}
+
+func isVoid(typ btf.Type) bool {
+	_, ok := btf.UnderlyingType(typ).(*btf.Void)
+	return ok
+}
+
 // fixupAndValidate is called by the ELF reader right before marshaling the
 // instruction stream. It performs last-minute adjustments to the program and
 // runs some sanity checks before sending it off to the kernel.
 func fixupAndValidate(insns asm.Instructions) error {
+	if err := fixupUndefinedReferences(insns); err != nil {
+		return err
+	}
+
+	var missingMaps []string
 	iter := insns.Iterate()
 	for iter.Next() {
 		ins := iter.Ins
 
 		// Map load was tagged with a Reference, but does not contain a Map pointer.
 		if ins.IsLoadFromMap() && ins.Reference() != "" && ins.Map() == nil {
-			return fmt.Errorf("instruction %d: map %s: %w", iter.Index, ins.Reference(), asm.ErrUnsatisfiedMapReference)
+			missingMaps = append(missingMaps, ins.Reference())
 		}
 
 		fixupProbeReadKernel(ins)
 	}
 
+	if len(missingMaps) > 0 {
+		return fmt.Errorf("maps %s: %w", joinUnique(missingMaps), asm.ErrUnsatisfiedMapReference)
+	}
+
 	return nil
 }
 
+// fixupUndefinedReferences handles references to functions which aren't part
+// of insns.
+//
+// Calls to missing weak functions are rewritten to calls which the verifier
+// rejects if they are reachable, and loads of their address become zero.
+// Missing functions with any other binding are collected into a single error.
+func fixupUndefinedReferences(insns asm.Instructions) error {
+	symbols := make(map[string]bool)
+	for _, ins := range insns {
+		if sym := ins.Symbol(); sym != "" {
+			symbols[sym] = true
+		}
+	}
+
+	var missing []string
+	for i := range insns {
+		ins := &insns[i]
+		ref := ins.Reference()
+		if ref == "" || symbols[ref] || !ins.IsFunctionReference() || ins.Constant != -1 {
+			continue
+		}
+
+		if weak, _ := ins.Metadata.Get(weakMetaKey{}).(bool); !weak {
+			missing = append(missing, ref)
+			continue
+		}
+
+		if ins.IsFunctionCall() {
+			ins.Constant = weakCallPoison
+		} else {
+			ins.Constant = 0
+		}
+		ins.Src = asm.R0
+		*ins = ins.WithReference("")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined functions %s: %w", joinUnique(missing), asm.ErrUnsatisfiedProgramReference)
+	}
+
+	return nil
+}
+
+// joinUnique sorts names and joins them without duplicates.
+func joinUnique(names []string) string {
+	sort.Strings(names)
+
+	unique := names[:0]
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		unique = append(unique, name)
+	}
+
+	return strings.Join(unique, ", ")
+}
+
 // fixupProbeReadKernel replaces calls to bpf_probe_read_{kernel,user}(_str)
 // with bpf_probe_read(_str) on kernels that don't support it yet.
 func fixupProbeReadKernel(ins *asm.Instruction) {
@@ -214,12 +429,16 @@ func fixupProbeReadKernel(ins *asm.Instruction) {
 var kernelBTF struct {
 	sync.Mutex
 	spec *btf.Spec
+	// The value of internal.KernelBTFGeneration when spec was loaded.
+	generation uint64
 }
 
 // maybeLoadKernelBTF loads the current kernel's BTF if spec is nil, otherwise
 // it returns spec unchanged.
 //
-// The kernel BTF is cached for the lifetime of the process.
+// The kernel BTF is shared by all callers and must not be modified. It is
+// cached until btf.FlushKernelSpec is called, to avoid copying it for every
+// program.
 func maybeLoadKernelBTF(spec *btf.Spec) (*btf.Spec, error) {
 	if spec != nil {
 		return spec, nil
@@ -228,11 +447,16 @@ func maybeLoadKernelBTF(spec *btf.Spec) (*btf.Spec, error) {
 	kernelBTF.Lock()
 	defer kernelBTF.Unlock()
 
-	if kernelBTF.spec != nil {
+	generation := internal.KernelBTFGeneration()
+	if kernelBTF.spec != nil && kernelBTF.generation == generation {
 		return kernelBTF.spec, nil
 	}
 
-	var err error
-	kernelBTF.spec, err = btf.LoadKernelSpec()
-	return kernelBTF.spec, err
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	kernelBTF.spec, kernelBTF.generation = spec, generation
+	return spec, nil
 }
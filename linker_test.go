@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/kallsyms"
 	"github.com/cilium/ebpf/internal/testutils"
 
 	qt "github.com/frankban/quicktest"
@@ -56,6 +58,84 @@ func TestFindReferences(t *testing.T) {
 	}
 }
 
+func TestFlattenProgramsSharedAndRecursive(t *testing.T) {
+	fn := func(name string, calls ...string) *ProgramSpec {
+		insns := asm.Instructions{asm.Mov.Imm(asm.R0, 0).WithSymbol(name)}
+		for _, call := range calls {
+			insns = append(insns, asm.Call.Label(call))
+		}
+		return &ProgramSpec{Instructions: append(insns, asm.Return())}
+	}
+
+	progs := map[string]*ProgramSpec{
+		"entry":  fn("entry", "a", "b"),
+		"a":      fn("a", "shared"),
+		"b":      fn("b", "shared"),
+		"shared": fn("shared"),
+		"loop":   fn("loop", "back"),
+		"back":   fn("back", "loop"),
+	}
+
+	flattenPrograms(progs, []string{"entry", "loop"})
+
+	// Functions called from multiple places are only included once.
+	offsets, err := progs["entry"].Instructions.SymbolOffsets()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, offsets, qt.HasLen, 4)
+
+	// Calling back into the program doesn't duplicate it.
+	offsets, err = progs["loop"].Instructions.SymbolOffsets()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, offsets, qt.DeepEquals, map[string]int{"loop": 0, "back": 3})
+}
+
+func TestFixupUndefinedReferences(t *testing.T) {
+	weak := func(ins asm.Instruction) asm.Instruction {
+		ins.Metadata.Set(weakMetaKey{}, true)
+		return ins
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Imm(asm.R0, 1),
+		asm.JEq.Imm(asm.R0, 1, "exit"),
+		weak(asm.Call.Label("maybe")),
+		asm.Instruction{OpCode: asm.LoadImmOp(asm.DWord), Src: asm.PseudoFunc, Dst: asm.R1, Constant: -1}.WithReference("maybe"),
+		asm.Return().WithSymbol("exit"),
+	}
+	insns[3] = weak(insns[3])
+
+	qt.Assert(t, fixupUndefinedReferences(insns), qt.IsNil)
+	qt.Assert(t, insns[2].IsBuiltinCall(), qt.IsTrue)
+	qt.Assert(t, insns[2].Constant, qt.Equals, int64(weakCallPoison))
+	qt.Assert(t, insns[3].IsConstantLoad(asm.DWord), qt.IsTrue)
+	qt.Assert(t, insns[3].Constant, qt.Equals, int64(0))
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type:         SocketFilter,
+		License:      "MIT",
+		Instructions: insns,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer prog.Close()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ret, qt.Equals, uint32(1))
+
+	// Missing functions without weak binding are all reported.
+	insns = asm.Instructions{
+		asm.Call.Label("b"),
+		asm.Call.Label("a"),
+		asm.Call.Label("b"),
+		asm.Return(),
+	}
+	err = fixupUndefinedReferences(insns)
+	qt.Assert(t, errors.Is(err, asm.ErrUnsatisfiedProgramReference), qt.IsTrue)
+	qt.Assert(t, err, qt.ErrorMatches, "undefined functions a, b: .*")
+}
+
 func TestForwardFunctionDeclaration(t *testing.T) {
 	testutils.Files(t, testutils.Glob(t, "testdata/fwd_decl-*.elf"), func(t *testing.T, file string) {
 		coll, err := LoadCollectionSpec(file)
@@ -155,3 +235,86 @@ func TestSplitSymbols(t *testing.T) {
 	c.Assert(len(m["sym3"]), qt.Equals, 3)
 	c.Assert(len(m["sym4"]), qt.Equals, 4)
 }
+
+func TestFixupKsyms(t *testing.T) {
+	c := qt.New(t)
+
+	symbols := map[string]uint64{"_text": 0, "bpf_prog_active": 0}
+	if err := kallsyms.AssignAddresses(symbols); err != nil || symbols["_text"] == 0 {
+		t.Skip("Kernel symbol addresses are not available")
+	}
+
+	ksym := func(ins asm.Instruction, typ btf.Type, weak bool) asm.Instruction {
+		ins.Metadata.Set(ksymMetaKey{}, &ksymMeta{typ, weak})
+		return ins.WithReference(typ.TypeName())
+	}
+
+	kfunc := asm.Instruction{OpCode: asm.OpCode(asm.JumpClass).SetJumpOp(asm.Call), Src: asm.PseudoKfuncCall, Constant: -1}
+	u32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+	typed := ksym(asm.LoadImm(asm.R1, 0, asm.DWord), &btf.Var{Name: "bpf_prog_active", Type: u32}, false)
+
+	insns := asm.Instructions{
+		ksym(kfunc, &btf.Func{Name: "bpf_cast_to_kern_ctx"}, false),
+		ksym(asm.LoadImm(asm.R1, 0, asm.DWord), &btf.Var{Name: "_text", Type: (*btf.Void)(nil)}, false),
+		ksym(asm.LoadImm(asm.R1, 0, asm.DWord), &btf.Var{Name: "does_not_exist", Type: u32}, true),
+		asm.Mov.Imm(asm.R0, 1),
+		// The verifier knows that R0 is 1 and ignores the poisoned call.
+		asm.JEq.Imm(asm.R0, 1, "exit"),
+		ksym(kfunc, &btf.Func{Name: "does_not_exist"}, true),
+		asm.Return().WithSymbol("exit"),
+	}
+
+	if symbols["bpf_prog_active"] != 0 {
+		// The kernel resolves typed ksyms via kallsyms as well.
+		insns = append(asm.Instructions{typed}, insns...)
+	}
+
+	spec := &ProgramSpec{
+		Type:         SocketFilter,
+		License:      "GPL",
+		Instructions: insns,
+	}
+
+	prog, err := NewProgram(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if errors.Is(err, btf.ErrNotFound) {
+		t.Skip("Kernel lacks symbol:", err)
+	}
+	c.Assert(err, qt.IsNil)
+	defer prog.Close()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ret, qt.Equals, uint32(1))
+
+	fixed := append(asm.Instructions{typed}, insns...)
+	c.Assert(fixupKsyms(fixed, nil), qt.IsNil)
+	c.Assert(fixed[0].Src, qt.Equals, asm.PseudoBTFID)
+	c.Assert(fixed[1].IsKfuncCall(), qt.IsTrue)
+	c.Assert(fixed[2].Constant, qt.Equals, int64(symbols["_text"]))
+	c.Assert(fixed[3].Constant, qt.Equals, int64(0))
+	c.Assert(fixed[6].IsBuiltinCall(), qt.IsTrue)
+	c.Assert(spec.Instructions[len(insns)-2].Constant, qt.Equals, int64(-1), qt.Commentf("NewProgram modified the spec"))
+
+	missing := asm.Instructions{
+		ksym(kfunc, &btf.Func{Name: "does_not_exist"}, false),
+		asm.Return(),
+	}
+	c.Assert(fixupKsyms(missing, nil), qt.ErrorIs, btf.ErrNotFound)
+}
+
+func TestMaybeLoadKernelBTFFlush(t *testing.T) {
+	spec, err := maybeLoadKernelBTF(nil)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+
+	cached, err := maybeLoadKernelBTF(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, cached, qt.Equals, spec)
+
+	btf.FlushKernelSpec()
+	flushed, err := maybeLoadKernelBTF(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, flushed, qt.Not(qt.Equals), spec)
+}
@@ -0,0 +1,145 @@
+// Package lpmtrie encodes IP prefixes as keys of BPF_MAP_TYPE_LPM_TRIE maps.
+//
+// The kernel expects keys in the layout of struct bpf_lpm_trie_key: the
+// prefix length in host byte order, followed by the address in network byte
+// order. Maps holding IPv4 prefixes use a key size of KeySizeIPv4, maps
+// holding IPv6 prefixes use KeySizeIPv6.
+package lpmtrie
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+)
+
+const (
+	// KeySizeIPv4 is the key size of an LPMTrie holding IPv4 prefixes.
+	KeySizeIPv4 = 4 + net.IPv4len
+	// KeySizeIPv6 is the key size of an LPMTrie holding IPv6 prefixes.
+	KeySizeIPv6 = 4 + net.IPv6len
+)
+
+// Key is an IP prefix used as the key of an LPMTrie.
+//
+// It implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler,
+// and can be passed to the methods of ebpf.Map directly.
+type Key struct {
+	// IP is the address of the prefix. It must be 4 bytes long for maps
+	// holding IPv4 prefixes, and 16 bytes long otherwise.
+	IP net.IP
+	// PrefixLen is the number of leading bits of IP which are significant.
+	PrefixLen uint32
+}
+
+// KeyFromIPNet converts n into a Key.
+//
+// IPv4 prefixes, as returned by net.ParseCIDR, produce keys for maps
+// holding IPv4 prefixes.
+func KeyFromIPNet(n *net.IPNet) Key {
+	ones, bits := n.Mask.Size()
+	ip := n.IP
+	if bits == 8*net.IPv4len {
+		ip = ip.To4()
+	}
+	return Key{ip, uint32(ones)}
+}
+
+// KeyFromIP returns a Key which matches exactly ip.
+//
+// IPv4 addresses produce keys for maps holding IPv4 prefixes. Looking up
+// the key returns the value of the longest prefix containing ip.
+func KeyFromIP(ip net.IP) Key {
+	if ip4 := ip.To4(); ip4 != nil {
+		return Key{ip4, 8 * net.IPv4len}
+	}
+	return Key{ip, 8 * net.IPv6len}
+}
+
+// IPNet returns the prefix as a net.IPNet.
+//
+// Returns nil if the key is invalid.
+func (k Key) IPNet() *net.IPNet {
+	if k.validate() != nil {
+		return nil
+	}
+
+	mask := net.CIDRMask(int(k.PrefixLen), 8*len(k.IP))
+	return &net.IPNet{IP: k.IP.Mask(mask), Mask: mask}
+}
+
+func (k Key) String() string {
+	if err := k.validate(); err != nil {
+		return fmt.Sprintf("Key(%v/%d)", k.IP, k.PrefixLen)
+	}
+	return k.IPNet().String()
+}
+
+func (k Key) validate() error {
+	if len(k.IP) != net.IPv4len && len(k.IP) != net.IPv6len {
+		return fmt.Errorf("invalid IP length %d", len(k.IP))
+	}
+
+	if int(k.PrefixLen) > 8*len(k.IP) {
+		return fmt.Errorf("prefix length %d exceeds %d bits", k.PrefixLen, 8*len(k.IP))
+	}
+
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// Bits of IP beyond PrefixLen are cleared.
+func (k Key) MarshalBinary() ([]byte, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4+len(k.IP))
+	internal.NativeEndian.PutUint32(buf, k.PrefixLen)
+	copy(buf[4:], k.IP.Mask(net.CIDRMask(int(k.PrefixLen), 8*len(k.IP))))
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (k *Key) UnmarshalBinary(buf []byte) error {
+	if len(buf) != KeySizeIPv4 && len(buf) != KeySizeIPv6 {
+		return fmt.Errorf("invalid key size %d", len(buf))
+	}
+
+	prefixLen := internal.NativeEndian.Uint32(buf)
+	if int(prefixLen) > 8*(len(buf)-4) {
+		return fmt.Errorf("prefix length %d exceeds %d bits", prefixLen, 8*(len(buf)-4))
+	}
+
+	k.IP = append(net.IP(nil), buf[4:]...)
+	k.PrefixLen = prefixLen
+	return nil
+}
+
+// Lookup retrieves the value of the longest prefix in m containing ip.
+//
+// Returns ebpf.ErrKeyNotExist if no prefix matches.
+func Lookup(m *ebpf.Map, ip net.IP, valueOut interface{}) error {
+	if m.Type() != ebpf.LPMTrie {
+		return fmt.Errorf("invalid map type %s, expected LPMTrie", m.Type())
+	}
+
+	var key Key
+	switch m.KeySize() {
+	case KeySizeIPv4:
+		key = Key{ip.To4(), 8 * net.IPv4len}
+	case KeySizeIPv6:
+		// IPv4 addresses match IPv4-mapped IPv6 prefixes.
+		key = Key{ip.To16(), 8 * net.IPv6len}
+	default:
+		return fmt.Errorf("key size %d doesn't hold an IP prefix", m.KeySize())
+	}
+
+	if key.IP == nil {
+		return fmt.Errorf("can't look up %v in map with key size %d", ip, m.KeySize())
+	}
+
+	return m.Lookup(key, valueOut)
+}
@@ -0,0 +1,180 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+func mustParseCIDR(tb testing.TB, s string) *net.IPNet {
+	tb.Helper()
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return n
+}
+
+func mustNewTrie(tb testing.TB, keySize uint32) *ebpf.Map {
+	tb.Helper()
+	testutils.SkipOnOldKernel(tb, "4.11", "LPM trie")
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.LPMTrie,
+		KeySize:    keySize,
+		ValueSize:  4,
+		MaxEntries: 16,
+		Flags:      unix.BPF_F_NO_PREALLOC,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { m.Close() })
+
+	return m
+}
+
+func TestKeyMarshaling(t *testing.T) {
+	for _, s := range []string{"10.1.2.0/24", "0.0.0.0/0", "2001:db8::/32", "::ffff:10.0.0.0/104"} {
+		t.Run(s, func(t *testing.T) {
+			n := mustParseCIDR(t, s)
+			key := KeyFromIPNet(n)
+
+			buf, err := key.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ones, bits := n.Mask.Size()
+			if len(buf) != 4+bits/8 {
+				t.Fatalf("Key has %d bytes, expected %d", len(buf), 4+bits/8)
+			}
+			if prefixLen := internal.NativeEndian.Uint32(buf); prefixLen != uint32(ones) {
+				t.Errorf("Encoded prefix length %d, expected %d", prefixLen, ones)
+			}
+
+			var have Key
+			if err := have.UnmarshalBinary(buf); err != nil {
+				t.Fatal(err)
+			}
+			if have.String() != n.String() {
+				t.Errorf("Decoded %s, expected %s", have, n)
+			}
+		})
+	}
+
+	// Bits beyond the prefix are cleared.
+	buf, err := Key{net.IPv4(10, 1, 2, 3).To4(), 24}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[4:], []byte{10, 1, 2, 0}) {
+		t.Errorf("Host bits aren't cleared: %v", buf[4:])
+	}
+
+	for _, key := range []Key{
+		{net.IPv4(10, 0, 0, 0).To4(), 33},
+		{net.IP{1, 2, 3}, 8},
+		{nil, 0},
+	} {
+		if _, err := key.MarshalBinary(); err == nil {
+			t.Errorf("Marshaling invalid key %s doesn't return an error", key)
+		}
+	}
+
+	var key Key
+	if err := key.UnmarshalBinary(make([]byte, 5)); err == nil {
+		t.Error("Unmarshaling a key of invalid size doesn't return an error")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		keySize  uint32
+		prefixes []string
+		lookups  map[string]uint32
+	}{
+		{
+			"IPv4", KeySizeIPv4,
+			[]string{"10.0.0.0/8", "10.1.0.0/16"},
+			map[string]uint32{
+				"10.2.3.4":        0,
+				"10.1.2.3":        1,
+				"::ffff:10.1.2.3": 1,
+				"192.168.0.1":     ^uint32(0),
+				"2001:db8::1":     ^uint32(0),
+			},
+		},
+		{
+			"IPv6", KeySizeIPv6,
+			[]string{"2001:db8::/32", "2001:db8:1::/48", "::ffff:10.0.0.0/104"},
+			map[string]uint32{
+				"2001:db8:2::1": 0,
+				"2001:db8:1::1": 1,
+				"10.1.2.3":      2,
+				"2001:db9::1":   ^uint32(0),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := mustNewTrie(t, tc.keySize)
+
+			for i, s := range tc.prefixes {
+				if err := m.Put(KeyFromIPNet(mustParseCIDR(t, s)), uint32(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for s, want := range tc.lookups {
+				ip := net.ParseIP(s)
+				if tc.keySize == KeySizeIPv4 && ip.To4() == nil {
+					var value uint32
+					if err := Lookup(m, ip, &value); err == nil {
+						t.Errorf("Lookup of %s in IPv4 map doesn't return an error", s)
+					}
+					continue
+				}
+
+				var value uint32
+				err := Lookup(m, ip, &value)
+				if want == ^uint32(0) {
+					if !errors.Is(err, ebpf.ErrKeyNotExist) {
+						t.Errorf("Lookup of %s: expected ErrKeyNotExist, got %v", s, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("Lookup of %s: %v", s, err)
+				} else if value != want {
+					t.Errorf("Lookup of %s returned %d, expected %d", s, value, want)
+				}
+			}
+
+			var (
+				key    Key
+				value  uint32
+				prefix = make(map[string]bool)
+			)
+			iter := m.Iterate()
+			for iter.Next(&key, &value) {
+				prefix[key.String()] = true
+			}
+			if err := iter.Err(); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tc.prefixes {
+				if !prefix[mustParseCIDR(t, s).String()] {
+					t.Errorf("Iteration doesn't return %s", s)
+				}
+			}
+		})
+	}
+}
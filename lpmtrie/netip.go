@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package lpmtrie
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+)
+
+// KeyFromPrefix converts p into a Key.
+//
+// IPv4 prefixes produce keys for maps holding IPv4 prefixes, IPv4-mapped
+// IPv6 prefixes are kept as IPv6.
+func KeyFromPrefix(p netip.Prefix) Key {
+	return Key{p.Addr().AsSlice(), uint32(p.Bits())}
+}
+
+// Prefix returns the prefix as a netip.Prefix.
+//
+// The result is invalid if the key is invalid.
+func (k Key) Prefix() netip.Prefix {
+	if k.validate() != nil {
+		return netip.Prefix{}
+	}
+
+	addr, _ := netip.AddrFromSlice(k.IP)
+	return netip.PrefixFrom(addr, int(k.PrefixLen)).Masked()
+}
+
+// LookupAddr retrieves the value of the longest prefix in m containing addr.
+//
+// Returns ebpf.ErrKeyNotExist if no prefix matches.
+func LookupAddr(m *ebpf.Map, addr netip.Addr, valueOut interface{}) error {
+	return Lookup(m, net.IP(addr.AsSlice()), valueOut)
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package lpmtrie
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestKeyFromPrefix(t *testing.T) {
+	for _, s := range []string{"10.1.2.0/24", "2001:db8::/32", "::ffff:10.0.0.0/104"} {
+		p := netip.MustParsePrefix(s)
+		key := KeyFromPrefix(p)
+
+		if len(key.IP) != p.Addr().BitLen()/8 {
+			t.Errorf("%s: key has %d address bytes", s, len(key.IP))
+		}
+		if have := key.Prefix(); have != p {
+			t.Errorf("%s: round trip returned %s", s, have)
+		}
+	}
+
+	if (Key{}).Prefix().IsValid() {
+		t.Error("Prefix of an invalid key is valid")
+	}
+}
+
+func TestLookupAddr(t *testing.T) {
+	m := mustNewTrie(t, KeySizeIPv4)
+
+	if err := m.Put(KeyFromPrefix(netip.MustParsePrefix("192.0.2.0/24")), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	var value uint32
+	if err := LookupAddr(m, netip.MustParseAddr("192.0.2.1"), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Errorf("Expected 42, got %d", value)
+	}
+
+	if err := LookupAddr(m, netip.Addr{}, &value); err == nil {
+		t.Error("Lookup of an invalid address doesn't return an error")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"os"
 	"path/filepath"
 	"reflect"
 	"time"
@@ -26,15 +27,41 @@ var (
 	errMapNoBTFValue    = errors.New("map spec does not contain a BTF Value")
 )
 
+// MapIncompatibleError describes how an existing map differs from a MapSpec.
+type MapIncompatibleError struct {
+	// The name of the MapSpec field which doesn't match, e.g. "KeySize".
+	Field string
+	// The value of the field in the MapSpec and in the existing map.
+	Expected, Actual interface{}
+}
+
+func (mie *MapIncompatibleError) Error() string {
+	return fmt.Sprintf("expected %s %v, got %v: %s", mie.Field, mie.Expected, mie.Actual, ErrMapIncompatible)
+}
+
+// Is indicates that MapIncompatibleError is ErrMapIncompatible.
+func (mie *MapIncompatibleError) Is(target error) bool {
+	return target == ErrMapIncompatible
+}
+
 // MapOptions control loading a map into the kernel.
 type MapOptions struct {
 	// The base path to pin maps in if requested via PinByName.
 	// Existing maps will be re-used if they are compatible, otherwise an
 	// error is returned.
+	//
+	// Defaults to /sys/fs/bpf, like libbpf.
 	PinPath        string
 	LoadPinOptions LoadPinOptions
+	// Create maps and load their BTF with the permissions delegated by
+	// a token. Optional field.
+	Token *Token
 }
 
+// defaultPinPath is the base path for PinByName if MapOptions.PinPath is
+// empty. It's a variable so that tests don't have to pin to the global bpffs.
+var defaultPinPath = "/sys/fs/bpf"
+
 // MapID represents the unique ID of an eBPF map
 type MapID uint32
 
@@ -50,15 +77,31 @@ type MapSpec struct {
 
 	// Flags is passed to the kernel and specifies additional map
 	// creation attributes.
+	//
+	// BPF_F_RDONLY and BPF_F_WRONLY restrict access from user space to the
+	// created Map. Operations which aren't permitted return an error
+	// wrapping EPERM. BPF_F_RDONLY_PROG and BPF_F_WRONLY_PROG restrict
+	// access from programs instead.
 	Flags uint32
 
+	// MapExtra is passed to the kernel and its meaning depends on Type.
+	// For BloomFilter it is the number of hash functions, from 1 to 15.
+	// Zero selects the kernel default.
+	MapExtra uint64
+
 	// Automatically pin and load a map from MapOptions.PinPath.
 	// Generates an error if an existing pinned map is incompatible with the MapSpec.
+	//
+	// Maps declared with LIBBPF_PIN_BY_NAME in an ELF use PinByName.
 	Pinning PinType
 
 	// Specify numa node during map creation
 	// (effective only if unix.BPF_F_NUMA_NODE flag is set,
 	// which can be imported from golang.org/x/sys/unix)
+	//
+	// The memory of the map is allocated on the given node. Maps with
+	// per-CPU values can't be placed on a node. The kernel ignores NumaNode
+	// if the flag isn't set.
 	NumaNode uint32
 
 	// The initial contents of the map. May be nil.
@@ -67,11 +110,14 @@ type MapSpec struct {
 	// Whether to freeze a map after setting its initial contents.
 	Freeze bool
 
-	// InnerMap is used as a template for ArrayOfMaps and HashOfMaps
+	// InnerMap is used as a template for ArrayOfMaps and HashOfMaps.
+	// It may not be a map of maps itself.
 	InnerMap *MapSpec
 
 	// Extra trailing bytes found in the ELF map definition when using structs
-	// larger than libbpf's bpf_map_def. nil if no trailing bytes were present.
+	// larger than libbpf's bpf_map_def. The fields of iproute2's bpf_elf_map
+	// and of the bpf_map_def in the kernel's samples/bpf are parsed instead.
+	// nil if no trailing bytes were present.
 	// Must be nil or empty before instantiating the MapSpec into a Map.
 	Extra *bytes.Reader
 
@@ -88,7 +134,9 @@ func (ms *MapSpec) String() string {
 
 // Copy returns a copy of the spec.
 //
-// MapSpec.Contents is a shallow copy.
+// Keys and values in MapSpec.Contents which are byte slices or
+// StructOpsValues are copied, other values are shared with the original.
+// The BTF types are shared as well.
 func (ms *MapSpec) Copy() *MapSpec {
 	if ms == nil {
 		return nil
@@ -97,19 +145,259 @@ func (ms *MapSpec) Copy() *MapSpec {
 	cpy := *ms
 
 	cpy.Contents = make([]MapKV, len(ms.Contents))
-	copy(cpy.Contents, ms.Contents)
+	for i, kv := range ms.Contents {
+		cpy.Contents[i] = MapKV{copyContent(kv.Key), copyContent(kv.Value)}
+	}
+
+	if ms.Extra != nil {
+		extra := *ms.Extra
+		cpy.Extra = &extra
+	}
 
 	cpy.InnerMap = ms.InnerMap.Copy()
 
 	return &cpy
 }
 
+// copyContent copies mutable keys and values of MapSpec.Contents.
+func copyContent(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if v == nil {
+			return v
+		}
+		cpy := make([]byte, len(v))
+		copy(cpy, v)
+		return cpy
+	case StructOpsValue:
+		return v.copy()
+	default:
+		return v
+	}
+}
+
+// SetGoTypes derives Key, Value and BTF from the Go types of key and
+// value. This allows tools like bpftool to display the contents of maps
+// which are created without an ELF.
+//
+// key may be nil for map types without keys. The value of per-CPU maps is
+// the type stored for a single CPU. KeySize and ValueSize are set to the
+// size of the types if they are zero, and must match otherwise.
+//
+// See btf.GoType for the supported types.
+func (ms *MapSpec) SetGoTypes(key, value interface{}) error {
+	var keyType btf.Type = (*btf.Void)(nil)
+	var keySize uint32
+	if key != nil {
+		typ := reflect.TypeOf(key)
+		var err error
+		keyType, err = btf.GoType(typ)
+		if err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+		keySize = uint32(typ.Size())
+	}
+
+	typ := reflect.TypeOf(value)
+	valueType, err := btf.GoType(typ)
+	if err != nil {
+		return fmt.Errorf("value: %w", err)
+	}
+	valueSize := uint32(typ.Size())
+
+	if ms.KeySize != 0 && ms.KeySize != keySize {
+		return fmt.Errorf("key type %T has size %d instead of %d", key, keySize, ms.KeySize)
+	}
+
+	if ms.ValueSize != 0 && ms.ValueSize != valueSize {
+		return fmt.Errorf("value type %T has size %d instead of %d", value, valueSize, ms.ValueSize)
+	}
+
+	spec, err := btf.NewSpecFromTypes(keyType, valueType)
+	if err != nil {
+		return err
+	}
+
+	ms.KeySize, ms.ValueSize = keySize, valueSize
+	ms.Key, ms.Value, ms.BTF = keyType, valueType, spec
+	return nil
+}
+
 // hasBTF returns true if the MapSpec has a valid BTF spec and if its
 // map type supports associated BTF metadata in the kernel.
 func (ms *MapSpec) hasBTF() bool {
 	return ms.BTF != nil && ms.Type.hasBTF()
 }
 
+// Validate checks the spec for mistakes which would cause the kernel to
+// reject it, without creating a map.
+//
+// It verifies the key and value size, MaxEntries and Flags against the
+// constraints of well known map types. The checks are not exhaustive: a
+// spec which passes may still be rejected by the kernel, for example
+// because the map type isn't supported. Map types unknown to the library
+// are not checked.
+func (ms *MapSpec) Validate() error {
+	if err := ms.validate(); err != nil {
+		return fmt.Errorf("invalid %s: %w", ms.Type, err)
+	}
+	return nil
+}
+
+func (ms *MapSpec) validate() error {
+	if ms.Flags&unix.BPF_F_RDONLY != 0 && ms.Flags&unix.BPF_F_WRONLY != 0 {
+		return errors.New("flags BPF_F_RDONLY and BPF_F_WRONLY are mutually exclusive")
+	}
+
+	if ms.Flags&unix.BPF_F_RDONLY_PROG != 0 && ms.Flags&unix.BPF_F_WRONLY_PROG != 0 {
+		return errors.New("flags BPF_F_RDONLY_PROG and BPF_F_WRONLY_PROG are mutually exclusive")
+	}
+
+	if ms.Flags&unix.BPF_F_RDONLY != 0 && (len(ms.Contents) > 0 || ms.Freeze) {
+		return errors.New("flag BPF_F_RDONLY prevents populating or freezing the map")
+	}
+
+	// Map types added to the kernel after the library may accept flags
+	// and MapExtra, leave checking them to the kernel.
+	if ms.Flags&unix.BPF_F_MMAPABLE != 0 && ms.Type.isKnown() && ms.Type != Array {
+		return errors.New("flag BPF_F_MMAPABLE is only valid for Array")
+	}
+
+	if ms.Flags&unix.BPF_F_NUMA_NODE != 0 && ms.Type.hasPerCPUValue() {
+		return errors.New("flag BPF_F_NUMA_NODE is not valid for per-CPU maps")
+	}
+
+	if ms.MapExtra != 0 && ms.Type.isKnown() && ms.Type != BloomFilter {
+		return errors.New("MapExtra is only valid for BloomFilter")
+	}
+
+	switch ms.Type {
+	case Hash, PerCPUHash, LRUHash, LRUCPUHash, SockHash, DevMapHash:
+		if ms.KeySize == 0 {
+			return errors.New("KeySize must be non-zero")
+		}
+
+	case Array, PerCPUArray, ProgramArray, CGroupArray, ArrayOfMaps,
+		DevMap, SockMap, CPUMap, XSKMap,
+		SkStorage, InodeStorage, TaskStorage:
+		if ms.KeySize != 4 {
+			return fmt.Errorf("KeySize must be 4, got %d", ms.KeySize)
+		}
+
+	case PerfEventArray:
+		if ms.KeySize != 0 && ms.KeySize != 4 {
+			return fmt.Errorf("KeySize must be zero or 4, got %d", ms.KeySize)
+		}
+
+	case Queue, Stack, BloomFilter, RingBuf:
+		if ms.KeySize != 0 {
+			return fmt.Errorf("KeySize must be zero, got %d", ms.KeySize)
+		}
+	}
+
+	switch ms.Type {
+	case ProgramArray, CGroupArray:
+		if ms.ValueSize != 4 {
+			return fmt.Errorf("ValueSize must be 4, got %d", ms.ValueSize)
+		}
+
+	case ArrayOfMaps, HashOfMaps, PerfEventArray:
+		if ms.ValueSize != 0 && ms.ValueSize != 4 {
+			return fmt.Errorf("ValueSize must be zero or 4, got %d", ms.ValueSize)
+		}
+
+	case RingBuf:
+		if ms.ValueSize != 0 {
+			return fmt.Errorf("ValueSize must be zero, got %d", ms.ValueSize)
+		}
+
+	case Hash, Array, LRUHash, LPMTrie, Queue, Stack, BloomFilter,
+		SkStorage, InodeStorage, TaskStorage:
+		if ms.ValueSize == 0 {
+			return errors.New("ValueSize must be non-zero")
+		}
+
+	case PerCPUHash, PerCPUArray, LRUCPUHash:
+		if ms.ValueSize == 0 {
+			return errors.New("ValueSize must be non-zero")
+		}
+
+		// The kernel allocates per-CPU values from chunks of
+		// PCPU_MIN_UNIT_SIZE bytes.
+		if internal.Align(int(ms.ValueSize), 8) > 32*1024 {
+			return fmt.Errorf("ValueSize of %d bytes exceeds the per-CPU limit of 32KiB", ms.ValueSize)
+		}
+	}
+
+	switch ms.Type {
+	case SkStorage, InodeStorage, TaskStorage:
+		if ms.MaxEntries != 0 {
+			return fmt.Errorf("MaxEntries must be zero, got %d", ms.MaxEntries)
+		}
+
+	case RingBuf:
+		if ms.MaxEntries == 0 || ms.MaxEntries&(ms.MaxEntries-1) != 0 || ms.MaxEntries%uint32(os.Getpagesize()) != 0 {
+			return fmt.Errorf("MaxEntries must be a power of two and a multiple of the page size, got %d", ms.MaxEntries)
+		}
+
+	case Hash, Array, ProgramArray, PerCPUHash, PerCPUArray, StackTrace,
+		CGroupArray, LRUHash, LRUCPUHash, LPMTrie, ArrayOfMaps, HashOfMaps,
+		DevMap, SockMap, CPUMap, XSKMap, SockHash, ReusePortSockArray,
+		Queue, Stack, DevMapHash, BloomFilter:
+		if ms.MaxEntries == 0 {
+			return errors.New("MaxEntries must be non-zero")
+		}
+	}
+
+	switch ms.Type {
+	case Array, PerCPUArray:
+		if ms.Flags&unix.BPF_F_NO_PREALLOC != 0 {
+			return errors.New("flag BPF_F_NO_PREALLOC is not valid for arrays")
+		}
+
+	case LPMTrie:
+		// The key is a struct bpf_lpm_trie_key followed by 1 to 256
+		// bytes of data.
+		if ms.KeySize < 4+1 || ms.KeySize > 4+256 {
+			return fmt.Errorf("KeySize must be between 5 and 260, got %d", ms.KeySize)
+		}
+
+		if ms.Flags&unix.BPF_F_NO_PREALLOC == 0 {
+			return errors.New("flag BPF_F_NO_PREALLOC is required")
+		}
+
+	case SkStorage, InodeStorage, TaskStorage:
+		if ms.Flags&unix.BPF_F_NO_PREALLOC == 0 {
+			return errors.New("flag BPF_F_NO_PREALLOC is required")
+		}
+
+	case BloomFilter:
+		// The lower four bits hold the number of hash functions.
+		if ms.MapExtra&^0xf != 0 {
+			return fmt.Errorf("MapExtra must be at most 15, got %d", ms.MapExtra)
+		}
+
+	case ArrayOfMaps, HashOfMaps:
+		if ms.InnerMap == nil {
+			return errors.New("InnerMap is required")
+		}
+
+		if ms.InnerMap.Pinning != PinNone {
+			return errors.New("inner maps cannot be pinned")
+		}
+
+		if ms.InnerMap.Type == ArrayOfMaps || ms.InnerMap.Type == HashOfMaps {
+			return fmt.Errorf("inner map: %s can't be nested", ms.InnerMap.Type)
+		}
+
+		if err := ms.InnerMap.Validate(); err != nil {
+			return fmt.Errorf("inner map: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (ms *MapSpec) clampPerfEventArraySize() error {
 	if ms.Type != PerfEventArray {
 		return nil
@@ -159,22 +447,39 @@ type MapKV struct {
 }
 
 func (ms *MapSpec) checkCompatibility(m *Map) error {
+	// A size of zero is replaced with the fixed size of these map types
+	// when creating the map, see createMap.
+	keySize, valueSize := ms.KeySize, ms.ValueSize
+	switch ms.Type {
+	case ArrayOfMaps, HashOfMaps:
+		if valueSize == 0 {
+			valueSize = 4
+		}
+	case PerfEventArray:
+		if keySize == 0 {
+			keySize = 4
+		}
+		if valueSize == 0 {
+			valueSize = 4
+		}
+	}
+
 	switch {
 	case m.typ != ms.Type:
-		return fmt.Errorf("expected type %v, got %v: %w", ms.Type, m.typ, ErrMapIncompatible)
+		return &MapIncompatibleError{"Type", ms.Type, m.typ}
 
-	case m.keySize != ms.KeySize:
-		return fmt.Errorf("expected key size %v, got %v: %w", ms.KeySize, m.keySize, ErrMapIncompatible)
+	case m.keySize != keySize:
+		return &MapIncompatibleError{"KeySize", keySize, m.keySize}
 
-	case m.valueSize != ms.ValueSize:
-		return fmt.Errorf("expected value size %v, got %v: %w", ms.ValueSize, m.valueSize, ErrMapIncompatible)
+	case m.valueSize != valueSize:
+		return &MapIncompatibleError{"ValueSize", valueSize, m.valueSize}
 
 	case !(ms.Type == PerfEventArray && ms.MaxEntries == 0) &&
 		m.maxEntries != ms.MaxEntries:
-		return fmt.Errorf("expected max entries %v, got %v: %w", ms.MaxEntries, m.maxEntries, ErrMapIncompatible)
+		return &MapIncompatibleError{"MaxEntries", ms.MaxEntries, m.maxEntries}
 
 	case m.flags != ms.Flags:
-		return fmt.Errorf("expected flags %v, got %v: %w", ms.Flags, m.flags, ErrMapIncompatible)
+		return &MapIncompatibleError{"Flags", ms.Flags, m.flags}
 	}
 	return nil
 }
@@ -188,6 +493,10 @@ func (ms *MapSpec) checkCompatibility(m *Map) error {
 //
 // Implement encoding.BinaryMarshaler or encoding.BinaryUnmarshaler
 // if you require custom encoding.
+//
+// Values of per-CPU maps are slices with one element per possible CPU.
+// The kernel aligns each element to 8 bytes, the necessary padding is
+// added and removed automatically.
 type Map struct {
 	name       string
 	fd         *sys.FD
@@ -199,11 +508,18 @@ type Map struct {
 	pinnedPath string
 	// Per CPU maps return values larger than the size in the spec
 	fullValueSize int
+	// Access mode of fd, either O_RDONLY, O_WRONLY or O_RDWR.
+	access int
 }
 
 // NewMapFromFD creates a map from a raw fd.
 //
-// You should not use fd after calling this function.
+// The type, key and value size and name of the map are queried from the
+// kernel, so that maps received via a unix socket or inherited from a
+// parent process can be used like maps created by this process.
+//
+// You should not use fd after calling this function, it is closed if an
+// error is returned.
 func NewMapFromFD(fd int) (*Map, error) {
 	f, err := sys.NewFD(fd)
 	if err != nil {
@@ -214,6 +530,11 @@ func NewMapFromFD(fd int) (*Map, error) {
 }
 
 func newMapFromFD(fd *sys.FD) (*Map, error) {
+	if err := fd.CheckAnonInode("bpf-map"); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
 	info, err := newMapInfoFromFd(fd)
 	if err != nil {
 		fd.Close()
@@ -264,6 +585,10 @@ func newMapWithOptions(spec *MapSpec, opts MapOptions, handles *handleCache) (_
 		}
 	}
 
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch spec.Pinning {
 	case PinByName:
 		if spec.Name == "" {
@@ -271,7 +596,7 @@ func newMapWithOptions(spec *MapSpec, opts MapOptions, handles *handleCache) (_
 		}
 
 		if opts.PinPath == "" {
-			return nil, fmt.Errorf("pin by name: missing MapOptions.PinPath")
+			opts.PinPath = defaultPinPath
 		}
 
 		path := filepath.Join(opts.PinPath, spec.Name)
@@ -285,7 +610,7 @@ func newMapWithOptions(spec *MapSpec, opts MapOptions, handles *handleCache) (_
 		defer closeOnError(m)
 
 		if err := spec.checkCompatibility(m); err != nil {
-			return nil, fmt.Errorf("use pinned map %s: %w", spec.Name, err)
+			return nil, fmt.Errorf("use pinned map %s at %s: %w", spec.Name, path, err)
 		}
 
 		return m, nil
@@ -299,14 +624,6 @@ func newMapWithOptions(spec *MapSpec, opts MapOptions, handles *handleCache) (_
 
 	var innerFd *sys.FD
 	if spec.Type == ArrayOfMaps || spec.Type == HashOfMaps {
-		if spec.InnerMap == nil {
-			return nil, fmt.Errorf("%s requires InnerMap", spec.Type)
-		}
-
-		if spec.InnerMap.Pinning != PinNone {
-			return nil, errors.New("inner maps cannot be pinned")
-		}
-
 		template, err := spec.InnerMap.createMap(nil, opts, handles)
 		if err != nil {
 			return nil, fmt.Errorf("inner map: %w", err)
@@ -356,26 +673,22 @@ func (spec *MapSpec) createMap(inner *sys.FD, opts MapOptions, handles *handleCa
 		}
 	}
 
+	var vmlinuxValueTypeID btf.TypeID
 	switch spec.Type {
 	case ArrayOfMaps, HashOfMaps:
 		if err := haveNestedMaps(); err != nil {
 			return nil, err
 		}
 
-		if spec.ValueSize != 0 && spec.ValueSize != 4 {
-			return nil, errors.New("ValueSize must be zero or four for map of map")
-		}
 		spec.ValueSize = 4
 
-	case PerfEventArray:
-		if spec.KeySize != 0 && spec.KeySize != 4 {
-			return nil, errors.New("KeySize must be zero or four for perf event array")
+	case BloomFilter:
+		if err := haveBloomFilter(); err != nil {
+			return nil, err
 		}
-		spec.KeySize = 4
 
-		if spec.ValueSize != 0 && spec.ValueSize != 4 {
-			return nil, errors.New("ValueSize must be zero or four for perf event array")
-		}
+	case PerfEventArray:
+		spec.KeySize = 4
 		spec.ValueSize = 4
 
 		if spec.MaxEntries == 0 {
@@ -385,6 +698,25 @@ func (spec *MapSpec) createMap(inner *sys.FD, opts MapOptions, handles *handleCa
 			}
 			spec.MaxEntries = uint32(n)
 		}
+
+	case StructOpsMap:
+		if spec.BTF == nil {
+			return nil, errors.New("struct_ops map requires BTF")
+		}
+
+		local, ok := btf.UnderlyingType(spec.Value).(*btf.Struct)
+		if !ok {
+			return nil, fmt.Errorf("struct_ops map value BTF is a %T, not a *btf.Struct", spec.Value)
+		}
+
+		kv, err := findStructOpsKernelValue(local.Name)
+		if err != nil {
+			return nil, fmt.Errorf("struct_ops map: %w", err)
+		}
+
+		// The kernel dictates the layout of the value.
+		spec.ValueSize = kv.value.Size
+		vmlinuxValueTypeID = kv.valueID
 	}
 
 	if spec.Flags&(unix.BPF_F_RDONLY_PROG|unix.BPF_F_WRONLY_PROG) > 0 || spec.Freeze {
@@ -415,23 +747,34 @@ func (spec *MapSpec) createMap(inner *sys.FD, opts MapOptions, handles *handleCa
 		MaxEntries: spec.MaxEntries,
 		MapFlags:   spec.Flags,
 		NumaNode:   spec.NumaNode,
+		MapExtra:   spec.MapExtra,
 	}
 
 	if inner != nil {
 		attr.InnerMapFd = inner.Uint()
 	}
 
+	if opts.Token != nil {
+		attr.MapTokenFd = int32(opts.Token.FD())
+		attr.MapFlags |= unix.BPF_F_TOKEN_FD
+	}
+
 	if haveObjName() == nil {
 		attr.MapName = sys.NewObjName(spec.Name)
 	}
 
 	if spec.hasBTF() {
-		handle, err := handles.btfHandle(spec.BTF)
+		handle, err := handles.btfHandle(spec.BTF, opts.Token)
 		if err != nil && !errors.Is(err, btf.ErrNotSupported) {
 			return nil, fmt.Errorf("load BTF: %w", err)
 		}
 
-		if handle != nil {
+		if handle != nil && spec.Type == StructOpsMap {
+			// The value is described by vmlinux, but the kernel still
+			// requires the BTF of the object.
+			attr.BtfFd = uint32(handle.FD())
+			attr.BtfVmlinuxValueTypeId = uint32(vmlinuxValueTypeID)
+		} else if handle != nil {
 			keyTypeID, err := spec.BTF.TypeID(spec.Key)
 			if err != nil {
 				return nil, err
@@ -445,6 +788,8 @@ func (spec *MapSpec) createMap(inner *sys.FD, opts MapOptions, handles *handleCa
 			attr.BtfFd = uint32(handle.FD())
 			attr.BtfKeyTypeId = uint32(keyTypeID)
 			attr.BtfValueTypeId = uint32(valueTypeID)
+		} else if spec.Type == StructOpsMap {
+			return nil, fmt.Errorf("struct_ops map: %w", btf.ErrNotSupported)
 		}
 	}
 
@@ -484,6 +829,14 @@ func newMap(fd *sys.FD, name string, typ MapType, keySize, valueSize, maxEntries
 		flags,
 		"",
 		int(valueSize),
+		unix.O_RDWR,
+	}
+
+	// The fd may have been created or opened with BPF_F_RDONLY or
+	// BPF_F_WRONLY, which restricts the operations allowed from user space.
+	// Not all fds support querying the mode, so assume read-write if that fails.
+	if fl, err := unix.FcntlInt(uintptr(fd.Int()), unix.F_GETFL, 0); err == nil {
+		m.access = fl & unix.O_ACCMODE
 	}
 
 	if !typ.hasPerCPUValue() {
@@ -576,8 +929,30 @@ func (m *Map) LookupWithFlags(key, valueOut interface{}, flags MapLookupFlags) e
 	return m.unmarshalValue(valueOut, valueBytes)
 }
 
+// HasKey checks whether a key exists in a Map.
+//
+// It's a convenience wrapper around Lookup for callers which aren't
+// interested in the value. A missing key is reported as false instead of
+// ErrKeyNotExist, other errors are returned as is.
+func (m *Map) HasKey(key interface{}) (bool, error) {
+	valueBytes := make([]byte, m.fullValueSize)
+	err := m.lookup(key, sys.NewSlicePointer(valueBytes), 0)
+	if errors.Is(err, ErrKeyNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // LookupAndDelete retrieves and deletes a value from a Map.
 //
+// The value is removed atomically, so that concurrent callers never
+// retrieve the same value twice. Queue and Stack are supported since
+// Linux 4.20, hash maps since Linux 5.14. Other map types return an error
+// wrapping ErrNotSupported.
+//
 // Returns ErrKeyNotExist if the key doesn't exist.
 func (m *Map) LookupAndDelete(key, valueOut interface{}) error {
 	return m.lookupAndDelete(key, valueOut, 0)
@@ -594,8 +969,33 @@ func (m *Map) LookupAndDeleteWithFlags(key, valueOut interface{}, flags MapLooku
 	return m.lookupAndDelete(key, valueOut, flags)
 }
 
+// LookupWithBuffer retrieves the raw value of a key into valueBuf.
+//
+// valueBuf must be able to hold the value as exchanged with the kernel:
+// ValueSize bytes, or for per-CPU maps one element per possible CPU with
+// each element padded to 8 bytes. The value isn't decoded, which avoids
+// the cost of unmarshaling and allocating when reading values frequently.
+// Pass an unsafe.Pointer or []byte as key to avoid encoding it.
+//
+// Returns an error if the key doesn't exist, see ErrKeyNotExist.
+func (m *Map) LookupWithBuffer(key interface{}, valueBuf []byte) error {
+	if len(valueBuf) < m.fullValueSize {
+		return fmt.Errorf("value buffer of %d bytes is smaller than %d bytes", len(valueBuf), m.fullValueSize)
+	}
+
+	return m.lookup(key, sys.NewSlicePointer(valueBuf), 0)
+}
+
 // LookupBytes gets a value from Map.
 //
+// The value is returned in the format used by the kernel, without
+// decoding. Values of per-CPU maps contain an element per possible CPU,
+// each padded to 8 bytes.
+//
+// key is marshaled like for Lookup, so that both decoded keys and raw keys
+// from NextKeyBytes can be used. A []byte key is passed to the kernel as is,
+// without copying.
+//
 // Returns a nil value if a key doesn't exist.
 func (m *Map) LookupBytes(key interface{}) ([]byte, error) {
 	valueBytes := make([]byte, m.fullValueSize)
@@ -610,6 +1010,10 @@ func (m *Map) LookupBytes(key interface{}) ([]byte, error) {
 }
 
 func (m *Map) lookup(key interface{}, valueOut sys.Pointer, flags MapLookupFlags) error {
+	if err := m.checkReadable(); err != nil {
+		return fmt.Errorf("lookup: %w", err)
+	}
+
 	keyPtr, err := m.marshalKey(key)
 	if err != nil {
 		return fmt.Errorf("can't marshal key: %w", err)
@@ -629,6 +1033,10 @@ func (m *Map) lookup(key interface{}, valueOut sys.Pointer, flags MapLookupFlags
 }
 
 func (m *Map) lookupAndDelete(key, valueOut interface{}, flags MapLookupFlags) error {
+	if err := m.checkReadWrite(); err != nil {
+		return fmt.Errorf("lookup and delete: %w", err)
+	}
+
 	valuePtr, valueBytes := makeBuffer(valueOut, m.fullValueSize)
 
 	keyPtr, err := m.marshalKey(key)
@@ -662,7 +1070,8 @@ const (
 	UpdateNoExist MapUpdateFlags = 1 << (iota - 1)
 	// UpdateExist updates an existing element.
 	UpdateExist
-	// UpdateLock updates elements under bpf_spin_lock.
+	// UpdateLock updates elements under bpf_spin_lock. The value must
+	// contain a struct bpf_spin_lock, which isn't modified.
 	UpdateLock
 )
 
@@ -673,7 +1082,84 @@ func (m *Map) Put(key, value interface{}) error {
 	return m.Update(key, value, UpdateAny)
 }
 
+// PutBytes replaces or creates a value in map, using key and value in the
+// format used by the kernel.
+//
+// Neither key nor value are encoded. value must have the layout returned
+// by LookupBytes, which for per-CPU maps contains an element per possible
+// CPU.
+func (m *Map) PutBytes(key, value []byte) error {
+	if len(key) != int(m.keySize) {
+		return fmt.Errorf("key of %d bytes doesn't match key size %d", len(key), m.keySize)
+	}
+
+	if len(value) != m.fullValueSize {
+		return fmt.Errorf("value of %d bytes doesn't match value size %d", len(value), m.fullValueSize)
+	}
+
+	return m.update(sys.NewSlicePointer(key), sys.NewSlicePointer(value), UpdateAny)
+}
+
+// Push adds a value to a map without keys, like a Queue, Stack or
+// BloomFilter.
+//
+// It is equivalent to calling Update with a nil key and UpdateAny.
+func (m *Map) Push(value interface{}) error {
+	if m.keySize != 0 {
+		return fmt.Errorf("can't push to %s with keys", m.typ)
+	}
+	return m.Update(nil, value, UpdateAny)
+}
+
+// Pop retrieves and removes the next value of a Queue or Stack.
+//
+// Returns ErrKeyNotExist if the map is empty.
+func (m *Map) Pop(valueOut interface{}) error {
+	if m.typ != Queue && m.typ != Stack {
+		return fmt.Errorf("can't pop from %s: %w", m.typ, ErrNotSupported)
+	}
+	return m.LookupAndDelete(nil, valueOut)
+}
+
+// Peek retrieves the next value of a Queue or Stack without removing it.
+//
+// Returns ErrKeyNotExist if the map is empty.
+func (m *Map) Peek(valueOut interface{}) error {
+	if m.typ != Queue && m.typ != Stack {
+		return fmt.Errorf("can't peek at %s: %w", m.typ, ErrNotSupported)
+	}
+	return m.Lookup(nil, valueOut)
+}
+
+// Contains tests whether value is a member of a BloomFilter.
+//
+// A BloomFilter may report false positives, but never false negatives.
+func (m *Map) Contains(value interface{}) (bool, error) {
+	if m.typ != BloomFilter {
+		return false, fmt.Errorf("can't test membership of %s: %w", m.typ, ErrNotSupported)
+	}
+
+	valuePtr, err := m.marshalValue(value)
+	if err != nil {
+		return false, fmt.Errorf("can't marshal value: %w", err)
+	}
+
+	// The kernel reads the value to test from the output buffer.
+	err = m.lookup(nil, valuePtr, 0)
+	if errors.Is(err, ErrKeyNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Update changes the value of a key.
+//
+// The value of an ArrayOfMaps or HashOfMaps is a *Map which must be
+// compatible with the InnerMap of the spec used to create m. Similarly,
+// the value of a ProgramArray is a *Program.
 func (m *Map) Update(key, value interface{}, flags MapUpdateFlags) error {
 	keyPtr, err := m.marshalKey(key)
 	if err != nil {
@@ -685,6 +1171,14 @@ func (m *Map) Update(key, value interface{}, flags MapUpdateFlags) error {
 		return fmt.Errorf("can't marshal value: %w", err)
 	}
 
+	return m.update(keyPtr, valuePtr, flags)
+}
+
+func (m *Map) update(keyPtr, valuePtr sys.Pointer, flags MapUpdateFlags) error {
+	if err := m.checkWritable(); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
 	attr := sys.MapUpdateElemAttr{
 		MapFd: m.fd.Uint(),
 		Key:   keyPtr,
@@ -692,7 +1186,7 @@ func (m *Map) Update(key, value interface{}, flags MapUpdateFlags) error {
 		Flags: uint64(flags),
 	}
 
-	if err = sys.MapUpdateElem(&attr); err != nil {
+	if err := sys.MapUpdateElem(&attr); err != nil {
 		return fmt.Errorf("update: %w", wrapMapError(err))
 	}
 
@@ -703,6 +1197,10 @@ func (m *Map) Update(key, value interface{}, flags MapUpdateFlags) error {
 //
 // Returns ErrKeyNotExist if the key does not exist.
 func (m *Map) Delete(key interface{}) error {
+	if err := m.checkWritable(); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
 	keyPtr, err := m.marshalKey(key)
 	if err != nil {
 		return fmt.Errorf("can't marshal key: %w", err)
@@ -757,6 +1255,10 @@ func (m *Map) NextKeyBytes(key interface{}) ([]byte, error) {
 }
 
 func (m *Map) nextKey(key interface{}, nextKeyOut sys.Pointer) error {
+	if err := m.checkReadable(); err != nil {
+		return fmt.Errorf("next key: %w", err)
+	}
+
 	var (
 		keyPtr sys.Pointer
 		err    error
@@ -843,7 +1345,8 @@ func (m *Map) guessNonExistentKey() ([]byte, error) {
 // BatchLookup looks up many elements in a map at once.
 //
 // "keysOut" and "valuesOut" must be of type slice, a pointer
-// to a slice or buffer will not work.
+// to a slice or buffer will not work. For per-CPU maps, valuesOut must
+// have room for one element per possible CPU for each key, see Map.
 // "prevKey" is the key to start the batch lookup from, it will
 // *not* be included in the results. Use nil to start at the first key.
 //
@@ -873,106 +1376,130 @@ func (m *Map) batchLookup(cmd sys.Cmd, startKey, nextKeyOut, keysOut, valuesOut
 	if err := haveBatchAPI(); err != nil {
 		return 0, err
 	}
-	if m.typ.hasPerCPUValue() {
-		return 0, ErrNotSupported
-	}
-	keysValue := reflect.ValueOf(keysOut)
-	if keysValue.Kind() != reflect.Slice {
-		return 0, fmt.Errorf("keys must be a slice")
-	}
-	valuesValue := reflect.ValueOf(valuesOut)
-	if valuesValue.Kind() != reflect.Slice {
-		return 0, fmt.Errorf("valuesOut must be a slice")
-	}
-	count := keysValue.Len()
-	if count != valuesValue.Len() {
-		return 0, fmt.Errorf("keysOut and valuesOut must be the same length")
+
+	nextBuf := make([]byte, m.keySize)
+	n, sysErr := m.batchLookupRaw(cmd, startKey, nextBuf, int(m.keySize), keysOut, valuesOut, opts)
+	if sysErr != nil && !errors.Is(sysErr, ErrKeyNotExist) {
+		return 0, sysErr
 	}
-	keyBuf := make([]byte, count*int(m.keySize))
-	keyPtr := sys.NewSlicePointer(keyBuf)
-	valueBuf := make([]byte, count*int(m.fullValueSize))
-	valuePtr := sys.NewSlicePointer(valueBuf)
-	nextPtr, nextBuf := makeBuffer(nextKeyOut, int(m.keySize))
 
-	attr := sys.MapLookupBatchAttr{
-		MapFd:    m.fd.Uint(),
-		Keys:     keyPtr,
-		Values:   valuePtr,
-		Count:    uint32(count),
-		OutBatch: nextPtr,
+	if err := m.unmarshalKey(nextKeyOut, nextBuf); err != nil {
+		return 0, err
 	}
 
-	if opts != nil {
-		attr.ElemFlags = opts.ElemFlags
-		attr.Flags = opts.Flags
+	return n, sysErr
+}
+
+// batchLookupRaw executes a batch lookup.
+//
+// startKey is the position to start from, of length tokenSize. next
+// receives the position of the next batch. Returns an error wrapping
+// ErrKeyNotExist and partial results once the end of the map is reached.
+func (m *Map) batchLookupRaw(cmd sys.Cmd, startKey interface{}, next []byte, tokenSize int, keysOut, valuesOut interface{}, opts *BatchOptions) (int, error) {
+	count, err := m.batchCount(keysOut, valuesOut)
+	if err != nil {
+		return 0, err
 	}
+	keyBuf := make([]byte, count*int(m.keySize))
+	valueBuf := make([]byte, count*int(m.fullValueSize))
 
-	var err error
+	var inBatch sys.Pointer
 	if startKey != nil {
-		attr.InBatch, err = marshalPtr(startKey, int(m.keySize))
+		inBatch, err = marshalPtr(startKey, tokenSize)
 		if err != nil {
 			return 0, err
 		}
 	}
 
-	_, sysErr := sys.BPF(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
-	sysErr = wrapMapError(sysErr)
+	n, sysErr := m.batchLookupBuffers(cmd, inBatch, next, keyBuf, valueBuf, count, opts)
 	if sysErr != nil && !errors.Is(sysErr, unix.ENOENT) {
 		return 0, sysErr
 	}
 
-	err = m.unmarshalKey(nextKeyOut, nextBuf)
-	if err != nil {
-		return 0, err
-	}
 	err = unmarshalBytes(keysOut, keyBuf)
 	if err != nil {
 		return 0, err
 	}
-	err = unmarshalBytes(valuesOut, valueBuf)
+	err = m.unmarshalBatchValues(valuesOut, valueBuf)
 	if err != nil {
 		return 0, err
 	}
 
-	return int(attr.Count), sysErr
+	return n, sysErr
 }
 
-// BatchUpdate updates the map with multiple keys and values
-// simultaneously.
-// "keys" and "values" must be of type slice, a pointer
-// to a slice or buffer will not work.
-func (m *Map) BatchUpdate(keys, values interface{}, opts *BatchOptions) (int, error) {
-	if err := haveBatchAPI(); err != nil {
+// batchLookupBuffers executes a batch lookup into pre-allocated buffers.
+//
+// keyBuf and valueBuf must have room for count elements.
+func (m *Map) batchLookupBuffers(cmd sys.Cmd, inBatch sys.Pointer, next, keyBuf, valueBuf []byte, count int, opts *BatchOptions) (int, error) {
+	check := m.checkReadable
+	if cmd == sys.BPF_MAP_LOOKUP_AND_DELETE_BATCH {
+		check = m.checkReadWrite
+	}
+	if err := check(); err != nil {
 		return 0, err
 	}
-	if m.typ.hasPerCPUValue() {
-		return 0, ErrNotSupported
+
+	attr := sys.MapLookupBatchAttr{
+		MapFd:    m.fd.Uint(),
+		Keys:     sys.NewSlicePointer(keyBuf),
+		Values:   sys.NewSlicePointer(valueBuf),
+		Count:    uint32(count),
+		InBatch:  inBatch,
+		OutBatch: sys.NewSlicePointer(next),
 	}
-	keysValue := reflect.ValueOf(keys)
-	if keysValue.Kind() != reflect.Slice {
-		return 0, fmt.Errorf("keys must be a slice")
-	}
-	valuesValue := reflect.ValueOf(values)
-	if valuesValue.Kind() != reflect.Slice {
-		return 0, fmt.Errorf("values must be a slice")
+
+	if opts != nil {
+		attr.ElemFlags = opts.ElemFlags
+		attr.Flags = opts.Flags
 	}
-	var (
-		count    = keysValue.Len()
-		valuePtr sys.Pointer
-		err      error
-	)
-	if count != valuesValue.Len() {
-		return 0, fmt.Errorf("keys and values must be the same length")
+
+	_, err := sys.BPF(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return int(attr.Count), wrapMapError(err)
+}
+
+// BatchUpdate updates the map with multiple keys and values
+// simultaneously.
+// "keys" and "values" must be of type slice, a pointer
+// to a slice or buffer will not work.
+//
+// For per-CPU maps, values must contain one element per possible CPU for
+// each key, see Map.
+//
+// On kernels without support for batch operations, elements are updated
+// one at a time.
+func (m *Map) BatchUpdate(keys, values interface{}, opts *BatchOptions) (int, error) {
+	count, err := m.batchCount(keys, values)
+	if err != nil {
+		return 0, err
 	}
-	keyPtr, err := marshalPtr(keys, count*int(m.keySize))
+	keyBuf, err := marshalBytes(keys, count*int(m.keySize))
 	if err != nil {
 		return 0, err
 	}
-	valuePtr, err = marshalPtr(values, count*int(m.valueSize))
+	valueBuf, err := m.marshalBatchValues(values, count)
 	if err != nil {
 		return 0, err
 	}
 
+	return m.batchUpdate(keyBuf, valueBuf, count, opts)
+}
+
+// batchUpdate updates count elements from encoded keys and values.
+func (m *Map) batchUpdate(keyBuf, valueBuf []byte, count int, opts *BatchOptions) (int, error) {
+	if err := m.checkWritable(); err != nil {
+		return 0, fmt.Errorf("batch update: %w", err)
+	}
+
+	if err := haveBatchAPI(); errors.Is(err, ErrNotSupported) {
+		return m.batchUpdateFallback(keyBuf, valueBuf, count, opts)
+	} else if err != nil {
+		return 0, err
+	}
+
+	keyPtr := sys.NewSlicePointer(keyBuf)
+	valuePtr := sys.NewSlicePointer(valueBuf)
+
 	attr := sys.MapUpdateBatchAttr{
 		MapFd:  m.fd.Uint(),
 		Keys:   keyPtr,
@@ -984,8 +1511,7 @@ func (m *Map) BatchUpdate(keys, values interface{}, opts *BatchOptions) (int, er
 		attr.Flags = opts.Flags
 	}
 
-	err = sys.MapUpdateBatch(&attr)
-	if err != nil {
+	if err := sys.MapUpdateBatch(&attr); err != nil {
 		return int(attr.Count), fmt.Errorf("batch update: %w", wrapMapError(err))
 	}
 
@@ -994,23 +1520,32 @@ func (m *Map) BatchUpdate(keys, values interface{}, opts *BatchOptions) (int, er
 
 // BatchDelete batch deletes entries in the map by keys.
 // "keys" must be of type slice, a pointer to a slice or buffer will not work.
+//
+// On kernels without support for batch operations, elements are deleted
+// one at a time.
 func (m *Map) BatchDelete(keys interface{}, opts *BatchOptions) (int, error) {
-	if err := haveBatchAPI(); err != nil {
-		return 0, err
-	}
-	if m.typ.hasPerCPUValue() {
-		return 0, ErrNotSupported
+	if err := m.checkWritable(); err != nil {
+		return 0, fmt.Errorf("batch delete: %w", err)
 	}
+
 	keysValue := reflect.ValueOf(keys)
 	if keysValue.Kind() != reflect.Slice {
 		return 0, fmt.Errorf("keys must be a slice")
 	}
 	count := keysValue.Len()
-	keyPtr, err := marshalPtr(keys, count*int(m.keySize))
+	keyBuf, err := marshalBytes(keys, count*int(m.keySize))
 	if err != nil {
 		return 0, fmt.Errorf("cannot marshal keys: %v", err)
 	}
 
+	if err := haveBatchAPI(); errors.Is(err, ErrNotSupported) {
+		return m.batchDeleteFallback(keyBuf, count)
+	} else if err != nil {
+		return 0, err
+	}
+
+	keyPtr := sys.NewSlicePointer(keyBuf)
+
 	attr := sys.MapDeleteBatchAttr{
 		MapFd: m.fd.Uint(),
 		Keys:  keyPtr,
@@ -1029,6 +1564,249 @@ func (m *Map) BatchDelete(keys interface{}, opts *BatchOptions) (int, error) {
 	return int(attr.Count), nil
 }
 
+// BatchCursor tracks the position of a batch lookup in a map, which allows
+// resuming the lookup with subsequent calls.
+//
+// The zero value starts at the beginning of the map. A BatchCursor may only
+// be used with a single map.
+type BatchCursor struct {
+	m *Map
+	// Opaque position returned by the kernel, or the last key if batch
+	// operations are emulated. Nil at the beginning of the map.
+	opaque []byte
+	done   bool
+}
+
+// BatchLookupWithCursor looks up many elements in a map at once, starting
+// at the position of cursor.
+//
+// "keysOut" and "valuesOut" must be slices of the same length, which
+// determines the maximum number of elements returned. For per-CPU maps,
+// valuesOut must have room for one element per possible CPU for each key,
+// see Map. The cursor is advanced past the returned elements.
+//
+// ErrKeyNotExist is returned once the end of the map is reached, even when
+// partial results are returned. Hash maps return ENOSPC if a single hash
+// bucket contains more elements than fit into keysOut, in which case the
+// lookup can be retried with larger slices.
+//
+// On kernels without support for batch operations the lookup is emulated
+// using NextKey and Lookup. Concurrent modifications to the map may then
+// cause elements to be skipped or returned more than once.
+func (m *Map) BatchLookupWithCursor(cursor *BatchCursor, keysOut, valuesOut interface{}, opts *BatchOptions) (int, error) {
+	return m.batchLookupCursor(sys.BPF_MAP_LOOKUP_BATCH, cursor, keysOut, valuesOut, opts)
+}
+
+// BatchLookupAndDeleteWithCursor looks up many elements in a map at once
+// and deletes them, starting at the position of cursor.
+//
+// See BatchLookupWithCursor for details.
+func (m *Map) BatchLookupAndDeleteWithCursor(cursor *BatchCursor, keysOut, valuesOut interface{}, opts *BatchOptions) (int, error) {
+	return m.batchLookupCursor(sys.BPF_MAP_LOOKUP_AND_DELETE_BATCH, cursor, keysOut, valuesOut, opts)
+}
+
+func (m *Map) batchLookupCursor(cmd sys.Cmd, cursor *BatchCursor, keysOut, valuesOut interface{}, opts *BatchOptions) (int, error) {
+	if cursor.m == nil {
+		cursor.m = m
+	} else if cursor.m != m {
+		return 0, errors.New("cursor belongs to a different map")
+	}
+
+	if cursor.done {
+		return 0, ErrKeyNotExist
+	}
+
+	if err := haveBatchAPI(); errors.Is(err, ErrNotSupported) {
+		return m.batchLookupFallback(cmd, cursor, keysOut, valuesOut, opts)
+	} else if err != nil {
+		return 0, err
+	}
+
+	tokenSize := m.batchTokenSize()
+
+	var startKey interface{}
+	if cursor.opaque != nil {
+		startKey = cursor.opaque
+	}
+
+	next := make([]byte, tokenSize)
+	n, err := m.batchLookupRaw(cmd, startKey, next, tokenSize, keysOut, valuesOut, opts)
+	if errors.Is(err, ErrKeyNotExist) {
+		cursor.done = true
+	} else if err != nil {
+		return 0, err
+	}
+
+	cursor.opaque = next
+	return n, err
+}
+
+// batchTokenSize returns the size of the position returned by batch
+// lookups.
+func (m *Map) batchTokenSize() int {
+	// Hash maps return a four byte bucket index instead of a key.
+	if m.keySize < 4 {
+		return 4
+	}
+	return int(m.keySize)
+}
+
+// batchLookupFallback emulates a batch lookup using single element
+// operations.
+func (m *Map) batchLookupFallback(cmd sys.Cmd, cursor *BatchCursor, keysOut, valuesOut interface{}, opts *BatchOptions) (int, error) {
+	count, err := m.batchCount(keysOut, valuesOut)
+	if err != nil {
+		return 0, err
+	}
+
+	var flags MapLookupFlags
+	if opts != nil {
+		flags = MapLookupFlags(opts.ElemFlags)
+	}
+
+	keySize, valueSize := int(m.keySize), m.fullValueSize
+	keyBuf := make([]byte, count*keySize)
+	valueBuf := make([]byte, count*valueSize)
+
+	var (
+		prev  = cursor.opaque
+		n     int
+		first = prev == nil
+	)
+	for n < count {
+		key := keyBuf[n*keySize : (n+1)*keySize]
+
+		var prevKey interface{}
+		if !first {
+			prevKey = prev
+		}
+
+		err := m.nextKey(prevKey, sys.NewSlicePointer(key))
+		if errors.Is(err, ErrKeyNotExist) {
+			cursor.done = true
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		first = false
+		prev = append(prev[:0], key...)
+
+		value := valueBuf[n*valueSize : (n+1)*valueSize]
+		err = m.lookup(key, sys.NewSlicePointer(value), flags)
+		if errors.Is(err, ErrKeyNotExist) {
+			// The element was deleted concurrently.
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if cmd == sys.BPF_MAP_LOOKUP_AND_DELETE_BATCH {
+			if err := m.Delete(key); err != nil && !errors.Is(err, ErrKeyNotExist) {
+				return 0, err
+			}
+			// The deleted key can't be used to find the next one.
+			// Since all elements up to here have been deleted, start
+			// over instead.
+			first = true
+		}
+
+		n++
+	}
+
+	cursor.opaque = prev
+
+	if err := unmarshalBytes(keysOut, keyBuf); err != nil {
+		return 0, err
+	}
+	if err := m.unmarshalBatchValues(valuesOut, valueBuf); err != nil {
+		return 0, err
+	}
+
+	if cursor.done {
+		return n, fmt.Errorf("batch lookup: %w", ErrKeyNotExist)
+	}
+	return n, nil
+}
+
+func (m *Map) batchUpdateFallback(keyBuf, valueBuf []byte, count int, opts *BatchOptions) (int, error) {
+	var flags MapUpdateFlags
+	if opts != nil {
+		flags = MapUpdateFlags(opts.ElemFlags)
+	}
+
+	keySize, valueSize := int(m.keySize), m.fullValueSize
+	for i := 0; i < count; i++ {
+		key := sys.NewSlicePointer(keyBuf[i*keySize : (i+1)*keySize])
+		value := sys.NewSlicePointer(valueBuf[i*valueSize : (i+1)*valueSize])
+		if err := m.update(key, value, flags); err != nil {
+			return i, fmt.Errorf("batch update: %w", err)
+		}
+	}
+
+	return count, nil
+}
+
+func (m *Map) batchDeleteFallback(keyBuf []byte, count int) (int, error) {
+	keySize := int(m.keySize)
+	for i := 0; i < count; i++ {
+		if err := m.Delete(keyBuf[i*keySize : (i+1)*keySize]); err != nil {
+			return i, fmt.Errorf("batch delete: %w", err)
+		}
+	}
+
+	return count, nil
+}
+
+// batchCount returns the number of elements in keys. values must be a slice
+// with the same length, or one element per possible CPU for each key for
+// per-CPU maps.
+func (m *Map) batchCount(keys, values interface{}) (int, error) {
+	keysValue := reflect.ValueOf(keys)
+	if keysValue.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("keys must be a slice")
+	}
+	valuesValue := reflect.ValueOf(values)
+	if valuesValue.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("values must be a slice")
+	}
+
+	perKey := 1
+	if m.typ.hasPerCPUValue() {
+		var err error
+		perKey, err = internal.PossibleCPUs()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if keysValue.Len()*perKey != valuesValue.Len() {
+		if perKey > 1 {
+			return 0, fmt.Errorf("values must have %d elements per key", perKey)
+		}
+		return 0, fmt.Errorf("keys and values must be the same length")
+	}
+	return keysValue.Len(), nil
+}
+
+// marshalBatchValues encodes the values of count elements.
+func (m *Map) marshalBatchValues(values interface{}, count int) ([]byte, error) {
+	if m.typ.hasPerCPUValue() {
+		return marshalPerCPUSlice(values, int(m.valueSize))
+	}
+	return marshalBytes(values, count*int(m.valueSize))
+}
+
+// unmarshalBatchValues decodes the values of a batch lookup.
+func (m *Map) unmarshalBatchValues(valuesOut interface{}, buf []byte) error {
+	if m.typ.hasPerCPUValue() {
+		return unmarshalPerCPUSlice(valuesOut, int(m.valueSize), buf)
+	}
+	return unmarshalBytes(valuesOut, buf)
+}
+
 // Iterate traverses a map.
 //
 // It's safe to create multiple iterators at the same time.
@@ -1040,6 +1818,9 @@ func (m *Map) Iterate() *MapIterator {
 }
 
 // Close removes a Map
+//
+// Closing a Map more than once has no effect. Use Clone to obtain a
+// handle with an independent lifetime instead of sharing a Map.
 func (m *Map) Close() error {
 	if m == nil {
 		// This makes it easier to clean up when iterating maps
@@ -1059,6 +1840,7 @@ func (m *Map) FD() int {
 
 // Clone creates a duplicate of the Map.
 //
+// The duplicate refers to the same kernel object via a new file descriptor.
 // Closing the duplicate does not affect the original, and vice versa.
 // Changes made to the map are reflected by both instances however.
 // If the original map was pinned, the cloned map will not be pinned by default.
@@ -1084,9 +1866,116 @@ func (m *Map) Clone() (*Map, error) {
 		m.flags,
 		"",
 		m.fullValueSize,
+		m.access,
 	}, nil
 }
 
+// Resize creates a copy of m with room for maxEntries elements.
+//
+// All entries of m are copied to the new map. The copy isn't pinned and any
+// pin of m is left untouched, see ResizeAndRepin.
+//
+// Programs using m aren't affected, and entries modified by them while
+// copying may be lost. m remains valid and must still be closed. Only maps
+// which store plain data and don't require BTF can be resized. Maps can't be
+// shrunk, since entries may not fit into the copy.
+func (m *Map) Resize(maxEntries uint32) (*Map, error) {
+	resized, err := m.resize(maxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("resize: %w", err)
+	}
+	return resized, nil
+}
+
+// ResizeAndRepin is like Resize, except that the copy atomically replaces
+// the pin of m if m is pinned. m is no longer considered pinned afterwards.
+func (m *Map) ResizeAndRepin(maxEntries uint32) (*Map, error) {
+	resized, err := m.resize(maxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("resize: %w", err)
+	}
+
+	if m.pinnedPath != "" {
+		if err := internal.ReplacePin(m.pinnedPath, resized.fd); err != nil {
+			resized.Close()
+			return nil, fmt.Errorf("resize: replace pin: %w", err)
+		}
+
+		resized.pinnedPath = m.pinnedPath
+		m.pinnedPath = ""
+	}
+
+	return resized, nil
+}
+
+func (m *Map) resize(maxEntries uint32) (*Map, error) {
+	if !m.typ.canSnapshot() {
+		return nil, fmt.Errorf("%s: %w", m.typ, ErrNotSupported)
+	}
+
+	if maxEntries < m.maxEntries {
+		return nil, fmt.Errorf("can't shrink map from %d to %d entries", m.maxEntries, maxEntries)
+	}
+
+	resized, err := NewMap(&MapSpec{
+		Name:       m.name,
+		Type:       m.typ,
+		KeySize:    m.keySize,
+		ValueSize:  m.valueSize,
+		MaxEntries: maxEntries,
+		Flags:      m.flags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.copyTo(resized); err != nil {
+		resized.Close()
+		return nil, err
+	}
+
+	return resized, nil
+}
+
+// copyTo copies all entries of m to dst, which must have the same key and
+// value size.
+func (m *Map) copyTo(dst *Map) error {
+	const batchSize = 64
+
+	var (
+		keySize, valueSize = int(m.keySize), m.fullValueSize
+		keyBuf             = make([]byte, batchSize*keySize)
+		valueBuf           = make([]byte, batchSize*valueSize)
+		n                  int
+		key                []byte
+		iter               = m.Iterate()
+	)
+
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		_, err := dst.batchUpdate(keyBuf[:n*keySize], valueBuf[:n*valueSize], n, nil)
+		n = 0
+		return err
+	}
+
+	for iter.Next(&key, unsafe.Pointer(&valueBuf[n*valueSize])) {
+		copy(keyBuf[n*keySize:], key)
+		if n++; n == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
 // Pin persists the map on the BPF virtual file system past the lifetime of
 // the process that created it .
 //
@@ -1116,6 +2005,32 @@ func (m *Map) Unpin() error {
 	return nil
 }
 
+// checkReadable returns an error if the fd of m was opened write-only.
+//
+// The kernel rejects such operations with EPERM, the returned error does
+// the same without issuing a syscall.
+func (m *Map) checkReadable() error {
+	if m.access == unix.O_WRONLY {
+		return fmt.Errorf("map is write-only: %w", unix.EPERM)
+	}
+	return nil
+}
+
+// checkWritable returns an error if the fd of m was opened read-only.
+func (m *Map) checkWritable() error {
+	if m.access == unix.O_RDONLY {
+		return fmt.Errorf("map is read-only: %w", unix.EPERM)
+	}
+	return nil
+}
+
+func (m *Map) checkReadWrite() error {
+	if err := m.checkReadable(); err != nil {
+		return err
+	}
+	return m.checkWritable()
+}
+
 // IsPinned returns true if the map has a non-empty pinned path.
 func (m *Map) IsPinned() bool {
 	return m.pinnedPath != ""
@@ -1125,6 +2040,10 @@ func (m *Map) IsPinned() bool {
 //
 // It makes no changes to kernel-side restrictions.
 func (m *Map) Freeze() error {
+	if err := m.checkWritable(); err != nil {
+		return fmt.Errorf("can't freeze map: %w", err)
+	}
+
 	if err := haveMapMutabilityModifiers(); err != nil {
 		return fmt.Errorf("can't freeze map: %w", err)
 	}
@@ -1320,19 +2239,49 @@ type MapIterator struct {
 	count, maxEntries uint32
 	done              bool
 	err               error
+
+	// Hash maps are iterated in batches if possible.
+	batch        bool
+	batchSize    int
+	batchToken   []byte
+	batchLast    bool
+	keys, values []byte
+	pos, n       int
+
+	// Set by IterateSnapshot until the entries have been read.
+	snapshot bool
 }
 
+// iterateBatchSize is the initial number of elements fetched at once when
+// iterating a hash map.
+const iterateBatchSize = 64
+
 func newMapIterator(target *Map) *MapIterator {
-	return &MapIterator{
+	mi := &MapIterator{
 		target:     target,
 		maxEntries: target.maxEntries,
 		prevBytes:  make([]byte, target.keySize),
 	}
+
+	if (target.typ == Hash || target.typ == LRUHash) && haveBatchAPI() == nil {
+		mi.batch = true
+		mi.batchSize = iterateBatchSize
+		if mi.batchSize > int(target.maxEntries) {
+			mi.batchSize = int(target.maxEntries)
+		}
+	}
+
+	return mi
 }
 
 // Next decodes the next key and value.
 //
-// Iterating a hash map from which keys are being deleted is not
+// On kernels which support batch operations (Linux 5.6), hash maps are
+// read one hash bucket at a time. Each key which is present for the whole
+// iteration is returned exactly once, even if other keys are concurrently
+// added or deleted.
+//
+// Otherwise, iterating a hash map from which keys are being deleted is not
 // safe. You may see the same key multiple times. Iteration may
 // also abort with an error, see IsIterationAborted.
 //
@@ -1345,6 +2294,14 @@ func (mi *MapIterator) Next(keyOut, valueOut interface{}) bool {
 		return false
 	}
 
+	if mi.snapshot {
+		return mi.nextFromSnapshot(keyOut, valueOut)
+	}
+
+	if mi.batch {
+		return mi.nextFromBatch(keyOut, valueOut)
+	}
+
 	// For array-like maps NextKeyBytes returns nil only on after maxEntries
 	// iterations.
 	for mi.count <= mi.maxEntries {
@@ -1391,6 +2348,77 @@ func (mi *MapIterator) Next(keyOut, valueOut interface{}) bool {
 	return false
 }
 
+func (mi *MapIterator) nextFromBatch(keyOut, valueOut interface{}) bool {
+	for mi.pos >= mi.n {
+		if mi.batchLast {
+			mi.done = true
+			return false
+		}
+
+		mi.err = mi.nextBatch()
+		if errors.Is(mi.err, ErrNotSupported) && mi.batchToken == nil {
+			// The map doesn't support batch lookups after all.
+			mi.batch, mi.err = false, nil
+			return mi.Next(keyOut, valueOut)
+		}
+		if mi.err != nil {
+			return false
+		}
+	}
+
+	keySize, valueSize := int(mi.target.keySize), int(mi.target.fullValueSize)
+	key := mi.keys[mi.pos*keySize : (mi.pos+1)*keySize]
+	value := mi.values[mi.pos*valueSize : (mi.pos+1)*valueSize]
+	mi.pos++
+
+	if mi.err = mi.target.unmarshalValue(valueOut, value); mi.err != nil {
+		return false
+	}
+
+	mi.err = mi.target.unmarshalKey(keyOut, key)
+	return mi.err == nil
+}
+
+// nextBatch fetches the next batch of elements.
+//
+// The batch size is increased if a hash bucket doesn't fit.
+func (mi *MapIterator) nextBatch() error {
+	m := mi.target
+
+	var inBatch sys.Pointer
+	if mi.batchToken != nil {
+		inBatch = sys.NewSlicePointer(mi.batchToken)
+	}
+
+	for {
+		// Allocate new buffers, since unmarshaling into a []byte
+		// doesn't copy.
+		keys := make([]byte, mi.batchSize*int(m.keySize))
+		values := make([]byte, mi.batchSize*int(m.fullValueSize))
+		next := make([]byte, m.batchTokenSize())
+
+		n, err := m.batchLookupBuffers(sys.BPF_MAP_LOOKUP_BATCH, inBatch, next, keys, values, mi.batchSize, nil)
+		if errors.Is(err, unix.ENOSPC) && mi.batchSize < int(mi.maxEntries) {
+			mi.batchSize *= 2
+			if mi.batchSize > int(mi.maxEntries) {
+				mi.batchSize = int(mi.maxEntries)
+			}
+			continue
+		}
+
+		if errors.Is(err, ErrKeyNotExist) {
+			mi.batchLast = true
+		} else if err != nil {
+			return fmt.Errorf("batch lookup: %w", err)
+		}
+
+		mi.keys, mi.values = keys, values
+		mi.pos, mi.n = 0, n
+		mi.batchToken = next
+		return nil
+	}
+}
+
 // Err returns any encountered error.
 //
 // The method must be called after Next returns nil.
@@ -1402,6 +2430,10 @@ func (mi *MapIterator) Err() error {
 
 // MapGetNextID returns the ID of the next eBPF map.
 //
+// Pass MapID(0) to get the first map. Together with NewMapFromID this
+// allows enumerating all maps on the system, including maps created by
+// other processes. Requires CAP_SYS_ADMIN.
+//
 // Returns ErrNotExist, if there is no next eBPF map.
 func MapGetNextID(startID MapID) (MapID, error) {
 	attr := &sys.MapGetNextIdAttr{Id: uint32(startID)}
@@ -1410,6 +2442,10 @@ func MapGetNextID(startID MapID) (MapID, error) {
 
 // NewMapFromID returns the map for a given id.
 //
+// The type, key and value size of the map are queried from the kernel,
+// so the map can be used like a map created by this process. Requires
+// CAP_SYS_ADMIN.
+//
 // Returns ErrNotExist, if there is no eBPF map with the given id.
 func NewMapFromID(id MapID) (*Map, error) {
 	fd, err := sys.MapGetFdById(&sys.MapGetFdByIdAttr{
@@ -0,0 +1,172 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// IterateSnapshot traverses a map using a kernel map iterator (bpf_iter).
+//
+// All entries are read from the kernel by the first call to Next, in a
+// single pass over the map. Contrary to Iterate, hash maps are traversed
+// bucket by bucket without looking up keys, so iteration never restarts
+// or aborts when keys are concurrently deleted. Entries which are added
+// or removed during that pass may or may not be returned.
+//
+// Falls back to the behaviour of Iterate if the kernel doesn't support
+// map iterators (Linux 5.9) or the map type can't be iterated this way.
+func (m *Map) IterateSnapshot() *MapIterator {
+	mi := newMapIterator(m)
+	if m.typ.canIterateElems() {
+		mi.snapshot = true
+	}
+	return mi
+}
+
+// canIterateElems returns true if the map type supports the bpf_map_elem
+// iterator.
+func (mt MapType) canIterateElems() bool {
+	switch mt {
+	case Hash, Array, PerCPUHash, PerCPUArray, LRUHash, LRUCPUHash:
+		return true
+	}
+	return false
+}
+
+// nextFromSnapshot reads all entries via bpf_iter on the first call and
+// returns them one at a time afterwards.
+func (mi *MapIterator) nextFromSnapshot(keyOut, valueOut interface{}) bool {
+	mi.snapshot = false
+
+	keys, values, n, err := mi.target.readElems()
+	if errors.Is(err, ErrNotSupported) {
+		return mi.Next(keyOut, valueOut)
+	}
+	if err != nil {
+		mi.err = fmt.Errorf("iterate snapshot: %w", err)
+		return false
+	}
+
+	// Serve the entries like the last batch of a batch lookup.
+	mi.batch, mi.batchLast = true, true
+	mi.keys, mi.values = keys, values
+	mi.pos, mi.n = 0, n
+	return mi.nextFromBatch(keyOut, valueOut)
+}
+
+// readElems copies all keys and values of m using a map element iterator.
+//
+// Returns ErrNotSupported if the kernel doesn't support map element iterators.
+func (m *Map) readElems() (keys, values []byte, n int, err error) {
+	prog, err := newMapElemIterProgram(m.keySize, uint32(m.fullValueSize))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer prog.Close()
+
+	info := struct{ mapFd uint32 }{m.fd.Uint()}
+	link, err := sys.LinkCreateIter(&sys.LinkCreateIterAttr{
+		ProgFd:      uint32(prog.FD()),
+		AttachType:  sys.AttachType(AttachTraceIter),
+		IterInfo:    sys.NewPointer(unsafe.Pointer(&info)),
+		IterInfoLen: uint32(unsafe.Sizeof(info)),
+	})
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("create iterator link: %w", err)
+	}
+	defer link.Close()
+
+	fd, err := sys.IterCreate(&sys.IterCreateAttr{LinkFd: link.Uint()})
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("create iterator: %w", err)
+	}
+
+	f := fd.File("bpf_map_elem iter")
+	defer f.Close()
+
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("read iterator: %w", err)
+	}
+
+	keySize, valueSize := int(m.keySize), m.fullValueSize
+	if len(buf)%(keySize+valueSize) != 0 {
+		return nil, nil, 0, fmt.Errorf("iterator output of %d bytes isn't a multiple of the entry size", len(buf))
+	}
+
+	// Split the interleaved output into keys and values, the layout used
+	// by batch lookups.
+	n = len(buf) / (keySize + valueSize)
+	keys = make([]byte, 0, n*keySize)
+	values = make([]byte, 0, n*valueSize)
+	for off := 0; off < len(buf); off += keySize + valueSize {
+		keys = append(keys, buf[off:off+keySize]...)
+		values = append(values, buf[off+keySize:off+keySize+valueSize]...)
+	}
+
+	return keys, values, n, nil
+}
+
+// newMapElemIterProgram loads a bpf_map_elem iterator which writes the
+// raw key and value of each element to the seq_file.
+func newMapElemIterProgram(keySize, valueSize uint32) (*Program, error) {
+	// Offsets into struct bpf_iter__bpf_map_elem.
+	const (
+		metaOff  = 0
+		keyOff   = 16
+		valueOff = 24
+	)
+
+	spec := &ProgramSpec{
+		Name:       "map_elem_iter",
+		Type:       Tracing,
+		AttachType: AttachTraceIter,
+		AttachTo:   "bpf_map_elem",
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.LoadMem(asm.R6, asm.R1, metaOff, asm.DWord),
+			asm.LoadMem(asm.R7, asm.R1, keyOff, asm.DWord),
+			asm.LoadMem(asm.R8, asm.R1, valueOff, asm.DWord),
+			// key and value are NULL after the last element.
+			asm.JEq.Imm(asm.R7, 0, "exit"),
+			asm.JEq.Imm(asm.R8, 0, "exit"),
+			// bpf_seq_write(meta->seq, key, keySize)
+			asm.LoadMem(asm.R1, asm.R6, 0, asm.DWord),
+			asm.Mov.Reg(asm.R2, asm.R7),
+			asm.Mov.Imm(asm.R3, int32(keySize)),
+			asm.FnSeqWrite.Call(),
+			asm.JNE.Imm(asm.R0, 0, "exit"),
+			// bpf_seq_write(meta->seq, value, valueSize)
+			asm.LoadMem(asm.R1, asm.R6, 0, asm.DWord),
+			asm.Mov.Reg(asm.R2, asm.R8),
+			asm.Mov.Imm(asm.R3, int32(valueSize)),
+			asm.FnSeqWrite.Call(),
+			asm.Mov.Imm(asm.R0, 0).Sym("exit"),
+			asm.Return(),
+		},
+	}
+
+	prog, err := NewProgram(spec)
+	if err == nil {
+		return prog, nil
+	}
+
+	// A missing bpf_map_elem target or kernel BTF already wraps ErrNotSupported.
+	// Kernels without tracing programs reject the program with EINVAL before
+	// running the verifier, so the log is empty. Other errors, like EPERM or
+	// a verifier rejection, are passed through.
+	var ve *internal.VerifierError
+	if !errors.Is(err, ErrNotSupported) && errors.Is(err, unix.EINVAL) &&
+		errors.As(err, &ve) && strings.Join(ve.Log, "") == "" {
+		return nil, fmt.Errorf("load iterator program: %s: %w", err, ErrNotSupported)
+	}
+	return nil, fmt.Errorf("load iterator program: %w", err)
+}
@@ -0,0 +1,107 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestMapIterateSnapshot(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.9", "bpf_map_elem iter")
+
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []MapType{Hash, PerCPUHash, Array} {
+		t.Run(typ.String(), func(t *testing.T) {
+			m, err := NewMap(&MapSpec{
+				Type:       typ,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 10,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			values := make([]uint32, possibleCPUs)
+			for i := uint32(0); i < 10; i++ {
+				for cpu := range values {
+					values[cpu] = i*100 + uint32(cpu)
+				}
+
+				var value interface{} = values[0]
+				if typ.hasPerCPUValue() {
+					value = values
+				}
+				if err := m.Put(i, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var (
+				key, n uint32
+				value  = make([]uint32, possibleCPUs)
+				iter   = m.IterateSnapshot()
+			)
+			valueOut := interface{}(&value[0])
+			if typ.hasPerCPUValue() {
+				valueOut = &value
+			}
+			for iter.Next(&key, valueOut) {
+				for cpu, v := range value {
+					if v != key*100+uint32(cpu) {
+						t.Errorf("Key %d has value %d on CPU %d", key, v, cpu)
+					}
+					if !typ.hasPerCPUValue() {
+						break
+					}
+				}
+				n++
+			}
+			if err := iter.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != 10 {
+				t.Errorf("Iterated %d entries instead of 10", n)
+			}
+
+			// Make sure the entries can be read via bpf_iter, instead of
+			// having fallen back to syscall iteration.
+			_, _, n2, err := m.readElems()
+			testutils.SkipIfNotSupported(t, err)
+			if err != nil {
+				t.Fatal("Can't read elements:", err)
+			}
+			if n2 != 10 {
+				t.Errorf("Read %d elements instead of 10", n2)
+			}
+		})
+	}
+}
+
+func TestMapIterateSnapshotFallback(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	var key, value uint32
+	iter := m.IterateSnapshot()
+	for iter.Next(&key, &value) {
+		t.Error("Empty ProgramArray returned an entry")
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
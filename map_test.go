@@ -1,6 +1,7 @@
 package ebpf
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math"
@@ -213,6 +214,237 @@ func TestBatchAPIHash(t *testing.T) {
 	}
 }
 
+func TestBatchCursor(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fallback=%t", fallback), func(t *testing.T) {
+			if fallback {
+				defer func(fn func() error) { haveBatchAPI = fn }(haveBatchAPI)
+				haveBatchAPI = func() error { return internal.ErrNotSupported }
+			} else if err := haveBatchAPI(); err != nil {
+				t.Skipf("batch api not available: %v", err)
+			}
+
+			for _, typ := range []MapType{Hash, Array} {
+				t.Run(typ.String(), func(t *testing.T) {
+					testBatchCursor(t, typ)
+				})
+			}
+		})
+	}
+}
+
+func testBatchCursor(t *testing.T, typ MapType) {
+	const entries = 10
+
+	// Use a key size smaller than the batch token of hash maps.
+	keySize := uint32(2)
+	makeKeys := func(n int) interface{} { return make([]uint16, n) }
+	keyAt := func(keys interface{}, i int) uint32 { return uint32(keys.([]uint16)[i]) }
+	if typ == Array {
+		keySize = 4
+		makeKeys = func(n int) interface{} { return make([]uint32, n) }
+		keyAt = func(keys interface{}, i int) uint32 { return keys.([]uint32)[i] }
+	}
+
+	m, err := NewMap(&MapSpec{
+		Type:       typ,
+		KeySize:    keySize,
+		ValueSize:  4,
+		MaxEntries: entries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	keys := makeKeys(entries)
+	values := make([]uint32, entries)
+	for i := range values {
+		reflect.ValueOf(keys).Index(i).SetUint(uint64(i))
+		values[i] = uint32(i) * 10
+	}
+
+	count, err := m.BatchUpdate(keys, values, nil)
+	if err != nil {
+		t.Fatal("BatchUpdate:", err)
+	}
+	if count != entries {
+		t.Fatalf("BatchUpdate: updated %d elements instead of %d", count, entries)
+	}
+
+	var (
+		cursor BatchCursor
+		seen   = make(map[uint32]bool)
+	)
+	for i := 0; ; i++ {
+		if i > entries {
+			t.Fatal("Cursor doesn't terminate")
+		}
+
+		// Hash maps return a whole bucket at a time, make sure that
+		// it's likely to fit.
+		keysOut := makeKeys(5)
+		valuesOut := make([]uint32, 5)
+		n, err := m.BatchLookupWithCursor(&cursor, keysOut, valuesOut, nil)
+		if err != nil && !errors.Is(err, ErrKeyNotExist) {
+			t.Fatal("BatchLookupWithCursor:", err)
+		}
+
+		for j := 0; j < n; j++ {
+			key := keyAt(keysOut, j)
+			if valuesOut[j] != key*10 {
+				t.Errorf("Key %d has value %d", key, valuesOut[j])
+			}
+			if seen[key] {
+				t.Errorf("Key %d returned twice", key)
+			}
+			seen[key] = true
+		}
+
+		if errors.Is(err, ErrKeyNotExist) {
+			break
+		}
+	}
+
+	if len(seen) != entries {
+		t.Errorf("Cursor returned %d elements instead of %d", len(seen), entries)
+	}
+
+	if _, err := m.BatchLookupWithCursor(&cursor, makeKeys(1), make([]uint32, 1), nil); !errors.Is(err, ErrKeyNotExist) {
+		t.Error("Exhausted cursor doesn't return ErrKeyNotExist:", err)
+	}
+
+	other, err := NewMap(&MapSpec{Type: typ, KeySize: keySize, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	if _, err := other.BatchLookupWithCursor(&cursor, makeKeys(1), make([]uint32, 1), nil); err == nil {
+		t.Error("Cursor can be used with a different map")
+	}
+
+	if typ != Hash {
+		return
+	}
+
+	cursor = BatchCursor{}
+	n, err := m.BatchLookupAndDeleteWithCursor(&cursor, makeKeys(entries), make([]uint32, entries), nil)
+	if err != nil && !errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("BatchLookupAndDeleteWithCursor:", err)
+	}
+	if n != entries {
+		t.Errorf("BatchLookupAndDeleteWithCursor returned %d elements instead of %d", n, entries)
+	}
+
+	if key, err := m.NextKeyBytes(nil); err != nil {
+		t.Fatal(err)
+	} else if key != nil {
+		t.Error("BatchLookupAndDeleteWithCursor didn't delete all elements")
+	}
+
+	count, err = m.BatchDelete([]uint16{0}, nil)
+	if !errors.Is(err, ErrKeyNotExist) {
+		t.Error("BatchDelete of missing key doesn't return ErrKeyNotExist:", err)
+	}
+	if count != 0 {
+		t.Error("BatchDelete of missing key returns count", count)
+	}
+}
+
+func TestBatchPerCPU(t *testing.T) {
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fallback=%t", fallback), func(t *testing.T) {
+			if fallback {
+				defer func(fn func() error) { haveBatchAPI = fn }(haveBatchAPI)
+				haveBatchAPI = func() error { return internal.ErrNotSupported }
+			} else if err := haveBatchAPI(); err != nil {
+				t.Skipf("batch api not available: %v", err)
+			}
+
+			for _, typ := range []MapType{PerCPUHash, PerCPUArray} {
+				t.Run(typ.String(), func(t *testing.T) {
+					const entries = 4
+
+					// The value size isn't a multiple of 8 to exercise padding.
+					m, err := NewMap(&MapSpec{
+						Type:       typ,
+						KeySize:    4,
+						ValueSize:  3,
+						MaxEntries: entries,
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer m.Close()
+
+					keys := make([]uint32, entries)
+					values := make([][3]byte, entries*possibleCPUs)
+					for i := range keys {
+						keys[i] = uint32(i)
+						for cpu := 0; cpu < possibleCPUs; cpu++ {
+							values[i*possibleCPUs+cpu] = [3]byte{byte(i), byte(cpu), 0xff}
+						}
+					}
+
+					if _, err := m.BatchUpdate(keys, values[:possibleCPUs], nil); err == nil {
+						t.Error("BatchUpdate accepts a single value per key")
+					}
+
+					if _, err := m.BatchUpdate(keys, values, nil); err != nil {
+						t.Fatal("BatchUpdate:", err)
+					}
+
+					var perCPU [][3]byte
+					if err := m.Lookup(uint32(1), &perCPU); err != nil {
+						t.Fatal("Lookup:", err)
+					}
+					for cpu, value := range perCPU {
+						if value != values[possibleCPUs+cpu] {
+							t.Errorf("Lookup: CPU %d has value %v", cpu, value)
+						}
+					}
+
+					var cursor BatchCursor
+					keysOut := make([]uint32, entries)
+					valuesOut := make([][3]byte, entries*possibleCPUs)
+					n, err := m.BatchLookupWithCursor(&cursor, keysOut, valuesOut, nil)
+					if err != nil && !errors.Is(err, ErrKeyNotExist) {
+						t.Fatal("BatchLookupWithCursor:", err)
+					}
+					if n != entries {
+						t.Fatalf("BatchLookupWithCursor returned %d elements instead of %d", n, entries)
+					}
+
+					for i, key := range keysOut {
+						for cpu := 0; cpu < possibleCPUs; cpu++ {
+							want := [3]byte{byte(key), byte(cpu), 0xff}
+							if have := valuesOut[i*possibleCPUs+cpu]; have != want {
+								t.Errorf("Key %d on CPU %d has value %v, expected %v", key, cpu, have, want)
+							}
+						}
+					}
+
+					if typ != PerCPUHash {
+						return
+					}
+
+					if n, err := m.BatchDelete(keys, nil); err != nil {
+						t.Fatal("BatchDelete:", err)
+					} else if n != entries {
+						t.Errorf("BatchDelete deleted %d elements instead of %d", n, entries)
+					}
+				})
+			}
+		})
+	}
+}
+
 func TestBatchAPIMapDelete(t *testing.T) {
 	if err := haveBatchAPI(); err != nil {
 		t.Skipf("batch api not available: %v", err)
@@ -408,6 +640,197 @@ func TestMapCloneNil(t *testing.T) {
 	}
 }
 
+func TestMapCloneClose(t *testing.T) {
+	m := createArray(t)
+	defer m.Close()
+
+	clone, err := m.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clone.FD() == m.FD() {
+		t.Fatal("Clone shares the file descriptor of the original")
+	}
+
+	if err := clone.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Close(); err != nil {
+		t.Error("Closing a map twice returns an error:", err)
+	}
+
+	if err := m.Put(uint32(0), uint32(1)); err != nil {
+		t.Error("Original map is unusable after closing the clone:", err)
+	}
+
+	if _, err := clone.Clone(); err == nil {
+		t.Error("Cloning a closed map doesn't return an error")
+	}
+}
+
+func TestMapResize(t *testing.T) {
+	for _, typ := range []MapType{Hash, PerCPUArray} {
+		t.Run(typ.String(), func(t *testing.T) {
+			m, err := NewMap(&MapSpec{
+				Type:       typ,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 2,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			values := func(v uint32) interface{} {
+				if !typ.hasPerCPUValue() {
+					return v
+				}
+				cpus, err := internal.PossibleCPUs()
+				if err != nil {
+					t.Fatal(err)
+				}
+				vs := make([]uint32, cpus)
+				for i := range vs {
+					vs[i] = v + uint32(i)
+				}
+				return vs
+			}
+
+			for i := uint32(0); i < 2; i++ {
+				if err := m.Put(i, values(i*10)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := m.Resize(1); err == nil {
+				t.Error("Shrinking a map doesn't return an error")
+			}
+
+			resized, err := m.Resize(4)
+			if err != nil {
+				t.Fatal("Resize:", err)
+			}
+			defer resized.Close()
+
+			if resized.MaxEntries() != 4 {
+				t.Errorf("Resized map has %d entries", resized.MaxEntries())
+			}
+
+			for i := uint32(0); i < 2; i++ {
+				want, err := m.LookupBytes(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := resized.LookupBytes(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(want, have) {
+					t.Errorf("Key %d has value %v instead of %v", i, have, want)
+				}
+			}
+
+			if err := resized.Put(uint32(3), values(30)); err != nil {
+				t.Error("Can't use additional entries:", err)
+			}
+		})
+	}
+
+	m, err := NewMap(&MapSpec{
+		Type:       ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := m.Resize(4); !errors.Is(err, ErrNotSupported) {
+		t.Error("Resizing a ProgramArray doesn't return ErrNotSupported:", err)
+	}
+}
+
+func TestMapResizePinned(t *testing.T) {
+	tmp := testutils.TempBPFFS(t)
+	path := filepath.Join(tmp, "map")
+
+	m := createArray(t)
+	defer m.Close()
+
+	if err := m.Put(uint32(1), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Pin(path); err != nil {
+		t.Fatal(err)
+	}
+
+	unpinned, err := m.Resize(4)
+	if err != nil {
+		t.Fatal("Resize:", err)
+	}
+	defer unpinned.Close()
+
+	if !m.IsPinned() {
+		t.Error("Resize unpinned the original map")
+	}
+	if unpinned.IsPinned() {
+		t.Error("Resize pinned the copy")
+	}
+
+	original, err := LoadPinnedMap(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	if original.MaxEntries() != m.MaxEntries() {
+		t.Error("Resize replaced the pin")
+	}
+
+	resized, err := m.ResizeAndRepin(4)
+	if err != nil {
+		t.Fatal("ResizeAndRepin:", err)
+	}
+	defer resized.Close()
+
+	if m.IsPinned() {
+		t.Error("Original map is still pinned")
+	}
+	if !resized.IsPinned() {
+		t.Error("Resized map isn't pinned")
+	}
+
+	pinned, err := LoadPinnedMap(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pinned.Close()
+
+	if pinned.MaxEntries() != 4 {
+		t.Errorf("Pinned map has %d entries instead of 4", pinned.MaxEntries())
+	}
+
+	var v uint32
+	if err := pinned.Lookup(uint32(1), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != 42 {
+		t.Errorf("Pinned map has value %d instead of 42", v)
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Resize leaves %d files behind", len(entries)-1)
+	}
+}
+
 func TestMapPin(t *testing.T) {
 	m := createArray(t)
 	c := qt.New(t)
@@ -640,6 +1063,52 @@ func TestMapLoadPinnedUnpin(t *testing.T) {
 	c.Assert(err, qt.IsNil)
 }
 
+func TestMapPinningDefaultPath(t *testing.T) {
+	tmp := testutils.TempBPFFS(t)
+	oldPath := defaultPinPath
+	defaultPinPath = tmp
+	defer func() { defaultPinPath = oldPath }()
+
+	spec := &MapSpec{
+		Name:       "test_default_pin",
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+		Pinning:    PinByName,
+	}
+
+	m1, err := NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m1.Close()
+	defer m1.Unpin()
+
+	if !m1.IsPinned() {
+		t.Fatal("Map isn't pinned")
+	}
+
+	if err := m1.Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second map is loaded from the pin.
+	m2, err := NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+
+	var value uint32
+	if err := m2.Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Error("Map wasn't reused from", filepath.Join(defaultPinPath, spec.Name))
+	}
+}
+
 func TestMapLoadPinnedWithOptions(t *testing.T) {
 	// Introduced in commit 6e71b04a8224.
 	testutils.SkipOnOldKernel(t, "4.15", "file_flags in BPF_OBJ_GET")
@@ -774,6 +1243,148 @@ func TestMapQueue(t *testing.T) {
 	}
 }
 
+func TestMapLookupAndDeleteHash(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.14", "lookup and delete for hash maps")
+
+	hash := createHash()
+	defer hash.Close()
+
+	if err := hash.Put("hello", uint32(21)); err != nil {
+		t.Fatal(err)
+	}
+
+	var v uint32
+	if err := hash.LookupAndDelete("hello", &v); err != nil {
+		t.Fatal("Can't lookup and delete element:", err)
+	}
+	if v != 21 {
+		t.Error("Want value 21, got", v)
+	}
+
+	if err := hash.LookupAndDelete("hello", &v); !errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("Lookup and delete of deleted key:", err)
+	}
+
+	arr := createArray(t)
+	defer arr.Close()
+
+	if err := arr.LookupAndDelete(uint32(0), &v); !errors.Is(err, ErrNotSupported) {
+		t.Error("Lookup and delete on an array doesn't return ErrNotSupported:", err)
+	}
+}
+
+func TestMapPushPop(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.20", "map type queue")
+
+	for _, tc := range []struct {
+		typ   MapType
+		order []uint32
+	}{
+		{Queue, []uint32{1, 2, 3}},
+		{Stack, []uint32{3, 2, 1}},
+	} {
+		t.Run(tc.typ.String(), func(t *testing.T) {
+			m, err := NewMap(&MapSpec{
+				Type:       tc.typ,
+				ValueSize:  4,
+				MaxEntries: 3,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			for _, v := range []uint32{1, 2, 3} {
+				if err := m.Push(v); err != nil {
+					t.Fatalf("Can't push %d: %s", v, err)
+				}
+			}
+
+			for _, want := range tc.order {
+				var v uint32
+				if err := m.Peek(&v); err != nil {
+					t.Fatal("Peek:", err)
+				}
+				if v != want {
+					t.Errorf("Peek: want %d, got %d", want, v)
+				}
+
+				v = 0
+				if err := m.Pop(&v); err != nil {
+					t.Fatal("Pop:", err)
+				}
+				if v != want {
+					t.Errorf("Pop: want %d, got %d", want, v)
+				}
+			}
+
+			var v uint32
+			if err := m.Pop(&v); !errors.Is(err, ErrKeyNotExist) {
+				t.Error("Pop on empty map doesn't return ErrKeyNotExist:", err)
+			}
+			if err := m.Peek(&v); !errors.Is(err, ErrKeyNotExist) {
+				t.Error("Peek on empty map doesn't return ErrKeyNotExist:", err)
+			}
+		})
+	}
+}
+
+func TestMapBloomFilter(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       BloomFilter,
+		ValueSize:  4,
+		MaxEntries: 100,
+		MapExtra:   3,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for _, v := range []uint32{42, 4242} {
+		if err := m.Push(v); err != nil {
+			t.Fatalf("Can't push %d: %s", v, err)
+		}
+	}
+
+	for _, v := range []uint32{42, 4242} {
+		ok, err := m.Contains(v)
+		if err != nil {
+			t.Fatal("Contains:", err)
+		}
+		if !ok {
+			t.Errorf("Bloom filter doesn't contain %d", v)
+		}
+	}
+
+	// A bloom filter with three hashes and 100 entries is unlikely to
+	// report a false positive for a single value.
+	if ok, err := m.Contains(uint32(1)); err != nil {
+		t.Fatal("Contains:", err)
+	} else if ok {
+		t.Error("Bloom filter contains a value that was never pushed")
+	}
+
+	hash, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+
+	if err := hash.Push(uint32(0)); err == nil {
+		t.Error("Push on a map with keys doesn't return an error")
+	}
+	if _, err := hash.Contains(uint32(0)); !errors.Is(err, ErrNotSupported) {
+		t.Error("Contains on a hash map doesn't return ErrNotSupported:", err)
+	}
+}
+
 func TestMapInMap(t *testing.T) {
 	for _, typ := range []MapType{ArrayOfMaps, HashOfMaps} {
 		t.Run(typ.String(), func(t *testing.T) {
@@ -901,40 +1512,197 @@ func createMapInMap(t *testing.T, typ MapType) *Map {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return m
-}
+	return m
+}
+
+func TestMapInMapValueSize(t *testing.T) {
+	spec := &MapSpec{
+		Type:       ArrayOfMaps,
+		KeySize:    4,
+		ValueSize:  0,
+		MaxEntries: 2,
+		InnerMap: &MapSpec{
+			Type:       Array,
+			KeySize:    4,
+			ValueSize:  4,
+			MaxEntries: 2,
+		},
+	}
+
+	m, err := NewMap(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.checkCompatibility(m); err != nil {
+		t.Error("Spec with zero value size isn't compatible:", err)
+	}
+	m.Close()
+
+	spec.ValueSize = 4
+	m, err = NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	spec.ValueSize = 1
+	if _, err := NewMap(spec); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestMapInMapNested(t *testing.T) {
+	spec := &MapSpec{
+		Type:       ArrayOfMaps,
+		KeySize:    4,
+		MaxEntries: 2,
+		InnerMap: &MapSpec{
+			Type:       HashOfMaps,
+			KeySize:    4,
+			MaxEntries: 2,
+			InnerMap: &MapSpec{
+				Type:       Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 2,
+			},
+		},
+	}
+
+	if _, err := NewMap(spec); err == nil {
+		t.Fatal("Creating a nested map of maps doesn't return an error")
+	}
+}
+
+func TestMapNumaNode(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.14", "BPF_F_NUMA_NODE")
+
+	m, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+		Flags:      unix.BPF_F_NUMA_NODE,
+		NumaNode:   0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if m.Flags()&unix.BPF_F_NUMA_NODE == 0 {
+		t.Error("Map doesn't have flag BPF_F_NUMA_NODE")
+	}
+}
+
+func TestMapSpecSetGoTypes(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.18", "map BTF")
+
+	type value struct {
+		Counter uint64
+		Flags   [4]uint8
+		_       uint32
+	}
+
+	spec := &MapSpec{
+		Type:       Hash,
+		MaxEntries: 1,
+	}
+	if err := spec.SetGoTypes(uint32(0), value{}); err != nil {
+		t.Fatal(err)
+	}
+	if spec.KeySize != 4 || spec.ValueSize != 16 {
+		t.Fatalf("Unexpected key size %d, value size %d", spec.KeySize, spec.ValueSize)
+	}
+
+	m, err := NewMap(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
 
-func TestMapInMapValueSize(t *testing.T) {
-	spec := &MapSpec{
-		Type:       ArrayOfMaps,
-		KeySize:    4,
-		ValueSize:  0,
-		MaxEntries: 2,
-		InnerMap: &MapSpec{
-			Type:       Array,
-			KeySize:    4,
-			ValueSize:  4,
-			MaxEntries: 2,
-		},
+	info, err := m.Info()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	m, err := NewMap(spec)
+	id, ok := info.BTFID()
+	if !ok {
+		t.Fatal("Map has no BTF")
+	}
+
+	handle, err := btf.NewHandleFromID(id)
 	testutils.SkipIfNotSupported(t, err)
 	if err != nil {
 		t.Fatal(err)
 	}
-	m.Close()
+	defer handle.Close()
 
-	spec.ValueSize = 4
-	m, err = NewMap(spec)
-	if err != nil {
+	var s *btf.Struct
+	if err := handle.Spec().TypeByName("value", &s); err != nil {
 		t.Fatal(err)
 	}
-	m.Close()
+	if len(s.Members) != 2 || s.Members[1].Name != "Flags" {
+		t.Errorf("Unexpected members %v", s.Members)
+	}
 
-	spec.ValueSize = 1
-	if _, err := NewMap(spec); err == nil {
-		t.Fatal("Expected an error")
+	if err := (&MapSpec{KeySize: 8}).SetGoTypes(uint32(0), value{}); err == nil {
+		t.Error("SetGoTypes doesn't return an error for a mismatched key size")
+	}
+
+	if err := (&MapSpec{}).SetGoTypes(uint32(0), []byte{}); err == nil {
+		t.Error("SetGoTypes doesn't return an error for a slice")
+	}
+}
+
+func TestMapSpecValidate(t *testing.T) {
+	for _, spec := range []*MapSpec{
+		{Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+		{Type: Hash, KeySize: 8, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NO_PREALLOC},
+		{Type: PerfEventArray},
+		{Type: LPMTrie, KeySize: 8, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NO_PREALLOC},
+		{Type: Queue, ValueSize: 4, MaxEntries: 1},
+		{Type: BloomFilter, ValueSize: 4, MaxEntries: 1, MapExtra: 3},
+		{Type: RingBuf, MaxEntries: uint32(os.Getpagesize())},
+		{Type: Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NUMA_NODE, NumaNode: 1},
+		{Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1, NumaNode: 1},
+		{Type: ArrayOfMaps, KeySize: 4, MaxEntries: 1, InnerMap: &MapSpec{Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1}},
+		// Unknown map types are left to the kernel, e.g. BPF_MAP_TYPE_ARENA.
+		{Type: maxMapType + 2, MaxEntries: 1, Flags: unix.BPF_F_MMAPABLE, MapExtra: 1 << 32},
+	} {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%s: %s", spec, err)
+		}
+	}
+
+	for name, spec := range map[string]*MapSpec{
+		"zero MaxEntries":      {Type: Hash, KeySize: 4, ValueSize: 4},
+		"zero KeySize":         {Type: Hash, ValueSize: 4, MaxEntries: 1},
+		"array KeySize":        {Type: Array, KeySize: 8, ValueSize: 4, MaxEntries: 1},
+		"array no prealloc":    {Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NO_PREALLOC},
+		"queue KeySize":        {Type: Queue, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+		"per-CPU ValueSize":    {Type: PerCPUArray, KeySize: 4, ValueSize: 32*1024 + 1, MaxEntries: 1},
+		"lpm prealloc":         {Type: LPMTrie, KeySize: 8, ValueSize: 4, MaxEntries: 1},
+		"lpm KeySize":          {Type: LPMTrie, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NO_PREALLOC},
+		"rdonly and wronly":    {Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_RDONLY_PROG | unix.BPF_F_WRONLY_PROG},
+		"mmapable hash":        {Type: Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_MMAPABLE},
+		"bloom filter hashes":  {Type: BloomFilter, ValueSize: 4, MaxEntries: 1, MapExtra: 16},
+		"ring buffer size":     {Type: RingBuf, MaxEntries: 3},
+		"missing inner map":    {Type: ArrayOfMaps, KeySize: 4, MaxEntries: 1},
+		"invalid inner map":    {Type: ArrayOfMaps, KeySize: 4, MaxEntries: 1, InnerMap: &MapSpec{Type: Array, KeySize: 4, ValueSize: 4}},
+		"perf event ValueSize": {Type: PerfEventArray, ValueSize: 8},
+		"per-CPU NUMA node":    {Type: PerCPUHash, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_NUMA_NODE},
+		"rdonly with contents": {Type: Array, KeySize: 4, ValueSize: 4, MaxEntries: 1, Flags: unix.BPF_F_RDONLY, Contents: []MapKV{{uint32(0), uint32(1)}}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: Validate doesn't return an error", name)
+		}
+
+		if _, err := NewMap(spec); err == nil {
+			t.Errorf("%s: NewMap doesn't return an error", name)
+		}
 	}
 }
 
@@ -1040,6 +1808,54 @@ func TestMapIterate(t *testing.T) {
 	}
 }
 
+func TestMapIterateConcurrentDelete(t *testing.T) {
+	if err := haveBatchAPI(); err != nil {
+		t.Skipf("batch api not available: %v", err)
+	}
+
+	const entries = 1000
+	hash, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: entries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+
+	for i := uint32(0); i < entries; i++ {
+		if err := hash.Put(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var key, value uint32
+	seen := make(map[uint32]int)
+	iter := hash.Iterate()
+	for iter.Next(&key, &value) {
+		seen[key]++
+
+		// Deleting the current key causes GetNextKey to restart from
+		// the beginning of the map.
+		if key%2 == 1 {
+			if err := hash.Delete(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < entries; i++ {
+		if seen[i] != 1 {
+			t.Fatalf("Key %d returned %d times", i, seen[i])
+		}
+	}
+}
+
 func TestMapIterateHashKeyOneByteFull(t *testing.T) {
 	hash, err := NewMap(&MapSpec{
 		Type:       Hash,
@@ -1315,6 +2131,149 @@ type bpfCgroupStorageKey struct {
 	_             [4]byte // Padding
 }
 
+func TestMapHasKey(t *testing.T) {
+	hash := createHash()
+	defer hash.Close()
+
+	if err := hash.Put("hello", uint32(21)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := hash.HasKey("hello"); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("HasKey doesn't report existing key")
+	}
+
+	if ok, err := hash.HasKey("world"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("HasKey reports missing key")
+	}
+
+	if _, err := hash.HasKey(uint32(0)); err == nil {
+		t.Error("HasKey accepts key of the wrong size")
+	}
+}
+
+func TestMapLookupWithBuffer(t *testing.T) {
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMap(&MapSpec{
+		Type:       PerCPUArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	values := make([]uint32, possibleCPUs)
+	for i := range values {
+		values[i] = uint32(i) + 1
+	}
+	if err := m.Put(uint32(0), values); err != nil {
+		t.Fatal(err)
+	}
+
+	key := uint32(0)
+	buf := make([]byte, 8*possibleCPUs)
+	if err := m.LookupWithBuffer(unsafe.Pointer(&key), buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range values {
+		if have := internal.NativeEndian.Uint32(buf[i*8:]); have != want {
+			t.Errorf("CPU %d has value %d instead of %d", i, have, want)
+		}
+	}
+
+	var out []uint32
+	lookupAllocs := testing.AllocsPerRun(10, func() {
+		_ = m.Lookup(unsafe.Pointer(&key), &out)
+	})
+	bufferAllocs := testing.AllocsPerRun(10, func() {
+		_ = m.LookupWithBuffer(unsafe.Pointer(&key), buf)
+	})
+	if bufferAllocs >= lookupAllocs {
+		t.Errorf("LookupWithBuffer allocates %v times, Lookup %v times", bufferAllocs, lookupAllocs)
+	}
+
+	if err := m.LookupWithBuffer(uint32(0), buf[:len(buf)-1]); err == nil {
+		t.Error("LookupWithBuffer accepts a short buffer")
+	}
+}
+
+func TestMapPutBytes(t *testing.T) {
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewMap(&MapSpec{
+		Type:       PerCPUHash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := NewMap(&MapSpec{
+		Type:       PerCPUHash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	values := make([]uint32, possibleCPUs)
+	for i := range values {
+		values[i] = uint32(i) + 1
+	}
+	if err := src.Put(uint32(1), values); err != nil {
+		t.Fatal(err)
+	}
+
+	key := make([]byte, 4)
+	internal.NativeEndian.PutUint32(key, 1)
+	value, err := src.LookupBytes(key)
+	if err != nil {
+		t.Fatal("LookupBytes:", err)
+	}
+
+	if err := dst.PutBytes(key, value); err != nil {
+		t.Fatal("PutBytes:", err)
+	}
+
+	var have []uint32
+	if err := dst.Lookup(uint32(1), &have); err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		if have[i] != values[i] {
+			t.Errorf("CPU %d has value %d instead of %d", i, have[i], values[i])
+		}
+	}
+
+	if err := dst.PutBytes(key, value[:4]); err == nil {
+		t.Error("PutBytes accepts a short value")
+	}
+	if err := dst.PutBytes(key[:2], value); err == nil {
+		t.Error("PutBytes accepts a short key")
+	}
+}
+
 func TestCgroupPerCPUStorageMarshaling(t *testing.T) {
 	numCPU, err := internal.PossibleCPUs()
 	if err != nil {
@@ -1480,6 +2439,23 @@ func TestMapName(t *testing.T) {
 	}
 }
 
+func TestMapFromFDNotAMap(t *testing.T) {
+	prog := mustSocketFilter(t)
+	defer prog.Close()
+
+	dup, err := prog.fd.Dup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NewMapFromFD takes ownership of the fd.
+	dup.Forget()
+
+	if m, err := NewMapFromFD(dup.Int()); err == nil {
+		m.Close()
+		t.Fatal("NewMapFromFD accepts a program fd")
+	}
+}
+
 func TestMapFromFD(t *testing.T) {
 	m := createArray(t)
 	defer m.Close()
@@ -1570,6 +2546,80 @@ func TestMapFreeze(t *testing.T) {
 	}
 }
 
+func TestMapAccessMode(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.15", "BPF_F_RDONLY and BPF_F_WRONLY")
+
+	newArray := func(t *testing.T, flags uint32) *Map {
+		t.Helper()
+
+		m, err := NewMap(&MapSpec{
+			Type:       Array,
+			KeySize:    4,
+			ValueSize:  4,
+			MaxEntries: 1,
+			Flags:      flags,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { m.Close() })
+		return m
+	}
+
+	t.Run("read-only", func(t *testing.T) {
+		m := newArray(t, unix.BPF_F_RDONLY)
+
+		var value uint32
+		if err := m.Lookup(uint32(0), &value); err != nil {
+			t.Fatal("Can't lookup in read-only map:", err)
+		}
+
+		clone, err := m.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clone.Close()
+
+		for _, m := range []*Map{m, clone} {
+			if err := m.Put(uint32(0), uint32(1)); !errors.Is(err, unix.EPERM) {
+				t.Error("Put doesn't return EPERM:", err)
+			}
+			if err := m.Delete(uint32(0)); !errors.Is(err, unix.EPERM) {
+				t.Error("Delete doesn't return EPERM:", err)
+			}
+			if _, err := m.BatchUpdate([]uint32{0}, []uint32{1}, nil); !errors.Is(err, unix.EPERM) {
+				t.Error("BatchUpdate doesn't return EPERM:", err)
+			}
+		}
+	})
+
+	t.Run("write-only", func(t *testing.T) {
+		m := newArray(t, unix.BPF_F_WRONLY)
+
+		if err := m.Put(uint32(0), uint32(1)); err != nil {
+			t.Fatal("Can't update write-only map:", err)
+		}
+
+		var value uint32
+		if err := m.Lookup(uint32(0), &value); !errors.Is(err, unix.EPERM) {
+			t.Error("Lookup doesn't return EPERM:", err)
+		}
+
+		var key uint32
+		if err := m.NextKey(nil, &key); !errors.Is(err, unix.EPERM) {
+			t.Error("NextKey doesn't return EPERM:", err)
+		}
+
+		entries := m.Iterate()
+		if entries.Next(&key, &value) {
+			t.Error("Iterate returns entries of a write-only map")
+		}
+		if !errors.Is(entries.Err(), unix.EPERM) {
+			t.Error("Iterate doesn't return EPERM:", entries.Err())
+		}
+	})
+}
+
 func TestMapGetNextID(t *testing.T) {
 	testutils.SkipOnOldKernel(t, "4.13", "bpf_map_get_next_id")
 	var next MapID
@@ -1793,8 +2843,13 @@ func BenchmarkPerCPUMarshalling(b *testing.B) {
 		return m
 	}
 
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		b.Fatal(err)
+	}
+
 	key := uint64(1)
-	val := []uint64{1, 2, 3, 4, 5, 6, 7, 8}
+	val := make([]uint64, possibleCPUs)
 
 	m := newMap(8)
 	if err := m.Put(key, val[0:]); err != nil {
@@ -1814,6 +2869,20 @@ func BenchmarkPerCPUMarshalling(b *testing.B) {
 			}
 		}
 	})
+
+	b.Run("buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		buf := make([]byte, m.fullValueSize)
+
+		for i := 0; i < b.N; i++ {
+			err := m.LookupWithBuffer(unsafe.Pointer(&key), buf)
+			if err != nil {
+				b.Fatal("Can't get key:", err)
+			}
+		}
+	})
 }
 
 func BenchmarkMap(b *testing.B) {
@@ -1996,6 +3065,39 @@ func ExampleMap_NextKey() {
 	// Order of keys is non-deterministic due to randomized map seed
 }
 
+// ExampleMapGetNextID demonstrates how to list all maps on the system,
+// similar to bpftool map show.
+func ExampleMapGetNextID() {
+	var id MapID
+	for {
+		var err error
+		id, err = MapGetNextID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		m, err := NewMapFromID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			// The map was removed in the meantime.
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		info, err := m.Info()
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("%d: %s %s\n", id, m.Type(), info.Name)
+		m.Close()
+	}
+}
+
 // ExampleMap_Iterate demonstrates how to iterate over all entries
 // in a map.
 func ExampleMap_Iterate() {
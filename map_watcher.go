@@ -0,0 +1,222 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// MapEventType describes how an entry of a map changed.
+type MapEventType int
+
+const (
+	// MapEntryAdded is emitted for keys which didn't exist during the
+	// previous poll.
+	MapEntryAdded MapEventType = iota + 1
+	// MapEntryUpdated is emitted for keys whose value changed since the
+	// previous poll.
+	MapEntryUpdated
+	// MapEntryDeleted is emitted for keys which existed during the previous
+	// poll but are now gone.
+	MapEntryDeleted
+)
+
+func (et MapEventType) String() string {
+	switch et {
+	case MapEntryAdded:
+		return "added"
+	case MapEntryUpdated:
+		return "updated"
+	case MapEntryDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("MapEventType(%d)", int(et))
+	}
+}
+
+// MapEvent is a change to a single entry of a map.
+//
+// Keys and values are in the format used by the kernel, see LookupBytes.
+type MapEvent struct {
+	Type MapEventType
+	Key  []byte
+	// The current value. Nil for MapEntryDeleted.
+	Value []byte
+	// The value during the previous poll. Nil for MapEntryAdded.
+	OldValue []byte
+}
+
+// MapWatcherOptions control the behaviour of a MapWatcher.
+type MapWatcherOptions struct {
+	// The interval at which Read polls the map. Defaults to one second.
+	Interval time.Duration
+
+	// Read the map using IterateSnapshot instead of Iterate, which avoids
+	// restarts when keys are deleted concurrently. Requires Linux 5.9,
+	// older kernels fall back to Iterate.
+	Snapshot bool
+}
+
+// MapWatcher reports changes to the entries of a map.
+//
+// Changes are detected by periodically reading the whole map and comparing
+// it to the previous contents. Changes which are reverted between two polls
+// go unnoticed, and several changes to a key are reported as one. The cost
+// of a poll grows with the number of entries in the map.
+//
+// A MapWatcher is not safe for concurrent use, except for Close.
+type MapWatcher struct {
+	mu       sync.Mutex
+	m        *Map
+	interval time.Duration
+	snapshot bool
+	entries  map[string][]byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewMapWatcher starts watching m for changes.
+//
+// The watcher starts out with an empty view of the map, so the first poll
+// reports all existing entries as added. Only maps which store plain data
+// can be watched, other map types return ErrNotSupported.
+//
+// The watcher uses a duplicate of m, so the caller may close it. opts may
+// be nil.
+func NewMapWatcher(m *Map, opts *MapWatcherOptions) (*MapWatcher, error) {
+	if !m.typ.canSnapshot() {
+		return nil, fmt.Errorf("watch %s: %w", m.typ, ErrNotSupported)
+	}
+
+	if opts == nil {
+		opts = &MapWatcherOptions{}
+	}
+
+	interval := opts.Interval
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid interval %s", interval)
+	}
+	if interval == 0 {
+		interval = time.Second
+	}
+
+	dup, err := m.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MapWatcher{
+		m:        dup,
+		interval: interval,
+		snapshot: opts.Snapshot,
+		entries:  make(map[string][]byte),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Close stops watching the map.
+//
+// It interrupts calls to Read.
+func (w *MapWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+	})
+
+	// Acquire the lock. This ensures that Poll isn't running.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.m == nil {
+		return nil
+	}
+
+	err := w.m.Close()
+	w.m = nil
+	return err
+}
+
+// Read blocks until the map changed and returns the changes.
+//
+// The map is polled immediately and then once every interval until a change
+// is detected.
+//
+// Returns an error wrapping os.ErrClosed if the watcher was closed.
+func (w *MapWatcher) Read() ([]MapEvent, error) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return nil, fmt.Errorf("map watcher: %w", os.ErrClosed)
+		case <-timer.C:
+		}
+
+		events, err := w.Poll()
+		if err != nil || len(events) > 0 {
+			return events, err
+		}
+
+		timer.Reset(w.interval)
+	}
+}
+
+// Poll reads the map and returns the changes since the previous poll,
+// ordered by key.
+//
+// Returns an error wrapping os.ErrClosed if the watcher was closed.
+func (w *MapWatcher) Poll() ([]MapEvent, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.m == nil {
+		return nil, fmt.Errorf("map watcher: %w", os.ErrClosed)
+	}
+
+	iter := w.m.Iterate()
+	if w.snapshot {
+		iter = w.m.IterateSnapshot()
+	}
+
+	var (
+		key     []byte
+		value   = make([]byte, w.m.fullValueSize)
+		entries = make(map[string][]byte, len(w.entries))
+		events  []MapEvent
+	)
+	for iter.Next(&key, unsafe.Pointer(&value[0])) {
+		k := string(key)
+		v := append([]byte(nil), value...)
+		entries[k] = v
+
+		old, ok := w.entries[k]
+		switch {
+		case !ok:
+			events = append(events, MapEvent{MapEntryAdded, []byte(k), v, nil})
+		case !bytes.Equal(old, v):
+			events = append(events, MapEvent{MapEntryUpdated, []byte(k), v, old})
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("poll: %w", err)
+	}
+
+	for k, old := range w.entries {
+		if _, ok := entries[k]; !ok {
+			events = append(events, MapEvent{MapEntryDeleted, []byte(k), nil, old})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].Key, events[j].Key) < 0
+	})
+
+	w.entries = entries
+	return events, nil
+}
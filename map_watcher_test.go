@@ -0,0 +1,157 @@
+package ebpf
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+func TestMapWatcher(t *testing.T) {
+	for _, snapshot := range []bool{false, true} {
+		name := "iterate"
+		if snapshot {
+			name = "snapshot"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			hash := createHash()
+			defer hash.Close()
+
+			w, err := NewMapWatcher(hash, &MapWatcherOptions{Interval: time.Millisecond, Snapshot: snapshot})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			mustPut := func(key string, value uint32) {
+				t.Helper()
+				if err := hash.Put(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mustPut("aaaaa", 1)
+			mustPut("bbbbb", 2)
+
+			events, err := w.Read()
+			if err != nil {
+				t.Fatal("Read:", err)
+			}
+			checkMapEvents(t, events, []MapEvent{
+				{Type: MapEntryAdded, Key: []byte("aaaaa"), Value: mapWatcherValue(1)},
+				{Type: MapEntryAdded, Key: []byte("bbbbb"), Value: mapWatcherValue(2)},
+			})
+
+			if events, err := w.Poll(); err != nil {
+				t.Fatal("Poll:", err)
+			} else if len(events) != 0 {
+				t.Fatal("Poll of unchanged map returns events:", events)
+			}
+
+			mustPut("aaaaa", 3)
+			mustPut("ccccc", 4)
+			if err := hash.Delete("bbbbb"); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err = w.Read()
+			if err != nil {
+				t.Fatal("Read:", err)
+			}
+			checkMapEvents(t, events, []MapEvent{
+				{Type: MapEntryUpdated, Key: []byte("aaaaa"), Value: mapWatcherValue(3), OldValue: mapWatcherValue(1)},
+				{Type: MapEntryDeleted, Key: []byte("bbbbb"), OldValue: mapWatcherValue(2)},
+				{Type: MapEntryAdded, Key: []byte("ccccc"), Value: mapWatcherValue(4)},
+			})
+		})
+	}
+}
+
+func TestMapWatcherClose(t *testing.T) {
+	hash := createHash()
+	defer hash.Close()
+
+	w, err := NewMapWatcher(hash, &MapWatcherOptions{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := w.Read()
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		t.Fatal("Read returns instead of blocking:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Fatal("Read after Close doesn't return os.ErrClosed:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close doesn't interrupt Read")
+	}
+
+	if _, err := w.Poll(); !errors.Is(err, os.ErrClosed) {
+		t.Fatal("Poll after Close doesn't return os.ErrClosed:", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Second Close:", err)
+	}
+
+	// The watcher uses its own copy of the map.
+	if err := hash.Put("aaaaa", uint32(1)); err != nil {
+		t.Fatal("Can't use map after closing watcher:", err)
+	}
+}
+
+func TestMapWatcherUnsupported(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewMapWatcher(m, nil); !errors.Is(err, ErrNotSupported) {
+		t.Error("Watching a ProgramArray doesn't return ErrNotSupported:", err)
+	}
+}
+
+func mapWatcherValue(v uint32) []byte {
+	buf := make([]byte, 4)
+	internal.NativeEndian.PutUint32(buf, v)
+	return buf
+}
+
+func checkMapEvents(tb testing.TB, have, want []MapEvent) {
+	tb.Helper()
+
+	if len(have) != len(want) {
+		tb.Fatalf("Expected %d events, got %d: %v", len(want), len(have), have)
+	}
+
+	for i := range want {
+		h, w := have[i], want[i]
+		if h.Type != w.Type || string(h.Key) != string(w.Key) ||
+			string(h.Value) != string(w.Value) || string(h.OldValue) != string(w.OldValue) {
+			tb.Errorf("Event %d: expected %s %q, got %s %q (%v)", i, w.Type, w.Key, h.Type, h.Key, h)
+		}
+	}
+}
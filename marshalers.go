@@ -169,27 +169,48 @@ func marshalPerCPUValue(slice interface{}, elemLength int) (sys.Pointer, error)
 		return sys.Pointer{}, err
 	}
 
-	sliceValue := reflect.ValueOf(slice)
-	sliceLen := sliceValue.Len()
+	sliceLen := reflect.ValueOf(slice).Len()
 	if sliceLen > possibleCPUs {
 		return sys.Pointer{}, fmt.Errorf("per-CPU value exceeds number of CPUs")
 	}
 
+	buf, err := marshalPerCPUSlice(slice, elemLength)
+	if err != nil {
+		return sys.Pointer{}, err
+	}
+
+	alignedElemLength := internal.Align(elemLength, 8)
+	buf = append(buf, make([]byte, (possibleCPUs-sliceLen)*alignedElemLength)...)
+
+	return sys.NewSlicePointer(buf), nil
+}
+
+// marshalPerCPUSlice encodes each element of slice, padding elements to a
+// multiple of 8 bytes.
+//
+// This is the layout of per-CPU values used by the kernel. It's also used by
+// batch operations, where values for consecutive keys follow each other.
+func marshalPerCPUSlice(slice interface{}, elemLength int) ([]byte, error) {
+	sliceValue := reflect.ValueOf(slice)
+	if sliceValue.Kind() != reflect.Slice {
+		return nil, errors.New("per-CPU value requires slice")
+	}
+
 	alignedElemLength := internal.Align(elemLength, 8)
-	buf := make([]byte, alignedElemLength*possibleCPUs)
+	buf := make([]byte, alignedElemLength*sliceValue.Len())
 
-	for i := 0; i < sliceLen; i++ {
+	for i := 0; i < sliceValue.Len(); i++ {
 		elem := sliceValue.Index(i).Interface()
 		elemBytes, err := marshalBytes(elem, elemLength)
 		if err != nil {
-			return sys.Pointer{}, err
+			return nil, err
 		}
 
 		offset := i * alignedElemLength
 		copy(buf[offset:offset+elemLength], elemBytes)
 	}
 
-	return sys.NewSlicePointer(buf), nil
+	return buf, nil
 }
 
 // unmarshalPerCPUValue decodes a buffer into a slice containing one value per
@@ -207,27 +228,42 @@ func unmarshalPerCPUValue(slicePtr interface{}, elemLength int, buf []byte) erro
 		return err
 	}
 
-	sliceType := slicePtrType.Elem()
-	slice := reflect.MakeSlice(sliceType, possibleCPUs, possibleCPUs)
+	slice := reflect.MakeSlice(slicePtrType.Elem(), possibleCPUs, possibleCPUs)
+	if err := unmarshalPerCPUSlice(slice.Interface(), elemLength, buf); err != nil {
+		return err
+	}
 
-	sliceElemType := sliceType.Elem()
+	reflect.ValueOf(slicePtr).Elem().Set(slice)
+	return nil
+}
+
+// unmarshalPerCPUSlice decodes a buffer in the layout produced by
+// marshalPerCPUSlice into the existing elements of slice.
+func unmarshalPerCPUSlice(slice interface{}, elemLength int, buf []byte) error {
+	sliceValue := reflect.ValueOf(slice)
+	if sliceValue.Kind() != reflect.Slice {
+		return fmt.Errorf("per-cpu values require a slice")
+	}
+
+	sliceElemType := sliceValue.Type().Elem()
 	sliceElemIsPointer := sliceElemType.Kind() == reflect.Ptr
 	if sliceElemIsPointer {
 		sliceElemType = sliceElemType.Elem()
 	}
 
-	step := len(buf) / possibleCPUs
-	if step < elemLength {
+	step := internal.Align(elemLength, 8)
+	if len(buf) < sliceValue.Len()*step {
 		return fmt.Errorf("per-cpu element length is larger than available data")
 	}
-	for i := 0; i < possibleCPUs; i++ {
+
+	for i := 0; i < sliceValue.Len(); i++ {
 		var elem interface{}
 		if sliceElemIsPointer {
 			newElem := reflect.New(sliceElemType)
-			slice.Index(i).Set(newElem)
+			sliceValue.Index(i).Set(newElem)
 			elem = newElem.Interface()
 		} else {
-			elem = slice.Index(i).Addr().Interface()
+			elem = sliceValue.Index(i).Addr().Interface()
 		}
 
 		// Make a copy, since unmarshal can hold on to itemBytes
@@ -242,6 +278,5 @@ func unmarshalPerCPUValue(slicePtr interface{}, elemLength int, buf []byte) erro
 		buf = buf[step:]
 	}
 
-	reflect.ValueOf(slicePtr).Elem().Set(slice)
 	return nil
 }
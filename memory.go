@@ -0,0 +1,129 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Memory is the values of an Array map mapped into the address space of
+// the process.
+//
+// Reads and writes are visible to BPF programs immediately and don't
+// require a syscall. There is no synchronisation with BPF programs
+// accessing the same values concurrently.
+type Memory struct {
+	b  []byte
+	ro bool
+}
+
+// Memory maps the values of m into memory.
+//
+// m must be an Array created with the BPF_F_MMAPABLE flag. The mapping is
+// read-only if m is frozen. The caller must call Memory.Close to release the
+// mapping, closing m doesn't invalidate it.
+func (m *Map) Memory() (*Memory, error) {
+	if m.typ != Array || m.flags&unix.BPF_F_MMAPABLE == 0 {
+		return nil, fmt.Errorf("%s without BPF_F_MMAPABLE can't be mapped into memory: %w", m.typ, ErrNotSupported)
+	}
+
+	// Values are padded to 8 bytes, like for per-CPU maps.
+	size := internal.Align(int(m.valueSize), 8) * int(m.maxEntries)
+	if size == 0 {
+		return nil, errors.New("can't map empty array into memory")
+	}
+
+	mm := &Memory{}
+	b, err := unix.Mmap(m.FD(), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if errors.Is(err, unix.EPERM) {
+		// Frozen maps can only be mapped read-only.
+		mm.ro = true
+		b, err = unix.Mmap(m.FD(), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mmap map: %w", err)
+	}
+
+	mm.b = b
+	return mm, nil
+}
+
+// Close removes the mapping.
+//
+// Slices returned by Bytes are invalid afterwards.
+func (mm *Memory) Close() error {
+	if mm.b == nil {
+		return nil
+	}
+
+	err := unix.Munmap(mm.b)
+	mm.b = nil
+	return err
+}
+
+// Size returns the size of the mapping in bytes.
+//
+// Each value of the array occupies a multiple of 8 bytes.
+func (mm *Memory) Size() int {
+	return len(mm.b)
+}
+
+// Readonly returns true if the mapping can't be written to.
+func (mm *Memory) Readonly() bool {
+	return mm.ro
+}
+
+// Bytes returns the mapped memory.
+//
+// Accessing the slice after calling Close crashes the process. Writing to
+// the slice of a Readonly mapping crashes the process.
+func (mm *Memory) Bytes() []byte {
+	return mm.b
+}
+
+// ReadAt implements io.ReaderAt.
+func (mm *Memory) ReadAt(p []byte, off int64) (int, error) {
+	if mm.b == nil {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, fmt.Errorf("read offset %d out of range", off)
+	}
+	if off >= int64(len(mm.b)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, mm.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+func (mm *Memory) WriteAt(p []byte, off int64) (int, error) {
+	if mm.b == nil {
+		return 0, os.ErrClosed
+	}
+
+	if mm.ro {
+		return 0, fmt.Errorf("memory is read-only")
+	}
+
+	if off < 0 || off >= int64(len(mm.b)) {
+		return 0, fmt.Errorf("write offset %d out of range", off)
+	}
+
+	n := copy(mm.b[off:], p)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+
+	return n, nil
+}
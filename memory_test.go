@@ -0,0 +1,139 @@
+package ebpf
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+func TestMemory(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.5", "mmapable maps")
+
+	m, err := NewMap(&MapSpec{
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+		Flags:      unix.BPF_F_MMAPABLE,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	mm, err := m.Memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+
+	if mm.Readonly() {
+		t.Error("Memory of a mutable map is read-only")
+	}
+	if mm.Size() != 16 {
+		t.Errorf("Expected size 16, got %d", mm.Size())
+	}
+
+	if err := m.Put(uint32(1), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each value is padded to 8 bytes.
+	buf := make([]byte, 4)
+	if _, err := mm.ReadAt(buf, 8); err != nil {
+		t.Fatal("ReadAt:", err)
+	}
+	if v := internal.NativeEndian.Uint32(buf); v != 42 {
+		t.Errorf("Expected 42, got %d", v)
+	}
+
+	internal.NativeEndian.PutUint32(buf, 23)
+	if _, err := mm.WriteAt(buf, 0); err != nil {
+		t.Fatal("WriteAt:", err)
+	}
+
+	var v uint32
+	if err := m.Lookup(uint32(0), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != 23 {
+		t.Errorf("Expected 23, got %d", v)
+	}
+
+	if _, err := mm.WriteAt(buf, 14); err == nil {
+		t.Error("WriteAt past the end doesn't return an error")
+	}
+
+	if n, err := mm.ReadAt(buf, int64(mm.Size())); n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("ReadAt at the end should return 0, io.EOF, got %d, %v", n, err)
+	}
+	if _, err := mm.ReadAt(buf, -1); err == nil || errors.Is(err, io.EOF) {
+		t.Error("ReadAt with a negative offset doesn't return an error")
+	}
+
+	all, err := io.ReadAll(io.NewSectionReader(mm, 0, 1<<20))
+	if err != nil {
+		t.Fatal("Read via io.SectionReader:", err)
+	}
+	if len(all) != mm.Size() {
+		t.Errorf("Expected to read %d bytes, got %d", mm.Size(), len(all))
+	}
+
+	if err := mm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mm.ReadAt(buf, 0); err == nil {
+		t.Error("ReadAt on closed memory doesn't return an error")
+	}
+}
+
+func TestMemoryReadonly(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.5", "mmapable maps")
+
+	m, err := NewMap(&MapSpec{
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+		Flags:      unix.BPF_F_MMAPABLE,
+		Freeze:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	mm, err := m.Memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+
+	if !mm.Readonly() {
+		t.Error("Memory of a frozen map isn't read-only")
+	}
+	if _, err := mm.WriteAt([]byte{1}, 0); err == nil {
+		t.Error("WriteAt on read-only memory doesn't return an error")
+	}
+}
+
+func TestMemoryNotMmapable(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := m.Memory(); !errors.Is(err, ErrNotSupported) {
+		t.Error("Expected ErrNotSupported, got", err)
+	}
+}
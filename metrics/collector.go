@@ -0,0 +1,236 @@
+// Package metrics exports statistics about eBPF maps to Prometheus.
+//
+// The package is a separate Go module, so that importing
+// github.com/cilium/ebpf doesn't pull in the Prometheus client library.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KeyGauge exports a gauge for each entry of a map.
+type KeyGauge struct {
+	// Name of the gauge, prefixed with the namespace of the MapCollector.
+	Name string
+	Help string
+
+	// LabelNames are the names of the labels returned by Func, in order.
+	// A label "map" is added automatically.
+	LabelNames []string
+
+	// Func is called with every key and value of the map, in the format
+	// returned by Map.LookupBytes. It returns the label values identifying
+	// the entry and the value of the gauge. Label values must be unique
+	// across the entries of a map.
+	Func func(key, value []byte) (labelValues []string, gauge float64)
+}
+
+// CollectedMap selects a map and the metrics exported for it.
+//
+// The maximum number of entries and memory usage of a map are read from
+// its MapInfo and always exported. Counting entries and KeyGauges require
+// iterating the map on every scrape, which is costly for large maps.
+type CollectedMap struct {
+	// Name is used as the value of the "map" label.
+	Name string
+	Map  *ebpf.Map
+
+	// CountEntries exports the number of entries in the map.
+	CountEntries bool
+
+	Gauges []KeyGauge
+}
+
+// MapCollector is a prometheus.Collector which exports metrics about a fixed
+// set of maps.
+//
+// Metrics are gathered when the collector is scraped. The collector doesn't
+// take ownership of the maps, which must stay open while it is registered.
+type MapCollector struct {
+	maps []CollectedMap
+
+	maxEntries *prometheus.Desc
+	memlock    *prometheus.Desc
+	entries    *prometheus.Desc
+	gauges     map[string]*prometheus.Desc
+}
+
+var _ prometheus.Collector = (*MapCollector)(nil)
+
+// NewMapCollector creates a collector for the given maps.
+//
+// namespace is prepended to the names of all metrics, for example "ebpf".
+// KeyGauges of different maps may share a Name as long as they also share
+// LabelNames and Help.
+func NewMapCollector(namespace string, maps ...CollectedMap) (*MapCollector, error) {
+	labels := []string{"map"}
+	mc := &MapCollector{
+		maps: maps,
+		maxEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "map", "max_entries"),
+			"Maximum number of entries of a map.",
+			labels, nil,
+		),
+		memlock: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "map", "memlock_bytes"),
+			"Memory charged to a map.",
+			labels, nil,
+		),
+		entries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "map", "entries"),
+			"Number of entries in a map.",
+			labels, nil,
+		),
+		gauges: make(map[string]*prometheus.Desc),
+	}
+
+	names := make(map[string]bool)
+	for _, cm := range maps {
+		if cm.Name == "" {
+			return nil, errors.New("map without a name")
+		}
+		if names[cm.Name] {
+			return nil, fmt.Errorf("duplicate map %q", cm.Name)
+		}
+		names[cm.Name] = true
+
+		if cm.Map == nil {
+			return nil, fmt.Errorf("map %q: missing Map", cm.Name)
+		}
+
+		for _, g := range cm.Gauges {
+			if g.Func == nil {
+				return nil, fmt.Errorf("map %q: gauge %q: missing Func", cm.Name, g.Name)
+			}
+
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", g.Name),
+				g.Help,
+				append([]string{"map"}, g.LabelNames...), nil,
+			)
+
+			if existing := mc.gauges[g.Name]; existing != nil {
+				if existing.String() != desc.String() {
+					return nil, fmt.Errorf("map %q: gauge %q: conflicting definitions", cm.Name, g.Name)
+				}
+				continue
+			}
+
+			mc.gauges[g.Name] = desc
+		}
+	}
+
+	return mc, nil
+}
+
+// Describe implements prometheus.Collector.
+func (mc *MapCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mc.maxEntries
+	ch <- mc.memlock
+	ch <- mc.entries
+	for _, desc := range mc.gauges {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (mc *MapCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, cm := range mc.maps {
+		mc.collectMap(ch, &cm)
+	}
+}
+
+func (mc *MapCollector) collectMap(ch chan<- prometheus.Metric, cm *CollectedMap) {
+	info, err := cm.Map.Info()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(mc.maxEntries, fmt.Errorf("map %q: %w", cm.Name, err))
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(mc.maxEntries, prometheus.GaugeValue, float64(info.MaxEntries), cm.Name)
+
+	if memlock, ok := info.Memlock(); ok {
+		ch <- prometheus.MustNewConstMetric(mc.memlock, prometheus.GaugeValue, float64(memlock), cm.Name)
+	}
+
+	if !cm.CountEntries && len(cm.Gauges) == 0 {
+		return
+	}
+
+	var metrics []prometheus.Metric
+	count, err := iterate(cm.Map, info.MaxEntries, len(cm.Gauges) > 0, func(key, value []byte) error {
+		for _, g := range cm.Gauges {
+			labelValues, v := g.Func(key, value)
+			m, err := prometheus.NewConstMetric(mc.gauges[g.Name], prometheus.GaugeValue, v,
+				append([]string{cm.Name}, labelValues...)...)
+			if err != nil {
+				return fmt.Errorf("gauge %q: %w", g.Name, err)
+			}
+			metrics = append(metrics, m)
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(mc.entries, fmt.Errorf("map %q: %w", cm.Name, err))
+		return
+	}
+
+	if cm.CountEntries {
+		ch <- prometheus.MustNewConstMetric(mc.entries, prometheus.GaugeValue, float64(count), cm.Name)
+	}
+
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// iterate calls fn for every entry of m and returns the number of entries.
+//
+// value is nil unless withValues is true. Entries deleted during iteration
+// are skipped.
+func iterate(m *ebpf.Map, maxEntries uint32, withValues bool, fn func(key, value []byte) error) (int, error) {
+	var (
+		// A nil interface, not a nil slice, starts at the first key.
+		prev  interface{}
+		count int
+	)
+
+	for {
+		key, err := m.NextKeyBytes(prev)
+		if err != nil {
+			return 0, err
+		}
+		if key == nil {
+			return count, nil
+		}
+		prev = key
+
+		// Some map types restart from the first key if the current key is
+		// deleted concurrently, which may lead to an endless loop.
+		if count >= int(maxEntries) {
+			return 0, fmt.Errorf("more than %d entries: %w", maxEntries, ebpf.ErrIterationAborted)
+		}
+
+		var value []byte
+		if withValues {
+			value, err = m.LookupBytes(key)
+			if err != nil {
+				return 0, err
+			}
+			if value == nil {
+				// The entry was deleted in the meantime.
+				continue
+			}
+		}
+
+		if err := fn(key, value); err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMapCollector(t *testing.T) {
+	hash, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 10,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+
+	for i := uint32(1); i <= 3; i++ {
+		if err := hash.Put(i, uint64(i*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mc, err := NewMapCollector("ebpf", CollectedMap{
+		Name:         "hash",
+		Map:          hash,
+		CountEntries: true,
+		Gauges: []KeyGauge{{
+			Name:       "value",
+			Help:       "Value of an entry.",
+			LabelNames: []string{"key"},
+			Func: func(key, value []byte) ([]string, float64) {
+				k := internal.NativeEndian.Uint32(key)
+				return []string{strconv.Itoa(int(k))}, float64(internal.NativeEndian.Uint64(value))
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `
+# HELP ebpf_map_entries Number of entries in a map.
+# TYPE ebpf_map_entries gauge
+ebpf_map_entries{map="hash"} 3
+# HELP ebpf_map_max_entries Maximum number of entries of a map.
+# TYPE ebpf_map_max_entries gauge
+ebpf_map_max_entries{map="hash"} 10
+# HELP ebpf_value Value of an entry.
+# TYPE ebpf_value gauge
+ebpf_value{key="1",map="hash"} 100
+ebpf_value{key="2",map="hash"} 200
+ebpf_value{key="3",map="hash"} 300
+`
+	err = testutil.CollectAndCompare(mc, strings.NewReader(want), "ebpf_map_entries", "ebpf_map_max_entries", "ebpf_value")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(mc, "ebpf_map_memlock_bytes"); n != 1 {
+		t.Errorf("Expected one memlock metric, got %d", n)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(mc); err != nil {
+		t.Fatal("Can't register collector:", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Error("Can't gather metrics:", err)
+	}
+}
+
+func TestNewMapCollectorInvalid(t *testing.T) {
+	var m ebpf.Map
+	fn := func(key, value []byte) ([]string, float64) { return nil, 0 }
+
+	for name, maps := range map[string][]CollectedMap{
+		"no name":      {{Map: &m}},
+		"no map":       {{Name: "a"}},
+		"duplicate":    {{Name: "a", Map: &m}, {Name: "a", Map: &m}},
+		"missing func": {{Name: "a", Map: &m, Gauges: []KeyGauge{{Name: "g"}}}},
+		"conflicting gauges": {
+			{Name: "a", Map: &m, Gauges: []KeyGauge{{Name: "g", Func: fn}}},
+			{Name: "b", Map: &m, Gauges: []KeyGauge{{Name: "g", LabelNames: []string{"key"}, Func: fn}}},
+		},
+	} {
+		if _, err := NewMapCollector("ebpf", maps...); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
+	"unsafe"
 
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
@@ -35,20 +39,57 @@ const (
 // verifier log.
 const DefaultVerifierLogSize = 64 * 1024
 
+// maxVerifierLogSize is the largest log buffer accepted by the kernel.
+const maxVerifierLogSize = math.MaxUint32 >> 2
+
+// defaultVerifierLogSizeMax is the default limit for growing the verifier log
+// buffer.
+const defaultVerifierLogSizeMax = 64 * 1024 * 1024
+
 // ProgramOptions control loading a program into the kernel.
 type ProgramOptions struct {
 	// Controls the detail emitted by the kernel verifier. Set to non-zero
 	// to enable logging.
 	LogLevel uint32
-	// Controls the output buffer size for the verifier. Defaults to
+	// Controls the initial output buffer size for the verifier. Defaults to
 	// DefaultVerifierLogSize.
+	//
+	// The buffer is doubled and the program loaded again if the kernel
+	// truncates the log, up to LogSizeMax.
 	LogSize int
+	// Limits the size the verifier log buffer may grow to. Loading fails
+	// with a truncated VerifierError if the log doesn't fit. Defaults to
+	// 64 MiB, values larger than accepted by the kernel are capped.
+	LogSizeMax int
+	// Receives the output of the verifier instead of Program.VerifierLog
+	// and VerifierError. Optional field.
+	//
+	// The kernel writes the log into a single buffer, see LogSize. The
+	// log is written to LogWriter after each attempt to load the program,
+	// and the buffer is released right after. This avoids keeping large
+	// logs, for example at LogLevel 2, in memory for the lifetime of the
+	// Program or error.
+	LogWriter io.Writer
+	// File descriptors passed to the kernel as fd_array. Instructions
+	// created by asm.LoadMapPtrIdx and asm.LoadMapValueIdx refer to maps
+	// by their index into FDArray. The descriptors must stay open until
+	// the program is loaded.
+	//
+	// Requires at least Linux 5.14.
+	FDArray []int
+	// Load the program and its BTF with the permissions delegated by a
+	// token. Optional field.
+	Token *Token
 	// Type information used for CO-RE relocations and when attaching to
 	// kernel functions.
 	//
 	// This is useful in environments where the kernel BTF is not available
 	// (containers) or where it is in a non-standard location. Defaults to
 	// use the kernel BTF from a well-known location if nil.
+	//
+	// BTF for kernels which don't expose their own, like the files shipped
+	// by BTFHub, can be loaded with btf.LoadSpecFromReader. Use
+	// CollectionOptions.Programs to apply it to a whole collection.
 	KernelTypes *btf.Spec
 }
 
@@ -59,19 +100,39 @@ type ProgramSpec struct {
 	Name string
 
 	// Type determines at which hook in the kernel a program will run.
+	//
+	// When reading an ELF, Type is inferred from the section name following
+	// the libbpf conventions. Programs in unrecognized sections have an
+	// unspecified type and must have Type set before they can be loaded.
 	Type ProgramType
 
 	// AttachType of the program, needed to differentiate allowed context
-	// accesses in some newer program types like CGroupSockAddr.
+	// accesses in some newer program types like CGroupSockAddr. It is passed
+	// to the kernel as the expected attach type.
+	//
+	// Like Type, AttachType is inferred from the ELF section name and may be
+	// overridden before loading the program.
 	//
 	// Available on kernels 4.17 and later.
 	AttachType AttachType
 
 	// Name of a kernel data structure or function to attach to. Its
 	// interpretation depends on Type and AttachType.
+	//
+	// For fentry, fexit and fmod_ret programs this is the name of a kernel
+	// function. It's resolved against ProgramOptions.KernelTypes or the
+	// kernel BTF, so there is no need to look up a BTF ID manually.
+	//
+	// For StructOps programs this is the kernel struct and the function
+	// pointer member implemented by the program, like
+	// "tcp_congestion_ops:ssthresh". It's set automatically for programs
+	// referenced from a .struct_ops section.
 	AttachTo string
 
 	// The program to attach to. Must be provided manually.
+	//
+	// For Extension programs AttachTo is the name of the function in
+	// AttachTarget which is replaced.
 	AttachTarget *Program
 
 	// The name of the ELF section this program orininated from.
@@ -118,11 +179,102 @@ func (ps *ProgramSpec) Copy() *ProgramSpec {
 
 // Tag calculates the kernel tag for a series of instructions.
 //
+// The tag is derived from a SHA-1 digest, like kernels before 6.18 do.
+// Kernels starting from 6.18 use TagSHA256 instead. Compare ProgramInfo.Tag
+// to both if the kernel version isn't known.
+//
 // Use asm.Instructions.Tag if you need to calculate for non-native endianness.
 func (ps *ProgramSpec) Tag() (string, error) {
 	return ps.Instructions.Tag(internal.NativeEndian)
 }
 
+// TagSHA256 calculates the kernel tag for a series of instructions the way
+// Linux 6.18 and later do.
+//
+// See Tag for details.
+func (ps *ProgramSpec) TagSHA256() (string, error) {
+	return ps.Instructions.TagSHA256(internal.NativeEndian)
+}
+
+// RewriteConstants replaces the value of constants loaded by the program.
+//
+// A constant is a 64 bit immediate load which references a symbol, for
+// example:
+//
+//	asm.LoadImm(asm.R1, 0, asm.DWord).WithReference("port")
+//
+// Replacement values must be integers or booleans. Use
+// CollectionSpec.RewriteConstants to replace constants stored in .rodata.
+//
+// Returns an error if a constant isn't referenced by the program, in which
+// case no constants are replaced.
+func (ps *ProgramSpec) RewriteConstants(consts map[string]interface{}) error {
+	values := make(map[string]int64, len(consts))
+	for name, value := range consts {
+		v, err := constantValue(value)
+		if err != nil {
+			return fmt.Errorf("constant %s: %w", name, err)
+		}
+		values[name] = v
+	}
+
+	// Validate all references before modifying any instruction.
+	var targets []int
+	replaced := make(map[string]bool)
+	for i := range ps.Instructions {
+		ins := &ps.Instructions[i]
+
+		name := ins.Reference()
+		if _, ok := values[name]; !ok {
+			continue
+		}
+
+		if !ins.IsConstantLoad(asm.DWord) {
+			return fmt.Errorf("constant %s: instruction %d is not a 64 bit immediate load", name, i)
+		}
+
+		targets = append(targets, i)
+		replaced[name] = true
+	}
+
+	var missing []string
+	for name := range consts {
+		if !replaced[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("program is missing one or more constants: %s", strings.Join(missing, ","))
+	}
+
+	for _, i := range targets {
+		ins := &ps.Instructions[i]
+		ins.Constant = values[ins.Reference()]
+	}
+
+	return nil
+}
+
+// constantValue converts an integer or boolean to the immediate of a load.
+func constantValue(value interface{}) (int64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported type %T", value)
+	}
+}
+
 type VerifierError = internal.VerifierError
 
 // Program represents BPF program loaded into the kernel.
@@ -174,11 +326,14 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 	}
 
 	if spec.Type == UnspecifiedProgram {
+		if spec.SectionName != "" {
+			return nil, fmt.Errorf("can't load program of unspecified type: unrecognized section name %q", spec.SectionName)
+		}
 		return nil, errors.New("can't load program of unspecified type")
 	}
 
-	if spec.ByteOrder != nil && spec.ByteOrder != internal.NativeEndian {
-		return nil, fmt.Errorf("can't load %s program on %s", spec.ByteOrder, internal.NativeEndian)
+	if err := internal.CheckByteOrder(spec.ByteOrder); err != nil {
+		return nil, fmt.Errorf("can't load program: %w", err)
 	}
 
 	// Kernels before 5.0 (6c4fc209fcf9 "bpf: remove useless version check for prog load")
@@ -206,6 +361,11 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 		attr.ProgName = sys.NewObjName(spec.Name)
 	}
 
+	if opts.Token != nil {
+		attr.ProgTokenFd = int32(opts.Token.FD())
+		attr.ProgFlags |= unix.BPF_F_TOKEN_FD
+	}
+
 	kernelTypes := opts.KernelTypes
 
 	insns := make(asm.Instructions, len(spec.Instructions))
@@ -217,7 +377,7 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 			return nil, fmt.Errorf("apply CO-RE relocations: %w", err)
 		}
 
-		handle, err := handles.btfHandle(spec.BTF)
+		handle, err := handles.btfHandle(spec.BTF, opts.Token)
 		btfDisabled = errors.Is(err, btf.ErrNotSupported)
 		if err != nil && !btfDisabled {
 			return nil, fmt.Errorf("load BTF: %w", err)
@@ -241,6 +401,10 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 		}
 	}
 
+	if err := fixupKsyms(insns, kernelTypes); err != nil {
+		return nil, err
+	}
+
 	if err := fixupAndValidate(insns); err != nil {
 		return nil, err
 	}
@@ -255,6 +419,18 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 	attr.Insns = sys.NewSlicePointer(bytecode)
 	attr.InsnCnt = uint32(len(bytecode) / asm.InstructionSize)
 
+	if len(opts.FDArray) > 0 {
+		fds := make([]int32, 0, len(opts.FDArray))
+		for i, fd := range opts.FDArray {
+			if fd < 0 || int(int32(fd)) != fd {
+				return nil, fmt.Errorf("fd_array entry %d: invalid file descriptor %d", i, fd)
+			}
+			fds = append(fds, int32(fd))
+		}
+		attr.FdArray = sys.NewPointer(unsafe.Pointer(&fds[0]))
+		defer runtime.KeepAlive(fds)
+	}
+
 	if spec.AttachTarget != nil {
 		targetID, err := findTargetInProgram(spec.AttachTarget, spec.AttachTo, spec.Type, spec.AttachType)
 		if err != nil {
@@ -264,6 +440,16 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 		attr.AttachBtfId = uint32(targetID)
 		attr.AttachProgFd = uint32(spec.AttachTarget.FD())
 		defer runtime.KeepAlive(spec.AttachTarget)
+	} else if spec.Type == StructOps && spec.AttachTo != "" {
+		// struct_ops programs implement a member of a kernel struct, which
+		// is identified by its index instead of an attach type.
+		targetID, member, err := findStructOpsTarget(kernelTypes, spec.AttachTo)
+		if err != nil {
+			return nil, fmt.Errorf("attach %s/%s: %w", spec.Type, spec.AttachTo, err)
+		}
+
+		attr.AttachBtfId = uint32(targetID)
+		attr.ExpectedAttachType = sys.AttachType(member)
 	} else if spec.AttachTo != "" {
 		targetID, err := findTargetInKernel(kernelTypes, spec.AttachTo, spec.Type, spec.AttachType)
 		if err != nil && !errors.Is(err, errUnrecognizedAttachType) {
@@ -280,26 +466,72 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 		logSize = opts.LogSize
 	}
 
+	logSizeMax := defaultVerifierLogSizeMax
+	if opts.LogSizeMax > 0 {
+		logSizeMax = opts.LogSizeMax
+	}
+	if logSizeMax > maxVerifierLogSize {
+		logSizeMax = maxVerifierLogSize
+	}
+	if logSize > logSizeMax {
+		logSize = logSizeMax
+	}
+
 	var logBuf []byte
-	if opts.LogLevel > 0 {
+	setLog := func(level uint32) {
 		logBuf = make([]byte, logSize)
-		attr.LogLevel = opts.LogLevel
+		attr.LogLevel = level
 		attr.LogSize = uint32(len(logBuf))
 		attr.LogBuf = sys.NewSlicePointer(logBuf)
 	}
 
-	fd, err := sys.ProgLoad(attr)
+	// loadGrowingLog loads the program, and retries with a larger log buffer
+	// as long as the kernel reports that the log was truncated. The final
+	// log is passed to LogWriter, if any, and failing to write it is
+	// recorded in writeErr.
+	var writeErr error
+	loadGrowingLog := func() (*sys.FD, error) {
+		for {
+			fd, err := sys.ProgLoad(attr)
+			if !errors.Is(err, unix.ENOSPC) || logSize >= logSizeMax {
+				if opts.LogWriter != nil && writeErr == nil {
+					writeErr = writeVerifierLog(opts.LogWriter, logBuf)
+				}
+				return fd, err
+			}
+
+			logSize *= 2
+			if logSize > logSizeMax {
+				logSize = logSizeMax
+			}
+			setLog(attr.LogLevel)
+		}
+	}
+
+	var fd *sys.FD
+	if opts.LogLevel > 0 {
+		setLog(opts.LogLevel)
+		fd, err = loadGrowingLog()
+	} else {
+		fd, err = sys.ProgLoad(attr)
+	}
 	if err == nil {
+		if writeErr != nil {
+			fd.Close()
+			return nil, writeErr
+		}
+		if opts.LogWriter != nil {
+			return &Program{"", fd, spec.Name, "", spec.Type}, nil
+		}
 		return &Program{unix.ByteSliceToString(logBuf), fd, spec.Name, "", spec.Type}, nil
 	}
 
 	if opts.LogLevel == 0 && opts.LogSize >= 0 {
 		// Re-run with the verifier enabled to get better error messages.
-		logBuf = make([]byte, logSize)
-		attr.LogLevel = 1
-		attr.LogSize = uint32(len(logBuf))
-		attr.LogBuf = sys.NewSlicePointer(logBuf)
-		_, _ = sys.ProgLoad(attr)
+		setLog(1)
+		if fd, err := loadGrowingLog(); err == nil {
+			fd.Close()
+		}
 	}
 
 	switch {
@@ -320,6 +552,14 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 		}
 	}
 
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if opts.LogWriter != nil {
+		// The log has been passed to LogWriter already.
+		logBuf = nil
+	}
+
 	err = internal.ErrorWithLog(err, logBuf)
 	if btfDisabled {
 		return nil, fmt.Errorf("load program: %w (BTF disabled)", err)
@@ -327,6 +567,22 @@ func newProgramWithOptions(spec *ProgramSpec, opts ProgramOptions, handles *hand
 	return nil, fmt.Errorf("load program: %w", err)
 }
 
+// writeVerifierLog writes the NUL terminated contents of buf to w.
+func writeVerifierLog(w io.Writer, buf []byte) error {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("write verifier log: %w", err)
+	}
+	return nil
+}
+
 // NewProgramFromFD creates a program from a raw fd.
 //
 // You should not use fd after calling this function.
@@ -343,6 +599,9 @@ func NewProgramFromFD(fd int) (*Program, error) {
 
 // NewProgramFromID returns the program for a given id.
 //
+// The type and name of the program are queried from the kernel. Requires
+// CAP_SYS_ADMIN.
+//
 // Returns ErrNotExist, if there is no eBPF program with the given id.
 func NewProgramFromID(id ProgramID) (*Program, error) {
 	fd, err := sys.ProgGetFdById(&sys.ProgGetFdByIdAttr{
@@ -356,13 +615,18 @@ func NewProgramFromID(id ProgramID) (*Program, error) {
 }
 
 func newProgramFromFD(fd *sys.FD) (*Program, error) {
+	if err := fd.CheckAnonInode("bpf-prog"); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
 	info, err := newProgramInfoFromFd(fd)
 	if err != nil {
 		fd.Close()
 		return nil, fmt.Errorf("discover program type: %w", err)
 	}
 
-	return &Program{"", fd, "", "", info.Type}, nil
+	return &Program{"", fd, info.Name, "", info.Type}, nil
 }
 
 func (p *Program) String() string {
@@ -384,6 +648,38 @@ func (p *Program) Info() (*ProgramInfo, error) {
 	return newProgramInfoFromFd(p.fd)
 }
 
+// ProgramStats contains runtime statistics of a program.
+//
+// The kernel only collects RunCount and Runtime while statistics are
+// enabled, see EnableStats.
+type ProgramStats struct {
+	// Total accumulated runtime of the program.
+	Runtime time.Duration
+	// Total number of times the program was called.
+	RunCount uint64
+	// Total number of times the program was not called because it was
+	// already running on the same CPU. Available from 5.12.
+	RecursionMisses uint64
+}
+
+// Stats returns runtime statistics of the program.
+//
+// It's cheaper than Info since it only retrieves the statistics.
+//
+// Requires at least 5.1.
+func (p *Program) Stats() (*ProgramStats, error) {
+	var info sys.ProgInfo
+	if err := sys.ObjInfo(p.fd, &info); err != nil {
+		return nil, fmt.Errorf("get program info: %w", err)
+	}
+
+	return &ProgramStats{
+		Runtime:         time.Duration(info.RunTimeNs),
+		RunCount:        info.RunCnt,
+		RecursionMisses: info.RecursionMisses,
+	}, nil
+}
+
 // FD gets the file descriptor of the Program.
 //
 // It is invalid to call this function after Close has been called.
@@ -451,21 +747,32 @@ func (p *Program) Close() error {
 	return p.fd.Close()
 }
 
+// RunOnCPU is a flag for RunOptions which runs the program on RunOptions.CPU
+// instead of the CPU of the calling thread.
+//
+// Supported by RawTracepoint programs since Linux 5.10. Other program
+// types reject the flag.
+const RunOnCPU = unix.BPF_F_TEST_RUN_ON_CPU
+
 // Various options for Run'ing a Program
 type RunOptions struct {
-	// Program's data input. Required field.
+	// Program's data input. Required field, except for Syscall programs and
+	// program types which only take a Context, like RawTracepoint.
 	Data []byte
 	// Program's data after Program has run. Caller must allocate. Optional field.
 	DataOut []byte
 	// Program's context input. Optional field.
 	Context interface{}
 	// Program's context after Program has run. Must be a pointer or slice. Optional field.
+	//
+	// Syscall programs modify Context in place, which is then decoded into
+	// ContextOut.
 	ContextOut interface{}
 	// Number of times to run Program. Optional field. Defaults to 1.
 	Repeat uint32
-	// Optional flags.
+	// Optional flags, see RunOnCPU.
 	Flags uint32
-	// CPU to run Program on. Optional field.
+	// CPU to run Program on. Only used if Flags contains RunOnCPU.
 	// Note not all program types support this field.
 	CPU uint32
 	// Called whenever the syscall is interrupted, and should be set to testing.B.ResetTimer
@@ -474,7 +781,8 @@ type RunOptions struct {
 }
 
 // Test runs the Program in the kernel with the given input and returns the
-// value returned by the eBPF program. outLen may be zero.
+// value returned by the eBPF program and the output data. The output may
+// differ in length from the input if the program resized the packet.
 //
 // Note: the kernel expects at least 14 bytes input for an ethernet header for
 // XDP and SKB programs.
@@ -503,6 +811,11 @@ func (p *Program) Test(in []byte) (uint32, []byte, error) {
 
 // Run runs the Program in kernel with given RunOptions.
 //
+// Returns the value returned by the eBPF program. The output data and
+// context are written to opts.DataOut and opts.ContextOut, DataOut is
+// truncated to the length of the output. Use Benchmark to measure the
+// duration of a run.
+//
 // Note: the same restrictions from Test apply.
 func (p *Program) Run(opts *RunOptions) (uint32, error) {
 	ret, _, err := p.testRun(opts)
@@ -516,14 +829,19 @@ func (p *Program) Run(opts *RunOptions) (uint32, error) {
 // and returns the time taken per iteration.
 //
 // Returns the result of the last execution of the program and the time per
-// run or an error. reset is called whenever the benchmark syscall is
-// interrupted, and should be set to testing.B.ResetTimer or similar.
+// run or an error. The kernel runs the program repeat times in a loop and
+// reports the average, a repeat of zero is treated as one. reset is called
+// whenever the benchmark syscall is interrupted, and should be set to
+// testing.B.ResetTimer or similar.
 //
 // Note: profiling a call to this function will skew it's results, see
 // https://github.com/cilium/ebpf/issues/24
 //
 // This function requires at least Linux 4.12.
 func (p *Program) Benchmark(in []byte, repeat int, reset func()) (uint32, time.Duration, error) {
+	if repeat < 0 {
+		return 0, 0, fmt.Errorf("repeat must not be negative")
+	}
 	if uint(repeat) > math.MaxUint32 {
 		return 0, 0, fmt.Errorf("repeat is too high")
 	}
@@ -587,7 +905,7 @@ var haveProgTestRun = internal.FeatureTest("BPF_PROG_TEST_RUN", "4.12", func() e
 })
 
 func (p *Program) testRun(opts *RunOptions) (uint32, time.Duration, error) {
-	if len(opts.Data) == 0 {
+	if len(opts.Data) == 0 && opts.Context == nil && p.Type() != Syscall {
 		return 0, 0, fmt.Errorf("missing input")
 	}
 
@@ -628,6 +946,13 @@ func (p *Program) testRun(opts *RunOptions) (uint32, time.Duration, error) {
 		Cpu:         opts.CPU,
 	}
 
+	if p.Type() == Syscall {
+		// The kernel writes the context of a Syscall program back into
+		// the input buffer and rejects an output buffer.
+		attr.CtxSizeOut = 0
+		attr.CtxOut = sys.Pointer{}
+	}
+
 	for {
 		err := sys.ProgRun(&attr)
 		if err == nil {
@@ -657,6 +982,10 @@ func (p *Program) testRun(opts *RunOptions) (uint32, time.Duration, error) {
 		opts.DataOut = opts.DataOut[:int(attr.DataSizeOut)]
 	}
 
+	if p.Type() == Syscall {
+		copy(ctxOut, ctxBytes)
+	}
+
 	if len(ctxOut) != 0 {
 		b := bytes.NewReader(ctxOut)
 		if err := binary.Read(b, internal.NativeEndian, opts.ContextOut); err != nil {
@@ -691,6 +1020,8 @@ func marshalProgram(p *Program, length int) ([]byte, error) {
 
 // LoadPinnedProgram loads a Program from a BPF file.
 //
+// Returns an error if the file refers to a map or link instead of a program.
+//
 // Requires at least Linux 4.11.
 func LoadPinnedProgram(fileName string, opts *LoadPinOptions) (*Program, error) {
 	fd, err := sys.ObjGet(&sys.ObjGetAttr{
@@ -701,6 +1032,11 @@ func LoadPinnedProgram(fileName string, opts *LoadPinOptions) (*Program, error)
 		return nil, err
 	}
 
+	if err := fd.CheckAnonInode("bpf-prog"); err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
 	info, err := newProgramInfoFromFd(fd)
 	if err != nil {
 		_ = fd.Close()
@@ -728,25 +1064,58 @@ func SanitizeName(name string, replacement rune) string {
 
 // ProgramGetNextID returns the ID of the next eBPF program.
 //
+// Pass ProgramID(0) to get the first program. Together with
+// NewProgramFromID this allows enumerating all programs on the system,
+// including programs loaded by other processes. Requires CAP_SYS_ADMIN.
+//
 // Returns ErrNotExist, if there is no next eBPF program.
 func ProgramGetNextID(startID ProgramID) (ProgramID, error) {
 	attr := &sys.ProgGetNextIdAttr{Id: uint32(startID)}
-	return ProgramID(attr.NextId), sys.ProgGetNextId(attr)
+	if err := sys.ProgGetNextId(attr); err != nil {
+		return 0, err
+	}
+	return ProgramID(attr.NextId), nil
 }
 
 // BindMap binds map to the program and is only released once program is released.
 //
 // This may be used in cases where metadata should be associated with the program
-// which otherwise does not contain any references to the map.
+// which otherwise does not contain any references to the map. Binding a map
+// which is already used by the program is a no-op.
+//
+// Requires at least Linux 5.10.
 func (p *Program) BindMap(m *Map) error {
+	if err := haveProgBindMap(); err != nil {
+		return err
+	}
+
 	attr := &sys.ProgBindMapAttr{
 		ProgFd: uint32(p.FD()),
 		MapFd:  uint32(m.FD()),
 	}
 
-	return sys.ProgBindMap(attr)
+	if err := sys.ProgBindMap(attr); err != nil {
+		return fmt.Errorf("bind map %s: %w", m, err)
+	}
+	return nil
 }
 
+var haveProgBindMap = internal.FeatureTest("BPF_PROG_BIND_MAP", "5.10", func() error {
+	// Invalid file descriptors make the command fail with EBADF if it
+	// exists, without having to create any objects.
+	err := sys.ProgBindMap(&sys.ProgBindMapAttr{
+		ProgFd: math.MaxUint32,
+		MapFd:  math.MaxUint32,
+	})
+	if errors.Is(err, unix.EINVAL) {
+		return internal.ErrNotSupported
+	}
+	if errors.Is(err, unix.EBADF) {
+		return nil
+	}
+	return err
+})
+
 var errUnrecognizedAttachType = errors.New("unrecognized attach type")
 
 // find an attach target type in the kernel.
@@ -835,6 +1204,10 @@ func findTargetInProgram(prog *Program, name string, progType ProgramType, attac
 		return 0, errUnrecognizedAttachType
 	}
 
+	if typeName == "" {
+		return 0, fmt.Errorf("missing name of the function to replace in %s", prog)
+	}
+
 	info, err := prog.Info()
 	if err != nil {
 		return 0, fmt.Errorf("load target BTF: %w", err)
@@ -842,7 +1215,7 @@ func findTargetInProgram(prog *Program, name string, progType ProgramType, attac
 
 	btfID, ok := info.BTFID()
 	if !ok {
-		return 0, fmt.Errorf("load target BTF: no BTF info available")
+		return 0, fmt.Errorf("load target BTF: %s was loaded without BTF", prog)
 	}
 
 	btfHandle, err := btf.NewHandleFromID(btfID)
@@ -88,6 +88,23 @@ func TestProgramRun(t *testing.T) {
 	if !bytes.Equal(out[:len(pat)], pat) {
 		t.Errorf("Expected %v, got %v", pat, out)
 	}
+
+	opts := RunOptions{
+		Data:    buf,
+		DataOut: make([]byte, 2*len(buf)),
+	}
+	ret, err = prog.Run(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret != 42 {
+		t.Error("Expected return value to be 42, got", ret)
+	}
+
+	if !bytes.Equal(opts.DataOut, out) {
+		t.Errorf("Expected DataOut to be %v, got %v", out, opts.DataOut)
+	}
 }
 
 func TestProgramRunWithOptions(t *testing.T) {
@@ -136,6 +153,114 @@ func TestProgramRunWithOptions(t *testing.T) {
 	}
 }
 
+func TestProgramRunContextOnly(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.10", "BPF_PROG_TEST_RUN for raw tracepoints")
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type: RawTracepoint,
+		Instructions: asm.Instructions{
+			// r0 = *(u64 *)(r1 + 8)
+			asm.LoadMem(asm.R0, asm.R1, 8, asm.DWord),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	ret, err := prog.Run(&RunOptions{
+		Context: [2]uint64{23, 42},
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret != 42 {
+		t.Error("Expected return value 42, got", ret)
+	}
+
+	if _, err := prog.Run(&RunOptions{}); err == nil {
+		t.Error("Run without data or context doesn't return an error")
+	}
+}
+
+func TestProgramRunOnCPU(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.10", "BPF_F_TEST_RUN_ON_CPU")
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type: RawTracepoint,
+		Instructions: asm.Instructions{
+			asm.FnGetSmpProcessorId.Call(),
+			asm.Return(),
+		},
+		License: "MIT",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	for _, cpu := range []uint32{0, uint32(runtime.NumCPU() - 1)} {
+		ret, err := prog.Run(&RunOptions{
+			Context: [1]uint64{},
+			Flags:   RunOnCPU,
+			CPU:     cpu,
+		})
+		testutils.SkipIfNotSupported(t, err)
+		if err != nil {
+			t.Fatalf("CPU %d: %s", cpu, err)
+		}
+
+		if ret != cpu {
+			t.Errorf("Expected program to run on CPU %d, got %d", cpu, ret)
+		}
+	}
+}
+
+func TestProgramRunSyscall(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.14", "BPF_PROG_TYPE_SYSCALL")
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type: Syscall,
+		Instructions: asm.Instructions{
+			// r0 = *(u32 *)(r1 + 0) + 1
+			asm.LoadMem(asm.R0, asm.R1, 0, asm.Word),
+			asm.Add.Imm(asm.R0, 1),
+			// *(u32 *)(r1 + 0) = r0
+			asm.StoreMem(asm.R1, 0, asm.R0, asm.Word),
+			asm.Return(),
+		},
+		Flags:   unix.BPF_F_SLEEPABLE,
+		License: "MIT",
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	var out uint32
+	ret, err := prog.Run(&RunOptions{
+		Context:    uint32(41),
+		ContextOut: &out,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret != 42 {
+		t.Error("Expected return value 42, got", ret)
+	}
+
+	if out != 42 {
+		t.Error("Expected context to be modified to 42, got", out)
+	}
+}
+
 func TestProgramBenchmark(t *testing.T) {
 	prog := mustSocketFilter(t)
 
@@ -152,6 +277,10 @@ func TestProgramBenchmark(t *testing.T) {
 	if duration == 0 {
 		t.Error("Expected non-zero duration")
 	}
+
+	if _, _, err := prog.Benchmark(make([]byte, 14), -1, nil); err == nil {
+		t.Error("Benchmark accepts a negative repeat")
+	}
 }
 
 func TestProgramTestRunInterrupt(t *testing.T) {
@@ -288,6 +417,31 @@ func TestProgramUnpin(t *testing.T) {
 	}
 }
 
+func TestProgramFromFDNotAProgram(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	dup, err := m.fd.Dup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NewProgramFromFD takes ownership of the fd.
+	dup.Forget()
+
+	if prog, err := NewProgramFromFD(dup.Int()); err == nil {
+		prog.Close()
+		t.Fatal("NewProgramFromFD accepts a map")
+	}
+}
+
 func TestProgramLoadPinnedWithFlags(t *testing.T) {
 	// Introduced in commit 6e71b04a8224.
 	testutils.SkipOnOldKernel(t, "4.14", "file_flags in BPF_OBJ_GET")
@@ -334,6 +488,109 @@ func TestProgramVerifierOutputOnError(t *testing.T) {
 	}
 }
 
+func TestProgramVerifierLogGrows(t *testing.T) {
+	insns := asm.Instructions{asm.LoadImm(asm.R0, 0, asm.DWord)}
+	for i := 0; i < 32; i++ {
+		insns = append(insns, asm.Add.Imm(asm.R0, 1))
+	}
+	insns = append(insns, asm.Return())
+
+	// The kernel rejects log buffers smaller than 128 bytes.
+	const logSize = 128
+	prog, err := NewProgramWithOptions(&ProgramSpec{
+		Type:         SocketFilter,
+		Instructions: insns,
+		License:      "MIT",
+	}, ProgramOptions{
+		LogLevel: 2,
+		LogSize:  logSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	if len(prog.VerifierLog) <= logSize {
+		t.Errorf("Expected a verifier log longer than %d bytes, got %d", logSize, len(prog.VerifierLog))
+	}
+}
+
+func TestProgramVerifierLogSizeMax(t *testing.T) {
+	insns := asm.Instructions{asm.LoadImm(asm.R0, 0, asm.DWord)}
+	for i := 0; i < 32; i++ {
+		insns = append(insns, asm.Add.Imm(asm.R0, 1))
+	}
+	insns = append(insns, asm.Return())
+
+	const logSizeMax = 256
+	_, err := NewProgramWithOptions(&ProgramSpec{
+		Type:         SocketFilter,
+		Instructions: insns,
+		License:      "MIT",
+	}, ProgramOptions{
+		LogLevel:   2,
+		LogSize:    128,
+		LogSizeMax: logSizeMax,
+	})
+	if err == nil {
+		t.Fatal("Expected loading to fail with a truncated log")
+	}
+
+	var ve *VerifierError
+	if !errors.As(err, &ve) {
+		t.Fatal("Error does not contain a VerifierError")
+	}
+	if !ve.Truncated {
+		t.Error("Expected the log to be truncated")
+	}
+	if n := len(strings.Join(ve.Log, "\n")); n > logSizeMax {
+		t.Errorf("Expected a log of at most %d bytes, got %d", logSizeMax, n)
+	}
+}
+
+func TestProgramVerifierLogWriter(t *testing.T) {
+	var log bytes.Buffer
+	prog, err := NewProgramWithOptions(socketFilterSpec, ProgramOptions{
+		LogLevel:  2,
+		LogWriter: &log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	if prog.VerifierLog != "" {
+		t.Error("VerifierLog should be empty when using LogWriter")
+	}
+	if !strings.Contains(log.String(), "processed") {
+		t.Errorf("LogWriter didn't receive the verifier log: %q", log.String())
+	}
+	if bytes.IndexByte(log.Bytes(), 0) != -1 {
+		t.Error("Log contains NUL bytes")
+	}
+
+	log.Reset()
+	spec := socketFilterSpec.Copy()
+	spec.Instructions = asm.Instructions{asm.Return()}
+	_, err = NewProgramWithOptions(spec, ProgramOptions{
+		LogWriter: &log,
+	})
+	if err == nil {
+		t.Fatal("Expected program without return value to be rejected")
+	}
+	if !strings.Contains(log.String(), "R0 !read_ok") {
+		t.Errorf("LogWriter didn't receive the log of a failed load: %q", log.String())
+	}
+
+	var ve *VerifierError
+	if !errors.As(err, &ve) {
+		t.Fatal("Error does not contain a VerifierError")
+	}
+	if strings.Join(ve.Log, "") != "" {
+		t.Error("VerifierError contains the log written to LogWriter")
+	}
+}
+
 func TestProgramKernelVersion(t *testing.T) {
 	testutils.SkipOnOldKernel(t, "4.20", "KernelVersion")
 	prog, err := NewProgram(&ProgramSpec{
@@ -546,7 +803,11 @@ func TestNewProgramFromID(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Can't get FD for program ID %d: %v", id, err)
 	}
-	prog2.Close()
+	defer prog2.Close()
+
+	if prog2.name != info.Name {
+		t.Errorf("Expected name %q, got %q", info.Name, prog2.name)
+	}
 
 	// As there can be multiple programs, we use max(uint32) as ProgramID to trigger an expected error.
 	_, err = NewProgramFromID(ProgramID(math.MaxUint32))
@@ -555,6 +816,41 @@ func TestNewProgramFromID(t *testing.T) {
 	}
 }
 
+func TestFindTargetInKernel(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.5", "bpf_fentry_test1")
+
+	spec, err := btf.LoadKernelSpec()
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, attachType := range []AttachType{AttachTraceFEntry, AttachTraceFExit, AttachModifyReturn} {
+		id, err := findTargetInKernel(spec, "bpf_fentry_test1", Tracing, attachType)
+		if err != nil {
+			t.Fatalf("%s: %s", attachType, err)
+		}
+
+		typ, err := spec.TypeByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fn, ok := typ.(*btf.Func); !ok || fn.Name != "bpf_fentry_test1" {
+			t.Errorf("%s: resolved to %s instead of bpf_fentry_test1", attachType, typ)
+		}
+	}
+
+	_, err = findTargetInKernel(spec, "no_such_function", Tracing, AttachTraceFEntry)
+	if !errors.Is(err, ErrNotSupported) {
+		t.Error("Missing function doesn't return ErrNotSupported:", err)
+	}
+
+	_, err = findTargetInKernel(spec, "bpf_fentry_test1", SocketFilter, AttachNone)
+	if !errors.Is(err, errUnrecognizedAttachType) {
+		t.Error("Expected errUnrecognizedAttachType, got", err)
+	}
+}
+
 func TestProgramRejectIncorrectByteOrder(t *testing.T) {
 	spec := socketFilterSpec.Copy()
 
@@ -569,6 +865,28 @@ func TestProgramRejectIncorrectByteOrder(t *testing.T) {
 	}
 }
 
+func TestProgramUnrecognizedSection(t *testing.T) {
+	spec := socketFilterSpec.Copy()
+	spec.Type = UnspecifiedProgram
+	spec.SectionName = "unknown/foo"
+
+	_, err := NewProgram(spec)
+	if err == nil {
+		t.Fatal("Program of unspecified type should be rejected at load time")
+	}
+	if !strings.Contains(err.Error(), spec.SectionName) {
+		t.Error("Error doesn't mention the section name:", err)
+	}
+
+	// Overriding the inferred type allows loading the program.
+	spec.Type = SocketFilter
+	prog, err := NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.Close()
+}
+
 func TestProgramSpecTag(t *testing.T) {
 	arr := createArray(t)
 	defer arr.Close()
@@ -596,14 +914,63 @@ func TestProgramSpecTag(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tag, err := spec.Tag()
+	tag := kernelTag(t, spec)
+	if tag != info.Tag {
+		t.Errorf("Calculated tag %s doesn't match kernel tag %s", tag, info.Tag)
+	}
+}
+
+func TestHaveSHA256Tags(t *testing.T) {
+	testutils.CheckFeatureTest(t, haveSHA256Tags)
+}
+
+var haveSHA256Tags = internal.FeatureTest("SHA-256 program tags", "6.18", func() error {
+	insns := asm.Instructions{
+		asm.LoadImm(asm.R0, 0, asm.DWord),
+		asm.Return(),
+	}
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type:         SocketFilter,
+		Instructions: insns,
+		License:      "MIT",
+	})
 	if err != nil {
-		t.Fatal("Can't calculate tag:", err)
+		return err
 	}
+	defer prog.Close()
 
-	if tag != info.Tag {
-		t.Errorf("Calculated tag %s doesn't match kernel tag %s", tag, info.Tag)
+	info, err := prog.Info()
+	if err != nil {
+		return err
+	}
+
+	tag, err := insns.TagSHA256(internal.NativeEndian)
+	if err != nil {
+		return err
+	}
+
+	if info.Tag != tag {
+		return internal.ErrNotSupported
+	}
+	return nil
+})
+
+// kernelTag calculates the tag of spec with the algorithm used by the running
+// kernel.
+func kernelTag(tb testing.TB, spec *ProgramSpec) string {
+	tb.Helper()
+
+	tag := spec.TagSHA256
+	if errors.Is(haveSHA256Tags(), ErrNotSupported) {
+		tag = spec.Tag
+	}
+
+	s, err := tag()
+	if err != nil {
+		tb.Fatal("Can't calculate tag:", err)
 	}
+	return s
 }
 
 func TestProgramTypeLSM(t *testing.T) {
@@ -703,7 +1070,125 @@ func TestProgramBindMap(t *testing.T) {
 	// the metadata part of the program will be empty. This
 	// test just makes sure that we can bind a map to a program.
 	if err := prog.BindMap(arr); err != nil {
-		t.Errorf("Failed to bind map to program: %v", err)
+		t.Fatalf("Failed to bind map to program: %v", err)
+	}
+
+	info, err := prog.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapInfo, err := arr.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapID, _ := mapInfo.ID()
+
+	ids, _ := info.MapIDs()
+	if len(ids) != 1 || ids[0] != mapID {
+		t.Errorf("Expected map IDs [%d], got %v", mapID, ids)
+	}
+}
+
+func TestHaveProgBindMap(t *testing.T) {
+	testutils.CheckFeatureTest(t, haveProgBindMap)
+}
+
+func TestProgramFDArray(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.14", "fd_array")
+
+	m, err := NewMap(&MapSpec{
+		Type:       Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &ProgramSpec{
+		Type: SocketFilter,
+		Instructions: asm.Instructions{
+			asm.LoadMapValueIdx(asm.R1, 1, 0),
+			asm.LoadMem(asm.R0, asm.R1, 0, asm.Word),
+			asm.Return(),
+		},
+		License: "MIT",
+	}
+
+	if _, err := NewProgram(spec); err == nil {
+		t.Fatal("Loading a program without fd_array should fail")
+	}
+
+	prog, err := NewProgramWithOptions(spec, ProgramOptions{
+		FDArray: []int{m.FD(), m.FD()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 42 {
+		t.Errorf("Expected value 42 from the map, got %d", ret)
+	}
+}
+
+func TestProgramSpecRewriteConstants(t *testing.T) {
+	spec := &ProgramSpec{
+		Type: SocketFilter,
+		Instructions: asm.Instructions{
+			asm.LoadImm(asm.R0, 0, asm.DWord).WithReference("ret"),
+			asm.Return(),
+		},
+		License: "MIT",
+	}
+
+	if err := spec.RewriteConstants(map[string]interface{}{"ret": uint32(42)}); err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 42 {
+		t.Errorf("Expected return value 42, got %d", ret)
+	}
+
+	if err := spec.RewriteConstants(map[string]interface{}{"ret": 1, "missing": 1}); err == nil {
+		t.Error("Rewriting a missing constant should fail")
+	}
+	if c := spec.Instructions[0].Constant; c != 42 {
+		t.Errorf("Failed call modified the spec: expected constant 42, got %d", c)
+	}
+
+	if err := spec.RewriteConstants(map[string]interface{}{"ret": "foo"}); err == nil {
+		t.Error("Rewriting a constant with a string should fail")
+	}
+
+	spec.Instructions = append(spec.Instructions, asm.Mov.Imm(asm.R0, 0).WithReference("ret"))
+	if err := spec.RewriteConstants(map[string]interface{}{"ret": 1}); err == nil {
+		t.Error("Rewriting a non-load instruction should fail")
+	}
+	if c := spec.Instructions[0].Constant; c != 42 {
+		t.Errorf("Failed call modified the spec: expected constant 42, got %d", c)
 	}
 }
 
@@ -751,6 +1236,31 @@ func TestProgramInstructions(t *testing.T) {
 	}
 }
 
+func TestProgramJitedInstructions(t *testing.T) {
+	jit, err := os.ReadFile("/proc/sys/net/core/bpf_jit_enable")
+	if err != nil || strings.TrimSpace(string(jit)) == "0" {
+		t.Skip("JIT is disabled")
+	}
+
+	prog := mustSocketFilter(t)
+
+	pi, err := prog.Info()
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := pi.JitedInstructions()
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) == 0 {
+		t.Error("Expected machine code for the program")
+	}
+}
+
 func createProgramArray(t *testing.T) *Map {
 	t.Helper()
 
@@ -877,11 +1387,73 @@ func ExampleProgramSpec_Tag() {
 
 	prog, _ := NewProgram(spec)
 	info, _ := prog.Info()
+
+	// Kernels starting from 6.18 use a different algorithm.
 	tag, _ := spec.Tag()
+	tagSHA256, _ := spec.TagSHA256()
 
-	if info.Tag != tag {
+	if info.Tag != tag && info.Tag != tagSHA256 {
 		fmt.Printf("The tags don't match: %s != %s\n", info.Tag, tag)
 	} else {
-		fmt.Println("The programs are identical, tag is", tag)
+		fmt.Println("The programs are identical, tag is", info.Tag)
+	}
+}
+
+func TestProgramBTFFromBuilder(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.0", "func_info")
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	fn := &btf.Func{
+		Name: "main",
+		Type: &btf.FuncProto{
+			Return: &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed},
+			Params: []btf.FuncParam{{Name: "ctx", Type: &btf.Pointer{Target: &btf.Void{}}}},
+		},
+		Linkage: btf.StaticFunc,
 	}
+
+	b, err := btf.NewBuilder([]btf.Type{fn, u32})
+	qt.Assert(t, err, qt.IsNil)
+	spec, err := b.Spec()
+	qt.Assert(t, err, qt.IsNil)
+
+	m, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+		Key:        u32,
+		Value:      u32,
+		BTF:        spec,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer m.Close()
+
+	prog, err := NewProgram(&ProgramSpec{
+		Type:    SocketFilter,
+		License: "MIT",
+		BTF:     spec,
+		Instructions: asm.Instructions{
+			btf.WithFuncMetadata(asm.Mov.Imm(asm.R0, 0), fn),
+			asm.Return(),
+		},
+	})
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer prog.Close()
+
+	info, err := prog.Info()
+	qt.Assert(t, err, qt.IsNil)
+
+	id, ok := info.BTFID()
+	qt.Assert(t, ok, qt.IsTrue)
+
+	h, err := btf.NewHandleFromID(id)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer h.Close()
+
+	var kernelFn *btf.Func
+	qt.Assert(t, h.Spec().TypeByName("main", &kernelFn), qt.IsNil)
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/internal"
@@ -111,6 +112,7 @@ type Reader struct {
 	epollEvents []unix.EpollEvent
 	header      []byte
 	haveData    bool
+	deadline    time.Time
 }
 
 // NewReader creates a new BPF ringbuf reader.
@@ -171,9 +173,21 @@ func (r *Reader) Close() error {
 	return nil
 }
 
+// SetDeadline controls how long Read and ReadInto will block waiting for
+// samples.
+//
+// Passing a zero time.Time removes the deadline.
+func (r *Reader) SetDeadline(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deadline = t
+}
+
 // Read the next record from the BPF ringbuf.
 //
-// Calling Close interrupts the function.
+// Calling Close interrupts the function. Returns an error wrapping
+// os.ErrDeadlineExceeded if a deadline was set and no record arrived in time.
 func (r *Reader) Read() (Record, error) {
 	var rec Record
 	return rec, r.ReadInto(&rec)
@@ -190,7 +204,7 @@ func (r *Reader) ReadInto(rec *Record) error {
 
 	for {
 		if !r.haveData {
-			_, err := r.poller.Wait(r.epollEvents[:cap(r.epollEvents)])
+			_, err := r.poller.Wait(r.epollEvents[:cap(r.epollEvents)], r.deadline)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,184 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+)
+
+// snapshotMagic identifies the output of Map.Dump.
+var snapshotMagic = [4]byte{'e', 'b', 'p', 'm'}
+
+const snapshotVersion = 1
+
+// snapshotHeader precedes the entries of a dump.
+//
+// It is encoded in little endian. Keys and values are stored as they are
+// exchanged with the kernel, in native endianness.
+type snapshotHeader struct {
+	Magic      [4]byte
+	Version    uint32
+	Type       MapType
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	Flags      uint32
+	// The number of values per key. Zero for maps without per-CPU values.
+	CPUs uint32
+}
+
+// canSnapshot returns true if the keys and values of a map type are plain
+// data which is meaningful outside of the kernel.
+func (mt MapType) canSnapshot() bool {
+	switch mt {
+	case Hash, Array, PerCPUHash, PerCPUArray, LRUHash, LRUCPUHash, LPMTrie:
+		return true
+	}
+	return false
+}
+
+// Dump writes all entries of m to w.
+//
+// The output contains the type and dimensions of m, followed by the raw
+// keys and values. Values of per-CPU maps include the padding of each
+// element. Use RestoreMap to create a new map from the output.
+//
+// Only maps which store plain data can be dumped, maps containing file
+// descriptors or references to other objects return ErrNotSupported.
+// The output is consistent only if m isn't modified concurrently.
+func (m *Map) Dump(w io.Writer) error {
+	if !m.typ.canSnapshot() {
+		return fmt.Errorf("dump %s: %w", m.typ, ErrNotSupported)
+	}
+
+	hdr := snapshotHeader{
+		Magic:      snapshotMagic,
+		Version:    snapshotVersion,
+		Type:       m.typ,
+		KeySize:    m.keySize,
+		ValueSize:  m.valueSize,
+		MaxEntries: m.maxEntries,
+		Flags:      m.flags,
+	}
+
+	if m.typ.hasPerCPUValue() {
+		cpus, err := internal.PossibleCPUs()
+		if err != nil {
+			return err
+		}
+		hdr.CPUs = uint32(cpus)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	var (
+		key   []byte
+		value = make([]byte, m.fullValueSize)
+		iter  = m.Iterate()
+	)
+	for iter.Next(&key, unsafe.Pointer(&value[0])) {
+		if _, err := w.Write(key); err != nil {
+			return fmt.Errorf("write key: %w", err)
+		}
+		if _, err := w.Write(value); err != nil {
+			return fmt.Errorf("write value: %w", err)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("dump: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreMap creates a new map from spec and populates it with the output
+// of Map.Dump.
+//
+// The dumped map must have the same key and value size as spec. The type
+// may differ, as long as both store plain data and either both or neither
+// have per-CPU values. The number of possible CPUs must match for per-CPU
+// maps. Contents of spec are ignored.
+func RestoreMap(r io.Reader, spec *MapSpec) (*Map, error) {
+	var hdr snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	if hdr.Magic != snapshotMagic {
+		return nil, errors.New("not a map dump")
+	}
+
+	if hdr.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", hdr.Version)
+	}
+
+	if !spec.Type.canSnapshot() {
+		return nil, fmt.Errorf("restore %s: %w", spec.Type, ErrNotSupported)
+	}
+
+	if hdr.KeySize != spec.KeySize || hdr.ValueSize != spec.ValueSize {
+		return nil, fmt.Errorf("dump of %s(keySize=%d, valueSize=%d) doesn't match %s: %w",
+			hdr.Type, hdr.KeySize, hdr.ValueSize, spec, ErrMapIncompatible)
+	}
+
+	if hdr.Type.hasPerCPUValue() != spec.Type.hasPerCPUValue() {
+		return nil, fmt.Errorf("can't restore %s into %s: %w", hdr.Type, spec.Type, ErrMapIncompatible)
+	}
+
+	if spec.Type.hasPerCPUValue() {
+		cpus, err := internal.PossibleCPUs()
+		if err != nil {
+			return nil, err
+		}
+		if int(hdr.CPUs) != cpus {
+			return nil, fmt.Errorf("dump contains values for %d CPUs instead of %d", hdr.CPUs, cpus)
+		}
+	}
+
+	spec = spec.Copy()
+	spec.Contents = nil
+
+	m, err := NewMap(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.restore(r); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// restore reads entries written by Dump until EOF.
+func (m *Map) restore(r io.Reader) error {
+	key := make([]byte, m.keySize)
+	value := make([]byte, m.fullValueSize)
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, key); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read key of entry %d: %w", i, err)
+		}
+
+		if _, err := io.ReadFull(r, value); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("read value of entry %d: %w", i, err)
+		}
+
+		if err := m.update(sys.NewSlicePointer(key), sys.NewSlicePointer(value), UpdateAny); err != nil {
+			return fmt.Errorf("restore entry %d: %w", i, err)
+		}
+	}
+}
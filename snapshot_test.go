@@ -0,0 +1,154 @@
+package ebpf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+func TestMapDumpRestore(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := uint32(0); i < 5; i++ {
+		if err := m.Put(i, uint64(i)*100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.Dump(&buf); err != nil {
+		t.Fatal("Dump:", err)
+	}
+	dump := buf.Bytes()
+
+	// A different type with the same dimensions can be restored into.
+	restored, err := RestoreMap(bytes.NewReader(dump), &MapSpec{
+		Type:       LRUHash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 20,
+	})
+	if err != nil {
+		t.Fatal("RestoreMap:", err)
+	}
+	defer restored.Close()
+
+	var (
+		key, n uint32
+		value  uint64
+		iter   = restored.Iterate()
+	)
+	for iter.Next(&key, &value) {
+		if value != uint64(key)*100 {
+			t.Errorf("Key %d has value %d", key, value)
+		}
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("Restored %d entries instead of 5", n)
+	}
+
+	_, err = RestoreMap(bytes.NewReader(dump), &MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 10,
+	})
+	if !errors.Is(err, ErrMapIncompatible) {
+		t.Error("Restoring into a different value size doesn't return ErrMapIncompatible:", err)
+	}
+
+	_, err = RestoreMap(bytes.NewReader(dump[:len(dump)-1]), &MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 10,
+	})
+	if err == nil {
+		t.Error("Restoring a truncated dump doesn't return an error")
+	}
+
+	if _, err := RestoreMap(bytes.NewReader([]byte("garbage garbage garbage garbage")), &MapSpec{}); err == nil {
+		t.Error("Restoring garbage doesn't return an error")
+	}
+}
+
+func TestMapDumpRestorePerCPU(t *testing.T) {
+	possibleCPUs, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &MapSpec{
+		Type:       PerCPUArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	}
+
+	m, err := NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	values := make([]uint32, possibleCPUs)
+	for i := range values {
+		values[i] = uint32(i) + 1
+	}
+	if err := m.Put(uint32(1), values); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Dump(&buf); err != nil {
+		t.Fatal("Dump:", err)
+	}
+
+	restored, err := RestoreMap(&buf, spec)
+	if err != nil {
+		t.Fatal("RestoreMap:", err)
+	}
+	defer restored.Close()
+
+	var have []uint32
+	if err := restored.Lookup(uint32(1), &have); err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		if have[i] != values[i] {
+			t.Errorf("CPU %d has value %d instead of %d", i, have[i], values[i])
+		}
+	}
+}
+
+func TestMapDumpUnsupported(t *testing.T) {
+	m, err := NewMap(&MapSpec{
+		Type:       ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Dump(&bytes.Buffer{}); !errors.Is(err, ErrNotSupported) {
+		t.Error("Dumping a ProgramArray doesn't return ErrNotSupported:", err)
+	}
+}
@@ -0,0 +1,56 @@
+package ebpf
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// UpdateSocket inserts the socket underlying conn into a SockMap or
+// SockHash.
+//
+// conn is usually a *net.TCPConn, *net.UDPConn or *net.UnixConn. The map
+// holds its own reference to the socket, which the kernel removes from the
+// map once the socket is closed. See UpdateSocketFD for the kinds of
+// sockets supported by the kernel.
+func (m *Map) UpdateSocket(key interface{}, conn syscall.Conn, flags MapUpdateFlags) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var updateErr error
+	err = rawConn.Control(func(fd uintptr) {
+		updateErr = m.UpdateSocketFD(key, int(fd), flags)
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+// UpdateSocketFD inserts the socket referred to by fd into a SockMap or
+// SockHash.
+//
+// fd is only used for the duration of the call. TCP sockets must be
+// established, bound UDP sockets are supported since Linux 5.7 and Unix
+// sockets since Linux 5.15. The kernel removes the socket from the map once
+// it is closed.
+func (m *Map) UpdateSocketFD(key interface{}, fd int, flags MapUpdateFlags) error {
+	if m.typ != SockMap && m.typ != SockHash {
+		return fmt.Errorf("%s is not a socket map: %w", m.typ, ErrNotSupported)
+	}
+	if fd < 0 || int(int32(fd)) != fd {
+		return fmt.Errorf("invalid file descriptor %d", fd)
+	}
+
+	// The kernel accepts both 4 and 8 byte values.
+	var value interface{} = uint32(fd)
+	if m.valueSize == 8 {
+		value = uint64(fd)
+	}
+
+	if err := m.Update(key, value, flags); err != nil {
+		return fmt.Errorf("update socket: %w", err)
+	}
+	return nil
+}
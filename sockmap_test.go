@@ -0,0 +1,65 @@
+package ebpf
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestMapUpdateSocket(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.14", "sockmap")
+
+	for _, valueSize := range []uint32{4, 8} {
+		m, err := NewMap(&MapSpec{
+			Type:       SockMap,
+			KeySize:    4,
+			ValueSize:  valueSize,
+			MaxEntries: 1,
+		})
+		testutils.SkipIfNotSupported(t, err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		dial := func() *net.TCPConn {
+			t.Helper()
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { conn.Close() })
+			return conn.(*net.TCPConn)
+		}
+
+		conn := dial()
+		if err := m.UpdateSocket(uint32(0), conn, UpdateNoExist); err != nil {
+			t.Fatalf("Value size %d: can't insert socket: %s", valueSize, err)
+		}
+
+		if err := m.UpdateSocket(uint32(0), dial(), UpdateNoExist); !errors.Is(err, ErrKeyExist) {
+			t.Fatalf("Value size %d: inserting into occupied slot doesn't return ErrKeyExist: %v", valueSize, err)
+		}
+
+		// The kernel frees the slot when the socket is closed.
+		conn.Close()
+		if err := m.UpdateSocket(uint32(0), dial(), UpdateNoExist); err != nil {
+			t.Errorf("Value size %d: closed socket wasn't removed from the map: %s", valueSize, err)
+		}
+	}
+
+	hash := createHash()
+	defer hash.Close()
+
+	if err := hash.UpdateSocketFD("hello", 0, UpdateAny); !errors.Is(err, ErrNotSupported) {
+		t.Error("UpdateSocketFD on a hash map doesn't return ErrNotSupported:", err)
+	}
+}
@@ -0,0 +1,111 @@
+package stacktrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KernelSymbols resolves kernel addresses using /proc/kallsyms.
+type KernelSymbols struct {
+	syms []kernelSymbol
+}
+
+type kernelSymbol struct {
+	addr   uint64
+	name   string
+	module string
+}
+
+// LoadKernelSymbols reads the symbols of the running kernel and its modules.
+//
+// Reading addresses requires CAP_SYSLOG, depending on the value of the
+// kernel.kptr_restrict sysctl. Returns an error if addresses are hidden.
+func LoadKernelSymbols() (*KernelSymbols, error) {
+	f, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseKallsyms(f)
+}
+
+func parseKallsyms(r io.Reader) (*KernelSymbols, error) {
+	var (
+		syms   []kernelSymbol
+		hidden = true
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// <address> <type> <name> [<module>]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid kallsyms line %q", scanner.Text())
+		}
+
+		switch fields[1] {
+		case "t", "T", "w", "W":
+		default:
+			// Not a function.
+			continue
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address in kallsyms line %q: %w", scanner.Text(), err)
+		}
+		if addr != 0 {
+			hidden = false
+		}
+
+		var module string
+		if len(fields) > 3 {
+			module = strings.Trim(fields[3], "[]")
+		}
+
+		syms = append(syms, kernelSymbol{addr, fields[2], module})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read kallsyms: %w", err)
+	}
+
+	if hidden {
+		return nil, errors.New("kernel symbol addresses are hidden, check kernel.kptr_restrict")
+	}
+
+	sort.SliceStable(syms, func(i, j int) bool {
+		return syms[i].addr < syms[j].addr
+	})
+
+	return &KernelSymbols{syms}, nil
+}
+
+// Resolve turns kernel addresses into Frames.
+//
+// Addresses below the first symbol are returned without a Symbol.
+func (ks *KernelSymbols) Resolve(addrs []uint64) []Frame {
+	frames := make([]Frame, 0, len(addrs))
+	for _, addr := range addrs {
+		frames = append(frames, ks.resolve(addr))
+	}
+	return frames
+}
+
+func (ks *KernelSymbols) resolve(addr uint64) Frame {
+	i := sort.Search(len(ks.syms), func(i int) bool {
+		return ks.syms[i].addr > addr
+	})
+	if i == 0 {
+		return Frame{Addr: addr}
+	}
+
+	sym := ks.syms[i-1]
+	return Frame{addr, sym.name, addr - sym.addr, sym.module}
+}
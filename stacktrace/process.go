@@ -0,0 +1,224 @@
+package stacktrace
+
+import (
+	"bufio"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+// ProcessSymbols resolves user space addresses of a process using the symbol
+// tables of the ELF files mapped into it.
+//
+// ELF files are read when they are first needed. Files without symbols,
+// like stripped binaries, produce Frames without a Symbol.
+type ProcessSymbols struct {
+	pid      int
+	mappings []mapping
+	files    map[string]*elfSymbols
+}
+
+// mapping is an executable region of a process' address space.
+type mapping struct {
+	start, end uint64
+	offset     uint64
+	path       string
+}
+
+// LoadProcessSymbols reads the memory mappings of the process with the
+// given pid.
+//
+// The mappings are only read once. Addresses in libraries which are loaded
+// afterwards aren't resolved.
+func LoadProcessSymbols(pid int) (*ProcessSymbols, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mappings, err := parseMaps(f)
+	if err != nil {
+		return nil, fmt.Errorf("pid %d: %w", pid, err)
+	}
+
+	return &ProcessSymbols{pid, mappings, make(map[string]*elfSymbols)}, nil
+}
+
+func parseMaps(r io.Reader) ([]mapping, error) {
+	var mappings []mapping
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// <start>-<end> <perms> <offset> <dev> <inode> [<path>]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid maps line %q", scanner.Text())
+		}
+
+		if len(fields) < 6 || !strings.HasPrefix(fields[5], "/") || !strings.Contains(fields[1], "x") {
+			// Anonymous, special or not executable.
+			continue
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid address range %q", fields[0])
+		}
+
+		start, err := strconv.ParseUint(bounds[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range %q: %w", fields[0], err)
+		}
+		end, err := strconv.ParseUint(bounds[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range %q: %w", fields[0], err)
+		}
+		offset, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %q: %w", fields[2], err)
+		}
+
+		// Paths may contain spaces.
+		path := strings.Join(fields[5:], " ")
+		mappings = append(mappings, mapping{start, end, offset, path})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read maps: %w", err)
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].start < mappings[j].start
+	})
+
+	return mappings, nil
+}
+
+// Resolve turns user space addresses of the process into Frames.
+//
+// Addresses outside of file backed mappings, for example in JIT compiled
+// code, are returned without a Module.
+func (ps *ProcessSymbols) Resolve(addrs []uint64) []Frame {
+	frames := make([]Frame, 0, len(addrs))
+	for _, addr := range addrs {
+		frames = append(frames, ps.resolve(addr))
+	}
+	return frames
+}
+
+func (ps *ProcessSymbols) resolve(addr uint64) Frame {
+	i := sort.Search(len(ps.mappings), func(i int) bool {
+		return ps.mappings[i].end > addr
+	})
+	if i == len(ps.mappings) || ps.mappings[i].start > addr {
+		return Frame{Addr: addr}
+	}
+	m := &ps.mappings[i]
+
+	frame := Frame{Addr: addr, Module: m.path}
+
+	syms, ok := ps.files[m.path]
+	if !ok {
+		// Go through the root of the process, since it may live in a
+		// different mount namespace. Errors are cached as missing symbols.
+		syms, _ = loadELFSymbols(filepath.Join(fmt.Sprintf("/proc/%d/root", ps.pid), m.path))
+		ps.files[m.path] = syms
+	}
+	if syms == nil {
+		return frame
+	}
+
+	vaddr, ok := syms.vaddr(addr - m.start + m.offset)
+	if !ok {
+		return frame
+	}
+
+	if sym, ok := syms.lookup(vaddr); ok {
+		frame.Symbol = sym.Name
+		frame.Offset = vaddr - sym.Value
+	}
+	return frame
+}
+
+// elfSymbols are the function symbols and loadable segments of an ELF.
+type elfSymbols struct {
+	progs []elf.ProgHeader
+	// Sorted by Value.
+	syms []elf.Symbol
+}
+
+func loadELFSymbols(path string) (*elfSymbols, error) {
+	f, err := internal.OpenSafeELFFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return newELFSymbols(f)
+}
+
+func newELFSymbols(f *internal.SafeELFFile) (*elfSymbols, error) {
+	var es elfSymbols
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			es.progs = append(es.progs, prog.ProgHeader)
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+
+	dynsyms, err := f.DynamicSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+
+	for _, sym := range append(syms, dynsyms...) {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		es.syms = append(es.syms, sym)
+	}
+
+	sort.SliceStable(es.syms, func(i, j int) bool {
+		return es.syms[i].Value < es.syms[j].Value
+	})
+
+	return &es, nil
+}
+
+// vaddr converts an offset into the file to a virtual address.
+func (es *elfSymbols) vaddr(fileOffset uint64) (uint64, bool) {
+	for _, prog := range es.progs {
+		if fileOffset >= prog.Off && fileOffset < prog.Off+prog.Filesz {
+			return fileOffset - prog.Off + prog.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// lookup finds the symbol containing vaddr.
+func (es *elfSymbols) lookup(vaddr uint64) (elf.Symbol, bool) {
+	i := sort.Search(len(es.syms), func(i int) bool {
+		return es.syms[i].Value > vaddr
+	})
+	if i == 0 {
+		return elf.Symbol{}, false
+	}
+
+	sym := es.syms[i-1]
+	if sym.Size != 0 && vaddr >= sym.Value+sym.Size {
+		return elf.Symbol{}, false
+	}
+	return sym, true
+}
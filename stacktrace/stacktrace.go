@@ -0,0 +1,87 @@
+// Package stacktrace reads stack traces from StackTrace maps and resolves
+// them to symbols.
+//
+// Programs record stacks with bpf_get_stackid, which returns an id into a
+// StackTrace map. Use Lookup to read the instruction pointers of a stack,
+// and KernelSymbols or ProcessSymbols to turn them into Frames.
+package stacktrace
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Frame is a single entry of a stack trace.
+type Frame struct {
+	// The instruction pointer.
+	Addr uint64
+	// The name of the symbol containing Addr. Empty if it is unknown.
+	Symbol string
+	// The offset of Addr from the start of Symbol.
+	Offset uint64
+	// The kernel module or the path of the ELF containing Addr. Empty
+	// for the kernel image and for unknown addresses.
+	Module string
+}
+
+func (f Frame) String() string {
+	var s string
+	if f.Symbol == "" {
+		s = fmt.Sprintf("%#x", f.Addr)
+	} else {
+		s = fmt.Sprintf("%s+%#x", f.Symbol, f.Offset)
+	}
+
+	if f.Module != "" {
+		s += " [" + f.Module + "]"
+	}
+	return s
+}
+
+// Lookup returns the instruction pointers of the stack with the given id.
+//
+// The innermost frame comes first. m must be a StackTrace map which doesn't
+// store build IDs.
+//
+// Returns an error wrapping ebpf.ErrKeyNotExist if there is no stack with
+// the given id, for example because it was evicted by a hash collision.
+func Lookup(m *ebpf.Map, id uint32) ([]uint64, error) {
+	if m.Type() != ebpf.StackTrace {
+		return nil, fmt.Errorf("%s is not a stack trace map", m.Type())
+	}
+
+	if m.Flags()&unix.BPF_F_STACK_BUILD_ID != 0 {
+		return nil, errors.New("stack trace maps with build IDs are not supported")
+	}
+
+	buf, err := m.LookupBytes(id)
+	if err != nil {
+		return nil, fmt.Errorf("lookup stack %d: %w", id, err)
+	}
+	if buf == nil {
+		return nil, fmt.Errorf("lookup stack %d: %w", id, ebpf.ErrKeyNotExist)
+	}
+
+	return parseStack(buf), nil
+}
+
+// parseStack decodes the value of a stack trace map.
+//
+// Unused entries at the end of the value are zero.
+func parseStack(buf []byte) []uint64 {
+	var addrs []uint64
+	for len(buf) >= 8 {
+		addr := internal.NativeEndian.Uint64(buf)
+		if addr == 0 {
+			break
+		}
+
+		addrs = append(addrs, addr)
+		buf = buf[8:]
+	}
+	return addrs
+}
@@ -0,0 +1,146 @@
+package stacktrace
+
+import (
+	"debug/elf"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestParseStack(t *testing.T) {
+	buf := make([]byte, 4*8)
+	internal.NativeEndian.PutUint64(buf[0:], 0x1000)
+	internal.NativeEndian.PutUint64(buf[8:], 0x2000)
+
+	addrs := parseStack(buf)
+	if !reflect.DeepEqual(addrs, []uint64{0x1000, 0x2000}) {
+		t.Error("Unexpected addresses:", addrs)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.StackTrace,
+		KeySize:    4,
+		ValueSize:  8 * 127,
+		MaxEntries: 1,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := Lookup(m, 0); !errors.Is(err, ebpf.ErrKeyNotExist) {
+		t.Error("Lookup of missing stack doesn't return ErrKeyNotExist:", err)
+	}
+
+	hash, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+
+	if _, err := Lookup(hash, 0); err == nil {
+		t.Error("Lookup accepts a hash map")
+	}
+}
+
+func TestKernelSymbols(t *testing.T) {
+	const kallsyms = `0000000000000000 A fixed_percpu_data
+ffffffff81000000 T _stext
+ffffffff81000100 t do_one_initcall
+ffffffff81000200 D some_data
+ffffffffc0000000 t nf_hook [nf_tables]
+`
+
+	ks, err := parseKallsyms(strings.NewReader(kallsyms))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := ks.Resolve([]uint64{0x1000, 0xffffffff81000010, 0xffffffff81000280, 0xffffffffc0000008})
+	want := []string{
+		"0x1000",
+		"_stext+0x10",
+		"do_one_initcall+0x180",
+		"nf_hook+0x8 [nf_tables]",
+	}
+	for i, frame := range frames {
+		if frame.String() != want[i] {
+			t.Errorf("Frame %d: expected %q, got %q", i, want[i], frame)
+		}
+	}
+
+	if _, err := parseKallsyms(strings.NewReader("0000000000000000 T _stext\n")); err == nil {
+		t.Error("parseKallsyms accepts hidden addresses")
+	}
+}
+
+func TestParseMaps(t *testing.T) {
+	const maps = `00400000-00452000 r-xp 00001000 08:02 173521 /usr/bin/dbus daemon
+00651000-00652000 rw-p 00051000 08:02 173521 /usr/bin/dbus daemon
+7ffd5a5fc000-7ffd5a5fe000 r-xp 00000000 00:00 0 [vdso]
+7f0000000000-7f0000001000 r-xp 00000000 00:00 0
+`
+
+	mappings, err := parseMaps(strings.NewReader(maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []mapping{{0x400000, 0x452000, 0x1000, "/usr/bin/dbus daemon"}}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("Expected %v, got %v", want, mappings)
+	}
+}
+
+func TestProcessSymbols(t *testing.T) {
+	ps, err := LoadProcessSymbols(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test binaries are stripped, use the exported symbols of bash instead.
+	bash, err := loadELFSymbols("/bin/bash")
+	if err != nil {
+		t.Skip("Can't read /bin/bash:", err)
+	}
+	if len(bash.syms) == 0 {
+		t.Skip("/bin/bash has no symbols")
+	}
+
+	// Symbols may share an address, lookup returns one of them.
+	sym, _ := bash.lookup(bash.syms[len(bash.syms)/2].Value)
+	var text elf.ProgHeader
+	for _, prog := range bash.progs {
+		if sym.Value >= prog.Vaddr && sym.Value < prog.Vaddr+prog.Filesz {
+			text = prog
+		}
+	}
+
+	// Pretend that bash is mapped into the process.
+	const start = 0x10000
+	ps.mappings = []mapping{{start, start + text.Filesz, text.Off, "/bin/bash"}}
+
+	addr := start + sym.Value - text.Vaddr + 1
+	frames := ps.Resolve([]uint64{addr, 0})
+
+	if frame := frames[0]; frame.Symbol != sym.Name || frame.Offset != 1 || frame.Module != "/bin/bash" {
+		t.Errorf("Expected frame for %s+0x1, got %s", sym.Name, frame)
+	}
+	if frame := frames[1]; frame.Symbol != "" || frame.Module != "" {
+		t.Error("Unexpected frame for address zero:", frame)
+	}
+}
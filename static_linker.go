@@ -0,0 +1,562 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+)
+
+// LinkCollectionSpecs statically links multiple CollectionSpecs, usually
+// loaded from separate ELF objects, into a single CollectionSpec.
+//
+// Calls to functions which aren't defined in the same spec are resolved
+// against the programs and global library functions of the other specs.
+// It's an error if such a call matches functions in more than one spec.
+// Data sections with the same name, like .data, .rodata and .bss, are
+// concatenated. All other maps with the same name are deduplicated and must
+// have compatible definitions. Program names must be unique across specs.
+//
+// The types of all specs are merged into a single btf.Spec. Either all or
+// none of the specs must contain BTF.
+//
+// The specs are not modified.
+func LinkCollectionSpecs(specs ...*CollectionSpec) (*CollectionSpec, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no specs to link")
+	}
+
+	byteOrder := specs[0].ByteOrder
+	hasBTF := specs[0].Types != nil
+	for i, spec := range specs {
+		if spec.ByteOrder != byteOrder {
+			return nil, fmt.Errorf("spec %d: byte order %v doesn't match %v", i, spec.ByteOrder, byteOrder)
+		}
+
+		if (spec.Types != nil) != hasBTF {
+			return nil, fmt.Errorf("spec %d: can't link specs with and without BTF", i)
+		}
+	}
+
+	objs := make([]*linkObject, 0, len(specs))
+	for i, spec := range specs {
+		obj, err := newLinkObject(spec)
+		if err != nil {
+			return nil, fmt.Errorf("spec %d: %w", i, err)
+		}
+		objs = append(objs, obj)
+	}
+
+	maps, err := linkMaps(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &staticLinker{objs, hasBTF}
+
+	progs := make(map[string]*ProgramSpec)
+	for i, obj := range objs {
+		for name, prog := range obj.spec.Programs {
+			if progs[name] != nil {
+				return nil, fmt.Errorf("spec %d: duplicate program %s", i, name)
+			}
+
+			insns, err := l.link(symbolKey{i, obj.entries[name]}, name)
+			if err != nil {
+				return nil, fmt.Errorf("program %s: %w", name, err)
+			}
+
+			cpy := prog.Copy()
+			cpy.Instructions = insns
+			progs[name] = cpy
+		}
+	}
+
+	// Library functions stay available, so that the result can be linked
+	// again. If there are multiple functions with the same name, the first
+	// one wins.
+	funcs := make(map[string]asm.Instructions)
+	for i, obj := range objs {
+		for name := range obj.spec.Functions {
+			if funcs[name] != nil || progs[name] != nil {
+				continue
+			}
+
+			insns, err := l.link(symbolKey{i, name}, name)
+			if err != nil {
+				return nil, fmt.Errorf("function %s: %w", name, err)
+			}
+			funcs[name] = insns
+		}
+	}
+
+	cs := &CollectionSpec{
+		Maps:      maps,
+		Programs:  progs,
+		ByteOrder: byteOrder,
+		Functions: funcs,
+	}
+
+	if hasBTF {
+		if err := cs.linkTypes(specs); err != nil {
+			return nil, fmt.Errorf("link BTF: %w", err)
+		}
+	}
+
+	return cs, nil
+}
+
+// linkObject is a CollectionSpec taking part in static linking.
+type linkObject struct {
+	spec *CollectionSpec
+
+	// All functions of the spec by symbol name. Programs are split into
+	// their individual functions.
+	funcs map[string]asm.Instructions
+
+	// The symbol of the first function of each program.
+	entries map[string]string
+
+	// Offset of the spec's data sections in the linked data sections.
+	dataOffsets map[string]uint32
+
+	// Offsets of the spec's kconfig externs in the linked .kconfig map.
+	kconfigOffsets map[uint32]uint32
+}
+
+func newLinkObject(spec *CollectionSpec) (*linkObject, error) {
+	obj := &linkObject{
+		spec:           spec,
+		funcs:          make(map[string]asm.Instructions),
+		entries:        make(map[string]string),
+		dataOffsets:    make(map[string]uint32),
+		kconfigOffsets: make(map[uint32]uint32),
+	}
+
+	add := func(insns asm.Instructions) error {
+		chunks, err := splitSymbols(insns)
+		if err != nil {
+			return err
+		}
+
+		for sym, chunk := range chunks {
+			if obj.funcs[sym] == nil {
+				obj.funcs[sym] = chunk
+			}
+		}
+		return nil
+	}
+
+	for name, insns := range spec.Functions {
+		if err := add(insns); err != nil {
+			return nil, fmt.Errorf("function %s: %w", name, err)
+		}
+	}
+
+	for name, prog := range spec.Programs {
+		if len(prog.Instructions) == 0 {
+			return nil, fmt.Errorf("program %s: no instructions", name)
+		}
+
+		insns := prog.Instructions
+		if insns[0].Symbol() == "" {
+			// Programs which aren't loaded from an ELF may lack a symbol.
+			insns = make(asm.Instructions, len(prog.Instructions))
+			copy(insns, prog.Instructions)
+			insns[0] = insns[0].WithSymbol(name)
+		}
+		obj.entries[name] = insns[0].Symbol()
+
+		if err := add(insns); err != nil {
+			return nil, fmt.Errorf("program %s: %w", name, err)
+		}
+	}
+
+	return obj, nil
+}
+
+// isGlobal returns true if the function is visible to other objects.
+func (obj *linkObject) isGlobal(name string) bool {
+	insns := obj.funcs[name]
+	if insns == nil {
+		return false
+	}
+
+	if fn := btf.FuncMetadata(&insns[0]); fn != nil {
+		return fn.Linkage != btf.StaticFunc
+	}
+
+	return true
+}
+
+// rewriteMapOffset adjusts a direct load from a data section to the layout
+// of the linked data section.
+func (obj *linkObject) rewriteMapOffset(ins *asm.Instruction) error {
+	name := ins.Reference()
+	offset := uint32(uint64(ins.Constant) >> 32)
+
+	if name == ".kconfig" {
+		newOffset, ok := obj.kconfigOffsets[offset]
+		if !ok {
+			return fmt.Errorf("no kconfig extern at offset %d", offset)
+		}
+		return ins.RewriteMapOffset(newOffset)
+	}
+
+	base, ok := obj.dataOffsets[name]
+	if !ok {
+		return nil
+	}
+
+	return ins.RewriteMapOffset(base + offset)
+}
+
+type symbolKey struct {
+	obj  int
+	name string
+}
+
+type staticLinker struct {
+	objs   []*linkObject
+	hasBTF bool
+}
+
+// resolve finds the function which a reference from the given object
+// refers to.
+//
+// Returns false if the function isn't defined in any object.
+func (l *staticLinker) resolve(from int, ref string) (symbolKey, bool, error) {
+	if l.objs[from].funcs[ref] != nil {
+		return symbolKey{from, ref}, true, nil
+	}
+
+	var (
+		target symbolKey
+		found  bool
+	)
+	for i, obj := range l.objs {
+		if i == from || !obj.isGlobal(ref) {
+			continue
+		}
+
+		if found {
+			return symbolKey{}, false, fmt.Errorf("reference to %s is ambiguous: defined in specs %d and %d", ref, target.obj, i)
+		}
+
+		target, found = symbolKey{i, ref}, true
+	}
+
+	return target, found, nil
+}
+
+// link creates the instructions for the function identified by entry,
+// followed by all functions it references directly or indirectly.
+//
+// Functions are renamed if their symbol collides with a function from
+// another object.
+func (l *staticLinker) link(entry symbolKey, name string) (asm.Instructions, error) {
+	names := map[symbolKey]string{entry: name}
+	used := map[string]bool{name: true}
+	pending := []symbolKey{entry}
+
+	var insns asm.Instructions
+	for len(pending) > 0 {
+		var key symbolKey
+		key, pending = pending[0], pending[1:]
+
+		obj := l.objs[key.obj]
+		chunk := make(asm.Instructions, len(obj.funcs[key.name]))
+		copy(chunk, obj.funcs[key.name])
+		chunk[0] = chunk[0].WithSymbol(names[key])
+
+		for i := range chunk {
+			ins := &chunk[i]
+
+			if l.hasBTF {
+				// Line info refers to the string table of the original BTF.
+				if line, ok := ins.Source().(*btf.Line); ok {
+					*ins = ins.WithSource(asm.Comment(line.String()))
+				}
+			}
+
+			switch {
+			case ins.IsFunctionReference():
+				ref := ins.Reference()
+				target, ok, err := l.resolve(key.obj, ref)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					// Leave extern functions alone, same as the ELF loader.
+					continue
+				}
+
+				if _, ok := names[target]; !ok {
+					sym := ref
+					for n := 1; used[sym]; n++ {
+						sym = fmt.Sprintf("%s.%d", ref, n)
+					}
+
+					names[target] = sym
+					used[sym] = true
+					pending = append(pending, target)
+				}
+
+				*ins = ins.WithReference(names[target])
+
+			case ins.IsLoadFromMap() && ins.Src == asm.PseudoMapValue:
+				if err := obj.rewriteMapOffset(ins); err != nil {
+					return nil, fmt.Errorf("%s: %w", ins.Reference(), err)
+				}
+			}
+		}
+
+		insns = append(insns, chunk...)
+	}
+
+	return insns, nil
+}
+
+// isDataSectionMap returns true if the map holds the contents of an ELF data
+// section.
+func isDataSectionMap(name string) bool {
+	return name == ".bss" || strings.HasPrefix(name, ".data") || strings.HasPrefix(name, ".rodata")
+}
+
+// linkMaps merges the maps of all objects and records where the data
+// sections of each object end up.
+func linkMaps(objs []*linkObject) (map[string]*MapSpec, error) {
+	maps := make(map[string]*MapSpec)
+	datasecs := make(map[string]*btf.Datasec)
+	contents := make(map[string][]byte)
+
+	for i, obj := range objs {
+		for name, ms := range obj.spec.Maps {
+			var err error
+			switch {
+			case isDataSectionMap(name):
+				err = linkDataSection(maps, datasecs, contents, obj, name, ms)
+			case name == ".kconfig":
+				err = linkKconfig(maps, obj, ms)
+			default:
+				if existing := maps[name]; existing != nil {
+					err = checkMapSpecsCompatible(existing, ms)
+				} else {
+					maps[name] = ms.Copy()
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("spec %d: map %s: %w", i, name, err)
+			}
+		}
+	}
+
+	for name, data := range contents {
+		maps[name].Contents = []MapKV{{uint32(0), data}}
+	}
+
+	for name, ds := range datasecs {
+		ds.Size = maps[name].ValueSize
+		maps[name].Key = &btf.Void{}
+		maps[name].Value = ds
+	}
+
+	return maps, nil
+}
+
+// linkDataSection appends the contents of a data section to the data
+// section of the same name.
+func linkDataSection(maps map[string]*MapSpec, datasecs map[string]*btf.Datasec, contents map[string][]byte, obj *linkObject, name string, ms *MapSpec) error {
+	var data []byte
+	switch len(ms.Contents) {
+	case 0:
+	case 1:
+		var ok bool
+		data, ok = ms.Contents[0].Value.([]byte)
+		if !ok {
+			return fmt.Errorf("value at first map key is %T, not []byte", ms.Contents[0].Value)
+		}
+	default:
+		return fmt.Errorf("expected one key, found %d", len(ms.Contents))
+	}
+
+	linked := maps[name]
+	if linked == nil {
+		linked = ms.Copy()
+		linked.ValueSize = 0
+		linked.Contents = nil
+		linked.Key, linked.Value = nil, nil
+		maps[name] = linked
+	} else {
+		// The size of data sections is expected to differ.
+		cmp := *ms
+		cmp.ValueSize = linked.ValueSize
+		if err := checkMapSpecsCompatible(linked, &cmp); err != nil {
+			return err
+		}
+	}
+
+	base := uint32(internal.Align(int(linked.ValueSize), 8))
+	obj.dataOffsets[name] = base
+	linked.ValueSize = base + ms.ValueSize
+
+	if data != nil || contents[name] != nil {
+		buf := make([]byte, linked.ValueSize)
+		copy(buf, contents[name])
+		copy(buf[base:], data)
+		contents[name] = buf
+	}
+
+	if ds, ok := ms.Value.(*btf.Datasec); ok {
+		linkedDs := datasecs[name]
+		if linkedDs == nil {
+			linkedDs = &btf.Datasec{Name: ds.Name}
+			datasecs[name] = linkedDs
+		}
+
+		for _, vsi := range ds.Vars {
+			vsi.Offset += base
+			linkedDs.Vars = append(linkedDs.Vars, vsi)
+		}
+	}
+
+	return nil
+}
+
+// linkKconfig merges kconfig externs by name.
+func linkKconfig(maps map[string]*MapSpec, obj *linkObject, ms *MapSpec) error {
+	ds, ok := ms.Value.(*btf.Datasec)
+	if !ok {
+		return fmt.Errorf("map value BTF is a %T, not a *btf.Datasec", ms.Value)
+	}
+
+	linked := maps[".kconfig"]
+	if linked == nil {
+		linked = ms.Copy()
+		linked.ValueSize = 0
+		linked.Value = &btf.Datasec{Name: ds.Name}
+		maps[".kconfig"] = linked
+	}
+	linkedDs := linked.Value.(*btf.Datasec)
+
+outer:
+	for _, vsi := range ds.Vars {
+		for _, existing := range linkedDs.Vars {
+			if existing.Type.TypeName() != vsi.Type.TypeName() {
+				continue
+			}
+
+			if existing.Size != vsi.Size {
+				return fmt.Errorf("kconfig extern %s: size %d doesn't match %d", vsi.Type.TypeName(), vsi.Size, existing.Size)
+			}
+
+			obj.kconfigOffsets[vsi.Offset] = existing.Offset
+			continue outer
+		}
+
+		offset := uint32(internal.Align(int(linked.ValueSize), 8))
+		obj.kconfigOffsets[vsi.Offset] = offset
+		linkedDs.Vars = append(linkedDs.Vars, btf.VarSecinfo{Type: vsi.Type, Offset: offset, Size: vsi.Size})
+		linked.ValueSize = offset + vsi.Size
+	}
+
+	linkedDs.Size = linked.ValueSize
+	return nil
+}
+
+// checkMapSpecsCompatible returns an error if two MapSpecs with the same name
+// can't be merged into a single map.
+func checkMapSpecsCompatible(have, spec *MapSpec) error {
+	switch {
+	case have.Type != spec.Type:
+		return &MapIncompatibleError{"Type", have.Type, spec.Type}
+
+	case have.KeySize != spec.KeySize:
+		return &MapIncompatibleError{"KeySize", have.KeySize, spec.KeySize}
+
+	case have.ValueSize != spec.ValueSize:
+		return &MapIncompatibleError{"ValueSize", have.ValueSize, spec.ValueSize}
+
+	case have.MaxEntries != spec.MaxEntries:
+		return &MapIncompatibleError{"MaxEntries", have.MaxEntries, spec.MaxEntries}
+
+	case have.Flags != spec.Flags:
+		return &MapIncompatibleError{"Flags", have.Flags, spec.Flags}
+
+	case have.Pinning != spec.Pinning:
+		return &MapIncompatibleError{"Pinning", have.Pinning, spec.Pinning}
+	}
+
+	return nil
+}
+
+// linkTypes merges the types of specs and assigns the result to all programs
+// and maps of cs.
+func (cs *CollectionSpec) linkTypes(specs []*CollectionSpec) error {
+	var roots []btf.Type
+
+	for _, spec := range specs {
+		iter := spec.Types.Iterate()
+		for iter.Next() {
+			if !isLinkableType(iter.Type) {
+				continue
+			}
+			roots = append(roots, iter.Type)
+		}
+	}
+
+	for _, ms := range cs.Maps {
+		if ms.BTF == nil && ms.Value == nil {
+			continue
+		}
+
+		for _, typ := range []btf.Type{ms.Key, ms.Value} {
+			if typ != nil {
+				roots = append(roots, typ)
+			}
+		}
+	}
+
+	for _, prog := range cs.Programs {
+		for _, ins := range prog.Instructions {
+			if fn := btf.FuncMetadata(&ins); fn != nil {
+				roots = append(roots, fn)
+			}
+		}
+	}
+
+	types, err := btf.NewSpecFromTypes(roots...)
+	if err != nil {
+		return err
+	}
+
+	cs.Types = types
+	for _, prog := range cs.Programs {
+		prog.BTF = types
+	}
+	for _, ms := range cs.Maps {
+		if ms.BTF != nil || ms.Value != nil {
+			ms.BTF = types
+		}
+	}
+
+	return nil
+}
+
+// isLinkableType returns false for types which are specific to a single
+// object and are recreated or dropped during linking.
+func isLinkableType(typ btf.Type) bool {
+	switch t := typ.(type) {
+	case *btf.Void, *btf.Datasec, *btf.Var:
+		return false
+	case *btf.Func:
+		return t.Linkage != btf.ExternFunc
+	case *btf.DeclTag:
+		return isLinkableType(t.Type)
+	default:
+		return true
+	}
+}
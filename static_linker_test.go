@@ -0,0 +1,175 @@
+package ebpf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// linkTestSpecs returns two specs. The program in the first spec returns the
+// sum of the value in its .data section and the value in the .data section of
+// the second spec. Both specs define a function called helper.
+func linkTestSpecs(t *testing.T, withBTF bool) (*CollectionSpec, *CollectionSpec) {
+	t.Helper()
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+
+	newSpec := func(value uint32, varName string, progs map[string]*ProgramSpec, funcs map[string]asm.Instructions) *CollectionSpec {
+		data := make([]byte, 4)
+		internal.NativeEndian.PutUint32(data, value)
+
+		ms := &MapSpec{
+			Name:       ".data",
+			Type:       Array,
+			KeySize:    4,
+			ValueSize:  4,
+			MaxEntries: 1,
+			Contents:   []MapKV{{uint32(0), data}},
+		}
+
+		cs := &CollectionSpec{
+			Maps:      map[string]*MapSpec{".data": ms},
+			Programs:  progs,
+			ByteOrder: internal.NativeEndian,
+			Functions: funcs,
+		}
+
+		if withBTF {
+			ds := &btf.Datasec{
+				Name: ".data",
+				Size: 4,
+				Vars: []btf.VarSecinfo{{
+					Type: &btf.Var{Name: varName, Type: u32, Linkage: btf.GlobalVar},
+					Size: 4,
+				}},
+			}
+
+			types, err := btf.NewSpecFromTypes(ds)
+			qt.Assert(t, err, qt.IsNil)
+
+			cs.Types = types
+			ms.BTF, ms.Key, ms.Value = types, &btf.Void{}, ds
+			for _, prog := range progs {
+				prog.BTF = types
+			}
+		}
+
+		return cs
+	}
+
+	helper := asm.Instructions{
+		asm.LoadMapValue(asm.R1, 0, 0).WithReference(".data").WithSymbol("helper"),
+		asm.LoadMem(asm.R0, asm.R1, 0, asm.Word),
+		asm.Return(),
+	}
+
+	a := newSpec(5, "a", map[string]*ProgramSpec{
+		"entry": {
+			Type:    SocketFilter,
+			License: "MIT",
+			Instructions: asm.Instructions{
+				asm.Call.Label("helper"),
+				asm.Mov.Reg(asm.R6, asm.R0),
+				asm.Call.Label("other"),
+				asm.Add.Reg(asm.R0, asm.R6),
+				asm.Return(),
+			},
+		},
+	}, map[string]asm.Instructions{"helper": helper})
+
+	b := newSpec(7, "b", nil, map[string]asm.Instructions{
+		"other": {
+			asm.Call.Label("helper").WithSymbol("other"),
+			asm.Return(),
+		},
+		"helper": helper,
+	})
+
+	return a, b
+}
+
+func TestLinkCollectionSpecs(t *testing.T) {
+	for _, withBTF := range []bool{false, true} {
+		name := "without BTF"
+		if withBTF {
+			name = "with BTF"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			c := qt.New(t)
+
+			a, b := linkTestSpecs(t, withBTF)
+			spec, err := LinkCollectionSpecs(a, b)
+			c.Assert(err, qt.IsNil)
+
+			c.Assert(spec.Maps, qt.HasLen, 1)
+			data := spec.Maps[".data"]
+			c.Assert(data.ValueSize, qt.Equals, uint32(12))
+			c.Assert(data.Contents, qt.HasLen, 1)
+			c.Assert(data.Contents[0].Value, qt.HasLen, 12)
+
+			if withBTF {
+				c.Assert(spec.Types, qt.IsNotNil)
+				c.Assert(data.BTF, qt.Equals, spec.Types)
+
+				ds := data.Value.(*btf.Datasec)
+				c.Assert(ds.Size, qt.Equals, uint32(12))
+				c.Assert(ds.Vars, qt.HasLen, 2)
+				c.Assert(ds.Vars[1].Offset, qt.Equals, uint32(8))
+			}
+
+			insns := spec.Programs["entry"].Instructions
+			offsets, err := insns.SymbolOffsets()
+			c.Assert(err, qt.IsNil)
+			for _, sym := range []string{"entry", "helper", "other", "helper.1"} {
+				_, ok := offsets[sym]
+				c.Assert(ok, qt.IsTrue, qt.Commentf("missing symbol %s", sym))
+			}
+
+			// The original specs are not modified.
+			c.Assert(a.Maps[".data"].ValueSize, qt.Equals, uint32(4))
+			c.Assert(a.Programs["entry"].Instructions, qt.HasLen, 5)
+			c.Assert(b.Functions["helper"][0].Constant, qt.Equals, int64(0))
+
+			coll, err := NewCollection(spec)
+			testutils.SkipIfNotSupported(t, err)
+			c.Assert(err, qt.IsNil)
+			defer coll.Close()
+
+			ret, _, err := coll.Programs["entry"].Test(make([]byte, 14))
+			testutils.SkipIfNotSupported(t, err)
+			c.Assert(err, qt.IsNil)
+			c.Assert(ret, qt.Equals, uint32(12))
+		})
+	}
+}
+
+func TestLinkCollectionSpecsErrors(t *testing.T) {
+	a, b := linkTestSpecs(t, false)
+	b.Maps["map"] = &MapSpec{Type: Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1}
+	a.Maps["map"] = &MapSpec{Type: Hash, KeySize: 4, ValueSize: 8, MaxEntries: 1}
+	_, err := LinkCollectionSpecs(a, b)
+	qt.Assert(t, errors.Is(err, ErrMapIncompatible), qt.IsTrue, qt.Commentf("got %v", err))
+
+	a, b = linkTestSpecs(t, false)
+	b.Programs = map[string]*ProgramSpec{"entry": a.Programs["entry"]}
+	_, err = LinkCollectionSpecs(a, b)
+	qt.Assert(t, err, qt.ErrorMatches, ".*duplicate program entry")
+
+	a, b = linkTestSpecs(t, false)
+	c, _ := linkTestSpecs(t, false)
+	delete(a.Functions, "helper")
+	c.Programs = nil
+	_, err = LinkCollectionSpecs(a, b, c)
+	qt.Assert(t, err, qt.ErrorMatches, ".*reference to helper is ambiguous.*")
+
+	withBTF, _ := linkTestSpecs(t, true)
+	_, err = LinkCollectionSpecs(withBTF, b)
+	qt.Assert(t, err, qt.IsNotNil)
+}
@@ -0,0 +1,58 @@
+package ebpf
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// LookupStorage retrieves the value which a SkStorage, InodeStorage or
+// TaskStorage map holds for the object referred to by fd.
+//
+// fd must be a socket for SkStorage, a file for InodeStorage and a pidfd
+// for TaskStorage. It is only used for the duration of the call. Use
+// os.File.Fd, syscall.RawConn.Control or pidfd_open(2) to obtain it.
+//
+// Returns ErrKeyNotExist if no value is stored for the object.
+func (m *Map) LookupStorage(fd int, valueOut interface{}) error {
+	key, err := m.storageKey(fd)
+	if err != nil {
+		return err
+	}
+	return m.Lookup(unsafe.Pointer(&key), valueOut)
+}
+
+// UpdateStorage stores value for the object referred to by fd in a
+// SkStorage, InodeStorage or TaskStorage map.
+//
+// See LookupStorage for the meaning of fd.
+func (m *Map) UpdateStorage(fd int, value interface{}, flags MapUpdateFlags) error {
+	key, err := m.storageKey(fd)
+	if err != nil {
+		return err
+	}
+	return m.Update(unsafe.Pointer(&key), value, flags)
+}
+
+// DeleteStorage removes the value stored for the object referred to by fd
+// from a SkStorage, InodeStorage or TaskStorage map.
+//
+// See LookupStorage for the meaning of fd. Returns ErrKeyNotExist if no
+// value is stored for the object.
+func (m *Map) DeleteStorage(fd int) error {
+	key, err := m.storageKey(fd)
+	if err != nil {
+		return err
+	}
+	return m.Delete(unsafe.Pointer(&key))
+}
+
+// storageKey returns the key of a local storage map for fd.
+func (m *Map) storageKey(fd int) (int32, error) {
+	if !m.typ.isLocalStorage() {
+		return 0, fmt.Errorf("%s is not a local storage map: %w", m.typ, ErrNotSupported)
+	}
+	if fd < 0 || int(int32(fd)) != fd {
+		return 0, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	return int32(fd), nil
+}
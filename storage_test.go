@@ -0,0 +1,118 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// newStorageMap creates a local storage map, using the BTF of the spin
+// lock test map as the kernel requires a value type.
+func newStorageMap(t *testing.T, typ MapType) *Map {
+	t.Helper()
+
+	file := fmt.Sprintf("testdata/map_spin_lock-%s.elf", internal.ClangEndian)
+	spec, err := LoadCollectionSpec(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapSpec := spec.Maps["spin_lock_map"].Copy()
+	mapSpec.Type = typ
+	mapSpec.MaxEntries = 0
+	mapSpec.Flags = unix.BPF_F_NO_PREALLOC
+
+	m, err := NewMap(mapSpec)
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	return m
+}
+
+// storageValue mirrors struct hash_elem of the spin lock test map.
+type storageValue struct {
+	Cnt  uint32
+	Lock uint32
+}
+
+func testStorage(t *testing.T, m *Map, fd int) {
+	t.Helper()
+
+	var value storageValue
+	if err := m.LookupStorage(fd, &value); !errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("Expected ErrKeyNotExist before update, got", err)
+	}
+
+	if err := m.UpdateStorage(fd, storageValue{Cnt: 42}, UpdateAny); err != nil {
+		t.Fatal("UpdateStorage:", err)
+	}
+
+	if err := m.LookupStorage(fd, &value); err != nil {
+		t.Fatal("LookupStorage:", err)
+	}
+	if value.Cnt != 42 {
+		t.Errorf("Expected 42, got %d", value.Cnt)
+	}
+
+	if err := m.DeleteStorage(fd); err != nil {
+		t.Fatal("DeleteStorage:", err)
+	}
+	if err := m.LookupStorage(fd, &value); !errors.Is(err, ErrKeyNotExist) {
+		t.Error("Expected ErrKeyNotExist after delete, got", err)
+	}
+}
+
+func TestTaskStorage(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.11", "task storage")
+
+	m := newStorageMap(t, TaskStorage)
+
+	pidfd, err := unix.PidfdOpen(unix.Getpid(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(pidfd)
+
+	testStorage(t, m, pidfd)
+}
+
+func TestSkStorage(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.2", "socket storage")
+
+	m := newStorageMap(t, SkStorage)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = raw.Control(func(fd uintptr) {
+		testStorage(t, m, int(fd))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageUnsupported(t *testing.T) {
+	m := createArray(t)
+	defer m.Close()
+
+	if err := m.DeleteStorage(0); !errors.Is(err, ErrNotSupported) {
+		t.Error("Expected ErrNotSupported, got", err)
+	}
+}
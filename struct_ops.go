@@ -0,0 +1,239 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+)
+
+// structOpsValuePrefix is the prefix of the kernel type which wraps a
+// struct_ops type in the value of a StructOpsMap.
+const structOpsValuePrefix = "bpf_struct_ops_"
+
+// StructOpsValue is the value of a StructOpsMap in MapSpec.Contents.
+//
+// The map is registered with the kernel once its value has been set, either
+// directly or by attaching it via the link package if the map was created
+// with BPF_F_LINK.
+type StructOpsValue struct {
+	// The contents of the struct in the layout described by MapSpec.Value.
+	// Function pointer members are ignored.
+	Data []byte
+
+	// The programs implementing function pointer members, indexed by the name
+	// of the member. Programs are looked up by name in the CollectionSpec
+	// and must have Type StructOps.
+	Programs map[string]string
+}
+
+func (v StructOpsValue) copy() StructOpsValue {
+	cpy := StructOpsValue{Data: make([]byte, len(v.Data))}
+	copy(cpy.Data, v.Data)
+	if v.Programs != nil {
+		cpy.Programs = make(map[string]string, len(v.Programs))
+		for member, prog := range v.Programs {
+			cpy.Programs[member] = prog
+		}
+	}
+	return cpy
+}
+
+// structOpsKernelValue describes the value of a struct_ops map as defined by
+// the kernel.
+type structOpsKernelValue struct {
+	// The type of the value, bpf_struct_ops_<name>.
+	value   *btf.Struct
+	valueID btf.TypeID
+	// The struct_ops type and its offset in value.
+	data   *btf.Struct
+	offset uint32
+}
+
+// findStructOpsKernelValue finds the kernel type used as the value of a
+// struct_ops map for the struct_ops type called name.
+func findStructOpsKernelValue(name string) (*structOpsKernelValue, error) {
+	kernelTypes, err := maybeLoadKernelBTF(nil)
+	if err != nil {
+		return nil, fmt.Errorf("load kernel spec: %w", err)
+	}
+
+	var value *btf.Struct
+	if err := kernelTypes.TypeByName(structOpsValuePrefix+name, &value); err != nil {
+		if errors.Is(err, btf.ErrNotFound) {
+			return nil, &internal.UnsupportedFeatureError{Name: name + " struct_ops"}
+		}
+		return nil, err
+	}
+
+	id, err := kernelTypes.TypeID(value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range value.Members {
+		if m.Name != "data" {
+			continue
+		}
+
+		data, ok := btf.UnderlyingType(m.Type).(*btf.Struct)
+		if !ok || data.Name != name {
+			break
+		}
+		return &structOpsKernelValue{value, id, data, m.Offset.Bytes()}, nil
+	}
+
+	return nil, fmt.Errorf("%s doesn't contain struct %s", value.Name, name)
+}
+
+// findStructOpsTarget finds the struct_ops type and the index of the member
+// implemented by a program attached to target, which has the form
+// "type:member".
+func findStructOpsTarget(kernelTypes *btf.Spec, target string) (btf.TypeID, uint32, error) {
+	i := strings.IndexByte(target, ':')
+	if i == -1 {
+		return 0, 0, fmt.Errorf("struct_ops target %q is not of the form type:member", target)
+	}
+	typeName, memberName := target[:i], target[i+1:]
+
+	kernelTypes, err := maybeLoadKernelBTF(kernelTypes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load kernel spec: %w", err)
+	}
+
+	var typ *btf.Struct
+	if err := kernelTypes.TypeByName(typeName, &typ); err != nil {
+		if errors.Is(err, btf.ErrNotFound) {
+			return 0, 0, &internal.UnsupportedFeatureError{Name: typeName + " struct_ops"}
+		}
+		return 0, 0, err
+	}
+
+	for i, m := range typ.Members {
+		if m.Name != memberName {
+			continue
+		}
+
+		if !isFuncPointer(m.Type) {
+			return 0, 0, fmt.Errorf("member %s of %s is not a function pointer", memberName, typeName)
+		}
+
+		id, err := kernelTypes.TypeID(typ)
+		if err != nil {
+			return 0, 0, err
+		}
+		return id, uint32(i), nil
+	}
+
+	return 0, 0, fmt.Errorf("%s has no member %s: %w", typeName, memberName, btf.ErrNotFound)
+}
+
+// marshalKernel converts the value into the layout of the kernel's
+// struct_ops value type. Members are matched by name, and function pointers
+// are replaced by the file descriptors of the given programs.
+func (v *StructOpsValue) marshalKernel(typ btf.Type, progs map[string]*Program) ([]byte, error) {
+	local, ok := btf.UnderlyingType(typ).(*btf.Struct)
+	if !ok {
+		return nil, fmt.Errorf("map value BTF is a %T, not a *btf.Struct", typ)
+	}
+
+	kv, err := findStructOpsKernelValue(local.Name)
+	if err != nil {
+		return nil, err
+	}
+	kern := kv.data
+
+	kernMembers := make(map[string]btf.Member, len(kern.Members))
+	for _, m := range kern.Members {
+		kernMembers[m.Name] = m
+	}
+
+	data := make([]byte, kv.value.Size)
+	for _, m := range local.Members {
+		if m.BitfieldSize > 0 {
+			return nil, fmt.Errorf("member %s: bitfields are not supported", m.Name)
+		}
+
+		size, err := btf.Sizeof(m.Type)
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", m.Name, err)
+		}
+
+		offset := int(m.Offset.Bytes())
+		if offset+size > len(v.Data) {
+			return nil, fmt.Errorf("member %s: out of bounds of data", m.Name)
+		}
+		udata := v.Data[offset : offset+size]
+
+		km, ok := kernMembers[m.Name]
+		if !ok {
+			if _, isProg := progs[m.Name]; !isProg && !isFuncPointer(m.Type) && isZero(udata) {
+				// Tolerate members missing from the kernel as long as they
+				// are unset.
+				continue
+			}
+			return nil, fmt.Errorf("member %s doesn't exist in kernel struct %s", m.Name, kern.Name)
+		}
+		kdata := data[kv.offset+km.Offset.Bytes():]
+
+		if prog, ok := progs[m.Name]; ok {
+			if !isFuncPointer(km.Type) {
+				return nil, fmt.Errorf("member %s is not a function pointer", m.Name)
+			}
+
+			fd := prog.FD()
+			if fd < 0 {
+				return nil, fmt.Errorf("member %s: invalid program: %w", m.Name, sys.ErrClosedFd)
+			}
+			internal.NativeEndian.PutUint64(kdata, uint64(fd))
+			continue
+		}
+
+		if isFuncPointer(m.Type) {
+			// Function pointers without a program stay NULL.
+			continue
+		}
+
+		ksize, err := btf.Sizeof(km.Type)
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", m.Name, err)
+		}
+
+		if km.BitfieldSize > 0 || ksize != size {
+			return nil, fmt.Errorf("member %s: size %d doesn't match kernel size %d", m.Name, size, ksize)
+		}
+
+		copy(kdata, udata)
+	}
+
+	for name := range progs {
+		if _, ok := kernMembers[name]; !ok {
+			return nil, fmt.Errorf("member %s doesn't exist in kernel struct %s", name, kern.Name)
+		}
+	}
+
+	return data, nil
+}
+
+// isFuncPointer returns true if typ is a pointer to a function prototype.
+func isFuncPointer(typ btf.Type) bool {
+	ptr, ok := btf.UnderlyingType(typ).(*btf.Pointer)
+	if !ok {
+		return false
+	}
+
+	_, ok = btf.UnderlyingType(ptr.Target).(*btf.FuncProto)
+	return ok
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package ebpf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal/testutils"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// tcpCongestionOpsSpec returns a spec for a minimal TCP congestion control
+// algorithm. The layout of the struct intentionally differs from the kernel.
+func tcpCongestionOpsSpec(t *testing.T, name string) *CollectionSpec {
+	t.Helper()
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	fn := &btf.Pointer{Target: &btf.FuncProto{Return: u32}}
+	ops := &btf.Struct{
+		Name: "tcp_congestion_ops",
+		Size: 40,
+		Members: []btf.Member{
+			{Name: "name", Type: &btf.Array{Index: u32, Type: char, Nelems: 16}},
+			{Name: "ssthresh", Type: fn, Offset: 16 * 8},
+			{Name: "undo_cwnd", Type: fn, Offset: 24 * 8},
+			{Name: "cong_avoid", Type: fn, Offset: 32 * 8},
+		},
+	}
+
+	types, err := btf.NewSpecFromTypes(ops)
+	qt.Assert(t, err, qt.IsNil)
+
+	data := make([]byte, ops.Size)
+	copy(data, name)
+
+	prog := func(member string, ret int32) *ProgramSpec {
+		return &ProgramSpec{
+			Name:     member,
+			Type:     StructOps,
+			AttachTo: "tcp_congestion_ops:" + member,
+			License:  "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, ret),
+				asm.Return(),
+			},
+		}
+	}
+
+	return &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			"ops": {
+				Name:       "ops",
+				Type:       StructOpsMap,
+				KeySize:    4,
+				ValueSize:  ops.Size,
+				MaxEntries: 1,
+				BTF:        types,
+				Value:      ops,
+				Contents: []MapKV{{uint32(0), StructOpsValue{
+					Data: data,
+					Programs: map[string]string{
+						"ssthresh":   "ssthresh",
+						"undo_cwnd":  "undo_cwnd",
+						"cong_avoid": "cong_avoid",
+					},
+				}}},
+			},
+		},
+		Programs: map[string]*ProgramSpec{
+			"ssthresh":   prog("ssthresh", 2),
+			"undo_cwnd":  prog("undo_cwnd", 2),
+			"cong_avoid": prog("cong_avoid", 0),
+		},
+		Types: types,
+	}
+}
+
+func availableCongestionControl(t *testing.T) []string {
+	t.Helper()
+
+	contents, err := os.ReadFile("/proc/sys/net/ipv4/tcp_available_congestion_control")
+	if os.IsNotExist(err) {
+		t.Skip("TCP congestion control is not available")
+	}
+	qt.Assert(t, err, qt.IsNil)
+
+	return strings.Fields(string(contents))
+}
+
+func TestStructOpsMap(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.6", "struct_ops")
+	c := qt.New(t)
+
+	spec := tcpCongestionOpsSpec(t, "ebpf_go_test")
+	coll, err := NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	c.Assert(err, qt.IsNil)
+	defer coll.Close()
+
+	ops := coll.Maps["ops"]
+	c.Assert(ops.Type(), qt.Equals, StructOpsMap)
+
+	// Setting the value registers the algorithm, deleting it unregisters.
+	c.Assert(availableCongestionControl(t), qt.Contains, "ebpf_go_test")
+	c.Assert(ops.Delete(uint32(0)), qt.IsNil)
+	c.Assert(availableCongestionControl(t), qt.Not(qt.Contains), "ebpf_go_test")
+}
+
+func TestStructOpsMapUnknownMember(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.6", "struct_ops")
+
+	spec := tcpCongestionOpsSpec(t, "ebpf_go_test")
+	ops := spec.Maps["ops"].Value.(*btf.Struct)
+	ops.Members = append(ops.Members, btf.Member{Name: "bogus", Type: &btf.Int{Size: 4}, Offset: 36 * 8})
+	copy(spec.Maps["ops"].Contents[0].Value.(StructOpsValue).Data[36:], []byte{1})
+
+	coll, err := NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	if err == nil {
+		coll.Maps["ops"].Delete(uint32(0))
+		coll.Close()
+	}
+	qt.Assert(t, err, qt.ErrorMatches, ".*member bogus doesn't exist.*")
+}
+
+func TestFindStructOpsTarget(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.6", "struct_ops")
+
+	id, member, err := findStructOpsTarget(nil, "tcp_congestion_ops:undo_cwnd")
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.Not(qt.Equals), btf.TypeID(0))
+
+	spec, err := btf.LoadKernelSpec()
+	qt.Assert(t, err, qt.IsNil)
+	typ, err := spec.TypeByID(id)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, typ.(*btf.Struct).Members[member].Name, qt.Equals, "undo_cwnd")
+
+	_, _, err = findStructOpsTarget(nil, "tcp_congestion_ops:name")
+	qt.Assert(t, err, qt.IsNotNil)
+
+	_, _, err = findStructOpsTarget(nil, "tcp_congestion_ops")
+	qt.Assert(t, err, qt.IsNotNil)
+}
@@ -98,6 +98,20 @@ var haveMmapableMaps = internal.FeatureTest("mmapable maps", "5.5", func() error
 	return nil
 })
 
+var haveBloomFilter = internal.FeatureTest("bloom filter", "5.16", func() error {
+	m, err := sys.MapCreate(&sys.MapCreateAttr{
+		MapType:    sys.MapType(BloomFilter),
+		ValueSize:  4,
+		MaxEntries: 1,
+		MapExtra:   1,
+	})
+	if err != nil {
+		return internal.ErrNotSupported
+	}
+	_ = m.Close()
+	return nil
+})
+
 var haveInnerMaps = internal.FeatureTest("inner maps", "5.10", func() error {
 	// This checks BPF_F_INNER_MAP, which appeared in 5.10.
 	m, err := sys.MapCreate(&sys.MapCreateAttr{
@@ -47,6 +47,10 @@ func TestHaveMmapableMaps(t *testing.T) {
 	testutils.CheckFeatureTest(t, haveMmapableMaps)
 }
 
+func TestHaveBloomFilter(t *testing.T) {
+	testutils.CheckFeatureTest(t, haveBloomFilter)
+}
+
 func TestHaveInnerMaps(t *testing.T) {
 	testutils.CheckFeatureTest(t, haveInnerMaps)
 }
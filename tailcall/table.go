@@ -0,0 +1,319 @@
+// Package tailcall manages the contents of ProgramArray maps used to
+// dispatch tail calls.
+//
+// A Table assigns a name to each slot of a ProgramArray, so that the BPF
+// side can use fixed indices while the Go side deals in handler names.
+// Handlers can be replaced while the datapath is running.
+package tailcall
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cilium/ebpf"
+)
+
+// ErrUnknownHandler is returned when referring to a name which wasn't
+// declared when creating a Table.
+var ErrUnknownHandler = errors.New("unknown handler")
+
+// ErrIncompatibleProgram is returned when installing a handler whose type
+// differs from the other handlers in a Table.
+var ErrIncompatibleProgram = errors.New("incompatible program")
+
+// Table maps named handlers to slots of a ProgramArray.
+//
+// The Table holds a reference to each installed program. Replacing or
+// removing a handler releases that reference, so the kernel frees the old
+// program as soon as no other references, including running tail calls,
+// remain.
+//
+// It is safe to use a Table from multiple goroutines.
+type Table struct {
+	mu       sync.Mutex
+	array    *ebpf.Map
+	indices  map[string]uint32
+	handlers map[string]*ebpf.Program
+}
+
+// NewTable creates a Table for an existing ProgramArray.
+//
+// indices assigns a slot to each handler name. The Table uses a duplicate of
+// array, so the caller may close it.
+func NewTable(array *ebpf.Map, indices map[string]uint32) (*Table, error) {
+	if t := array.Type(); t != ebpf.ProgramArray {
+		return nil, fmt.Errorf("invalid map type %s, expected ProgramArray", t)
+	}
+
+	seen := make(map[uint32]string)
+	for name, index := range indices {
+		if index >= array.MaxEntries() {
+			return nil, fmt.Errorf("handler %s: index %d exceeds %d entries", name, index, array.MaxEntries())
+		}
+		if other, ok := seen[index]; ok {
+			return nil, fmt.Errorf("handlers %s and %s share index %d", name, other, index)
+		}
+		seen[index] = name
+	}
+
+	dup, err := array.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	copied := make(map[string]uint32, len(indices))
+	for name, index := range indices {
+		copied[name] = index
+	}
+
+	return &Table{
+		array:    dup,
+		indices:  copied,
+		handlers: make(map[string]*ebpf.Program),
+	}, nil
+}
+
+// NewTableFromNames creates a ProgramArray with one slot per name.
+//
+// Slots are assigned in the order of names.
+func NewTableFromNames(names ...string) (*Table, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no handler names given")
+	}
+
+	indices := make(map[string]uint32, len(names))
+	for i, name := range names {
+		if _, ok := indices[name]; ok {
+			return nil, fmt.Errorf("duplicate handler %s", name)
+		}
+		indices[name] = uint32(i)
+	}
+
+	array, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: uint32(len(names)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer array.Close()
+
+	return NewTable(array, indices)
+}
+
+// Map returns the underlying ProgramArray.
+//
+// The map is owned by the Table and becomes invalid once the Table is
+// closed. Use it to populate a MapReplacement or to pin it.
+func (t *Table) Map() *ebpf.Map {
+	return t.array
+}
+
+// Names returns the declared handler names in order of their index.
+func (t *Table) Names() []string {
+	names := make([]string, 0, len(t.indices))
+	for name := range t.indices {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return t.indices[names[i]] < t.indices[names[j]]
+	})
+	return names
+}
+
+// Index returns the slot of a handler.
+func (t *Table) Index(name string) (uint32, bool) {
+	index, ok := t.indices[name]
+	return index, ok
+}
+
+// Program returns a duplicate of the program currently installed for name,
+// or nil if the slot is empty.
+//
+// The caller must close the returned program.
+func (t *Table) Program(name string) (*ebpf.Program, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.indices[name]; !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownHandler)
+	}
+
+	prog := t.handlers[name]
+	if prog == nil {
+		return nil, nil
+	}
+
+	return prog.Clone()
+}
+
+// Set installs prog as the handler for name, replacing the previous one.
+//
+// The update is atomic from the point of view of BPF programs: a tail call
+// runs either the old or the new handler.
+func (t *Table) Set(name string, prog *ebpf.Program) error {
+	return t.Replace(map[string]*ebpf.Program{name: prog})
+}
+
+// Replace installs multiple handlers.
+//
+// All handlers of a Table must be of the same program type, since a tail
+// call can only jump to programs of the type of the caller. Returns an error
+// wrapping ErrIncompatibleProgram otherwise. The kernel enforces further
+// constraints once a caller has been loaded.
+//
+// If installing any of the handlers fails, the previous handlers are
+// restored. Each slot is updated atomically, but there is no guarantee
+// that all slots change at the same time.
+func (t *Table) Replace(handlers map[string]*ebpf.Program) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.array == nil {
+		return fmt.Errorf("table is closed")
+	}
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		if _, ok := t.indices[name]; !ok {
+			return fmt.Errorf("%s: %w", name, ErrUnknownHandler)
+		}
+		if handlers[name] == nil {
+			return fmt.Errorf("%s: program is nil", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := t.checkTypes(names, handlers); err != nil {
+		return err
+	}
+
+	installed := make(map[string]*ebpf.Program, len(names))
+	rollback := func() {
+		for name, prog := range installed {
+			t.restore(name)
+			prog.Close()
+		}
+	}
+
+	for _, name := range names {
+		prog, err := handlers[name].Clone()
+		if err != nil {
+			rollback()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if err := t.array.Put(t.indices[name], prog); err != nil {
+			prog.Close()
+			rollback()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		installed[name] = prog
+	}
+
+	for name, prog := range installed {
+		if old := t.handlers[name]; old != nil {
+			old.Close()
+		}
+		t.handlers[name] = prog
+	}
+
+	return nil
+}
+
+// checkTypes ensures that the new handlers share a type with each other and
+// with the handlers which remain installed.
+func (t *Table) checkTypes(names []string, handlers map[string]*ebpf.Program) error {
+	var (
+		want  ebpf.ProgramType
+		owner string
+	)
+	for name, prog := range t.handlers {
+		if _, ok := handlers[name]; !ok {
+			want, owner = prog.Type(), name
+			break
+		}
+	}
+
+	for _, name := range names {
+		typ := handlers[name].Type()
+		if owner == "" {
+			want, owner = typ, name
+			continue
+		}
+		if typ != want {
+			return fmt.Errorf("%s: type %s doesn't match %s of handler %s: %w", name, typ, want, owner, ErrIncompatibleProgram)
+		}
+	}
+
+	return nil
+}
+
+// restore puts the current handler for name back into the array.
+func (t *Table) restore(name string) {
+	if old := t.handlers[name]; old != nil {
+		_ = t.array.Put(t.indices[name], old)
+	} else {
+		_ = t.array.Delete(t.indices[name])
+	}
+}
+
+// Delete removes the handler for name.
+//
+// Tail calls to the slot fail afterwards, and execution continues in the
+// calling program. Deleting an empty slot is not an error.
+func (t *Table) Delete(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.array == nil {
+		return fmt.Errorf("table is closed")
+	}
+
+	index, ok := t.indices[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownHandler)
+	}
+
+	old := t.handlers[name]
+	if old == nil {
+		return nil
+	}
+
+	if err := t.array.Delete(index); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	old.Close()
+	delete(t.handlers, name)
+	return nil
+}
+
+// Close releases all handlers and the ProgramArray.
+//
+// The kernel empties a ProgramArray once no user space references to it
+// remain, even if BPF programs still use it. Pin the array or keep another
+// duplicate open to preserve the handlers.
+func (t *Table) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.array == nil {
+		return nil
+	}
+
+	for name, prog := range t.handlers {
+		prog.Close()
+		delete(t.handlers, name)
+	}
+
+	err := t.array.Close()
+	t.array = nil
+	return err
+}
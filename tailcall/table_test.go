@@ -0,0 +1,206 @@
+package tailcall
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func mustReturn(tb testing.TB, value int32) *ebpf.Program {
+	tb.Helper()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, value),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+func mustXDP(tb testing.TB) *ebpf.Program {
+	tb.Helper()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+// mustDispatch loads a program which tail calls into index of array, and
+// returns 0 if the tail call fails.
+func mustDispatch(tb testing.TB, array *ebpf.Map, index int32) *ebpf.Program {
+	tb.Helper()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.LoadMapPtr(asm.R2, array.FD()),
+			asm.Mov.Imm(asm.R3, index),
+			asm.FnTailCall.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+
+	return prog
+}
+
+func run(tb testing.TB, prog *ebpf.Program) uint32 {
+	tb.Helper()
+
+	ret, _, err := prog.Test(make([]byte, 14))
+	testutils.SkipIfNotSupported(tb, err)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ret
+}
+
+func TestTable(t *testing.T) {
+	table, err := NewTableFromNames("ipv4", "ipv6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if names := table.Names(); len(names) != 2 || names[0] != "ipv4" || names[1] != "ipv6" {
+		t.Fatal("Unexpected names:", names)
+	}
+
+	if index, ok := table.Index("ipv6"); !ok || index != 1 {
+		t.Fatal("Unexpected index for ipv6:", index)
+	}
+
+	dispatch := mustDispatch(t, table.Map(), 1)
+
+	if ret := run(t, dispatch); ret != 0 {
+		t.Fatal("Tail call into an empty slot returned", ret)
+	}
+
+	if err := table.Set("ipv6", mustReturn(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ret := run(t, dispatch); ret != 1 {
+		t.Fatal("Expected first handler to return 1, got", ret)
+	}
+
+	if err := table.Set("ipv6", mustReturn(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ret := run(t, dispatch); ret != 2 {
+		t.Fatal("Expected reloaded handler to return 2, got", ret)
+	}
+
+	prog, err := table.Program("ipv6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog == nil {
+		t.Fatal("No program returned for installed handler")
+	}
+	prog.Close()
+
+	if err := table.Set("foo", mustReturn(t, 3)); !errors.Is(err, ErrUnknownHandler) {
+		t.Fatal("Expected ErrUnknownHandler, got", err)
+	}
+
+	err = table.Replace(map[string]*ebpf.Program{
+		"ipv4": mustReturn(t, 4),
+		"ipv6": nil,
+	})
+	if err == nil {
+		t.Fatal("Replace with a nil program should fail")
+	}
+
+	if ret := run(t, dispatch); ret != 2 {
+		t.Fatal("Failed Replace changed the handler, got", ret)
+	}
+
+	if err := table.Set("ipv4", mustXDP(t)); !errors.Is(err, ErrIncompatibleProgram) {
+		t.Fatal("Expected ErrIncompatibleProgram, got", err)
+	}
+
+	if err := table.Delete("ipv6"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ret := run(t, dispatch); ret != 0 {
+		t.Fatal("Tail call into a deleted slot returned", ret)
+	}
+
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Set("ipv4", mustReturn(t, 1)); err == nil {
+		t.Fatal("Set on a closed table should fail")
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	array, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	if _, err := NewTable(array, map[string]uint32{"a": 2}); err == nil {
+		t.Error("Index out of bounds should fail")
+	}
+
+	if _, err := NewTable(array, map[string]uint32{"a": 1, "b": 1}); err == nil {
+		t.Error("Duplicate index should fail")
+	}
+
+	if _, err := NewTableFromNames("a", "a"); err == nil {
+		t.Error("Duplicate name should fail")
+	}
+
+	table, err := NewTable(array, map[string]uint32{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if err := table.Set("a", mustReturn(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var id ebpf.ProgramID
+	if err := array.Lookup(uint32(1), &id); err != nil {
+		t.Fatal("Handler isn't visible via the original map:", err)
+	}
+}
@@ -0,0 +1,83 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/sys"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Token grants permissions to create maps and to load programs and BTF,
+// which have been delegated by an instance of bpffs.
+//
+// Tokens allow processes without CAP_BPF, for example in a container, to use
+// BPF. A privileged process mounts a bpffs with the delegate_cmds,
+// delegate_maps, delegate_progs and delegate_attachs options and hands it
+// to the user namespace of the container. Tokens can't be created in the
+// initial user namespace.
+//
+// Pass a Token via MapOptions or ProgramOptions. Feature detection performed
+// by this package doesn't use the token and may fail without privileges.
+type Token struct {
+	fd *sys.FD
+}
+
+// NewToken creates a token from the bpffs mounted at path.
+//
+// Returns ErrNotSupported if the kernel doesn't support tokens. Requires at
+// least Linux 6.9.
+func NewToken(path string) (*Token, error) {
+	if err := haveBPFToken(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fd, err := sys.TokenCreate(&sys.TokenCreateAttr{
+		BpffsFd: uint32(f.Fd()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create token from %s: %w", path, err)
+	}
+
+	return &Token{fd}, nil
+}
+
+// FD returns the file descriptor of the token.
+func (t *Token) FD() int {
+	return t.fd.Int()
+}
+
+// Close releases the token.
+//
+// Objects created with the token remain valid.
+func (t *Token) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	return t.fd.Close()
+}
+
+var haveBPFToken = internal.FeatureTest("BPF_TOKEN_CREATE", "6.9", func() error {
+	// An invalid file descriptor makes the command fail with EBADF if it
+	// exists, without having to mount a bpffs.
+	_, err := sys.TokenCreate(&sys.TokenCreateAttr{
+		BpffsFd: math.MaxUint32,
+	})
+	if errors.Is(err, unix.EINVAL) {
+		return internal.ErrNotSupported
+	}
+	if errors.Is(err, unix.EBADF) {
+		return nil
+	}
+	return err
+})
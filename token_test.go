@@ -0,0 +1,20 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestHaveBPFToken(t *testing.T) {
+	testutils.CheckFeatureTest(t, haveBPFToken)
+}
+
+func TestNewTokenNotBPFFS(t *testing.T) {
+	token, err := NewToken(t.TempDir())
+	testutils.SkipIfNotSupported(t, err)
+	if err == nil {
+		token.Close()
+		t.Fatal("Creating a token from a directory which isn't a bpffs should fail")
+	}
+}
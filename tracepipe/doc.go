@@ -0,0 +1,6 @@
+// Package tracepipe allows reading the output of bpf_trace_printk.
+//
+// BPF programs can emit debug messages using bpf_trace_printk, which the
+// kernel writes to <tracefs>/trace_pipe. Reading from trace_pipe consumes
+// messages, so only one reader on the system sees each message.
+package tracepipe
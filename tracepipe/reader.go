@@ -0,0 +1,212 @@
+package tracepipe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/internal"
+)
+
+var (
+	// ErrClosed is returned by Reader.Read after the Reader was closed. It's
+	// the same value as os.ErrClosed.
+	ErrClosed = os.ErrClosed
+
+	// tracefsPaths are the possible mount points of tracefs, in order of
+	// preference.
+	tracefsPaths = []string{
+		"/sys/kernel/tracing",
+		"/sys/kernel/debug/tracing",
+	}
+
+	// An entry in trace_pipe looks like this:
+	//
+	//    <comm>-<pid> [(<tgid>)] [<cpu>] [<flags>] <timestamp>: <function>: <message>
+	//
+	// The tgid is only present if the record-tgid option is enabled, and
+	// flags are missing on very old kernels.
+	lineRe = regexp.MustCompile(`^\s*(.*)-(\d+)\s+(?:\(\s*[-\d]+\)\s+)?\[(\d+)\]\s+(?:(\S+)\s+)?(\d+)\.(\d+):\s+([^:]+):\s?(.*)$`)
+)
+
+// printkFunction is the function name used for output of bpf_trace_printk.
+const printkFunction = "bpf_trace_printk"
+
+// Record is a single entry read from trace_pipe.
+type Record struct {
+	// Task is the command name of the task which was running when the
+	// message was written.
+	Task string
+	PID  int
+	CPU  int
+	// Flags contains the irq and preemption state, in the format used by
+	// ftrace.
+	Flags string
+	// Timestamp is the time since boot at which the message was written.
+	Timestamp time.Duration
+	// Function is the name of the trace event.
+	Function string
+	Message  string
+}
+
+// ReaderOptions control the behaviour of a Reader.
+type ReaderOptions struct {
+	// Path to the tracefs mount point. Searched for in the default
+	// locations if empty.
+	Path string
+
+	// All returns every entry in trace_pipe, not just those written by
+	// bpf_trace_printk.
+	All bool
+
+	// Prefix only returns messages starting with Prefix, which is
+	// removed from the message. Programs can use a unique prefix to
+	// distinguish their messages from those of other programs.
+	Prefix string
+}
+
+// Reader parses entries from trace_pipe.
+type Reader struct {
+	mu   sync.Mutex
+	file *os.File
+	rd   *bufio.Reader
+	opts ReaderOptions
+}
+
+// NewReader opens trace_pipe using default options.
+func NewReader() (*Reader, error) {
+	return NewReaderWithOptions(ReaderOptions{})
+}
+
+// NewReaderWithOptions opens trace_pipe.
+//
+// Returns ErrNotSupported if tracefs is not mounted.
+func NewReaderWithOptions(opts ReaderOptions) (*Reader, error) {
+	paths := tracefsPaths
+	if opts.Path != "" {
+		paths = []string{opts.Path}
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(filepath.Join(path, "trace_pipe"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open trace_pipe: %w", err)
+		}
+
+		return &Reader{
+			file: file,
+			rd:   bufio.NewReader(file),
+			opts: opts,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("find trace_pipe: %w", internal.ErrNotSupported)
+}
+
+// Close the reader.
+//
+// Interrupts any Read calls in progress.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// SetDeadline controls how long Read waits for an entry.
+//
+// Passing a zero time.Time will remove the deadline.
+func (r *Reader) SetDeadline(t time.Time) error {
+	return r.file.SetDeadline(t)
+}
+
+// Read the next entry.
+//
+// Blocks until an entry matching the options is available. Lines which
+// can't be parsed, like markers for lost events, are skipped.
+//
+// Returns os.ErrDeadlineExceeded if a deadline was set, and ErrClosed if
+// the reader was closed.
+func (r *Reader) Read() (Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		line, err := r.rd.ReadString('\n')
+		if errors.Is(err, os.ErrClosed) {
+			return Record{}, fmt.Errorf("read trace_pipe: %w", ErrClosed)
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("read trace_pipe: %w", err)
+		}
+
+		rec, ok := parseLine(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			continue
+		}
+
+		if !r.opts.All && rec.Function != printkFunction {
+			continue
+		}
+
+		if r.opts.Prefix != "" {
+			if !strings.HasPrefix(rec.Message, r.opts.Prefix) {
+				continue
+			}
+			rec.Message = strings.TrimPrefix(rec.Message, r.opts.Prefix)
+		}
+
+		return rec, nil
+	}
+}
+
+// parseLine parses a single line of trace_pipe output.
+func parseLine(line string) (Record, bool) {
+	match := lineRe.FindStringSubmatch(line)
+	if match == nil {
+		return Record{}, false
+	}
+
+	pid, err := strconv.Atoi(match[2])
+	if err != nil {
+		return Record{}, false
+	}
+
+	cpu, err := strconv.Atoi(match[3])
+	if err != nil {
+		return Record{}, false
+	}
+
+	secs, err := strconv.ParseInt(match[5], 10, 64)
+	if err != nil {
+		return Record{}, false
+	}
+
+	// The fractional part has microsecond or nanosecond precision
+	// depending on the trace clock.
+	frac := match[6]
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	nsecs, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if err != nil {
+		return Record{}, false
+	}
+
+	return Record{
+		Task:      strings.TrimSpace(match[1]),
+		PID:       pid,
+		CPU:       cpu,
+		Flags:     match[4],
+		Timestamp: time.Duration(secs)*time.Second + time.Duration(nsecs),
+		Function:  match[7],
+		Message:   match[8],
+	}, true
+}
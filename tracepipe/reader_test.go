@@ -0,0 +1,176 @@
+package tracepipe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestParseLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		rec  Record
+	}{
+		{
+			"           <...>-1234    [001] d..31 12345.678901: bpf_trace_printk: hello world",
+			Record{"<...>", 1234, 1, "d..31", 12345*time.Second + 678901*time.Microsecond, printkFunction, "hello world"},
+		},
+		{
+			" systemd-journal-42  [000] .... 1.000000001: bpf_trace_printk: a: b",
+			Record{"systemd-journal", 42, 0, "....", time.Second + 1, printkFunction, "a: b"},
+		},
+		{
+			"bash-1977  ( 1977) [003] ...1    12.5: sys_enter: NR 1",
+			Record{"bash", 1977, 3, "...1", 12*time.Second + 500*time.Millisecond, "sys_enter", "NR 1"},
+		},
+		{
+			"bash-1977  [003] 12.5: bpf_trace_printk: ",
+			Record{"bash", 1977, 3, "", 12*time.Second + 500*time.Millisecond, printkFunction, ""},
+		},
+	} {
+		rec, ok := parseLine(tc.line)
+		if !ok {
+			t.Errorf("Can't parse %q", tc.line)
+			continue
+		}
+		if rec != tc.rec {
+			t.Errorf("Parsing %q\nexpected %+v\ngot      %+v", tc.line, tc.rec, rec)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"CPU:3 [LOST 12 EVENTS]",
+	} {
+		if _, ok := parseLine(line); ok {
+			t.Errorf("Parsing %q should fail", line)
+		}
+	}
+}
+
+func TestReaderFilter(t *testing.T) {
+	dir := t.TempDir()
+	contents := "sh-1 [000] .... 1.0: sys_enter: NR 1\n" +
+		"CPU:0 [LOST 1 EVENTS]\n" +
+		"sh-1 [000] .... 2.0: bpf_trace_printk: other\n" +
+		"sh-1 [000] .... 3.0: bpf_trace_printk: myapp: hello\n"
+	if err := os.WriteFile(filepath.Join(dir, "trace_pipe"), []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(opts ReaderOptions) []string {
+		t.Helper()
+
+		opts.Path = dir
+		rd, err := NewReaderWithOptions(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+
+		var msgs []string
+		for {
+			rec, err := rd.Read()
+			if err != nil {
+				return msgs
+			}
+			msgs = append(msgs, rec.Message)
+		}
+	}
+
+	if msgs := read(ReaderOptions{All: true}); len(msgs) != 3 {
+		t.Error("Expected three entries, got", msgs)
+	}
+
+	if msgs := read(ReaderOptions{}); len(msgs) != 2 {
+		t.Error("Expected two bpf_trace_printk entries, got", msgs)
+	}
+
+	if msgs := read(ReaderOptions{Prefix: "myapp: "}); len(msgs) != 1 || msgs[0] != "hello" {
+		t.Error("Expected a single prefixed entry, got", msgs)
+	}
+
+	_, err := NewReaderWithOptions(ReaderOptions{Path: t.TempDir()})
+	if !errors.Is(err, internal.ErrNotSupported) {
+		t.Error("Expected ErrNotSupported for a missing trace_pipe, got", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	rd, err := NewReaderWithOptions(ReaderOptions{Prefix: "tracepipe-test:"})
+	if errors.Is(err, internal.ErrNotSupported) {
+		t.Skip("tracefs is not mounted")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	// "tracepipe-test:%d" followed by a NUL byte.
+	msg := []byte("tracepipe-test:%d\x00\x00\x00\x00\x00\x00\x00")
+	insns := asm.Instructions{}
+	for i := 0; i < len(msg); i += 8 {
+		insns = append(insns,
+			asm.LoadImm(asm.R1, int64(internal.NativeEndian.Uint64(msg[i:])), asm.DWord),
+			asm.StoreMem(asm.RFP, int16(i-len(msg)), asm.R1, asm.DWord),
+		)
+	}
+	insns = append(insns,
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, int32(-len(msg))),
+		asm.Mov.Imm(asm.R2, int32(len(msg))),
+		asm.Mov.Imm(asm.R3, 42),
+		asm.FnTracePrintk.Call(),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+	)
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.SocketFilter,
+		License:      "GPL",
+		Instructions: insns,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	if _, _, err := prog.Test(make([]byte, 14)); err != nil {
+		testutils.SkipIfNotSupported(t, err)
+		t.Fatal(err)
+	}
+
+	if err := rd.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := rd.Read()
+	if err != nil {
+		t.Fatal("Can't read message:", err)
+	}
+
+	if rec.Message != "42" {
+		t.Errorf("Expected message 42, got %q", rec.Message)
+	}
+	if rec.Function != printkFunction {
+		t.Errorf("Expected function %s, got %s", printkFunction, rec.Function)
+	}
+	if rec.Task == "" || rec.PID == 0 {
+		t.Errorf("Missing task information in %+v", rec)
+	}
+
+	if err := rd.SetDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rd.Read(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("Expected os.ErrDeadlineExceeded, got", err)
+	}
+}
@@ -0,0 +1,154 @@
+//go:build go1.21
+// +build go1.21
+
+package ebpf
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// TypedMap is a Map with fixed key and value types.
+//
+// Keys and values are marshaled according to the same rules as the
+// interface{} arguments of Map. Values of per-CPU maps must be slices, with
+// one element per possible CPU.
+//
+// TypedMap requires Go 1.21 or later.
+type TypedMap[K, V any] struct {
+	m *Map
+}
+
+// NewTypedMap wraps m.
+//
+// Returns an error if the encoded size of K or V doesn't match the key or
+// value size of the map. Types implementing encoding.BinaryMarshaler are not
+// checked.
+//
+// The TypedMap doesn't take ownership of m, the caller must close it.
+func NewTypedMap[K, V any](m *Map) (*TypedMap[K, V], error) {
+	if err := checkTypedSize[K](m.KeySize()); err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+
+	if !m.Type().hasPerCPUValue() {
+		if err := checkTypedSize[V](m.ValueSize()); err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+	}
+
+	return &TypedMap[K, V]{m}, nil
+}
+
+func checkTypedSize[T any](want uint32) error {
+	var zero T
+	if _, ok := any(&zero).(encoding.BinaryMarshaler); ok {
+		return nil
+	}
+	if _, ok := any(zero).(encoding.BinaryMarshaler); ok {
+		return nil
+	}
+
+	typ := reflect.TypeOf(&zero).Elem()
+	if typ.Kind() == reflect.Slice || typ.Kind() == reflect.String {
+		// The size is only known at runtime.
+		return nil
+	}
+
+	size := binary.Size(zero)
+	if size < 0 {
+		return fmt.Errorf("%s has no fixed size", typ)
+	}
+	if uint32(size) != want {
+		return fmt.Errorf("%s has size %d, expected %d", typ, size, want)
+	}
+
+	return nil
+}
+
+// Map returns the underlying Map.
+func (tm *TypedMap[K, V]) Map() *Map {
+	return tm.m
+}
+
+// Lookup retrieves the value for a key.
+//
+// Returns an error wrapping ErrKeyNotExist if the key doesn't exist.
+func (tm *TypedMap[K, V]) Lookup(key K) (V, error) {
+	var value V
+	err := tm.m.Lookup(key, &value)
+	return value, err
+}
+
+// HasKey checks whether a key exists.
+//
+// See Map.HasKey.
+func (tm *TypedMap[K, V]) HasKey(key K) (bool, error) {
+	return tm.m.HasKey(key)
+}
+
+// LookupWithFlags retrieves the value for a key with flags.
+//
+// Pass LookupLock to read a value containing a bpf_spin_lock consistently.
+func (tm *TypedMap[K, V]) LookupWithFlags(key K, flags MapLookupFlags) (V, error) {
+	var value V
+	err := tm.m.LookupWithFlags(key, &value, flags)
+	return value, err
+}
+
+// LookupAndDelete retrieves and deletes the value for a key.
+//
+// See Map.LookupAndDelete.
+func (tm *TypedMap[K, V]) LookupAndDelete(key K) (V, error) {
+	var value V
+	err := tm.m.LookupAndDelete(key, &value)
+	return value, err
+}
+
+// Put replaces or creates a value in the map.
+func (tm *TypedMap[K, V]) Put(key K, value V) error {
+	return tm.m.Put(key, value)
+}
+
+// Update changes the value of a key.
+//
+// Pass UpdateLock to write a value containing a bpf_spin_lock consistently.
+func (tm *TypedMap[K, V]) Update(key K, value V, flags MapUpdateFlags) error {
+	return tm.m.Update(key, value, flags)
+}
+
+// Delete removes a value.
+//
+// Returns an error wrapping ErrKeyNotExist if the key doesn't exist.
+func (tm *TypedMap[K, V]) Delete(key K) error {
+	return tm.m.Delete(key)
+}
+
+// Iterate traverses the map.
+//
+// See Map.Iterate.
+func (tm *TypedMap[K, V]) Iterate() *TypedMapIterator[K, V] {
+	return &TypedMapIterator[K, V]{tm.m.Iterate()}
+}
+
+// TypedMapIterator iterates a TypedMap.
+type TypedMapIterator[K, V any] struct {
+	iter *MapIterator
+}
+
+// Next decodes the next key and value.
+//
+// Returns false if there are no more entries. You must check the result of
+// Err afterwards.
+//
+// See MapIterator.Next for details.
+func (it *TypedMapIterator[K, V]) Next(key *K, value *V) bool {
+	return it.iter.Next(key, value)
+}
+
+// Err returns any encountered error.
+func (it *TypedMapIterator[K, V]) Err() error {
+	return it.iter.Err()
+}
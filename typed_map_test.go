@@ -0,0 +1,210 @@
+//go:build go1.21
+// +build go1.21
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestTypedMap(t *testing.T) {
+	type key struct {
+		A uint32
+		B uint16
+		_ uint16
+	}
+
+	type value struct {
+		Count uint64
+	}
+
+	m, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    8,
+		ValueSize:  8,
+		MaxEntries: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[key, value](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tm.Put(key{A: 1, B: 2}, value{42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Update(key{A: 2}, value{23}, UpdateNoExist); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := tm.Lookup(key{A: 1, B: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Count != 42 {
+		t.Error("Unexpected value", v)
+	}
+
+	var (
+		k     key
+		total uint64
+		iter  = tm.Iterate()
+	)
+	for iter.Next(&k, &v) {
+		total += v.Count
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if total != 65 {
+		t.Error("Iterate returned wrong values, total is", total)
+	}
+
+	if err := tm.Delete(key{A: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tm.Lookup(key{A: 2}); !errors.Is(err, ErrKeyNotExist) {
+		t.Error("Lookup of deleted key doesn't return ErrKeyNotExist:", err)
+	}
+	if ok, err := tm.HasKey(key{A: 2}); err != nil || ok {
+		t.Error("HasKey reports deleted key:", ok, err)
+	}
+	if ok, err := tm.HasKey(key{A: 1, B: 2}); err != nil || !ok {
+		t.Error("HasKey doesn't report existing key:", ok, err)
+	}
+
+	if _, err := NewTypedMap[uint32, value](m); err == nil {
+		t.Error("NewTypedMap accepts key with wrong size")
+	}
+	if _, err := NewTypedMap[key, uint32](m); err == nil {
+		t.Error("NewTypedMap accepts value with wrong size")
+	}
+	if _, err := NewTypedMap[key, int](m); err == nil {
+		t.Error("NewTypedMap accepts value without fixed size")
+	}
+}
+
+func TestTypedMapLookupAndDelete(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.14", "lookup and delete for hash maps")
+
+	m, err := NewMap(&MapSpec{
+		Type:       Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[uint32, uint64](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tm.Put(1, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := tm.LookupAndDelete(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 42 {
+		t.Error("Unexpected value", v)
+	}
+
+	if _, err := tm.LookupAndDelete(1); !errors.Is(err, ErrKeyNotExist) {
+		t.Error("LookupAndDelete of deleted key doesn't return ErrKeyNotExist:", err)
+	}
+}
+
+func TestTypedMapPerCPU(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.6", "per-CPU arrays")
+
+	m, err := NewMap(&MapSpec{
+		Type:       PerCPUArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[uint32, []uint32](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpus, err := internal.PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]uint32, cpus)
+	for i := range values {
+		values[i] = uint32(i)
+	}
+
+	if err := tm.Put(0, values); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tm.Lookup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != cpus || got[cpus-1] != uint32(cpus-1) {
+		t.Error("Unexpected per-CPU values", got)
+	}
+}
+
+func TestTypedMapWithLock(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.1", "MAP BPF_F_LOCK")
+
+	file := fmt.Sprintf("testdata/map_spin_lock-%s.elf", internal.ClangEndian)
+	spec, err := LoadCollectionSpec(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMap(spec.Maps["spin_lock_map"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	type spinLockValue struct {
+		Cnt     uint32
+		Padding uint32
+	}
+
+	tm, err := NewTypedMap[uint32, spinLockValue](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tm.Update(1, spinLockValue{Cnt: 5}, UpdateLock); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := tm.LookupWithFlags(1, LookupLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Cnt != 5 {
+		t.Errorf("Want value 5, got %d", value.Cnt)
+	}
+}
@@ -67,10 +67,12 @@ const (
 	// itself.
 	HashOfMaps
 	// DevMap - Specialized map to store references to network devices.
+	// See DevMapValue.
 	DevMap
 	// SockMap - Specialized map to store references to sockets.
 	SockMap
 	// CPUMap - Specialized map to store references to CPUs.
+	// See CPUMapValue.
 	CPUMap
 	// XSKMap - Specialized map for XDP programs to store references to open sockets.
 	XSKMap
@@ -87,22 +89,34 @@ const (
 	// Stack - LIFO storage for BPF programs.
 	Stack
 	// SkStorage - Specialized map for local storage at SK for BPF programs.
+	// Keyed by socket file descriptor, see Map.LookupStorage.
 	SkStorage
 	// DevMapHash - Hash-based indexing scheme for references to network devices.
+	// See DevMapValue.
 	DevMapHash
 	// StructOpsMap - This map holds a kernel struct with its function pointer implemented in a BPF
-	// program.
+	// program. See StructOpsValue.
 	StructOpsMap
 	// RingBuf - Similar to PerfEventArray, but shared across all CPUs.
 	RingBuf
 	// InodeStorage - Specialized local storage map for inodes.
+	// Keyed by file descriptor, see Map.LookupStorage.
 	InodeStorage
 	// TaskStorage - Specialized local storage map for task_struct.
+	// Keyed by pidfd, see Map.LookupStorage.
 	TaskStorage
+	// BloomFilter - Probabilistic set membership test. Values are pushed and
+	// tested using Push and Contains, there are no keys.
+	BloomFilter
 	// maxMapType - Bound enum of MapTypes, has to be last in enum.
 	maxMapType
 )
 
+// isKnown returns true if the map type is declared by the library.
+func (mt MapType) isKnown() bool {
+	return mt < maxMapType
+}
+
 // hasPerCPUValue returns true if the Map stores a value per CPU.
 func (mt MapType) hasPerCPUValue() bool {
 	return mt == PerCPUHash || mt == PerCPUArray || mt == LRUCPUHash || mt == PerCPUCGroupStorage
@@ -120,6 +134,12 @@ func (mt MapType) canStoreProgram() bool {
 	return mt == ProgramArray
 }
 
+// isLocalStorage returns true if the map type stores a value per kernel
+// object, keyed by a file descriptor referring to the object.
+func (mt MapType) isLocalStorage() bool {
+	return mt == SkStorage || mt == InodeStorage || mt == TaskStorage
+}
+
 // hasBTF returns true if the map type supports BTF key/value metadata.
 func (mt MapType) hasBTF() bool {
 	switch mt {
@@ -173,6 +193,7 @@ const (
 	LSM
 	SkLookup
 	Syscall
+	Netfilter
 	maxProgramType
 )
 
@@ -229,6 +250,10 @@ const (
 	AttachSkReuseportSelect
 	AttachSkReuseportSelectOrMigrate
 	AttachPerfEvent
+	AttachTraceKprobeMulti
+	AttachLSMCgroup
+	AttachStructOps
+	AttachNetfilter
 )
 
 // AttachFlags of the eBPF program used in BPF_PROG_ATTACH command
@@ -250,6 +275,9 @@ const (
 type LoadPinOptions struct {
 	// Request a read-only or write-only object. The default is a read-write
 	// object. Only one of the flags may be set.
+	//
+	// Operations on a read-only or write-only Map which aren't permitted
+	// return an error wrapping EPERM.
 	ReadOnly  bool
 	WriteOnly bool
 
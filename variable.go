@@ -0,0 +1,158 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// Variable is a global variable declared in a data section (.bss, .data or
+// .rodata) of a Collection.
+//
+// Variables are located via the BTF of their section, which means that
+// sections without a BTF Datasec don't expose any.
+type Variable struct {
+	name     string
+	offset   uint32
+	size     uint32
+	typ      btf.Type
+	readOnly bool
+
+	m *Map
+}
+
+// newVariables returns the variables in the data section held by m.
+//
+// Entries which aren't a variable or lie outside of the section are skipped.
+func newVariables(ms *MapSpec, m *Map) map[string]*Variable {
+	ds, ok := ms.Value.(*btf.Datasec)
+	if !ok {
+		return nil
+	}
+
+	vars := make(map[string]*Variable, len(ds.Vars))
+	for _, vsi := range ds.Vars {
+		v, ok := vsi.Type.(*btf.Var)
+		if !ok {
+			continue
+		}
+
+		if vsi.Offset+vsi.Size > m.ValueSize() {
+			continue
+		}
+
+		vars[v.Name] = &Variable{
+			v.Name,
+			vsi.Offset,
+			vsi.Size,
+			v.Type,
+			ms.Freeze,
+			m,
+		}
+	}
+
+	return vars
+}
+
+// String returns a human-readable description of the variable.
+func (v *Variable) String() string {
+	return fmt.Sprintf("Variable(%s)", v.name)
+}
+
+// Name returns the name of the variable.
+func (v *Variable) Name() string {
+	return v.name
+}
+
+// Size returns the size of the variable in bytes.
+func (v *Variable) Size() int {
+	return int(v.size)
+}
+
+// Type returns the BTF type of the variable.
+func (v *Variable) Type() btf.Type {
+	return v.typ
+}
+
+// ReadOnly returns true if the variable is in a frozen section, like .rodata.
+func (v *Variable) ReadOnly() bool {
+	return v.readOnly
+}
+
+// Get reads the current value of the variable into out.
+//
+// out follows the same rules as the value passed to Map.Lookup, and must
+// be large enough to hold Size bytes.
+func (v *Variable) Get(out interface{}) error {
+	buf, err := v.read()
+	if err != nil {
+		return fmt.Errorf("variable %s: %w", v.name, err)
+	}
+
+	if err := unmarshalBytes(out, buf[v.offset:v.offset+v.size]); err != nil {
+		return fmt.Errorf("variable %s: %w", v.name, err)
+	}
+	return nil
+}
+
+// Set writes in to the variable.
+//
+// Integers and booleans are converted to the size of the variable if it has
+// an integer or enum type, other values must marshal to exactly Size bytes.
+//
+// If the section was created with BPF_F_MMAPABLE only the bytes of the
+// variable are written. Otherwise the whole section is read and written
+// back, which may revert concurrent modifications of other variables in the
+// same section by BPF programs.
+func (v *Variable) Set(in interface{}) error {
+	if v.readOnly {
+		return fmt.Errorf("variable %s is read-only: %w", v.name, unix.EPERM)
+	}
+
+	b, err := marshalConstant(in, v.typ, int(v.size))
+	if err != nil {
+		return fmt.Errorf("variable %s: %w", v.name, err)
+	}
+
+	if err := v.write(b); err != nil {
+		return fmt.Errorf("variable %s: %w", v.name, err)
+	}
+	return nil
+}
+
+// read returns the contents of the whole section.
+func (v *Variable) read() ([]byte, error) {
+	var buf []byte
+	if err := v.m.Lookup(uint32(0), &buf); err != nil {
+		return nil, err
+	}
+
+	if len(buf) < int(v.offset+v.size) {
+		return nil, errors.New("section is smaller than the variable")
+	}
+	return buf, nil
+}
+
+// write replaces the bytes of the variable in the section with b.
+func (v *Variable) write(b []byte) error {
+	if v.m.Flags()&unix.BPF_F_MMAPABLE != 0 {
+		mm, err := v.m.Memory()
+		if err != nil {
+			return err
+		}
+		defer mm.Close()
+
+		_, err = mm.WriteAt(b, int64(v.offset))
+		return err
+	}
+
+	buf, err := v.read()
+	if err != nil {
+		return err
+	}
+
+	copy(buf[v.offset:v.offset+v.size], b)
+	return v.m.Update(uint32(0), buf, UpdateExist)
+}
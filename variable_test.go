@@ -0,0 +1,165 @@
+package ebpf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// variableTestSpec returns a spec with a .data section holding the variables
+// a and b, and a .rodata section holding the constant c. The program returns
+// the value of b.
+func variableTestSpec(t *testing.T, flags uint32) *CollectionSpec {
+	t.Helper()
+
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	dataSec := func(name string, vars ...string) *MapSpec {
+		ds := &btf.Datasec{Name: name, Size: uint32(len(vars)) * 4}
+		for i, v := range vars {
+			ds.Vars = append(ds.Vars, btf.VarSecinfo{
+				Type:   &btf.Var{Name: v, Type: u32, Linkage: btf.GlobalVar},
+				Offset: uint32(i) * 4,
+				Size:   4,
+			})
+		}
+
+		contents := make([]byte, ds.Size)
+		for i := range vars {
+			internal.NativeEndian.PutUint32(contents[i*4:], uint32(i)+1)
+		}
+
+		return &MapSpec{
+			Name:       name,
+			Type:       Array,
+			KeySize:    4,
+			ValueSize:  ds.Size,
+			MaxEntries: 1,
+			Flags:      flags,
+			Contents:   []MapKV{{uint32(0), contents}},
+			Key:        &btf.Void{},
+			Value:      ds,
+		}
+	}
+
+	data := dataSec(".data", "a", "b")
+	rodata := dataSec(".rodata", "c")
+	rodata.Freeze = true
+
+	types, err := btf.NewSpecFromTypes(data.Value, rodata.Value)
+	qt.Assert(t, err, qt.IsNil)
+	data.BTF, rodata.BTF = types, types
+
+	return &CollectionSpec{
+		Maps: map[string]*MapSpec{
+			".data":   data,
+			".rodata": rodata,
+		},
+		Programs: map[string]*ProgramSpec{
+			"get_b": {
+				Type:    SocketFilter,
+				License: "MIT",
+				Instructions: asm.Instructions{
+					asm.LoadMapValue(asm.R1, 0, 4).WithReference(".data"),
+					asm.LoadMem(asm.R0, asm.R1, 0, asm.Word),
+					asm.Return(),
+				},
+			},
+		},
+		Types: types,
+	}
+}
+
+func TestVariable(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flags uint32
+	}{
+		{"syscall", 0},
+		{"mmapable", unix.BPF_F_MMAPABLE},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.flags != 0 {
+				testutils.SkipOnOldKernel(t, "5.5", "BPF_F_MMAPABLE")
+			}
+			c := qt.New(t)
+
+			coll, err := NewCollection(variableTestSpec(t, tc.flags))
+			testutils.SkipIfNotSupported(t, err)
+			c.Assert(err, qt.IsNil)
+			defer coll.Close()
+
+			c.Assert(coll.Variables, qt.HasLen, 3)
+
+			b := coll.Variables["b"]
+			c.Assert(b.Name(), qt.Equals, "b")
+			c.Assert(b.Size(), qt.Equals, 4)
+			c.Assert(b.ReadOnly(), qt.IsFalse)
+
+			var value uint32
+			c.Assert(b.Get(&value), qt.IsNil)
+			c.Assert(value, qt.Equals, uint32(2))
+
+			c.Assert(b.Set(42), qt.IsNil)
+			c.Assert(b.Get(&value), qt.IsNil)
+			c.Assert(value, qt.Equals, uint32(42))
+
+			ret, _, err := coll.Programs["get_b"].Test(make([]byte, 14))
+			testutils.SkipIfNotSupported(t, err)
+			c.Assert(err, qt.IsNil)
+			c.Assert(ret, qt.Equals, uint32(42))
+
+			// Neighbouring variables are left alone.
+			c.Assert(coll.Variables["a"].Get(&value), qt.IsNil)
+			c.Assert(value, qt.Equals, uint32(1))
+
+			// The size of the variable is enforced.
+			c.Assert(b.Set(uint64(1)<<40), qt.IsNotNil)
+			c.Assert(b.Set([]byte{1, 2}), qt.IsNotNil)
+
+			cv := coll.Variables["c"]
+			c.Assert(cv.ReadOnly(), qt.IsTrue)
+			c.Assert(cv.Get(&value), qt.IsNil)
+			c.Assert(value, qt.Equals, uint32(1))
+			c.Assert(errors.Is(cv.Set(1), unix.EPERM), qt.IsTrue)
+		})
+	}
+}
+
+func TestVariableAmbiguous(t *testing.T) {
+	spec := variableTestSpec(t, 0)
+	rodata := spec.Maps[".rodata"].Value.(*btf.Datasec)
+	rodata.Vars[0].Type.(*btf.Var).Name = "a"
+
+	coll, err := NewCollection(spec)
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	qt.Assert(t, coll.Variables, qt.HasLen, 1)
+	qt.Assert(t, coll.Variables["b"], qt.IsNotNil)
+}
+
+func TestCollectionAssignVariable(t *testing.T) {
+	coll, err := NewCollection(variableTestSpec(t, 0))
+	testutils.SkipIfNotSupported(t, err)
+	qt.Assert(t, err, qt.IsNil)
+	defer coll.Close()
+
+	var objs struct {
+		A *Variable `ebpf:"a"`
+	}
+	qt.Assert(t, coll.Assign(&objs), qt.IsNil)
+	qt.Assert(t, objs.A, qt.Equals, coll.Variables["a"])
+
+	var missing struct {
+		X *Variable `ebpf:"x"`
+	}
+	qt.Assert(t, coll.Assign(&missing), qt.ErrorMatches, `.*missing variable "x"`)
+}
@@ -0,0 +1,364 @@
+// Package watchdog monitors the runtime statistics of BPF programs.
+//
+// The kernel only collects statistics while they are enabled, either via
+// sysctl kernel.bpf_stats_enabled or ebpf.EnableStats. Options.EnableStats
+// takes care of the latter.
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// ErrAlreadyWatched is returned by Watch if a program is already monitored.
+var ErrAlreadyWatched = errors.New("program is already watched")
+
+// Limits on the cost of a program. Zero values are ignored.
+type Limits struct {
+	// MaxAverageRuntime is the maximum average runtime of a single
+	// invocation during an interval.
+	MaxAverageRuntime time.Duration
+
+	// MinRunCount is the number of invocations during an interval
+	// required before MaxAverageRuntime is checked. Averages over few
+	// invocations are noisy.
+	MinRunCount uint64
+
+	// MaxCPUShare is the maximum CPU time spent in the program during an
+	// interval, relative to the length of the interval. A value of 1.0
+	// corresponds to one CPU being fully occupied.
+	MaxCPUShare float64
+}
+
+// Violation describes a program exceeding its Limits.
+type Violation struct {
+	Program ebpf.ProgramID
+	Name    string
+
+	// The interval in which the violation occurred.
+	Interval time.Duration
+	// Number of invocations during the interval.
+	RunCount uint64
+	// Time spent in the program during the interval.
+	Runtime time.Duration
+
+	AverageRuntime time.Duration
+	CPUShare       float64
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("program %s (id %d): %d runs in %s, average %s, CPU share %.3f",
+		v.Name, v.Program, v.RunCount, v.Interval, v.AverageRuntime, v.CPUShare)
+}
+
+// Options for a Watchdog.
+type Options struct {
+	// Interval at which statistics are sampled. Defaults to one second.
+	Interval time.Duration
+
+	// EnableStats enables the collection of statistics for as long as the
+	// Watchdog exists. Requires Linux 5.8.
+	EnableStats bool
+}
+
+// Watchdog periodically checks programs against their Limits.
+type Watchdog struct {
+	mu    sync.Mutex
+	stats io.Closer
+	// watches is nil once the Watchdog is closed.
+	watches map[ebpf.ProgramID]*watch
+	// inCallback is true while callbacks are invoked.
+	inCallback bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type watch struct {
+	prog        *ebpf.Program
+	id          ebpf.ProgramID
+	name        string
+	limits      Limits
+	onViolation func(Violation)
+	last        sample
+}
+
+type sample struct {
+	at       time.Time
+	runCount uint64
+	runtime  time.Duration
+}
+
+// New creates a Watchdog and starts monitoring.
+func New(opts Options) (*Watchdog, error) {
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("invalid interval %s", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+
+	var stats io.Closer
+	if opts.EnableStats {
+		var err error
+		stats, err = ebpf.EnableStats(uint32(unix.BPF_STATS_RUN_TIME))
+		if err != nil {
+			return nil, fmt.Errorf("enable stats: %w", err)
+		}
+	}
+
+	w := &Watchdog{
+		stats:   stats,
+		watches: make(map[ebpf.ProgramID]*watch),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go w.run(opts.Interval)
+	return w, nil
+}
+
+// Close stops monitoring.
+//
+// Close waits for the monitoring goroutine to exit, unless callbacks are
+// being invoked. This allows calling Close from a callback passed to Watch.
+// Callbacks that are already running may still be executing when Close
+// returns, but no further callbacks are invoked. It is safe to call Close
+// multiple times and concurrently.
+func (w *Watchdog) Close() error {
+	w.mu.Lock()
+	if w.watches == nil {
+		w.mu.Unlock()
+		return nil
+	}
+
+	close(w.stop)
+	for _, wt := range w.watches {
+		wt.prog.Close()
+	}
+	w.watches = nil
+	wait := !w.inCallback
+	w.mu.Unlock()
+
+	if wait {
+		<-w.done
+	}
+
+	if w.stats != nil {
+		return w.stats.Close()
+	}
+	return nil
+}
+
+// Watch starts monitoring prog.
+//
+// onViolation is invoked from a separate goroutine every interval in which
+// prog exceeds limits. Use Detach to remove the program from its hook.
+// The Watchdog uses a duplicate of prog, so the caller may close it.
+//
+// Returns ErrAlreadyWatched if prog is already monitored by w.
+func (w *Watchdog) Watch(prog *ebpf.Program, limits Limits, onViolation func(Violation)) error {
+	if onViolation == nil {
+		return errors.New("onViolation must not be nil")
+	}
+	if limits.MaxAverageRuntime < 0 || limits.MaxCPUShare < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+
+	dup, err := prog.Clone()
+	if err != nil {
+		return err
+	}
+
+	info, err := dup.Info()
+	if err != nil {
+		dup.Close()
+		return err
+	}
+	id, ok := info.ID()
+	if !ok {
+		dup.Close()
+		return fmt.Errorf("program ID: %w", ebpf.ErrNotSupported)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.watches == nil {
+		dup.Close()
+		return fmt.Errorf("watchdog is closed")
+	}
+
+	if _, ok := w.watches[id]; ok {
+		dup.Close()
+		return fmt.Errorf("program %s (id %d): %w", info.Name, id, ErrAlreadyWatched)
+	}
+
+	w.watches[id] = &watch{dup, id, info.Name, limits, onViolation, newSample(info, time.Now())}
+	return nil
+}
+
+// Unwatch stops monitoring prog.
+//
+// It's safe to call Unwatch from a callback passed to Watch.
+func (w *Watchdog) Unwatch(prog *ebpf.Program) error {
+	info, err := prog.Info()
+	if err != nil {
+		return err
+	}
+
+	id, ok := info.ID()
+	if !ok {
+		return fmt.Errorf("program ID: %w", ebpf.ErrNotSupported)
+	}
+
+	if !w.unwatch(id) {
+		return fmt.Errorf("program %s (id %d) isn't watched", info.Name, id)
+	}
+	return nil
+}
+
+func (w *Watchdog) unwatch(id ebpf.ProgramID) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wt, ok := w.watches[id]
+	if !ok {
+		return false
+	}
+
+	wt.prog.Close()
+	delete(w.watches, id)
+	return true
+}
+
+// Detach returns a callback which closes l on the first violation, stops
+// monitoring the offending program and then invokes next, if it's not nil.
+//
+// Errors from closing l are ignored.
+func (w *Watchdog) Detach(l io.Closer, next func(Violation)) func(Violation) {
+	var once sync.Once
+	return func(v Violation) {
+		once.Do(func() {
+			_ = l.Close()
+			w.unwatch(v.Program)
+			if next != nil {
+				next(v)
+			}
+		})
+	}
+}
+
+func (w *Watchdog) run(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+// check samples all programs and invokes callbacks for violations.
+func (w *Watchdog) check(now time.Time) {
+	type pending struct {
+		fn func(Violation)
+		v  Violation
+	}
+	var violations []pending
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, wt := range w.watches {
+		cur, err := takeSample(wt.prog, now)
+		if err != nil {
+			continue
+		}
+
+		if v, ok := wt.evaluate(cur); ok {
+			violations = append(violations, pending{wt.onViolation, v})
+		}
+		wt.last = cur
+	}
+
+	if len(violations) == 0 {
+		return
+	}
+
+	// Invoke callbacks without holding the lock so that they may call
+	// methods on the Watchdog.
+	w.inCallback = true
+	for _, p := range violations {
+		if w.watches == nil {
+			// Closed by a callback.
+			break
+		}
+
+		w.mu.Unlock()
+		p.fn(p.v)
+		w.mu.Lock()
+	}
+	w.inCallback = false
+}
+
+func takeSample(prog *ebpf.Program, now time.Time) (sample, error) {
+	info, err := prog.Info()
+	if err != nil {
+		return sample{}, err
+	}
+
+	return newSample(info, now), nil
+}
+
+func newSample(info *ebpf.ProgramInfo, now time.Time) sample {
+	runCount, _ := info.RunCount()
+	runtime, _ := info.Runtime()
+	return sample{now, runCount, runtime}
+}
+
+// evaluate compares the difference between the previous sample and cur
+// against the limits.
+func (wt *watch) evaluate(cur sample) (Violation, bool) {
+	interval := cur.at.Sub(wt.last.at)
+	if interval <= 0 || cur.runCount < wt.last.runCount {
+		return Violation{}, false
+	}
+
+	v := Violation{
+		Program:  wt.id,
+		Name:     wt.name,
+		Interval: interval,
+		RunCount: cur.runCount - wt.last.runCount,
+		Runtime:  cur.runtime - wt.last.runtime,
+	}
+	if v.RunCount > 0 {
+		v.AverageRuntime = v.Runtime / time.Duration(v.RunCount)
+	}
+	v.CPUShare = float64(v.Runtime) / float64(interval)
+
+	exceeded := false
+	if max := wt.limits.MaxAverageRuntime; max > 0 && v.RunCount > 0 && v.RunCount >= wt.limits.MinRunCount {
+		exceeded = exceeded || v.AverageRuntime > max
+	}
+	if max := wt.limits.MaxCPUShare; max > 0 {
+		exceeded = exceeded || v.CPUShare > max
+	}
+	if !exceeded {
+		return Violation{}, false
+	}
+
+	return v, true
+}
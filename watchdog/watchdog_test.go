@@ -0,0 +1,233 @@
+package watchdog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/internal/testutils"
+)
+
+func TestEvaluate(t *testing.T) {
+	start := time.Unix(0, 0)
+
+	for _, test := range []struct {
+		name     string
+		limits   Limits
+		runCount uint64
+		runtime  time.Duration
+		violated bool
+	}{
+		{"no limits", Limits{}, 10, time.Second, false},
+		{"average below", Limits{MaxAverageRuntime: time.Millisecond}, 1000, 500 * time.Millisecond, false},
+		{"average above", Limits{MaxAverageRuntime: time.Millisecond}, 100, 500 * time.Millisecond, true},
+		{"average min count", Limits{MaxAverageRuntime: time.Millisecond, MinRunCount: 1000}, 100, 500 * time.Millisecond, false},
+		{"no runs", Limits{MaxAverageRuntime: time.Nanosecond}, 0, 0, false},
+		{"share below", Limits{MaxCPUShare: 0.5}, 1, 100 * time.Millisecond, false},
+		{"share above", Limits{MaxCPUShare: 0.5}, 1, 600 * time.Millisecond, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wt := &watch{
+				id:     42,
+				name:   "foo",
+				limits: test.limits,
+				last:   sample{start, 5, time.Second},
+			}
+
+			cur := sample{start.Add(time.Second), 5 + test.runCount, time.Second + test.runtime}
+			v, violated := wt.evaluate(cur)
+			if violated != test.violated {
+				t.Fatalf("Expected violation to be %v, got %v (%v)", test.violated, violated, v)
+			}
+
+			if !violated {
+				return
+			}
+
+			if v.Program != 42 || v.Name != "foo" {
+				t.Error("Violation doesn't identify program:", v)
+			}
+			if v.RunCount != test.runCount || v.Runtime != test.runtime || v.Interval != time.Second {
+				t.Error("Unexpected deltas:", v)
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	w := &Watchdog{watches: make(map[ebpf.ProgramID]*watch)}
+
+	var closed, notified int
+	fn := w.Detach(closerFunc(func() error {
+		closed++
+		return nil
+	}), func(Violation) {
+		notified++
+	})
+
+	fn(Violation{})
+	fn(Violation{})
+
+	if closed != 1 || notified != 1 {
+		t.Fatalf("Expected a single close and notification, got %d and %d", closed, notified)
+	}
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+func TestWatchdog(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.8", "BPF_ENABLE_STATS")
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	// Use a long interval so that only explicit calls to check run.
+	w, err := New(Options{Interval: time.Hour, EnableStats: true})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var violations []Violation
+	err = w.Watch(prog, Limits{MaxCPUShare: 1e-12}, func(v Violation) {
+		violations = append(violations, v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.check(time.Now())
+	if len(violations) != 0 {
+		t.Fatal("Idle program caused a violation:", violations[0])
+	}
+
+	if _, _, err := prog.Benchmark(make([]byte, 14), 1000, nil); err != nil {
+		testutils.SkipIfNotSupported(t, err)
+		t.Fatal(err)
+	}
+
+	w.check(time.Now())
+	if len(violations) != 1 {
+		t.Fatal("Expected a violation, got", len(violations))
+	}
+
+	if v := violations[0]; v.RunCount < 1000 || v.Runtime == 0 {
+		t.Error("Unexpected statistics:", v)
+	}
+
+	err = w.Watch(prog, Limits{}, func(Violation) {})
+	if !errors.Is(err, ErrAlreadyWatched) {
+		t.Fatal("Expected ErrAlreadyWatched, got", err)
+	}
+
+	if err := w.Unwatch(prog); err != nil {
+		t.Fatal("Can't unwatch:", err)
+	}
+	if err := w.Unwatch(prog); err == nil {
+		t.Fatal("Unwatch on a program that isn't watched should fail")
+	}
+
+	// Detach stops monitoring the program after closing the link.
+	var detached int
+	err = w.Watch(prog, Limits{MaxCPUShare: 1e-12}, w.Detach(closerFunc(func() error {
+		detached++
+		return nil
+	}), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.check(time.Now())
+	if _, _, err := prog.Benchmark(make([]byte, 14), 1000, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.check(time.Now())
+
+	if detached != 1 {
+		t.Fatal("Expected the link to be closed once, got", detached)
+	}
+	if len(w.watches) != 0 {
+		t.Fatal("Program is still watched after Detach")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Watch(prog, Limits{}, func(Violation) {}); err == nil {
+		t.Fatal("Watch on a closed watchdog should fail")
+	}
+}
+
+func TestWatchdogClose(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "5.8", "BPF_ENABLE_STATS")
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	w, err := New(Options{Interval: time.Hour, EnableStats: true})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var calls int
+	err = w.Watch(prog, Limits{MaxCPUShare: 1e-12}, func(Violation) {
+		calls++
+		if err := w.Close(); err != nil {
+			t.Error("Close from callback:", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.check(time.Now())
+	if _, _, err := prog.Benchmark(make([]byte, 14), 1000, nil); err != nil {
+		testutils.SkipIfNotSupported(t, err)
+		t.Fatal(err)
+	}
+
+	// Close from the callback must not deadlock.
+	w.check(time.Now())
+	if calls != 1 {
+		t.Fatal("Expected a single callback, got", calls)
+	}
+
+	// Concurrent calls to Close must not panic.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- w.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
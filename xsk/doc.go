@@ -0,0 +1,11 @@
+// Package xsk allows sending and receiving packets via AF_XDP sockets.
+//
+// An AF_XDP socket owns a region of memory called UMEM, which is split into
+// equally sized frames. Frames are exchanged with the kernel via four rings:
+// the fill and completion rings hand frames to and back from the kernel, while
+// the rx and tx rings carry descriptors of received and transmitted packets.
+//
+// Packets are only delivered to a socket if an XDP program redirects them into
+// an XSKMap using bpf_redirect_map. Use Socket.Register to add a socket to
+// such a map.
+package xsk
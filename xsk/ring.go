@@ -0,0 +1,95 @@
+package xsk
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// ring is a single producer, single consumer queue shared with the kernel.
+type ring struct {
+	mem []byte
+	// These point into mmap'ed memory and must be accessed atomically.
+	producer, consumer *uint32
+	flags              *uint32
+	entries            unsafe.Pointer
+	mask               uint32
+	size               uint32
+}
+
+func newRing(fd int, pgoff int64, off *unix.XDPRingOffset, size, entrySize uint32) (*ring, error) {
+	length := int(off.Desc) + int(size*entrySize)
+	mem, err := unix.Mmap(fd, pgoff, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, fmt.Errorf("can't mmap ring: %w", err)
+	}
+
+	return &ring{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[off.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.Consumer])),
+		flags:    (*uint32)(unsafe.Pointer(&mem[off.Flags])),
+		entries:  unsafe.Pointer(&mem[off.Desc]),
+		mask:     size - 1,
+		size:     size,
+	}, nil
+}
+
+func (r *ring) close() {
+	if r == nil || r.mem == nil {
+		return
+	}
+
+	_ = unix.Munmap(r.mem)
+	r.mem = nil
+}
+
+// needWakeup returns true if the kernel asks to be woken up to process
+// the ring.
+func (r *ring) needWakeup() bool {
+	return atomic.LoadUint32(r.flags)&unix.XDP_RING_NEED_WAKEUP != 0
+}
+
+// reserve returns the position of the first free slot and the number of
+// slots, up to n, that may be produced.
+func (r *ring) reserve(n int) (uint32, int) {
+	prod := atomic.LoadUint32(r.producer)
+	free := r.size - (prod - atomic.LoadUint32(r.consumer))
+	if uint32(n) > free {
+		n = int(free)
+	}
+	return prod, n
+}
+
+// submit makes n slots starting at prod visible to the kernel.
+func (r *ring) submit(prod uint32, n int) {
+	atomic.StoreUint32(r.producer, prod+uint32(n))
+}
+
+// peek returns the position of the first filled slot and the number of
+// slots, up to n, that may be consumed.
+func (r *ring) peek(n int) (uint32, int) {
+	cons := atomic.LoadUint32(r.consumer)
+	avail := atomic.LoadUint32(r.producer) - cons
+	if uint32(n) > avail {
+		n = int(avail)
+	}
+	return cons, n
+}
+
+// release hands n slots starting at cons back to the kernel.
+func (r *ring) release(cons uint32, n int) {
+	atomic.StoreUint32(r.consumer, cons+uint32(n))
+}
+
+// addrs returns the entries of a fill or completion ring.
+func (r *ring) addrs() []uint64 {
+	return unsafe.Slice((*uint64)(r.entries), r.size)
+}
+
+// descs returns the entries of an rx or tx ring.
+func (r *ring) descs() []Desc {
+	return unsafe.Slice((*Desc)(r.entries), r.size)
+}
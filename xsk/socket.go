@@ -0,0 +1,542 @@
+package xsk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+// ErrClosed is returned when interacting with a closed Socket.
+var ErrClosed = os.ErrClosed
+
+// Desc describes a packet stored in the UMEM.
+//
+// It mirrors struct xdp_desc.
+type Desc struct {
+	// Offset of the packet from the start of the UMEM.
+	Addr uint64
+	// Length of the packet in bytes.
+	Len     uint32
+	Options uint32
+}
+
+// Mode controls whether packets are copied between the driver and the UMEM.
+type Mode int
+
+const (
+	// ModeAuto uses zero-copy mode if the driver supports it, and falls back
+	// to copy mode otherwise.
+	ModeAuto Mode = iota
+	// ModeCopy always copies packets.
+	ModeCopy
+	// ModeZeroCopy requires driver support for zero-copy mode.
+	ModeZeroCopy
+)
+
+// SocketOptions control the creation of a Socket.
+type SocketOptions struct {
+	// The number of frames in the UMEM. Defaults to 4096.
+	NumFrames int
+	// The size of a single frame in bytes. Must be a power of two between
+	// 2048 and the page size. Defaults to the page size.
+	FrameSize int
+	// The number of bytes reserved at the start of each frame. The kernel
+	// places received packets after the headroom. Defaults to 0.
+	Headroom int
+
+	// The number of entries in each ring. Must be a power of two. Defaults to
+	// 2048 for the fill and completion rings and to 1024 for the rx and tx
+	// rings. Set RxRingSize or TxRingSize to a negative value to omit the
+	// ring, at least one of them must be present.
+	FillRingSize       int
+	CompletionRingSize int
+	RxRingSize         int
+	TxRingSize         int
+
+	Mode Mode
+	// Only wake up the kernel to process the fill and tx rings when it
+	// asks for it. Reduces the number of syscalls, requires Linux 5.4.
+	NeedWakeup bool
+}
+
+func (so *SocketOptions) defaults() SocketOptions {
+	opts := *so
+	if opts.NumFrames == 0 {
+		opts.NumFrames = 4096
+	}
+	if opts.FrameSize == 0 {
+		opts.FrameSize = os.Getpagesize()
+	}
+	if opts.FillRingSize == 0 {
+		opts.FillRingSize = 2048
+	}
+	if opts.CompletionRingSize == 0 {
+		opts.CompletionRingSize = 2048
+	}
+	if opts.RxRingSize == 0 {
+		opts.RxRingSize = 1024
+	}
+	if opts.TxRingSize == 0 {
+		opts.TxRingSize = 1024
+	}
+	return opts
+}
+
+// Socket is an AF_XDP socket bound to a single queue of a network interface.
+//
+// A Socket is not safe for concurrent use.
+type Socket struct {
+	fd         int
+	queueID    uint32
+	umem       []byte
+	frameSize  int
+	numFrames  int
+	needWakeup bool
+
+	fill, completion *ring
+	rx, tx           *ring
+}
+
+// NewSocket creates an AF_XDP socket with its own UMEM and binds it to the
+// given queue of a network interface.
+//
+// opts may be nil, in which case defaults are used.
+func NewSocket(ifindex, queueID int, opts *SocketOptions) (*Socket, error) {
+	if opts == nil {
+		opts = &SocketOptions{}
+	}
+	o := opts.defaults()
+
+	if o.FrameSize < 2048 || o.FrameSize > os.Getpagesize() || !isPowerOfTwo(o.FrameSize) {
+		return nil, fmt.Errorf("invalid frame size %d", o.FrameSize)
+	}
+	if o.NumFrames <= 0 {
+		return nil, fmt.Errorf("invalid number of frames %d", o.NumFrames)
+	}
+	if o.Headroom < 0 || o.Headroom >= o.FrameSize {
+		return nil, fmt.Errorf("invalid headroom %d", o.Headroom)
+	}
+	if o.FillRingSize < 0 || o.CompletionRingSize < 0 {
+		return nil, errors.New("fill and completion rings can't be omitted")
+	}
+	if o.RxRingSize < 0 && o.TxRingSize < 0 {
+		return nil, errors.New("socket needs an rx or tx ring")
+	}
+	for _, size := range []int{o.FillRingSize, o.CompletionRingSize, o.RxRingSize, o.TxRingSize} {
+		if size > 0 && !isPowerOfTwo(size) {
+			return nil, fmt.Errorf("ring size %d is not a power of two", size)
+		}
+	}
+
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if errors.Is(err, unix.EAFNOSUPPORT) {
+		return nil, fmt.Errorf("create AF_XDP socket: %w", internal.ErrNotSupported)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create AF_XDP socket: %w", err)
+	}
+
+	s := &Socket{
+		fd:         fd,
+		queueID:    uint32(queueID),
+		frameSize:  o.FrameSize,
+		numFrames:  o.NumFrames,
+		needWakeup: o.NeedWakeup,
+	}
+
+	if err := s.setup(ifindex, &o); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Socket) setup(ifindex int, o *SocketOptions) error {
+	umem, err := unix.Mmap(-1, 0, o.NumFrames*o.FrameSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return fmt.Errorf("allocate UMEM: %w", err)
+	}
+	s.umem = umem
+
+	reg := unix.XDPUmemReg{
+		Addr:     uint64(uintptr(unsafe.Pointer(&umem[0]))),
+		Len:      uint64(len(umem)),
+		Size:     uint32(o.FrameSize),
+		Headroom: uint32(o.Headroom),
+	}
+	if err := unix.Setsockopt(s.fd, unix.SOL_XDP, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), uint32(unsafe.Sizeof(reg))); err != nil {
+		return fmt.Errorf("register UMEM: %w", err)
+	}
+
+	sizes := []struct {
+		opt  int
+		size int
+	}{
+		{unix.XDP_UMEM_FILL_RING, o.FillRingSize},
+		{unix.XDP_UMEM_COMPLETION_RING, o.CompletionRingSize},
+		{unix.XDP_RX_RING, o.RxRingSize},
+		{unix.XDP_TX_RING, o.TxRingSize},
+	}
+	for _, ring := range sizes {
+		if ring.size < 0 {
+			continue
+		}
+
+		size := uint32(ring.size)
+		if err := unix.Setsockopt(s.fd, unix.SOL_XDP, ring.opt, unsafe.Pointer(&size), uint32(unsafe.Sizeof(size))); err != nil {
+			return fmt.Errorf("set ring size: %w", err)
+		}
+	}
+
+	off, err := mmapOffsets(s.fd)
+	if err != nil {
+		return err
+	}
+
+	s.fill, err = newRing(s.fd, unix.XDP_UMEM_PGOFF_FILL_RING, &off.Fr, uint32(o.FillRingSize), 8)
+	if err != nil {
+		return fmt.Errorf("fill ring: %w", err)
+	}
+
+	s.completion, err = newRing(s.fd, unix.XDP_UMEM_PGOFF_COMPLETION_RING, &off.Cr, uint32(o.CompletionRingSize), 8)
+	if err != nil {
+		return fmt.Errorf("completion ring: %w", err)
+	}
+
+	descSize := uint32(unsafe.Sizeof(Desc{}))
+	if o.RxRingSize > 0 {
+		s.rx, err = newRing(s.fd, unix.XDP_PGOFF_RX_RING, &off.Rx, uint32(o.RxRingSize), descSize)
+		if err != nil {
+			return fmt.Errorf("rx ring: %w", err)
+		}
+	}
+
+	if o.TxRingSize > 0 {
+		s.tx, err = newRing(s.fd, unix.XDP_PGOFF_TX_RING, &off.Tx, uint32(o.TxRingSize), descSize)
+		if err != nil {
+			return fmt.Errorf("tx ring: %w", err)
+		}
+	}
+
+	var flags uint16
+	switch o.Mode {
+	case ModeAuto:
+	case ModeCopy:
+		flags |= unix.XDP_COPY
+	case ModeZeroCopy:
+		flags |= unix.XDP_ZEROCOPY
+	default:
+		return fmt.Errorf("invalid mode %d", o.Mode)
+	}
+	if o.NeedWakeup {
+		flags |= unix.XDP_USE_NEED_WAKEUP
+	}
+
+	sa := &unix.SockaddrXDP{
+		Flags:   flags,
+		Ifindex: uint32(ifindex),
+		QueueID: s.queueID,
+	}
+	if err := unix.Bind(s.fd, sa); err != nil {
+		return fmt.Errorf("bind to interface %d queue %d: %w", ifindex, s.queueID, err)
+	}
+
+	return nil
+}
+
+// mmapOffsets retrieves the layout of the rings.
+func mmapOffsets(fd int) (*unix.XDPMmapOffsets, error) {
+	var off unix.XDPMmapOffsets
+	size := uint32(unsafe.Sizeof(off))
+	if err := unix.Getsockopt(fd, unix.SOL_XDP, unix.XDP_MMAP_OFFSETS, unsafe.Pointer(&off), &size); err != nil {
+		return nil, fmt.Errorf("get mmap offsets: %w", err)
+	}
+
+	if size == uint32(unsafe.Sizeof(off)) {
+		return &off, nil
+	}
+
+	// Kernels before 5.4 don't return the offset of the flags and use a
+	// smaller struct xdp_ring_offset.
+	v1 := (*[12]uint64)(unsafe.Pointer(&off))
+	rings := make([]unix.XDPRingOffset, 4)
+	for i := range rings {
+		rings[i] = unix.XDPRingOffset{
+			Producer: v1[i*3],
+			Consumer: v1[i*3+1],
+			Desc:     v1[i*3+2],
+			Flags:    v1[i*3+1] + 4,
+		}
+	}
+
+	return &unix.XDPMmapOffsets{Rx: rings[0], Tx: rings[1], Fr: rings[2], Cr: rings[3]}, nil
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Close the socket and release the UMEM.
+//
+// Packets referring to the UMEM become invalid.
+func (s *Socket) Close() error {
+	if s.fd < 0 {
+		return nil
+	}
+
+	for _, r := range []*ring{s.fill, s.completion, s.rx, s.tx} {
+		r.close()
+	}
+
+	err := unix.Close(s.fd)
+	s.fd = -1
+
+	if s.umem != nil {
+		_ = unix.Munmap(s.umem)
+		s.umem = nil
+	}
+
+	return err
+}
+
+// FD returns the file descriptor of the socket.
+func (s *Socket) FD() int {
+	return s.fd
+}
+
+// Register adds the socket to an XSKMap, using its queue ID as the key.
+//
+// An XDP program can then redirect packets received on that queue to the
+// socket.
+func (s *Socket) Register(xsks *ebpf.Map) error {
+	if s.fd < 0 {
+		return ErrClosed
+	}
+
+	if xsks.Type() != ebpf.XSKMap {
+		return fmt.Errorf("can't register socket in map of type %s", xsks.Type())
+	}
+
+	if err := xsks.Put(s.queueID, uint32(s.fd)); err != nil {
+		return fmt.Errorf("register socket: %w", err)
+	}
+
+	return nil
+}
+
+// NumFrames returns the number of frames in the UMEM.
+func (s *Socket) NumFrames() int {
+	return s.numFrames
+}
+
+// FrameAddr returns the address of the i-th frame in the UMEM.
+func (s *Socket) FrameAddr(i int) uint64 {
+	return uint64(i * s.frameSize)
+}
+
+// Frame returns the frame at addr, which may point anywhere into the frame.
+//
+// The returned slice is only valid until the socket is closed.
+func (s *Socket) Frame(addr uint64) ([]byte, error) {
+	if s.umem == nil {
+		return nil, ErrClosed
+	}
+	if addr >= uint64(len(s.umem)) {
+		return nil, fmt.Errorf("address %d is outside of the UMEM", addr)
+	}
+
+	start := addr - addr%uint64(s.frameSize)
+	return s.umem[start : start+uint64(s.frameSize)], nil
+}
+
+// Packet returns the contents of a packet.
+//
+// The returned slice is only valid until the socket is closed.
+func (s *Socket) Packet(desc Desc) ([]byte, error) {
+	if s.umem == nil {
+		return nil, ErrClosed
+	}
+
+	end := desc.Addr + uint64(desc.Len)
+	if end < desc.Addr || end > uint64(len(s.umem)) {
+		return nil, fmt.Errorf("packet at %d with length %d is outside of the UMEM", desc.Addr, desc.Len)
+	}
+
+	return s.umem[desc.Addr:end], nil
+}
+
+// Fill hands frames to the kernel, which uses them to store received packets.
+//
+// Returns the number of frames added to the fill ring, which may be less than
+// len(addrs) if the ring is full.
+func (s *Socket) Fill(addrs []uint64) (int, error) {
+	if s.fd < 0 {
+		return 0, ErrClosed
+	}
+
+	prod, n := s.fill.reserve(len(addrs))
+	ring := s.fill.addrs()
+	for i := 0; i < n; i++ {
+		ring[(prod+uint32(i))&s.fill.mask] = addrs[i]
+	}
+	s.fill.submit(prod, n)
+	return n, nil
+}
+
+// Complete retrieves frames which the kernel has finished transmitting.
+//
+// Returns the number of addresses written to addrs.
+func (s *Socket) Complete(addrs []uint64) (int, error) {
+	if s.fd < 0 {
+		return 0, ErrClosed
+	}
+
+	cons, n := s.completion.peek(len(addrs))
+	ring := s.completion.addrs()
+	for i := 0; i < n; i++ {
+		addrs[i] = ring[(cons+uint32(i))&s.completion.mask]
+	}
+	s.completion.release(cons, n)
+	return n, nil
+}
+
+// Receive retrieves descriptors of received packets.
+//
+// Returns the number of descriptors written to descs. The frames of the
+// packets are owned by the caller and should eventually be passed to Fill or
+// Transmit.
+func (s *Socket) Receive(descs []Desc) (int, error) {
+	if s.fd < 0 {
+		return 0, ErrClosed
+	}
+
+	if s.rx == nil {
+		return 0, nil
+	}
+
+	cons, n := s.rx.peek(len(descs))
+	ring := s.rx.descs()
+	for i := 0; i < n; i++ {
+		descs[i] = ring[(cons+uint32(i))&s.rx.mask]
+	}
+	s.rx.release(cons, n)
+	return n, nil
+}
+
+// Transmit queues packets for transmission and wakes up the kernel if
+// necessary.
+//
+// Returns the number of packets queued, which may be less than len(descs) if
+// the tx ring is full. Use Complete to find out when frames may be reused.
+func (s *Socket) Transmit(descs []Desc) (int, error) {
+	if s.fd < 0 {
+		return 0, ErrClosed
+	}
+
+	if s.tx == nil {
+		return 0, errors.New("socket has no tx ring")
+	}
+
+	prod, n := s.tx.reserve(len(descs))
+	ring := s.tx.descs()
+	for i := 0; i < n; i++ {
+		ring[(prod+uint32(i))&s.tx.mask] = descs[i]
+	}
+	s.tx.submit(prod, n)
+
+	if n == 0 || (s.needWakeup && !s.tx.needWakeup()) {
+		return n, nil
+	}
+
+	return n, s.kick()
+}
+
+// kick asks the kernel to process the tx ring.
+func (s *Socket) kick() error {
+	err := unix.Sendto(s.fd, nil, unix.MSG_DONTWAIT)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EBUSY), errors.Is(err, unix.ENOBUFS):
+		// The kernel is busy, packets will be sent later.
+		return nil
+	default:
+		return fmt.Errorf("wake up tx: %w", err)
+	}
+}
+
+// Wait blocks until packets are available in the rx ring or the timeout
+// expires. A negative timeout waits indefinitely.
+//
+// Waiting also wakes up the kernel to process the fill ring. Returns
+// os.ErrDeadlineExceeded if the timeout expired.
+func (s *Socket) Wait(timeout time.Duration) error {
+	if s.fd < 0 {
+		return ErrClosed
+	}
+
+	msec := -1
+	if timeout >= 0 {
+		// Round up, so that short timeouts don't turn into a non-blocking poll.
+		msec = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, msec)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("poll: %w", err)
+		}
+		if n == 0 {
+			return os.ErrDeadlineExceeded
+		}
+		return nil
+	}
+}
+
+// Statistics are counters maintained by the kernel for a socket.
+type Statistics struct {
+	// Packets dropped for reasons other than invalid descriptors.
+	RxDropped uint64
+	// Packets dropped due to invalid descriptors.
+	RxInvalidDescs uint64
+	TxInvalidDescs uint64
+	// Packets dropped because the rx ring was full. Requires Linux 5.9.
+	RxRingFull uint64
+	// Failed attempts to take a frame from the fill ring. Requires Linux 5.9.
+	RxFillRingEmptyDescs uint64
+	// Failed attempts to take a descriptor from the tx ring. Requires Linux 5.9.
+	TxRingEmptyDescs uint64
+}
+
+// Stats returns the statistics of the socket.
+func (s *Socket) Stats() (Statistics, error) {
+	if s.fd < 0 {
+		return Statistics{}, ErrClosed
+	}
+
+	var stats unix.XDPStatistics
+	size := uint32(unsafe.Sizeof(stats))
+	if err := unix.Getsockopt(s.fd, unix.SOL_XDP, unix.XDP_STATISTICS, unsafe.Pointer(&stats), &size); err != nil {
+		return Statistics{}, fmt.Errorf("get statistics: %w", err)
+	}
+
+	return Statistics{
+		RxDropped:            stats.Rx_dropped,
+		RxInvalidDescs:       stats.Rx_invalid_descs,
+		TxInvalidDescs:       stats.Tx_invalid_descs,
+		RxRingFull:           stats.Rx_ring_full,
+		RxFillRingEmptyDescs: stats.Rx_fill_ring_empty_descs,
+		TxRingEmptyDescs:     stats.Tx_ring_empty_descs,
+	}, nil
+}
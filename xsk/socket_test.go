@@ -0,0 +1,166 @@
+package xsk
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/internal/testutils"
+	"github.com/cilium/ebpf/internal/unix"
+)
+
+func mustSocket(tb testing.TB, opts *SocketOptions) *Socket {
+	tb.Helper()
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	// The kernel releases a queue asynchronously after a socket is closed.
+	var s *Socket
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s, err = NewSocket(lo.Index, 0, opts)
+		if !errors.Is(err, unix.EBUSY) || time.Now().After(deadline) {
+			break
+		}
+	}
+	testutils.SkipIfNotSupported(tb, err)
+	if errors.Is(err, unix.EPERM) {
+		tb.Skip("Insufficient privileges to create AF_XDP socket")
+	}
+	if err != nil {
+		tb.Fatal("Can't create socket:", err)
+	}
+	tb.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestSocket(t *testing.T) {
+	testutils.SkipOnOldKernel(t, "4.18", "AF_XDP")
+
+	s := mustSocket(t, &SocketOptions{
+		NumFrames: 64,
+		Mode:      ModeCopy,
+	})
+
+	addrs := make([]uint64, s.NumFrames()/2)
+	for i := range addrs {
+		addrs[i] = s.FrameAddr(i)
+	}
+	n, err := s.Fill(addrs)
+	if err != nil {
+		t.Fatal("Can't fill:", err)
+	}
+	if n != len(addrs) {
+		t.Fatalf("Filled %d instead of %d frames", n, len(addrs))
+	}
+
+	if err := s.Wait(0); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("Expected deadline exceeded, got", err)
+	}
+
+	if _, err := s.Stats(); err != nil {
+		t.Fatal("Can't get statistics:", err)
+	}
+
+	xsks, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.XSKMap,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	testutils.SkipIfNotSupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xsks.Close()
+
+	if err := s.Register(xsks); err != nil {
+		t.Fatal("Can't register socket:", err)
+	}
+
+	// Frames 0 to len(addrs) belong to the kernel.
+	addr := s.FrameAddr(len(addrs))
+	frame, err := s.Frame(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range frame[:64] {
+		frame[i] = 0xff
+	}
+
+	n, err = s.Transmit([]Desc{{Addr: addr, Len: 64}})
+	if err != nil {
+		t.Fatal("Can't transmit:", err)
+	}
+	if n != 1 {
+		t.Fatal("Expected to queue one packet, got", n)
+	}
+
+	if _, err := s.Frame(uint64(s.NumFrames() * s.frameSize)); err == nil {
+		t.Error("Frame accepts an address outside of the UMEM")
+	}
+	if _, err := s.Packet(Desc{Addr: addr, Len: 1 << 30}); err == nil {
+		t.Error("Packet accepts a length outside of the UMEM")
+	}
+	if _, err := s.Packet(Desc{Addr: ^uint64(0), Len: 2}); err == nil {
+		t.Error("Packet accepts an overflowing address")
+	}
+
+	completed := make([]uint64, 1)
+	deadline := time.Now().Add(time.Second)
+	for {
+		n, err := s.Complete(completed)
+		if err != nil {
+			t.Fatal("Can't complete:", err)
+		}
+		if n != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Packet wasn't completed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if completed[0] != addr {
+		t.Errorf("Expected completion of %d, got %d", addr, completed[0])
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Fill(addrs); !errors.Is(err, ErrClosed) {
+		t.Error("Fill after Close doesn't return ErrClosed:", err)
+	}
+	if _, err := s.Complete(completed); !errors.Is(err, ErrClosed) {
+		t.Error("Complete after Close doesn't return ErrClosed:", err)
+	}
+	if _, err := s.Receive(make([]Desc, 1)); !errors.Is(err, ErrClosed) {
+		t.Error("Receive after Close doesn't return ErrClosed:", err)
+	}
+	if _, err := s.Transmit([]Desc{{Addr: addr, Len: 64}}); !errors.Is(err, ErrClosed) {
+		t.Error("Transmit after Close doesn't return ErrClosed:", err)
+	}
+}
+
+func TestSocketOptions(t *testing.T) {
+	for _, opts := range []SocketOptions{
+		{FrameSize: 1000},
+		{NumFrames: -1},
+		{Headroom: 1 << 20},
+		{RxRingSize: -1, TxRingSize: -1},
+		{FillRingSize: 3},
+		{FillRingSize: -1},
+		{CompletionRingSize: -1},
+	} {
+		if _, err := NewSocket(1, 0, &opts); err == nil {
+			t.Errorf("Options %+v don't return an error", opts)
+		}
+	}
+}